@@ -0,0 +1,66 @@
+package extract
+
+import (
+	"fmt"
+	"log"
+	"os"
+)
+
+// Abort policies for output that a failed job leaves behind: partial local
+// files and staged objects whose load failed.
+const (
+	abortDelete = "delete"
+	abortMark   = "mark"
+	abortKeep   = "keep"
+)
+
+// incompleteSuffix is appended to partial files under abort: mark.
+const incompleteSuffix = ".incomplete"
+
+// partSuffix is appended to a local file's path while it is written; it is
+// renamed to the path once complete, so the last complete file stays in
+// place until then.
+const partSuffix = ".part"
+
+// validateAbortPolicy checks the abort setting.
+func validateAbortPolicy(policy string) error {
+	switch policy {
+	case "", abortDelete, abortMark, abortKeep:
+		return nil
+	}
+	return fmt.Errorf("Unsupported abort policy '%s'\n", policy)
+}
+
+// discardPart applies policy to the partial file written as part for the
+// file at path: delete removes it, mark renames it to path.incomplete and
+// keep leaves it under its temporary name, next to the last complete file.
+func discardPart(part, path, policy string) {
+	var err error
+	switch policy {
+	case abortKeep:
+		return
+	case abortMark:
+		err = os.Rename(part, path+incompleteSuffix)
+	default:
+		err = os.Remove(part)
+	}
+	if err != nil && !os.IsNotExist(err) {
+		log.Printf("Warning: could not clean up partial file %s: %v\n", part, err)
+	}
+}
+
+// discardFile applies policy to the file at path, complete but rejected.
+func discardFile(path, policy string) {
+	var err error
+	switch policy {
+	case abortKeep:
+		return
+	case abortMark:
+		err = os.Rename(path, path+incompleteSuffix)
+	default:
+		err = os.Remove(path)
+	}
+	if err != nil && !os.IsNotExist(err) {
+		log.Printf("Warning: could not clean up partial file %s: %v\n", path, err)
+	}
+}
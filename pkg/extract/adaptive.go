@@ -0,0 +1,163 @@
+package extract
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+// defaultHealthQuery samples SQL Server load. It needs VIEW SERVER STATE.
+const defaultHealthQuery = `SELECT
+	(SELECT TOP (1) CONVERT(xml, record).value('(./Record/SchedulerMonitorEvent/SystemHealth/ProcessUtilization)[1]', 'int')
+		FROM sys.dm_os_ring_buffers
+		WHERE ring_buffer_type = N'RING_BUFFER_SCHEDULER_MONITOR' AND record LIKE N'%<SystemHealth>%'
+		ORDER BY [timestamp] DESC) AS cpu_percent,
+	(SELECT SUM(runnable_tasks_count) FROM sys.dm_os_schedulers WHERE status = 'VISIBLE ONLINE') AS runnable_tasks,
+	(SELECT COUNT(*) FROM sys.dm_exec_requests WHERE blocking_session_id <> 0) AS blocked_sessions`
+
+// growBelow is the fraction of every limit the load must stay under before
+// concurrency grows again.
+const growBelow = 0.75
+
+// adaptiveOptions shrinks and grows the number of concurrent jobs with the
+// load of the source server.
+type adaptiveOptions struct {
+	// Query returns one row of numeric load measures; the default samples
+	// cpu_percent, runnable_tasks and blocked_sessions.
+	Query string `yaml:"query"`
+	// Limits are the stress thresholds per column of Query.
+	Limits   map[string]float64 `yaml:"limits"`
+	Interval configDuration     `yaml:"interval"`
+	Min      int                `yaml:"min"`
+	Max      int                `yaml:"max"`
+}
+
+// enabled reports whether adaptive concurrency is configured.
+func (o adaptiveOptions) enabled() bool {
+	return len(o.Limits) > 0
+}
+
+// validate fills in the defaults and checks the bounds.
+func (o *adaptiveOptions) validate(maxJobs int) error {
+	if !o.enabled() {
+		return nil
+	}
+	if o.Query == "" {
+		o.Query = defaultHealthQuery
+	}
+	if o.Interval <= 0 {
+		o.Interval = configDuration(30 * time.Second)
+	}
+	if o.Min <= 0 {
+		o.Min = 1
+	}
+	if o.Max <= 0 {
+		o.Max = maxJobs
+	}
+	if o.Min > o.Max {
+		return fmt.Errorf("Adaptive concurrency min %d is above max %d\n", o.Min, o.Max)
+	}
+	return nil
+}
+
+// sampleLoad runs the health query, returning its columns as numbers.
+func sampleLoad(db *sql.DB, query string) (map[string]float64, error) {
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	cols, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("health query returned no rows")
+	}
+	values := make([]any, len(cols))
+	ptrs := make([]any, len(cols))
+	for i := range values {
+		ptrs[i] = &values[i]
+	}
+	if err := rows.Scan(ptrs...); err != nil {
+		return nil, err
+	}
+	load := make(map[string]float64, len(cols))
+	for i, c := range cols {
+		if values[i] == nil {
+			continue
+		}
+		f, err := asFloat64(values[i])
+		if err != nil {
+			if n, intErr := asInt64(values[i]); intErr == nil {
+				f, err = float64(n), nil
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("health column %s: %v", c, err)
+		}
+		load[strings.ToLower(c)] = f
+	}
+	return load, nil
+}
+
+// nextLimit halves the limit when any measure is over its threshold and
+// adds one when all are comfortably below, within the bounds.
+func (o adaptiveOptions) nextLimit(current int, load map[string]float64) (int, []string) {
+	var over []string
+	calm := true
+	for name, limit := range o.Limits {
+		v, ok := load[strings.ToLower(name)]
+		if !ok {
+			continue
+		}
+		if v > limit {
+			over = append(over, fmt.Sprintf("%s=%g > %g", name, v, limit))
+		}
+		if v >= limit*growBelow {
+			calm = false
+		}
+	}
+	sort.Strings(over)
+	switch {
+	case len(over) > 0:
+		return max(o.Min, current/2), over
+	case calm:
+		return min(o.Max, current+1), nil
+	}
+	return current, nil
+}
+
+// adaptConcurrency polls the source's load and resizes l until stop is closed.
+func adaptConcurrency(db *sql.DB, o adaptiveOptions, l *limiter, stop <-chan struct{}) {
+	l.setLimit(o.Max)
+	ticker := time.NewTicker(time.Duration(o.Interval))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		load, err := sampleLoad(db, o.Query)
+		if err != nil {
+			log.Printf("Warning: could not sample source load: %v\n", err)
+			continue
+		}
+		current := l.currentLimit()
+		next, over := o.nextLimit(current, load)
+		switch {
+		case next < current:
+			log.Printf("Source under stress (%s), reducing concurrency to %d\n", strings.Join(over, ", "), next)
+		case next > current:
+			log.Printf("Source load is low, raising concurrency to %d\n", next)
+		}
+		l.setLimit(next)
+	}
+}
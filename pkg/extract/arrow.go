@@ -0,0 +1,227 @@
+package extract
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/decimal128"
+	"github.com/apache/arrow-go/v18/arrow/ipc"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+)
+
+// arrowBatchRows is the number of rows buffered into each record batch.
+const arrowBatchRows = 64 * 1024
+
+// arrowOptions holds the settings specific to format: arrow.
+type arrowOptions struct {
+	// Compression is one of none (default), lz4 or zstd.
+	Compression string `yaml:"compression"`
+}
+
+// arrowType maps a column to the Arrow data type that preserves its values.
+func arrowType(c column) arrow.DataType {
+	switch c.kind() {
+	case kindInt:
+		return arrow.PrimitiveTypes.Int64
+	case kindFloat:
+		return arrow.PrimitiveTypes.Float64
+	case kindBool:
+		return arrow.FixedWidthTypes.Boolean
+	case kindDecimal:
+		return &arrow.Decimal128Type{Precision: int32(c.Precision), Scale: int32(c.Scale)}
+	case kindDate:
+		return arrow.FixedWidthTypes.Date32
+	case kindTimestamp:
+		return &arrow.TimestampType{Unit: arrow.Microsecond, TimeZone: "UTC"}
+	case kindBytes:
+		return arrow.BinaryTypes.Binary
+	default:
+		return arrow.BinaryTypes.String
+	}
+}
+
+// recordBatcher accumulates rows into typed Arrow record batches. It backs
+// every columnar format built on Arrow.
+type recordBatcher struct {
+	cols    []column
+	schema  *arrow.Schema
+	builder *array.RecordBuilder
+	rows    int
+}
+
+func newRecordBatcher(cols []column) *recordBatcher {
+	return newRecordBatcherWithIDs(cols, nil)
+}
+
+// newRecordBatcherWithIDs tags each field with its Parquet field id, which
+// Iceberg matches columns by.
+func newRecordBatcherWithIDs(cols []column, fieldIDs []int) *recordBatcher {
+	fields := make([]arrow.Field, len(cols))
+	for i, c := range cols {
+		fields[i] = arrow.Field{Name: c.Name, Type: arrowType(c), Nullable: true}
+		if fieldIDs != nil {
+			fields[i].Metadata = arrow.NewMetadata([]string{"PARQUET:field_id"}, []string{strconv.Itoa(fieldIDs[i])})
+		}
+	}
+	schema := arrow.NewSchema(fields, nil)
+	return &recordBatcher{
+		cols:    cols,
+		schema:  schema,
+		builder: array.NewRecordBuilder(memory.DefaultAllocator, schema),
+	}
+}
+
+// add appends one row and reports whether the current batch is full.
+func (b *recordBatcher) add(values []any) (bool, error) {
+	for i, v := range values {
+		if err := b.append(i, v); err != nil {
+			return false, fmt.Errorf("column %s: %v", b.cols[i].Name, err)
+		}
+	}
+	b.rows++
+	return b.rows == arrowBatchRows, nil
+}
+
+// append adds one value to the builder of column i.
+func (b *recordBatcher) append(i int, v any) error {
+	fb := b.builder.Field(i)
+	if v == nil {
+		fb.AppendNull()
+		return nil
+	}
+	switch fb := fb.(type) {
+	case *array.Int64Builder:
+		n, err := asInt64(v)
+		if err != nil {
+			return err
+		}
+		fb.Append(n)
+	case *array.Float64Builder:
+		f, err := asFloat64(v)
+		if err != nil {
+			return err
+		}
+		fb.Append(f)
+	case *array.BooleanBuilder:
+		t, err := asBool(v)
+		if err != nil {
+			return err
+		}
+		fb.Append(t)
+	case *array.Decimal128Builder:
+		dt := fb.Type().(*arrow.Decimal128Type)
+		var n decimal128.Num
+		var err error
+		if f, ok := v.(float64); ok {
+			n, err = decimal128.FromFloat64(f, dt.Precision, dt.Scale)
+		} else {
+			n, err = decimal128.FromString(formatValue(v), dt.Precision, dt.Scale)
+		}
+		if err != nil {
+			return err
+		}
+		fb.Append(n)
+	case *array.Date32Builder:
+		t, err := asTime(v)
+		if err != nil {
+			return err
+		}
+		fb.Append(arrow.Date32FromTime(t))
+	case *array.TimestampBuilder:
+		t, err := asTime(v)
+		if err != nil {
+			return err
+		}
+		fb.Append(arrow.Timestamp(t.UTC().UnixMicro()))
+	case *array.BinaryBuilder:
+		if p, ok := v.([]byte); ok {
+			fb.Append(p)
+		} else {
+			fb.Append([]byte(formatValue(v)))
+		}
+	case *array.StringBuilder:
+		fb.Append(formatValue(v))
+	default:
+		return fmt.Errorf("unsupported arrow builder %T", fb)
+	}
+	return nil
+}
+
+// batch returns the buffered rows as a record batch, or nil when there are
+// none. The caller must release the batch.
+func (b *recordBatcher) batch() arrow.RecordBatch {
+	if b.rows == 0 {
+		return nil
+	}
+	b.rows = 0
+	return b.builder.NewRecordBatch()
+}
+
+func (b *recordBatcher) release() {
+	b.builder.Release()
+}
+
+// arrowWriter writes the result set as an Arrow IPC file (Feather v2), keeping
+// the column types reported by the driver.
+type arrowWriter struct {
+	w    io.Writer
+	opts []ipc.Option
+	b    *recordBatcher
+	fw   *ipc.FileWriter
+}
+
+func newArrowWriter(w io.Writer, o arrowOptions) (*arrowWriter, error) {
+	opts := []ipc.Option{ipc.WithAllocator(memory.DefaultAllocator)}
+	switch o.Compression {
+	case "", "none":
+	case "lz4":
+		opts = append(opts, ipc.WithLZ4())
+	case "zstd":
+		opts = append(opts, ipc.WithZstd())
+	default:
+		return nil, fmt.Errorf("Unsupported arrow compression '%s'\n", o.Compression)
+	}
+	return &arrowWriter{w: w, opts: opts}, nil
+}
+
+func (a *arrowWriter) WriteHeader(cols []column) error {
+	a.b = newRecordBatcher(cols)
+	fw, err := ipc.NewFileWriter(a.w, append(a.opts, ipc.WithSchema(a.b.schema))...)
+	if err != nil {
+		return err
+	}
+	a.fw = fw
+	return nil
+}
+
+func (a *arrowWriter) WriteRow(values []any) error {
+	full, err := a.b.add(values)
+	if err != nil || !full {
+		return err
+	}
+	return a.flush()
+}
+
+// flush writes the buffered rows as one record batch.
+func (a *arrowWriter) flush() error {
+	rec := a.b.batch()
+	if rec == nil {
+		return nil
+	}
+	defer rec.Release()
+	return a.fw.Write(rec)
+}
+
+func (a *arrowWriter) Close() error {
+	if a.fw == nil {
+		return nil
+	}
+	defer a.b.release()
+	if err := a.flush(); err != nil {
+		return err
+	}
+	return a.fw.Close()
+}
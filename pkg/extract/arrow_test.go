@@ -0,0 +1,89 @@
+package extract
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/ipc"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+)
+
+func TestExportDataArrow(t *testing.T) {
+	registerFake("typed", &fakeQuery{sets: []*fakeResult{typedResult()}})
+	for _, compression := range []string{"", "lz4", "zstd"} {
+		out := filepath.Join(t.TempDir(), "out.arrow")
+		if _, err := exportFake(t, &config{Format: "arrow", Arrow: arrowOptions{Compression: compression}}, "typed", out); err != nil {
+			t.Fatal(err)
+		}
+		f, err := os.Open(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		r, err := ipc.NewFileReader(f, ipc.WithAllocator(memory.DefaultAllocator))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Close()
+
+		wantTypes := []arrow.DataType{
+			arrow.PrimitiveTypes.Int64, arrow.PrimitiveTypes.Float64, &arrow.Decimal128Type{Precision: 10, Scale: 2},
+			arrow.FixedWidthTypes.Boolean, arrow.FixedWidthTypes.Date32, &arrow.TimestampType{Unit: arrow.Microsecond, TimeZone: "UTC"},
+			arrow.BinaryTypes.String, arrow.BinaryTypes.Binary,
+		}
+		schema := r.Schema()
+		for i, want := range wantTypes {
+			if !arrow.TypeEqual(schema.Field(i).Type, want) {
+				t.Errorf("%s: column %s is %v, want %v", compression, schema.Field(i).Name, schema.Field(i).Type, want)
+			}
+		}
+		if r.NumRecords() != 1 {
+			t.Fatalf("%s: got %d record batches, want 1", compression, r.NumRecords())
+		}
+		rec, err := r.Record(0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rec.NumRows() != 2 {
+			t.Fatalf("%s: got %d rows, want 2", compression, rec.NumRows())
+		}
+		at := time.Date(2026, 10, 15, 9, 30, 15, 123456000, time.UTC)
+		if v := rec.Column(0).(*array.Int64).Value(0); v != 42 {
+			t.Errorf("id = %d", v)
+		}
+		if v := rec.Column(1).(*array.Float64).Value(0); v != 2.5 {
+			t.Errorf("price = %g", v)
+		}
+		if v := rec.Column(2).(*array.Decimal128).ValueStr(0); v != "12.34" {
+			t.Errorf("amount = %s", v)
+		}
+		if v := rec.Column(3).(*array.Boolean).Value(0); !v {
+			t.Errorf("active = %t", v)
+		}
+		if v := rec.Column(4).(*array.Date32).Value(0).ToTime(); !v.Equal(at.Truncate(24 * time.Hour)) {
+			t.Errorf("day = %s", v)
+		}
+		if v := rec.Column(5).(*array.Timestamp).Value(0); v != arrow.Timestamp(at.UnixMicro()) {
+			t.Errorf("at = %d", v)
+		}
+		if v := rec.Column(6).(*array.String).Value(0); v != `Åsa "q"` {
+			t.Errorf("name = %q", v)
+		}
+		if v := rec.Column(7).(*array.Binary).Value(0); string(v) != "\x00\x01\x02\xff" {
+			t.Errorf("data = %q", v)
+		}
+		for i := 0; i < int(rec.NumCols()); i++ {
+			if !rec.Column(i).IsNull(1) {
+				t.Errorf("%s: column %s of the NULL row is not null", compression, schema.Field(i).Name)
+			}
+		}
+	}
+
+	if _, err := exportFake(t, &config{Format: "arrow", Arrow: arrowOptions{Compression: "brotli"}}, "typed", filepath.Join(t.TempDir(), "out.arrow")); err == nil {
+		t.Error("an unsupported compression was accepted")
+	}
+}
@@ -0,0 +1,218 @@
+package extract
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/user"
+	"sync"
+	"time"
+)
+
+// auditKeyEnv names the environment variable holding the key the audit log
+// entries are signed with.
+const auditKeyEnv = "AUDIT_KEY"
+
+// auditEntry records one job's access to the source. Hash is the HMAC of the
+// entry with an empty Hash, and Prev is the hash of the entry before it, so
+// editing, removing or reordering entries breaks the chain, and without the
+// key the chain cannot be rewritten to match.
+type auditEntry struct {
+	Time       time.Time `json:"time"`
+	User       string    `json:"user"`
+	Host       string    `json:"host"`
+	Config     string    `json:"config"`
+	ConfigHash string    `json:"config_sha256"`
+	Server     string    `json:"server"`
+	Database   string    `json:"database"`
+	Query      string    `json:"query"`
+	Tables     []string  `json:"tables,omitempty"`
+	OutFile    string    `json:"destination"`
+	Status     string    `json:"status"`
+	Rows       uint      `json:"rows"`
+	DryRun     bool      `json:"dry_run,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	TimedOut   bool      `json:"timed_out,omitempty"`
+	Prev       string    `json:"prev"`
+	Hash       string    `json:"hash"`
+}
+
+// digest returns the HMAC of e with Hash left out.
+func (e auditEntry) digest(key []byte) string {
+	e.Hash = ""
+	data, _ := json.Marshal(e)
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// auditKey returns the audit log key from the environment.
+func auditKey() ([]byte, error) {
+	key := os.Getenv(auditKeyEnv)
+	if key == "" {
+		return nil, fmt.Errorf("The audit log key environment variable %s is not set\n", auditKeyEnv)
+	}
+	return []byte(key), nil
+}
+
+// auditEntry starts the audit entry of a job with what the configuration says
+// about it.
+func (c *config) auditEntry(tenant, query, outFile string) auditEntry {
+	server, database := c.serverFor(tenant)
+	return auditEntry{
+		Time: time.Now().UTC(), Config: c.source, ConfigHash: c.digest,
+		Server: server, Database: database, Query: query, Tables: referencedTables(query),
+		OutFile: outFile, DryRun: c.dryRun,
+	}
+}
+
+// auditLog appends hash-chained entries to a JSON lines file. Several runs
+// may share the file, so each entry is chained to the last one in the file
+// rather than the last one this run wrote.
+type auditLog struct {
+	mu   sync.Mutex
+	path string
+	key  []byte
+	user string
+	host string
+}
+
+// openAuditLog checks the chain of the audit log at path, refusing to append
+// to a log whose chain is already broken. A nil log records nothing.
+func openAuditLog(path string) (*auditLog, error) {
+	if path == "" {
+		return nil, nil
+	}
+	key, err := auditKey()
+	if err != nil {
+		return nil, err
+	}
+	if _, _, err := verifyAuditLog(path, key); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	a := &auditLog{path: path, key: key, user: "unknown"}
+	if u, err := user.Current(); err == nil {
+		a.user = u.Username
+	}
+	a.host, _ = os.Hostname()
+	return a, nil
+}
+
+// record chains e to the log and appends it. The file stays locked from
+// reading the last hash until the entry is written, so runs appending at
+// the same time cannot both chain to the same entry.
+func (a *auditLog) record(e auditEntry) error {
+	if a == nil {
+		return nil
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	f, err := os.OpenFile(a.path, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0o640)
+	if err != nil {
+		return fmt.Errorf("Could not open audit log %s: %v\n", a.path, err)
+	}
+	defer f.Close()
+	if err := lockFile(f); err != nil {
+		return fmt.Errorf("Could not lock audit log %s: %v\n", a.path, err)
+	}
+	defer unlockFile(f)
+
+	prev, err := lastAuditHash(f)
+	if err != nil {
+		return fmt.Errorf("Could not read audit log %s: %v\n", a.path, err)
+	}
+	e.User, e.Host, e.Prev = a.user, a.host, prev
+	e.Hash = e.digest(a.key)
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("Could not write audit log %s: %v\n", a.path, err)
+	}
+	if err := f.Sync(); err != nil {
+		return fmt.Errorf("Could not write audit log %s: %v\n", a.path, err)
+	}
+	return nil
+}
+
+// lastAuditHash returns the hash of the last entry of the audit log f, read
+// back from its end, or "" for an empty log.
+func lastAuditHash(f *os.File) (string, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	end := info.Size()
+	if end == 0 {
+		return "", nil
+	}
+	// the last line ends in a newline; find the one before it
+	var tail []byte
+	for chunk := int64(4096); ; chunk *= 2 {
+		start := max(end-chunk, 0)
+		tail = make([]byte, end-start)
+		if _, err := f.ReadAt(tail, start); err != nil {
+			return "", err
+		}
+		if i := bytes.LastIndexByte(tail[:len(tail)-1], '\n'); i >= 0 {
+			tail = tail[i+1:]
+			break
+		}
+		if start == 0 {
+			break
+		}
+	}
+	var e auditEntry
+	if err := json.Unmarshal(tail, &e); err != nil {
+		return "", fmt.Errorf("the last line is not an entry: %v", err)
+	}
+	return e.Hash, nil
+}
+
+// verifyAuditLog checks every entry's hash and link against key, returning
+// the last hash and the number of entries.
+func verifyAuditLog(path string, key []byte) (string, int, error) {
+	return scanAuditLog(path, key, nil)
+}
+
+// scanAuditLog verifies the audit log at path as verifyAuditLog does, passing
+// each entry to visit if it is set.
+func scanAuditLog(path string, key []byte, visit func(e auditEntry)) (string, int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+
+	var prev string
+	n := 0
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		n++
+		var e auditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return "", n, fmt.Errorf("Audit log %s line %d is not an entry: %v\n", path, n, err)
+		}
+		if e.Prev != prev {
+			return "", n, fmt.Errorf("Audit log %s line %d does not follow the entry before it\n", path, n)
+		}
+		if !hmac.Equal([]byte(e.digest(key)), []byte(e.Hash)) {
+			return "", n, fmt.Errorf("Audit log %s line %d was modified\n", path, n)
+		}
+		prev = e.Hash
+		if visit != nil {
+			visit(e)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", n, fmt.Errorf("Could not read audit log %s: %v\n", path, err)
+	}
+	return prev, n, nil
+}
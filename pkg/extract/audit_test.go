@@ -0,0 +1,131 @@
+package extract
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestAuditLogChain(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	if _, err := openAuditLog(path); err == nil || !strings.Contains(err.Error(), auditKeyEnv) {
+		t.Errorf("opened a log without a key: %v", err)
+	}
+	t.Setenv(auditKeyEnv, "secret")
+	key := []byte("secret")
+	for i, outFile := range []string{"a.csv", "b.csv", "c.csv"} {
+		// reopening continues the chain of the existing entries
+		a, err := openAuditLog(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := a.record(auditEntry{OutFile: outFile, Status: jobDone, Rows: uint(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, n, err := verifyAuditLog(path, key); err != nil || n != 3 {
+		t.Fatalf("verify = %d entries, %v", n, err)
+	}
+
+	data, _ := os.ReadFile(path)
+	tampered := strings.Replace(string(data), `"rows":1`, `"rows":100`, 1)
+	os.WriteFile(path, []byte(tampered), 0o640)
+	if _, _, err := verifyAuditLog(path, key); err == nil || !strings.Contains(err.Error(), "line 2 was modified") {
+		t.Errorf("got %v, want line 2 reported as modified", err)
+	}
+	if _, err := openAuditLog(path); err == nil {
+		t.Error("opened a log with a broken chain for appending")
+	}
+
+	// an intact log does not verify without its key, so a rewritten chain would not either
+	os.WriteFile(path, data, 0o640)
+	if _, _, err := verifyAuditLog(path, []byte("guess")); err == nil || !strings.Contains(err.Error(), "line 1 was modified") {
+		t.Errorf("got %v, want the wrong key reported", err)
+	}
+
+	lines := strings.SplitAfter(string(data), "\n")
+	os.WriteFile(path, []byte(lines[0]+lines[2]), 0o640)
+	if _, _, err := verifyAuditLog(path, key); err == nil || !strings.Contains(err.Error(), "does not follow") {
+		t.Errorf("got %v, want a removed entry detected", err)
+	}
+}
+
+func TestAuditLogConcurrentRuns(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	t.Setenv(auditKeyEnv, "secret")
+	// two runs share the log, each recording from several jobs at once
+	var runs []*auditLog
+	for range 2 {
+		a, err := openAuditLog(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		runs = append(runs, a)
+	}
+	var wg sync.WaitGroup
+	for i := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range 25 {
+				// some entries are longer than the tail read back at first
+				query := strings.Repeat("SELECT 1 UNION ALL ", j%3*300)
+				if err := runs[i%2].record(auditEntry{OutFile: fmt.Sprintf("%d-%d.csv", i, j), Query: query, Status: jobDone}); err != nil {
+					t.Error(err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if _, n, err := verifyAuditLog(path, []byte("secret")); err != nil || n != 200 {
+		t.Errorf("verify = %d entries, %v", n, err)
+	}
+}
+
+func TestAuditLogWaitsForLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	t.Setenv(auditKeyEnv, "secret")
+	key := []byte("secret")
+	a, err := openAuditLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.record(auditEntry{OutFile: "a.csv", Status: jobDone})
+
+	// another run holds the lock between reading the last hash and appending
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := lockFile(f); err != nil {
+		t.Fatal(err)
+	}
+	prev, err := lastAuditHash(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error)
+	go func() { done <- a.record(auditEntry{OutFile: "c.csv", Status: jobDone}) }()
+	select {
+	case err := <-done:
+		t.Fatalf("recorded while the log was locked: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	e := auditEntry{OutFile: "b.csv", Status: jobDone, Prev: prev}
+	e.Hash = e.digest(key)
+	data, _ := json.Marshal(e)
+	f.Write(append(data, '\n'))
+	unlockFile(f)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if _, n, err := verifyAuditLog(path, key); err != nil || n != 3 {
+		t.Errorf("verify = %d entries, %v", n, err)
+	}
+}
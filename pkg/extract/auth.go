@@ -0,0 +1,147 @@
+package extract
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	mssql "github.com/denisenkom/go-mssqldb"
+)
+
+// Login methods of the source database.
+const (
+	authSQL     = "sql"
+	authWindows = "windows"
+	authAzureAD = "azure_ad"
+)
+
+// azureSQLScope is the scope of Azure AD tokens for Azure SQL.
+const azureSQLScope = "https://database.windows.net/.default"
+
+// authOptions is how the source database is logged in to.
+type authOptions struct {
+	// Method is sql, windows or azure_ad. It is sql when a user is set and
+	// windows otherwise.
+	Method string `yaml:"method"`
+	// User and the password read from the PasswordEnv environment variable
+	// are the SQL login, or with windows a DOMAIN\user logging in over NTLM
+	// in place of the integrated login of the current Windows account.
+	User        string `yaml:"user"`
+	PasswordEnv string `yaml:"password_env"`
+	// SPN is the Kerberos service principal name of the server for windows
+	// logins, MSSQLSvc/host:port by default.
+	SPN string `yaml:"spn"`
+	// Credential is the Azure AD credential: default (environment,
+	// workload identity, managed identity or Azure CLI), managed_identity or
+	// service_principal.
+	Credential string `yaml:"credential"`
+	// ClientID picks a user-assigned managed identity, or is the application
+	// of a service principal, with TenantID and the secret read from the
+	// ClientSecretEnv environment variable.
+	ClientID        string `yaml:"client_id"`
+	TenantID        string `yaml:"tenant_id"`
+	ClientSecretEnv string `yaml:"client_secret_env"`
+}
+
+// method returns the login method, defaulting by whether a user is set.
+func (a authOptions) method() string {
+	switch {
+	case a.Method != "":
+		return a.Method
+	case a.User != "":
+		return authSQL
+	}
+	return authWindows
+}
+
+// validate checks the login for the source driver. where names the setting
+// in errors, such as auth or tenant finance.
+func (a authOptions) validate(where string, d sourceDriver) error {
+	if a.PasswordEnv != "" && a.User == "" {
+		return fmt.Errorf("The %s login sets password_env without a user\n", where)
+	}
+	if d.semicolons && strings.Contains(a.User, ";") {
+		return fmt.Errorf("The %s user cannot contain ';'\n", where)
+	}
+	method := a.method()
+	if method != authSQL && d.name != "sqlserver" {
+		return fmt.Errorf("The %s login method %s is only supported for SQL Server\n", where, method)
+	}
+	switch method {
+	case authSQL:
+		if a.User == "" {
+			return fmt.Errorf("The %s login method sql needs a user\n", where)
+		}
+	case authWindows:
+		if a.User != "" && !strings.Contains(a.User, `\`) {
+			return fmt.Errorf("The %s windows login user must be given as DOMAIN\\user, got %s\n", where, a.User)
+		}
+	case authAzureAD:
+		if a.User != "" {
+			return fmt.Errorf("The %s azure_ad login takes no user\n", where)
+		}
+		switch a.Credential {
+		case "", "default", "managed_identity":
+		case "service_principal":
+			if a.TenantID == "" || a.ClientID == "" || a.ClientSecretEnv == "" {
+				return fmt.Errorf("The %s service_principal login needs tenant_id, client_id and client_secret_env\n", where)
+			}
+		default:
+			return fmt.Errorf("Unsupported %s Azure AD credential '%s' (default, managed_identity, service_principal)\n", where, a.Credential)
+		}
+	default:
+		return fmt.Errorf("Unsupported %s login method '%s' (sql, windows, azure_ad)\n", where, a.Method)
+	}
+	return nil
+}
+
+// login returns the login to the source database: auth, with the
+// top-level user and password_env as its user.
+func (c *config) login() authOptions {
+	a := c.Auth
+	if a.User == "" {
+		a.User, a.PasswordEnv = c.User, c.PasswordEnv
+	}
+	return a
+}
+
+// azureCredential returns the Azure AD credential of the login.
+func (a authOptions) azureCredential() (azcore.TokenCredential, error) {
+	switch a.Credential {
+	case "managed_identity":
+		opts := &azidentity.ManagedIdentityCredentialOptions{}
+		if a.ClientID != "" {
+			opts.ID = azidentity.ClientID(a.ClientID)
+		}
+		return azidentity.NewManagedIdentityCredential(opts)
+	case "service_principal":
+		secret := os.Getenv(a.ClientSecretEnv)
+		if secret == "" {
+			return nil, fmt.Errorf("the client secret variable %s is not set", a.ClientSecretEnv)
+		}
+		return azidentity.NewClientSecretCredential(a.TenantID, a.ClientID, secret, nil)
+	}
+	return azidentity.NewDefaultAzureCredential(nil)
+}
+
+// openAzureAD connects to SQL Server with Azure AD tokens of the login's
+// credential, fetched again as they expire.
+func openAzureAD(server, database string, a authOptions) (*sql.DB, error) {
+	cred, err := a.azureCredential()
+	if err != nil {
+		return nil, fmt.Errorf("Could not load the Azure AD credential for %s: %v\n", server, err)
+	}
+	connector, err := mssql.NewAccessTokenConnector(connectionString(server, database), func() (string, error) {
+		tok, err := cred.GetToken(context.Background(), policy.TokenRequestOptions{Scopes: []string{azureSQLScope}})
+		return tok.Token, err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Could not connect to %s: %v\n", server, err)
+	}
+	return sql.OpenDB(connector), nil
+}
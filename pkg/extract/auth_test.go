@@ -0,0 +1,52 @@
+package extract
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAuthValidate(t *testing.T) {
+	sqlserver, _ := (&config{}).sourceDriver()
+	postgres, _ := (&config{Driver: "postgres"}).sourceDriver()
+	for _, tc := range []struct {
+		a    authOptions
+		d    sourceDriver
+		want string
+	}{
+		{authOptions{}, sqlserver, ""},
+		{authOptions{User: "etl", PasswordEnv: "SQL_PASSWORD"}, sqlserver, ""},
+		{authOptions{Method: authWindows, User: `CORP\etl`, PasswordEnv: "SQL_PASSWORD", SPN: "MSSQLSvc/db1.corp:1433"}, sqlserver, ""},
+		{authOptions{Method: authAzureAD}, sqlserver, ""},
+		{authOptions{Method: authAzureAD, Credential: "managed_identity", ClientID: "b1e5"}, sqlserver, ""},
+		{authOptions{Method: authAzureAD, Credential: "service_principal", TenantID: "t", ClientID: "c", ClientSecretEnv: "SECRET"}, sqlserver, ""},
+		{authOptions{User: "etl"}, postgres, ""},
+		{authOptions{PasswordEnv: "SQL_PASSWORD"}, sqlserver, "without a user"},
+		{authOptions{Method: authSQL}, sqlserver, "needs a user"},
+		{authOptions{Method: authWindows, User: "etl"}, sqlserver, `DOMAIN\user`},
+		{authOptions{Method: authAzureAD, User: "etl"}, sqlserver, "takes no user"},
+		{authOptions{Method: authAzureAD, Credential: "service_principal"}, sqlserver, "needs tenant_id"},
+		{authOptions{Method: authAzureAD, Credential: "cli"}, sqlserver, "Unsupported"},
+		{authOptions{Method: "kerberos"}, sqlserver, "Unsupported"},
+		{authOptions{Method: authAzureAD}, postgres, "only supported for SQL Server"},
+		{authOptions{User: "e;tl"}, sqlserver, "cannot contain ';'"},
+	} {
+		err := tc.a.validate("auth", tc.d)
+		if tc.want == "" && err != nil || tc.want != "" && (err == nil || !strings.Contains(err.Error(), tc.want)) {
+			t.Errorf("%+v on %s: got %v, want %q", tc.a, tc.d.name, err, tc.want)
+		}
+	}
+}
+
+func TestLogin(t *testing.T) {
+	c := &config{User: "etl", PasswordEnv: "SQL_PASSWORD", Auth: authOptions{SPN: "MSSQLSvc/db1:1433"}}
+	if got := c.login(); got.User != "etl" || got.PasswordEnv != "SQL_PASSWORD" || got.SPN != "MSSQLSvc/db1:1433" || got.method() != authSQL {
+		t.Errorf("got %+v", got)
+	}
+	tenants := map[string]tenantOptions{"finance": {User: `FIN\svc_export`, PasswordEnv: "FIN_PASSWORD"}, "sales": {}}
+	if got := tenants["finance"].login(c); got.method() != authWindows || got.User != `FIN\svc_export` {
+		t.Errorf("finance got %+v", got)
+	}
+	if got := tenants["sales"].login(c); got.User != "etl" {
+		t.Errorf("sales got %+v, want the top-level login", got)
+	}
+}
@@ -0,0 +1,343 @@
+package extract
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Iceberg keeps its manifests in Avro object container files. avroEncoder
+// writes the binary encoding of the records this tool writes itself, and
+// readAvroFile decodes any container file by its schema, so manifest lists
+// written by other engines can be carried into the next snapshot.
+
+const avroMagic = "Obj\x01"
+
+// avroEncoder writes values in the Avro binary encoding.
+type avroEncoder struct{ bytes.Buffer }
+
+func (e *avroEncoder) long(v int64) {
+	var b [binary.MaxVarintLen64]byte
+	e.Write(b[:binary.PutVarint(b[:], v)])
+}
+
+func (e *avroEncoder) int(v int32) { e.long(int64(v)) }
+
+func (e *avroEncoder) bool(v bool) {
+	if v {
+		e.WriteByte(1)
+	} else {
+		e.WriteByte(0)
+	}
+}
+
+func (e *avroEncoder) double(v float64) {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], math.Float64bits(v))
+	e.Write(b[:])
+}
+
+func (e *avroEncoder) bytes(p []byte) {
+	e.long(int64(len(p)))
+	e.Write(p)
+}
+
+func (e *avroEncoder) string(s string) {
+	e.long(int64(len(s)))
+	e.WriteString(s)
+}
+
+// union writes the branch of a ["null", T] union: false for null, after
+// which nothing follows.
+func (e *avroEncoder) union(present bool) {
+	if present {
+		e.long(1)
+	} else {
+		e.long(0)
+	}
+}
+
+// writeAvroFile writes records, each already encoded, as one uncompressed
+// block of a container file with schema and the metadata meta.
+func writeAvroFile(schema string, meta map[string]string, records [][]byte) []byte {
+	var e avroEncoder
+	e.WriteString(avroMagic)
+	e.long(int64(len(meta) + 2))
+	e.string("avro.schema")
+	e.bytes([]byte(schema))
+	e.string("avro.codec")
+	e.bytes([]byte("null"))
+	for k, v := range meta {
+		e.string(k)
+		e.bytes([]byte(v))
+	}
+	e.long(0)
+	var sync [16]byte
+	rand.Read(sync[:])
+	e.Write(sync[:])
+	if len(records) > 0 {
+		var block bytes.Buffer
+		for _, r := range records {
+			block.Write(r)
+		}
+		e.long(int64(len(records)))
+		e.long(int64(block.Len()))
+		e.Write(block.Bytes())
+		e.Write(sync[:])
+	}
+	return e.Bytes()
+}
+
+// avroFile is a decoded container file. Records and fields are decoded to
+// map[string]any, arrays to []any, maps to map[string]any and null to nil.
+type avroFile struct {
+	meta    map[string]string
+	records []any
+}
+
+// readAvroFile decodes a container file compressed with null, deflate,
+// snappy or zstandard.
+func readAvroFile(data []byte) (*avroFile, error) {
+	d := &avroDecoder{r: bytes.NewReader(data)}
+	magic := make([]byte, 4)
+	if _, err := io.ReadFull(d.r, magic); err != nil || string(magic) != avroMagic {
+		return nil, errors.New("not an Avro container file")
+	}
+	f := &avroFile{meta: map[string]string{}}
+	for {
+		n, err := d.long()
+		if err != nil {
+			return nil, err
+		}
+		if n == 0 {
+			break
+		}
+		if n < 0 {
+			d.long()
+			n = -n
+		}
+		for ; n > 0; n-- {
+			k, err := d.string()
+			if err != nil {
+				return nil, err
+			}
+			v, err := d.bytes()
+			if err != nil {
+				return nil, err
+			}
+			f.meta[k] = string(v)
+		}
+	}
+	var sync [16]byte
+	if _, err := io.ReadFull(d.r, sync[:]); err != nil {
+		return nil, err
+	}
+	var schema any
+	if err := json.Unmarshal([]byte(f.meta["avro.schema"]), &schema); err != nil {
+		return nil, fmt.Errorf("unreadable Avro schema: %v", err)
+	}
+	names := map[string]any{}
+	for d.r.Len() > 0 {
+		count, err := d.long()
+		if err != nil {
+			return nil, err
+		}
+		block, err := d.bytes()
+		if err != nil {
+			return nil, err
+		}
+		if block, err = avroDecompress(f.meta["avro.codec"], block); err != nil {
+			return nil, err
+		}
+		bd := &avroDecoder{r: bytes.NewReader(block)}
+		for ; count > 0; count-- {
+			v, err := bd.value(schema, names)
+			if err != nil {
+				return nil, err
+			}
+			f.records = append(f.records, v)
+		}
+		var marker [16]byte
+		if _, err := io.ReadFull(d.r, marker[:]); err != nil || marker != sync {
+			return nil, errors.New("Avro sync marker mismatch")
+		}
+	}
+	return f, nil
+}
+
+func avroDecompress(codec string, block []byte) ([]byte, error) {
+	switch codec {
+	case "", "null":
+		return block, nil
+	case "deflate":
+		return io.ReadAll(flate.NewReader(bytes.NewReader(block)))
+	case "snappy":
+		// the block ends in the CRC-32 of the uncompressed data
+		if len(block) < 4 {
+			return nil, errors.New("short snappy block")
+		}
+		return snappy.Decode(nil, block[:len(block)-4])
+	case "zstandard":
+		dec, err := zstd.NewReader(nil)
+		if err != nil {
+			return nil, err
+		}
+		defer dec.Close()
+		return dec.DecodeAll(block, nil)
+	}
+	return nil, fmt.Errorf("unsupported Avro codec %s", codec)
+}
+
+type avroDecoder struct{ r *bytes.Reader }
+
+func (d *avroDecoder) long() (int64, error) {
+	return binary.ReadVarint(d.r)
+}
+
+func (d *avroDecoder) bytes() ([]byte, error) {
+	n, err := d.long()
+	if err != nil {
+		return nil, err
+	}
+	if n < 0 || n > int64(d.r.Len()) {
+		return nil, errors.New("invalid Avro length")
+	}
+	p := make([]byte, n)
+	_, err = io.ReadFull(d.r, p)
+	return p, err
+}
+
+func (d *avroDecoder) string() (string, error) {
+	p, err := d.bytes()
+	return string(p), err
+}
+
+// value decodes one value of schema. names collects the named types seen so
+// far, which later fields may refer to by name.
+func (d *avroDecoder) value(schema any, names map[string]any) (any, error) {
+	switch s := schema.(type) {
+	case string:
+		return d.primitive(s, names)
+	case []any:
+		branch, err := d.long()
+		if err != nil {
+			return nil, err
+		}
+		if branch < 0 || branch >= int64(len(s)) {
+			return nil, errors.New("invalid Avro union branch")
+		}
+		return d.value(s[branch], names)
+	case map[string]any:
+		typ, _ := s["type"].(string)
+		if name, ok := s["name"].(string); ok {
+			names[name] = s
+		}
+		switch typ {
+		case "record":
+			fields, _ := s["fields"].([]any)
+			rec := make(map[string]any, len(fields))
+			for _, f := range fields {
+				field, _ := f.(map[string]any)
+				name, _ := field["name"].(string)
+				v, err := d.value(field["type"], names)
+				if err != nil {
+					return nil, err
+				}
+				rec[name] = v
+			}
+			return rec, nil
+		case "enum":
+			i, err := d.long()
+			symbols, _ := s["symbols"].([]any)
+			if err != nil || i < 0 || i >= int64(len(symbols)) {
+				return nil, errors.New("invalid Avro enum")
+			}
+			return symbols[i], nil
+		case "fixed":
+			size, _ := s["size"].(float64)
+			p := make([]byte, int(size))
+			_, err := io.ReadFull(d.r, p)
+			return p, err
+		case "array":
+			var items []any
+			err := d.blocks(func() error {
+				v, err := d.value(s["items"], names)
+				items = append(items, v)
+				return err
+			})
+			return items, err
+		case "map":
+			m := map[string]any{}
+			err := d.blocks(func() error {
+				k, err := d.string()
+				if err != nil {
+					return err
+				}
+				m[k], err = d.value(s["values"], names)
+				return err
+			})
+			return m, err
+		}
+		// a primitive with attributes, such as a logical type
+		return d.value(s["type"], names)
+	}
+	return nil, fmt.Errorf("invalid Avro schema %v", schema)
+}
+
+// blocks reads the blocks of an array or map, calling item for each entry.
+func (d *avroDecoder) blocks(item func() error) error {
+	for {
+		n, err := d.long()
+		if err != nil || n == 0 {
+			return err
+		}
+		if n < 0 {
+			n = -n
+			if _, err := d.long(); err != nil {
+				return err
+			}
+		}
+		for ; n > 0; n-- {
+			if err := item(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (d *avroDecoder) primitive(name string, names map[string]any) (any, error) {
+	switch name {
+	case "null":
+		return nil, nil
+	case "boolean":
+		b, err := d.r.ReadByte()
+		return b != 0, err
+	case "int", "long":
+		return d.long()
+	case "float":
+		var b [4]byte
+		_, err := io.ReadFull(d.r, b[:])
+		return float64(math.Float32frombits(binary.LittleEndian.Uint32(b[:]))), err
+	case "double":
+		var b [8]byte
+		_, err := io.ReadFull(d.r, b[:])
+		return math.Float64frombits(binary.LittleEndian.Uint64(b[:])), err
+	case "bytes":
+		return d.bytes()
+	case "string":
+		return d.string()
+	}
+	if s, ok := names[name]; ok {
+		return d.value(s, names)
+	}
+	return nil, fmt.Errorf("unknown Avro type %s", name)
+}
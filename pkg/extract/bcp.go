@@ -0,0 +1,173 @@
+package extract
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// bcpFormatVersion is the non-XML format file version, readable by SQL Server 2008 and later.
+const bcpFormatVersion = "10.0"
+
+// bcpField describes how one column is stored in the native data file.
+type bcpField struct {
+	hostType string
+	prefix   int
+	length   int
+}
+
+// bcpFieldFor picks the host storage of a column. Integers, floats and bits
+// keep their native binary form; other values are stored as Unicode text,
+// which SQL Server converts to the target column type on load.
+func bcpFieldFor(c column) bcpField {
+	switch c.kind() {
+	case kindInt:
+		return bcpField{"SQLBIGINT", 1, 8}
+	case kindFloat:
+		return bcpField{"SQLFLT8", 1, 8}
+	case kindBool:
+		return bcpField{"SQLBIT", 1, 1}
+	case kindBytes:
+		return bcpField{"SQLBINARY", 8, 0}
+	default:
+		return bcpField{"SQLNCHAR", 8, 0}
+	}
+}
+
+// bcpWriter writes the result set as a bcp native data file, with no header,
+// and a matching format file next to it.
+type bcpWriter struct {
+	w       *bufio.Writer
+	fmtPath string
+	cols    []column
+	fields  []bcpField
+	buf     [8]byte
+}
+
+func newBCPWriter(w io.Writer, outFile string) *bcpWriter {
+	return &bcpWriter{w: bufio.NewWriter(w), fmtPath: outFile + ".fmt"}
+}
+
+// WriteHeader writes the format file describing the data file layout.
+func (b *bcpWriter) WriteHeader(cols []column) error {
+	b.cols = cols
+	b.fields = make([]bcpField, len(cols))
+	f, err := os.Create(b.fmtPath)
+	if err != nil {
+		return fmt.Errorf("Could not create format file %s: %v\n", b.fmtPath, err)
+	}
+	defer f.Close()
+
+	fmt.Fprintf(f, "%s\r\n%d\r\n", bcpFormatVersion, len(cols))
+	for i, c := range cols {
+		// columns map to the table by position; the name is informational and
+		// must not contain whitespace
+		name := strings.Join(strings.Fields(c.Name), "_")
+		b.fields[i] = bcpFieldFor(c)
+		fmt.Fprintf(f, "%d\t%s\t%d\t%d\t\"\"\t%d\t%s\t\"\"\r\n", i+1, b.fields[i].hostType, b.fields[i].prefix, b.fields[i].length, i+1, name)
+	}
+	return f.Close()
+}
+
+func (b *bcpWriter) WriteRow(values []any) error {
+	for i, v := range values {
+		if err := b.writeField(b.cols[i], b.fields[i], v); err != nil {
+			return fmt.Errorf("column %s: %v", b.cols[i].Name, err)
+		}
+	}
+	return nil
+}
+
+// writeField writes the length prefix and value of one field; a prefix of
+// all one bits marks NULL.
+func (b *bcpWriter) writeField(c column, f bcpField, v any) error {
+	if v == nil {
+		return b.writePrefix(f.prefix, -1)
+	}
+	var data []byte
+	switch f.hostType {
+	case "SQLBIGINT":
+		n, err := asInt64(v)
+		if err != nil {
+			return err
+		}
+		data = binary.LittleEndian.AppendUint64(b.buf[:0], uint64(n))
+	case "SQLFLT8":
+		x, err := asFloat64(v)
+		if err != nil {
+			return err
+		}
+		data = binary.LittleEndian.AppendUint64(b.buf[:0], math.Float64bits(x))
+	case "SQLBIT":
+		t, err := asBool(v)
+		if err != nil {
+			return err
+		}
+		data = b.buf[:1]
+		data[0] = 0
+		if t {
+			data[0] = 1
+		}
+	case "SQLBINARY":
+		p, ok := v.([]byte)
+		if !ok {
+			p = []byte(formatValue(v))
+		}
+		data = p
+	default:
+		data = utf16LE(bcpText(c, v))
+	}
+	if err := b.writePrefix(f.prefix, len(data)); err != nil {
+		return err
+	}
+	_, err := b.w.Write(data)
+	return err
+}
+
+func (b *bcpWriter) writePrefix(size, n int) error {
+	var p []byte
+	switch size {
+	case 1:
+		p = []byte{byte(n)}
+	case 8:
+		p = binary.LittleEndian.AppendUint64(b.buf[:0], uint64(int64(n)))
+	}
+	_, err := b.w.Write(p)
+	return err
+}
+
+// bcpText renders a value stored as text in a form SQL Server converts losslessly.
+func bcpText(c column, v any) string {
+	t, ok := v.(time.Time)
+	if !ok {
+		return formatValue(v)
+	}
+	switch {
+	case c.kind() == kindDate:
+		return t.Format("2006-01-02")
+	case c.DBType == "DATETIMEOFFSET":
+		return t.Format("2006-01-02 15:04:05.9999999 -07:00")
+	default:
+		return t.Format("2006-01-02 15:04:05.9999999")
+	}
+}
+
+// utf16LE encodes s as the little endian UTF-16 bcp uses for SQLNCHAR.
+func utf16LE(s string) []byte {
+	units := utf16.Encode([]rune(s))
+	out := make([]byte, 2*len(units))
+	for i, u := range units {
+		binary.LittleEndian.PutUint16(out[2*i:], u)
+	}
+	return out
+}
+
+func (b *bcpWriter) Close() error {
+	return b.w.Flush()
+}
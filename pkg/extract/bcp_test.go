@@ -0,0 +1,106 @@
+package extract
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"unicode/utf16"
+)
+
+// readBCP decodes a native data file laid out by fields, returning each
+// field as bcp would load it: integers, floats and bits as Go values, text as
+// a string, binary as bytes and NULL as nil.
+func readBCP(t *testing.T, data []byte, fields []bcpField) [][]any {
+	t.Helper()
+	var rows [][]any
+	r := bytes.NewReader(data)
+	for r.Len() > 0 {
+		row := make([]any, len(fields))
+		for i, f := range fields {
+			var n int64
+			switch f.prefix {
+			case 1:
+				b, err := r.ReadByte()
+				if err != nil {
+					t.Fatal(err)
+				}
+				n = int64(int8(b))
+			case 8:
+				if err := binary.Read(r, binary.LittleEndian, &n); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if n < 0 {
+				continue
+			}
+			p := make([]byte, n)
+			if _, err := r.Read(p); err != nil && n > 0 {
+				t.Fatal(err)
+			}
+			switch f.hostType {
+			case "SQLBIGINT":
+				row[i] = int64(binary.LittleEndian.Uint64(p))
+			case "SQLFLT8":
+				row[i] = math.Float64frombits(binary.LittleEndian.Uint64(p))
+			case "SQLBIT":
+				row[i] = p[0] == 1
+			case "SQLBINARY":
+				row[i] = p
+			default:
+				units := make([]uint16, len(p)/2)
+				for j := range units {
+					units[j] = binary.LittleEndian.Uint16(p[2*j:])
+				}
+				row[i] = string(utf16.Decode(units))
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+func TestExportDataBCP(t *testing.T) {
+	registerFake("typed", &fakeQuery{sets: []*fakeResult{typedResult()}})
+	out := filepath.Join(t.TempDir(), "out.dat")
+	if _, err := exportFake(t, &config{Format: "bcp"}, "typed", out); err != nil {
+		t.Fatal(err)
+	}
+
+	format, err := os.ReadFile(out + ".fmt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantFormat := "10.0\r\n8\r\n" +
+		"1\tSQLBIGINT\t1\t8\t\"\"\t1\tid\t\"\"\r\n" +
+		"2\tSQLFLT8\t1\t8\t\"\"\t2\tprice\t\"\"\r\n" +
+		"3\tSQLNCHAR\t8\t0\t\"\"\t3\tamount\t\"\"\r\n" +
+		"4\tSQLBIT\t1\t1\t\"\"\t4\tactive\t\"\"\r\n" +
+		"5\tSQLNCHAR\t8\t0\t\"\"\t5\tday\t\"\"\r\n" +
+		"6\tSQLNCHAR\t8\t0\t\"\"\t6\tat\t\"\"\r\n" +
+		"7\tSQLNCHAR\t8\t0\t\"\"\t7\tname\t\"\"\r\n" +
+		"8\tSQLBINARY\t8\t0\t\"\"\t8\tdata\t\"\"\r\n"
+	if string(format) != wantFormat {
+		t.Errorf("format file:\n%q\nwant\n%q", format, wantFormat)
+	}
+
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fields := []bcpField{
+		{"SQLBIGINT", 1, 8}, {"SQLFLT8", 1, 8}, {"SQLNCHAR", 8, 0}, {"SQLBIT", 1, 1},
+		{"SQLNCHAR", 8, 0}, {"SQLNCHAR", 8, 0}, {"SQLNCHAR", 8, 0}, {"SQLBINARY", 8, 0},
+	}
+	rows := readBCP(t, data, fields)
+	want := [][]any{
+		{int64(42), 2.5, "12.34", true, "2026-10-15", "2026-10-15 09:30:15.123456", `Åsa "q"`, []byte{0, 1, 2, 255}},
+		make([]any, len(fields)),
+	}
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("got %v, want %v", rows, want)
+	}
+}
@@ -0,0 +1,201 @@
+package extract
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"path"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"cloud.google.com/go/storage"
+)
+
+// bigqueryOptions holds the settings for bigquery:// destinations.
+type bigqueryOptions struct {
+	// Staging is the gs://bucket/prefix location the extract is uploaded to
+	// before the load job reads it.
+	Staging string `yaml:"staging"`
+	// WriteDisposition is append (default), truncate or empty.
+	WriteDisposition string `yaml:"write_disposition"`
+	// KeepStaged leaves the staged file in GCS after a successful load.
+	KeepStaged bool `yaml:"keep_staged"`
+}
+
+// bigqueryWriter stages the result set to GCS as Parquet and loads it into a
+// BigQuery table once the upload completes.
+type bigqueryWriter struct {
+	*parquetWriter
+	opts    bigqueryOptions
+	project string
+	dataset string
+	table   string
+	bucket  string
+	object  string
+	ctx     context.Context
+	cancel  context.CancelFunc
+	gcs     *storage.Client
+	abort   string
+	done    bool
+}
+
+// splitGCSURI splits gs://bucket/path into its bucket and object path.
+func splitGCSURI(uri string) (string, string, error) {
+	rest, ok := strings.CutPrefix(uri, "gs://")
+	if !ok || rest == "" {
+		return "", "", fmt.Errorf("Invalid GCS location '%s'\n", uri)
+	}
+	bucket, object, _ := strings.Cut(rest, "/")
+	return bucket, object, nil
+}
+
+// splitBigQueryTarget splits project.dataset.table. The project may hold
+// dots itself, as domain-scoped projects such as example.com:sales do.
+func splitBigQueryTarget(target string) (string, string, string, error) {
+	dot := strings.LastIndex(target, ".")
+	if dot < 0 {
+		return "", "", "", fmt.Errorf("BigQuery destination '%s' must be project.dataset.table\n", target)
+	}
+	dot2 := strings.LastIndex(target[:dot], ".")
+	if dot2 < 0 {
+		return "", "", "", fmt.Errorf("BigQuery destination '%s' must be project.dataset.table\n", target)
+	}
+	return target[:dot2], target[dot2+1 : dot], target[dot+1:], nil
+}
+
+// newBigQueryWriter prepares a load into target, given as project.dataset.table.
+func newBigQueryWriter(target string, o bigqueryOptions, abort string) (*bigqueryWriter, error) {
+	project, dataset, table, err := splitBigQueryTarget(target)
+	if err != nil {
+		return nil, err
+	}
+
+	switch o.WriteDisposition {
+	case "", "append", "truncate", "empty":
+	default:
+		return nil, fmt.Errorf("Unsupported BigQuery write_disposition '%s'\n", o.WriteDisposition)
+	}
+	if o.Staging == "" {
+		return nil, fmt.Errorf("BigQuery destinations require bigquery.staging to be set\n")
+	}
+	bucket, prefix, err := splitGCSURI(o.Staging)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	gcs, err := storage.NewClient(ctx)
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("Could not create GCS client: %v\n", err)
+	}
+	object := path.Join(prefix, fmt.Sprintf("%s-%s.parquet", table, time.Now().UTC().Format("20060102T150405.000000000")))
+	sink := gcs.Bucket(bucket).Object(object).NewWriter(ctx)
+	sink.ContentType = "application/vnd.apache.parquet"
+	pw, _ := newParquetWriter(sink, "snappy")
+
+	return &bigqueryWriter{
+		parquetWriter: pw,
+		opts:          o,
+		project:       project,
+		dataset:       dataset,
+		table:         table,
+		bucket:        bucket,
+		object:        object,
+		ctx:           ctx,
+		cancel:        cancel,
+		gcs:           gcs,
+		abort:         abort,
+	}, nil
+}
+
+// Close completes the upload and runs the load job, waiting for it to finish.
+func (b *bigqueryWriter) Close() error {
+	b.done = true
+	defer b.cancel()
+	defer b.gcs.Close()
+	if err := b.parquetWriter.Close(); err != nil {
+		return fmt.Errorf("Could not stage extract to gs://%s/%s: %v\n", b.bucket, b.object, err)
+	}
+	loaded := false
+	defer func() {
+		if !loaded {
+			b.discardStaged()
+		}
+	}()
+
+	bq, err := bigquery.NewClient(b.ctx, b.project)
+	if err != nil {
+		return fmt.Errorf("Could not create BigQuery client: %v\n", err)
+	}
+	defer bq.Close()
+
+	dst := bq.Dataset(b.dataset).Table(b.table)
+	loader := dst.LoaderFrom(nil)
+	loader.LoadConfig = b.loadConfig(dst)
+
+	job, err := loader.Run(b.ctx)
+	if err != nil {
+		return fmt.Errorf("Could not start BigQuery load into %s.%s.%s: %v\n", b.project, b.dataset, b.table, err)
+	}
+	status, err := job.Wait(b.ctx)
+	if err == nil {
+		err = status.Err()
+	}
+	if err != nil {
+		return fmt.Errorf("BigQuery load job %s into %s.%s.%s failed: %v\n", job.ID(), b.project, b.dataset, b.table, err)
+	}
+	log.Printf("BigQuery load job %s completed for %s.%s.%s\n", job.ID(), b.project, b.dataset, b.table)
+	loaded = true
+
+	if !b.opts.KeepStaged {
+		if err := b.gcs.Bucket(b.bucket).Object(b.object).Delete(b.ctx); err != nil {
+			log.Printf("Could not delete staged file gs://%s/%s: %v\n", b.bucket, b.object, err)
+		}
+	}
+	return nil
+}
+
+// loadConfig loads the staged Parquet file into dst with the configured
+// write disposition.
+func (b *bigqueryWriter) loadConfig(dst *bigquery.Table) bigquery.LoadConfig {
+	ref := bigquery.NewGCSReference(fmt.Sprintf("gs://%s/%s", b.bucket, b.object))
+	ref.SourceFormat = bigquery.Parquet
+	cfg := bigquery.LoadConfig{Src: ref, Dst: dst}
+	switch b.opts.WriteDisposition {
+	case "truncate":
+		cfg.WriteDisposition = bigquery.WriteTruncate
+	case "empty":
+		cfg.WriteDisposition = bigquery.WriteEmpty
+	default:
+		cfg.WriteDisposition = bigquery.WriteAppend
+	}
+	return cfg
+}
+
+// discardStaged deletes or marks the staged object of a failed load.
+func (b *bigqueryWriter) discardStaged() {
+	obj := b.gcs.Bucket(b.bucket).Object(b.object)
+	var err error
+	switch b.abort {
+	case abortKeep:
+		return
+	case abortMark:
+		_, err = obj.Update(b.ctx, storage.ObjectAttrsToUpdate{Metadata: map[string]string{"incomplete": "true"}})
+	default:
+		err = obj.Delete(b.ctx)
+	}
+	if err != nil {
+		log.Printf("Warning: could not clean up staged file gs://%s/%s: %v\n", b.bucket, b.object, err)
+	}
+}
+
+// Abort cancels the upload so no partial object is created.
+func (b *bigqueryWriter) Abort() {
+	if !b.done {
+		b.done = true
+		b.cancel()
+		b.gcs.Close()
+	}
+}
@@ -0,0 +1,71 @@
+package extract
+
+import (
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/bigquery"
+)
+
+func TestSplitBigQueryTarget(t *testing.T) {
+	for _, tc := range []struct {
+		target                  string
+		project, dataset, table string
+	}{
+		{"acme-prod.sales.orders", "acme-prod", "sales", "orders"},
+		{"example.com:acme.sales.orders", "example.com:acme", "sales", "orders"},
+	} {
+		project, dataset, table, err := splitBigQueryTarget(tc.target)
+		if err != nil || project != tc.project || dataset != tc.dataset || table != tc.table {
+			t.Errorf("%s: got %s, %s, %s, %v", tc.target, project, dataset, table, err)
+		}
+	}
+	if _, _, _, err := splitBigQueryTarget("sales.orders"); err == nil {
+		t.Error("split a target without a project")
+	}
+}
+
+func TestBigQueryOptions(t *testing.T) {
+	for _, tc := range []struct {
+		o    bigqueryOptions
+		want string
+	}{
+		{bigqueryOptions{Staging: "gs://stage", WriteDisposition: "replace"}, "write_disposition"},
+		{bigqueryOptions{}, "bigquery.staging"},
+		{bigqueryOptions{Staging: "s3://stage"}, "Invalid GCS location"},
+	} {
+		if _, err := newBigQueryWriter("acme.sales.orders", tc.o, ""); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%+v: got %v, want %q", tc.o, err, tc.want)
+		}
+	}
+}
+
+func TestBigQueryLoadConfig(t *testing.T) {
+	for _, tc := range []struct {
+		disposition string
+		want        bigquery.TableWriteDisposition
+	}{
+		{"", bigquery.WriteAppend},
+		{"append", bigquery.WriteAppend},
+		{"truncate", bigquery.WriteTruncate},
+		{"empty", bigquery.WriteEmpty},
+	} {
+		b := &bigqueryWriter{opts: bigqueryOptions{WriteDisposition: tc.disposition}, bucket: "stage", object: "loads/orders-20261015T000000.000000000.parquet"}
+		dst := &bigquery.Table{ProjectID: "acme", DatasetID: "sales", TableID: "orders"}
+		cfg := b.loadConfig(dst)
+		ref, ok := cfg.Src.(*bigquery.GCSReference)
+		if !ok || len(ref.URIs) != 1 || ref.URIs[0] != "gs://stage/loads/orders-20261015T000000.000000000.parquet" || ref.SourceFormat != bigquery.Parquet {
+			t.Errorf("%q: source %+v", tc.disposition, cfg.Src)
+		}
+		if cfg.Dst != dst || cfg.WriteDisposition != tc.want {
+			t.Errorf("%q: loads into %v with %s, want %s", tc.disposition, cfg.Dst, cfg.WriteDisposition, tc.want)
+		}
+	}
+}
+
+func TestBigQueryReplayable(t *testing.T) {
+	// a failed load job leaves the table as it was, so the job runs again
+	if !(&config{}).replayable("bigquery://acme.sales.orders") {
+		t.Error("bigquery:// is not replayable")
+	}
+}
@@ -0,0 +1,195 @@
+package extract
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// businessDayOptions defines the days -serve counts as business days: every
+// day but the weekend and the holidays listed in a file, one 2006-01-02 date
+// per line, or returned by a query.
+type businessDayOptions struct {
+	Holidays      string   `yaml:"holidays"`
+	HolidaysQuery string   `yaml:"holidays_query"`
+	Weekend       []string `yaml:"weekend"`
+}
+
+func (o businessDayOptions) validate() error {
+	if o.Holidays != "" && o.HolidaysQuery != "" {
+		return fmt.Errorf("business_days takes either holidays or a holidays_query\n")
+	}
+	for _, day := range o.Weekend {
+		if _, ok := weekdays[strings.ToLower(day)]; !ok {
+			return fmt.Errorf("business_days weekend must be days of the week, got '%s'\n", day)
+		}
+	}
+	return nil
+}
+
+// businessCalendar tells business days from weekends and holidays.
+type businessCalendar struct {
+	weekend  map[time.Weekday]bool
+	holidays map[string]bool
+}
+
+func (cal businessCalendar) isBusinessDay(day time.Time) bool {
+	return !cal.weekend[day.Weekday()] && !cal.holidays[day.Format("2006-01-02")]
+}
+
+// loadBusinessCalendar reads the holidays of business_days. The weekend is
+// Saturday and Sunday unless set.
+func (c *config) loadBusinessCalendar(ctx context.Context) (businessCalendar, error) {
+	o := c.BusinessDays
+	cal := businessCalendar{weekend: map[time.Weekday]bool{}, holidays: map[string]bool{}}
+	if o.Weekend == nil {
+		cal.weekend[time.Saturday], cal.weekend[time.Sunday] = true, true
+	}
+	for _, day := range o.Weekend {
+		cal.weekend[weekdays[strings.ToLower(day)]] = true
+	}
+	switch {
+	case o.Holidays != "":
+		f, err := os.Open(o.Holidays)
+		if err != nil {
+			return cal, fmt.Errorf("Could not read the holidays: %v\n", err)
+		}
+		defer f.Close()
+		scanner := bufio.NewScanner(f)
+		for n := 1; scanner.Scan(); n++ {
+			line, _, _ := strings.Cut(scanner.Text(), "#")
+			if line = strings.TrimSpace(line); line == "" {
+				continue
+			}
+			day, err := time.Parse("2006-01-02", line)
+			if err != nil {
+				return cal, fmt.Errorf("%s:%d: a holiday must be a 2006-01-02 date, got '%s'\n", o.Holidays, n, line)
+			}
+			cal.holidays[day.Format("2006-01-02")] = true
+		}
+		if err := scanner.Err(); err != nil {
+			return cal, fmt.Errorf("Could not read the holidays: %v\n", err)
+		}
+	case o.HolidaysQuery != "":
+		db, err := sqlConnect(c)
+		if err != nil {
+			return cal, err
+		}
+		defer db.Close()
+		rows, err := db.QueryContext(ctx, o.HolidaysQuery)
+		if err != nil {
+			return cal, fmt.Errorf("Could not load the holidays: %v\n", err)
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var v any
+			if err := rows.Scan(&v); err != nil {
+				return cal, fmt.Errorf("Could not read the holidays, the query must return one date column: %v\n", err)
+			}
+			day, err := asDate(v)
+			if err != nil {
+				return cal, fmt.Errorf("Could not read the holidays: %v\n", err)
+			}
+			cal.holidays[day.Format("2006-01-02")] = true
+		}
+		if err := rows.Err(); err != nil {
+			return cal, fmt.Errorf("Could not load the holidays: %v\n", err)
+		}
+	}
+	return cal, nil
+}
+
+// runRule is the run_on of a job: every business day, or the nth business
+// day of the month, counted back from its end when nth is negative.
+type runRule struct {
+	nth int
+}
+
+var runRulePattern = regexp.MustCompile(`^(?:(\d+)(?:st|nd|rd|th) )?(last )?business day$`)
+
+// parseRunRule reads "business days", "3rd business day", "last business
+// day" or "2nd last business day".
+func parseRunRule(s string) (runRule, error) {
+	s = strings.ToLower(strings.Join(strings.Fields(s), " "))
+	if s == "business days" {
+		return runRule{}, nil
+	}
+	m := runRulePattern.FindStringSubmatch(s)
+	if m == nil || (m[1] == "" && m[2] == "") {
+		return runRule{}, fmt.Errorf("'%s' is not business days, the nth business day or the nth last business day", s)
+	}
+	n := 1
+	if m[1] != "" {
+		n, _ = strconv.Atoi(m[1])
+		if n < 1 || n > 23 {
+			return runRule{}, fmt.Errorf("a month has no business day %d", n)
+		}
+	}
+	if m[2] != "" {
+		n = -n
+	}
+	return runRule{nth: n}, nil
+}
+
+// matches reports whether the job runs on day.
+func (r runRule) matches(cal businessCalendar, day time.Time) bool {
+	if !cal.isBusinessDay(day) {
+		return false
+	}
+	if r.nth == 0 {
+		return true
+	}
+	// count the business days from day to the start or end of its month
+	n, step := 1, -1
+	if r.nth < 0 {
+		n, step = -1, 1
+	}
+	for d := day.AddDate(0, 0, step); d.Month() == day.Month(); d = d.AddDate(0, 0, step) {
+		if cal.isBusinessDay(d) {
+			n -= step
+		}
+	}
+	return n == r.nth
+}
+
+// jobsOn returns the outfiles of the jobs -serve runs on day: those without
+// a run_on, and those whose run_on matches.
+func (c *config) jobsOn(ctx context.Context, day time.Time) ([]string, error) {
+	if len(c.RunOn) == 0 {
+		return c.OutFiles, nil
+	}
+	cal, err := c.loadBusinessCalendar(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var outFiles []string
+	for _, outFile := range c.OutFiles {
+		rule, ok := c.RunOn[outFile]
+		if !ok {
+			outFiles = append(outFiles, outFile)
+			continue
+		}
+		r, _ := parseRunRule(rule)
+		if r.matches(cal, day) {
+			outFiles = append(outFiles, outFile)
+		}
+	}
+	return outFiles, nil
+}
+
+// scheduled returns the configuration of the run -serve starts at now, left
+// with the jobs that run on its day.
+func (c *config) scheduled(ctx context.Context, now time.Time) (*config, error) {
+	outFiles, err := c.jobsOn(ctx, now)
+	if err != nil || len(outFiles) == len(c.OutFiles) {
+		return c, err
+	}
+	run := *c
+	run.keepJobs(outFiles)
+	return &run, nil
+}
@@ -0,0 +1,60 @@
+package extract
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRunOn(t *testing.T) {
+	holidays := filepath.Join(t.TempDir(), "holidays.txt")
+	os.WriteFile(holidays, []byte("# bank holidays\n2025-05-05\n2025-05-26 # Spring bank holiday\n"), 0o644)
+	c := &config{
+		OutFiles:     []string{"daily.csv", "third.csv", "close.csv", "always.csv"},
+		Queries:      []string{"1", "2", "3", "4"},
+		BusinessDays: businessDayOptions{Holidays: holidays},
+		RunOn: map[string]string{
+			"daily.csv": "business days",
+			"third.csv": "3rd business day",
+			"close.csv": "last business day",
+		},
+	}
+	if err := c.validate(); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		day  string
+		want []string
+	}{
+		// May 2025 starts on a Thursday and the 5th is a holiday
+		{"2025-05-01", []string{"daily.csv", "always.csv"}},
+		{"2025-05-05", []string{"always.csv"}},
+		{"2025-05-06", []string{"daily.csv", "third.csv", "always.csv"}},
+		{"2025-05-10", []string{"always.csv"}},
+		{"2025-05-30", []string{"daily.csv", "close.csv", "always.csv"}},
+	} {
+		day, _ := time.Parse("2006-01-02", tc.day)
+		run, err := c.scheduled(context.Background(), day)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(run.OutFiles, tc.want) {
+			t.Errorf("%s: got %v, want %v", tc.day, run.OutFiles, tc.want)
+		}
+	}
+	if len(c.OutFiles) != 4 {
+		t.Errorf("scheduling changed the configuration: %v", c.OutFiles)
+	}
+
+	for _, bad := range []string{"business day", "3rd", "every day", "40th business day"} {
+		if _, err := parseRunRule(bad); err == nil {
+			t.Errorf("accepted %q", bad)
+		}
+	}
+	if r, err := parseRunRule("2nd  Last business day"); err != nil || r.nth != -2 {
+		t.Errorf("got %+v and %v, want the 2nd last", r, err)
+	}
+}
@@ -0,0 +1,121 @@
+package extract
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// -cache keeps each query's result for a while, so that reruns while working
+// on a job's output do not query the database again. Results are stored keyed
+// by the server, database and normalized query.
+
+// defaultCacheDir returns the directory -cache uses when -cache-dir is not set.
+func defaultCacheDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "sql-export-wiz")
+}
+
+// cacheKey names the cached result of a job's query with its arguments.
+func cacheKey(c *config, query, outFile string) string {
+	server, database := c.serverFor(c.JobTenants[outFile])
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%s\x00%s", server, database, normalizeSQL(wrapQuery(c, query)))
+	for _, arg := range c.jobArgs(outFile) {
+		if p, ok := arg.(sql.NamedArg); ok {
+			fmt.Fprintf(h, "\x00%s=%v", p.Name, p.Value)
+		} else {
+			fmt.Fprintf(h, "\x00%v", arg)
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// normalizeSQL drops comments and collapses whitespace outside of literals
+// and quoted identifiers, so reformatting a query keeps its cached result.
+func normalizeSQL(query string) string {
+	var b strings.Builder
+	r := []rune(query)
+	space := false
+	for i := 0; i < len(r); {
+		c := r[i]
+		switch {
+		case unicode.IsSpace(c):
+			space = true
+			i++
+			continue
+		case c == '-' && i+1 < len(r) && r[i+1] == '-':
+			for i < len(r) && r[i] != '\n' {
+				i++
+			}
+			space = true
+			continue
+		case c == '/' && i+1 < len(r) && r[i+1] == '*':
+			for depth := 0; i < len(r); {
+				if r[i] == '/' && i+1 < len(r) && r[i+1] == '*' {
+					depth, i = depth+1, i+2
+				} else if r[i] == '*' && i+1 < len(r) && r[i+1] == '/' {
+					depth, i = depth-1, i+2
+					if depth == 0 {
+						break
+					}
+				} else {
+					i++
+				}
+			}
+			space = true
+			continue
+		}
+		if space && b.Len() > 0 {
+			b.WriteByte(' ')
+		}
+		space = false
+		end := i + 1
+		switch c {
+		case '\'':
+			end = skipQuoted(r, i, '\'')
+		case '"':
+			end = skipQuoted(r, i, '"')
+		case '[':
+			end = skipQuoted(r, i, ']')
+		}
+		b.WriteString(string(r[i:end]))
+		i = end
+	}
+	return strings.TrimRight(b.String(), "; ")
+}
+
+// openCached returns the job's cached result if it is younger than the TTL,
+// or else runs the query and caches its result as it is read.
+func openCached(ctx context.Context, db *sql.DB, c *config, query, outFile string) (resultSet, error) {
+	path := filepath.Join(c.cacheDir, cacheKey(c, query, outFile))
+	if fi, err := os.Stat(path); err == nil && time.Since(fi.ModTime()) < c.cacheTTL {
+		res, err := readStoredResult(c.keys, path, fi.Size())
+		if err == nil {
+			log.Printf("Using the result of %s cached at %s\n", outFile, fi.ModTime().Format(time.DateTime))
+			return res, nil
+		}
+		log.Printf("Warning: ignoring the cached result of %s: %v\n", outFile, err)
+	}
+	live, err := queryResult(ctx, db, c, query, outFile)
+	if err != nil {
+		return nil, err
+	}
+	rec, err := newResultRecorder(c.keys, path, query, live)
+	if err != nil {
+		log.Printf("Warning: not caching the result of %s: %v\n", outFile, err)
+		return live, nil
+	}
+	return rec, nil
+}
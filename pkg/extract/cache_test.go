@@ -0,0 +1,58 @@
+package extract
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestNormalizeSQL(t *testing.T) {
+	a := normalizeSQL("SELECT  a,\n\tb -- the keys\nFROM /* nested /* comment */ */ dbo.T WHERE s = 'x  y';")
+	b := normalizeSQL("SELECT a, b FROM dbo.T WHERE s = 'x  y'")
+	if a != b {
+		t.Errorf("%q and %q differ", a, b)
+	}
+	if normalizeSQL("SELECT 'x  y'") == normalizeSQL("SELECT 'x y'") {
+		t.Error("whitespace inside a literal was collapsed")
+	}
+}
+
+func TestCachedExport(t *testing.T) {
+	registerFake("cached numbers", &fakeQuery{sets: []*fakeResult{numbersResult(5)}})
+	dir := t.TempDir()
+	outFile := filepath.Join(dir, "out.csv")
+	keys := testKeyring(t, "k1")
+	newConfig := func() *config {
+		return &config{Server: "db1", cacheTTL: time.Hour, cacheDir: filepath.Join(dir, "cache"), keys: keys}
+	}
+
+	first, err := exportFake(t, newConfig(), "cached numbers", outFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := exportFake(t, newConfig(), "cached numbers", outFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls := fakeCalls("cached numbers"); calls != 1 {
+		t.Errorf("the query ran %d times, want once", calls)
+	}
+	if first != second {
+		t.Errorf("the cached result differs:\n%s\nwant\n%s", second, first)
+	}
+
+	// an expired result is queried again
+	entries, _ := os.ReadDir(filepath.Join(dir, "cache"))
+	if len(entries) != 1 {
+		t.Fatalf("want one cache file, got %d", len(entries))
+	}
+	old := time.Now().Add(-2 * time.Hour)
+	os.Chtimes(filepath.Join(dir, "cache", entries[0].Name()), old, old)
+	if _, err := exportFake(t, newConfig(), "cached numbers", outFile); err != nil {
+		t.Fatal(err)
+	}
+	if calls := fakeCalls("cached numbers"); calls != 2 {
+		t.Errorf("the query ran %d times after the result expired, want twice", calls)
+	}
+}
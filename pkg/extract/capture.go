@@ -0,0 +1,220 @@
+package extract
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"slices"
+	"sync"
+)
+
+// captureOptions captures one value of a job for the query templates of the
+// jobs running after it: Column takes that column of the first row of its
+// result, and Query the first column of the first row of a query run with
+// the job's params once it succeeds.
+type captureOptions struct {
+	Column string `yaml:"column"`
+	Query  string `yaml:"query"`
+}
+
+// runVars holds the values the jobs of a run captured, by name.
+type runVars struct {
+	mu     sync.Mutex
+	values map[string]string
+}
+
+func (v *runVars) get(name string) (string, bool) {
+	if v == nil {
+		return "", false
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	value, ok := v.values[name]
+	return value, ok
+}
+
+func (v *runVars) set(name, value string) {
+	if v == nil {
+		return
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.values == nil {
+		v.values = map[string]string{}
+	}
+	v.values[name] = value
+}
+
+// extractNamed returns the extract with the given name or outfile.
+func (c *config) extractNamed(name string) (extract, bool) {
+	for _, e := range c.Extracts {
+		if e.Name == name || e.OutFile == name {
+			return e, true
+		}
+	}
+	return extract{}, false
+}
+
+func validateCaptures(c *config) error {
+	captured := map[string]string{}
+	for _, e := range c.Extracts {
+		for name, o := range e.Capture {
+			if !paramName.MatchString(name) {
+				return fmt.Errorf("Extract %s captures '%s', which is not a valid name\n", e.Name, name)
+			}
+			if name == "watermark" {
+				return fmt.Errorf("Extract %s cannot capture watermark, the parameter of incremental jobs\n", e.Name)
+			}
+			if (o.Column == "") == (o.Query == "") {
+				return fmt.Errorf("Extract %s capture %s needs either a column or a query\n", e.Name, name)
+			}
+			if other, ok := captured[name]; ok {
+				return fmt.Errorf("Extracts %s and %s both capture %s\n", other, e.Name, name)
+			}
+			captured[name] = e.Name
+		}
+
+		for _, name := range e.After {
+			dep, ok := c.extractNamed(name)
+			if !ok {
+				return fmt.Errorf("Extract %s runs after %s, which is not a job\n", e.Name, name)
+			}
+			if dep.OutFile == e.OutFile {
+				return fmt.Errorf("Extract %s cannot run after itself\n", e.Name)
+			}
+		}
+	}
+	// captured values are bound as parameters next to the params
+	for _, e := range c.Extracts {
+		for name := range e.Params {
+			if other, ok := captured[name]; ok {
+				return fmt.Errorf("Extract %s captures %s, which is also a param of %s\n", other, name, e.Name)
+			}
+		}
+	}
+	// a cycle of after would leave its jobs waiting for each other
+	visiting, visited := map[string]bool{}, map[string]bool{}
+	var visit func(e extract) error
+	visit = func(e extract) error {
+		if visited[e.OutFile] {
+			return nil
+		}
+		if visiting[e.OutFile] {
+			return fmt.Errorf("Extract %s runs after itself through after\n", e.Name)
+		}
+		visiting[e.OutFile] = true
+		for _, name := range c.waitsFor(e) {
+			dep, _ := c.extractNamed(name)
+			if err := visit(dep); err != nil {
+				return err
+			}
+		}
+		visited[e.OutFile] = true
+		return nil
+	}
+	for _, e := range c.Extracts {
+		if err := visit(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// hasCaptures reports whether any extract captures values.
+func (c *config) hasCaptures() bool {
+	for _, e := range c.Extracts {
+		if len(e.Capture) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// dependencies returns the outfiles of the jobs of this run that the job
+// writing outFile runs after. Jobs left out of the run are not waited for.
+func (c *config) dependencies(outFile string) []string {
+	e, ok := c.extract(outFile)
+	if !ok {
+		return nil
+	}
+	var deps []string
+	for _, name := range c.waitsFor(e) {
+		if dep, ok := c.extractNamed(name); ok && slices.Contains(c.OutFiles, dep.OutFile) {
+			deps = append(deps, dep.OutFile)
+		}
+	}
+	return deps
+}
+
+// jobCapture collects the captured columns of a job as its rows are written.
+type jobCapture struct {
+	names   []string
+	columns []int
+	values  map[string]any
+	seen    bool
+}
+
+// newJobCapture returns the capture of the columns of outFile's job, or nil
+// if it captures none. A captured column missing from cols is an error.
+func (c *config) newJobCapture(outFile string, cols []column) (*jobCapture, error) {
+	e, _ := c.extract(outFile)
+	var j *jobCapture
+	for name, o := range e.Capture {
+		if o.Column == "" {
+			continue
+		}
+		i := slices.IndexFunc(cols, func(col column) bool { return col.Name == o.Column })
+		if i < 0 {
+			return nil, fmt.Errorf("The result of %s has no column %s to capture %s from\n", outFile, o.Column, name)
+		}
+		if j == nil {
+			j = &jobCapture{values: map[string]any{}}
+		}
+		j.names, j.columns = append(j.names, name), append(j.columns, i)
+	}
+	return j, nil
+}
+
+// row captures the columns of the first row written.
+func (j *jobCapture) row(values []any) {
+	if j == nil || j.seen {
+		return
+	}
+	j.seen = true
+	for i, name := range j.names {
+		j.values[name] = values[j.columns[i]]
+	}
+}
+
+// saveCaptures runs the capture queries of the succeeded job writing outFile
+// and hands its captured values to the jobs running after it. NULLs, empty
+// results and capture queries in replayed runs capture nothing.
+func (c *config) saveCaptures(ctx context.Context, db *sql.DB, outFile string, j *jobCapture) error {
+	e, _ := c.extract(outFile)
+	if len(e.Capture) == 0 {
+		return nil
+	}
+	values := map[string]any{}
+	if j != nil {
+		values = j.values
+	}
+	for name, o := range e.Capture {
+		if o.Query == "" || c.replayDir != "" {
+			continue
+		}
+		v, _, err := queryScalar(ctx, db, o.Query, c.queryArgs(outFile))
+		if err != nil {
+			return fmt.Errorf("Unable to execute the capture query of %s '%s': %v\n", name, o.Query, err)
+		}
+		values[name] = v
+	}
+	for name, v := range values {
+		if v == nil {
+			log.Printf("%s captured no value for %s\n", outFile, name)
+			continue
+		}
+		c.vars.set(name, formatValue(v))
+	}
+	return nil
+}
@@ -0,0 +1,161 @@
+package extract
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestExportDataCapture(t *testing.T) {
+	registerFake("batches", &fakeQuery{sets: []*fakeResult{numbersResult(3)}})
+	registerFake("SELECT MAX(LoadDate) FROM etl.Batches", &fakeQuery{sets: []*fakeResult{{
+		columns: []fakeColumn{{name: "", dbType: "DATE", scanType: reflect.TypeOf("")}},
+		rows:    1,
+		value:   func(row, col int) driver.Value { return "2025-03-31" },
+	}}})
+	outFile := filepath.Join(t.TempDir(), "batches.csv")
+	c := &config{
+		Extracts: []extract{{Name: "batches", OutFile: outFile, Capture: map[string]captureOptions{
+			"batch_id":  {Column: "id"},
+			"load_date": {Query: "SELECT MAX(LoadDate) FROM etl.Batches"},
+		}}},
+		vars: &runVars{},
+	}
+	if _, err := exportFake(t, c, "batches", outFile); err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]string{"batch_id": "1", "load_date": "2025-03-31"}; !reflect.DeepEqual(c.vars.values, want) {
+		t.Errorf("captured %v, want %v", c.vars.values, want)
+	}
+
+	c.Extracts[0].Capture = map[string]captureOptions{"batch_id": {Column: "batch"}}
+	if _, err := exportFake(t, c, "batches", outFile); err == nil {
+		t.Error("captured a missing column")
+	}
+}
+
+func TestRunJobsAfter(t *testing.T) {
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	c := &config{
+		Extracts: []extract{
+			{Name: "load", OutFile: "load.csv", Capture: map[string]captureOptions{"batch_id": {Column: "id"}}},
+			{Name: "orders", OutFile: "orders.csv", After: []string{"load"}},
+			{Name: "broken", OutFile: "broken.csv"},
+			{Name: "lines", OutFile: "lines.csv", After: []string{"orders", "broken"}},
+		},
+		Queries:  []string{"load", `SELECT * FROM dbo.Orders WHERE BatchID = {{ .Var "batch_id" }}`, "broken", "lines"},
+		OutFiles: []string{"load.csv", "orders.csv", "broken.csv", "lines.csv"},
+	}
+	if err := validateCaptures(c); err != nil {
+		t.Fatal(err)
+	}
+	control := newController(c.OutFiles)
+	var ordersQuery string
+	dispatch := func(*ledger, *runReport) jobFunc {
+		return func(_ context.Context, _, query, outFile string) error {
+			switch outFile {
+			case "load.csv":
+				c.vars.set("batch_id", "42")
+			case "orders.csv":
+				ordersQuery = query
+			case "broken.csv":
+				return errors.New("login failed")
+			}
+			return nil
+		}
+	}
+	if err := runJobs(context.Background(), c, control, dispatch); err == nil {
+		t.Error("the run succeeded with a failed job")
+	}
+	if want := "SELECT * FROM dbo.Orders WHERE BatchID = @batch_id"; ordersQuery != want {
+		t.Errorf("orders ran %q, want %q", ordersQuery, want)
+	}
+	if got, want := c.jobArgs("orders.csv"), []any{sql.Named("batch_id", "42")}; !reflect.DeepEqual(got, want) {
+		t.Errorf("orders args %v, want %v", got, want)
+	}
+	if want := map[string]string{"load.csv": jobDone, "orders.csv": jobDone, "broken.csv": jobFailed, "lines.csv": jobSkipped}; !reflect.DeepEqual(control.states(), want) {
+		t.Errorf("states %v, want %v", control.states(), want)
+	}
+
+	for _, bad := range [][]extract{
+		{{Name: "a", OutFile: "a.csv", After: []string{"b"}}},
+		{{Name: "a", OutFile: "a.csv", After: []string{"a"}}},
+		{{Name: "a", OutFile: "a.csv", After: []string{"b"}}, {Name: "b", OutFile: "b.csv", After: []string{"a.csv"}}},
+		{{Name: "a", OutFile: "a.csv", Capture: map[string]captureOptions{"id": {}}}},
+		{{Name: "a", OutFile: "a.csv", Capture: map[string]captureOptions{"id": {Column: "id"}}}, {Name: "b", OutFile: "b.csv", Capture: map[string]captureOptions{"id": {Column: "id"}}}},
+		{{Name: "a", OutFile: "a.csv", Params: map[string]string{"id": "1"}}, {Name: "b", OutFile: "b.csv", Capture: map[string]captureOptions{"id": {Column: "id"}}}},
+	} {
+		if err := validateCaptures(&config{Extracts: bad}); err == nil {
+			t.Errorf("accepted %+v", bad)
+		}
+	}
+}
+
+func TestRenderQueryBindsVars(t *testing.T) {
+	c := &config{Driver: "postgres", vars: &runVars{}, bound: &boundArgs{args: map[string][]boundArg{}}}
+	c.vars.set("batch_id", "42' OR 1=1 --")
+	c.vars.set("region", "EMEA")
+	q, err := renderQuery(c, "orders.csv", `SELECT * FROM orders WHERE batch_id = {{ .Var "batch_id" }} AND region = {{ .Var "region" }} OR parent_batch = {{ .Var "batch_id" }}`)
+	if want := "SELECT * FROM orders WHERE batch_id = $1 AND region = $2 OR parent_batch = $1"; q != want || err != nil {
+		t.Errorf("rendered %q, %v, want %q", q, err, want)
+	}
+	if got, want := c.jobArgs("orders.csv"), []any{"42' OR 1=1 --", "EMEA"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("args %v, want %v", got, want)
+	}
+
+	c.Driver = "mysql"
+	q, _ = renderQuery(c, "orders.csv", `SELECT * FROM orders WHERE batch_id = {{ .Var "batch_id" }} OR parent_batch = {{ .Var "batch_id" }}`)
+	if want := "SELECT * FROM orders WHERE batch_id = ? OR parent_batch = ?"; q != want {
+		t.Errorf("rendered %q, want %q", q, want)
+	}
+	if got := c.jobArgs("orders.csv"); len(got) != 2 {
+		t.Errorf("args %v, want the value twice", got)
+	}
+
+	// outfiles get the value itself
+	c.paths = nil
+	c.OutFiles = []string{"{{ .Var \"region\" }}/orders.csv"}
+	if err := c.renderOutFiles(); err != nil {
+		t.Fatal(err)
+	}
+	if got := c.outputPath(c.OutFiles[0]); got != "EMEA/orders.csv" {
+		t.Errorf("outfile %s", got)
+	}
+}
+
+func TestCaptureFailureAfterDelivery(t *testing.T) {
+	main := &fakeQuery{sets: []*fakeResult{numbersResult(3)}}
+	registerFake("delivered", main)
+	registerFake("SELECT MAX(LoadDate) FROM etl.Flaky", &fakeQuery{err: errors.New("read tcp 10.0.0.5:50112: i/o timeout"), failures: 1})
+	outFile := filepath.Join(t.TempDir(), "delivered.csv")
+	c := &config{
+		Extracts: []extract{{Name: "delivered", OutFile: outFile, Capture: map[string]captureOptions{
+			"load_date": {Query: "SELECT MAX(LoadDate) FROM etl.Flaky"},
+		}}},
+		Retries:      2,
+		RetryBackoff: configDuration(time.Millisecond),
+		vars:         &runVars{},
+	}
+	// the file is in place when the capture query fails, so a retry would
+	// write it again
+	err := c.withRetries(context.Background(), newController([]string{outFile}), outFile, func() error {
+		_, err := exportFake(t, c, "delivered", outFile)
+		return err
+	})
+	var delivered *deliveredError
+	if !errors.As(err, &delivered) {
+		t.Errorf("got %v, want the capture failure reported as delivered", err)
+	}
+	if main.calls != 1 {
+		t.Errorf("the export ran %d times, want once", main.calls)
+	}
+}
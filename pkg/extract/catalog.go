@@ -0,0 +1,148 @@
+package extract
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// feedInfo describes a feed to its consumers in the catalog.
+type feedInfo struct {
+	Name        string `yaml:"name"`
+	Owner       string `yaml:"owner"`
+	Description string `yaml:"description"`
+}
+
+// catalogFeed is one feed in the catalog file.
+type catalogFeed struct {
+	Name        string          `json:"name" yaml:"name"`
+	Owner       string          `json:"owner,omitempty" yaml:"owner,omitempty"`
+	Description string          `json:"description,omitempty" yaml:"description,omitempty"`
+	Destination string          `json:"destination" yaml:"destination"`
+	Latest      string          `json:"latest,omitempty" yaml:"latest,omitempty"`
+	Format      string          `json:"format" yaml:"format"`
+	Schedule    string          `json:"schedule,omitempty" yaml:"schedule,omitempty"`
+	Tables      []string        `json:"source_tables,omitempty" yaml:"source_tables,omitempty"`
+	Schema      []catalogColumn `json:"schema,omitempty" yaml:"schema,omitempty"`
+	LastSuccess *time.Time      `json:"last_success,omitempty" yaml:"last_success,omitempty"`
+	LastRows    uint            `json:"last_rows" yaml:"last_rows"`
+}
+
+type catalogColumn struct {
+	Name     string `json:"name" yaml:"name"`
+	Type     string `json:"type" yaml:"type"`
+	Nullable bool   `json:"nullable" yaml:"nullable"`
+}
+
+// feedCatalog is the catalog file, written as YAML for .yaml and .yml paths
+// and as JSON otherwise.
+type feedCatalog struct {
+	Updated time.Time     `json:"updated" yaml:"updated"`
+	Feeds   []catalogFeed `json:"feeds" yaml:"feeds"`
+}
+
+func catalogIsYAML(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	return ext == ".yaml" || ext == ".yml"
+}
+
+// loadCatalog reads the catalog at path, returning an empty one if there is
+// none yet.
+func loadCatalog(path string) (*feedCatalog, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return &feedCatalog{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	cat := &feedCatalog{}
+	if catalogIsYAML(path) {
+		err = yaml.Unmarshal(data, cat)
+	} else {
+		err = json.Unmarshal(data, cat)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Could not parse catalog %s: %v\n", path, err)
+	}
+	return cat, nil
+}
+
+// updateCatalog rewrites the catalog with every feed of the configuration.
+// Feeds that succeeded in this run get their schema and freshness updated;
+// the others keep what the catalog last knew about them.
+func updateCatalog(c *config, control *controller, r *runReport) error {
+	if c.Catalog == "" {
+		return nil
+	}
+	old, err := loadCatalog(c.Catalog)
+	if err != nil {
+		return err
+	}
+	previous := map[string]catalogFeed{}
+	for _, f := range old.Feeds {
+		previous[f.Destination] = f
+	}
+
+	states := control.states()
+	now := time.Now().UTC()
+	cat := &feedCatalog{Updated: now}
+	for i, outFile := range c.OutFiles {
+		feed := previous[outFile]
+		info := c.Feeds[outFile]
+		feed.Name = info.Name
+		if e, ok := c.extract(outFile); ok && feed.Name == "" {
+			feed.Name = e.Name
+		}
+		if feed.Name == "" {
+			feed.Name = strings.TrimSuffix(filepath.Base(outFile), filepath.Ext(outFile))
+		}
+		feed.Owner = c.owner(outFile)
+		feed.Description = info.Description
+		feed.Destination = c.outputPath(outFile)
+		feed.Latest = c.Latest[outFile]
+		feed.Format = c.format(outFile)
+		if feed.Format == "" {
+			feed.Format = "csv"
+		}
+		feed.Schedule = c.schedule
+		if e, ok := c.extract(outFile); ok && feed.Schedule == "" {
+			feed.Schedule = e.Schedule
+		}
+		feed.Tables = referencedTables(c.Queries[i])
+		if states[outFile] == jobDone {
+			feed.Schema = nil
+			for _, col := range r.schemaFor(outFile) {
+				feed.Schema = append(feed.Schema, catalogColumn{Name: col.Name, Type: col.DBType, Nullable: col.Nullable})
+			}
+			feed.LastSuccess = &now
+			feed.LastRows = r.rowsFor(outFile)
+		}
+		cat.Feeds = append(cat.Feeds, feed)
+	}
+	sort.Slice(cat.Feeds, func(i, j int) bool { return cat.Feeds[i].Destination < cat.Feeds[j].Destination })
+
+	var data []byte
+	if catalogIsYAML(c.Catalog) {
+		data, err = yaml.Marshal(cat)
+	} else {
+		data, err = json.MarshalIndent(cat, "", "  ")
+	}
+	if err != nil {
+		return err
+	}
+	tmp := c.Catalog + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("Could not write catalog %s: %v\n", c.Catalog, err)
+	}
+	if err := os.Rename(tmp, c.Catalog); err != nil {
+		return fmt.Errorf("Could not write catalog %s: %v\n", c.Catalog, err)
+	}
+	return nil
+}
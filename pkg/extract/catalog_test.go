@@ -0,0 +1,51 @@
+package extract
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestUpdateCatalog(t *testing.T) {
+	for _, name := range []string{"catalog.json", "catalog.yaml"} {
+		path := filepath.Join(t.TempDir(), name)
+		c := &config{
+			Catalog:  path,
+			Queries:  []string{"SELECT id FROM dbo.Orders", "SELECT id FROM dbo.Lines"},
+			OutFiles: []string{"orders.csv", "lines.csv"},
+			Feeds:    map[string]feedInfo{"orders.csv": {Name: "orders", Owner: "finance"}},
+		}
+		run := func(done, failed string) {
+			control := newController(c.OutFiles)
+			report := newRunReport("")
+			for outFile, err := range map[string]error{done: nil, failed: errors.New("boom")} {
+				control.start(t.Context(), outFile)
+				if err == nil {
+					report.schema(outFile, []column{{Name: "id", DBType: "BIGINT"}})
+					report.job(outFile, report.Started, 5, 0)
+				}
+				control.finish(outFile, err)
+			}
+			if err := updateCatalog(c, control, report); err != nil {
+				t.Fatal(err)
+			}
+		}
+		run("orders.csv", "lines.csv")
+		run("lines.csv", "orders.csv")
+
+		cat, err := loadCatalog(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(cat.Feeds) != 2 {
+			t.Fatalf("%s: got %d feeds", name, len(cat.Feeds))
+		}
+		lines, orders := cat.Feeds[0], cat.Feeds[1]
+		if orders.Name != "orders" || orders.Owner != "finance" || orders.LastSuccess == nil || len(orders.Schema) != 1 {
+			t.Errorf("%s: the failed run lost what the catalog knew about orders: %+v", name, orders)
+		}
+		if lines.Name != "lines" || lines.LastRows != 5 || len(lines.Tables) != 1 || lines.Tables[0] != "dbo.Lines" {
+			t.Errorf("%s: lines = %+v", name, lines)
+		}
+	}
+}
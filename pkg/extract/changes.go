@@ -0,0 +1,314 @@
+package extract
+
+import (
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"log"
+	"math"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+func init() {
+	// key values are stored as interfaces, so every scanned type must be known
+	gob.Register(time.Time{})
+}
+
+// Values of the change column.
+const (
+	changeInsert = "I"
+	changeUpdate = "U"
+	changeDelete = "D"
+)
+
+// changeOptions publishes only the rows that changed since the last full run.
+// Rows are matched on Key and compared by a hash of their other columns, held
+// in the Snapshot between runs: a local path, s3://bucket/key or
+// gs://bucket/object.
+type changeOptions struct {
+	Key      []string `yaml:"key"`
+	Snapshot string   `yaml:"snapshot"`
+	// MaxKeys fails the job rather than let the snapshot grow past this many
+	// keys; it needs about 32 bytes of memory per key while the job runs.
+	MaxKeys int `yaml:"max_keys"`
+	// Column receives I, U or D, change_type by default.
+	Column string `yaml:"column"`
+	// Ignore lists columns left out of the comparison, e.g. load timestamps.
+	Ignore []string `yaml:"ignore"`
+	// Deletes adds a D row, with only the key set, for each key no longer in
+	// the result. It defaults to true.
+	Deletes *bool `yaml:"deletes"`
+}
+
+func (o changeOptions) validate(outFile string) error {
+	if len(o.Key) == 0 {
+		return fmt.Errorf("Change publication for %s needs at least one key column\n", outFile)
+	}
+	if o.Snapshot == "" {
+		return fmt.Errorf("Change publication for %s needs a snapshot path\n", outFile)
+	}
+	if o.MaxKeys < 0 {
+		return fmt.Errorf("Change publication for %s: max_keys cannot be negative\n", outFile)
+	}
+	return nil
+}
+
+// changeTransform drops unchanged rows and marks the rest with their change.
+// It has to be the last stage, since the delete rows it adds at the end skip
+// the stages before it.
+type changeTransform struct {
+	opts changeOptions
+	// partial runs (limited, sampled or dry) compare but neither report deletes
+	// nor replace the snapshot.
+	partial bool
+
+	cols    []column
+	key     []int
+	compare []int
+
+	keys    *keyring
+	store   snapshotStore
+	current stateSource
+	release func()
+	old     snapshotHashes
+	seen    []bool
+
+	next   *os.File
+	sealer io.WriteCloser
+	writer *snapshotWriter
+}
+
+func newChangeTransform(o changeOptions, partial bool, keys *keyring) *changeTransform {
+	if o.Column == "" {
+		o.Column = "change_type"
+	}
+	return &changeTransform{opts: o, partial: partial, keys: keys}
+}
+
+func (t *changeTransform) Setup(cols []column) ([]column, error) {
+	index := make(map[string]int, len(cols))
+	for i, c := range cols {
+		index[strings.ToLower(c.Name)] = i
+	}
+	for _, name := range t.opts.Key {
+		i, ok := index[strings.ToLower(name)]
+		if !ok {
+			return nil, fmt.Errorf("Change key column %s is not in the result\n", name)
+		}
+		t.key = append(t.key, i)
+	}
+	skip := map[int]bool{}
+	for _, i := range t.key {
+		skip[i] = true
+	}
+	for _, name := range t.opts.Ignore {
+		if i, ok := index[strings.ToLower(name)]; ok {
+			skip[i] = true
+		}
+	}
+	for i := range cols {
+		if !skip[i] {
+			t.compare = append(t.compare, i)
+		}
+	}
+	t.cols = cols
+
+	store, err := openSnapshotStore(t.opts.Snapshot)
+	if err != nil {
+		return nil, err
+	}
+	t.store = store
+	current, release, err := store.fetch()
+	if err != nil {
+		return nil, err
+	}
+	t.release = release
+	if current != nil {
+		fi, err := current.Stat()
+		if err != nil {
+			return nil, err
+		}
+		if t.current, err = t.keys.openState(current, fi.Size(), t.opts.Snapshot); err != nil {
+			var plain *plaintextStateError
+			if errors.As(err, &plain) {
+				return nil, err
+			}
+			return nil, fmt.Errorf("Could not read snapshot: %v\n", err)
+		}
+		if t.old, err = readSnapshotIndex(t.current); err != nil {
+			return nil, err
+		}
+		if t.opts.MaxKeys > 0 && len(t.old) > t.opts.MaxKeys {
+			return nil, fmt.Errorf("Snapshot %s holds %d keys, more than max_keys (%d)\n", t.opts.Snapshot, len(t.old), t.opts.MaxKeys)
+		}
+	}
+	t.seen = make([]bool, len(t.old))
+
+	if !t.partial {
+		if t.next, err = store.create(); err != nil {
+			return nil, err
+		}
+		if t.sealer, err = t.keys.seal(t.next); err != nil {
+			return nil, err
+		}
+		t.writer = newSnapshotWriter(t.sealer, t.opts.MaxKeys)
+	}
+
+	out := slices.Clone(cols)
+	if t.deletes() {
+		// delete rows only carry their key
+		for i := range out {
+			if !slices.Contains(t.key, i) {
+				out[i].Nullable = true
+			}
+		}
+	}
+	return append(out, column{Name: t.opts.Column, DBType: "NVARCHAR"}), nil
+}
+
+func (t *changeTransform) Apply(row []any) ([]any, bool, error) {
+	keyHash, rowHash := hashValues(row, t.key), hashValues(row, t.compare)
+	if t.writer != nil {
+		key := make([]any, len(t.key))
+		for i, k := range t.key {
+			key[i] = row[k]
+		}
+		if err := t.writer.add(keyHash, rowHash, key); err != nil {
+			return nil, false, err
+		}
+	}
+
+	change := changeInsert
+	if i, ok := t.old.find(keyHash); ok {
+		t.seen[i] = true
+		if t.old[i].row == rowHash {
+			return nil, false, nil
+		}
+		change = changeUpdate
+	}
+	return append(row, change), true, nil
+}
+
+func (t *changeTransform) deletes() bool {
+	return !t.partial && (t.opts.Deletes == nil || *t.opts.Deletes)
+}
+
+// Finish returns a delete row for every snapshot key the result no longer has.
+func (t *changeTransform) Finish() ([][]any, error) {
+	if !t.deletes() || t.current == nil {
+		return nil, nil
+	}
+	var deletes [][]any
+	err := readSnapshotKeys(t.current, func(k snapshotKey) {
+		i, ok := t.old.find(k.KeyHash)
+		if !ok || t.seen[i] {
+			return
+		}
+		// a key is deleted once, however often it was in the snapshot
+		t.seen[i] = true
+		row := make([]any, len(t.cols)+1)
+		for j, c := range t.key {
+			if j < len(k.Key) {
+				row[c] = k.Key[j]
+			}
+		}
+		row[len(t.cols)] = changeDelete
+		deletes = append(deletes, row)
+	})
+	return deletes, err
+}
+
+// Commit replaces the snapshot with the one written during this run.
+func (t *changeTransform) Commit() error {
+	t.close()
+	defer t.closeStore()
+	if t.next == nil {
+		return nil
+	}
+	next := t.next
+	t.next = nil
+	if err := t.writer.finish(); err != nil {
+		t.store.discard(next)
+		return fmt.Errorf("Could not write snapshot %s: %v\n", t.opts.Snapshot, err)
+	}
+	if err := t.sealer.Close(); err != nil {
+		t.store.discard(next)
+		return fmt.Errorf("Could not write snapshot %s: %v\n", t.opts.Snapshot, err)
+	}
+	return t.store.commit(next)
+}
+
+// Discard drops the snapshot written during a failed run, keeping the last one.
+func (t *changeTransform) Discard() {
+	t.close()
+	if t.next != nil {
+		t.store.discard(t.next)
+		t.next = nil
+	}
+	t.closeStore()
+}
+
+// close releases the current snapshot.
+func (t *changeTransform) close() {
+	if t.release != nil {
+		t.release()
+		t.release = nil
+	}
+}
+
+// closeStore releases the snapshot store once the run is done with it.
+func (t *changeTransform) closeStore() {
+	if t.store != nil {
+		if err := t.store.Close(); err != nil {
+			log.Printf("Warning: could not close snapshot store %s: %v\n", t.opts.Snapshot, err)
+		}
+		t.store = nil
+	}
+}
+
+// hashValues hashes the values at idx with their types, so 1 and "1" differ.
+func hashValues(row []any, idx []int) uint64 {
+	h := fnv.New64a()
+	var buf [8]byte
+	for _, i := range idx {
+		switch v := row[i].(type) {
+		case nil:
+			h.Write([]byte{0})
+		case int64:
+			h.Write([]byte{1})
+			binary.LittleEndian.PutUint64(buf[:], uint64(v))
+			h.Write(buf[:])
+		case float64:
+			h.Write([]byte{2})
+			binary.LittleEndian.PutUint64(buf[:], math.Float64bits(v))
+			h.Write(buf[:])
+		case string:
+			h.Write([]byte{3})
+			binary.LittleEndian.PutUint64(buf[:], uint64(len(v)))
+			h.Write(buf[:])
+			h.Write([]byte(v))
+		case []byte:
+			h.Write([]byte{4})
+			binary.LittleEndian.PutUint64(buf[:], uint64(len(v)))
+			h.Write(buf[:])
+			h.Write(v)
+		case time.Time:
+			h.Write([]byte{5})
+			b, _ := v.MarshalBinary()
+			h.Write(b)
+		default:
+			s := fmt.Sprintf("%T:%v", v, v)
+			h.Write([]byte{6})
+			binary.LittleEndian.PutUint64(buf[:], uint64(len(s)))
+			h.Write(buf[:])
+			h.Write([]byte(s))
+		}
+	}
+	return h.Sum64()
+}
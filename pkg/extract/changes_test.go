@@ -0,0 +1,120 @@
+package extract
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPublishChanges(t *testing.T) {
+	dir := t.TempDir()
+	c := &config{Changes: map[string]changeOptions{}}
+	run := func(name string, res *fakeResult) string {
+		outFile := filepath.Join(dir, name+".csv")
+		c.Changes[outFile] = changeOptions{Key: []string{"id"}, Snapshot: filepath.Join(dir, "snapshot")}
+		registerFake(name, &fakeQuery{sets: []*fakeResult{res}})
+		got, err := exportFake(t, c, name, outFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return got
+	}
+
+	if got := run("first", numbersResult(3)); strings.Count(got, ",I\n") != 3 {
+		t.Fatalf("the first run should insert every row, got %q", got)
+	}
+
+	// row 2 changes, row 3 goes away and row 4 is new
+	second := numbersResult(3)
+	second.value = func(row, col int) driver.Value {
+		ids, names := []int64{1, 2, 4}, []string{"name 1", "renamed", "name 4"}
+		if col == 0 {
+			return ids[row]
+		}
+		return names[row]
+	}
+	got := run("second", second)
+	want := "id,name,change_type\n2,renamed,U\n4,name 4,I\n3,,D\n"
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	if got := run("third", second); got != "id,name,change_type\n" {
+		t.Errorf("an unchanged result published %q", got)
+	}
+}
+
+func TestPublishChangesEncrypted(t *testing.T) {
+	dir := t.TempDir()
+	snapshot := filepath.Join(dir, "snapshot")
+	c := &config{Changes: map[string]changeOptions{}, keys: testKeyring(t, "k1")}
+	for i, want := range []int{3, 0} {
+		outFile := filepath.Join(dir, fmt.Sprintf("run%d.csv", i))
+		c.Changes[outFile] = changeOptions{Key: []string{"id"}, Snapshot: snapshot}
+		registerFake("encrypted", &fakeQuery{sets: []*fakeResult{numbersResult(3)}})
+		got, err := exportFake(t, c, "encrypted", outFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n := strings.Count(got, ",I\n"); n != want {
+			t.Errorf("run %d inserted %d rows, want %d", i, n, want)
+		}
+	}
+	data, _ := os.ReadFile(snapshot)
+	if !strings.HasPrefix(string(data), stateMagic) {
+		t.Error("the snapshot was written in plain text")
+	}
+}
+
+// closeCounter counts how often a snapshot store is closed.
+type closeCounter struct {
+	snapshotStore
+	closed int
+}
+
+func (s *closeCounter) Close() error {
+	s.closed++
+	return s.snapshotStore.Close()
+}
+
+func TestChangesCloseSnapshotStore(t *testing.T) {
+	dir := t.TempDir()
+	o := changeOptions{Key: []string{"id"}, Snapshot: filepath.Join(dir, "snapshot")}
+	cols := []column{{Name: "id", DBType: "BIGINT"}, {Name: "name", DBType: "NVARCHAR"}}
+	setup := func() (*changeTransform, *closeCounter) {
+		tr := newChangeTransform(o, false, nil)
+		if _, err := tr.Setup(cols); err != nil {
+			t.Fatal(err)
+		}
+		store := &closeCounter{snapshotStore: tr.store}
+		tr.store = store
+		return tr, store
+	}
+
+	// the job discards its stages after committing them too
+	tr, store := setup()
+	tr.Apply([]any{int64(1), "a"})
+	if _, err := tr.Finish(); err != nil {
+		t.Fatal(err)
+	}
+	if err := tr.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	tr.Discard()
+	if store.closed != 1 {
+		t.Errorf("committed run closed the store %d times", store.closed)
+	}
+
+	tr, store = setup()
+	tr.Discard()
+	tr.Discard()
+	if store.closed != 1 {
+		t.Errorf("failed run closed the store %d times", store.closed)
+	}
+	if _, err := os.Stat(o.Snapshot + ".new"); !os.IsNotExist(err) {
+		t.Errorf("failed run left its snapshot: %v", err)
+	}
+}
@@ -0,0 +1,159 @@
+package extract
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sessionCPUQuery reads the CPU time, in milliseconds, that the current SQL
+// Server session has used. A session may always see its own row.
+const sessionCPUQuery = "SELECT cpu_time FROM sys.dm_exec_sessions WHERE session_id = @@SPID"
+
+// cpuResult is a query result on a connection of its own, so that the CPU
+// time of its session is the query's.
+type cpuResult struct {
+	sqlResult
+	conn   *sql.Conn
+	before int64
+}
+
+// queryCPUMeter is implemented by results that measure the server's CPU time.
+type queryCPUMeter interface {
+	queryCPU() (time.Duration, bool)
+}
+
+// queryWithCPU runs query with args on its own connection, reading the
+// session's CPU time first. Without access to the DMV it runs the query as
+// usual.
+func queryWithCPU(ctx context.Context, db *sql.DB, query string, args ...any) (resultSet, error) {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var before int64
+	if err := conn.QueryRowContext(ctx, sessionCPUQuery).Scan(&before); err != nil {
+		conn.Close()
+		rows, err := db.QueryContext(ctx, query, args...)
+		if err != nil {
+			return nil, err
+		}
+		return sqlResult{rows}, nil
+	}
+	rows, err := conn.QueryContext(ctx, query, args...)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &cpuResult{sqlResult: sqlResult{rows}, conn: conn, before: before}, nil
+}
+
+// queryCPU ends the result and returns the CPU time the server spent on it.
+func (r *cpuResult) queryCPU() (time.Duration, bool) {
+	r.Rows.Close()
+	var after int64
+	if err := r.conn.QueryRowContext(context.Background(), sessionCPUQuery).Scan(&after); err != nil {
+		return 0, false
+	}
+	return time.Duration(after-r.before) * time.Millisecond, true
+}
+
+func (r *cpuResult) Close() error {
+	err := r.Rows.Close()
+	r.conn.Close()
+	return err
+}
+
+// chargebackRecord is the accounting of one job, for attributing the cost of
+// a run to the team owning each feed.
+type chargebackRecord struct {
+	Run             time.Time `json:"run"`
+	Job             string    `json:"job"`
+	OutFile         string    `json:"outfile"`
+	Team            string    `json:"team"`
+	Status          string    `json:"status"`
+	Rows            uint      `json:"rows"`
+	BytesWritten    int64     `json:"bytes_written"`
+	WallSeconds     float64   `json:"wall_seconds"`
+	QueryCPUSeconds *float64  `json:"query_cpu_seconds"`
+}
+
+var chargebackHeader = []string{"run", "job", "outfile", "team", "status", "rows", "bytes_written", "wall_seconds", "query_cpu_seconds"}
+
+// owner returns the team owning the feed of outFile: its feed owner, or else
+// its tenant.
+func (c *config) owner(outFile string) string {
+	if owner := c.Feeds[outFile].Owner; owner != "" {
+		return owner
+	}
+	return c.JobTenants[outFile]
+}
+
+// chargebackRecords returns the accounting of every job of the run.
+func chargebackRecords(c *config, control *controller, r *runReport) []chargebackRecord {
+	states := control.states()
+	records := make([]chargebackRecord, 0, len(c.OutFiles))
+	for _, outFile := range c.OutFiles {
+		rec := chargebackRecord{Run: c.started, Job: c.jobName(outFile), OutFile: outFile, Team: c.owner(outFile), Status: states[outFile]}
+		if u, ok := r.usageFor(outFile); ok {
+			rec.Rows, rec.BytesWritten, rec.WallSeconds = u.Rows, u.BytesWritten, u.Seconds
+			rec.QueryCPUSeconds = u.QueryCPUSeconds
+		}
+		records = append(records, rec)
+	}
+	return records
+}
+
+// writeChargeback appends the accounting of the run to the chargeback file,
+// as CSV, or as JSON lines for .json and .jsonl paths.
+func writeChargeback(c *config, control *controller, r *runReport) error {
+	if c.Chargeback == "" {
+		return nil
+	}
+	records := chargebackRecords(c, control, r)
+	fi, statErr := os.Stat(c.Chargeback)
+	f, err := os.OpenFile(c.Chargeback, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("Could not open chargeback file %s: %v\n", c.Chargeback, err)
+	}
+	defer f.Close()
+
+	switch strings.ToLower(filepath.Ext(c.Chargeback)) {
+	case ".json", ".jsonl":
+		enc := json.NewEncoder(f)
+		for _, rec := range records {
+			if err := enc.Encode(rec); err != nil {
+				return fmt.Errorf("Could not write chargeback file %s: %v\n", c.Chargeback, err)
+			}
+		}
+	default:
+		w := csv.NewWriter(f)
+		if statErr != nil || fi.Size() == 0 {
+			w.Write(chargebackHeader)
+		}
+		for _, rec := range records {
+			cpu := ""
+			if rec.QueryCPUSeconds != nil {
+				cpu = strconv.FormatFloat(*rec.QueryCPUSeconds, 'f', 3, 64)
+			}
+			w.Write([]string{rec.Run.UTC().Format(time.RFC3339), rec.Job, rec.OutFile, rec.Team, rec.Status,
+				strconv.FormatUint(uint64(rec.Rows), 10), strconv.FormatInt(rec.BytesWritten, 10),
+				strconv.FormatFloat(rec.WallSeconds, 'f', 3, 64), cpu})
+		}
+		w.Flush()
+		if err := w.Error(); err != nil {
+			return fmt.Errorf("Could not write chargeback file %s: %v\n", c.Chargeback, err)
+		}
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("Could not write chargeback file %s: %v\n", c.Chargeback, err)
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package extract
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestQueryCPU(t *testing.T) {
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	registerFake("numbers", &fakeQuery{sets: []*fakeResult{numbersResult(3)}})
+	cpu := int64(1000)
+	registerFakeHandler(t, func(query string) (*fakeResult, bool) {
+		if query != sessionCPUQuery {
+			return nil, false
+		}
+		cpu += 250
+		return &fakeResult{
+			columns: []fakeColumn{{name: "cpu_time", dbType: "INT", scanType: reflect.TypeOf(int64(0))}},
+			rows:    1,
+			value:   func(int, int) driver.Value { return cpu },
+		}, true
+	})
+	db, err := sql.Open("fakedb", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	c := &config{Delimiter: ",", Chargeback: filepath.Join(t.TempDir(), "chargeback.csv")}
+	c.dialect, _ = dialectFor("sqlserver")
+	r := newRunReport("")
+	outFile := filepath.Join(t.TempDir(), "out.csv")
+	if err := exportData(context.Background(), db, c, nil, r, nil, "numbers", outFile); err != nil {
+		t.Fatal(err)
+	}
+	u, _ := r.usageFor(outFile)
+	if u.QueryCPUSeconds == nil || *u.QueryCPUSeconds != 0.25 {
+		t.Errorf("query CPU %v, want 0.25s", u.QueryCPUSeconds)
+	}
+}
+
+func TestWriteChargeback(t *testing.T) {
+	dir := t.TempDir()
+	c := &config{
+		Extracts:   []extract{{Name: "orders", OutFile: "orders.csv"}},
+		OutFiles:   []string{"orders.csv", "refunds.csv"},
+		Feeds:      map[string]feedInfo{"orders.csv": {Owner: "sales"}},
+		JobTenants: map[string]string{"refunds.csv": "finance"},
+		started:    time.Date(2026, 3, 4, 1, 0, 0, 0, time.UTC),
+	}
+	control := newController(c.OutFiles)
+	control.start(context.Background(), "orders.csv")
+	control.finish("orders.csv", nil)
+	r := newRunReport("")
+	r.add(jobUsage{OutFile: "orders.csv", Rows: 10, BytesWritten: 200, Seconds: 1.5})
+	r.jobCPU("orders.csv", 750*time.Millisecond)
+
+	c.Chargeback = filepath.Join(dir, "chargeback.csv")
+	for range 2 {
+		if err := writeChargeback(c, control, r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	data, _ := os.ReadFile(c.Chargeback)
+	want := "run,job,outfile,team,status,rows,bytes_written,wall_seconds,query_cpu_seconds\n" +
+		strings.Repeat("2026-03-04T01:00:00Z,orders,orders.csv,sales,done,10,200,1.500,0.750\n"+
+			"2026-03-04T01:00:00Z,refunds.csv,refunds.csv,finance,queued,0,0,0.000,\n", 2)
+	if string(data) != want {
+		t.Errorf("got %q, want %q", data, want)
+	}
+
+	c.Chargeback = filepath.Join(dir, "chargeback.jsonl")
+	if err := writeChargeback(c, control, r); err != nil {
+		t.Fatal(err)
+	}
+	data, _ = os.ReadFile(c.Chargeback)
+	want = `{"run":"2026-03-04T01:00:00Z","job":"orders","outfile":"orders.csv","team":"sales","status":"done","rows":10,"bytes_written":200,"wall_seconds":1.5,"query_cpu_seconds":0.75}` + "\n" +
+		`{"run":"2026-03-04T01:00:00Z","job":"refunds.csv","outfile":"refunds.csv","team":"finance","status":"queued","rows":0,"bytes_written":0,"wall_seconds":0,"query_cpu_seconds":null}` + "\n"
+	if string(data) != want {
+		t.Errorf("got %s, want %s", data, want)
+	}
+}
@@ -0,0 +1,182 @@
+package extract
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// maskedValue replaces values of masked columns.
+const maskedValue = "****"
+
+// classificationOptions tags sensitive columns and sets what each destination
+// may receive.
+type classificationOptions struct {
+	// Columns maps column name globs (case-insensitive) to a class such as pii or secret.
+	Columns map[string]string `yaml:"columns"`
+	// Policies are matched against the outfile in order; the first match applies.
+	Policies []classificationPolicy `yaml:"policies"`
+	// Default maps classes to actions for destinations no policy matches.
+	// Classes without an action are denied.
+	Default map[string]string `yaml:"default"`
+	// HashKeyEnv names the environment variable holding the secret key of the
+	// hash action. Defaults to CLASSIFICATION_HASH_KEY.
+	HashKeyEnv string `yaml:"hash_key_env"`
+}
+
+// classificationPolicy maps classes to actions for destinations matching Destination.
+type classificationPolicy struct {
+	Destination string            `yaml:"destination"`
+	Actions     map[string]string `yaml:"actions"`
+}
+
+// validate checks that every action is known.
+func (o classificationOptions) validate() error {
+	check := func(actions map[string]string) error {
+		for class, action := range actions {
+			switch action {
+			case "allow", "mask", "hash", "drop", "deny":
+			default:
+				return fmt.Errorf("Unsupported classification action '%s' for class %s\n", action, class)
+			}
+		}
+		return nil
+	}
+	for _, p := range o.Policies {
+		if err := check(p.Actions); err != nil {
+			return err
+		}
+	}
+	return check(o.Default)
+}
+
+// classOf returns the class of a column name, or "" when it is unclassified.
+// The longest matching pattern wins so specific names override broad globs.
+func (o classificationOptions) classOf(name string) string {
+	patterns := make([]string, 0, len(o.Columns))
+	for p := range o.Columns {
+		patterns = append(patterns, p)
+	}
+	sort.Slice(patterns, func(i, j int) bool { return len(patterns[i]) > len(patterns[j]) })
+	for _, p := range patterns {
+		if globMatch(strings.ToLower(p), strings.ToLower(name)) {
+			return o.Columns[p]
+		}
+	}
+	return ""
+}
+
+// transform returns the policy enforcement stage for outFile, or nil when no
+// columns are classified.
+func (o classificationOptions) transform(outFile string) transform {
+	if len(o.Columns) == 0 {
+		return nil
+	}
+	actions := o.Default
+	for _, p := range o.Policies {
+		if globMatch(p.Destination, outFile) {
+			actions = p.Actions
+			break
+		}
+	}
+	return &classificationTransform{opts: o, actions: actions, outFile: outFile}
+}
+
+// classificationTransform applies a destination's policy to classified columns.
+type classificationTransform struct {
+	opts    classificationOptions
+	actions map[string]string
+	outFile string
+	keep    []int
+	mode    []string
+	hashKey []byte
+}
+
+// Setup resolves the action of every column, failing when the policy denies
+// one so the job stops before any data is written.
+func (t *classificationTransform) Setup(cols []column) ([]column, error) {
+	var out []column
+	var denied []string
+	for i, c := range cols {
+		class, action := t.classify(c)
+		switch action {
+		case "deny":
+			denied = append(denied, fmt.Sprintf("%s (%s)", c.Name, class))
+			continue
+		case "drop":
+			continue
+		case "mask", "hash":
+			// masked values are text regardless of the source type
+			c = column{Name: c.Name, DBType: "NVARCHAR", Nullable: c.Nullable}
+		}
+		t.keep = append(t.keep, i)
+		t.mode = append(t.mode, action)
+		out = append(out, c)
+	}
+	if len(denied) > 0 {
+		return nil, fmt.Errorf("Classification policy does not allow %s to receive %s\n", t.outFile, strings.Join(denied, ", "))
+	}
+	for _, mode := range t.mode {
+		if mode == "hash" {
+			env := t.opts.HashKeyEnv
+			if env == "" {
+				env = "CLASSIFICATION_HASH_KEY"
+			}
+			// an unkeyed digest of a low-entropy value such as an SSN is
+			// reversed by hashing every candidate
+			if t.hashKey = []byte(os.Getenv(env)); len(t.hashKey) == 0 {
+				return nil, fmt.Errorf("Classification hash key environment variable %s is not set\n", env)
+			}
+			break
+		}
+	}
+	return out, nil
+}
+
+// actionRank orders the actions from the least to the most strict. A hash
+// still tells equal values apart, so masking is stricter.
+var actionRank = map[string]int{"allow": 0, "hash": 1, "mask": 2, "drop": 3, "deny": 4}
+
+// classify returns the class of c and the action the destination takes on
+// it. A computed column takes the strictest of its own class and those of
+// the columns it is calculated from.
+func (t *classificationTransform) classify(c column) (string, string) {
+	class, action := "", "allow"
+	for _, name := range append([]string{c.Name}, c.Sources...) {
+		cl := t.opts.classOf(name)
+		if cl == "" {
+			continue
+		}
+		a := t.actions[cl]
+		if a == "" {
+			a = "deny"
+		}
+		if class == "" || actionRank[a] > actionRank[action] {
+			class, action = cl, a
+		}
+	}
+	return class, action
+}
+
+func (t *classificationTransform) Apply(row []any) ([]any, bool, error) {
+	out := make([]any, len(t.keep))
+	for i, idx := range t.keep {
+		v := row[idx]
+		if v != nil {
+			switch t.mode[i] {
+			case "mask":
+				v = maskedValue
+			case "hash":
+				mac := hmac.New(sha256.New, t.hashKey)
+				mac.Write([]byte(formatValue(v)))
+				v = hex.EncodeToString(mac.Sum(nil))
+			}
+		}
+		out[i] = v
+	}
+	return out, true, nil
+}
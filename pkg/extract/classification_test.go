@@ -0,0 +1,84 @@
+package extract
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExportDataClassification(t *testing.T) {
+	registerFake("classified", &fakeQuery{sets: []*fakeResult{numbersResult(2)}})
+	outFile := filepath.Join(t.TempDir(), "out.csv")
+	c := &config{Classification: classificationOptions{
+		Columns:    map[string]string{"name": "pii"},
+		Default:    map[string]string{"pii": "hash"},
+		HashKeyEnv: "TEST_HASH_KEY",
+	}}
+	if _, err := exportFake(t, c, "classified", outFile); err == nil || !strings.Contains(err.Error(), "TEST_HASH_KEY is not set") {
+		t.Errorf("hashed without a key: %v", err)
+	}
+
+	t.Setenv("TEST_HASH_KEY", "k1")
+	got, err := exportFake(t, c, "classified", outFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mac := hmac.New(sha256.New, []byte("k1"))
+	mac.Write([]byte("name 1"))
+	hash1 := hex.EncodeToString(mac.Sum(nil))
+	if !strings.HasPrefix(got, "id,name\n1,"+hash1+"\n") {
+		t.Errorf("got %q, want name 1 hashed to %s", got, hash1)
+	}
+
+	// another key gives other digests
+	t.Setenv("TEST_HASH_KEY", "k2")
+	if other, err := exportFake(t, c, "classified", outFile); err != nil || strings.Contains(other, hash1) {
+		t.Errorf("a different key gave %q, %v", other, err)
+	}
+}
+
+func TestComputedColumnClassification(t *testing.T) {
+	registerFake("classified computed", &fakeQuery{sets: []*fakeResult{numbersResult(3)}})
+	outFile := filepath.Join(t.TempDir(), "out.csv")
+	c := &config{
+		Server: "db1",
+		Computed: map[string][]computedColumn{outFile: {
+			{Name: "next_id", Expr: "id + 1"},
+			// label is calculated from name through coalesce and from id
+			// through next_id, and takes the stricter of their classes
+			{Name: "label", Expr: "upper(coalesce(name, 'none')) || '/' || next_id"},
+			{Name: "server", Expr: "source_server"},
+		}},
+	}
+	for _, tc := range []struct {
+		actions map[string]string
+		want    string
+		err     string
+	}{
+		{
+			map[string]string{"pii": "mask", "internal": "allow"},
+			"id,name,next_id,label,server\n1,****,2,****,db1\n2,****,3,****,db1\n3,,4,****,db1\n", "",
+		},
+		{
+			map[string]string{"pii": "allow", "internal": "mask"},
+			"id,name,next_id,label,server\n****,name 1,****,****,db1\n****,name 2,****,****,db1\n****,,****,****,db1\n", "",
+		},
+		{map[string]string{"pii": "drop", "internal": "allow"}, "id,next_id,server\n1,2,db1\n2,3,db1\n3,4,db1\n", ""},
+		{map[string]string{"internal": "allow"}, "", "receive name (pii), label (pii)"},
+	} {
+		c.Classification = classificationOptions{Columns: map[string]string{"name": "pii", "id": "internal"}, Default: tc.actions}
+		got, err := exportFake(t, c, "classified computed", outFile)
+		if tc.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("%v: got %v, want %q", tc.actions, err, tc.err)
+			}
+			continue
+		}
+		if err != nil || got != tc.want {
+			t.Errorf("%v: got %q, %v, want %q", tc.actions, got, err, tc.want)
+		}
+	}
+}
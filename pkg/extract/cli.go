@@ -0,0 +1,433 @@
+package extract
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Main runs the sql-export-wiz command line with args, the arguments after
+// the program name, and exits the process if the command fails.
+func Main(args []string) {
+	setupConsole()
+	name := "run"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+	cmd, ok := findCommand(name)
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n\n", name)
+		printUsage(os.Stderr)
+		os.Exit(2)
+	}
+	if err := cmd.execute(args); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// command is a subcommand of the CLI. setup defines its flags on fs and
+// returns the function running it with the arguments left after them.
+type command struct {
+	name    string
+	args    string
+	summary string
+	setup   func(fs *flag.FlagSet) func(args []string) error
+	// jobs is set for the commands taking job names as arguments.
+	jobs bool
+	// hidden commands are left out of the usage and the completion.
+	hidden bool
+}
+
+// commands lists the subcommands in the order the usage shows them. Without
+// one, the arguments are those of run.
+var commands []command
+
+func init() {
+	commands = []command{
+		{name: "run", summary: "Export every job of the configuration.", setup: runCommand},
+		{name: "validate", summary: "Check the configuration without connecting to any database.", setup: validateCommand},
+		{name: "list", summary: "List each job with the tables its query reads.", setup: listCommand},
+		{name: "history", args: "[job...]", summary: "Show the recorded runs of the jobs.", setup: historyCommand, jobs: true},
+		{name: "init", summary: "Write a starting configuration.", setup: initCommand},
+		{name: "ping", summary: "Connect to each configured database.", setup: pingCommand},
+		{name: "backfill", args: "[job...]", summary: "Export the named jobs, or those without a recent successful run.", setup: backfillCommand, jobs: true},
+		{name: "migrate-config", args: "file...", summary: "Rewrite queries and outfiles lists as extracts.", setup: migrateConfigCommand},
+		{name: "schema", summary: "Print the JSON Schema of the configuration.", setup: schemaCommand},
+		{name: "completion", args: "bash|zsh|powershell", summary: "Print the shell completion script.", setup: completionCommand},
+		{name: "help", summary: "Show this help.", setup: helpCommand},
+		{name: completeJobsCommand, summary: "List the job names for the completion.", setup: completeJobsSetup, hidden: true},
+	}
+}
+
+func findCommand(name string) (command, bool) {
+	for _, c := range commands {
+		if c.name == name {
+			return c, true
+		}
+	}
+	return command{}, false
+}
+
+// flags returns the flag set of c and the function running it.
+func (c command) flags() (*flag.FlagSet, func(args []string) error) {
+	fs := flag.NewFlagSet(c.name, flag.ExitOnError)
+	run := c.setup(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: sql-export-wiz %s [flags] %s\n\n%s\n\n", c.name, c.args, c.summary)
+		fs.PrintDefaults()
+	}
+	return fs, run
+}
+
+func (c command) execute(args []string) error {
+	fs, run := c.flags()
+	fs.Parse(args)
+	return run(fs.Args())
+}
+
+func printUsage(w io.Writer) {
+	fmt.Fprintln(w, "usage: sql-export-wiz [command] [flags]")
+	fmt.Fprintln(w)
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for _, c := range commands {
+		if !c.hidden {
+			fmt.Fprintf(tw, "  %s\t%s\n", c.name, c.summary)
+		}
+	}
+	tw.Flush()
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Without a command the flags are those of run. Run sql-export-wiz <command> -h for its flags.")
+}
+
+func helpCommand(fs *flag.FlagSet) func([]string) error {
+	return func(args []string) error {
+		if len(args) > 0 {
+			if c, ok := findCommand(args[0]); ok {
+				cfs, _ := c.flags()
+				cfs.SetOutput(os.Stdout)
+				cfs.Usage()
+				return nil
+			}
+		}
+		printUsage(os.Stdout)
+		return nil
+	}
+}
+
+func runCommand(fs *flag.FlagSet) func([]string) error {
+	f := addRunFlags(fs)
+	return func(args []string) error {
+		if len(args) > 0 {
+			return fmt.Errorf("Unexpected arguments %v, run exports every job\n", args)
+		}
+		return f.execute(nil)
+	}
+}
+
+func backfillCommand(fs *flag.FlagSet) func([]string) error {
+	f := addRunFlags(fs)
+	since := fs.Duration("since", 0, "Export the jobs the ledger has no successful run for in this long, e.g. 24h.")
+	return func(names []string) error {
+		if len(names) == 0 && *since <= 0 {
+			return fmt.Errorf("backfill needs the names of the jobs or -since\n")
+		}
+		return f.execute(func(c *config) error {
+			keep, err := c.jobsNamed(names)
+			if err != nil {
+				return err
+			}
+			if *since > 0 {
+				missed, err := c.jobsMissed(time.Now().Add(-*since))
+				if err != nil {
+					return err
+				}
+				keep = append(keep, missed...)
+			}
+			c.keepJobs(keep)
+			return nil
+		})
+	}
+}
+
+func validateCommand(fs *flag.FlagSet) func([]string) error {
+	configFile := configFlag(fs)
+	return func([]string) error {
+		c, err := loadConfig(*configFile)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s: %d job(s), valid\n", *configFile, len(c.OutFiles))
+		return nil
+	}
+}
+
+func listCommand(fs *flag.FlagSet) func([]string) error {
+	configFile := configFlag(fs)
+	reads := fs.String("reads", "", "List only the jobs whose query reads this table or view.")
+	return func([]string) error {
+		c, err := loadConfig(*configFile)
+		if err != nil {
+			return err
+		}
+		listTables(os.Stdout, c, *reads)
+		return nil
+	}
+}
+
+func historyCommand(fs *flag.FlagSet) func([]string) error {
+	configFile := configFlag(fs)
+	return func(names []string) error {
+		c, err := loadConfig(*configFile)
+		if err != nil {
+			return err
+		}
+		jobs, err := c.jobsNamed(names)
+		if err != nil {
+			return err
+		}
+		return printHistory(os.Stdout, c, jobs)
+	}
+}
+
+// printHistory writes the runs of the jobs writing outFiles, or of every job
+// if there are none: each run from the audit log, or else the last
+// successful one from the ledger.
+func printHistory(w io.Writer, c *config, outFiles []string) error {
+	want := func(outFile string) bool {
+		return len(outFiles) == 0 || slices.Contains(outFiles, outFile)
+	}
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	defer tw.Flush()
+	switch {
+	case c.Audit != "":
+		key, err := auditKey()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(tw, "TIME\tJOB\tSTATUS\tROWS\tERROR")
+		_, _, err = scanAuditLog(c.Audit, key, func(e auditEntry) {
+			if want(e.OutFile) {
+				fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%s\n", e.Time.Local().Format(time.DateTime), c.jobName(e.OutFile), e.Status, e.Rows, e.Error)
+			}
+		})
+		if err != nil {
+			return err
+		}
+	case c.Ledger != "":
+		l, err := loadLedger(c.Ledger, c.keys)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(tw, "LAST SUCCESS\tJOB\tROWS\tCOLUMNS")
+		for _, outFile := range c.OutFiles {
+			e := l.entry(outFile)
+			if !want(outFile) || e == nil {
+				continue
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%d\t%d\n", e.LastRun.Local().Format(time.DateTime), c.jobName(outFile), e.Rows, len(e.Schema))
+		}
+	default:
+		return fmt.Errorf("%s sets neither audit nor ledger, so no runs are recorded\n", c.source)
+	}
+	return nil
+}
+
+// starterConfig is the configuration init writes.
+const starterConfig = `server: %s
+database: %s
+delimiter: ","
+ledger: .tea-extract/ledger.json
+extracts:
+  - name: example
+    query: SELECT * FROM dbo.Example
+    outfile: example.csv
+`
+
+func initCommand(fs *flag.FlagSet) func([]string) error {
+	out := fs.String("o", "config.yaml", "The file to write.")
+	server := fs.String("server", "localhost", "The SQL Server to extract from.")
+	database := fs.String("database", "master", "The database to extract from.")
+	return func([]string) error {
+		f, err := os.OpenFile(*out, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+		if errors.Is(err, os.ErrExist) {
+			return fmt.Errorf("%s already exists\n", *out)
+		}
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(f, starterConfig, *server, *database); err != nil {
+			f.Close()
+			return err
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+		fmt.Printf("Wrote %s, check it with sql-export-wiz validate -config %s\n", *out, *out)
+		return nil
+	}
+}
+
+func pingCommand(fs *flag.FlagSet) func([]string) error {
+	configFile := configFlag(fs)
+	timeout := fs.Duration("timeout", 10*time.Second, "How long to wait for each database.")
+	return func([]string) error {
+		c, err := loadConfig(*configFile)
+		if err != nil {
+			return err
+		}
+		dbs, err := openDatabases(c)
+		if err != nil {
+			return err
+		}
+		defer closeDatabases(c, dbs)
+		names := make([]string, 0, len(dbs))
+		for name := range dbs {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		failed := 0
+		for _, name := range names {
+			server, database := c.serverFor(name)
+			ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+			started := time.Now()
+			err := dbs[name].PingContext(ctx)
+			cancel()
+			if err != nil {
+				failed++
+				fmt.Printf("%s/%s: %v\n", server, database, err)
+				continue
+			}
+			fmt.Printf("%s/%s: ok in %v\n", server, database, time.Since(started).Round(time.Millisecond))
+		}
+		if failed > 0 {
+			return fmt.Errorf("%d of %d database(s) could not be reached\n", failed, len(names))
+		}
+		return nil
+	}
+}
+
+func completionCommand(fs *flag.FlagSet) func([]string) error {
+	return func(args []string) error {
+		if len(args) != 1 {
+			return fmt.Errorf("completion needs one of bash, zsh or powershell\n")
+		}
+		return writeCompletion(os.Stdout, args[0])
+	}
+}
+
+// completeJobsCommand is run by the completion scripts to list the job
+// names of a configuration.
+const completeJobsCommand = "__jobs"
+
+// completeJobsSetup reads the configuration without validating it, so the
+// jobs of a configuration being edited still complete.
+func completeJobsSetup(fs *flag.FlagSet) func([]string) error {
+	configFile := configFlag(fs)
+	return func([]string) error {
+		docs, err := readConfigDocs(*configFile)
+		if err != nil {
+			return err
+		}
+		c, _, err := parseConfigDocs(docs)
+		if err != nil {
+			return err
+		}
+		for _, outFile := range c.OutFiles {
+			fmt.Println(c.jobName(outFile))
+		}
+		return nil
+	}
+}
+
+// writeCompletion writes the completion script for shell. Subcommands and
+// their flags are listed in the script, and job names come from the
+// configuration on the command line when completing.
+func writeCompletion(w io.Writer, shell string) error {
+	var names, jobCommands []string
+	flags := map[string]string{}
+	for _, c := range commands {
+		if c.hidden {
+			continue
+		}
+		names = append(names, c.name)
+		if c.jobs {
+			jobCommands = append(jobCommands, c.name)
+		}
+		fs, _ := c.flags()
+		var list []string
+		fs.VisitAll(func(f *flag.Flag) { list = append(list, "-"+f.Name) })
+		flags[c.name] = strings.Join(list, " ")
+	}
+	switch shell {
+	case "bash", "zsh":
+		if shell == "zsh" {
+			fmt.Fprintln(w, "autoload -U +X bashcompinit && bashcompinit")
+		}
+		fmt.Fprintf(w, "_sql_export_wiz() {\n")
+		fmt.Fprintf(w, "  local cur=${COMP_WORDS[COMP_CWORD]} prev=${COMP_WORDS[COMP_CWORD-1]} cmd=run config=config.yaml i\n")
+		fmt.Fprintf(w, "  [[ ${COMP_WORDS[1]} != -* ]] && cmd=${COMP_WORDS[1]}\n")
+		fmt.Fprintf(w, "  for ((i=1; i<COMP_CWORD; i++)); do\n")
+		fmt.Fprintf(w, "    [[ ${COMP_WORDS[i]} == -config ]] && config=${COMP_WORDS[i+1]}\n")
+		fmt.Fprintf(w, "  done\n")
+		fmt.Fprintf(w, "  if ((COMP_CWORD == 1)) && [[ $cur != -* ]]; then\n")
+		fmt.Fprintf(w, "    COMPREPLY=($(compgen -W %q -- \"$cur\"))\n", strings.Join(names, " "))
+		fmt.Fprintf(w, "  elif [[ $prev == -config || $prev == -o ]]; then\n")
+		fmt.Fprintf(w, "    COMPREPLY=($(compgen -f -- \"$cur\"))\n")
+		fmt.Fprintf(w, "  elif [[ $cur == -* ]]; then\n")
+		fmt.Fprintf(w, "    case $cmd in\n")
+		for _, name := range names {
+			fmt.Fprintf(w, "      %s) COMPREPLY=($(compgen -W %q -- \"$cur\")) ;;\n", name, flags[name])
+		}
+		fmt.Fprintf(w, "    esac\n")
+		fmt.Fprintf(w, "  else\n")
+		fmt.Fprintf(w, "    case $cmd in\n")
+		fmt.Fprintf(w, "      %s) COMPREPLY=($(compgen -W \"$(sql-export-wiz %s -config \"$config\" 2>/dev/null)\" -- \"$cur\")) ;;\n", strings.Join(jobCommands, "|"), completeJobsCommand)
+		fmt.Fprintf(w, "      completion) COMPREPLY=($(compgen -W \"bash zsh powershell\" -- \"$cur\")) ;;\n")
+		fmt.Fprintf(w, "      migrate-config) COMPREPLY=($(compgen -f -- \"$cur\")) ;;\n")
+		fmt.Fprintf(w, "    esac\n")
+		fmt.Fprintf(w, "  fi\n")
+		fmt.Fprintf(w, "}\n")
+		fmt.Fprintf(w, "complete -F _sql_export_wiz sql-export-wiz\n")
+	case "powershell":
+		fmt.Fprintf(w, "Register-ArgumentCompleter -Native -CommandName sql-export-wiz -ScriptBlock {\n")
+		fmt.Fprintf(w, "  param($word, $ast, $cursor)\n")
+		fmt.Fprintf(w, "  $words = @($ast.CommandElements | ForEach-Object { $_.ToString() })\n")
+		fmt.Fprintf(w, "  $cmd = if ($words.Count -gt 1 -and -not $words[1].StartsWith('-')) { $words[1] } else { 'run' }\n")
+		fmt.Fprintf(w, "  $config = 'config.yaml'\n")
+		fmt.Fprintf(w, "  $i = [array]::IndexOf($words, '-config')\n")
+		fmt.Fprintf(w, "  if ($i -ge 0 -and $i + 1 -lt $words.Count) { $config = $words[$i + 1] }\n")
+		fmt.Fprintf(w, "  $flags = @{\n")
+		for _, name := range names {
+			fmt.Fprintf(w, "    '%s' = '%s'\n", name, flags[name])
+		}
+		fmt.Fprintf(w, "  }\n")
+		fmt.Fprintf(w, "  $jobCommands = @('%s')\n", strings.Join(jobCommands, "', '"))
+		fmt.Fprintf(w, "  if ($words.Count -eq 1 -or ($words.Count -eq 2 -and $word -ne '' -and -not $word.StartsWith('-'))) {\n")
+		fmt.Fprintf(w, "    $candidates = '%s' -split ' '\n", strings.Join(names, " "))
+		fmt.Fprintf(w, "  } elseif ($word.StartsWith('-')) {\n")
+		fmt.Fprintf(w, "    $candidates = $flags[$cmd] -split ' '\n")
+		fmt.Fprintf(w, "  } elseif ($cmd -eq 'completion') {\n")
+		fmt.Fprintf(w, "    $candidates = 'bash', 'zsh', 'powershell'\n")
+		fmt.Fprintf(w, "  } elseif ($jobCommands -contains $cmd) {\n")
+		fmt.Fprintf(w, "    $candidates = @(sql-export-wiz %s -config $config 2>$null)\n", completeJobsCommand)
+		fmt.Fprintf(w, "  } else {\n")
+		fmt.Fprintf(w, "    return\n")
+		fmt.Fprintf(w, "  }\n")
+		fmt.Fprintf(w, "  $candidates | Where-Object { $_ -like \"$word*\" } | ForEach-Object {\n")
+		fmt.Fprintf(w, "    [System.Management.Automation.CompletionResult]::new($_, $_, 'ParameterValue', $_)\n")
+		fmt.Fprintf(w, "  }\n")
+		fmt.Fprintf(w, "}\n")
+	default:
+		return fmt.Errorf("Unsupported shell '%s', use bash, zsh or powershell\n", shell)
+	}
+	return nil
+}
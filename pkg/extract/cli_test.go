@@ -0,0 +1,92 @@
+package extract
+
+import (
+	"bytes"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSelectJobs(t *testing.T) {
+	dir := t.TempDir()
+	c := &config{
+		Ledger:   filepath.Join(dir, "ledger.json"),
+		Extracts: []extract{{Name: "customers", OutFile: "customers.csv"}, {Name: "orders", OutFile: "orders.csv"}},
+		Queries:  []string{"select 1", "select 2", "select 3"},
+		OutFiles: []string{"customers.csv", "orders.csv", "legacy.csv"},
+	}
+	got, err := c.jobsNamed([]string{"orders", "legacy.csv"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, []string{"orders.csv", "legacy.csv"}) {
+		t.Errorf("jobsNamed: got %v", got)
+	}
+	if _, err := c.jobsNamed([]string{"missing"}); err == nil {
+		t.Error("an unknown job was accepted")
+	}
+
+	l, err := loadLedger(c.Ledger, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.set("customers.csv", &ledgerEntry{LastRun: time.Now().Add(-time.Hour), Rows: 5})
+	l.set("orders.csv", &ledgerEntry{LastRun: time.Now().Add(-48 * time.Hour), Rows: 7})
+	missed, err := c.jobsMissed(time.Now().Add(-24 * time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(missed, []string{"orders.csv", "legacy.csv"}) {
+		t.Errorf("jobsMissed: got %v", missed)
+	}
+
+	var out bytes.Buffer
+	if err := printHistory(&out, c, []string{"orders.csv"}); err != nil {
+		t.Fatal(err)
+	}
+	if text := out.String(); !strings.Contains(text, "orders") || strings.Contains(text, "customers") {
+		t.Errorf("history:\n%s", text)
+	}
+
+	c.keepJobs(missed)
+	if !reflect.DeepEqual(c.Queries, []string{"select 2", "select 3"}) || !reflect.DeepEqual(c.OutFiles, missed) {
+		t.Errorf("keepJobs: got %v %v", c.Queries, c.OutFiles)
+	}
+}
+
+func TestInitConfig(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "config.yaml")
+	cmd, _ := findCommand("init")
+	if err := cmd.execute([]string{"-o", out, "-server", "db1"}); err != nil {
+		t.Fatal(err)
+	}
+	c, err := loadConfig(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Server != "db1" || c.jobName(c.OutFiles[0]) != "example" {
+		t.Errorf("got server %q and jobs %v", c.Server, c.OutFiles)
+	}
+	if err := cmd.execute([]string{"-o", out}); err == nil {
+		t.Error("init overwrote a configuration")
+	}
+}
+
+func TestCompletion(t *testing.T) {
+	for _, shell := range []string{"bash", "zsh", "powershell"} {
+		var out bytes.Buffer
+		if err := writeCompletion(&out, shell); err != nil {
+			t.Fatal(err)
+		}
+		for _, want := range []string{"backfill", "-since", completeJobsCommand} {
+			if !strings.Contains(out.String(), want) {
+				t.Errorf("%s completion is missing %s", shell, want)
+			}
+		}
+	}
+	if err := writeCompletion(&bytes.Buffer{}, "fish"); err == nil {
+		t.Error("an unsupported shell was accepted")
+	}
+}
@@ -0,0 +1,644 @@
+package extract
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+// A coordinator runs the jobs of one configuration on workers, processes
+// started with -worker and the same configuration. It keeps the ledger, run
+// report, audit log, lineage and catalog as a single process would, while the
+// workers query the databases and write the outfiles. Workers poll for jobs,
+// send heartbeats and report each result; the jobs of a worker that stops
+// sending heartbeats are handed to the others if their destinations are
+// replayable and failed otherwise. A worker that cannot get a heartbeat
+// through for half of workerTimeout cancels its jobs, so that it has stopped
+// writing by the time they are handed on.
+//
+// The protocol is gRPC with JSON messages, described by clusterService,
+// over TLS unless cluster is explicitly insecure. Workers present the token
+// in clusterTokenEnv, which both ends require.
+const clusterTokenEnv = "SQL_EXPORT_WIZ_CLUSTER_TOKEN"
+
+// clusterOptions secures the connection between the coordinator and its
+// workers. With CertFile and KeyFile the coordinator serves TLS, and workers
+// check its certificate against CAFile, or the system roots without one.
+// Insecure must be set to run without them, sending the token in clear text.
+type clusterOptions struct {
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+	CAFile   string `yaml:"ca_file"`
+	Insecure bool   `yaml:"insecure"`
+}
+
+func (o clusterOptions) validate() error {
+	if (o.CertFile == "") != (o.KeyFile == "") {
+		return fmt.Errorf("Cluster needs both cert_file and key_file\n")
+	}
+	if o.CAFile != "" && o.CertFile == "" {
+		return fmt.Errorf("Cluster sets ca_file without cert_file and key_file\n")
+	}
+	if o.Insecure && o.CertFile != "" {
+		return fmt.Errorf("Cluster sets insecure along with cert_file\n")
+	}
+	return nil
+}
+
+// plainText returns whether the cluster runs without TLS, failing unless
+// that was asked for.
+func (o clusterOptions) plainText() (bool, error) {
+	if o.CertFile != "" {
+		return false, nil
+	}
+	if !o.Insecure {
+		return false, fmt.Errorf("Set cluster.cert_file and cluster.key_file, or cluster.insecure to send the cluster token unencrypted\n")
+	}
+	return true, nil
+}
+
+// serverCredentials returns the transport of the coordinator.
+func (o clusterOptions) serverCredentials() (credentials.TransportCredentials, error) {
+	if plain, err := o.plainText(); err != nil || plain {
+		if plain {
+			log.Printf("Warning: the connection to the workers is not encrypted\n")
+			return insecure.NewCredentials(), nil
+		}
+		return nil, err
+	}
+	cert, err := tls.LoadX509KeyPair(o.CertFile, o.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("Could not load the cluster certificate: %v\n", err)
+	}
+	return credentials.NewTLS(&tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}), nil
+}
+
+// clientCredentials returns the transport of a worker.
+func (o clusterOptions) clientCredentials() (credentials.TransportCredentials, error) {
+	if plain, err := o.plainText(); err != nil || plain {
+		if plain {
+			log.Printf("Warning: the connection to the coordinator is not encrypted\n")
+			return insecure.NewCredentials(), nil
+		}
+		return nil, err
+	}
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	if o.CAFile != "" {
+		pem, err := os.ReadFile(o.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("Could not read the cluster CA: %v\n", err)
+		}
+		cfg.RootCAs = x509.NewCertPool()
+		if !cfg.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("The cluster CA %s holds no PEM certificate\n", o.CAFile)
+		}
+	}
+	return credentials.NewTLS(cfg), nil
+}
+
+// clusterToken returns the token of the cluster. Without one anyone who can
+// reach the coordinator could register, so it is required.
+func clusterToken() (string, error) {
+	token := os.Getenv(clusterTokenEnv)
+	if token == "" {
+		return "", fmt.Errorf("Set %s to the token workers present to the coordinator\n", clusterTokenEnv)
+	}
+	return token, nil
+}
+
+// How often workers send heartbeats and poll while there is no job, and how
+// long the coordinator waits for a heartbeat before reassigning the jobs of
+// a worker. Tests shorten them.
+var (
+	heartbeatInterval = 2 * time.Second
+	pollInterval      = time.Second
+	workerTimeout     = 10 * time.Second
+)
+
+type registerRequest struct {
+	Worker string `json:"worker"`
+	Digest string `json:"config_sha256"`
+	Token  string `json:"token,omitempty"`
+}
+
+type registerReply struct {
+	ID     string  `json:"id"`
+	Limit  int     `json:"limit,omitempty"`
+	Sample float64 `json:"sample,omitempty"`
+	DryRun bool    `json:"dry_run,omitempty"`
+}
+
+type workerRequest struct {
+	ID string `json:"id"`
+}
+
+// assignment is the reply to a poll: a job, come back later, or stop. The
+// query, the values its template bound and the file of a job are as the
+// coordinator rendered them.
+type assignment struct {
+	OutFile string       `json:"outfile,omitempty"`
+	Query   string       `json:"query,omitempty"`
+	Args    []boundArg   `json:"args,omitempty"`
+	Path    string       `json:"path,omitempty"`
+	Entry   *ledgerEntry `json:"ledger,omitempty"`
+	Wait    bool         `json:"wait,omitempty"`
+	Done    bool         `json:"done,omitempty"`
+}
+
+type heartbeatReply struct {
+	Cancel []string `json:"cancel,omitempty"`
+}
+
+// wireColumn is an output column as sent by a worker.
+type wireColumn struct {
+	Name      string `json:"name"`
+	DBType    string `json:"db_type"`
+	Nullable  bool   `json:"nullable,omitempty"`
+	Precision int64  `json:"precision,omitempty"`
+	Scale     int64  `json:"scale,omitempty"`
+}
+
+// jobResult is a worker's report of a job. Delivered is set when the job
+// failed after its destination took some of its rows.
+type jobResult struct {
+	ID        string       `json:"id"`
+	OutFile   string       `json:"outfile"`
+	Error     string       `json:"error,omitempty"`
+	Delivered bool         `json:"delivered,omitempty"`
+	Usage     *jobUsage    `json:"usage,omitempty"`
+	Schema    []wireColumn `json:"schema,omitempty"`
+	Entry     *ledgerEntry `json:"ledger,omitempty"`
+}
+
+type empty struct{}
+
+// errWorkerFenced cancels the jobs of a worker that lost the coordinator.
+var errWorkerFenced = errors.New("cancelled, the coordinator stopped answering heartbeats and may have handed the job on")
+
+// jsonCodec carries the messages as JSON, so the service needs no generated code.
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v any) ([]byte, error)      { return json.Marshal(v) }
+func (jsonCodec) Unmarshal(data []byte, v any) error { return json.Unmarshal(data, v) }
+func (jsonCodec) Name() string                       { return "json" }
+
+// unary adapts a coordinator method to a gRPC handler.
+func unary[Req, Reply any](call func(*coordinator, *Req) (*Reply, error)) grpc.MethodHandler {
+	return func(srv any, ctx context.Context, dec func(any) error, _ grpc.UnaryServerInterceptor) (any, error) {
+		req := new(Req)
+		if err := dec(req); err != nil {
+			return nil, err
+		}
+		return call(srv.(*coordinator), req)
+	}
+}
+
+var clusterService = grpc.ServiceDesc{
+	ServiceName: "sqlexportwiz.Coordinator",
+	HandlerType: (*any)(nil),
+	Methods: []grpc.MethodDesc{
+		{MethodName: "Register", Handler: unary((*coordinator).register)},
+		{MethodName: "Next", Handler: unary((*coordinator).next)},
+		{MethodName: "Heartbeat", Handler: unary((*coordinator).heartbeat)},
+		{MethodName: "Report", Handler: unary((*coordinator).report)},
+	},
+}
+
+// clusterJob is a job waiting for or running on a worker.
+type clusterJob struct {
+	outFile string
+	query   string
+	ctx     context.Context
+	worker  string
+	result  chan jobResult
+}
+
+type clusterWorker struct {
+	name string
+	seen time.Time
+	jobs map[string]bool
+}
+
+type coordinator struct {
+	params *config
+	token  string
+
+	mu      sync.Mutex
+	ledger  *ledger
+	workers map[string]*clusterWorker
+	queue   []*clusterJob
+	jobs    map[string]*clusterJob
+	done    bool
+}
+
+func newCoordinator(params *config) *coordinator {
+	return &coordinator{
+		params: params, token: os.Getenv(clusterTokenEnv),
+		workers: map[string]*clusterWorker{}, jobs: map[string]*clusterJob{},
+	}
+}
+
+func (c *coordinator) register(req *registerRequest) (*registerReply, error) {
+	if c.token == "" || subtle.ConstantTimeCompare([]byte(req.Token), []byte(c.token)) != 1 {
+		return nil, status.Error(codes.PermissionDenied, "wrong cluster token")
+	}
+	if req.Digest != c.params.digest {
+		return nil, status.Errorf(codes.FailedPrecondition, "the worker's configuration differs from the coordinator's (sha256 %s)", c.params.digest)
+	}
+	id := make([]byte, 16)
+	rand.Read(id)
+	reply := &registerReply{ID: hex.EncodeToString(id), Limit: c.params.limit, Sample: c.params.sample, DryRun: c.params.dryRun}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.workers[reply.ID] = &clusterWorker{name: req.Worker, seen: time.Now(), jobs: map[string]bool{}}
+	log.Printf("Worker %s registered\n", req.Worker)
+	return reply, nil
+}
+
+// worker returns the registered worker id, noting that it is alive. The
+// caller must hold c.mu.
+func (c *coordinator) worker(id string) (*clusterWorker, error) {
+	w, ok := c.workers[id]
+	if !ok {
+		return nil, status.Error(codes.NotFound, "unknown worker, it may have timed out")
+	}
+	w.seen = time.Now()
+	return w, nil
+}
+
+func (c *coordinator) next(req *workerRequest) (*assignment, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	w, err := c.worker(req.ID)
+	if err != nil {
+		return nil, err
+	}
+	for len(c.queue) > 0 {
+		j := c.queue[0]
+		c.queue = c.queue[1:]
+		if j.ctx.Err() != nil {
+			// cancelled while queued
+			j.result <- jobResult{OutFile: j.outFile, Error: context.Cause(j.ctx).Error()}
+			delete(c.jobs, j.outFile)
+			continue
+		}
+		j.worker = req.ID
+		w.jobs[j.outFile] = true
+		log.Printf("Assigned %s to %s\n", j.outFile, w.name)
+		a := &assignment{OutFile: j.outFile, Query: j.query, Args: c.params.bound.get(j.outFile), Entry: c.ledger.entry(j.outFile)}
+		if path, ok := c.params.paths.get(j.outFile); ok {
+			a.Path = path
+		}
+		return a, nil
+	}
+	if c.done {
+		return &assignment{Done: true}, nil
+	}
+	return &assignment{Wait: true}, nil
+}
+
+func (c *coordinator) heartbeat(req *workerRequest) (*heartbeatReply, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	w, err := c.worker(req.ID)
+	if err != nil {
+		return nil, err
+	}
+	reply := &heartbeatReply{}
+	for outFile := range w.jobs {
+		if j := c.jobs[outFile]; j != nil && j.ctx.Err() != nil {
+			reply.Cancel = append(reply.Cancel, outFile)
+		}
+	}
+	return reply, nil
+}
+
+func (c *coordinator) report(res *jobResult) (*empty, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	w, err := c.worker(res.ID)
+	if err != nil {
+		return nil, err
+	}
+	j := c.jobs[res.OutFile]
+	if j == nil || j.worker != res.ID {
+		// the job was given to another worker meanwhile
+		return &empty{}, nil
+	}
+	delete(w.jobs, res.OutFile)
+	delete(c.jobs, res.OutFile)
+	j.result <- *res
+	return &empty{}, nil
+}
+
+// reap drops the workers that stopped sending heartbeats, until stop is
+// closed. Their jobs are queued again, except those whose destination is not
+// replayable: the worker may have delivered rows before it went silent, so
+// they fail instead.
+func (c *coordinator) reap(stop <-chan struct{}) {
+	t := time.NewTicker(workerTimeout / 4)
+	defer t.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-t.C:
+		}
+		c.mu.Lock()
+		for id, w := range c.workers {
+			if time.Since(w.seen) < workerTimeout {
+				continue
+			}
+			delete(c.workers, id)
+			log.Printf("Warning: worker %s stopped responding with %d job(s)\n", w.name, len(w.jobs))
+			for outFile := range w.jobs {
+				j := c.jobs[outFile]
+				if j.ctx.Err() != nil {
+					delete(c.jobs, outFile)
+					j.result <- jobResult{OutFile: outFile, Error: context.Cause(j.ctx).Error()}
+					continue
+				}
+				if !c.params.replayable(outFile) {
+					delete(c.jobs, outFile)
+					j.result <- jobResult{OutFile: outFile, Delivered: true,
+						Error: fmt.Sprintf("Worker %s stopped responding while running %s, whose destination is not replayable, so it is not run again\n", w.name, outFile)}
+					continue
+				}
+				j.worker = ""
+				c.queue = append([]*clusterJob{j}, c.queue...)
+			}
+		}
+		c.mu.Unlock()
+	}
+}
+
+// dispatch returns the function runJobs hands each job to: it queues the job
+// for the next worker to poll and waits for the result, which goes into the
+// coordinator's ledger and report.
+func (c *coordinator) dispatch(l *ledger, r *runReport) jobFunc {
+	c.mu.Lock()
+	c.ledger = l
+	c.mu.Unlock()
+	return func(ctx context.Context, _, query, outFile string) error {
+		j := &clusterJob{outFile: outFile, query: query, ctx: ctx, result: make(chan jobResult, 1)}
+		c.mu.Lock()
+		c.jobs[outFile] = j
+		c.queue = append(c.queue, j)
+		c.mu.Unlock()
+
+		res := <-j.result
+		if res.Usage != nil {
+			r.add(*res.Usage)
+		}
+		if res.Schema != nil {
+			cols := make([]column, len(res.Schema))
+			for i, wc := range res.Schema {
+				cols[i] = column{Name: wc.Name, DBType: wc.DBType, Nullable: wc.Nullable, Precision: wc.Precision, Scale: wc.Scale}
+			}
+			r.schema(outFile, cols)
+		}
+		if res.Entry != nil {
+			if err := l.set(outFile, res.Entry); err != nil {
+				return err
+			}
+		}
+		if res.Error != "" && res.Delivered {
+			return &deliveredError{errors.New(res.Error)}
+		}
+		if res.Error != "" {
+			return errors.New(res.Error)
+		}
+		return nil
+	}
+}
+
+// runCoordinator serves workers on addr while running the jobs of params.
+func runCoordinator(ctx context.Context, addr string, params *config, control *controller) error {
+	if params.hasCaptures() {
+		return fmt.Errorf("Captured values are not handed between workers, so -coordinator cannot run extracts with capture\n")
+	}
+	if _, err := clusterToken(); err != nil {
+		return err
+	}
+	creds, err := params.Cluster.serverCredentials()
+	if err != nil {
+		return err
+	}
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("Could not listen for workers: %v\n", err)
+	}
+	c := newCoordinator(params)
+	srv := grpc.NewServer(grpc.Creds(creds), grpc.ForceServerCodec(jsonCodec{}))
+	srv.RegisterService(&clusterService, c)
+	go srv.Serve(lis)
+	defer srv.Stop()
+	stop := make(chan struct{})
+	defer close(stop)
+	go c.reap(stop)
+	log.Printf("Coordinating workers on %s\n", lis.Addr())
+
+	err = runJobs(ctx, params, control, c.dispatch)
+	c.mu.Lock()
+	c.done = true
+	c.mu.Unlock()
+	// give polling workers the chance to hear that the run is over
+	time.Sleep(2 * pollInterval)
+	return err
+}
+
+// clusterClient calls the coordinator's service.
+type clusterClient struct {
+	conn *grpc.ClientConn
+}
+
+func (cc clusterClient) call(method string, req, reply any) error {
+	ctx, cancel := context.WithTimeout(context.Background(), workerTimeout)
+	defer cancel()
+	return cc.conn.Invoke(ctx, "/"+clusterService.ServiceName+"/"+method, req, reply)
+}
+
+// runWorker runs jobs handed out by the coordinator at addr, at most slots at
+// once, until it reports that the run is over or ctx is cancelled.
+func runWorker(ctx context.Context, addr, name string, slots int, params *config) error {
+	token, err := clusterToken()
+	if err != nil {
+		return err
+	}
+	creds, err := params.Cluster.clientCredentials()
+	if err != nil {
+		return err
+	}
+	conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(creds), grpc.WithDefaultCallOptions(grpc.ForceCodec(jsonCodec{})))
+	if err != nil {
+		return fmt.Errorf("Could not reach the coordinator: %v\n", err)
+	}
+	defer conn.Close()
+	cc := clusterClient{conn}
+	var reg registerReply
+	if err := cc.call("Register", &registerRequest{Worker: name, Digest: params.digest, Token: token}, &reg); err != nil {
+		return fmt.Errorf("Could not register with the coordinator: %v\n", err)
+	}
+	params.limit, params.sample, params.dryRun = reg.Limit, reg.Sample, reg.DryRun
+	log.Printf("Registered with the coordinator at %s as %s\n", addr, name)
+
+	contracts, err := loadContracts(params.Contracts)
+	if err != nil {
+		return err
+	}
+	dbs, err := openDatabases(params)
+	if err != nil {
+		return err
+	}
+	defer closeDatabases(params, dbs)
+	queries := make(map[string]string, len(params.Queries))
+	for i, q := range params.Queries {
+		queries[params.OutFiles[i]] = q
+	}
+	params.paths = &outPaths{paths: map[string]string{}}
+	params.bound = &boundArgs{args: map[string][]boundArg{}}
+
+	var mu sync.Mutex
+	cancels := map[string]context.CancelCauseFunc{}
+	stop := make(chan struct{})
+	defer close(stop)
+	lastBeat := time.Now()
+	go func() {
+		t := time.NewTicker(heartbeatInterval)
+		defer t.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-t.C:
+			}
+			var reply heartbeatReply
+			if err := cc.call("Heartbeat", &workerRequest{ID: reg.ID}, &reply); err != nil {
+				log.Printf("Warning: heartbeat failed: %v\n", err)
+				// the coordinator hands the jobs on after workerTimeout, so
+				// stop writing them well before
+				if time.Since(lastBeat) >= workerTimeout/2 || status.Code(err) == codes.NotFound {
+					mu.Lock()
+					for _, cancel := range cancels {
+						cancel(errWorkerFenced)
+					}
+					mu.Unlock()
+				}
+				continue
+			}
+			lastBeat = time.Now()
+			mu.Lock()
+			for _, outFile := range reply.Cancel {
+				if cancel := cancels[outFile]; cancel != nil {
+					cancel(errJobCancelled)
+				}
+			}
+			mu.Unlock()
+		}
+	}()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, slots)
+	for range slots {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				var a assignment
+				if err := cc.call("Next", &workerRequest{ID: reg.ID}, &a); err != nil {
+					errs <- fmt.Errorf("Lost the coordinator: %v\n", err)
+					return
+				}
+				if a.Done {
+					return
+				}
+				if a.Wait {
+					time.Sleep(pollInterval)
+					continue
+				}
+				jobCtx, cancel := context.WithCancelCause(ctx)
+				mu.Lock()
+				cancels[a.OutFile] = cancel
+				mu.Unlock()
+				res := runAssigned(jobCtx, params, dbs, contracts, queries, a)
+				mu.Lock()
+				delete(cancels, a.OutFile)
+				mu.Unlock()
+				cancel(nil)
+				res.ID = reg.ID
+				if err := cc.call("Report", &res, &empty{}); err != nil {
+					errs <- fmt.Errorf("Could not report %s to the coordinator: %v\n", a.OutFile, err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	if err := <-errs; err != nil {
+		return err
+	}
+	if ctx.Err() != nil {
+		return fmt.Errorf("The worker was %v\n", context.Cause(ctx))
+	}
+	return nil
+}
+
+// runAssigned exports one job for the coordinator, against a ledger holding
+// only that job's last run.
+func runAssigned(ctx context.Context, params *config, dbs map[string]*sql.DB, contracts map[string]*contract, queries map[string]string, a assignment) jobResult {
+	res := jobResult{OutFile: a.OutFile}
+	query, ok := queries[a.OutFile]
+	if !ok {
+		res.Error = fmt.Sprintf("No job writes %s", a.OutFile)
+		return res
+	}
+	if a.Query != "" {
+		query = a.Query
+		params.bound.set(a.OutFile, a.Args)
+	}
+	if a.Path != "" {
+		params.paths.set(a.OutFile, a.Path)
+	}
+	var l *ledger
+	if params.Ledger != "" {
+		l = &ledger{Jobs: map[string]*ledgerEntry{}}
+		if a.Entry != nil {
+			l.Jobs[a.OutFile] = a.Entry
+		}
+	}
+	r := newRunReport("")
+	log.Printf("Running %s\n", a.OutFile)
+	if err := exportData(ctx, dbs[params.JobTenants[a.OutFile]], params, l, r, contracts[a.OutFile], query, a.OutFile); err != nil {
+		var delivered *deliveredError
+		res.Error, res.Delivered = err.Error(), errors.As(err, &delivered)
+	}
+	if u, ok := r.usageFor(a.OutFile); ok {
+		res.Usage = &u
+	}
+	for _, c := range r.schemaFor(a.OutFile) {
+		res.Schema = append(res.Schema, wireColumn{Name: c.Name, DBType: c.DBType, Nullable: c.Nullable, Precision: c.Precision, Scale: c.Scale})
+	}
+	if e := l.entry(a.OutFile); e != nil && e != a.Entry {
+		res.Entry = e
+	}
+	return res
+}
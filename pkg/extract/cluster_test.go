@@ -0,0 +1,290 @@
+package extract
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io"
+	"log"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+func TestCoordinatorReassignsJobs(t *testing.T) {
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	timeout := workerTimeout
+	workerTimeout = 40 * time.Millisecond
+	t.Cleanup(func() { workerTimeout = timeout })
+
+	c := newCoordinator(&config{digest: "abc"})
+	c.token = "secret"
+	report := newRunReport("")
+	run := c.dispatch(nil, report)
+	done := make(chan error, 1)
+	go func() { done <- run(context.Background(), "", "select 1", "a.csv") }()
+
+	if _, err := c.register(&registerRequest{Worker: "w0", Digest: "other", Token: "secret"}); err == nil {
+		t.Fatal("a worker with another configuration registered")
+	}
+	first, err := c.register(&registerRequest{Worker: "w1", Digest: "abc", Token: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var a *assignment
+	for a == nil || a.Wait {
+		if a, err = c.next(&workerRequest{ID: first.ID}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if a.OutFile != "a.csv" {
+		t.Fatalf("assigned %+v", a)
+	}
+
+	// w1 goes silent, so its job goes to w2
+	stop := make(chan struct{})
+	defer close(stop)
+	go c.reap(stop)
+	second, _ := c.register(&registerRequest{Worker: "w2", Digest: "abc", Token: "secret"})
+	deadline := time.Now().Add(time.Second)
+	for {
+		c.heartbeat(&workerRequest{ID: second.ID})
+		if a, err = c.next(&workerRequest{ID: second.ID}); err != nil {
+			t.Fatal(err)
+		}
+		if a.OutFile == "a.csv" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the job was not reassigned")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if _, err := c.next(&workerRequest{ID: first.ID}); err == nil {
+		t.Error("the timed out worker could still poll")
+	}
+
+	usage := &jobUsage{OutFile: "a.csv", Rows: 7}
+	if _, err := c.report(&jobResult{ID: second.ID, OutFile: "a.csv", Usage: usage, Schema: []wireColumn{{Name: "id", DBType: "INT"}}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if report.rowsFor("a.csv") != 7 || len(report.schemaFor("a.csv")) != 1 {
+		t.Errorf("the worker's usage did not reach the report: %+v", report.Jobs)
+	}
+}
+
+func TestCoordinatorFailsUnreplayableJobs(t *testing.T) {
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	timeout := workerTimeout
+	workerTimeout = 40 * time.Millisecond
+	t.Cleanup(func() { workerTimeout = timeout })
+
+	c := newCoordinator(&config{digest: "abc"})
+	c.token = "secret"
+	run := c.dispatch(nil, newRunReport(""))
+	done := make(chan error, 1)
+	go func() { done <- run(context.Background(), "", "select 1", "loader://x") }()
+
+	first, err := c.register(&registerRequest{Worker: "w1", Digest: "abc", Token: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var a *assignment
+	for a == nil || a.Wait {
+		if a, err = c.next(&workerRequest{ID: first.ID}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// w1 goes silent after it may have loaded rows, so the job fails
+	// rather than going to w2
+	stop := make(chan struct{})
+	defer close(stop)
+	go c.reap(stop)
+	second, _ := c.register(&registerRequest{Worker: "w2", Digest: "abc", Token: "secret"})
+	for {
+		select {
+		case err := <-done:
+			var delivered *deliveredError
+			if !errors.As(err, &delivered) {
+				t.Fatalf("got %v, want a delivered error", err)
+			}
+			return
+		default:
+		}
+		c.heartbeat(&workerRequest{ID: second.ID})
+		if a, err = c.next(&workerRequest{ID: second.ID}); err != nil {
+			t.Fatal(err)
+		}
+		if a.OutFile != "" {
+			t.Fatalf("the job was reassigned: %+v", a)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestClusterOptionsRequireTLSOrInsecure(t *testing.T) {
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	if _, err := (clusterOptions{}).serverCredentials(); err == nil {
+		t.Error("the coordinator fell back to plain text")
+	}
+	if _, err := (clusterOptions{}).clientCredentials(); err == nil {
+		t.Error("the worker fell back to plain text")
+	}
+	if _, err := (clusterOptions{Insecure: true}).clientCredentials(); err != nil {
+		t.Errorf("insecure was refused: %v", err)
+	}
+	if err := (clusterOptions{CertFile: "c.pem", KeyFile: "c.key", Insecure: true}).validate(); err == nil {
+		t.Error("insecure with a certificate is valid")
+	}
+}
+
+func TestCoordinatorService(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := grpc.NewServer(grpc.ForceServerCodec(jsonCodec{}))
+	c := newCoordinator(&config{digest: "abc", limit: 5})
+	c.token = "secret"
+	srv.RegisterService(&clusterService, c)
+	go srv.Serve(lis)
+	defer srv.Stop()
+
+	conn, err := grpc.NewClient(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()), grpc.WithDefaultCallOptions(grpc.ForceCodec(jsonCodec{})))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	cc := clusterClient{conn}
+	var reg registerReply
+	if err := cc.call("Register", &registerRequest{Worker: "w", Digest: "abc"}, &reg); err == nil {
+		t.Error("registered without the token")
+	}
+	if err := cc.call("Register", &registerRequest{Worker: "w", Digest: "abc", Token: "secret"}, &reg); err != nil {
+		t.Fatal(err)
+	}
+	if reg.ID == "" || reg.Limit != 5 {
+		t.Errorf("got %+v", reg)
+	}
+	c.mu.Lock()
+	c.done = true
+	c.mu.Unlock()
+	var a assignment
+	if err := cc.call("Next", &workerRequest{ID: reg.ID}, &a); err != nil || !a.Done {
+		t.Errorf("got %+v, %v, want done", a, err)
+	}
+}
+
+func TestClusterRequiresToken(t *testing.T) {
+	t.Setenv(clusterTokenEnv, "")
+	if err := runCoordinator(context.Background(), "127.0.0.1:0", &config{}, nil); err == nil || !strings.Contains(err.Error(), clusterTokenEnv) {
+		t.Errorf("coordinator started without a token: %v", err)
+	}
+	if err := runWorker(context.Background(), "127.0.0.1:1", "w", 1, &config{}); err == nil || !strings.Contains(err.Error(), clusterTokenEnv) {
+		t.Errorf("worker started without a token: %v", err)
+	}
+	c := newCoordinator(&config{digest: "abc"})
+	if _, err := c.register(&registerRequest{Worker: "w", Digest: "abc"}); err == nil {
+		t.Error("registered with an empty token")
+	}
+}
+
+func TestCoordinatorServiceTLS(t *testing.T) {
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	dir := t.TempDir()
+	o := clusterOptions{CertFile: filepath.Join(dir, "coord.pem"), KeyFile: filepath.Join(dir, "coord.key"), CAFile: filepath.Join(dir, "coord.pem")}
+	writeTestCertificate(t, o.CertFile, o.KeyFile, "127.0.0.1")
+	if err := (clusterOptions{CertFile: o.CertFile}).validate(); err == nil {
+		t.Error("a certificate without its key is valid")
+	}
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	creds, err := o.serverCredentials()
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := grpc.NewServer(grpc.Creds(creds), grpc.ForceServerCodec(jsonCodec{}))
+	c := newCoordinator(&config{digest: "abc"})
+	c.token = "secret"
+	srv.RegisterService(&clusterService, c)
+	go srv.Serve(lis)
+	defer srv.Stop()
+
+	register := func(creds credentials.TransportCredentials) error {
+		conn, err := grpc.NewClient(lis.Addr().String(), grpc.WithTransportCredentials(creds), grpc.WithDefaultCallOptions(grpc.ForceCodec(jsonCodec{})))
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		var reg registerReply
+		return clusterClient{conn}.call("Register", &registerRequest{Worker: "w", Digest: "abc", Token: "secret"}, &reg)
+	}
+	client, err := o.clientCredentials()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := register(client); err != nil {
+		t.Errorf("could not register over TLS: %v", err)
+	}
+	if err := register(insecure.NewCredentials()); err == nil {
+		t.Error("registered without TLS")
+	}
+}
+
+// writeTestCertificate writes a self-signed certificate for host and its key.
+func writeTestCertificate(t *testing.T, certFile, keyFile, host string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: host},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.ParseIP(host)},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
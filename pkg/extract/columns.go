@@ -0,0 +1,277 @@
+package extract
+
+import (
+	"database/sql"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// column describes one column of a query result as seen by the output writers.
+type column struct {
+	Name      string
+	DBType    string
+	ScanType  reflect.Type
+	Nullable  bool
+	Precision int64
+	Scale     int64
+	// Sources are the query result columns a computed column is calculated
+	// from, so it is classified as strictly as they are.
+	Sources []string
+}
+
+// valueKind is the logical type of a column used by type-aware output formats.
+type valueKind int
+
+const (
+	kindString valueKind = iota
+	kindInt
+	kindFloat
+	kindBool
+	kindDecimal
+	kindDate
+	kindTimestamp
+	kindBytes
+)
+
+// String returns the logical type name used in schema contracts.
+func (k valueKind) String() string {
+	switch k {
+	case kindInt:
+		return "integer"
+	case kindFloat:
+		return "float"
+	case kindBool:
+		return "boolean"
+	case kindDecimal:
+		return "decimal"
+	case kindDate:
+		return "date"
+	case kindTimestamp:
+		return "timestamp"
+	case kindBytes:
+		return "binary"
+	default:
+		return "string"
+	}
+}
+
+// newColumns converts the driver column metadata into output columns.
+func newColumns(types []*sql.ColumnType) []column {
+	cols := make([]column, len(types))
+	for i, t := range types {
+		cols[i] = column{
+			Name:     t.Name(),
+			DBType:   strings.ToUpper(t.DatabaseTypeName()),
+			ScanType: t.ScanType(),
+		}
+		if nullable, ok := t.Nullable(); ok {
+			cols[i].Nullable = nullable
+		} else {
+			cols[i].Nullable = true
+		}
+		if precision, scale, ok := t.DecimalSize(); ok {
+			cols[i].Precision, cols[i].Scale = precision, scale
+		}
+	}
+	return cols
+}
+
+// columnNames returns the names of cols in order.
+func columnNames(cols []column) []string {
+	names := make([]string, len(cols))
+	for i, c := range cols {
+		names[i] = c.Name
+	}
+	return names
+}
+
+// logicalTypes maps the logical type names to the database type used for them.
+var logicalTypes = map[string]string{
+	"string":    "NVARCHAR",
+	"integer":   "BIGINT",
+	"float":     "FLOAT",
+	"boolean":   "BIT",
+	"decimal":   "DECIMAL",
+	"date":      "DATE",
+	"timestamp": "DATETIME2",
+	"binary":    "VARBINARY",
+}
+
+var columnTypePattern = regexp.MustCompile(`^([A-Za-z][A-Za-z0-9_ ]*?)\s*(?:\(\s*(\d+)\s*(?:,\s*(\d+)\s*)?\))?$`)
+
+// parseColumnType builds a nullable column from a type name such as
+// DECIMAL(10,2), NVARCHAR or one of the logical type names.
+func parseColumnType(name, typ string) (column, error) {
+	m := columnTypePattern.FindStringSubmatch(strings.TrimSpace(typ))
+	if m == nil {
+		return column{}, fmt.Errorf("invalid type '%s'", typ)
+	}
+	c := column{Name: name, DBType: strings.ToUpper(m[1]), Nullable: true}
+	if t, ok := logicalTypes[strings.ToLower(m[1])]; ok {
+		c.DBType = t
+	}
+	if m[2] != "" {
+		c.Precision, _ = strconv.ParseInt(m[2], 10, 64)
+		c.Scale, _ = strconv.ParseInt(m[3], 10, 64)
+	} else if c.DBType == "DECIMAL" || c.DBType == "NUMERIC" {
+		c.Precision = 18
+	}
+	return c, nil
+}
+
+// kind classifies the column by its database type, falling back to the
+// driver's scan type for names this tool does not recognize.
+func (c column) kind() valueKind {
+	switch c.DBType {
+	case "BIT", "BOOL", "BOOLEAN":
+		return kindBool
+	case "TINYINT", "SMALLINT", "INT", "INTEGER", "BIGINT", "INT2", "INT4", "INT8", "MEDIUMINT":
+		return kindInt
+	case "REAL", "FLOAT", "FLOAT4", "FLOAT8", "DOUBLE":
+		return kindFloat
+	case "DECIMAL", "NUMERIC", "MONEY", "SMALLMONEY":
+		if c.Precision > 0 && c.Precision <= 38 {
+			return kindDecimal
+		}
+		return kindString
+	case "DATE":
+		return kindDate
+	case "DATETIME", "DATETIME2", "SMALLDATETIME", "DATETIMEOFFSET":
+		return kindTimestamp
+	case "BINARY", "VARBINARY", "IMAGE", "BLOB", "BYTEA":
+		return kindBytes
+	}
+
+	if c.ScanType == nil {
+		return kindString
+	}
+	switch c.ScanType.Kind() {
+	case reflect.Bool:
+		return kindBool
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return kindInt
+	case reflect.Float32, reflect.Float64:
+		return kindFloat
+	}
+	if c.ScanType == reflect.TypeOf(time.Time{}) {
+		return kindTimestamp
+	}
+	return kindString
+}
+
+// formatValue renders a driver value as text the same way database/sql does
+// when scanning into a []byte, so text formats keep their historical output.
+func formatValue(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	case float32:
+		return strconv.FormatFloat(float64(v), 'g', -1, 32)
+	case bool:
+		return strconv.FormatBool(v)
+	}
+
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(rv.Int(), 10)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(rv.Uint(), 10)
+	}
+	return fmt.Sprint(v)
+}
+
+// asInt64 converts an integer driver value, which some drivers return as text.
+func asInt64(v any) (int64, error) {
+	switch v := v.(type) {
+	case int64:
+		return v, nil
+	case []byte:
+		return strconv.ParseInt(string(v), 10, 64)
+	case string:
+		return strconv.ParseInt(v, 10, 64)
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return rv.Int(), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return int64(rv.Uint()), nil
+	}
+	return 0, fmt.Errorf("cannot convert %T to an integer", v)
+}
+
+// asFloat64 converts a floating point driver value.
+func asFloat64(v any) (float64, error) {
+	switch v := v.(type) {
+	case float64:
+		return v, nil
+	case float32:
+		return float64(v), nil
+	case int64:
+		return float64(v), nil
+	case []byte:
+		return strconv.ParseFloat(string(v), 64)
+	case string:
+		return strconv.ParseFloat(v, 64)
+	}
+	return 0, fmt.Errorf("cannot convert %T to a float", v)
+}
+
+// asBool converts a boolean driver value.
+func asBool(v any) (bool, error) {
+	switch v := v.(type) {
+	case bool:
+		return v, nil
+	case int64:
+		return v != 0, nil
+	case []byte:
+		return strconv.ParseBool(string(v))
+	case string:
+		return strconv.ParseBool(v)
+	}
+	return false, fmt.Errorf("cannot convert %T to a boolean", v)
+}
+
+// timeLayouts are the text forms accepted for temporal values returned as text.
+var timeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02",
+}
+
+// asTime converts a temporal driver value.
+func asTime(v any) (time.Time, error) {
+	var s string
+	switch v := v.(type) {
+	case time.Time:
+		return v, nil
+	case []byte:
+		s = string(v)
+	case string:
+		s = v
+	default:
+		return time.Time{}, fmt.Errorf("cannot convert %T to a time", v)
+	}
+	for _, layout := range timeLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("cannot parse '%s' as a time", s)
+}
@@ -0,0 +1,76 @@
+package extract
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// compressionExtensions maps the compression setting to the extension added
+// to the outfile.
+var compressionExtensions = map[string]string{
+	"gzip": ".gz",
+	"zstd": ".zst",
+}
+
+// validateCompression checks a compression setting for the job writing
+// outFile; only files, local or remote, and loader streams are compressed.
+func validateCompression(compression, outFile string) error {
+	switch compression {
+	case "", "none":
+		return nil
+	case "gzip", "zstd":
+		if strings.Contains(outFile, "://") && !isRemoteFile(outFile) && !strings.HasPrefix(outFile, "loader://") {
+			return fmt.Errorf("Compression of %s: only files and loader streams can be compressed\n", outFile)
+		}
+		return nil
+	}
+	return fmt.Errorf("Unsupported compression '%s' for %s (gzip, zstd, none)\n", compression, outFile)
+}
+
+// outputPath returns the file the job writing outFile creates: outFile, or
+// the file its template renders to in this run, with the extension of its
+// compression, unless it already ends in it.
+func (c *config) outputPath(outFile string) string {
+	path := outFile
+	if p, ok := c.paths.get(outFile); ok {
+		path = p
+	}
+	ext := compressionExtensions[c.compression(outFile)]
+	// a pipe is named by its reader
+	if ext == "" || isPipe(path) || strings.HasSuffix(strings.ToLower(path), ext) {
+		return path
+	}
+	return path + ext
+}
+
+// newCompressor returns a writer compressing into w, or nil when the
+// compression is none.
+func newCompressor(compression string, w io.Writer) (io.WriteCloser, error) {
+	switch compression {
+	case "gzip":
+		return gzip.NewWriter(w), nil
+	case "zstd":
+		return zstd.NewWriter(w)
+	}
+	return nil, nil
+}
+
+// newDecompressor returns a reader decompressing r, or nil when the
+// compression is none.
+func newDecompressor(compression string, r io.Reader) (io.ReadCloser, error) {
+	switch compression {
+	case "gzip":
+		return gzip.NewReader(r)
+	case "zstd":
+		d, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return d.IOReadCloser(), nil
+	}
+	return nil, nil
+}
@@ -0,0 +1,66 @@
+package extract
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestCompressedOutput(t *testing.T) {
+	registerFake("numbers", &fakeQuery{sets: []*fakeResult{numbersResult(3)}})
+	want := "id,name\n1,name 1\n2,name 2\n3,\n"
+	for _, tc := range []struct {
+		compression, outFile, file string
+		open                       func(io.Reader) (io.Reader, error)
+	}{
+		{"gzip", "out.csv", "out.csv.gz", func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) }},
+		{"zstd", "out.csv", "out.csv.zst", func(r io.Reader) (io.Reader, error) { return zstd.NewReader(r) }},
+		// the extension is not added twice
+		{"gzip", "out.csv.GZ", "out.csv.GZ", func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) }},
+	} {
+		dir := t.TempDir()
+		outFile := filepath.Join(dir, tc.outFile)
+		c := &config{Extracts: []extract{{Name: "numbers", OutFile: outFile, Compression: tc.compression}}}
+		if _, err := exportFake(t, c, "numbers", outFile); err != nil {
+			t.Fatal(err)
+		}
+		data, err := os.ReadFile(filepath.Join(dir, tc.file))
+		if err != nil {
+			t.Fatalf("%s: %v", tc.compression, err)
+		}
+		r, err := tc.open(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != want {
+			t.Errorf("%s: got %q, want %q", tc.compression, got, want)
+		}
+	}
+}
+
+func TestValidateCompression(t *testing.T) {
+	for _, tc := range []struct {
+		compression, outFile string
+		ok                   bool
+	}{
+		{"", "a.csv", true},
+		{"none", "a.csv", true},
+		{"zstd", "a.csv", true},
+		{"brotli", "a.csv", false},
+		{"gzip", "snowflake://db.public.orders", false},
+		{"zstd", "s3://bucket/a.csv", true},
+	} {
+		if err := validateCompression(tc.compression, tc.outFile); (err == nil) != tc.ok {
+			t.Errorf("%s for %s: %v", tc.compression, tc.outFile, err)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package extract
+
+import (
+	"fmt"
+	"strings"
+)
+
+// computedColumn is an output column calculated from the row and the run.
+type computedColumn struct {
+	Name string `yaml:"name"`
+	Expr string `yaml:"expr"`
+	// Type overrides the inferred output type, e.g. DATE or DECIMAL(12,2).
+	Type string `yaml:"type"`
+}
+
+// validateComputed checks the names, expressions and types of computed columns.
+func validateComputed(computed map[string][]computedColumn) error {
+	for outFile, ccs := range computed {
+		for _, cc := range ccs {
+			if cc.Name == "" {
+				return fmt.Errorf("Computed column for %s has no name\n", outFile)
+			}
+			if _, err := parseExpr(cc.Expr); err != nil {
+				return fmt.Errorf("Invalid computed column %s for %s: %v\n", cc.Name, outFile, err)
+			}
+			if cc.Type != "" {
+				if _, err := parseColumnType(cc.Name, cc.Type); err != nil {
+					return fmt.Errorf("Invalid computed column %s for %s: %v\n", cc.Name, outFile, err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// runVariables returns the run metadata expressions can reference.
+func runVariables(c *config, outFile string) map[string]exprVar {
+	// renderQueries has already failed the run if the fiscal lookup does
+	t, _ := newRunTemplate(c, outFile)
+	vars := map[string]exprVar{
+		"run_date":        {t.RunDate.Time, "DATE"},
+		"run_time":        {c.started, "DATETIME2"},
+		"source_server":   {c.Server, "NVARCHAR"},
+		"source_database": {c.Database, "NVARCHAR"},
+		"outfile":         {outFile, "NVARCHAR"},
+		"iso_year":        {int64(t.ISOYear), "INT"},
+		"iso_week":        {int64(t.ISOWeek), "INT"},
+		"fiscal_year":     {int64(t.FiscalYear), "INT"},
+		"fiscal_quarter":  {int64(t.FiscalQuarter), "INT"},
+		"fiscal_period":   {int64(t.FiscalPeriod), "INT"},
+		"fiscal_week":     {int64(t.FiscalWeek), "INT"},
+	}
+	for _, period := range periods {
+		if period != "day" {
+			start, _ := t.PeriodStart(period)
+			vars[period+"_start"] = exprVar{start.Time, "DATE"}
+		}
+	}
+	return vars
+}
+
+// computedTransform appends computed columns to every row. Each expression
+// may reference the columns computed before it.
+type computedTransform struct {
+	columns []computedColumn
+	vars    map[string]exprVar
+	exprs   []exprNode
+}
+
+func (t *computedTransform) Setup(cols []column) ([]column, error) {
+	for _, cc := range t.columns {
+		for _, c := range cols {
+			if strings.EqualFold(c.Name, cc.Name) {
+				return nil, fmt.Errorf("Computed column %s duplicates a column of the query result\n", cc.Name)
+			}
+		}
+		n, refs, err := compileExprRefs(cc.Expr, cols, t.vars)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid computed column %s: %v\n", cc.Name, err)
+		}
+		col := exprColumn(cc.Name, n, cols)
+		if cc.Type != "" {
+			if col, err = parseColumnType(cc.Name, cc.Type); err != nil {
+				return nil, err
+			}
+		}
+		col.Sources = columnSources(refs)
+		t.exprs = append(t.exprs, n)
+		cols = append(cols[:len(cols):len(cols)], col)
+	}
+	return cols, nil
+}
+
+// columnSources returns the query result columns behind refs: a computed
+// column stands for the columns it is calculated from.
+func columnSources(refs []column) []string {
+	var sources []string
+	for _, c := range refs {
+		if c.Sources != nil {
+			sources = append(sources, c.Sources...)
+		} else {
+			sources = append(sources, c.Name)
+		}
+	}
+	return sources
+}
+
+func (t *computedTransform) Apply(row []any) ([]any, bool, error) {
+	out := make([]any, len(row), len(row)+len(t.exprs))
+	copy(out, row)
+	for i, n := range t.exprs {
+		v, err := n.eval(out)
+		if err != nil {
+			return nil, false, fmt.Errorf("computed column %s: %v", t.columns[i].Name, err)
+		}
+		out = append(out, v)
+	}
+	return out, true, nil
+}
@@ -0,0 +1,104 @@
+package extract
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExportDataComputed(t *testing.T) {
+	registerFake("computed", &fakeQuery{sets: []*fakeResult{numbersResult(3)}})
+	outFile := filepath.Join(t.TempDir(), "out.csv")
+	c := &config{
+		Server:  "db1",
+		started: time.Date(2026, 10, 15, 9, 30, 0, 0, time.UTC),
+		Computed: map[string][]computedColumn{outFile: {
+			{Name: "next_id", Expr: "id + 1"},
+			// a computed column may use the ones before it
+			{Name: "label", Expr: "upper(coalesce(name, 'none')) || '/' || next_id"},
+			{Name: "server", Expr: "source_server"},
+			{Name: "day", Expr: "run_date"},
+		}},
+	}
+	if err := validateComputed(c.Computed); err != nil {
+		t.Fatal(err)
+	}
+	got, err := exportFake(t, c, "computed", outFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "id,name,next_id,label,server,day\n" +
+		"1,name 1,2,NAME 1/2,db1,2026-10-15T00:00:00Z\n" +
+		"2,name 2,3,NAME 2/3,db1,2026-10-15T00:00:00Z\n" +
+		"3,,4,NONE/4,db1,2026-10-15T00:00:00Z\n"
+	if got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
+
+func TestComputedErrors(t *testing.T) {
+	for _, cc := range []computedColumn{
+		{Name: "", Expr: "id"},
+		{Name: "x", Expr: "id +"},
+		{Name: "x", Expr: "id", Type: "DECIMAL(12"},
+	} {
+		if err := validateComputed(map[string][]computedColumn{"out.csv": {cc}}); err == nil {
+			t.Errorf("%+v is valid", cc)
+		}
+	}
+
+	registerFake("computed_errors", &fakeQuery{sets: []*fakeResult{numbersResult(3)}})
+	for _, tc := range []struct {
+		cc   computedColumn
+		want string
+	}{
+		{computedColumn{Name: "name", Expr: "id"}, "duplicates a column"},
+		{computedColumn{Name: "x", Expr: "missing + 1"}, "unknown column missing"},
+		// text only fails once a row is evaluated
+		{computedColumn{Name: "x", Expr: "name * 2"}, "is not a number"},
+	} {
+		outFile := filepath.Join(t.TempDir(), "out.csv")
+		c := &config{Computed: map[string][]computedColumn{outFile: {tc.cc}}}
+		if _, err := exportFake(t, c, "computed_errors", outFile); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%+v: error %v, want %q", tc.cc, err, tc.want)
+		}
+	}
+}
+
+func TestComputedTypes(t *testing.T) {
+	cols := []column{
+		{Name: "id", DBType: "BIGINT"},
+		{Name: "amount", DBType: "DECIMAL", Precision: 10, Scale: 2},
+		{Name: "name", DBType: "NVARCHAR"},
+	}
+	vars := map[string]exprVar{"run_date": {time.Now(), "DATE"}}
+	for _, tc := range []struct {
+		expr, want string
+	}{
+		{"amount", "DECIMAL"},
+		{"run_date", "DATE"},
+		{"2.5", "FLOAT"},
+		{"'x'", "NVARCHAR"},
+		{"id + 1", "BIGINT"},
+		{"-id % 3", "BIGINT"},
+		{"id / 2", "FLOAT"},
+		{"amount * 1.1", "FLOAT"},
+		{"id > 1 AND name IS NOT NULL", "BIT"},
+		{"NOT name LIKE 'a%'", "BIT"},
+		{"id IN (1, 2)", "BIT"},
+		{"name || id", "NVARCHAR"},
+		{"length(name)", "BIGINT"},
+		{"upper(name)", "NVARCHAR"},
+		{"coalesce(NULL, id, 0)", "BIGINT"},
+		{"coalesce(id, name)", "NVARCHAR"},
+	} {
+		n, err := compileExpr(tc.expr, cols, vars)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.expr, err)
+		}
+		if got := exprColumn("x", n, cols); got.DBType != tc.want || got.Name != "x" || !got.Nullable {
+			t.Errorf("%s: got %+v, want a nullable %s", tc.expr, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,239 @@
+package extract
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// checksumSuffix is appended to a concatenated file's path to name its
+// checksum file, which sha256sum -c reads.
+const checksumSuffix = ".sha256"
+
+// validateConcat checks the concat job e: its inputs are jobs writing local
+// files in its format and delimiter, or local paths, and the format is one
+// whose files can be joined.
+func validateConcat(c *config, e extract) error {
+	if len(e.Inputs) == 0 {
+		return fmt.Errorf("Concat job %s has no inputs\n", e.Name)
+	}
+	if e.Query != "" {
+		return fmt.Errorf("Concat job %s reads files, so it cannot have a query\n", e.Name)
+	}
+	if strings.Contains(e.OutFile, "://") {
+		return fmt.Errorf("Concat job %s must write a local file\n", e.Name)
+	}
+	format := concatFormat(c, e.OutFile)
+	if format != "csv" && format != "jsonl" {
+		return fmt.Errorf("Concat job %s cannot join %s files, only csv and jsonl\n", e.Name, format)
+	}
+	if compression := c.compression(e.OutFile); compression != "" && compression != "none" {
+		return fmt.Errorf("Concat job %s cannot compress its file\n", e.Name)
+	}
+	for _, input := range e.Inputs {
+		dep, ok := c.extractNamed(input)
+		if !ok {
+			if strings.Contains(input, "://") {
+				return fmt.Errorf("Concat job %s has input %s, which is not a local file\n", e.Name, input)
+			}
+			continue
+		}
+		if dep.OutFile == e.OutFile {
+			return fmt.Errorf("Concat job %s cannot read its own file\n", e.Name)
+		}
+		if strings.Contains(dep.OutFile, "://") {
+			return fmt.Errorf("Concat job %s reads %s, which does not write a local file\n", e.Name, dep.Name)
+		}
+		if compression := c.compression(dep.OutFile); compression != "" && compression != "none" {
+			return fmt.Errorf("Concat job %s reads %s, which is compressed\n", e.Name, dep.Name)
+		}
+		if depFormat := concatFormat(c, dep.OutFile); depFormat != format {
+			return fmt.Errorf("Concat job %s writes %s but reads %s, which writes %s\n", e.Name, format, dep.Name, depFormat)
+		}
+		if format == "csv" && c.delimiter(dep.OutFile) != c.delimiter(e.OutFile) {
+			return fmt.Errorf("Concat job %s reads %s, whose delimiter %q differs from its own %q\n", e.Name, dep.Name, c.delimiter(dep.OutFile), c.delimiter(e.OutFile))
+		}
+	}
+	return nil
+}
+
+// concatFormat returns the format of the job writing outFile, csv unless set.
+func concatFormat(c *config, outFile string) string {
+	if format := c.format(outFile); format != "" {
+		return format
+	}
+	return "csv"
+}
+
+// waitsFor returns the names of the jobs e runs after: its after list and,
+// for a concat job, the inputs naming jobs.
+func (c *config) waitsFor(e extract) []string {
+	if e.Type != jobTypeConcat {
+		return e.After
+	}
+	names := append([]string(nil), e.After...)
+	for _, input := range e.Inputs {
+		if _, ok := c.extractNamed(input); ok {
+			names = append(names, input)
+		}
+	}
+	return names
+}
+
+// concatInputs returns the files the concat job e joins, in order. Inputs
+// naming jobs are their files; others are paths or patterns, whose matches
+// are taken in name order. A pattern matching nothing is an error.
+func (c *config) concatInputs(e extract) ([]string, []string, error) {
+	var files, jobs []string
+	for _, input := range e.Inputs {
+		if dep, ok := c.extractNamed(input); ok {
+			files = append(files, c.outputPath(dep.OutFile))
+			jobs = append(jobs, dep.OutFile)
+			continue
+		}
+		matches, err := filepath.Glob(input)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Invalid input pattern %s of %s: %v\n", input, e.Name, err)
+		}
+		if len(matches) == 0 {
+			return nil, nil, fmt.Errorf("No file matches input %s of %s\n", input, e.Name)
+		}
+		sort.Strings(matches)
+		files = append(files, matches...)
+	}
+	return files, jobs, nil
+}
+
+// concatFiles runs the concat job writing outFile: its inputs are joined
+// into one file, keeping only the first file's header for csv, which the
+// others have to repeat, and the SHA-256 of the result is written next to it. The file is written under a
+// temporary name and renamed, so readers never see part of it.
+func concatFiles(c *config, r *runReport, outFile string) error {
+	started := time.Now()
+	e, _ := c.extract(outFile)
+	files, jobs, err := c.concatInputs(e)
+	if err != nil {
+		return err
+	}
+	path := c.outputPath(outFile)
+	if dir := filepath.Dir(path); dir != "" {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return fmt.Errorf("Could not create directory %s: %v\n", dir, err)
+		}
+	}
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return fmt.Errorf("Could not create file %s: %v\n", tmp, err)
+	}
+	h := sha256.New()
+	count := &countingWriter{w: c.throttle(f, outFile)}
+	w := bufio.NewWriter(io.MultiWriter(count, h))
+	var header *string
+	if concatFormat(c, outFile) == "csv" {
+		header = new(string)
+	}
+	for _, file := range files {
+		if err := appendFile(w, file, header); err != nil {
+			f.Close()
+			os.Remove(tmp)
+			return fmt.Errorf("Could not append %s to %s: %v\n", file, path, err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return fmt.Errorf("Could not write file %s: %v\n", path, err)
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("Could not write file %s: %v\n", path, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("Could not write file %s: %v\n", path, err)
+	}
+	sum := hex.EncodeToString(h.Sum(nil))
+	line := fmt.Sprintf("%s  %s\n", sum, filepath.Base(path))
+	if err := os.WriteFile(path+checksumSuffix, []byte(line), 0o644); err != nil {
+		return fmt.Errorf("Could not write checksum %s: %v\n", path+checksumSuffix, err)
+	}
+
+	// the rows are known when every input is a job of this run
+	var rows uint
+	if len(jobs) == len(files) {
+		for _, job := range jobs {
+			rows += r.rowsFor(job)
+		}
+	}
+	if len(jobs) > 0 {
+		r.schema(outFile, r.schemaFor(jobs[0]))
+	}
+	written := count.n.Load()
+	c.logJob(outFile, fmt.Sprintf("Joined %d file(s) into %s (sha256 %s)\n", len(files), path, sum),
+		slog.String("state", jobDone), slog.Int("files", len(files)), slog.Int64("bytes_written", written),
+		slog.String("sha256", sum), slog.Float64("duration_seconds", time.Since(started).Seconds()))
+	r.job(outFile, started, rows, written)
+	return nil
+}
+
+// appendFile copies the file at path to w. With header set, every file
+// starts with the same header line: the first file's is copied and kept in
+// header, and the others' is dropped, which fails when it differs. A file
+// without a trailing newline gets one, so the next file starts on a line of
+// its own.
+func appendFile(w *bufio.Writer, path string, header *string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	br := bufio.NewReader(f)
+	var last byte
+	if header != nil {
+		line, err := br.ReadString('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+		switch first := strings.TrimRight(line, "\r\n"); {
+		case line == "":
+		case *header == "":
+			*header = first
+			if _, err := w.WriteString(line); err != nil {
+				return err
+			}
+			last = line[len(line)-1]
+		case first != *header:
+			return fmt.Errorf("its header %q differs from %q of the first file", first, *header)
+		}
+	}
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := br.Read(buf)
+		if n > 0 {
+			if _, err := w.Write(buf[:n]); err != nil {
+				return err
+			}
+			last = buf[n-1]
+		}
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if last != 0 && last != '\n' {
+		return w.WriteByte('\n')
+	}
+	return nil
+}
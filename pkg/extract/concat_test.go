@@ -0,0 +1,87 @@
+package extract
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestConcatFiles(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, data string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	part1 := write("orders_1.csv", "id,name\n1,a\n")
+	write("orders_2.csv", "id,name\n2,b\n3,c")
+	write("orders_3.csv", "id,name\n")
+	outFile := filepath.Join(dir, "out", "orders.csv")
+	c := &config{Extracts: []extract{
+		{Name: "first", OutFile: part1, Query: "q"},
+		{Name: "orders", Type: jobTypeConcat, OutFile: outFile, Inputs: []string{"first", filepath.Join(dir, "orders_[23].csv")}},
+	}}
+	if err := validateJobTypes(c); err != nil {
+		t.Fatal(err)
+	}
+	if got := c.dependencies(outFile); !reflect.DeepEqual(got, []string(nil)) {
+		t.Errorf("dependencies outside the run %v", got)
+	}
+	c.OutFiles = []string{part1, outFile}
+	if got := c.dependencies(outFile); !reflect.DeepEqual(got, []string{part1}) {
+		t.Errorf("dependencies %v", got)
+	}
+
+	if _, err := exportFake(t, c, "", outFile); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(outFile)
+	if want := "id,name\n1,a\n2,b\n3,c\n"; string(data) != want {
+		t.Errorf("got %q, want %q", data, want)
+	}
+	sum := sha256.Sum256(data)
+	checksum, _ := os.ReadFile(outFile + checksumSuffix)
+	if want := hex.EncodeToString(sum[:]) + "  orders.csv\n"; string(checksum) != want {
+		t.Errorf("checksum %q, want %q", checksum, want)
+	}
+
+	write("orders_4.csv", "order_id,name\n4,d\n")
+	c.Extracts[1].Inputs = []string{"first", filepath.Join(dir, "orders_4.csv")}
+	if _, err := exportFake(t, c, "", outFile); err == nil || !strings.Contains(err.Error(), "differs") {
+		t.Errorf("joined files with different headers: %v", err)
+	}
+	if got, _ := os.ReadFile(outFile); string(got) != string(data) {
+		t.Errorf("a failed join replaced the file with %q", got)
+	}
+
+	c.Extracts[1].Inputs = []string{filepath.Join(dir, "missing_*.csv")}
+	if _, err := exportFake(t, c, "", outFile); err == nil || !strings.Contains(err.Error(), "No file matches") {
+		t.Errorf("joined no files: %v", err)
+	}
+}
+
+func TestValidateConcat(t *testing.T) {
+	for _, e := range []extract{
+		{Name: "orders", Type: jobTypeConcat, OutFile: "orders.csv"},
+		{Name: "orders", Type: jobTypeConcat, OutFile: "orders.csv", Inputs: []string{"s3://bucket/part.csv"}},
+		{Name: "orders", Type: jobTypeConcat, OutFile: "orders.parquet", Format: "parquet", Inputs: []string{"parts/*.parquet"}},
+		{Name: "orders", Type: jobTypeConcat, OutFile: "orders.csv", Query: "SELECT 1", Inputs: []string{"parts/*.csv"}},
+		{Name: "orders", Type: jobTypeConcat, OutFile: "orders.csv", Inputs: []string{"json_part"}},
+		{Name: "orders", Type: jobTypeConcat, OutFile: "orders.csv", Inputs: []string{"piped_part"}},
+	} {
+		c := &config{Delimiter: ",", Extracts: []extract{
+			e,
+			{Name: "json_part", OutFile: "part.jsonl", Format: "jsonl", Query: "q"},
+			{Name: "piped_part", OutFile: "part.csv", Delimiter: "|", Query: "q"},
+		}}
+		if err := validateJobTypes(c); err == nil {
+			t.Errorf("%+v is valid", e)
+		}
+	}
+}
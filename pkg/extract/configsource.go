@@ -0,0 +1,416 @@
+package extract
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// -config env: reads the configuration from the environment instead of a
+// file: the shared settings from configEnv and one job from each variable
+// starting with jobEnvPrefix.
+const (
+	envConfigSource = "env:"
+	configEnv       = "SQL_EXPORT_WIZ_CONFIG"
+	jobEnvPrefix    = "SQL_EXPORT_WIZ_JOB_"
+)
+
+// configDoc is one YAML document of the configuration.
+type configDoc struct {
+	name string
+	data []byte
+}
+
+// jobDoc is the shorthand for a document holding a single job.
+type jobDoc struct {
+	Query   string `yaml:"query"`
+	OutFile string `yaml:"outfile"`
+}
+
+// extract is one job of the extracts list. Delimiter, Format and NullString
+// override the shared settings for this job, and Timeout fails it if it runs longer.
+// QueryFile names a file holding the query instead, relative to the file of
+// the configuration. Params are bound to the query's @name parameters. Type
+// is schema for a job that only describes its result, or concat for one
+// joining the files of Inputs, jobs or paths, instead of running a query.
+type extract struct {
+	Name        string         `yaml:"name"`
+	Type        string         `yaml:"type"`
+	Query       string         `yaml:"query"`
+	QueryFile   string         `yaml:"query_file"`
+	OutFile     string         `yaml:"outfile"`
+	Delimiter   string         `yaml:"delimiter"`
+	Format      string         `yaml:"format"`
+	NullString  *string        `yaml:"null_string"`
+	Compression string         `yaml:"compression"`
+	Timeout     configDuration `yaml:"timeout"`
+	// Retries and RetryBackoff override the top-level settings.
+	Retries      *int              `yaml:"retries"`
+	RetryBackoff configDuration    `yaml:"retry_backoff"`
+	Params       map[string]string `yaml:"params"`
+	// Ready waits for the upstream load before the extract runs.
+	Ready readyOptions `yaml:"ready"`
+	// After names the jobs this one waits for, whose values captured by
+	// name in Capture its query template reads with .Var.
+	After   []string                  `yaml:"after"`
+	Capture map[string]captureOptions `yaml:"capture"`
+	// WriteRate caps the bytes per second the job writes to its file.
+	WriteRate byteSize `yaml:"write_rate"`
+	// Direct writes the job's file bypassing the page cache, where supported.
+	Direct bool `yaml:"direct"`
+	// PipeWait is how long the job waits for a reader when outfile is a pipe.
+	PipeWait configDuration `yaml:"pipe_wait"`
+	// Schedule is the cron expression -daemon runs the job on.
+	Schedule string `yaml:"schedule"`
+	// Incremental extracts only the rows past the last run's watermark.
+	Incremental incrementalOptions `yaml:"incremental"`
+	Inputs      []string           `yaml:"inputs"`
+}
+
+// extract returns the item of the extracts list writing outFile.
+func (c *config) extract(outFile string) (extract, bool) {
+	for _, e := range c.Extracts {
+		if e.OutFile == outFile {
+			return e, true
+		}
+	}
+	return extract{}, false
+}
+
+// delimiter returns the delimiter of the job writing outFile, a comma unless set.
+func (c *config) delimiter(outFile string) string {
+	if e, ok := c.extract(outFile); ok && e.Delimiter != "" {
+		return e.Delimiter
+	}
+	if c.Delimiter == "" {
+		return ","
+	}
+	return c.Delimiter
+}
+
+// format returns the output format of the job writing outFile.
+func (c *config) format(outFile string) string {
+	if e, ok := c.extract(outFile); ok && e.Format != "" {
+		return e.Format
+	}
+	return c.Format
+}
+
+// compression returns how the file of the job writing outFile is compressed.
+func (c *config) compression(outFile string) string {
+	if e, ok := c.extract(outFile); ok && e.Compression != "" {
+		return e.Compression
+	}
+	return c.Compression
+}
+
+// nullString returns the text the job writing outFile writes for NULL in
+// delimited output.
+func (c *config) nullString(outFile string) string {
+	if e, ok := c.extract(outFile); ok && e.NullString != nil {
+		return *e.NullString
+	}
+	return c.NullString
+}
+
+// timeout returns how long the job writing outFile may run: its extract's
+// timeout, else the top-level one, or 0.
+func (c *config) timeout(outFile string) time.Duration {
+	if e, ok := c.extract(outFile); ok && e.Timeout > 0 {
+		return time.Duration(e.Timeout)
+	}
+	return time.Duration(c.Timeout)
+}
+
+// jobName returns the name of the job writing outFile: its extract's name, or
+// for the older layout the outfile itself.
+func (c *config) jobName(outFile string) string {
+	if e, ok := c.extract(outFile); ok {
+		return e.Name
+	}
+	return outFile
+}
+
+// jobsNamed returns the outfiles of the jobs with the given names or outfiles.
+func (c *config) jobsNamed(names []string) ([]string, error) {
+	var outFiles []string
+	for _, name := range names {
+		found := false
+		for _, outFile := range c.OutFiles {
+			if name == outFile || name == c.jobName(outFile) {
+				outFiles = append(outFiles, outFile)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("No job is named %s\n", name)
+		}
+	}
+	return outFiles, nil
+}
+
+// jobsMissed returns the outfiles of the jobs the ledger records no
+// successful run for since the given time.
+func (c *config) jobsMissed(since time.Time) ([]string, error) {
+	if c.Ledger == "" {
+		return nil, fmt.Errorf("Finding the jobs without a recent run needs a ledger\n")
+	}
+	l, err := loadLedger(c.Ledger, c.keys)
+	if err != nil {
+		return nil, err
+	}
+	var outFiles []string
+	for _, outFile := range c.OutFiles {
+		if e := l.entry(outFile); e == nil || e.LastRun.Before(since) {
+			outFiles = append(outFiles, outFile)
+		}
+	}
+	return outFiles, nil
+}
+
+// keepJobs drops every job not writing one of outFiles.
+func (c *config) keepJobs(outFiles []string) {
+	var queries, kept []string
+	for i, outFile := range c.OutFiles {
+		if slices.Contains(outFiles, outFile) {
+			queries = append(queries, c.Queries[i])
+			kept = append(kept, outFile)
+		}
+	}
+	c.Queries, c.OutFiles = queries, kept
+}
+
+// readConfigDocs reads the documents making up the configuration at path: a
+// YAML file, a directory of them such as a mounted ConfigMap, or the
+// environment for env:.
+func readConfigDocs(path string) ([]configDoc, error) {
+	if path == envConfigSource {
+		return envConfigDocs(os.Environ())
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.IsDir() {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		return []configDoc{{path, data}}, nil
+	}
+	names, err := configDirFiles(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("No .yaml files in %s\n", path)
+	}
+	var docs []configDoc
+	for _, name := range names {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+		docs = append(docs, configDoc{name, data})
+	}
+	return docs, nil
+}
+
+// configDirFiles lists the .yaml and .yml files of dir in name order. Hidden
+// entries are skipped, such as the ..data links Kubernetes adds to volumes.
+func configDirFiles(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, e := range entries {
+		ext := strings.ToLower(filepath.Ext(e.Name()))
+		if strings.HasPrefix(e.Name(), ".") || (ext != ".yaml" && ext != ".yml") {
+			continue
+		}
+		names = append(names, filepath.Join(dir, e.Name()))
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// envConfigDocs collects the configuration from environment variables, the
+// jobs in variable name order.
+func envConfigDocs(environ []string) ([]configDoc, error) {
+	var docs, jobs []configDoc
+	for _, kv := range environ {
+		name, value, _ := strings.Cut(kv, "=")
+		switch {
+		case name == configEnv:
+			docs = append(docs, configDoc{"$" + name, []byte(value)})
+		case strings.HasPrefix(name, jobEnvPrefix):
+			jobs = append(jobs, configDoc{"$" + name, []byte(value)})
+		}
+	}
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].name < jobs[j].name })
+	docs = append(docs, jobs...)
+	if len(docs) == 0 {
+		return nil, fmt.Errorf("Neither %s nor any %s* variable is set\n", configEnv, jobEnvPrefix)
+	}
+	return docs, nil
+}
+
+// parseConfigDocs merges the documents into one configuration and returns it
+// with the SHA-256 of its sources. A single document without query files
+// hashes as the file does.
+func parseConfigDocs(docs []configDoc) (*config, string, error) {
+	params := &config{}
+	h := sha256.New()
+	for _, d := range docs {
+		if len(docs) == 1 {
+			h.Write(d.data)
+		} else {
+			fmt.Fprintf(h, "%s\x00%d\x00", d.name, len(d.data))
+			h.Write(d.data)
+		}
+
+		doc := &config{}
+		if err := yaml.Unmarshal(d.data, doc); err != nil {
+			return nil, "", fmt.Errorf("%s: %v\n", d.name, err)
+		}
+		var job jobDoc
+		if err := yaml.Unmarshal(d.data, &job); err != nil {
+			return nil, "", fmt.Errorf("%s: %v\n", d.name, err)
+		}
+		if (job.Query == "") != (job.OutFile == "") {
+			return nil, "", fmt.Errorf("%s: a job needs both query and outfile\n", d.name)
+		}
+		if len(doc.Queries) != len(doc.OutFiles) {
+			return nil, "", fmt.Errorf("%s: %d queries but %d outfiles, run migrate-config on the file to list them as extracts\n", d.name, len(doc.Queries), len(doc.OutFiles))
+		}
+		if len(doc.Queries) > 0 {
+			log.Printf("Warning: %s: queries and outfiles are deprecated, run migrate-config to rewrite them as extracts\n", d.name)
+		}
+		if job.Query != "" {
+			doc.Queries = append(doc.Queries, job.Query)
+			doc.OutFiles = append(doc.OutFiles, job.OutFile)
+		}
+		for i, e := range doc.Extracts {
+			if e.QueryFile != "" {
+				if e.Query != "" {
+					return nil, "", fmt.Errorf("%s: extract %s has both a query and a query_file\n", d.name, e.Name)
+				}
+				path, query, err := readQueryFile(d.name, e.QueryFile)
+				if err != nil {
+					return nil, "", fmt.Errorf("%s: extract %s: %v\n", d.name, e.Name, err)
+				}
+				// the query files are part of the configuration the digest identifies
+				fmt.Fprintf(h, "%s\x00%d\x00", path, len(query))
+				h.Write([]byte(query))
+				params.queryFiles = append(params.queryFiles, path)
+				e.Query, doc.Extracts[i].Query = query, query
+			}
+			if e.Name == "" || (e.Query == "" && e.Type != jobTypeConcat) || e.OutFile == "" {
+				return nil, "", fmt.Errorf("%s: extract %d needs a name, query or query_file, and outfile\n", d.name, i+1)
+			}
+			doc.Queries = append(doc.Queries, e.Query)
+			doc.OutFiles = append(doc.OutFiles, e.OutFile)
+		}
+		if err := mergeConfig(params, doc); err != nil {
+			return nil, "", fmt.Errorf("%s: %v\n", d.name, err)
+		}
+	}
+	return params, hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// readQueryFile reads the query_file name of the configuration document doc,
+// returning its path and the query. Relative names are resolved against the
+// directory of doc, or the working directory for the environment.
+func readQueryFile(doc, name string) (string, string, error) {
+	path := name
+	if !filepath.IsAbs(path) && !strings.HasPrefix(doc, "$") {
+		path = filepath.Join(filepath.Dir(doc), name)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", "", fmt.Errorf("could not read the query_file: %v", err)
+	}
+	query := strings.TrimSpace(string(data))
+	if query == "" {
+		return "", "", fmt.Errorf("the query_file %s is empty", path)
+	}
+	return path, query, nil
+}
+
+// mergeConfig adds the settings of src to dst: lists are appended and maps
+// joined, while any other setting may only be given by one document, or by
+// several with the same value.
+func mergeConfig(dst, src *config) error {
+	d, s := reflect.ValueOf(dst).Elem(), reflect.ValueOf(src).Elem()
+	for i := 0; i < d.NumField(); i++ {
+		field := d.Type().Field(i)
+		key, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+		if key == "" || s.Field(i).IsZero() {
+			continue
+		}
+		df, sf := d.Field(i), s.Field(i)
+		switch field.Type.Kind() {
+		case reflect.Slice:
+			df.Set(reflect.AppendSlice(df, sf))
+		case reflect.Map:
+			if df.IsNil() {
+				df.Set(reflect.MakeMap(field.Type))
+			}
+			for _, k := range sf.MapKeys() {
+				if df.MapIndex(k).IsValid() {
+					return fmt.Errorf("%s for %v is set twice", key, k)
+				}
+				df.SetMapIndex(k, sf.MapIndex(k))
+			}
+		default:
+			if !df.IsZero() && !reflect.DeepEqual(df.Interface(), sf.Interface()) {
+				return fmt.Errorf("%s is set twice with different values", key)
+			}
+			df.Set(sf)
+		}
+	}
+	return nil
+}
+
+// configModTime returns the latest modification time of the configuration
+// at path and the query files of c, so -serve notices a changed job file in
+// a directory or a changed query too. The environment cannot change, so it
+// has the zero time.
+func configModTime(path string, c *config) time.Time {
+	var mod time.Time
+	if c != nil {
+		for _, name := range c.queryFiles {
+			if t := modTime(name); t.After(mod) {
+				mod = t
+			}
+		}
+	}
+	if path == envConfigSource {
+		return mod
+	}
+	if t := modTime(path); t.After(mod) {
+		mod = t
+	}
+	names, err := configDirFiles(path)
+	if err != nil {
+		return mod
+	}
+	for _, name := range names {
+		if t := modTime(name); t.After(mod) {
+			mod = t
+		}
+	}
+	return mod
+}
@@ -0,0 +1,152 @@
+package extract
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConfigDirectory(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"00-base.yaml":      "server: db1\ndatabase: sales\ndelimiter: \";\"\n",
+		"10-customers.yaml": "query: select * from dbo.Customer\noutfile: customers.csv\nfilters:\n  customers.csv: id > 0\n",
+		"20-orders.yml":     "queries: [select * from dbo.Orders]\noutfiles: [orders.csv]\n",
+		"README.md":         "not configuration",
+		".hidden.yaml":      "server: other\n",
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c, err := loadConfig(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Server != "db1" || c.Delimiter != ";" || c.Filters["customers.csv"] != "id > 0" {
+		t.Errorf("settings not merged: %+v", c)
+	}
+	if want := []string{"customers.csv", "orders.csv"}; !reflect.DeepEqual(c.OutFiles, want) {
+		t.Errorf("outfiles: got %v, want %v", c.OutFiles, want)
+	}
+
+	os.WriteFile(filepath.Join(dir, "30-conflict.yaml"), []byte("server: db2\n"), 0o644)
+	if _, err := loadConfig(dir); err == nil || !strings.Contains(err.Error(), "server is set twice") {
+		t.Errorf("conflicting server: got %v", err)
+	}
+}
+
+func TestConfigEnvironment(t *testing.T) {
+	docs, err := envConfigDocs([]string{
+		"PATH=/bin",
+		jobEnvPrefix + "B=query: select 2\noutfile: b.csv\n",
+		configEnv + "=server: db1\n",
+		jobEnvPrefix + "A=query: select 1\noutfile: a.csv\n",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, _, err := parseConfigDocs(docs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Server != "db1" || !reflect.DeepEqual(c.OutFiles, []string{"a.csv", "b.csv"}) {
+		t.Errorf("got server %q and outfiles %v", c.Server, c.OutFiles)
+	}
+
+	if _, err := envConfigDocs([]string{"PATH=/bin"}); err == nil {
+		t.Error("an empty environment was accepted")
+	}
+	if _, _, err := parseConfigDocs([]configDoc{{"job", []byte("query: select 1\n")}}); err == nil {
+		t.Error("a job without an outfile was accepted")
+	}
+}
+
+func TestConfigExtracts(t *testing.T) {
+	data := `delimiter: ","
+extracts:
+  - name: customers
+    query: numbers
+    outfile: customers.csv
+    delimiter: ";"
+  - name: orders
+    query: numbers
+    outfile: orders.json
+    format: json
+    timeout: 30m
+`
+	c, _, err := parseConfigDocs([]configDoc{{"config.yaml", []byte(data)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(c.OutFiles, []string{"customers.csv", "orders.json"}) {
+		t.Errorf("outfiles: got %v", c.OutFiles)
+	}
+	if c.delimiter("customers.csv") != ";" || c.delimiter("orders.json") != "," || c.format("orders.json") != "json" {
+		t.Errorf("overrides not applied: %+v", c.Extracts)
+	}
+	if c.timeout("orders.json") != 30*time.Minute || c.timeout("customers.csv") != 0 {
+		t.Errorf("timeouts: got %v and %v", c.timeout("orders.json"), c.timeout("customers.csv"))
+	}
+
+	registerFake("numbers", &fakeQuery{sets: []*fakeResult{numbersResult(2)}})
+	c.Extracts[0].OutFile = filepath.Join(t.TempDir(), "customers.csv")
+	got, err := exportFake(t, c, "numbers", c.Extracts[0].OutFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(got, "id;name\n") {
+		t.Errorf("got %q, want the extract's delimiter", got)
+	}
+
+	for _, bad := range []string{
+		"queries: [select 1, select 2]\noutfiles: [a.csv]\n",
+		"extracts:\n  - {query: select 1, outfile: a.csv}\n",
+	} {
+		if _, _, err := parseConfigDocs([]configDoc{{"bad", []byte(bad)}}); err == nil {
+			t.Errorf("accepted %q", bad)
+		}
+	}
+	twice := &config{Extracts: []extract{{Name: "a", OutFile: "a.csv"}, {Name: "a", OutFile: "b.csv"}}}
+	if err := twice.validate(); err == nil || !strings.Contains(err.Error(), "used twice") {
+		t.Errorf("duplicate names: got %v", err)
+	}
+}
+
+func TestConfigQueryFile(t *testing.T) {
+	dir := t.TempDir()
+	os.Mkdir(filepath.Join(dir, "sql"), 0o755)
+	os.WriteFile(filepath.Join(dir, "sql", "orders.sql"), []byte("SELECT *\nFROM dbo.Orders\nWHERE Note = 'it''s'\n"), 0o644)
+	path := filepath.Join(dir, "config.yaml")
+	os.WriteFile(path, []byte("extracts:\n  - name: orders\n    query_file: sql/orders.sql\n    outfile: orders.csv\n"), 0o644)
+	c, err := readConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "SELECT *\nFROM dbo.Orders\nWHERE Note = 'it''s'"; len(c.Queries) != 1 || c.Queries[0] != want {
+		t.Errorf("got %q, want %q", c.Queries, want)
+	}
+	if !reflect.DeepEqual(c.queryFiles, []string{filepath.Join(dir, "sql", "orders.sql")}) {
+		t.Errorf("query files: got %v", c.queryFiles)
+	}
+
+	// the digest changes with the query file
+	before := c.digest
+	os.WriteFile(filepath.Join(dir, "sql", "orders.sql"), []byte("SELECT 1\n"), 0o644)
+	if c, err = readConfig(path); err != nil || c.digest == before {
+		t.Errorf("got digest %s and %v, want a new digest", c.digest, err)
+	}
+
+	for _, bad := range []string{
+		"extracts:\n  - {name: a, query: select 1, query_file: sql/orders.sql, outfile: a.csv}\n",
+		"extracts:\n  - {name: a, query_file: sql/missing.sql, outfile: a.csv}\n",
+	} {
+		if _, _, err := parseConfigDocs([]configDoc{{path, []byte(bad)}}); err == nil {
+			t.Errorf("accepted %q", bad)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package extract
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/term"
+)
+
+// consoleMode decides how the log reads. On a terminal it is rendered for a
+// person: times without the date, colors by kind of message, counts with
+// separators and rounded durations and sizes. Piped or redirected, the log
+// keeps its plain form for the tools reading it.
+type consoleMode struct {
+	human bool
+	color bool
+}
+
+var console consoleMode
+
+// setupConsole picks the rendering of the log from where it goes. NO_COLOR
+// turns the colors off but keeps the rest.
+func setupConsole() {
+	if !term.IsTerminal(int(os.Stderr.Fd())) {
+		return
+	}
+	console.human = true
+	// the Windows console only reads escape codes under Windows Terminal
+	console.color = os.Getenv("NO_COLOR") == "" && os.Getenv("TERM") != "dumb" &&
+		(runtime.GOOS != "windows" || os.Getenv("WT_SESSION") != "")
+	log.SetFlags(log.Ltime)
+	log.SetOutput(consoleLog{os.Stderr})
+}
+
+// count writes n, with thousands separators for a person.
+func (m consoleMode) count(n int64) string {
+	s := strconv.FormatInt(n, 10)
+	if !m.human {
+		return s
+	}
+	neg := strings.HasPrefix(s, "-")
+	s = strings.TrimPrefix(s, "-")
+	var b strings.Builder
+	for i, r := range s {
+		if i > 0 && (len(s)-i)%3 == 0 {
+			b.WriteByte(',')
+		}
+		b.WriteRune(r)
+	}
+	if neg {
+		return "-" + b.String()
+	}
+	return b.String()
+}
+
+// duration writes d as it is, or to the second once it runs past a minute
+// and to the millisecond below that for a person.
+func (m consoleMode) duration(d time.Duration) string {
+	switch {
+	case !m.human:
+		return d.String()
+	case d >= time.Minute:
+		return d.Round(time.Second).String()
+	case d >= time.Second:
+		return d.Round(10 * time.Millisecond).String()
+	}
+	return d.Round(time.Millisecond).String()
+}
+
+// humanSize writes n bytes in the largest binary unit below it, such as
+// 1.2 GiB.
+func humanSize(n int64) string {
+	if n < 1024 {
+		return fmt.Sprintf("%d B", n)
+	}
+	units := []string{"KiB", "MiB", "GiB", "TiB", "PiB", "EiB"}
+	v, i := float64(n)/1024, 0
+	for v >= 1024 && i < len(units)-1 {
+		v, i = v/1024, i+1
+	}
+	return fmt.Sprintf("%.1f %s", v, units[i])
+}
+
+// ANSI colors of the console log.
+const (
+	colorRed    = "\x1b[31m"
+	colorGreen  = "\x1b[32m"
+	colorYellow = "\x1b[33m"
+	colorFaint  = "\x1b[2m"
+	colorBold   = "\x1b[1m"
+	colorReset  = "\x1b[0m"
+)
+
+// consoleLog colors the log lines written to w by what they report.
+type consoleLog struct {
+	w io.Writer
+}
+
+func (c consoleLog) Write(p []byte) (int, error) {
+	if !console.color {
+		return c.w.Write(p)
+	}
+	// the log package writes one message, its time first, at a time
+	stamp, msg, ok := strings.Cut(string(p), " ")
+	if !ok {
+		return c.w.Write(p)
+	}
+	msg = strings.TrimSuffix(msg, "\n")
+	if color := messageColor(msg); color != "" {
+		msg = color + msg + colorReset
+	}
+	line := colorFaint + stamp + colorReset + " " + msg
+	if _, err := io.WriteString(c.w, line+"\n"); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// messageColor returns the color of a log message, or "" to leave it as is.
+func messageColor(msg string) string {
+	switch {
+	case strings.HasPrefix(msg, "Warning:"):
+		return colorYellow
+	case strings.HasPrefix(msg, "Failed "), strings.HasPrefix(msg, "  "):
+		// the summary lists failed jobs indented
+		return colorRed
+	case strings.HasPrefix(msg, "Extraction completed"), strings.HasPrefix(msg, "Completed "), strings.HasPrefix(msg, "Published "):
+		return colorGreen
+	case strings.HasPrefix(msg, "Skipped "), strings.HasPrefix(msg, "Cancelled "):
+		return colorFaint
+	case strings.HasPrefix(msg, "Finished "), strings.HasPrefix(msg, "Begin "):
+		return colorBold
+	}
+	return ""
+}
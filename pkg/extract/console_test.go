@@ -0,0 +1,52 @@
+package extract
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestConsoleMode(t *testing.T) {
+	plain, human := consoleMode{}, consoleMode{human: true}
+	for _, tc := range []struct {
+		got, want string
+	}{
+		{plain.count(1234567), "1234567"},
+		{human.count(1234567), "1,234,567"},
+		{human.count(-1234), "-1,234"},
+		{human.count(999), "999"},
+		{plain.duration(3*time.Minute + 42*time.Second + 123*time.Millisecond), "3m42.123s"},
+		{human.duration(3*time.Minute + 42*time.Second + 123*time.Millisecond), "3m42s"},
+		{human.duration(1234567 * time.Microsecond), "1.23s"},
+		{human.duration(1234 * time.Microsecond), "1ms"},
+		{humanSize(512), "512 B"},
+		{humanSize(1288490189), "1.2 GiB"},
+	} {
+		if tc.got != tc.want {
+			t.Errorf("got %q, want %q", tc.got, tc.want)
+		}
+	}
+}
+
+func TestConsoleLog(t *testing.T) {
+	saved := console
+	t.Cleanup(func() { console = saved })
+	var out bytes.Buffer
+	w := consoleLog{&out}
+
+	console = consoleMode{human: true}
+	w.Write([]byte("12:00:00 Warning: slow\n"))
+	if out.String() != "12:00:00 Warning: slow\n" {
+		t.Errorf("without color: %q", out.String())
+	}
+
+	out.Reset()
+	console.color = true
+	w.Write([]byte("12:00:00 Warning: slow\n"))
+	w.Write([]byte("12:00:00 Running a.csv\n"))
+	want := colorFaint + "12:00:00" + colorReset + " " + colorYellow + "Warning: slow" + colorReset + "\n" +
+		colorFaint + "12:00:00" + colorReset + " Running a.csv\n"
+	if out.String() != want {
+		t.Errorf("got %q, want %q", out.String(), want)
+	}
+}
@@ -0,0 +1,146 @@
+package extract
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"math/bits"
+	"os"
+	"strings"
+	"time"
+)
+
+// Row orders of a content hash.
+const (
+	hashOrdered   = "ordered"
+	hashUnordered = "unordered"
+)
+
+// manifestSuffix is appended to a file's path to name its manifest.
+const manifestSuffix = ".manifest.json"
+
+// validateContentHash checks the content_hash setting of the job writing
+// outFile. Only files, local or remote, get a manifest.
+func validateContentHash(outFile, order string) error {
+	switch order {
+	case hashOrdered, hashUnordered:
+	default:
+		return fmt.Errorf("Unsupported content_hash '%s' for %s (ordered, unordered)\n", order, outFile)
+	}
+	if strings.Contains(outFile, "://") && !isRemoteFile(outFile) {
+		return fmt.Errorf("Content hash of %s: only files can be hashed\n", outFile)
+	}
+	return nil
+}
+
+// hashedOutput computes the canonical content hash of what is written to
+// the output: a SHA-256 over the column names and types and the values of
+// every row, independent of the format, delimiter and compression of the
+// file. An ordered hash also covers the order of the rows; an unordered one
+// adds up the hashes of the rows, so that any order gives the same hash.
+type hashedOutput struct {
+	output
+	order string
+	h     hash.Hash
+	sum   [4]uint64
+	rows  uint64
+	buf   []byte
+}
+
+func newHashedOutput(w output, order string) *hashedOutput {
+	return &hashedOutput{output: w, order: order, h: sha256.New()}
+}
+
+func (o *hashedOutput) WriteHeader(cols []column) error {
+	o.buf = binary.AppendUvarint(o.buf[:0], uint64(len(cols)))
+	for _, col := range outputSchema(cols) {
+		o.buf = appendField(o.buf, col.Name)
+		o.buf = appendField(o.buf, col.Type)
+	}
+	o.h.Write(o.buf)
+	return o.output.WriteHeader(cols)
+}
+
+func (o *hashedOutput) WriteRow(values []any) error {
+	o.buf = o.buf[:0]
+	for _, v := range values {
+		if v == nil {
+			o.buf = append(o.buf, 0)
+			continue
+		}
+		o.buf = append(o.buf, 1)
+		o.buf = appendField(o.buf, formatValue(v))
+	}
+	row := sha256.Sum256(o.buf)
+	o.rows++
+	if o.order == hashOrdered {
+		o.h.Write(row[:])
+	} else {
+		// the sum of the row hashes, modulo 2^256
+		var carry uint64
+		for i := 3; i >= 0; i-- {
+			o.sum[i], carry = bits.Add64(o.sum[i], binary.BigEndian.Uint64(row[i*8:]), carry)
+		}
+	}
+	return o.output.WriteRow(values)
+}
+
+// appendField appends s with its length, so that fields cannot run together.
+func appendField(b []byte, s string) []byte {
+	b = binary.AppendUvarint(b, uint64(len(s)))
+	return append(b, s...)
+}
+
+// contentHash returns the hash of everything written, as sha256:<hex>.
+func (o *hashedOutput) contentHash() string {
+	h := sha256.New()
+	h.Write(o.h.Sum(nil))
+	tail := binary.BigEndian.AppendUint64(nil, o.rows)
+	if o.order == hashUnordered {
+		for _, n := range o.sum {
+			tail = binary.BigEndian.AppendUint64(tail, n)
+		}
+	}
+	h.Write(tail)
+	return "sha256:" + hex.EncodeToString(h.Sum(nil))
+}
+
+// outputManifest describes a file next to it, for checking that a rerun of
+// the job wrote the same content.
+type outputManifest struct {
+	OutFile     string         `json:"outfile"`
+	File        string         `json:"file"`
+	Written     time.Time      `json:"written"`
+	Rows        uint           `json:"rows"`
+	Schema      []schemaColumn `json:"schema"`
+	ContentHash string         `json:"content_hash"`
+	HashOrder   string         `json:"hash_order"`
+}
+
+// writeManifest writes m as the manifest of its file, in the same directory,
+// bucket or server.
+func writeManifest(c *config, m outputManifest) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := m.File + manifestSuffix
+	if !isRemoteFile(path) {
+		if err := os.WriteFile(path, append(data, '\n'), 0o644); err != nil {
+			return fmt.Errorf("Could not write manifest %s: %v\n", path, err)
+		}
+		return nil
+	}
+	u, err := openUpload(c, path)
+	if err != nil {
+		return err
+	}
+	if _, err := u.Write(append(data, '\n')); err != nil {
+		u.Abort()
+		return fmt.Errorf("Could not write manifest %s: %v\n", path, err)
+	}
+	return u.Close()
+}
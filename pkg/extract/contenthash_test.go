@@ -0,0 +1,73 @@
+package extract
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// manifestHash exports query to outFile with the content hash order and
+// returns the hash recorded in its manifest.
+func manifestHash(t *testing.T, c *config, query, outFile, order string) string {
+	t.Helper()
+	c.ContentHash = map[string]string{outFile: order}
+	if _, err := exportFake(t, c, query, outFile); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(outFile + manifestSuffix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m outputManifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		t.Fatal(err)
+	}
+	if m.Rows != 3 || m.HashOrder != order || !strings.HasPrefix(m.ContentHash, "sha256:") {
+		t.Fatalf("got manifest %+v", m)
+	}
+	return m.ContentHash
+}
+
+func TestContentHash(t *testing.T) {
+	registerFake("numbers", &fakeQuery{sets: []*fakeResult{numbersResult(3)}})
+	reversed := numbersResult(3)
+	value := reversed.value
+	reversed.value = func(row, col int) driver.Value { return value(2-row, col) }
+	registerFake("reversed", &fakeQuery{sets: []*fakeResult{reversed}})
+	dir := t.TempDir()
+
+	csv := manifestHash(t, &config{}, "numbers", filepath.Join(dir, "a.csv"), hashUnordered)
+	// the hash is of the content, not of its serialization
+	if got := manifestHash(t, &config{Delimiter: "|"}, "numbers", filepath.Join(dir, "b.csv"), hashUnordered); got != csv {
+		t.Errorf("another delimiter changed the hash: %s, want %s", got, csv)
+	}
+	if got := manifestHash(t, &config{}, "reversed", filepath.Join(dir, "c.csv"), hashUnordered); got != csv {
+		t.Errorf("another row order changed the unordered hash: %s, want %s", got, csv)
+	}
+	ordered := manifestHash(t, &config{}, "numbers", filepath.Join(dir, "d.csv"), hashOrdered)
+	if ordered == csv {
+		t.Error("the ordered and unordered hashes are the same")
+	}
+	if got := manifestHash(t, &config{}, "reversed", filepath.Join(dir, "e.csv"), hashOrdered); got == ordered {
+		t.Error("another row order kept the ordered hash")
+	}
+}
+
+func TestValidateContentHash(t *testing.T) {
+	for _, tc := range []struct {
+		outFile, order string
+		ok             bool
+	}{
+		{"a.csv", hashOrdered, true},
+		{"gs://bucket/a.csv", hashUnordered, true},
+		{"a.csv", "sorted", false},
+		{"delta:///lake/orders", hashOrdered, false},
+	} {
+		if err := validateContentHash(tc.outFile, tc.order); (err == nil) != tc.ok {
+			t.Errorf("%s %s: got %v", tc.outFile, tc.order, err)
+		}
+	}
+}
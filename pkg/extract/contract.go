@@ -0,0 +1,86 @@
+package extract
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// contractColumn is one expected column of a schema contract.
+type contractColumn struct {
+	Name string `yaml:"name"`
+	// Type is a database type name such as DECIMAL(10,2) or a logical type:
+	// string, integer, float, boolean, decimal, date, timestamp or binary.
+	Type string `yaml:"type"`
+	// Nullable, when set, must match the nullability the driver reports.
+	Nullable *bool `yaml:"nullable"`
+}
+
+// contract is the expected layout of a job's output. Contract files are YAML
+// or JSON.
+type contract struct {
+	Columns []contractColumn `yaml:"columns"`
+	// AnyOrder allows the columns to appear in any order.
+	AnyOrder bool `yaml:"any_order"`
+}
+
+// loadContracts reads the contract file of every job that references one.
+func loadContracts(paths map[string]string) (map[string]*contract, error) {
+	contracts := make(map[string]*contract, len(paths))
+	for job, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("Could not read contract %s: %v\n", path, err)
+		}
+		var k contract
+		if err := yaml.Unmarshal(data, &k); err != nil {
+			return nil, fmt.Errorf("Could not parse contract %s: %v\n", path, err)
+		}
+		contracts[job] = &k
+	}
+	return contracts, nil
+}
+
+// violations lists how cols differ from the contract.
+func (k *contract) violations(cols []column) []string {
+	var out []string
+	if len(cols) != len(k.Columns) {
+		out = append(out, fmt.Sprintf("expected %d columns, got %d", len(k.Columns), len(cols)))
+	}
+
+	byName := make(map[string]int, len(cols))
+	for i, c := range cols {
+		byName[strings.ToLower(c.Name)] = i
+	}
+	schema := outputSchema(cols)
+	for i, want := range k.Columns {
+		idx, ok := byName[strings.ToLower(want.Name)]
+		if !ok {
+			out = append(out, fmt.Sprintf("column %s is missing", want.Name))
+			continue
+		}
+		if !k.AnyOrder && idx != i {
+			out = append(out, fmt.Sprintf("column %s is at position %d, expected %d", want.Name, idx+1, i+1))
+		}
+		got := cols[idx]
+		if want.Type != "" && !strings.EqualFold(want.Type, got.DBType) && !strings.EqualFold(want.Type, schema[idx].Type) && !strings.EqualFold(want.Type, got.kind().String()) {
+			out = append(out, fmt.Sprintf("column %s is %s, expected %s", want.Name, schema[idx].Type, want.Type))
+		}
+		if want.Nullable != nil && *want.Nullable != got.Nullable {
+			out = append(out, fmt.Sprintf("column %s nullable is %t, expected %t", want.Name, got.Nullable, *want.Nullable))
+		}
+	}
+
+	expected := make(map[string]bool, len(k.Columns))
+	for _, c := range k.Columns {
+		expected[strings.ToLower(c.Name)] = true
+	}
+	for _, c := range cols {
+		if !expected[strings.ToLower(c.Name)] {
+			out = append(out, fmt.Sprintf("column %s is not in the contract", c.Name))
+		}
+	}
+	return out
+}
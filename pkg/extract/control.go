@@ -0,0 +1,516 @@
+package extract
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"net"
+	"os"
+	"os/signal"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Run states changed through the admin channel.
+const (
+	stateRunning  = "running"
+	statePaused   = "paused"
+	stateDraining = "draining"
+)
+
+// Job states tracked by the controller.
+const (
+	jobQueued    = "queued"
+	jobRunning   = "running"
+	jobDone      = "done"
+	jobFailed    = "failed"
+	jobSkipped   = "skipped"
+	jobCancelled = "cancelled"
+)
+
+// errJobCancelled is the cause of a job context cancelled by an admin.
+var errJobCancelled = errors.New("cancelled by an admin command")
+
+// errFailFast is the cause of the jobs cancelled by -fail-fast.
+var errFailFast = errors.New("cancelled by -fail-fast after another job failed")
+
+// timeoutError is the cause of a job context whose timeout passed.
+type timeoutError struct {
+	outFile string
+	timeout time.Duration
+}
+
+func (e *timeoutError) Error() string {
+	return fmt.Sprintf("%s did not finish within %v\n", e.outFile, e.timeout)
+}
+
+// signalContext returns a context cancelled on SIGINT or SIGTERM, the signal
+// in its cause. A second signal exits straight away. stop stops listening.
+func signalContext() (ctx context.Context, stop func()) {
+	ctx, cancel := context.WithCancelCause(context.Background())
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig, ok := <-sigs
+		if !ok {
+			return
+		}
+		log.Printf("Received %v, aborting the running extracts\n", sig)
+		cancel(fmt.Errorf("stopped by signal: %v", sig))
+		if sig, ok := <-sigs; ok {
+			log.Printf("Received %v again, exiting\n", sig)
+			os.Exit(130)
+		}
+	}()
+	return ctx, func() {
+		signal.Stop(sigs)
+		close(sigs)
+		cancel(nil)
+	}
+}
+
+// controller tracks the jobs of a run and holds them back while the run is
+// paused or draining.
+type controller struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	state   string
+	jobs    map[string]string
+	started map[string]time.Time
+	cancels map[string]context.CancelCauseFunc
+	errs    map[string]string
+	// timedOut marks the failed jobs that ran out of time.
+	timedOut map[string]bool
+	// stopped is set once -fail-fast stops the current run.
+	stopped bool
+	// report is the usage report of the current run, for live progress.
+	report *runReport
+	attrs  func(outFile string) []slog.Attr
+	// drained is closed once the run starts draining.
+	drained chan struct{}
+	// reload, when set, reloads the configuration for later runs.
+	reload func() error
+}
+
+func newController(outFiles []string) *controller {
+	c := &controller{state: stateRunning, drained: make(chan struct{})}
+	c.cond = sync.NewCond(&c.mu)
+	c.begin(outFiles)
+	return c
+}
+
+// begin starts tracking the jobs of a new run; the run state carries over.
+func (c *controller) begin(outFiles []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.jobs = make(map[string]string, len(outFiles))
+	c.started = make(map[string]time.Time)
+	c.cancels = make(map[string]context.CancelCauseFunc)
+	c.errs = make(map[string]string)
+	c.timedOut = make(map[string]bool)
+	c.stopped = false
+	c.report = nil
+	for _, f := range outFiles {
+		c.jobs[f] = jobQueued
+	}
+}
+
+// hold blocks while the run is paused and reports whether another run may
+// start, which it may not once draining.
+func (c *controller) hold() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for c.state == statePaused {
+		c.cond.Wait()
+	}
+	return c.state != stateDraining
+}
+
+// sleepUntil waits until t and reports whether it got there before draining.
+func (c *controller) sleepUntil(t time.Time) bool {
+	timer := time.NewTimer(time.Until(t))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-c.drained:
+		return false
+	}
+}
+
+// start blocks while the run is paused and marks the job running. It returns
+// the job's context, which an admin cancel command cancels, or false when the
+// job was cancelled before it started or the run is draining.
+func (c *controller) start(parent context.Context, outFile string) (context.Context, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for c.state == statePaused && c.jobs[outFile] == jobQueued {
+		c.cond.Wait()
+	}
+	if c.jobs[outFile] == jobCancelled {
+		return nil, false
+	}
+	if c.state == stateDraining || c.stopped {
+		c.jobs[outFile] = jobSkipped
+		c.cond.Broadcast()
+		return nil, false
+	}
+	ctx, cancel := context.WithCancelCause(parent)
+	c.jobs[outFile] = jobRunning
+	c.started[outFile] = time.Now()
+	c.cancels[outFile] = cancel
+	return ctx, true
+}
+
+// finish records the outcome of a job started with start. The error of a job
+// cancelled by an admin is logged and dropped; that of a failed job is logged
+// and returned. Either way the rest of the run goes on.
+func (c *controller) finish(outFile string, err error) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cancelled := c.jobs[outFile] == jobCancelled
+	// jobs running after this one wait for it in awaitJobs
+	defer c.cond.Broadcast()
+	c.cancels[outFile](nil)
+	delete(c.cancels, outFile)
+	delete(c.started, outFile)
+	switch {
+	case cancelled:
+		c.logJob(outFile, fmt.Sprintf("Cancelled %s, its partial output was discarded\n", outFile), slog.String("state", jobCancelled))
+		return nil
+	case errors.As(err, new(*timeoutError)):
+		c.jobs[outFile] = jobFailed
+		c.errs[outFile] = strings.TrimSpace(err.Error())
+		c.timedOut[outFile] = true
+		c.logJob(outFile, fmt.Sprintf("Timed out %s: %s\n", outFile, c.errs[outFile]), slog.String("state", jobFailed), slog.Bool("timed_out", true), slog.String("error", c.errs[outFile]))
+	case err != nil:
+		c.jobs[outFile] = jobFailed
+		c.errs[outFile] = strings.TrimSpace(err.Error())
+		c.logJob(outFile, fmt.Sprintf("Failed %s: %s\n", outFile, c.errs[outFile]), slog.String("state", jobFailed), slog.String("error", c.errs[outFile]))
+	default:
+		c.jobs[outFile] = jobDone
+	}
+	return err
+}
+
+// awaitJobs blocks until none of outFiles is queued or running and returns
+// the first of them that did not succeed, or "".
+func (c *controller) awaitJobs(outFiles []string) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for slices.ContainsFunc(outFiles, func(f string) bool { return c.jobs[f] == jobQueued || c.jobs[f] == jobRunning }) {
+		c.cond.Wait()
+	}
+	for _, f := range outFiles {
+		if c.jobs[f] != jobDone {
+			return f
+		}
+	}
+	return ""
+}
+
+// skip marks a queued job skipped, unless it was cancelled.
+func (c *controller) skip(outFile string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.jobs[outFile] == jobQueued {
+		c.jobs[outFile] = jobSkipped
+	}
+	c.cond.Broadcast()
+}
+
+// cancel stops the named job: a running job has its context cancelled and a
+// queued job never starts.
+func (c *controller) cancel(outFile string) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch c.jobs[outFile] {
+	case "":
+		return fmt.Sprintf("error: no job writes %s", outFile)
+	case jobQueued:
+		c.jobs[outFile] = jobCancelled
+		c.cond.Broadcast()
+		return fmt.Sprintf("ok: %s will not start", outFile)
+	case jobRunning:
+		c.jobs[outFile] = jobCancelled
+		c.cancels[outFile](errJobCancelled)
+		return fmt.Sprintf("ok: cancelling %s", outFile)
+	}
+	return fmt.Sprintf("error: %s is already %s", outFile, c.jobs[outFile])
+}
+
+// stopAll ends the current run, after a failed job or on a signal: running
+// jobs are cancelled with cause and queued jobs are skipped. Unlike drain,
+// the run state is left alone, so a server goes on with its next run.
+func (c *controller) stopAll(cause error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.stopped {
+		return
+	}
+	c.stopped = true
+	for f, cancel := range c.cancels {
+		c.jobs[f] = jobCancelled
+		cancel(cause)
+	}
+	c.cond.Broadcast()
+}
+
+// summary logs how every job of the run ended, in the language of p, and
+// returns an error if any failed.
+func (c *controller) summary(outFiles []string, p reportPrinter) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	counts := map[string]int{}
+	for _, f := range outFiles {
+		counts[c.jobs[f]]++
+	}
+	log.Println(p.Sprintf(msgRunFinished, counts[jobDone], len(outFiles), counts[jobFailed], counts[jobSkipped], counts[jobCancelled]))
+	// a person gets the errors lined up
+	width := 0
+	for _, f := range outFiles {
+		if c.jobs[f] == jobFailed && console.human {
+			width = max(width, len(f)+1)
+		}
+	}
+	timedOut := 0
+	for _, f := range outFiles {
+		if c.jobs[f] == jobFailed {
+			log.Printf("  %-*s %s\n", width, f+":", c.errs[f])
+		}
+		if c.timedOut[f] {
+			timedOut++
+		}
+	}
+	switch {
+	case timedOut > 0:
+		return fmt.Errorf("%d of %d extract(s) failed, %d of them timed out\n", counts[jobFailed], len(outFiles), timedOut)
+	case counts[jobFailed] > 0:
+		return fmt.Errorf("%d of %d extract(s) failed\n", counts[jobFailed], len(outFiles))
+	}
+	return nil
+}
+
+// setState changes the run state.
+func (c *controller) setState(state string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if state == stateDraining && c.state != stateDraining {
+		close(c.drained)
+	}
+	c.state = state
+	c.cond.Broadcast()
+}
+
+// command executes one admin command and returns the reply.
+func (c *controller) command(line string) string {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return "error: empty command"
+	}
+	switch strings.ToLower(fields[0]) {
+	case "pause":
+		c.mu.Lock()
+		draining := c.state == stateDraining
+		c.mu.Unlock()
+		if draining {
+			return "error: the run is draining"
+		}
+		c.setState(statePaused)
+		return "ok: paused, running jobs continue but no new jobs start"
+	case "resume":
+		c.mu.Lock()
+		draining := c.state == stateDraining
+		c.mu.Unlock()
+		if draining {
+			return "error: the run is draining"
+		}
+		c.setState(stateRunning)
+		return "ok: resumed"
+	case "drain":
+		c.setState(stateDraining)
+		return "ok: draining, the run ends once the running jobs finish"
+	case "status":
+		return c.status()
+	case "reload":
+		if c.reload == nil {
+			return "error: reload needs -serve"
+		}
+		if err := c.reload(); err != nil {
+			return fmt.Sprintf("error: keeping the previous configuration: %v", strings.TrimSpace(err.Error()))
+		}
+		return "ok: reloaded, the next run uses the new configuration"
+	case "cancel":
+		// outfiles may contain spaces, so the rest of the line is the name
+		name := strings.TrimSpace(strings.TrimSpace(line)[len(fields[0]):])
+		if name == "" {
+			return "error: usage: cancel <outfile>"
+		}
+		return c.cancel(name)
+	}
+	return fmt.Sprintf("error: unknown command %q (pause, resume, drain, status, cancel, reload)", fields[0])
+}
+
+// runState returns the run state.
+func (c *controller) runState() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.state
+}
+
+// track follows the progress of the current run's jobs in r. attrs, if
+// set, returns the fields of the JSON log records about a job.
+func (c *controller) track(r *runReport, attrs func(outFile string) []slog.Attr) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.report = r
+	c.attrs = attrs
+}
+
+// logJob logs msg about the job writing outFile, with its fields and extra
+// in the JSON log.
+func (c *controller) logJob(outFile, msg string, extra ...slog.Attr) {
+	attrs := []slog.Attr{slog.String("outfile", outFile)}
+	if c.attrs != nil {
+		attrs = c.attrs(outFile)
+	}
+	logAttrs(msg, append(attrs, extra...))
+}
+
+// jobProgress returns when a running job started and how far it has got. A
+// finished job has its final row and byte counts.
+func (c *controller) jobProgress(outFile string) (time.Time, jobProgress) {
+	c.mu.Lock()
+	started, r := c.started[outFile], c.report
+	c.mu.Unlock()
+	if r == nil {
+		return started, jobProgress{}
+	}
+	if u, ok := r.usageFor(outFile); ok {
+		return u.Started, jobProgress{Rows: u.Rows, Bytes: u.BytesWritten, Seconds: u.Seconds}
+	}
+	return started, r.progressFor(outFile)
+}
+
+// jobError returns the error a failed job ended with.
+func (c *controller) jobError(outFile string) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.errs[outFile]
+}
+
+// jobState returns the state of one job.
+func (c *controller) jobState(outFile string) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.jobs[outFile]
+}
+
+// timedOutJob reports whether the job writing outFile failed by running out
+// of time.
+func (c *controller) timedOutJob(outFile string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.timedOut[outFile]
+}
+
+// states returns the state of every job of the current run.
+func (c *controller) states() map[string]string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	states := make(map[string]string, len(c.jobs))
+	for f, s := range c.jobs {
+		states[f] = s
+	}
+	return states
+}
+
+// status summarizes the run and lists the running jobs.
+func (c *controller) status() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	counts := map[string]int{}
+	for _, s := range c.jobs {
+		counts[s]++
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "state=%s", c.state)
+	for _, s := range []string{jobQueued, jobRunning, jobDone, jobFailed, jobSkipped, jobCancelled} {
+		fmt.Fprintf(&b, " %s=%d", s, counts[s])
+	}
+	fmt.Fprintf(&b, " timed_out=%d", len(c.timedOut))
+	running := make([]string, 0, len(c.started))
+	for f := range c.started {
+		running = append(running, f)
+	}
+	sort.Strings(running)
+	for _, f := range running {
+		fmt.Fprintf(&b, "\nrunning %s for %s", f, time.Since(c.started[f]).Round(time.Second))
+	}
+	return b.String()
+}
+
+// serveAdmin accepts admin commands on a unix socket at path, one command per
+// connection. The returned function stops listening and removes the socket.
+func serveAdmin(path string, c *controller) (func(), error) {
+	// a socket left behind by a crashed run would block the listener
+	if fi, err := os.Stat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		os.Remove(path)
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("Could not listen on admin socket %s: %v\n", path, err)
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			if err != nil {
+				log.Printf("Warning: admin socket: %v\n", err)
+				continue
+			}
+			go func() {
+				defer conn.Close()
+				conn.SetDeadline(time.Now().Add(10 * time.Second))
+				line, err := bufio.NewReader(conn).ReadString('\n')
+				if err != nil && !errors.Is(err, io.EOF) {
+					return
+				}
+				reply := c.command(line)
+				log.Printf("Admin command %q: %s\n", strings.TrimSpace(line), strings.SplitN(reply, "\n", 2)[0])
+				fmt.Fprintln(conn, reply)
+			}()
+		}
+	}()
+	return func() {
+		ln.Close()
+		os.Remove(path)
+	}, nil
+}
+
+// sendAdmin sends one command to the admin socket at path and returns the reply.
+func sendAdmin(path, command string) (string, error) {
+	conn, err := net.DialTimeout("unix", path, 5*time.Second)
+	if err != nil {
+		return "", fmt.Errorf("Could not connect to admin socket %s: %v\n", path, err)
+	}
+	defer conn.Close()
+	if _, err := fmt.Fprintln(conn, command); err != nil {
+		return "", err
+	}
+	reply, err := io.ReadAll(conn)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(reply)), nil
+}
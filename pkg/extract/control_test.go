@@ -0,0 +1,206 @@
+package extract
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestControllerPauseDrain(t *testing.T) {
+	c := newController([]string{"a.csv", "b.csv", "c.csv"})
+	if _, ok := c.start(context.Background(), "a.csv"); !ok {
+		t.Fatal("a.csv should start while running")
+	}
+	c.command("pause")
+	started := make(chan bool)
+	go func() {
+		_, ok := c.start(context.Background(), "b.csv")
+		started <- ok
+	}()
+	select {
+	case <-started:
+		t.Fatal("b.csv started while paused")
+	case <-time.After(50 * time.Millisecond):
+	}
+	c.command("resume")
+	if !<-started {
+		t.Fatal("b.csv should start after resume")
+	}
+	c.command("drain")
+	if _, ok := c.start(context.Background(), "c.csv"); ok {
+		t.Fatal("c.csv started while draining")
+	}
+	c.finish("a.csv", nil)
+	status := c.command("status")
+	for _, want := range []string{"state=draining", "running=1", "done=1", "skipped=1", "running b.csv"} {
+		if !strings.Contains(status, want) {
+			t.Errorf("status %q lacks %q", status, want)
+		}
+	}
+}
+
+func TestAdminSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "admin.sock")
+	c := newController([]string{"a.csv"})
+	stop, err := serveAdmin(path, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stop()
+	reply, err := sendAdmin(path, "pause")
+	if err != nil || !strings.HasPrefix(reply, "ok:") {
+		t.Fatalf("pause: %q, %v", reply, err)
+	}
+	if reply, _ := sendAdmin(path, "status"); !strings.HasPrefix(reply, "state=paused") {
+		t.Errorf("status = %q", reply)
+	}
+	if reply, _ := sendAdmin(path, "bogus"); !strings.HasPrefix(reply, "error:") {
+		t.Errorf("bogus = %q", reply)
+	}
+}
+
+func TestCancelRunningJob(t *testing.T) {
+	res := numbersResult(1000)
+	res.delay = time.Millisecond
+	registerFake("slow", &fakeQuery{sets: []*fakeResult{res}})
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	db, err := sql.Open("fakedb", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	outFile := filepath.Join(t.TempDir(), "out.csv")
+	c := &config{Delimiter: ","}
+	c.dialect, _ = dialectFor("sqlserver")
+	control := newController([]string{outFile, "other.csv"})
+	ctx, _ := control.start(context.Background(), outFile)
+	time.AfterFunc(20*time.Millisecond, func() { control.command("cancel " + outFile) })
+	err = exportData(ctx, db, c, nil, newRunReport(""), nil, "slow", outFile)
+	if err == nil {
+		t.Fatal("the cancelled export succeeded")
+	}
+	if err := control.finish(outFile, err); err != nil {
+		t.Fatalf("finish returned %v for a cancelled job", err)
+	}
+	if _, err := os.Stat(outFile); !os.IsNotExist(err) {
+		t.Errorf("partial output was left behind: %v", err)
+	}
+	if reply := control.command("cancel other.csv"); !strings.HasPrefix(reply, "ok:") {
+		t.Errorf("cancel of a queued job: %q", reply)
+	}
+	if _, ok := control.start(context.Background(), "other.csv"); ok {
+		t.Error("a cancelled queued job started")
+	}
+}
+
+func TestRunJobsFailures(t *testing.T) {
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	var control *controller
+	dispatch := func(*ledger, *runReport) jobFunc {
+		return func(ctx context.Context, _, query, _ string) error {
+			switch query {
+			case "fail":
+				// a.csv has started by the time this fails
+				for control.jobState("a.csv") == jobQueued {
+					time.Sleep(time.Millisecond)
+				}
+				return errors.New("Invalid object name 'nope'\n")
+			case "wait":
+				<-ctx.Done()
+				return context.Cause(ctx)
+			}
+			return nil
+		}
+	}
+
+	c := &config{Queries: []string{"ok", "fail", "ok"}, OutFiles: []string{"a.csv", "b.csv", "c.csv"}}
+	control = newController(c.OutFiles)
+	err := runJobs(context.Background(), c, control, dispatch)
+	if err == nil || err.Error() != "1 of 3 extract(s) failed\n" {
+		t.Errorf("got %v", err)
+	}
+	want := map[string]string{"a.csv": jobDone, "b.csv": jobFailed, "c.csv": jobDone}
+	if got := control.states(); !reflect.DeepEqual(got, want) {
+		t.Errorf("states %v, want %v", got, want)
+	}
+
+	c = &config{Queries: []string{"wait", "fail"}, OutFiles: []string{"a.csv", "b.csv"}, failFast: true}
+	control = newController(c.OutFiles)
+	if err := runJobs(context.Background(), c, control, dispatch); err == nil {
+		t.Error("a failed run returned no error")
+	}
+	want = map[string]string{"a.csv": jobCancelled, "b.csv": jobFailed}
+	if got := control.states(); !reflect.DeepEqual(got, want) {
+		t.Errorf("fail-fast states %v, want %v", got, want)
+	}
+	if control.runState() != stateRunning {
+		t.Errorf("fail-fast left the run %s", control.runState())
+	}
+}
+
+func TestRunJobsInterrupted(t *testing.T) {
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	ctx, cancel := context.WithCancelCause(context.Background())
+	c := &config{Queries: []string{"wait"}, OutFiles: []string{"a.csv"}}
+	control := newController(c.OutFiles)
+	dispatch := func(*ledger, *runReport) jobFunc {
+		return func(jobCtx context.Context, _, _, _ string) error {
+			cancel(errors.New("stopped by signal: terminated"))
+			<-jobCtx.Done()
+			return context.Cause(jobCtx)
+		}
+	}
+	err := runJobs(ctx, c, control, dispatch)
+	if err == nil || err.Error() != "The run was stopped by signal: terminated\n" {
+		t.Errorf("got %v", err)
+	}
+	if s := control.jobState("a.csv"); s != jobCancelled {
+		t.Errorf("the interrupted job is %s", s)
+	}
+	if control.runState() != stateDraining {
+		t.Errorf("the interrupted run is %s", control.runState())
+	}
+}
+
+func TestRunJobsTimeout(t *testing.T) {
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	c := &config{
+		Timeout:  configDuration(10 * time.Millisecond),
+		Extracts: []extract{{Name: "quick", OutFile: "b.csv", Timeout: configDuration(time.Minute)}},
+		Queries:  []string{"wait", "ok"},
+		OutFiles: []string{"a.csv", "b.csv"},
+	}
+	control := newController(c.OutFiles)
+	dispatch := func(*ledger, *runReport) jobFunc {
+		return func(ctx context.Context, _, query, _ string) error {
+			if query == "wait" {
+				<-ctx.Done()
+				return ctx.Err()
+			}
+			return nil
+		}
+	}
+	err := runJobs(context.Background(), c, control, dispatch)
+	if err == nil || err.Error() != "1 of 2 extract(s) failed, 1 of them timed out\n" {
+		t.Errorf("got %v", err)
+	}
+	if !control.timedOutJob("a.csv") || control.timedOutJob("b.csv") || control.jobState("b.csv") != jobDone {
+		t.Errorf("states %v, timed out %v", control.states(), control.timedOut)
+	}
+	if c.timeout("b.csv") != time.Minute || c.timeout("a.csv") != 10*time.Millisecond {
+		t.Errorf("timeouts %v and %v", c.timeout("a.csv"), c.timeout("b.csv"))
+	}
+}
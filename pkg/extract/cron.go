@@ -0,0 +1,161 @@
+package extract
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronSchedule is a cron expression: minute, hour, day of month, month and
+// day of week, each a set of the values it fires on.
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64
+	// a day fires on either field when both are restricted, as in cron
+	domAny, dowAny bool
+}
+
+// cronMacros are the named schedules cron accepts in place of the fields.
+var cronMacros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+var (
+	cronMonths = []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}
+	cronDays   = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+)
+
+// cronHorizon is how far ahead next looks for a time the schedule fires on.
+const cronHorizon = 5 * 366 * 24 * time.Hour
+
+// parseCron parses a cron expression of five fields, such as "0 2 * * 1-5",
+// or one of the macros such as @daily.
+func parseCron(spec string) (*cronSchedule, error) {
+	if m, ok := cronMacros[strings.ToLower(strings.TrimSpace(spec))]; ok {
+		spec = m
+	}
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron expression '%s' needs 5 fields: minute, hour, day of month, month and day of week", spec)
+	}
+	s := &cronSchedule{domAny: fields[2] == "*", dowAny: fields[4] == "*"}
+	var err error
+	if s.minute, err = parseCronField(fields[0], 0, 59, nil, 0); err != nil {
+		return nil, fmt.Errorf("cron minute: %v", err)
+	}
+	if s.hour, err = parseCronField(fields[1], 0, 23, nil, 0); err != nil {
+		return nil, fmt.Errorf("cron hour: %v", err)
+	}
+	if s.dom, err = parseCronField(fields[2], 1, 31, nil, 0); err != nil {
+		return nil, fmt.Errorf("cron day of month: %v", err)
+	}
+	if s.month, err = parseCronField(fields[3], 1, 12, cronMonths, 1); err != nil {
+		return nil, fmt.Errorf("cron month: %v", err)
+	}
+	// 7 is Sunday too
+	if s.dow, err = parseCronField(fields[4], 0, 7, cronDays, 0); err != nil {
+		return nil, fmt.Errorf("cron day of week: %v", err)
+	}
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+	if s.next(time.Now()).IsZero() {
+		return nil, fmt.Errorf("cron expression '%s' never fires", spec)
+	}
+	return s, nil
+}
+
+// parseCronField parses a comma-separated list of values, ranges, * and
+// steps such as */15 or 8-18/2. names, if set, are accepted for the values
+// from base up.
+func parseCronField(field string, lo, hi int, names []string, base int) (uint64, error) {
+	value := func(v string) (int, error) {
+		for i, name := range names {
+			if strings.EqualFold(v, name) {
+				return base + i, nil
+			}
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil || n < lo || n > hi {
+			return 0, fmt.Errorf("'%s' is not a value from %d to %d", v, lo, hi)
+		}
+		return n, nil
+	}
+	var set uint64
+	for _, part := range strings.Split(field, ",") {
+		rng, step := part, 1
+		if r, s, ok := strings.Cut(part, "/"); ok {
+			n, err := strconv.Atoi(s)
+			if err != nil || n < 1 {
+				return 0, fmt.Errorf("'%s' has an invalid step", part)
+			}
+			rng, step = r, n
+		}
+		from, to := lo, hi
+		switch a, b, isRange := strings.Cut(rng, "-"); {
+		case rng == "*":
+		case isRange:
+			var err error
+			if from, err = value(a); err != nil {
+				return 0, err
+			}
+			if to, err = value(b); err != nil {
+				return 0, err
+			}
+			if from > to {
+				return 0, fmt.Errorf("'%s' ends before it starts", rng)
+			}
+		default:
+			n, err := value(rng)
+			if err != nil {
+				return 0, err
+			}
+			from, to = n, n
+			if step > 1 {
+				// n/step runs from n to the end
+				to = hi
+			}
+		}
+		for v := from; v <= to; v += step {
+			set |= 1 << v
+		}
+	}
+	return set, nil
+}
+
+// next returns the first minute after t the schedule fires on, in the
+// location of t, or the zero time if it does not within cronHorizon.
+func (s *cronSchedule) next(t time.Time) time.Time {
+	end := t.Add(cronHorizon)
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	for t.Before(end) {
+		switch {
+		case s.month&(1<<int(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		case !s.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		case s.hour&(1<<t.Hour()) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+		case s.minute&(1<<t.Minute()) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+func (s *cronSchedule) dayMatches(t time.Time) bool {
+	dom := s.dom&(1<<t.Day()) != 0
+	dow := s.dow&(1<<int(t.Weekday())) != 0
+	if s.domAny || s.dowAny {
+		return dom && dow
+	}
+	return dom || dow
+}
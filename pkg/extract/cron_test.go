@@ -0,0 +1,71 @@
+package extract
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCronNext(t *testing.T) {
+	// a Wednesday
+	from := time.Date(2026, 10, 14, 2, 30, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		spec string
+		want time.Time
+	}{
+		{"0 2 * * *", time.Date(2026, 10, 15, 2, 0, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2026, 10, 14, 2, 45, 0, 0, time.UTC)},
+		{"30 6 * * mon-fri", time.Date(2026, 10, 14, 6, 30, 0, 0, time.UTC)},
+		{"0 1 * * 7", time.Date(2026, 10, 18, 1, 0, 0, 0, time.UTC)},
+		{"0 0 1 jan,jul *", time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 8-18/4 * * *", time.Date(2026, 10, 14, 8, 0, 0, 0, time.UTC)},
+		// restricted day of month and week fire on either
+		{"0 0 13 * fri", time.Date(2026, 10, 16, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"@monthly", time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2026, 10, 14, 3, 0, 0, 0, time.UTC)},
+	} {
+		s, err := parseCron(tc.spec)
+		if err != nil {
+			t.Errorf("%s: %v", tc.spec, err)
+			continue
+		}
+		if got := s.next(from); !got.Equal(tc.want) {
+			t.Errorf("%s: next = %s, want %s", tc.spec, got, tc.want)
+		}
+	}
+}
+
+func TestCronNextInZone(t *testing.T) {
+	zone := time.FixedZone("IST", 5*3600+1800)
+	s, _ := parseCron("0 * * * *")
+	from := time.Date(2026, 10, 14, 10, 10, 0, 0, zone)
+	if got := s.next(from); !got.Equal(time.Date(2026, 10, 14, 11, 0, 0, 0, zone)) {
+		t.Errorf("next = %s, want 11:00 local time", got)
+	}
+}
+
+func TestParseCronErrors(t *testing.T) {
+	for _, spec := range []string{"", "0 2 * *", "60 * * * *", "0 24 * * *", "0 0 0 * *", "0 0 * 13 *", "5-1 * * * *", "*/0 * * * *", "0 0 30 2 *", "0 0 * * funday"} {
+		if _, err := parseCron(spec); err == nil {
+			t.Errorf("%q parsed", spec)
+		}
+	}
+}
+
+func TestScheduledTargets(t *testing.T) {
+	c := &config{
+		Extracts: []extract{{Name: "orders", OutFile: "orders.csv", Schedule: "0 2 * * *"}, {OutFile: "misc.csv"}, {OutFile: "stock.csv", Schedule: "@hourly"}},
+		Flows:    map[string]flowOptions{"morning": {Jobs: []string{"orders"}, Schedule: "0 6 * * 1-5"}, "adhoc": {Jobs: []string{"misc.csv"}}},
+	}
+	var names []string
+	for _, target := range c.scheduledTargets() {
+		names = append(names, target.name)
+	}
+	if len(names) != 3 || names[0] != "morning" || names[1] != "orders" || names[2] != "stock.csv" {
+		t.Errorf("targets = %v", names)
+	}
+	c.Extracts[1].Schedule = "0 0 31 4 *"
+	if err := validateSchedules(c); err == nil {
+		t.Error("a schedule that never fires is valid")
+	}
+}
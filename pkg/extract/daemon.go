@@ -0,0 +1,181 @@
+package extract
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+// daemonIdle is how long -daemon waits for a reload when no job or flow has
+// a schedule.
+const daemonIdle = time.Minute
+
+// scheduledTarget is a job or flow with a schedule for -daemon.
+type scheduledTarget struct {
+	// name is the flow's name, or the job's name or else outfile.
+	name     string
+	spec     string
+	schedule *cronSchedule
+}
+
+// scheduledTargets returns the flows and then the jobs of c that carry a
+// schedule.
+func (c *config) scheduledTargets() []scheduledTarget {
+	var targets []scheduledTarget
+	flows := make([]string, 0, len(c.Flows))
+	for name := range c.Flows {
+		flows = append(flows, name)
+	}
+	sort.Strings(flows)
+	for _, name := range flows {
+		if spec := c.Flows[name].Schedule; spec != "" {
+			s, _ := parseCron(spec)
+			targets = append(targets, scheduledTarget{name: name, spec: spec, schedule: s})
+		}
+	}
+	for _, e := range c.Extracts {
+		if e.Schedule == "" {
+			continue
+		}
+		name := e.Name
+		if name == "" {
+			name = e.OutFile
+		}
+		s, _ := parseCron(e.Schedule)
+		targets = append(targets, scheduledTarget{name: name, spec: e.Schedule, schedule: s})
+	}
+	return targets
+}
+
+func validateSchedules(c *config) error {
+	for _, e := range c.Extracts {
+		if e.Schedule == "" {
+			continue
+		}
+		if _, err := parseCron(e.Schedule); err != nil {
+			return fmt.Errorf("Extract %s schedule: %v\n", e.Name, err)
+		}
+	}
+	return nil
+}
+
+// daemon runs each scheduled job and flow when its schedule fires, until
+// the admin drain command. Runs go one at a time: a target held up behind
+// another's run starts as soon as it is over, once however often it fired
+// meanwhile, and a target still running when its own schedule fires again
+// skips that run.
+func (s *server) daemon(ctx context.Context) {
+	defer s.reloadOnHangup()()
+
+	// next is when each target fires next, by name and schedule
+	next := map[string]time.Time{}
+	for s.control.hold() {
+		targets := s.config().scheduledTargets()
+		now := time.Now()
+		var due []scheduledTarget
+		var earliest time.Time
+		seen := map[string]bool{}
+		for _, t := range targets {
+			key := t.name + " " + t.spec
+			seen[key] = true
+			at, ok := next[key]
+			if !ok {
+				at = t.schedule.next(now)
+				next[key] = at
+				if at.IsZero() {
+					log.Printf("Warning: the schedule '%s' of %s does not fire again\n", t.spec, t.name)
+				}
+			}
+			if at.IsZero() {
+				continue
+			}
+			if !at.After(now) {
+				due = append(due, t)
+			} else if earliest.IsZero() || at.Before(earliest) {
+				earliest = at
+			}
+		}
+		// a reload drops the targets it removed or rescheduled
+		for key := range next {
+			if !seen[key] {
+				delete(next, key)
+			}
+		}
+
+		if len(due) == 0 {
+			if earliest.IsZero() {
+				log.Println("Warning: no job or flow has a schedule, waiting for the configuration to change")
+				earliest = now.Add(daemonIdle)
+			} else {
+				log.Printf("Next scheduled run at %s\n", earliest.Format(time.DateTime))
+			}
+			if !s.control.sleepUntil(earliest) {
+				break
+			}
+			continue
+		}
+		for _, t := range due {
+			if ctx.Err() != nil || !s.control.hold() || !s.config().awaitMaintenance(s.control, t.name) {
+				break
+			}
+			key := t.name + " " + t.spec
+			started := time.Now()
+			if late := t.schedule.next(next[key]); !late.IsZero() && late.Before(started) {
+				log.Printf("%s starts late, behind another run since its schedule fired at %s\n", t.name, next[key].Format(time.DateTime))
+			}
+			log.Printf("Schedule '%s' starts %s\n", t.spec, t.name)
+			if err := s.runScheduled(ctx, t.name, earliest); err != nil {
+				log.Printf("Warning: run of %s failed: %v\n", t.name, strings.TrimSpace(err.Error()))
+			}
+			// only the target's own run counts as an overlap
+			now := time.Now()
+			if missed := t.schedule.next(started); !missed.IsZero() && missed.Before(now) {
+				log.Printf("Skipped the run of %s at %s, its previous run was still going\n", t.name, missed.Format(time.DateTime))
+			}
+			if next[key] = t.schedule.next(now); next[key].IsZero() {
+				log.Printf("Warning: the schedule '%s' of %s does not fire again\n", t.spec, t.name)
+			}
+		}
+	}
+	log.Println("Drained, stopping the daemon")
+}
+
+// runScheduled runs the flow named target, and the flows following it, or
+// else the job named target, leaving out the jobs that do not run today.
+func (s *server) runScheduled(ctx context.Context, target string, next time.Time) error {
+	c := s.config()
+	if _, ok := c.Flows[target]; ok {
+		var err error
+		if c, err = s.loadFlow(target); err != nil {
+			return err
+		}
+	} else {
+		outFiles, err := c.jobsNamed([]string{target})
+		if err != nil {
+			return err
+		}
+		run := *c
+		run.keepJobs(outFiles)
+		c = &run
+	}
+	c, err := c.scheduled(ctx, time.Now())
+	if err != nil {
+		return err
+	}
+	if len(c.OutFiles) == 0 {
+		log.Printf("No job of %s runs on %s\n", target, time.Now().Format(time.DateOnly))
+		return nil
+	}
+	s.control.begin(c.OutFiles)
+	if s.health != nil {
+		s.health.runStarted(c.OutFiles)
+	}
+	err = runChain(ctx, c, s.control, s.loadFlow, runExtraction)
+	if s.health != nil {
+		s.health.runFinished(s.control.states(), next)
+	}
+	return err
+}
@@ -0,0 +1,336 @@
+package extract
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// deltaCommitAttempts bounds the retries when another writer claims the same
+// log version first.
+const deltaCommitAttempts = 10
+
+// deltaOptions holds the settings for delta:// destinations.
+type deltaOptions struct {
+	// PartitionBy lists the columns the table is partitioned on.
+	PartitionBy []string `yaml:"partition_by"`
+	// Compression is the Parquet codec for data files: snappy (default), gzip, zstd or none.
+	Compression string `yaml:"compression"`
+}
+
+// deltaField is one field of a Delta table schema, serialized in the Spark
+// StructType JSON layout the Delta protocol uses.
+type deltaField struct {
+	Name     string         `json:"name"`
+	Type     string         `json:"type"`
+	Nullable bool           `json:"nullable"`
+	Metadata map[string]any `json:"metadata"`
+}
+
+type deltaSchema struct {
+	Type   string       `json:"type"`
+	Fields []deltaField `json:"fields"`
+}
+
+// deltaWriter appends the result set to a Delta Lake table as a new commit.
+// Data files are written first; they only become visible to readers once the
+// commit file is created in _delta_log.
+type deltaWriter struct {
+	table string
+	store tableStore
+	opts  deltaOptions
+	files *tableFiles
+	done  bool
+}
+
+// newDeltaWriter opens the table at table, a local path or a location in an
+// object store. Commits claim the next log file with a conditional write, so
+// concurrent writers never overwrite one another's version.
+func newDeltaWriter(table string, o deltaOptions) (*deltaWriter, error) {
+	if _, err := parquetCodec(o.Compression); err != nil {
+		return nil, err
+	}
+	store, err := openTableStore(table)
+	if err != nil {
+		return nil, err
+	}
+	return newDeltaTableWriter(table, store, o), nil
+}
+
+// newDeltaTableWriter appends to the table held in store.
+func newDeltaTableWriter(table string, store tableStore, o deltaOptions) *deltaWriter {
+	files := &tableFiles{store: store, compression: o.Compression, nullPartition: "__HIVE_DEFAULT_PARTITION__"}
+	return &deltaWriter{table: table, store: store, opts: o, files: files}
+}
+
+func (d *deltaWriter) WriteHeader(cols []column) error {
+	return d.files.setup(cols, d.opts.PartitionBy)
+}
+
+func (d *deltaWriter) WriteRow(values []any) error {
+	return d.files.write(values)
+}
+
+// Close finishes the data files and commits them to the table log.
+func (d *deltaWriter) Close() error {
+	d.done = true
+	defer d.store.Close()
+	parts, err := d.files.finish()
+	if err != nil {
+		d.files.cleanup()
+		return err
+	}
+
+	now := time.Now().UnixMilli()
+	var adds []map[string]any
+	for _, part := range parts {
+		values := make(map[string]*string, len(part.values))
+		for i, idx := range d.files.partIdx {
+			col := d.files.cols[idx]
+			values[col.Name] = nil
+			if v := part.values[i]; v != nil {
+				s := deltaPartitionValue(col, v)
+				values[col.Name] = &s
+			}
+		}
+		stats, _ := json.Marshal(map[string]int64{"numRecords": part.rows})
+		adds = append(adds, map[string]any{"add": map[string]any{
+			"path":             deltaPathURI(part.path),
+			"partitionValues":  values,
+			"size":             part.size,
+			"modificationTime": now,
+			"dataChange":       true,
+			"stats":            string(stats),
+		}})
+	}
+
+	if err := d.commit(adds, now); err != nil {
+		d.files.cleanup()
+		return err
+	}
+	return nil
+}
+
+// commit writes the next log version, retrying when a concurrent writer wins
+// the race for a version number.
+func (d *deltaWriter) commit(adds []map[string]any, now int64) error {
+	schema := d.schema()
+	for attempt := 0; attempt < deltaCommitAttempts; attempt++ {
+		version, current, err := readDeltaLog(d.store)
+		if err != nil {
+			return err
+		}
+
+		var actions []any
+		if current == nil {
+			schemaString, _ := json.Marshal(schema)
+			actions = append(actions,
+				map[string]any{"protocol": map[string]int{"minReaderVersion": 1, "minWriterVersion": 2}},
+				map[string]any{"metaData": map[string]any{
+					"id":               newUUID(),
+					"format":           map[string]any{"provider": "parquet", "options": map[string]string{}},
+					"schemaString":     string(schemaString),
+					"partitionColumns": nonNil(d.opts.PartitionBy),
+					"configuration":    map[string]string{},
+					"createdTime":      now,
+				}},
+			)
+		} else if err := current.compatible(schema, d.opts.PartitionBy); err != nil {
+			return fmt.Errorf("Cannot append to delta table %s: %v\n", d.table, err)
+		}
+		for _, add := range adds {
+			actions = append(actions, add)
+		}
+		actions = append(actions, map[string]any{"commitInfo": map[string]any{
+			"timestamp":           now,
+			"operation":           "WRITE",
+			"operationParameters": map[string]string{"mode": "Append"},
+			"engineInfo":          "tea-extract",
+		}})
+
+		var buf bytes.Buffer
+		enc := json.NewEncoder(&buf)
+		for _, action := range actions {
+			if err := enc.Encode(action); err != nil {
+				return err
+			}
+		}
+		logFile := fmt.Sprintf("_delta_log/%020d.json", version+1)
+		err = d.store.claim(logFile, buf.Bytes())
+		if errors.Is(err, os.ErrExist) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("Could not create delta commit %s: %v\n", d.store.location(logFile), err)
+		}
+		return nil
+	}
+	return fmt.Errorf("Could not commit to delta table %s after %d attempts\n", d.table, deltaCommitAttempts)
+}
+
+// Abort removes the data files written so far; nothing was committed yet.
+func (d *deltaWriter) Abort() {
+	if !d.done {
+		d.done = true
+		d.files.cleanup()
+		d.store.Close()
+	}
+}
+
+// schema describes the full result, including partition columns.
+func (d *deltaWriter) schema() deltaSchema {
+	s := deltaSchema{Type: "struct"}
+	for _, c := range d.files.cols {
+		s.Fields = append(s.Fields, deltaField{Name: c.Name, Type: deltaType(c), Nullable: true, Metadata: map[string]any{}})
+	}
+	return s
+}
+
+// deltaType maps a column to its Delta primitive type name.
+func deltaType(c column) string {
+	switch c.kind() {
+	case kindInt:
+		return "long"
+	case kindFloat:
+		return "double"
+	case kindBool:
+		return "boolean"
+	case kindDecimal:
+		return fmt.Sprintf("decimal(%d,%d)", c.Precision, c.Scale)
+	case kindDate:
+		return "date"
+	case kindTimestamp:
+		return "timestamp"
+	case kindBytes:
+		return "binary"
+	default:
+		return "string"
+	}
+}
+
+// deltaPathURI encodes a relative data file path as the URI the log records.
+func deltaPathURI(rel string) string {
+	segments := strings.Split(rel, "/")
+	for i, s := range segments {
+		segments[i] = url.PathEscape(s)
+	}
+	return strings.Join(segments, "/")
+}
+
+// deltaPartitionValue renders a partition value in the form Delta expects.
+func deltaPartitionValue(c column, v any) string {
+	if t, err := asTime(v); err == nil && (c.kind() == kindDate || c.kind() == kindTimestamp) {
+		if c.kind() == kindDate {
+			return t.Format("2006-01-02")
+		}
+		return t.UTC().Format("2006-01-02 15:04:05.999999")
+	}
+	return formatValue(v)
+}
+
+// escapePartitionValue escapes the characters Hive-style partition paths reserve.
+func escapePartitionValue(v string) string {
+	var b strings.Builder
+	for _, r := range v {
+		if r < 0x20 || r == 0x7f || strings.ContainsRune("\"#%'*/:=?\\{[]^", r) {
+			fmt.Fprintf(&b, "%%%02X", r)
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// deltaMetadata is the table metadata in effect at the latest log version.
+type deltaMetadata struct {
+	SchemaString     string   `json:"schemaString"`
+	PartitionColumns []string `json:"partitionColumns"`
+}
+
+// compatible reports whether data with schema s may be appended to the table.
+func (m *deltaMetadata) compatible(s deltaSchema, partitionBy []string) error {
+	var existing deltaSchema
+	if err := json.Unmarshal([]byte(m.SchemaString), &existing); err != nil {
+		return fmt.Errorf("unreadable table schema: %v", err)
+	}
+	if len(existing.Fields) != len(s.Fields) {
+		return fmt.Errorf("table has %d columns, query returned %d", len(existing.Fields), len(s.Fields))
+	}
+	for i, f := range existing.Fields {
+		if !strings.EqualFold(f.Name, s.Fields[i].Name) || f.Type != s.Fields[i].Type {
+			return fmt.Errorf("column %d is %s %s in the table but %s %s in the query", i+1, f.Name, f.Type, s.Fields[i].Name, s.Fields[i].Type)
+		}
+	}
+	if strings.Join(m.PartitionColumns, ",") != strings.Join(partitionBy, ",") {
+		return fmt.Errorf("table is partitioned by [%s], not [%s]", strings.Join(m.PartitionColumns, ","), strings.Join(partitionBy, ","))
+	}
+	return nil
+}
+
+// readDeltaLog returns the latest committed version (-1 for a new table) and
+// the metadata in effect at that version.
+func readDeltaLog(store tableStore) (int64, *deltaMetadata, error) {
+	names, err := store.list("_delta_log")
+	if err != nil {
+		return 0, nil, fmt.Errorf("Could not list the delta log of %s: %v\n", store.location(""), err)
+	}
+	var versions []int64
+	for _, name := range names {
+		name, ok := strings.CutSuffix(name, ".json")
+		if !ok || len(name) != 20 {
+			continue
+		}
+		if v, err := strconv.ParseInt(name, 10, 64); err == nil {
+			versions = append(versions, v)
+		}
+	}
+	if len(versions) == 0 {
+		return -1, nil, nil
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i] < versions[j] })
+
+	var meta *deltaMetadata
+	for _, v := range versions {
+		data, err := store.read(fmt.Sprintf("_delta_log/%020d.json", v))
+		if err != nil {
+			return 0, nil, err
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			var action struct {
+				MetaData *deltaMetadata `json:"metaData"`
+			}
+			if json.Unmarshal([]byte(line), &action) == nil && action.MetaData != nil {
+				meta = action.MetaData
+			}
+		}
+	}
+	if meta == nil {
+		return 0, nil, fmt.Errorf("No table metadata found in the delta log of %s\n", store.location(""))
+	}
+	return versions[len(versions)-1], meta, nil
+}
+
+// newUUID returns a random RFC 4122 version 4 UUID.
+func newUUID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// nonNil returns s, or an empty slice so it serializes as [] rather than null.
+func nonNil(s []string) []string {
+	if s == nil {
+		return []string{}
+	}
+	return s
+}
@@ -0,0 +1,187 @@
+package extract
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// readDeltaCommit returns the actions of log version v of table, each keyed
+// by its action name.
+func readDeltaCommit(t *testing.T, table string, v int) []map[string]json.RawMessage {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(table, "_delta_log", fmt.Sprintf("%020d.json", v)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var actions []map[string]json.RawMessage
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		var a map[string]json.RawMessage
+		if err := json.Unmarshal([]byte(line), &a); err != nil {
+			t.Fatalf("log line %q: %v", line, err)
+		}
+		actions = append(actions, a)
+	}
+	return actions
+}
+
+func writeDelta(t *testing.T, table string, o deltaOptions, cols []column, rows [][]any) error {
+	t.Helper()
+	d, err := newDeltaWriter(table, o)
+	if err != nil {
+		return err
+	}
+	if err := d.WriteHeader(cols); err != nil {
+		d.Abort()
+		return err
+	}
+	for _, row := range rows {
+		if err := d.WriteRow(row); err != nil {
+			d.Abort()
+			return err
+		}
+	}
+	return d.Close()
+}
+
+func TestDeltaCommit(t *testing.T) {
+	table := filepath.Join(t.TempDir(), "orders")
+	cols := []column{{Name: "id", DBType: "BIGINT"}, {Name: "region", DBType: "NVARCHAR"}, {Name: "amount", DBType: "FLOAT"}}
+	o := deltaOptions{PartitionBy: []string{"region"}}
+	rows := [][]any{{int64(1), "EU", 1.5}, {int64(2), "US", 2.5}, {int64(3), "EU", 3.5}, {int64(4), nil, 4.5}}
+	if err := writeDelta(t, table, o, cols, rows); err != nil {
+		t.Fatal(err)
+	}
+
+	actions := readDeltaCommit(t, table, 0)
+	if len(actions) != 6 {
+		t.Fatalf("first commit has %d actions, want protocol, metaData, 3 adds and commitInfo", len(actions))
+	}
+	var protocol struct{ MinReaderVersion, MinWriterVersion int }
+	json.Unmarshal(actions[0]["protocol"], &protocol)
+	if protocol.MinReaderVersion != 1 || protocol.MinWriterVersion != 2 {
+		t.Errorf("protocol %+v", protocol)
+	}
+	var meta struct {
+		SchemaString     string   `json:"schemaString"`
+		PartitionColumns []string `json:"partitionColumns"`
+	}
+	json.Unmarshal(actions[1]["metaData"], &meta)
+	var schema deltaSchema
+	if err := json.Unmarshal([]byte(meta.SchemaString), &schema); err != nil {
+		t.Fatal(err)
+	}
+	if len(schema.Fields) != 3 || schema.Fields[0].Type != "long" || schema.Fields[1].Type != "string" || schema.Fields[2].Type != "double" {
+		t.Errorf("schema %+v", schema.Fields)
+	}
+	if len(meta.PartitionColumns) != 1 || meta.PartitionColumns[0] != "region" {
+		t.Errorf("partition columns %v", meta.PartitionColumns)
+	}
+
+	type add struct {
+		Path            string             `json:"path"`
+		PartitionValues map[string]*string `json:"partitionValues"`
+		Size            int64              `json:"size"`
+		DataChange      bool               `json:"dataChange"`
+		Stats           string             `json:"stats"`
+	}
+	var adds []add
+	for _, a := range actions[2:5] {
+		var x add
+		if err := json.Unmarshal(a["add"], &x); err != nil || x.Path == "" {
+			t.Fatalf("expected an add action, got %v", a)
+		}
+		adds = append(adds, x)
+	}
+	records := map[string]string{}
+	for _, a := range adds {
+		region := "null"
+		if v := a.PartitionValues["region"]; v != nil {
+			region = *v
+		}
+		records[region] = a.Stats
+		info, err := os.Stat(filepath.Join(table, filepath.FromSlash(a.Path)))
+		if err != nil || info.Size() != a.Size || !a.DataChange {
+			t.Errorf("add %+v does not match its data file: %v", a, err)
+		}
+	}
+	if records["EU"] != `{"numRecords":2}` || records["US"] != `{"numRecords":1}` || records["null"] != `{"numRecords":1}` {
+		t.Errorf("records per partition %v", records)
+	}
+	if !strings.HasPrefix(adds[0].Path, "region=EU/") || !strings.HasPrefix(adds[1].Path, "region=US/") || !strings.HasPrefix(adds[2].Path, "region=__HIVE_DEFAULT_PARTITION__/") {
+		t.Errorf("data file paths %s, %s, %s", adds[0].Path, adds[1].Path, adds[2].Path)
+	}
+	var info struct {
+		Operation           string            `json:"operation"`
+		OperationParameters map[string]string `json:"operationParameters"`
+	}
+	json.Unmarshal(actions[5]["commitInfo"], &info)
+	if info.Operation != "WRITE" || info.OperationParameters["mode"] != "Append" {
+		t.Errorf("commit info %+v", info)
+	}
+
+	// the next append is a new version that keeps the metadata
+	if err := writeDelta(t, table, o, cols, rows[:1]); err != nil {
+		t.Fatal(err)
+	}
+	actions = readDeltaCommit(t, table, 1)
+	if len(actions) != 2 || actions[0]["add"] == nil || actions[1]["commitInfo"] == nil {
+		t.Errorf("append commit %v", actions)
+	}
+	if v, _, err := readDeltaLog(localTable(table)); v != 1 || err != nil {
+		t.Errorf("latest version %d, %v", v, err)
+	}
+}
+
+func TestDeltaRejects(t *testing.T) {
+	table := filepath.Join(t.TempDir(), "orders")
+	cols := []column{{Name: "id", DBType: "BIGINT"}, {Name: "region", DBType: "NVARCHAR"}}
+	if err := writeDelta(t, table, deltaOptions{}, cols, [][]any{{int64(1), "EU"}}); err != nil {
+		t.Fatal(err)
+	}
+	files := func() int {
+		n := 0
+		filepath.Walk(table, func(path string, info os.FileInfo, err error) error {
+			if strings.HasSuffix(path, ".parquet") {
+				n++
+			}
+			return nil
+		})
+		return n
+	}
+
+	changed := []column{{Name: "id", DBType: "NVARCHAR"}, {Name: "region", DBType: "NVARCHAR"}}
+	if err := writeDelta(t, table, deltaOptions{}, changed, [][]any{{"1", "EU"}}); err == nil || !strings.Contains(err.Error(), "column 1") {
+		t.Errorf("appended a different schema: %v", err)
+	}
+	if err := writeDelta(t, table, deltaOptions{PartitionBy: []string{"region"}}, cols, [][]any{{int64(1), "EU"}}); err == nil || !strings.Contains(err.Error(), "partitioned") {
+		t.Errorf("appended with different partitioning: %v", err)
+	}
+	if n := files(); n != 1 {
+		t.Errorf("rejected appends left %d data files, want 1", n)
+	}
+
+	d, err := newDeltaWriter(table, deltaOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.WriteHeader(cols)
+	d.WriteRow([]any{int64(2), "US"})
+	d.Abort()
+	if n := files(); n != 1 {
+		t.Errorf("an aborted append left %d data files, want 1", n)
+	}
+	if v, _, _ := readDeltaLog(localTable(table)); v != 0 {
+		t.Errorf("rejected appends committed version %d", v)
+	}
+
+	if _, err := newDeltaWriter("ftp://lake/orders", deltaOptions{}); err == nil {
+		t.Error("opened a table in an unsupported store")
+	}
+	if err := writeDelta(t, table, deltaOptions{PartitionBy: []string{"missing"}}, cols, nil); err == nil {
+		t.Error("partitioned by a column not in the result")
+	}
+}
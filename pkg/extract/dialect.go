@@ -0,0 +1,246 @@
+package extract
+
+import (
+	"fmt"
+	"strings"
+)
+
+// dialect generates the SQL for wrapping a query in a row limit, a random
+// sample or a zero-row probe on one kind of source database.
+type dialect interface {
+	// limit returns at most n rows of query.
+	limit(query string, n int) string
+	// sample keeps roughly percent of the rows of query.
+	sample(query string, percent float64) string
+	// randomRows returns at most n rows of query picked at random.
+	randomRows(query string, n int) string
+	// probe returns no rows but the same columns as query.
+	probe(query string) string
+	// selectFrom returns SELECT what FROM query, as a derived table named
+	// src, followed by rest, such as a WHERE clause.
+	selectFrom(query, what, rest string) string
+}
+
+// dialects maps database/sql driver names to their dialect.
+var dialects = map[string]dialect{
+	"sqlserver": sqlServerDialect{},
+	"mssql":     sqlServerDialect{},
+	"postgres":  postgresDialect{limitDialect{random: "random()"}},
+	"pgx":       postgresDialect{limitDialect{random: "random()"}},
+	"mysql":     limitDialect{random: "RAND()"},
+	"sqlite3":   limitDialect{random: "(abs(random()) / 9223372036854775807.0)"},
+	"oracle":    oracleDialect{},
+	"godror":    oracleDialect{},
+}
+
+// dialectFor returns the dialect of a driver.
+func dialectFor(driver string) (dialect, error) {
+	d, ok := dialects[driver]
+	if !ok {
+		return nil, fmt.Errorf("No SQL dialect is known for driver '%s'\n", driver)
+	}
+	return d, nil
+}
+
+// subquery prepares query for use as a derived table by dropping the
+// trailing semicolon.
+func subquery(query string) string {
+	return strings.TrimRight(strings.TrimSpace(query), "; \t\r\n")
+}
+
+// sqlServerDialect uses TOP and NEWID(). SQL Server takes neither a WITH
+// nor an ORDER BY without TOP or OFFSET in a derived table, so a leading
+// WITH is kept in front of the wrapping statement and a trailing ORDER BY
+// becomes an OFFSET FETCH for a limit and is dropped otherwise.
+type sqlServerDialect struct{}
+
+func (sqlServerDialect) limit(query string, n int) string {
+	with, body, order := splitSQLServerQuery(query)
+	if order != "" && n > 0 {
+		return fmt.Sprintf("%s%s %s OFFSET 0 ROWS FETCH NEXT %d ROWS ONLY", with, body, order, n)
+	}
+	return fmt.Sprintf("%sSELECT TOP (%d) * FROM (%s) AS src", with, n, body)
+}
+
+func (d sqlServerDialect) sample(query string, percent float64) string {
+	return d.selectFrom(query, "*", fmt.Sprintf("WHERE ABS(CHECKSUM(NEWID())) %% 10000 < %d", int(percent*100)))
+}
+
+func (sqlServerDialect) randomRows(query string, n int) string {
+	with, body, _ := splitSQLServerQuery(query)
+	return fmt.Sprintf("%sSELECT TOP (%d) * FROM (%s) AS src ORDER BY NEWID()", with, n, body)
+}
+
+func (d sqlServerDialect) probe(query string) string {
+	return d.limit(query, 0)
+}
+
+func (sqlServerDialect) selectFrom(query, what, rest string) string {
+	with, body, _ := splitSQLServerQuery(query)
+	return with + joinClauses(fmt.Sprintf("SELECT %s FROM (%s) AS src", what, body), rest)
+}
+
+// splitSQLServerQuery splits query into its WITH clause, up to the main
+// statement, the main statement, and its trailing ORDER BY. The ORDER BY is
+// left in the statement when it comes with an OFFSET, or the statement has
+// a TOP, as it then picks the rows. A WITH clause that cannot be read is
+// left in the statement.
+func splitSQLServerQuery(query string) (with, body, order string) {
+	q := []rune(strings.TrimLeft(subquery(query), "; \t\r\n"))
+	toks := tokenizeSQL(string(q))
+	depth := sqlDepths(toks)
+	main := 0
+	if len(toks) > 0 && toks[0].isWord("WITH") {
+		if i, ok := skipCTEs(toks, depth); ok {
+			main = i
+		}
+	}
+	if main == len(toks) {
+		return "", string(q), ""
+	}
+	start := toks[main].pos
+	orderAt := -1
+	for i := main; i < len(toks); i++ {
+		switch t := toks[i]; {
+		case depth[i] > 0:
+		case t.isWord("TOP"):
+			return string(q[:start]), string(q[start:]), ""
+		case t.isWord("ORDER") && i+1 < len(toks) && toks[i+1].isWord("BY"):
+			orderAt = i
+		case t.isWord("OFFSET") && orderAt >= 0:
+			return string(q[:start]), string(q[start:]), ""
+		}
+	}
+	if orderAt < 0 {
+		return string(q[:start]), string(q[start:]), ""
+	}
+	end := toks[orderAt].pos
+	return string(q[:start]), strings.TrimSpace(string(q[start:end])), string(q[end:])
+}
+
+// sqlDepths returns how deep in parentheses each token is; a parenthesis
+// is at the depth of what surrounds it.
+func sqlDepths(toks []sqlToken) []int {
+	depths := make([]int, len(toks))
+	depth := 0
+	for i, t := range toks {
+		if t.kind == ')' {
+			depth--
+		}
+		depths[i] = depth
+		if t.kind == '(' {
+			depth++
+		}
+	}
+	return depths
+}
+
+// skipCTEs returns the index of the first token after the common table
+// expressions of a WITH clause: name [(columns)] AS (query), repeated with
+// commas.
+func skipCTEs(toks []sqlToken, depth []int) (int, bool) {
+	closing := func(open int) int {
+		for i := open + 1; i < len(toks); i++ {
+			if toks[i].kind == ')' && depth[i] == depth[open] {
+				return i
+			}
+		}
+		return len(toks)
+	}
+	i := 1
+	for {
+		if i >= len(toks) || (toks[i].kind != 'w' && toks[i].kind != 'q') {
+			return 0, false
+		}
+		i++
+		if i < len(toks) && toks[i].kind == '(' {
+			i = closing(i) + 1
+		}
+		if i >= len(toks) || !toks[i].isWord("AS") {
+			return 0, false
+		}
+		i++
+		if i >= len(toks) || toks[i].kind != '(' {
+			return 0, false
+		}
+		i = closing(i) + 1
+		if i < len(toks) && toks[i].kind == ',' {
+			i++
+			continue
+		}
+		return i, i < len(toks)
+	}
+}
+
+// joinClauses appends rest to stmt, when there is one.
+func joinClauses(stmt, rest string) string {
+	if rest == "" {
+		return stmt
+	}
+	return stmt + " " + rest
+}
+
+// limitDialect covers the databases using LIMIT, differing only in their
+// random number function.
+type limitDialect struct {
+	random string
+}
+
+func (limitDialect) limit(query string, n int) string {
+	return fmt.Sprintf("SELECT * FROM (%s) AS src LIMIT %d", subquery(query), n)
+}
+
+func (d limitDialect) sample(query string, percent float64) string {
+	return d.selectFrom(query, "*", fmt.Sprintf("WHERE %s < %g", d.random, percent/100))
+}
+
+func (d limitDialect) randomRows(query string, n int) string {
+	return d.selectFrom(query, "*", fmt.Sprintf("ORDER BY %s LIMIT %d", d.random, n))
+}
+
+func (d limitDialect) probe(query string) string {
+	return d.limit(query, 0)
+}
+
+func (limitDialect) selectFrom(query, what, rest string) string {
+	return joinClauses(fmt.Sprintf("SELECT %s FROM (%s) AS src", what, subquery(query)), rest)
+}
+
+// postgresDialect is the limitDialect of Postgres, which also estimates rows.
+type postgresDialect struct {
+	limitDialect
+}
+
+// oracleDialect uses FETCH FIRST, which needs Oracle 12c or later.
+type oracleDialect struct{}
+
+func (oracleDialect) limit(query string, n int) string {
+	return fmt.Sprintf("SELECT * FROM (%s) src FETCH FIRST %d ROWS ONLY", subquery(query), n)
+}
+
+func (oracleDialect) sample(query string, percent float64) string {
+	return fmt.Sprintf("SELECT * FROM (%s) src WHERE DBMS_RANDOM.VALUE < %g", subquery(query), percent/100)
+}
+
+func (oracleDialect) randomRows(query string, n int) string {
+	return fmt.Sprintf("SELECT * FROM (%s) src ORDER BY DBMS_RANDOM.VALUE FETCH FIRST %d ROWS ONLY", subquery(query), n)
+}
+
+func (oracleDialect) probe(query string) string {
+	return fmt.Sprintf("SELECT * FROM (%s) src WHERE 1 = 0", subquery(query))
+}
+
+func (oracleDialect) selectFrom(query, what, rest string) string {
+	return joinClauses(fmt.Sprintf("SELECT %s FROM (%s) src", what, subquery(query)), rest)
+}
+
+// wrapQuery applies the run's sampling and row limit to query.
+func wrapQuery(c *config, query string) string {
+	if c.sample > 0 && c.sample < 100 {
+		query = c.dialect.sample(query, c.sample)
+	}
+	if c.limit > 0 {
+		query = c.dialect.limit(query, c.limit)
+	}
+	return query
+}
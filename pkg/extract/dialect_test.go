@@ -0,0 +1,123 @@
+package extract
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestSQLServerDialect(t *testing.T) {
+	d := sqlServerDialect{}
+	cte := ";WITH a AS (SELECT id FROM t ORDER BY id OFFSET 0 ROWS), [b] (id) AS (SELECT id FROM a) "
+	for _, tc := range []struct {
+		query, limit, probe string
+	}{
+		{"SELECT * FROM t;",
+			"SELECT TOP (5) * FROM (SELECT * FROM t) AS src",
+			"SELECT TOP (0) * FROM (SELECT * FROM t) AS src"},
+		// a derived table cannot be ordered, so the limit keeps the order itself
+		{"SELECT * FROM t ORDER BY id DESC",
+			"SELECT * FROM t ORDER BY id DESC OFFSET 0 ROWS FETCH NEXT 5 ROWS ONLY",
+			"SELECT TOP (0) * FROM (SELECT * FROM t) AS src"},
+		{"SELECT a FROM t UNION ALL SELECT a FROM u ORDER BY 1",
+			"SELECT a FROM t UNION ALL SELECT a FROM u ORDER BY 1 OFFSET 0 ROWS FETCH NEXT 5 ROWS ONLY",
+			"SELECT TOP (0) * FROM (SELECT a FROM t UNION ALL SELECT a FROM u) AS src"},
+		// with TOP or OFFSET the ORDER BY picks the rows and is allowed
+		{"SELECT TOP 10 * FROM t ORDER BY id",
+			"SELECT TOP (5) * FROM (SELECT TOP 10 * FROM t ORDER BY id) AS src",
+			"SELECT TOP (0) * FROM (SELECT TOP 10 * FROM t ORDER BY id) AS src"},
+		{"SELECT * FROM t ORDER BY id OFFSET 10 ROWS",
+			"SELECT TOP (5) * FROM (SELECT * FROM t ORDER BY id OFFSET 10 ROWS) AS src",
+			"SELECT TOP (0) * FROM (SELECT * FROM t ORDER BY id OFFSET 10 ROWS) AS src"},
+		// ORDER BY in windows, strings and comments is not the query's
+		{"SELECT ROW_NUMBER() OVER (ORDER BY id) AS n, 'ORDER BY x' AS s /* ORDER BY s */ FROM t",
+			"SELECT TOP (5) * FROM (SELECT ROW_NUMBER() OVER (ORDER BY id) AS n, 'ORDER BY x' AS s /* ORDER BY s */ FROM t) AS src",
+			"SELECT TOP (0) * FROM (SELECT ROW_NUMBER() OVER (ORDER BY id) AS n, 'ORDER BY x' AS s /* ORDER BY s */ FROM t) AS src"},
+		// the WITH clause stays in front of the wrapping statement
+		{cte + "SELECT * FROM [b]",
+			cte[1:] + "SELECT TOP (5) * FROM (SELECT * FROM [b]) AS src",
+			cte[1:] + "SELECT TOP (0) * FROM (SELECT * FROM [b]) AS src"},
+		{cte + "SELECT * FROM [b] ORDER BY id",
+			cte[1:] + "SELECT * FROM [b] ORDER BY id OFFSET 0 ROWS FETCH NEXT 5 ROWS ONLY",
+			cte[1:] + "SELECT TOP (0) * FROM (SELECT * FROM [b]) AS src"},
+	} {
+		if got := d.limit(tc.query, 5); got != tc.limit {
+			t.Errorf("limit of %s:\n got %s\nwant %s", tc.query, got, tc.limit)
+		}
+		if got := d.probe(tc.query); got != tc.probe {
+			t.Errorf("probe of %s:\n got %s\nwant %s", tc.query, got, tc.probe)
+		}
+	}
+
+	if got, want := d.sample(cte+"SELECT * FROM [b] ORDER BY id", 12.5), cte[1:]+"SELECT * FROM (SELECT * FROM [b]) AS src WHERE ABS(CHECKSUM(NEWID())) % 10000 < 1250"; got != want {
+		t.Errorf("sample:\n got %s\nwant %s", got, want)
+	}
+	if got, want := d.randomRows(cte+"SELECT * FROM [b] ORDER BY id", 5), cte[1:]+"SELECT TOP (5) * FROM (SELECT * FROM [b]) AS src ORDER BY NEWID()"; got != want {
+		t.Errorf("random rows:\n got %s\nwant %s", got, want)
+	}
+	if got, want := d.selectFrom(cte+"SELECT * FROM [b]", "MIN(id), MAX(id)", ""), cte[1:]+"SELECT MIN(id), MAX(id) FROM (SELECT * FROM [b]) AS src"; got != want {
+		t.Errorf("select from:\n got %s\nwant %s", got, want)
+	}
+	// a WITH clause it cannot read is left to the server to reject
+	if got, want := d.probe("WITH XMLNAMESPACES ('urn:x' AS x) SELECT 1"), "SELECT TOP (0) * FROM (WITH XMLNAMESPACES ('urn:x' AS x) SELECT 1) AS src"; got != want {
+		t.Errorf("probe:\n got %s\nwant %s", got, want)
+	}
+}
+
+func TestOtherDialects(t *testing.T) {
+	query := "WITH a AS (SELECT 1 AS id) SELECT * FROM a ORDER BY id;"
+	for _, tc := range []struct {
+		driver, limit, sample, random, probe, selectFrom string
+	}{
+		{"postgres",
+			"SELECT * FROM (WITH a AS (SELECT 1 AS id) SELECT * FROM a ORDER BY id) AS src LIMIT 5",
+			"SELECT * FROM (WITH a AS (SELECT 1 AS id) SELECT * FROM a ORDER BY id) AS src WHERE random() < 0.1",
+			"SELECT * FROM (WITH a AS (SELECT 1 AS id) SELECT * FROM a ORDER BY id) AS src ORDER BY random() LIMIT 5",
+			"SELECT * FROM (WITH a AS (SELECT 1 AS id) SELECT * FROM a ORDER BY id) AS src LIMIT 0",
+			"SELECT MIN(id) FROM (WITH a AS (SELECT 1 AS id) SELECT * FROM a ORDER BY id) AS src WHERE id > 0"},
+		{"mysql",
+			"SELECT * FROM (WITH a AS (SELECT 1 AS id) SELECT * FROM a ORDER BY id) AS src LIMIT 5",
+			"SELECT * FROM (WITH a AS (SELECT 1 AS id) SELECT * FROM a ORDER BY id) AS src WHERE RAND() < 0.1",
+			"SELECT * FROM (WITH a AS (SELECT 1 AS id) SELECT * FROM a ORDER BY id) AS src ORDER BY RAND() LIMIT 5",
+			"SELECT * FROM (WITH a AS (SELECT 1 AS id) SELECT * FROM a ORDER BY id) AS src LIMIT 0",
+			"SELECT MIN(id) FROM (WITH a AS (SELECT 1 AS id) SELECT * FROM a ORDER BY id) AS src WHERE id > 0"},
+		{"oracle",
+			"SELECT * FROM (WITH a AS (SELECT 1 AS id) SELECT * FROM a ORDER BY id) src FETCH FIRST 5 ROWS ONLY",
+			"SELECT * FROM (WITH a AS (SELECT 1 AS id) SELECT * FROM a ORDER BY id) src WHERE DBMS_RANDOM.VALUE < 0.1",
+			"SELECT * FROM (WITH a AS (SELECT 1 AS id) SELECT * FROM a ORDER BY id) src ORDER BY DBMS_RANDOM.VALUE FETCH FIRST 5 ROWS ONLY",
+			"SELECT * FROM (WITH a AS (SELECT 1 AS id) SELECT * FROM a ORDER BY id) src WHERE 1 = 0",
+			"SELECT MIN(id) FROM (WITH a AS (SELECT 1 AS id) SELECT * FROM a ORDER BY id) src WHERE id > 0"},
+	} {
+		d, err := dialectFor(tc.driver)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, got := range []struct{ got, want string }{
+			{d.limit(query, 5), tc.limit},
+			{d.sample(query, 10), tc.sample},
+			{d.randomRows(query, 5), tc.random},
+			{d.probe(query), tc.probe},
+			{d.selectFrom(query, "MIN(id)", "WHERE id > 0"), tc.selectFrom},
+		} {
+			if got.got != got.want {
+				t.Errorf("%s:\n got %s\nwant %s", tc.driver, got.got, got.want)
+			}
+		}
+	}
+	if _, err := dialectFor("db2"); err == nil {
+		t.Error("a dialect for an unknown driver")
+	}
+}
+
+func TestExportDataLimitWithCTE(t *testing.T) {
+	query := "WITH recent AS (SELECT * FROM dbo.Orders WHERE created > '2026-01-01') SELECT * FROM recent ORDER BY id DESC"
+	registerFake("WITH recent AS (SELECT * FROM dbo.Orders WHERE created > '2026-01-01') SELECT * FROM recent ORDER BY id DESC OFFSET 0 ROWS FETCH NEXT 2 ROWS ONLY",
+		&fakeQuery{sets: []*fakeResult{numbersResult(2)}})
+	outFile := filepath.Join(t.TempDir(), "out.csv")
+	got, err := exportFake(t, &config{limit: 2}, query, outFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "id,name\n1,name 1\n2,name 2\n"; got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
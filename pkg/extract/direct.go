@@ -0,0 +1,128 @@
+package extract
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"unsafe"
+)
+
+// directBlock is how much a direct write hands to the file at once, a
+// multiple of the alignment every file system asks of direct I/O.
+const (
+	directAlign = 4096
+	directBlock = 1 << 20
+)
+
+// direct reports whether the job writing outFile writes its file bypassing
+// the page cache.
+func (c *config) direct(outFile string) bool {
+	e, _ := c.extract(outFile)
+	return c.IO.Direct || e.Direct
+}
+
+// createFile creates the local file at path for the job writing outFile, and
+// the writer to write it through. With direct I/O the writer must be closed
+// before the file; where direct I/O is not supported the file is written
+// through the page cache as usual.
+func (c *config) createFile(path, outFile string) (*os.File, io.Writer, *directWriter, error) {
+	if !c.direct(outFile) {
+		f, err := os.Create(path)
+		return f, f, nil, err
+	}
+	f, ok, err := openDirect(path)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if !ok {
+		log.Printf("Warning: direct I/O is not supported for %s, writing it through the page cache\n", path)
+		return f, f, nil, nil
+	}
+	d := newDirectWriter(f)
+	return f, d, d, nil
+}
+
+// directWriter writes to a file opened for direct I/O in aligned blocks.
+// The tail that does not fill a block is written through the page cache on
+// Close, and dropped from it.
+type directWriter struct {
+	f   *os.File
+	buf []byte
+	n   int
+	// direct is false once the file system turned down a direct write.
+	direct bool
+}
+
+func newDirectWriter(f *os.File) *directWriter {
+	// direct I/O wants the memory aligned too
+	mem := make([]byte, directBlock+directAlign)
+	off := 0
+	if r := addrOf(mem) % directAlign; r != 0 {
+		off = directAlign - int(r)
+	}
+	return &directWriter{f: f, buf: mem[off : off+directBlock], direct: true}
+}
+
+func (d *directWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		m := copy(d.buf[d.n:], p)
+		d.n += m
+		written += m
+		p = p[m:]
+		if d.n == len(d.buf) {
+			if err := d.flush(d.n); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+// flush writes the first n bytes of the buffer, a multiple of directAlign
+// unless the file is no longer written directly, and keeps the rest.
+func (d *directWriter) flush(n int) error {
+	if n == 0 {
+		return nil
+	}
+	m, err := d.f.Write(d.buf[:n])
+	if err != nil && m == 0 && d.direct {
+		// some file systems accept the open but not the write
+		if endDirect(d.f) == nil {
+			log.Printf("Warning: direct I/O failed for %s, writing it through the page cache: %v\n", d.f.Name(), err)
+			d.direct = false
+			_, err = d.f.Write(d.buf[:n])
+		}
+	}
+	if err != nil {
+		return err
+	}
+	d.n = copy(d.buf, d.buf[n:d.n])
+	return nil
+}
+
+// Close writes what is left in the buffer. It does not close the file.
+func (d *directWriter) Close() error {
+	aligned := d.n - d.n%directAlign
+	if err := d.flush(aligned); err != nil {
+		return err
+	}
+	if d.n == 0 {
+		return nil
+	}
+	if d.direct {
+		if err := endDirect(d.f); err != nil {
+			return fmt.Errorf("could not write the end of %s: %v", d.f.Name(), err)
+		}
+		d.direct = false
+	}
+	if err := d.flush(d.n); err != nil {
+		return err
+	}
+	return dropCache(d.f)
+}
+
+func addrOf(b []byte) uintptr {
+	return uintptr(unsafe.Pointer(unsafe.SliceData(b)))
+}
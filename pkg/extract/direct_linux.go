@@ -0,0 +1,41 @@
+package extract
+
+import (
+	"errors"
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// openDirect creates the file at path for direct I/O. A file system that
+// does not support it, such as tmpfs, gets the file opened as usual and ok
+// false.
+func openDirect(path string) (f *os.File, ok bool, err error) {
+	f, err = os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC|syscall.O_DIRECT, 0o666)
+	if errors.Is(err, syscall.EINVAL) {
+		f, err = os.Create(path)
+		return f, false, err
+	}
+	return f, err == nil, err
+}
+
+// endDirect switches f back to writes through the page cache.
+func endDirect(f *os.File) error {
+	fd := int(f.Fd())
+	flags, err := unix.FcntlInt(uintptr(fd), unix.F_GETFL, 0)
+	if err != nil {
+		return err
+	}
+	_, err = unix.FcntlInt(uintptr(fd), unix.F_SETFL, flags&^unix.O_DIRECT)
+	return err
+}
+
+// dropCache writes out the pages of f still in the page cache and drops
+// them.
+func dropCache(f *os.File) error {
+	if err := unix.Fdatasync(int(f.Fd())); err != nil {
+		return err
+	}
+	return unix.Fadvise(int(f.Fd()), 0, 0, unix.FADV_DONTNEED)
+}
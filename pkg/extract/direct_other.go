@@ -0,0 +1,20 @@
+//go:build !linux && !darwin
+
+package extract
+
+import "os"
+
+// openDirect creates the file at path as usual: direct I/O is only supported
+// on Linux and macOS.
+func openDirect(path string) (*os.File, bool, error) {
+	f, err := os.Create(path)
+	return f, false, err
+}
+
+func endDirect(f *os.File) error {
+	return nil
+}
+
+func dropCache(f *os.File) error {
+	return nil
+}
@@ -0,0 +1,56 @@
+package extract
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDirectWriter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "big.csv")
+	f, direct, err := openDirect(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !direct {
+		t.Log("no direct I/O here, testing the blocks through the page cache")
+	}
+	d := newDirectWriter(f)
+	if addrOf(d.buf)%directAlign != 0 {
+		t.Fatal("buffer is not aligned")
+	}
+
+	var want bytes.Buffer
+	line := []byte("4711,Acme Corp,2026-10-14,129.95\n")
+	for want.Len() < 2*directBlock+12345 {
+		want.Write(line)
+		if _, err := d.Write(line); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want.Bytes()) {
+		t.Errorf("wrote %d bytes, want %d", len(got), want.Len())
+	}
+}
+
+func TestDirectOption(t *testing.T) {
+	c := &config{Extracts: []extract{{OutFile: "a.csv"}, {OutFile: "b.csv", Direct: true}}}
+	if c.direct("a.csv") || !c.direct("b.csv") {
+		t.Error("direct follows the extract")
+	}
+	c.IO.Direct = true
+	if !c.direct("a.csv") {
+		t.Error("io direct applies to every extract")
+	}
+}
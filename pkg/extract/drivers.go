@@ -0,0 +1,98 @@
+package extract
+
+import (
+	"database/sql"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+	_ "github.com/jackc/pgx/v5/stdlib"
+)
+
+// sourceDriver connects to one kind of source database. name is its
+// database/sql driver and dsn builds the connection string for a login; an
+// empty user leaves it to the driver, such as the integrated Windows login of
+// SQL Server or the PGUSER of PostgreSQL.
+type sourceDriver struct {
+	name string
+	dsn  func(server, database, user, password string) string
+	// semicolons are set for drivers whose connection string cannot quote them.
+	semicolons bool
+	// placeholder references the nth positional parameter of a query, for
+	// drivers without named parameters.
+	placeholder func(n int) string
+}
+
+// sourceDrivers maps the driver setting to its driver.
+var sourceDrivers = map[string]sourceDriver{
+	"sqlserver": {name: "sqlserver", dsn: sqlServerDSN, semicolons: true},
+	"postgres":  {name: "pgx", dsn: postgresDSN, placeholder: func(n int) string { return fmt.Sprintf("$%d", n) }},
+	"mysql":     {name: "mysql", dsn: mysqlDSN, placeholder: func(int) string { return "?" }},
+}
+
+// sourceDriver returns the driver of the configured source database, SQL
+// Server unless driver is set.
+func (c *config) sourceDriver() (sourceDriver, error) {
+	name := c.Driver
+	if name == "" {
+		name = "sqlserver"
+	}
+	d, ok := sourceDrivers[name]
+	if !ok {
+		return sourceDriver{}, fmt.Errorf("Unsupported driver '%s', use sqlserver, postgres or mysql\n", c.Driver)
+	}
+	return d, nil
+}
+
+// open connects to database on server with the login a, reading its
+// password from the environment.
+func (d sourceDriver) open(server, database string, a authOptions) (*sql.DB, error) {
+	if a.method() == authAzureAD {
+		return openAzureAD(server, database, a)
+	}
+	var password string
+	if a.User != "" {
+		password = os.Getenv(a.PasswordEnv)
+		if d.semicolons && strings.Contains(password, ";") {
+			return nil, fmt.Errorf("The password in %s cannot contain ';'\n", a.PasswordEnv)
+		}
+	}
+	dsn := d.dsn(server, database, a.User, password)
+	if a.SPN != "" {
+		dsn += fmt.Sprintf("ServerSPN=%s;", a.SPN)
+	}
+	db, err := sql.Open(d.name, dsn)
+	if err != nil {
+		return nil, fmt.Errorf("Could not connect to %s: %v\n", server, err)
+	}
+	return db, nil
+}
+
+func sqlServerDSN(server, database, user, password string) string {
+	if user == "" {
+		return connectionString(server, database)
+	}
+	return fmt.Sprintf("server=%s;user id=%s;password=%s;database=%s;", server, user, password, database)
+}
+
+// postgresDSN returns a postgres:// URL; server may carry a port.
+func postgresDSN(server, database, user, password string) string {
+	u := url.URL{Scheme: "postgres", Host: server, Path: "/" + database}
+	if user != "" {
+		u.User = url.UserPassword(user, password)
+	}
+	return u.String()
+}
+
+// mysqlDSN parses DATETIME and DATE values as times, in UTC by the driver's
+// default, as the other drivers return them; server may carry a port.
+func mysqlDSN(server, database, user, password string) string {
+	cfg := mysql.NewConfig()
+	cfg.User, cfg.Passwd = user, password
+	cfg.Net, cfg.Addr = "tcp", server
+	cfg.DBName = database
+	cfg.ParseTime = true
+	return cfg.FormatDSN()
+}
@@ -0,0 +1,854 @@
+package extract
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// exprNode is a parsed expression over the values of one row. Column
+// references are resolved by bind before the first eval.
+type exprNode interface {
+	bind(b *exprBinder) error
+	eval(row []any) (any, error)
+}
+
+// exprVar is a run variable available to expressions, such as run_date.
+type exprVar struct {
+	value  any
+	dbType string
+}
+
+// exprBinder resolves identifiers to column positions, falling back to
+// run variables. used collects the positions referenced.
+type exprBinder struct {
+	columns map[string]int
+	vars    map[string]exprVar
+	used    map[int]bool
+}
+
+// newExprBinder indexes cols by lower-cased name.
+func newExprBinder(cols []column, vars map[string]exprVar) *exprBinder {
+	b := &exprBinder{columns: make(map[string]int, len(cols)), vars: vars, used: make(map[int]bool)}
+	for i, c := range cols {
+		b.columns[strings.ToLower(c.Name)] = i
+	}
+	return b
+}
+
+// compileExpr parses src and binds it to cols.
+func compileExpr(src string, cols []column, vars map[string]exprVar) (exprNode, error) {
+	n, _, err := compileExprRefs(src, cols, vars)
+	return n, err
+}
+
+// compileExprRefs compiles src as compileExpr does and also returns the
+// columns of cols it references, in order.
+func compileExprRefs(src string, cols []column, vars map[string]exprVar) (exprNode, []column, error) {
+	n, err := parseExpr(src)
+	if err != nil {
+		return nil, nil, err
+	}
+	b := newExprBinder(cols, vars)
+	if err := n.bind(b); err != nil {
+		return nil, nil, fmt.Errorf("%s: %v", src, err)
+	}
+	var refs []column
+	for i, c := range cols {
+		if b.used[i] {
+			refs = append(refs, c)
+		}
+	}
+	return n, refs, nil
+}
+
+// parseExpr parses an expression such as
+//
+//	status <> 'TEST' AND amount >= 10 AND email NOT LIKE '%@example.com'
+func parseExpr(src string) (exprNode, error) {
+	toks, err := lexExpr(src)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", src, err)
+	}
+	p := &exprParser{toks: toks}
+	n, err := p.parseOr()
+	if err == nil && p.peek().kind != tokEOF {
+		err = fmt.Errorf("unexpected %s", p.peek())
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", src, err)
+	}
+	return n, nil
+}
+
+type tokKind int
+
+const (
+	tokEOF tokKind = iota
+	tokIdent
+	tokNumber
+	tokString
+	tokOp
+)
+
+// token is a lexed token; pos is the column it starts at, counted in
+// characters from 1, for error messages.
+type token struct {
+	kind tokKind
+	text string
+	pos  int
+}
+
+func (t token) String() string {
+	if t.kind == tokEOF {
+		return "end of expression"
+	}
+	return fmt.Sprintf("'%s' at column %d", t.text, t.pos)
+}
+
+// keyword reports whether t is the (case-insensitive) bare word kw.
+func (t token) keyword(kw string) bool {
+	return t.kind == tokIdent && !strings.ContainsAny(t.text[:1], `["`) && strings.EqualFold(t.text, kw)
+}
+
+// lexExpr splits src into tokens. Identifiers may be quoted with [brackets]
+// or "double quotes"; strings use single quotes, doubled to escape them.
+func lexExpr(src string) ([]token, error) {
+	var toks []token
+	rs := []rune(src)
+	for i := 0; i < len(rs); {
+		r := rs[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '\'':
+			start := i
+			var b strings.Builder
+			for i++; ; i++ {
+				if i >= len(rs) {
+					return nil, fmt.Errorf("unterminated string at column %d", start+1)
+				}
+				if rs[i] == '\'' {
+					if i+1 < len(rs) && rs[i+1] == '\'' {
+						i++
+					} else {
+						i++
+						break
+					}
+				}
+				b.WriteRune(rs[i])
+			}
+			toks = append(toks, token{tokString, b.String(), start + 1})
+		case r == '[' || r == '"':
+			end := ']'
+			if r == '"' {
+				end = '"'
+			}
+			j := i + 1
+			for j < len(rs) && rs[j] != end {
+				j++
+			}
+			if j >= len(rs) {
+				return nil, fmt.Errorf("unterminated identifier at column %d", i+1)
+			}
+			toks = append(toks, token{tokIdent, string(rs[i : j+1]), i + 1})
+			i = j + 1
+		case unicode.IsDigit(r) || (r == '.' && i+1 < len(rs) && unicode.IsDigit(rs[i+1])):
+			j := i
+			for j < len(rs) && (unicode.IsDigit(rs[j]) || rs[j] == '.') {
+				j++
+			}
+			toks = append(toks, token{tokNumber, string(rs[i:j]), i + 1})
+			i = j
+		case unicode.IsLetter(r) || r == '_':
+			j := i
+			for j < len(rs) && (unicode.IsLetter(rs[j]) || unicode.IsDigit(rs[j]) || rs[j] == '_') {
+				j++
+			}
+			toks = append(toks, token{tokIdent, string(rs[i:j]), i + 1})
+			i = j
+		default:
+			op := string(r)
+			if i+1 < len(rs) {
+				switch two := string(rs[i : i+2]); two {
+				case "==", "!=", "<>", "<=", ">=", "||":
+					op = two
+				}
+			}
+			if !strings.ContainsRune("=<>+-*/%(),", r) && len(op) == 1 {
+				return nil, fmt.Errorf("unexpected character '%c' at column %d", r, i+1)
+			}
+			toks = append(toks, token{tokOp, op, i + 1})
+			i += len(op)
+		}
+	}
+	return append(toks, token{kind: tokEOF, pos: len(rs) + 1}), nil
+}
+
+type exprParser struct {
+	toks []token
+	pos  int
+}
+
+func (p *exprParser) peek() token { return p.toks[p.pos] }
+
+func (p *exprParser) next() token {
+	t := p.toks[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+// accept consumes the next token when it is the operator or keyword s.
+func (p *exprParser) accept(s string) bool {
+	t := p.peek()
+	if (t.kind == tokOp && t.text == s) || t.keyword(s) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *exprParser) expect(s string) error {
+	if !p.accept(s) {
+		return fmt.Errorf("expected '%s' but found %s", s, p.peek())
+	}
+	return nil
+}
+
+func (p *exprParser) parseOr() (exprNode, error) {
+	l, err := p.parseAnd()
+	for err == nil && p.accept("OR") {
+		var r exprNode
+		if r, err = p.parseAnd(); err == nil {
+			l = &binaryExpr{op: "OR", l: l, r: r}
+		}
+	}
+	return l, err
+}
+
+func (p *exprParser) parseAnd() (exprNode, error) {
+	l, err := p.parseNot()
+	for err == nil && p.accept("AND") {
+		var r exprNode
+		if r, err = p.parseNot(); err == nil {
+			l = &binaryExpr{op: "AND", l: l, r: r}
+		}
+	}
+	return l, err
+}
+
+func (p *exprParser) parseNot() (exprNode, error) {
+	if p.accept("NOT") {
+		x, err := p.parseNot()
+		return &notExpr{x: x}, err
+	}
+	return p.parseComparison()
+}
+
+func (p *exprParser) parseComparison() (exprNode, error) {
+	l, err := p.parseAdditive()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind == tokOp {
+		switch t.text {
+		case "=", "==", "!=", "<>", "<", "<=", ">", ">=":
+			p.next()
+			r, err := p.parseAdditive()
+			return &binaryExpr{op: t.text, l: l, r: r}, err
+		}
+	}
+	if p.accept("IS") {
+		not := p.accept("NOT")
+		if err := p.expect("NULL"); err != nil {
+			return nil, err
+		}
+		return &isNullExpr{x: l, not: not}, nil
+	}
+	not := p.accept("NOT")
+	switch {
+	case p.accept("IN"):
+		if err := p.expect("("); err != nil {
+			return nil, err
+		}
+		list, err := p.parseList()
+		return &inExpr{x: l, list: list, not: not}, err
+	case p.accept("LIKE"):
+		t := p.next()
+		if t.kind != tokString {
+			return nil, fmt.Errorf("LIKE needs a string pattern but found %s", t)
+		}
+		return &likeExpr{x: l, re: likePattern(t.text), not: not}, nil
+	case not:
+		return nil, fmt.Errorf("expected IN or LIKE after NOT but found %s", p.peek())
+	}
+	return l, nil
+}
+
+func (p *exprParser) parseAdditive() (exprNode, error) {
+	l, err := p.parseMultiplicative()
+	for err == nil {
+		t := p.peek()
+		if t.kind != tokOp || (t.text != "+" && t.text != "-" && t.text != "||") {
+			break
+		}
+		p.next()
+		var r exprNode
+		if r, err = p.parseMultiplicative(); err == nil {
+			l = &binaryExpr{op: t.text, l: l, r: r}
+		}
+	}
+	return l, err
+}
+
+func (p *exprParser) parseMultiplicative() (exprNode, error) {
+	l, err := p.parseUnary()
+	for err == nil {
+		t := p.peek()
+		if t.kind != tokOp || (t.text != "*" && t.text != "/" && t.text != "%") {
+			break
+		}
+		p.next()
+		var r exprNode
+		if r, err = p.parseUnary(); err == nil {
+			l = &binaryExpr{op: t.text, l: l, r: r}
+		}
+	}
+	return l, err
+}
+
+func (p *exprParser) parseUnary() (exprNode, error) {
+	if p.accept("-") {
+		x, err := p.parseUnary()
+		return &binaryExpr{op: "-", l: &literalExpr{v: int64(0)}, r: x}, err
+	}
+	return p.parsePrimary()
+}
+
+func (p *exprParser) parsePrimary() (exprNode, error) {
+	t := p.next()
+	switch {
+	case t.kind == tokNumber:
+		if n, err := strconv.ParseInt(t.text, 10, 64); err == nil {
+			return &literalExpr{v: n}, nil
+		}
+		f, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %s", t)
+		}
+		return &literalExpr{v: f}, nil
+	case t.kind == tokString:
+		return &literalExpr{v: t.text}, nil
+	case t.kind == tokOp && t.text == "(":
+		n, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		return n, p.expect(")")
+	case t.keyword("NULL"):
+		return &literalExpr{v: nil}, nil
+	case t.keyword("TRUE"), t.keyword("FALSE"):
+		return &literalExpr{v: strings.EqualFold(t.text, "TRUE")}, nil
+	case t.kind == tokIdent:
+		if p.accept("(") {
+			fn, ok := exprFuncs[strings.ToLower(t.text)]
+			if !ok {
+				return nil, fmt.Errorf("unknown function %s at column %d", t.text, t.pos)
+			}
+			args, err := p.parseList()
+			return &callExpr{name: strings.ToLower(t.text), fn: fn, args: args}, err
+		}
+		name := t.text
+		if strings.ContainsAny(name[:1], `["`) {
+			name = name[1 : len(name)-1]
+		}
+		return &identExpr{name: name}, nil
+	}
+	return nil, fmt.Errorf("unexpected %s", t)
+}
+
+// parseList parses comma separated expressions up to the closing parenthesis.
+func (p *exprParser) parseList() ([]exprNode, error) {
+	var list []exprNode
+	if p.accept(")") {
+		return list, nil
+	}
+	for {
+		n, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, n)
+		if p.accept(")") {
+			return list, nil
+		}
+		if err := p.expect(","); err != nil {
+			return nil, err
+		}
+	}
+}
+
+type literalExpr struct{ v any }
+
+func (e *literalExpr) bind(*exprBinder) error  { return nil }
+func (e *literalExpr) eval([]any) (any, error) { return e.v, nil }
+
+func bindAll(b *exprBinder, ns ...exprNode) error {
+	for _, n := range ns {
+		if err := n.bind(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// exprColumn returns the output column for an expression, typed as exprType
+// infers it.
+func exprColumn(name string, n exprNode, cols []column) column {
+	c := exprType(n, cols)
+	c.Name, c.Nullable = name, true
+	return c
+}
+
+// exprType infers the type of the values an expression gives. A bare column
+// or variable reference keeps its type and a literal takes the type of its
+// value. Conditions are BIT, arithmetic on integers is BIGINT and other
+// arithmetic FLOAT, length is BIGINT and coalesce keeps the type its
+// arguments share. Anything else is text.
+func exprType(n exprNode, cols []column) column {
+	text := column{DBType: "NVARCHAR"}
+	switch n := n.(type) {
+	case *identExpr:
+		if n.idx >= 0 {
+			return cols[n.idx]
+		}
+		return column{DBType: n.v.dbType}
+	case *literalExpr:
+		switch n.v.(type) {
+		case int64:
+			return column{DBType: "BIGINT"}
+		case float64:
+			return column{DBType: "FLOAT"}
+		case bool:
+			return column{DBType: "BIT"}
+		}
+	case *notExpr, *isNullExpr, *inExpr, *likeExpr:
+		return column{DBType: "BIT"}
+	case *binaryExpr:
+		switch n.op {
+		case "AND", "OR", "=", "==", "!=", "<>", "<", "<=", ">", ">=":
+			return column{DBType: "BIT"}
+		case "||":
+			return text
+		}
+		// arithmetic keeps integers only when both sides are and it is not a division
+		if n.op != "/" && exprType(n.l, cols).kind() == kindInt && exprType(n.r, cols).kind() == kindInt {
+			return column{DBType: "BIGINT"}
+		}
+		return column{DBType: "FLOAT"}
+	case *callExpr:
+		switch n.name {
+		case "length":
+			return column{DBType: "BIGINT"}
+		case "coalesce":
+			var c *column
+			for _, arg := range n.args {
+				if l, ok := arg.(*literalExpr); ok && l.v == nil {
+					continue
+				}
+				t := exprType(arg, cols)
+				if c == nil {
+					c = &t
+				} else if c.kind() != t.kind() {
+					return text
+				}
+			}
+			if c != nil {
+				return *c
+			}
+		}
+	}
+	return text
+}
+
+// identExpr is a column reference or, when no column has the name, a run variable.
+type identExpr struct {
+	name string
+	idx  int
+	v    exprVar
+}
+
+func (e *identExpr) bind(b *exprBinder) error {
+	if i, ok := b.columns[strings.ToLower(e.name)]; ok {
+		e.idx = i
+		b.used[i] = true
+		return nil
+	}
+	if v, ok := b.vars[strings.ToLower(e.name)]; ok {
+		e.idx, e.v = -1, v
+		return nil
+	}
+	return fmt.Errorf("unknown column %s", e.name)
+}
+
+func (e *identExpr) eval(row []any) (any, error) { return e.value(row), nil }
+
+func (e *identExpr) value(row []any) any {
+	if e.idx < 0 {
+		return e.v.value
+	}
+	return row[e.idx]
+}
+
+type notExpr struct{ x exprNode }
+
+func (e *notExpr) bind(b *exprBinder) error { return e.x.bind(b) }
+
+func (e *notExpr) eval(row []any) (any, error) {
+	v, err := e.x.eval(row)
+	if err != nil {
+		return nil, err
+	}
+	t, err := truth(v)
+	return !t, err
+}
+
+type isNullExpr struct {
+	x   exprNode
+	not bool
+}
+
+func (e *isNullExpr) bind(b *exprBinder) error { return e.x.bind(b) }
+
+func (e *isNullExpr) eval(row []any) (any, error) {
+	v, err := e.x.eval(row)
+	return (v == nil) != e.not, err
+}
+
+type inExpr struct {
+	x    exprNode
+	list []exprNode
+	not  bool
+}
+
+func (e *inExpr) bind(b *exprBinder) error {
+	return bindAll(b, append([]exprNode{e.x}, e.list...)...)
+}
+
+func (e *inExpr) eval(row []any) (any, error) {
+	v, err := e.x.eval(row)
+	if err != nil {
+		return nil, err
+	}
+	for _, n := range e.list {
+		w, err := n.eval(row)
+		if err != nil {
+			return nil, err
+		}
+		if equalValues(v, w) {
+			return !e.not, nil
+		}
+	}
+	return e.not, nil
+}
+
+type likeExpr struct {
+	x   exprNode
+	re  *regexp.Regexp
+	not bool
+}
+
+func (e *likeExpr) bind(b *exprBinder) error { return e.x.bind(b) }
+
+func (e *likeExpr) eval(row []any) (any, error) {
+	v, err := e.x.eval(row)
+	if err != nil || v == nil {
+		return false, err
+	}
+	return e.re.MatchString(formatValue(v)) != e.not, nil
+}
+
+// likePattern converts a LIKE pattern (% and _ wildcards) to a regular expression.
+func likePattern(p string) *regexp.Regexp {
+	var b strings.Builder
+	b.WriteString("^(?s)")
+	for _, r := range p {
+		switch r {
+		case '%':
+			b.WriteString(".*")
+		case '_':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	b.WriteString("$")
+	return regexp.MustCompile(b.String())
+}
+
+type binaryExpr struct {
+	op   string
+	l, r exprNode
+}
+
+func (e *binaryExpr) bind(b *exprBinder) error { return bindAll(b, e.l, e.r) }
+
+func (e *binaryExpr) eval(row []any) (any, error) {
+	l, err := e.l.eval(row)
+	if err != nil {
+		return nil, err
+	}
+	// AND and OR short-circuit
+	switch e.op {
+	case "AND", "OR":
+		lt, err := truth(l)
+		if err != nil || lt == (e.op == "OR") {
+			return lt, err
+		}
+		r, err := e.r.eval(row)
+		if err != nil {
+			return nil, err
+		}
+		return truth(r)
+	}
+	r, err := e.r.eval(row)
+	if err != nil {
+		return nil, err
+	}
+	switch e.op {
+	case "=", "==":
+		return equalValues(l, r), nil
+	case "!=", "<>":
+		return !equalValues(l, r), nil
+	case "<", "<=", ">", ">=":
+		if l == nil || r == nil {
+			return false, nil
+		}
+		c := compareValues(l, r)
+		switch e.op {
+		case "<":
+			return c < 0, nil
+		case "<=":
+			return c <= 0, nil
+		case ">":
+			return c > 0, nil
+		}
+		return c >= 0, nil
+	case "||":
+		if l == nil || r == nil {
+			return nil, nil
+		}
+		return formatValue(l) + formatValue(r), nil
+	}
+	return arithmetic(e.op, l, r)
+}
+
+type callExpr struct {
+	name string
+	fn   func(args []any) (any, error)
+	args []exprNode
+}
+
+func (e *callExpr) bind(b *exprBinder) error { return bindAll(b, e.args...) }
+
+func (e *callExpr) eval(row []any) (any, error) {
+	args := make([]any, len(e.args))
+	for i, n := range e.args {
+		v, err := n.eval(row)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = v
+	}
+	v, err := e.fn(args)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", e.name, err)
+	}
+	return v, nil
+}
+
+// exprFuncs are the functions available to expressions. NULL arguments give
+// NULL except for coalesce.
+var exprFuncs = map[string]func(args []any) (any, error){
+	"lower": stringFunc(strings.ToLower),
+	"upper": stringFunc(strings.ToUpper),
+	"trim":  stringFunc(strings.TrimSpace),
+	"length": func(args []any) (any, error) {
+		if len(args) != 1 {
+			return nil, fmt.Errorf("takes 1 argument")
+		}
+		if args[0] == nil {
+			return nil, nil
+		}
+		return int64(len([]rune(formatValue(args[0])))), nil
+	},
+	"coalesce": func(args []any) (any, error) {
+		for _, v := range args {
+			if v != nil {
+				return v, nil
+			}
+		}
+		return nil, nil
+	},
+}
+
+func stringFunc(f func(string) string) func([]any) (any, error) {
+	return func(args []any) (any, error) {
+		if len(args) != 1 {
+			return nil, fmt.Errorf("takes 1 argument")
+		}
+		if args[0] == nil {
+			return nil, nil
+		}
+		return f(formatValue(args[0])), nil
+	}
+}
+
+// truth converts a value to a condition result; NULL is false.
+func truth(v any) (bool, error) {
+	if v == nil {
+		return false, nil
+	}
+	b, err := asBool(v)
+	if err != nil {
+		return false, fmt.Errorf("%s is not a boolean", formatValue(v))
+	}
+	return b, nil
+}
+
+// isNumber reports whether v holds a Go numeric type.
+func isNumber(v any) bool {
+	switch reflect.ValueOf(v).Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+// toNumber converts v to an int64 when it is integral, otherwise a float64.
+func toNumber(v any) (any, error) {
+	if n, err := asInt64(v); err == nil {
+		return n, nil
+	}
+	f, err := asFloat64(v)
+	if err != nil {
+		return nil, fmt.Errorf("%s is not a number", formatValue(v))
+	}
+	return f, nil
+}
+
+// equalValues reports whether two values are equal; NULL equals only NULL.
+func equalValues(a, b any) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return compareValues(a, b) == 0
+}
+
+// compareValues orders two non-NULL values. Numbers compare numerically and
+// times chronologically when the other side converts; everything else
+// compares as text.
+func compareValues(a, b any) int {
+	if isNumber(a) || isNumber(b) {
+		x, errA := toNumber(a)
+		y, errB := toNumber(b)
+		if errA == nil && errB == nil {
+			xi, okA := x.(int64)
+			yi, okB := y.(int64)
+			if okA && okB {
+				return cmpOrdered(xi, yi)
+			}
+			xf, _ := asFloat64(x)
+			yf, _ := asFloat64(y)
+			return cmpOrdered(xf, yf)
+		}
+	}
+	_, ta := a.(time.Time)
+	_, tb := b.(time.Time)
+	if ta || tb {
+		x, errA := asTime(a)
+		y, errB := asTime(b)
+		if errA == nil && errB == nil {
+			return x.Compare(y)
+		}
+	}
+	if _, ok := a.(bool); ok {
+		if y, err := asBool(b); err == nil {
+			x := a.(bool)
+			if x == y {
+				return 0
+			} else if x {
+				return 1
+			}
+			return -1
+		}
+	}
+	return strings.Compare(formatValue(a), formatValue(b))
+}
+
+func cmpOrdered[T int64 | float64](a, b T) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// arithmetic applies + - * / % to two numbers; integers stay integers except
+// for division.
+func arithmetic(op string, l, r any) (any, error) {
+	if l == nil || r == nil {
+		return nil, nil
+	}
+	x, err := toNumber(l)
+	if err != nil {
+		return nil, err
+	}
+	y, err := toNumber(r)
+	if err != nil {
+		return nil, err
+	}
+	xi, okA := x.(int64)
+	yi, okB := y.(int64)
+	if okA && okB && op != "/" {
+		switch op {
+		case "+":
+			return xi + yi, nil
+		case "-":
+			return xi - yi, nil
+		case "*":
+			return xi * yi, nil
+		case "%":
+			if yi == 0 {
+				return nil, fmt.Errorf("division by zero")
+			}
+			return xi % yi, nil
+		}
+	}
+	xf, _ := asFloat64(x)
+	yf, _ := asFloat64(y)
+	switch op {
+	case "+":
+		return xf + yf, nil
+	case "-":
+		return xf - yf, nil
+	case "*":
+		return xf * yf, nil
+	case "/":
+		if yf == 0 {
+			return nil, fmt.Errorf("division by zero")
+		}
+		return xf / yf, nil
+	}
+	return nil, fmt.Errorf("%% needs integers")
+}
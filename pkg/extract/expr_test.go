@@ -0,0 +1,166 @@
+package extract
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+var (
+	exprTestColumns = []column{
+		{Name: "id", DBType: "INT"},
+		{Name: "name", DBType: "NVARCHAR"},
+		{Name: "amount", DBType: "DECIMAL"},
+		{Name: "created", DBType: "DATETIME2"},
+		{Name: "active", DBType: "BIT"},
+		{Name: "note", DBType: "NVARCHAR"},
+	}
+	exprTestRow  = []any{int64(7), "Alice", []byte("12.50"), time.Date(2025, 1, 9, 14, 30, 0, 0, time.UTC), true, nil}
+	exprTestVars = map[string]exprVar{"run_date": {value: time.Date(2025, 1, 10, 0, 0, 0, 0, time.UTC), dbType: "DATE"}}
+)
+
+func TestEvalExpr(t *testing.T) {
+	for _, tc := range []struct {
+		src  string
+		want any
+	}{
+		// precedence
+		{"1 + 2 * 3", int64(7)},
+		{"(1 + 2) * 3", int64(9)},
+		{"10 - 4 - 3", int64(3)},
+		{"-id + 10", int64(3)},
+		{"- -id", int64(7)},
+		{"2 * 3 % 4", int64(2)},
+		{"'a' || 1 * 2", "a2"},
+		{"NOT active OR id = 7", true},
+		{"NOT (active OR id = 7)", false},
+		{"id = 7 OR id = 8 AND FALSE", true},
+		{"(id = 7 OR id = 8) AND FALSE", false},
+		{"NOT id = 8", true},
+		{"id + 1 > 7 AND name <> 'Bob'", true},
+
+		// numbers
+		{"7 / 2", 3.5},
+		{"8 / 2", 4.0},
+		{"7 % 4", int64(3)},
+		{"1 + 0.5", 1.5},
+		{"1.0 = 1", true},
+		{".5 < 1", true},
+
+		// coercion
+		{"id = '7'", true},
+		{"'1' = 1.0", true},
+		{"amount > 12", true},
+		{"amount = 12.5", true},
+		{"amount + 1", 13.5},
+		{"2 > '10'", false},
+		{"'2' > '10'", true},
+		{"created > '2024-12-31'", true},
+		{"created = '2025-01-09 14:30:00'", true},
+		{"created < run_date", true},
+		{"active = 1", true},
+		{"active = 'true'", true},
+		{"active", true},
+
+		// NULL
+		{"note IS NULL", true},
+		{"note IS NOT NULL", false},
+		{"note = NULL", true},
+		{"note <> NULL", false},
+		{"note = 'x'", false},
+		{"note > 1", false},
+		{"note < 1", false},
+		{"note + 1", nil},
+		{"note || 'x'", nil},
+		{"note LIKE '%'", false},
+		{"note NOT LIKE 'a%'", false},
+		{"note IN (1, NULL)", true},
+		{"upper(note)", nil},
+		{"coalesce(note, name)", "Alice"},
+		{"coalesce(note, NULL)", nil},
+
+		// strings and identifiers
+		{"'it''s'", "it's"},
+		{"[id] + \"id\"", int64(14)},
+		{"ID", int64(7)},
+		{"name LIKE 'A_i%'", true},
+		{"name LIKE 'a%'", false},
+		{"name NOT LIKE '%z'", true},
+		{"name IN ('Bob', 'Alice')", true},
+		{"id NOT IN (1, 2)", true},
+		{"lower(name) || '!'", "alice!"},
+		{"length(trim('  ab '))", int64(2)},
+	} {
+		n, err := compileExpr(tc.src, exprTestColumns, exprTestVars)
+		if err != nil {
+			t.Errorf("%s: %v", tc.src, err)
+			continue
+		}
+		got, err := n.eval(exprTestRow)
+		if err != nil {
+			t.Errorf("%s: %v", tc.src, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s = %#v, want %#v", tc.src, got, tc.want)
+		}
+	}
+}
+
+func TestParseExprErrors(t *testing.T) {
+	for _, tc := range []struct {
+		src  string
+		want string
+	}{
+		{"1 +", "1 +: unexpected end of expression"},
+		{"id = = 3", "unexpected '=' at column 6"},
+		{"1 2", "unexpected '2' at column 3"},
+		{"(1 + 2", "expected ')' but found end of expression"},
+		{"(1 + 2]", "unexpected character ']' at column 7"},
+		{"'open", "unterminated string at column 1"},
+		{"name = 'it''s", "unterminated string at column 8"},
+		{"[id", "unterminated identifier at column 1"},
+		{"id # 1", "unexpected character '#' at column 4"},
+		{"1.2.3", "invalid number '1.2.3' at column 1"},
+		{"name LIKE 5", "LIKE needs a string pattern but found '5' at column 11"},
+		{"id NOT 3", "expected IN or LIKE after NOT but found '3' at column 8"},
+		{"id IS 3", "expected 'NULL' but found '3' at column 7"},
+		{"id IN 1", "expected '(' but found '1' at column 7"},
+		{"id IN (1 2)", "expected ',' but found '2' at column 10"},
+		{"amount > foo(1)", "unknown function foo at column 10"},
+		// columns are counted in characters, not bytes
+		{"'né' = = 1", "unexpected '=' at column 8"},
+	} {
+		_, err := parseExpr(tc.src)
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: error %v, want %q", tc.src, err, tc.want)
+		}
+	}
+}
+
+func TestEvalExprErrors(t *testing.T) {
+	if _, err := compileExpr("missing = 1", exprTestColumns, exprTestVars); err == nil || !strings.Contains(err.Error(), "unknown column missing") {
+		t.Errorf("bound an unknown column: %v", err)
+	}
+	for _, tc := range []struct {
+		src  string
+		want string
+	}{
+		{"id / 0", "division by zero"},
+		{"id % 0", "division by zero"},
+		{"7.5 % 2", "% needs integers"},
+		{"name + 1", "Alice is not a number"},
+		{"name AND TRUE", "Alice is not a boolean"},
+		{"upper(name, name)", "upper: takes 1 argument"},
+	} {
+		n, err := compileExpr(tc.src, exprTestColumns, exprTestVars)
+		if err != nil {
+			t.Errorf("%s: %v", tc.src, err)
+			continue
+		}
+		if _, err := n.eval(exprTestRow); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: error %v, want %q", tc.src, err, tc.want)
+		}
+	}
+}
@@ -0,0 +1,1248 @@
+package extract
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	_ "github.com/denisenkom/go-mssqldb"
+	"golang.org/x/text/collate"
+	"golang.org/x/time/rate"
+)
+
+// defaultConcurrency is how many jobs run at once unless concurrency is set.
+const defaultConcurrency = 10
+
+type config struct {
+	Delimiter       string                      `yaml:"delimiter"`
+	NullString      string                      `yaml:"null_string"`
+	DatetimeFormat  string                      `yaml:"datetime_format"`
+	DateFormat      string                      `yaml:"date_format"`
+	DecimalPlaces   *int                        `yaml:"decimal_places"`
+	Format          string                      `yaml:"format"`
+	Compression     string                      `yaml:"compression"`
+	JSON            jsonOptions                 `yaml:"json"`
+	Arrow           arrowOptions                `yaml:"arrow"`
+	ORC             orcOptions                  `yaml:"orc"`
+	Parquet         parquetOptions              `yaml:"parquet"`
+	XLSX            xlsxOptions                 `yaml:"xlsx"`
+	IO              ioOptions                   `yaml:"io"`
+	Delta           deltaOptions                `yaml:"delta"`
+	Iceberg         icebergOptions              `yaml:"iceberg"`
+	BigQuery        bigqueryOptions             `yaml:"bigquery"`
+	Snowflake       snowflakeOptions            `yaml:"snowflake"`
+	Redshift        redshiftOptions             `yaml:"redshift"`
+	MSSQL           mssqlOptions                `yaml:"mssql"`
+	Postgres        postgresOptions             `yaml:"postgres"`
+	Loaders         map[string]loaderOptions    `yaml:"loaders"`
+	Ledger          string                      `yaml:"ledger"`
+	Report          string                      `yaml:"report"`
+	Audit           string                      `yaml:"audit"`
+	Encryption      stateEncryptionOptions      `yaml:"state_encryption"`
+	Lineage         lineageOptions              `yaml:"lineage"`
+	Abort           string                      `yaml:"abort"`
+	Timeout         configDuration              `yaml:"timeout"`
+	Retries         int                         `yaml:"retries"`
+	RetryBackoff    configDuration              `yaml:"retry_backoff"`
+	Concurrency     int                         `yaml:"concurrency"`
+	Pools           map[string]int              `yaml:"pools"`
+	JobPools        map[string]string           `yaml:"job_pools"`
+	Adaptive        adaptiveOptions             `yaml:"adaptive"`
+	Tenants         map[string]tenantOptions    `yaml:"tenants"`
+	JobTenants      map[string]string           `yaml:"job_tenants"`
+	OutputPolicy    outputPolicy                `yaml:"output_policy"`
+	Latest          map[string]string           `yaml:"latest"`
+	LatestMode      string                      `yaml:"latest_mode"`
+	Catalog         string                      `yaml:"catalog"`
+	Chargeback      string                      `yaml:"chargeback"`
+	Feeds           map[string]feedInfo         `yaml:"feeds"`
+	SchemaDrift     string                      `yaml:"schema_drift"`
+	Contracts       map[string]string           `yaml:"contracts"`
+	Classification  classificationOptions       `yaml:"classification"`
+	Filters         map[string]string           `yaml:"filters"`
+	Computed        map[string][]computedColumn `yaml:"computed"`
+	Literals        map[string]literalColumns   `yaml:"literals"`
+	RowKeys         map[string]rowKeyOptions    `yaml:"row_keys"`
+	Sort            map[string][]string         `yaml:"sort"`
+	SortMaxRows     int                         `yaml:"sort_max_rows"`
+	Locales         map[string]string           `yaml:"locales"`
+	ReportLocale    string                      `yaml:"report_locale"`
+	FiscalYearStart int                         `yaml:"fiscal_year_start"`
+	FiscalCalendar  fiscalCalendarOptions       `yaml:"fiscal_calendar"`
+	BusinessDays    businessDayOptions          `yaml:"business_days"`
+	RunOn           map[string]string           `yaml:"run_on"`
+	SLAs            map[string]slaOptions       `yaml:"sla"`
+	SLANotify       []string                    `yaml:"sla_notify"`
+	Maintenance     []maintenanceWindow         `yaml:"maintenance"`
+	Flows           map[string]flowOptions      `yaml:"flows"`
+	Triggers        triggerOptions              `yaml:"triggers"`
+	Queue           queueOptions                `yaml:"queue"`
+	Cluster         clusterOptions              `yaml:"cluster"`
+	Timezones       map[string]timezoneOptions  `yaml:"timezones"`
+	Changes         map[string]changeOptions    `yaml:"publish_changes"`
+	Partitions      map[string]partitionOptions `yaml:"partitions"`
+	Verify          map[string]verifyOptions    `yaml:"verify"`
+	ContentHash     map[string]string           `yaml:"content_hash"`
+	SFTP            map[string]sftpOptions      `yaml:"sftp"`
+	Driver          string                      `yaml:"driver"`
+	Server          string                      `yaml:"server"`
+	Database        string                      `yaml:"database"`
+	User            string                      `yaml:"user"`
+	PasswordEnv     string                      `yaml:"password_env"`
+	Auth            authOptions                 `yaml:"auth"`
+	Extracts        []extract                   `yaml:"extracts"`
+	// Queries and OutFiles are the older layout of the jobs, matched by index.
+	Queries  []string `yaml:"queries"`
+	OutFiles []string `yaml:"outfiles"`
+
+	// keys encrypt the ledger and snapshots.
+	keys *keyring
+	// source is the configuration file and digest its SHA-256, for the audit log.
+	source string
+	digest string
+	// schedule describes when -serve runs the jobs, for the catalog.
+	schedule string
+	// started is when the run began, exposed to expressions as run_time, and
+	// runID names the run in outfile templates.
+	started time.Time
+	runID   string
+	// paths are the files of the jobs with outfile templates in this run.
+	paths *outPaths
+	// vars are the values captured by the jobs of this run.
+	vars *runVars
+	// bound are the arguments the query templates of this run bound.
+	bound *boundArgs
+	// db is the database of an Exporter, used instead of connecting.
+	db *sql.DB
+	// writeLimiter is shared by the jobs of this run under io.write_rate.
+	writeLimiter *rate.Limiter
+	// dialect wraps queries for the source database.
+	dialect dialect
+	// limit, sample, dryRun and the result cache come from the command line.
+	limit    int
+	sample   float64
+	dryRun   bool
+	cacheTTL time.Duration
+	cacheDir string
+	// recordDir and replayDir hold the recorded results of -record and -replay.
+	recordDir string
+	replayDir string
+	// failFast stops the run at the first failed job.
+	failFast bool
+	// progressEvery and progressBar report the progress of running jobs.
+	progressEvery time.Duration
+	progressBar   bool
+	// flow is the flow the run was narrowed down to with -flow.
+	flow string
+	// queryFiles are the query_file paths the configuration read.
+	queryFiles []string
+	// calendar holds the periods of a fiscal_calendar query once loaded.
+	calendar tableCalendar
+}
+
+// concurrency returns how many jobs may run at once.
+func (c *config) concurrency() int {
+	if c.Concurrency > 0 {
+		return c.Concurrency
+	}
+	return defaultConcurrency
+}
+
+// partial reports whether the run leaves out or may reuse rows, so that it
+// must not update the ledger, change snapshots or published feeds.
+func (c *config) partial() bool {
+	return c.limit > 0 || c.sample > 0 || c.dryRun || c.cacheTTL > 0 || c.replayDir != ""
+}
+
+// runFlags are the flags of the run and backfill commands.
+type runFlags struct {
+	configFile      *string
+	limit           *int
+	sample          *float64
+	dryRun          *bool
+	cacheTTL        *time.Duration
+	cacheDir        *string
+	record          *string
+	replay          *string
+	profile         *string
+	pprofAddr       *string
+	admin           *string
+	send            *string
+	list            *bool
+	reads           *string
+	verifyAudit     *string
+	serve           *bool
+	daemon          *bool
+	watch           *bool
+	listen          *bool
+	healthAddr      *string
+	staleAfter      *time.Duration
+	every           *time.Duration
+	coordinatorAddr *string
+	workerOf        *string
+	workerName      *string
+	workerJobs      *int
+	tui             *bool
+	logFormat       *string
+	concurrency     *int
+	failFast        *bool
+	progress        *time.Duration
+	progressBar     *bool
+	flow            *string
+	params          paramFlag
+	fs              *flag.FlagSet
+}
+
+func addRunFlags(fs *flag.FlagSet) *runFlags {
+	return &runFlags{
+		configFile:      configFlag(fs),
+		limit:           fs.Int("limit", 0, "Export at most this many rows per query."),
+		sample:          fs.Float64("sample", 0, "Export a random sample of about this percent of rows per query."),
+		dryRun:          fs.Bool("dry-run", false, "Check each query's columns against the configuration without exporting any rows."),
+		cacheTTL:        fs.Duration("cache", 0, "Reuse each query's result for this long, e.g. 30m, while working on a job's output. Cached runs update no ledger, snapshot or feed."),
+		cacheDir:        fs.String("cache-dir", defaultCacheDir(), "Where -cache keeps query results."),
+		record:          fs.String("record", "", "Record each job's raw query result in this directory, for -replay."),
+		replay:          fs.String("replay", "", "Read each job's result from the recordings in this directory instead of the database."),
+		profile:         fs.String("profile", "", "Write profiles at the end of the run, e.g. cpu=cpu.out,heap=heap.out."),
+		pprofAddr:       fs.String("pprof", "", "Serve pprof endpoints on this address, e.g. localhost:6060."),
+		admin:           fs.String("admin", "", "Accept admin commands (pause, resume, drain, status) on this unix socket."),
+		send:            fs.String("send", "", "Send this command to the -admin socket of a running extraction and exit."),
+		list:            fs.Bool("list", false, "List each job with the tables its query reads and exit."),
+		reads:           fs.String("reads", "", "List the jobs whose query reads this table or view and exit."),
+		verifyAudit:     fs.String("verify-audit", "", "Check the hash chain of this audit log against AUDIT_KEY and exit."),
+		serve:           fs.Bool("serve", false, "Keep running and repeat the extraction every -every, reloading the configuration when it changes."),
+		daemon:          fs.Bool("daemon", false, "Keep running and run each job and flow with a schedule when its cron expression fires, reloading the configuration when it changes."),
+		watch:           fs.Bool("watch", false, "Keep running and start the flow or job named by each trigger file dropped into triggers.dir."),
+		listen:          fs.Bool("listen", false, "Keep running and start the flow or job named by each message on the configured queue."),
+		healthAddr:      fs.String("health", "", "With -serve or -daemon, serve /healthz and /readyz on this address, e.g. :8080."),
+		staleAfter:      fs.Duration("stale-after", 0, "With -health, report a job stale when it has not succeeded for this long (default twice -every)."),
+		every:           fs.Duration("every", time.Hour, "How long -serve waits between the start of one run and the next."),
+		coordinatorAddr: fs.String("coordinator", "", "Hand the jobs to workers connecting to this address, e.g. :7070, keeping one ledger and report."),
+		workerOf:        fs.String("worker", "", "Run jobs for the coordinator at this address, e.g. coord01:7070, until its run is over."),
+		workerName:      fs.String("worker-name", "", "The name this worker reports to the coordinator (default the host name)."),
+		workerJobs:      fs.Int("worker-jobs", defaultConcurrency, "How many jobs this worker runs at once."),
+		tui:             fs.Bool("tui", false, "Follow the run on a terminal dashboard, which can cancel jobs and show their log."),
+		logFormat:       fs.String("log-format", "text", "Write the log as text, or as json lines with the run, extract and row count fields for log aggregators."),
+		concurrency:     fs.Int("concurrency", 0, "Run at most this many jobs at once, in place of the concurrency setting."),
+		failFast:        fs.Bool("fail-fast", false, "Cancel the remaining jobs as soon as one fails, instead of letting the rest of the run finish."),
+		progress:        fs.Duration("progress", time.Minute, "Log the rows and bytes each running job has written this often, 0 for never."),
+		progressBar:     fs.Bool("progress-bar", false, "On a terminal, draw a line per running job with its rows, bytes and ETA under the log, in place of the progress log."),
+		flow:            fs.String("flow", "", "Run only the jobs of this flow, with its concurrency, notifications and -serve interval."),
+		params:          paramsFlag(fs),
+		fs:              fs,
+	}
+}
+
+// interval returns how long -serve waits between runs of c: -every, or the
+// every of the flow unless -every is given.
+func (f *runFlags) interval(c *config) time.Duration {
+	if o := c.Flows[c.flow]; c.flow != "" && o.Every > 0 {
+		set := false
+		f.fs.Visit(func(fl *flag.Flag) { set = set || fl.Name == "every" })
+		if !set {
+			return time.Duration(o.Every)
+		}
+	}
+	return *f.every
+}
+
+// configFlag defines the -config flag shared by the commands.
+func configFlag(fs *flag.FlagSet) *string {
+	return fs.String("config", "config.yaml", "A YAML file with list of configurations for SQL Extraction, a directory of them, or env: to read it from the environment.")
+}
+
+// execute runs the extraction. pick, if set, narrows down the jobs of every
+// configuration loaded.
+func (f *runFlags) execute(pick func(c *config) error) error {
+	if *f.send != "" {
+		reply, err := sendAdmin(*f.admin, *f.send)
+		if err != nil {
+			return err
+		}
+		fmt.Println(reply)
+		if strings.HasPrefix(reply, "error:") {
+			os.Exit(1)
+		}
+		return nil
+	}
+
+	if *f.verifyAudit != "" {
+		key, err := auditKey()
+		if err != nil {
+			return err
+		}
+		_, n, err := verifyAuditLog(*f.verifyAudit, key)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s: %d entries, chain intact\n", *f.verifyAudit, n)
+		return nil
+	}
+
+	if *f.replay != "" && (*f.record != "" || *f.dryRun || *f.cacheTTL > 0) {
+		return fmt.Errorf("-replay cannot be combined with -record, -dry-run or -cache\n")
+	}
+	if *f.tui && (*f.serve || *f.watch || *f.listen || *f.coordinatorAddr != "" || *f.workerOf != "") {
+		return fmt.Errorf("-tui cannot be combined with -serve, -watch, -listen, -coordinator or -worker\n")
+	}
+	if *f.tui && *f.progressBar {
+		return fmt.Errorf("-tui cannot be combined with -progress-bar\n")
+	}
+	if *f.tui && *f.logFormat == "json" {
+		return fmt.Errorf("-tui cannot be combined with -log-format json\n")
+	}
+	if err := setupLogFormat(*f.logFormat); err != nil {
+		return err
+	}
+	if *f.daemon && (*f.serve || *f.watch || *f.listen || *f.tui || *f.coordinatorAddr != "" || *f.workerOf != "") {
+		return fmt.Errorf("-daemon cannot be combined with -serve, -watch, -listen, -tui, -coordinator or -worker\n")
+	}
+	if *f.watch && (*f.serve || *f.listen || *f.coordinatorAddr != "" || *f.workerOf != "") {
+		return fmt.Errorf("-watch cannot be combined with -serve, -listen, -coordinator or -worker\n")
+	}
+	if *f.listen && (*f.serve || *f.coordinatorAddr != "" || *f.workerOf != "") {
+		return fmt.Errorf("-listen cannot be combined with -serve, -coordinator or -worker\n")
+	}
+	if *f.concurrency < 0 {
+		return fmt.Errorf("-concurrency must be at least 1, got %d\n", *f.concurrency)
+	}
+	if *f.sample < 0 || *f.sample > 100 {
+		return fmt.Errorf("Sample percent must be between 0 and 100, got %g\n", *f.sample)
+	}
+	// loadFlow loads the configuration narrowed down to flow, if set
+	loadFlow := func(flow string) (*config, error) {
+		c, err := readConfig(*f.configFile)
+		if err != nil {
+			return nil, err
+		}
+		if *f.concurrency > 0 {
+			c.Concurrency = *f.concurrency
+		}
+		if err := c.setParams(f.params); err != nil {
+			return nil, err
+		}
+		if err := c.prepare(); err != nil {
+			return nil, err
+		}
+		if flow != "" {
+			if err := c.selectFlow(flow); err != nil {
+				return nil, err
+			}
+			// -concurrency wins over the flow's
+			if *f.concurrency > 0 {
+				c.Concurrency = *f.concurrency
+			}
+		}
+		if pick != nil {
+			if err := pick(c); err != nil {
+				return nil, err
+			}
+		}
+		c.limit, c.sample, c.dryRun = *f.limit, *f.sample, *f.dryRun
+		c.cacheTTL, c.cacheDir = *f.cacheTTL, *f.cacheDir
+		c.recordDir, c.replayDir = *f.record, *f.replay
+		c.failFast = *f.failFast
+		c.progressEvery, c.progressBar = *f.progress, *f.progressBar
+		if *f.serve {
+			c.schedule = "every " + f.interval(c).String()
+		}
+		return c, nil
+	}
+	load := func() (*config, error) { return loadFlow(*f.flow) }
+
+	if *f.list || *f.reads != "" {
+		params, err := load()
+		if err != nil {
+			return err
+		}
+		listTables(os.Stdout, params, *f.reads)
+		return nil
+	}
+
+	ctx, stop := signalContext()
+	defer stop()
+
+	if *f.workerOf != "" {
+		params, err := load()
+		if err != nil {
+			return err
+		}
+		if *f.workerName == "" {
+			*f.workerName, _ = os.Hostname()
+		}
+		return runWorker(ctx, *f.workerOf, *f.workerName, max(*f.workerJobs, 1), params)
+	}
+
+	stopProfiles, err := startProfiles(*f.profile)
+	if err != nil {
+		return err
+	}
+	defer stopProfiles()
+	if *f.pprofAddr != "" {
+		servePprof(*f.pprofAddr)
+	}
+
+	params, err := load()
+	if err != nil {
+		return err
+	}
+
+	control := newController(params.OutFiles)
+	if *f.admin != "" {
+		stopAdmin, err := serveAdmin(*f.admin, control)
+		if err != nil {
+			return err
+		}
+		defer stopAdmin()
+	}
+
+	if *f.coordinatorAddr != "" {
+		if *f.serve {
+			return fmt.Errorf("-coordinator cannot be combined with -serve\n")
+		}
+		return runCoordinator(ctx, *f.coordinatorAddr, params, control)
+	}
+	if *f.serve {
+		s := newServer(*f.configFile, load, params, control)
+		s.loadFlow = loadFlow
+		if *f.healthAddr != "" {
+			s.health = newHealth(f.interval(params), *f.staleAfter)
+			serveHealth(*f.healthAddr, s.health, control)
+		}
+		s.serve(ctx, f.interval(params))
+		return nil
+	}
+	if *f.daemon {
+		if len(params.scheduledTargets()) == 0 {
+			return fmt.Errorf("-daemon needs a schedule on an extract or flow\n")
+		}
+		s := newServer(*f.configFile, load, params, control)
+		s.loadFlow = loadFlow
+		if *f.healthAddr != "" {
+			s.health = newHealth(24*time.Hour, *f.staleAfter)
+			serveHealth(*f.healthAddr, s.health, control)
+		}
+		s.daemon(ctx)
+		return nil
+	}
+	if *f.healthAddr != "" {
+		return fmt.Errorf("-health needs -serve or -daemon\n")
+	}
+	if *f.watch {
+		if params.Triggers.Dir == "" {
+			return fmt.Errorf("-watch needs triggers.dir in the configuration\n")
+		}
+		watchTriggers(ctx, params, control, loadFlow)
+		return nil
+	}
+	if *f.listen {
+		if !params.Queue.enabled() {
+			return fmt.Errorf("-listen needs a queue in the configuration\n")
+		}
+		return listenQueue(ctx, params, control, loadFlow)
+	}
+	if *f.tui {
+		return runDashboard(ctx, params, control)
+	}
+	return runChain(ctx, params, control, loadFlow, runExtraction)
+}
+
+// loadConfig reads and validates the configuration at path, a file, a
+// directory of files or env: for the environment.
+func loadConfig(path string) (*config, error) {
+	params, err := readConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := params.prepare(); err != nil {
+		return nil, err
+	}
+	return params, nil
+}
+
+// readConfig reads the configuration at path without validating it, so that
+// the command line can override settings first.
+func readConfig(path string) (*config, error) {
+	docs, err := readConfigDocs(path)
+	if err != nil {
+		return nil, err
+	}
+	return configFromDocs(docs, path)
+}
+
+// configFromDocs makes the configuration of the documents read from source.
+func configFromDocs(docs []configDoc, source string) (*config, error) {
+	params, digest, err := parseConfigDocs(docs)
+	if err != nil {
+		return nil, err
+	}
+	params.source, params.digest = source, digest
+	if err := params.expandParams(os.LookupEnv); err != nil {
+		return nil, err
+	}
+	driver, err := params.sourceDriver()
+	if err != nil {
+		return nil, err
+	}
+	if params.dialect, err = dialectFor(driver.name); err != nil {
+		return nil, err
+	}
+	return params, nil
+}
+
+// prepare validates the configuration and loads its keys.
+func (c *config) prepare() error {
+	if err := c.validate(); err != nil {
+		return err
+	}
+	var err error
+	c.keys, err = loadKeyring(c.Encryption)
+	return err
+}
+
+// validate checks the settings that would otherwise only fail once a job runs.
+func (c *config) validate() error {
+	if err := validateAbortPolicy(c.Abort); err != nil {
+		return err
+	}
+	switch c.SchemaDrift {
+	case "", "warn", "fail", "ignore":
+	default:
+		return fmt.Errorf("Unsupported schema_drift policy '%s'\n", c.SchemaDrift)
+	}
+	if c.Concurrency < 0 {
+		return fmt.Errorf("concurrency must be at least 1, got %d\n", c.Concurrency)
+	}
+	if c.Timeout < 0 {
+		return fmt.Errorf("timeout cannot be negative\n")
+	}
+	if c.PasswordEnv != "" && c.User == "" {
+		return fmt.Errorf("password_env is set without a user\n")
+	}
+	if c.User != "" && c.Auth.User != "" {
+		return fmt.Errorf("The user is set both at the top level and in auth\n")
+	}
+	if d, err := c.sourceDriver(); err == nil {
+		if err := c.login().validate("auth", d); err != nil {
+			return err
+		}
+	}
+	if c.Delimiter != "" && utf8.RuneCountInString(c.Delimiter) != 1 {
+		return fmt.Errorf("The delimiter must be one character\n")
+	}
+	names := map[string]bool{}
+	for _, e := range c.Extracts {
+		if names[e.Name] {
+			return fmt.Errorf("Extract name '%s' is used twice\n", e.Name)
+		}
+		names[e.Name] = true
+		if e.Delimiter != "" && utf8.RuneCountInString(e.Delimiter) != 1 {
+			return fmt.Errorf("The delimiter of extract %s must be one character\n", e.Name)
+		}
+	}
+	for _, outFile := range c.OutFiles {
+		if err := validateCompression(c.compression(outFile), outFile); err != nil {
+			return err
+		}
+		if strings.HasPrefix(outFile, "sftp://") {
+			if err := validateSFTP(outFile, c.SFTP); err != nil {
+				return err
+			}
+		}
+	}
+	if err := c.Classification.validate(); err != nil {
+		return err
+	}
+	for outFile, f := range c.Filters {
+		if _, err := parseExpr(f); err != nil {
+			return fmt.Errorf("Invalid filter for %s: %v\n", outFile, err)
+		}
+	}
+	if err := validateComputed(c.Computed); err != nil {
+		return err
+	}
+	for _, k := range c.RowKeys {
+		if err := k.validate(); err != nil {
+			return err
+		}
+	}
+	for _, tz := range c.Timezones {
+		if err := tz.validate(); err != nil {
+			return err
+		}
+	}
+	for outFile, o := range c.Changes {
+		if err := o.validate(outFile); err != nil {
+			return err
+		}
+	}
+	for outFile, o := range c.Partitions {
+		if err := o.validate(outFile); err != nil {
+			return err
+		}
+	}
+	for outFile, o := range c.Verify {
+		if err := o.validate(c, outFile); err != nil {
+			return err
+		}
+	}
+	if c.FiscalYearStart < 0 || c.FiscalYearStart > 12 {
+		return fmt.Errorf("fiscal_year_start must be a month from 1 to 12, got %d\n", c.FiscalYearStart)
+	}
+	if err := c.FiscalCalendar.validate(c); err != nil {
+		return err
+	}
+	if err := validateRetries(c); err != nil {
+		return err
+	}
+	if err := validateValueFormat(c); err != nil {
+		return err
+	}
+	if err := c.BusinessDays.validate(); err != nil {
+		return err
+	}
+	if err := c.validateParams(); err != nil {
+		return err
+	}
+	for outFile, o := range c.SLAs {
+		if err := o.validate(outFile); err != nil {
+			return err
+		}
+	}
+	if err := validateSLANotify(c.SLANotify); err != nil {
+		return err
+	}
+	for i, w := range c.Maintenance {
+		if err := w.validate(i); err != nil {
+			return err
+		}
+	}
+	for name, o := range c.Flows {
+		if err := o.validate(c, name); err != nil {
+			return err
+		}
+	}
+	if err := c.Triggers.validate(c); err != nil {
+		return err
+	}
+	if err := c.Queue.validate(); err != nil {
+		return err
+	}
+	if err := c.XLSX.validate(c); err != nil {
+		return err
+	}
+	if err := validateReady(c); err != nil {
+		return err
+	}
+	if err := validateCaptures(c); err != nil {
+		return err
+	}
+	if err := validateIncremental(c); err != nil {
+		return err
+	}
+	if err := validateJobTypes(c); err != nil {
+		return err
+	}
+	if err := validateIO(c); err != nil {
+		return err
+	}
+	if err := validatePipes(c); err != nil {
+		return err
+	}
+	if err := validateSchedules(c); err != nil {
+		return err
+	}
+	if err := validateLoaders(c); err != nil {
+		return err
+	}
+	for outFile, rule := range c.RunOn {
+		if _, err := parseRunRule(rule); err != nil {
+			return fmt.Errorf("Invalid run_on for %s: %v\n", outFile, err)
+		}
+	}
+	for i, query := range c.Queries {
+		if isTemplate(query) {
+			if _, err := parseQueryTemplate(c.OutFiles[i], query); err != nil {
+				return err
+			}
+		}
+		if isTemplate(c.OutFiles[i]) {
+			if _, err := parseOutFileTemplate(c.OutFiles[i]); err != nil {
+				return err
+			}
+		}
+	}
+	for outFile, order := range c.ContentHash {
+		if err := validateContentHash(outFile, order); err != nil {
+			return err
+		}
+	}
+	if c.SortMaxRows < 0 {
+		return fmt.Errorf("sort_max_rows must be positive\n")
+	}
+	for _, name := range c.Locales {
+		if _, err := parseLocale(name); err != nil {
+			return err
+		}
+	}
+	if c.ReportLocale != "" {
+		if _, err := parseLocale(c.ReportLocale); err != nil {
+			return err
+		}
+	}
+	pools, err := newWorkerPools(c.Pools, c.concurrency())
+	if err != nil {
+		return err
+	}
+	if err := pools.validate(c.JobPools); err != nil {
+		return err
+	}
+	if err := c.Adaptive.validate(c.concurrency()); err != nil {
+		return err
+	}
+	if err := validateLatest(c.Latest, c.LatestMode); err != nil {
+		return err
+	}
+	if err := c.Lineage.validate(); err != nil {
+		return err
+	}
+	if err := c.Postgres.validate(); err != nil {
+		return err
+	}
+	if err := c.Cluster.validate(); err != nil {
+		return err
+	}
+	if err := validateTenants(c); err != nil {
+		return err
+	}
+	return c.OutputPolicy.validate(c.policyTargets())
+}
+
+// runExtraction exports every query of params, starting each job through
+// control, until ctx is cancelled.
+func runExtraction(ctx context.Context, params *config, control *controller) error {
+	return runJobs(ctx, params, control, nil)
+}
+
+// jobFunc runs one job of a run.
+type jobFunc func(ctx context.Context, tenant, query, outFile string) error
+
+// runJobs runs every job of params. With dispatch set, it returns the
+// function handing each job to another process in place of exporting it here,
+// and the pools and tenant caps limit the jobs running at once on all of them.
+func runJobs(ctx context.Context, params *config, control *controller, dispatch func(l *ledger, r *runReport) jobFunc) error {
+	runLedger, err := loadLedger(params.Ledger, params.keys)
+	if err != nil {
+		return err
+	}
+	contracts, err := loadContracts(params.Contracts)
+	if err != nil {
+		return err
+	}
+	audit, err := openAuditLog(params.Audit)
+	if err != nil {
+		return err
+	}
+
+	// start timer
+	params.started = time.Now()
+	params.runID = newRunID(params.started)
+	params.vars = &runVars{}
+	params.writeLimiter = params.newWriteLimiter()
+	params.startBackground()
+	stop := startTimer(params)
+	defer stop()
+	report := newRunReport(params.Report)
+	control.track(report, params.jobAttrs)
+	stopProgress := params.startProgress(control)
+	defer stopProgress()
+
+	// cancelling ctx ends the run: the running jobs are cancelled, their
+	// partial output handled by the abort policy, and the rest skipped
+	stopAfter := context.AfterFunc(ctx, func() {
+		control.setState(stateDraining)
+		control.stopAll(context.Cause(ctx))
+	})
+	defer stopAfter()
+
+	// process requests
+	total := params.concurrency()
+	if dispatch != nil {
+		// the workers' slots bound how many jobs run at once
+		total = max(len(params.Queries), 1)
+	}
+	pools, err := newWorkerPools(params.Pools, total)
+	if err != nil {
+		return err
+	}
+	wg := sync.WaitGroup{}
+	// the first audit log error fails the run once its jobs are over
+	var auditMu sync.Mutex
+	var auditErr error
+
+	// each tenant has its own concurrency cap
+	caps := map[string]*limiter{}
+	for name, t := range params.Tenants {
+		if t.Concurrency > 0 {
+			caps[name] = newLimiter(t.Concurrency)
+		}
+	}
+
+	var run jobFunc
+	if dispatch != nil {
+		if err := params.loadFiscalCalendar(nil); err != nil {
+			return err
+		}
+		run = dispatch(runLedger, report)
+	} else {
+		// replayed runs need no database
+		dbs := map[string]*sql.DB{}
+		if params.replayDir == "" {
+			if dbs, err = openDatabases(params); err != nil {
+				return err
+			}
+			defer closeDatabases(params, dbs)
+		}
+		if err := params.loadFiscalCalendar(dbs[""]); err != nil {
+			return err
+		}
+		if params.Adaptive.enabled() && params.replayDir == "" {
+			stopAdapting := make(chan struct{})
+			defer close(stopAdapting)
+			go adaptConcurrency(dbs[""], params.Adaptive, pools.total, stopAdapting)
+		}
+		run = func(ctx context.Context, tenant, query, outFile string) error {
+			return exportData(ctx, dbs[tenant], params, runLedger, report, contracts[outFile], query, outFile)
+		}
+	}
+
+	queries, err := renderQueries(params)
+	if err != nil {
+		return err
+	}
+	if err := params.renderOutFiles(); err != nil {
+		return err
+	}
+	wg.Add(len(queries))
+
+	for i, query := range queries {
+		outFile := params.OutFiles[i]
+		go func(query, outFile string) {
+			defer wg.Done()
+			deps := params.dependencies(outFile)
+			if failed := control.awaitJobs(deps); failed != "" {
+				control.skip(outFile)
+				params.logJob(outFile, fmt.Sprintf("Skipped %s, %s did not succeed\n", outFile, failed), slog.String("state", jobSkipped))
+				return
+			}
+			// a drained run skips the job in control.start
+			params.awaitMaintenance(control, outFile)
+			tenant := params.JobTenants[outFile]
+			if l := caps[tenant]; l != nil {
+				l.acquire()
+				defer l.release()
+			}
+			release := pools.acquire(params.JobPools[outFile])
+			defer release()
+			// jobs are cancelled through control, which tells them from failed ones
+			ctx, ok := control.start(context.Background(), outFile)
+			if !ok {
+				params.logJob(outFile, fmt.Sprintf("Skipped %s\n", outFile), slog.String("state", jobSkipped))
+				return
+			}
+			if d := params.timeout(outFile); d > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeoutCause(ctx, d, &timeoutError{outFile, d})
+				defer cancel()
+			}
+			// the jobs it runs after may have captured values for its template
+			var jobErr error
+			if len(deps) > 0 {
+				query, jobErr = renderQuery(params, outFile, query)
+			}
+			lineage := params.newLineageRun(tenant, query, outFile)
+			lineage.emit("START", nil)
+			finishSLA := params.watchSLA(runLedger, tenant, outFile)
+			if jobErr == nil {
+				jobErr = params.withRetries(ctx, control, outFile, func() error { return run(ctx, tenant, query, outFile) })
+			}
+			finishSLA()
+			if jobErr != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				jobErr = context.Cause(ctx)
+			}
+			if control.finish(outFile, jobErr) != nil && params.failFast {
+				control.stopAll(errFailFast)
+			}
+			entry := params.auditEntry(tenant, query, outFile)
+			entry.Status, entry.Rows = control.jobState(outFile), report.rowsFor(outFile)
+			entry.TimedOut = control.timedOutJob(outFile)
+			if jobErr != nil {
+				entry.Error = strings.TrimSpace(jobErr.Error())
+			}
+			lineage.emit(lineageEventType(entry.Status), report.schemaFor(outFile))
+			if err := audit.record(entry); err != nil {
+				auditMu.Lock()
+				if auditErr == nil {
+					auditErr = err
+				}
+				auditMu.Unlock()
+			}
+		}(query, outFile)
+	}
+
+	wg.Wait()
+
+	notifyTenants(params, control, report)
+	notifyFlow(params, control, report)
+	writeWorkbooks(params, control, report)
+	// limited, sampled, dry and cached runs say nothing about the published feeds
+	if !params.partial() {
+		if err := updateCatalog(params, control, report); err != nil {
+			return err
+		}
+		if err := writeChargeback(params, control, report); err != nil {
+			return err
+		}
+	}
+	failed := control.summary(params.OutFiles, newReportPrinter(params.ReportLocale))
+	if err := report.finish(); err != nil {
+		return err
+	}
+	if auditErr != nil {
+		return auditErr
+	}
+	if ctx.Err() != nil {
+		return fmt.Errorf("The run was %v\n", context.Cause(ctx))
+	}
+	return failed
+}
+
+// openDatabases connects to the configured server, keyed "", and to each
+// tenant's, keyed by tenant name. The database an Exporter was given is used
+// in place of the configured server.
+func openDatabases(params *config) (map[string]*sql.DB, error) {
+	db := params.db
+	if db == nil {
+		var err error
+		if db, err = sqlConnect(params); err != nil {
+			return nil, err
+		}
+	}
+	dbs := map[string]*sql.DB{"": db}
+	for name, t := range params.Tenants {
+		tdb, err := t.connect(params)
+		if err != nil {
+			closeDatabases(params, dbs)
+			return nil, err
+		}
+		dbs[name] = tdb
+	}
+	return dbs, nil
+}
+
+// closeDatabases closes the connections of openDatabases, leaving the
+// database an Exporter was given open.
+func closeDatabases(params *config, dbs map[string]*sql.DB) {
+	for _, db := range dbs {
+		if db != params.db {
+			db.Close()
+		}
+	}
+}
+
+// startTimer returns a function to defer that will calculate total run time.
+func startTimer(c *config) func() {
+	t := time.Now()
+	run := slog.String("run_id", c.runID)
+	logAttrs(fmt.Sprintf("Begin extraction process for %s on %s.\n", c.Database, c.Server), []slog.Attr{run})
+	return func() {
+		d := time.Now().Sub(t)
+		logAttrs(fmt.Sprintln("Completed extraction process in", console.duration(d)), []slog.Attr{run, slog.Float64("duration_seconds", d.Seconds())})
+	}
+}
+
+// connectionString returns the go-mssqldb connection string for a database
+// without a user, for the integrated login or a token.
+func connectionString(server, database string) string {
+	return fmt.Sprintf("server=%s;database=%s;", server, database)
+}
+
+// sqlConnect uses the provided configuration to connect to SQL and return the *sql.DB
+func sqlConnect(c *config) (*sql.DB, error) {
+	d, err := c.sourceDriver()
+	if err != nil {
+		return nil, err
+	}
+	return d.open(c.Server, c.Database, c.login())
+}
+
+// exportData queries data from the SQL connection and saves it to the network.
+func exportData(ctx context.Context, db *sql.DB, c *config, l *ledger, r *runReport, k *contract, query, outFile string) (err error) {
+	if c.jobType(outFile) == jobTypeConcat {
+		if c.dryRun {
+			c.logJob(outFile, fmt.Sprintf("Dry run for %s: nothing to check for a concat job\n", outFile))
+			return nil
+		}
+		return concatFiles(c, r, outFile)
+	}
+	if c.dryRun {
+		return probeQuery(ctx, db, c, l, k, query, outFile)
+	}
+	if err := c.awaitReady(ctx, db, outFile); err != nil {
+		return err
+	}
+	if c.jobType(outFile) == jobTypeSchema {
+		return exportSchema(ctx, db, c, l, r, k, query, outFile)
+	}
+	if _, _, err := c.watermark(outFile); err != nil {
+		return err
+	}
+	started := time.Now()
+
+	// query the database
+	rows, err := openResult(ctx, db, c, query, outFile)
+	if err != nil {
+		return fmt.Errorf("Unable to execute the provided query '%s': %v\n", query, err)
+	}
+	defer rows.Close()
+
+	// write the column names to the output
+	resultCols, err := rows.columns()
+	if err != nil {
+		return fmt.Errorf("Columns could not be collected from the query result: %v\n", err)
+	}
+	stages, cols, err := prepareColumns(c, l, k, outFile, resultCols)
+	if err != nil {
+		return err
+	}
+	defer discardStages(stages)
+	r.schema(outFile, cols)
+	capture, err := c.newJobCapture(outFile, cols)
+	if err != nil {
+		return err
+	}
+	watermark, err := c.newWatermarkTracker(outFile, resultCols)
+	if err != nil {
+		return err
+	}
+
+	// the destination is only touched once the columns are accepted, so a
+	// job failing its contract, drift or classification checks leaves the
+	// last file as it was
+	w, err := openOutput(c, outFile)
+	if err != nil {
+		return err
+	}
+	counter, _ := w.(byteCounter)
+	// hashed as written, after any sort
+	var hashed *hashedOutput
+	if order := c.ContentHash[outFile]; order != "" {
+		hashed = newHashedOutput(w, order)
+		w = hashed
+	}
+	if spec := c.Sort[outFile]; len(spec) > 0 {
+		var collator *collate.Collator
+		if tag, ok := c.locale(outFile); ok {
+			collator = newCollator(tag)
+		}
+		w = newSortedOutput(w, spec, collator, c.sortMaxRows())
+	}
+	defer w.Abort()
+
+	if err := w.WriteHeader(cols); err != nil {
+		return fmt.Errorf("Column names could not be written to the export file: %v\n", err)
+	}
+
+	// collect row data and pass to output writer
+	row := make([]any, len(resultCols))
+	rowPtr := make([]any, len(resultCols))
+	for i := range row {
+		rowPtr[i] = &row[i]
+	}
+
+	var rowCount, skipped uint
+	// from the first row on, a destination that is not replayable has part
+	// of the result for good, and once the output is closed every destination
+	// has all of it
+	delivered := false
+	defer func() {
+		if err != nil && (delivered || rowCount > 0 && !c.replayable(outFile)) {
+			err = &deliveredError{err}
+		}
+	}()
+	for rows.Next() {
+		if err := rows.Scan(rowPtr...); err != nil {
+			return fmt.Errorf("Unable to properly parse the query result: %v\n", err)
+		}
+		mark := watermark.value(row)
+		out, keep, err := applyTransforms(stages, row)
+		if err != nil {
+			return fmt.Errorf("Unable to transform the query result: %v\n", err)
+		}
+		if !keep {
+			skipped++
+			continue
+		}
+		if err := w.WriteRow(out); err != nil {
+			return fmt.Errorf("Record could not be written to export file: %v\n", err)
+		}
+		capture.row(out)
+		watermark.advance(mark)
+		rowCount++
+		if rowCount%progressRows == 0 {
+			var written int64
+			if counter != nil {
+				written = counter.bytesWritten()
+			}
+			r.progress(outFile, rowCount, written)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("Reading the query result failed after %d row(s): %v\n", rowCount, err)
+	}
+	cpu, measured := time.Duration(0), false
+	if m, ok := rows.(queryCPUMeter); ok {
+		cpu, measured = m.queryCPU()
+	}
+	for _, t := range stages {
+		f, ok := t.(finisher)
+		if !ok {
+			continue
+		}
+		extra, err := f.Finish()
+		if err != nil {
+			return err
+		}
+		for _, out := range extra {
+			if err := w.WriteRow(out); err != nil {
+				return fmt.Errorf("Record could not be written to export file: %v\n", err)
+			}
+			rowCount++
+		}
+	}
+
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("Following error occurred while finalizing export file: %v\n", err)
+	}
+	delivered = true
+	// a partial run's file does not hold every source row
+	if o, ok := c.Verify[outFile]; ok && !c.partial() {
+		if err := verifyOutput(ctx, db, c, o, query, outFile, rowCount); err != nil {
+			discardFile(c.outputPath(outFile), c.Abort)
+			return err
+		}
+	}
+	if hashed != nil {
+		m := outputManifest{
+			OutFile: outFile, File: c.outputPath(outFile), Written: time.Now().UTC(), Rows: rowCount,
+			Schema: outputSchema(cols), ContentHash: hashed.contentHash(), HashOrder: hashed.order,
+		}
+		if err := writeManifest(c, m); err != nil {
+			return err
+		}
+		log.Printf("Content hash of %s is %s\n", outFile, m.ContentHash)
+	}
+
+	var written int64
+	if counter != nil {
+		written = counter.bytesWritten()
+	}
+	done := []slog.Attr{
+		slog.String("state", jobDone), slog.Uint64("rows", uint64(rowCount)), slog.Uint64("filtered", uint64(skipped)),
+		slog.Int64("bytes_written", written), slog.Float64("duration_seconds", time.Since(started).Seconds()),
+	}
+	if skipped > 0 {
+		c.logJob(outFile, fmt.Sprintf("Extraction completed for %s (%s row(s) affected, %s filtered out)\n", outFile, console.count(int64(rowCount)), console.count(int64(skipped))), done...)
+	} else {
+		c.logJob(outFile, fmt.Sprintf("Extraction completed for %s (%s row(s) affected)\n", outFile, console.count(int64(rowCount))), done...)
+	}
+	r.job(outFile, started, rowCount, written)
+	if measured {
+		r.jobCPU(outFile, cpu)
+	}
+
+	// limited, sampled and cached runs are not representative, so they leave no record
+	if !c.partial() {
+		if err := l.record(outFile, cols, rowCount); err != nil {
+			return err
+		}
+		for _, t := range stages {
+			if f, ok := t.(finisher); ok {
+				if err := f.Commit(); err != nil {
+					return err
+				}
+			}
+		}
+		if latest := c.Latest[outFile]; latest != "" {
+			if err := publishLatest(c.outputPath(outFile), latest, c.LatestMode); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := c.saveCaptures(ctx, db, outFile, capture); err != nil {
+		return err
+	}
+	// the watermark advances last, so that a job failing before it reads the
+	// same window again
+	if c.partial() {
+		return nil
+	}
+	return c.saveWatermark(outFile, watermark)
+}
+
+// prepareColumns checks the query result's columns against the job's contract
+// and the ledger, returning the job's transforms and the columns they output.
+// The caller discards the transforms; on failure they are discarded already.
+func prepareColumns(c *config, l *ledger, k *contract, outFile string, cols []column) ([]transform, []column, error) {
+	if k != nil {
+		if v := k.violations(cols); len(v) > 0 {
+			return nil, nil, fmt.Errorf("Result of %s does not match its contract: %s\n", outFile, strings.Join(v, "; "))
+		}
+	}
+	stages := jobTransforms(c, outFile)
+	cols, err := setupTransforms(stages, cols)
+	if err != nil {
+		discardStages(stages)
+		return nil, nil, err
+	}
+	if drift := l.schemaDrift(outFile, cols); len(drift) > 0 && c.SchemaDrift != "ignore" {
+		if c.SchemaDrift == "fail" {
+			discardStages(stages)
+			return nil, nil, fmt.Errorf("Schema of %s changed since the last run: %s\n", outFile, strings.Join(drift, "; "))
+		}
+		log.Printf("Warning: schema of %s changed since the last run: %s\n", outFile, strings.Join(drift, "; "))
+	}
+	return stages, cols, nil
+}
+
+// probeQuery runs query as a zero-row probe and checks its columns without
+// touching the output.
+func probeQuery(ctx context.Context, db *sql.DB, c *config, l *ledger, k *contract, query, outFile string) error {
+	rows, err := db.QueryContext(ctx, c.dialect.probe(query), c.jobArgs(outFile)...)
+	if err != nil {
+		return fmt.Errorf("Unable to execute the provided query '%s': %v\n", query, err)
+	}
+	defer rows.Close()
+
+	types, err := rows.ColumnTypes()
+	if err != nil {
+		return fmt.Errorf("Columns could not be collected from the query result: %v\n", err)
+	}
+	stages, cols, err := prepareColumns(c, l, k, outFile, newColumns(types))
+	if err != nil {
+		return err
+	}
+	discardStages(stages)
+	c.logJob(outFile, fmt.Sprintf("Dry run for %s: %s\n", outFile, strings.Join(columnNames(cols), ", ")), slog.Any("columns", columnNames(cols)))
+	return nil
+}
@@ -0,0 +1,235 @@
+package extract
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// exportFake runs exportData for a registered fake query into outFile and
+// returns the file's contents.
+func exportFake(t *testing.T, c *config, query, outFile string) (string, error) {
+	t.Helper()
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	db, err := sql.Open("fakedb", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	c.dialect, _ = dialectFor("sqlserver")
+	err = exportData(context.Background(), db, c, nil, newRunReport(""), nil, query, outFile)
+	data, _ := os.ReadFile(outFile)
+	return string(data), err
+}
+
+func TestExportDataCSV(t *testing.T) {
+	registerFake("numbers", &fakeQuery{sets: []*fakeResult{numbersResult(3)}})
+	got, err := exportFake(t, &config{}, "numbers", filepath.Join(t.TempDir(), "out.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "id,name\n1,name 1\n2,name 2\n3,\n"
+	if got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
+
+func TestExportDataNullString(t *testing.T) {
+	registerFake("nulls", &fakeQuery{sets: []*fakeResult{{
+		columns: []fakeColumn{{name: "note", dbType: "NVARCHAR", scanType: reflect.TypeOf(""), nullable: true}},
+		rows:    3,
+		value: func(row, _ int) driver.Value {
+			return []driver.Value{"x", "", nil}[row]
+		},
+	}}})
+	empty := ""
+	c := &config{
+		NullString: `\N`,
+		Extracts:   []extract{{Name: "compat", OutFile: "compat.csv", NullString: &empty}},
+	}
+	got, err := exportFake(t, c, "nulls", filepath.Join(t.TempDir(), "out.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "note\nx\n\n\\N\n"; got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+	if c.nullString("compat.csv") != "" || c.nullString("other.csv") != `\N` {
+		t.Errorf("the extract's null_string is not applied")
+	}
+}
+
+func TestExportDataJSONL(t *testing.T) {
+	registerFake("typed", &fakeQuery{sets: []*fakeResult{{
+		columns: []fakeColumn{
+			{name: "id", dbType: "BIGINT", scanType: reflect.TypeOf(int64(0))},
+			{name: "price", dbType: "DECIMAL", scanType: reflect.TypeOf([]byte{}), nullable: true, precision: 10, scale: 2},
+			{name: "active", dbType: "BIT", scanType: reflect.TypeOf(false)},
+			{name: "name", dbType: "NVARCHAR", scanType: reflect.TypeOf(""), nullable: true},
+		},
+		rows: 2,
+		value: func(row, col int) driver.Value {
+			return [][]driver.Value{
+				{int64(1), []byte("12.50"), true, "Ann \"A\""},
+				{int64(2), nil, false, nil},
+			}[row][col]
+		},
+	}}})
+	got, err := exportFake(t, &config{Format: "jsonl"}, "typed", filepath.Join(t.TempDir(), "out.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"id":1,"price":12.50,"active":true,"name":"Ann \"A\""}
+{"id":2,"price":null,"active":false,"name":null}
+`
+	if got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
+
+func TestExportDataQueryError(t *testing.T) {
+	registerFake("broken", &fakeQuery{err: errors.New("invalid object name")})
+	_, err := exportFake(t, &config{}, "broken", filepath.Join(t.TempDir(), "out.csv"))
+	if err == nil || !strings.Contains(err.Error(), "invalid object name") {
+		t.Fatalf("got error %v, want the query error", err)
+	}
+}
+
+func TestExportDataErrorMidStream(t *testing.T) {
+	res := numbersResult(10)
+	res.err, res.errAt = errors.New("connection reset"), 5
+	registerFake("reset", &fakeQuery{sets: []*fakeResult{res}})
+	_, err := exportFake(t, &config{}, "reset", filepath.Join(t.TempDir(), "out.csv"))
+	if err == nil || !strings.Contains(err.Error(), "connection reset") {
+		t.Fatalf("got error %v, want the mid-stream error", err)
+	}
+}
+
+func TestExportDataTransforms(t *testing.T) {
+	registerFake("transformed", &fakeQuery{sets: []*fakeResult{numbersResult(6)}})
+	outFile := filepath.Join(t.TempDir(), "out.csv")
+	c := &config{
+		Delimiter: ";",
+		Filters:   map[string]string{outFile: "name IS NOT NULL AND id > 1"},
+		Literals:  map[string]literalColumns{outFile: {{Name: "source", Value: "ERP1"}}},
+	}
+	got, err := exportFake(t, c, "transformed", outFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "id;name;source\n2;name 2;ERP1\n4;name 4;ERP1\n5;name 5;ERP1\n"
+	if got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
+
+func TestExportDataAbortPolicy(t *testing.T) {
+	res := numbersResult(10)
+	res.err, res.errAt = errors.New("connection reset"), 5
+	registerFake("aborted", &fakeQuery{sets: []*fakeResult{res}})
+
+	for _, tc := range []struct {
+		policy string
+		want   []string
+	}{
+		{"", nil},
+		{"delete", nil},
+		{"mark", []string{"out.csv" + incompleteSuffix}},
+		{"keep", []string{"out.csv" + partSuffix}},
+	} {
+		dir := t.TempDir()
+		if _, err := exportFake(t, &config{Abort: tc.policy}, "aborted", filepath.Join(dir, "out.csv")); err == nil {
+			t.Fatalf("abort %q: export succeeded, want the mid-stream error", tc.policy)
+		}
+		entries, _ := os.ReadDir(dir)
+		var got []string
+		for _, e := range entries {
+			got = append(got, e.Name())
+		}
+		if strings.Join(got, ",") != strings.Join(tc.want, ",") {
+			t.Errorf("abort %q: left %v, want %v", tc.policy, got, tc.want)
+		}
+	}
+}
+
+func TestExportDataRejectedColumnsKeepFile(t *testing.T) {
+	registerFake("rejected", &fakeQuery{sets: []*fakeResult{numbersResult(3)}})
+	outFile := filepath.Join(t.TempDir(), "out.csv")
+	if err := os.WriteFile(outFile, []byte("id,name\n1,yesterday\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	k := &contract{Columns: []contractColumn{{Name: "id"}}}
+	c := &config{Delimiter: ","}
+	c.dialect, _ = dialectFor("sqlserver")
+	db, _ := sql.Open("fakedb", "")
+	defer db.Close()
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	if err := exportData(context.Background(), db, c, nil, newRunReport(""), k, "rejected", outFile); err == nil {
+		t.Fatal("a result breaking its contract was exported")
+	}
+	if data, _ := os.ReadFile(outFile); string(data) != "id,name\n1,yesterday\n" {
+		t.Errorf("the last file became %q", data)
+	}
+}
+
+func TestExportDataFailureKeepsLastFile(t *testing.T) {
+	res := numbersResult(10)
+	res.err, res.errAt = errors.New("connection reset"), 5
+	registerFake("failed again", &fakeQuery{sets: []*fakeResult{res}})
+	outFile := filepath.Join(t.TempDir(), "out.csv")
+	if err := os.WriteFile(outFile, []byte("id,name\n1,yesterday\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := exportFake(t, &config{}, "failed again", outFile); err == nil {
+		t.Fatal("export succeeded, want the mid-stream error")
+	}
+	if data, _ := os.ReadFile(outFile); string(data) != "id,name\n1,yesterday\n" {
+		t.Errorf("the last file became %q", data)
+	}
+}
+
+func TestExportDataConfigDelimiter(t *testing.T) {
+	registerFake("numbers", &fakeQuery{sets: []*fakeResult{numbersResult(2)}})
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+	outFile := filepath.Join(dir, "out.csv")
+	os.WriteFile(path, []byte("server: db1\ndatabase: sales\nextracts:\n  - {name: numbers, query: numbers, outfile: "+outFile+"}\n"), 0o644)
+	c, err := loadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// no delimiter anywhere writes commas
+	got, err := exportFake(t, c, "numbers", outFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "id,name\n1,name 1\n2,name 2\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	for _, tc := range []struct {
+		yaml string
+		ok   bool
+	}{
+		{`delimiter: ""`, true},
+		{`delimiter: "é"`, true},
+		{`delimiter: ";;"`, false},
+		{"extracts:\n  - {name: numbers, query: numbers, outfile: out.csv, delimiter: ab}", false},
+	} {
+		os.WriteFile(path, []byte("server: db1\ndatabase: sales\n"+tc.yaml+"\n"), 0o644)
+		if _, err := loadConfig(path); (err == nil) != tc.ok {
+			t.Errorf("%s: got %v", tc.yaml, err)
+		}
+	}
+}
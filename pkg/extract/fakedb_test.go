@@ -0,0 +1,354 @@
+package extract
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeColumn describes one column of a fake result set.
+type fakeColumn struct {
+	name      string
+	dbType    string
+	scanType  reflect.Type
+	nullable  bool
+	precision int64
+	scale     int64
+}
+
+// fakeResult is a synthetic result set: rows rows whose values come from value.
+type fakeResult struct {
+	columns []fakeColumn
+	rows    int
+	value   func(row, col int) driver.Value
+	// err is returned by Next in place of row errAt, ending the result set.
+	err   error
+	errAt int
+	// delay is slept before every row.
+	delay time.Duration
+}
+
+// fakeQuery is what the fake driver does for one query text.
+type fakeQuery struct {
+	// sets are the result sets returned in order.
+	sets []*fakeResult
+	// err fails the query itself; with failures > 0 only that many calls fail.
+	err      error
+	failures int
+	// calls counts the executions of the query.
+	calls int
+}
+
+var (
+	fakeMu      sync.Mutex
+	fakeQueries = map[string]*fakeQuery{}
+	// fakeHandlers answer the queries that are not registered, in order.
+	fakeHandlers []func(query string) (*fakeResult, bool)
+)
+
+func init() {
+	sql.Register("fakedb", fakeDriver{})
+}
+
+// registerFake makes the fake driver answer query with q.
+func registerFake(query string, q *fakeQuery) {
+	fakeMu.Lock()
+	defer fakeMu.Unlock()
+	fakeQueries[query] = q
+}
+
+// registerFakeHandler makes the fake driver answer the unregistered queries
+// that h accepts, such as queries generated with varying bounds, until the
+// test ends.
+func registerFakeHandler(t *testing.T, h func(query string) (*fakeResult, bool)) {
+	fakeMu.Lock()
+	defer fakeMu.Unlock()
+	n := len(fakeHandlers)
+	fakeHandlers = append(fakeHandlers, h)
+	t.Cleanup(func() {
+		fakeMu.Lock()
+		defer fakeMu.Unlock()
+		fakeHandlers = fakeHandlers[:n]
+	})
+}
+
+// fakeCalls reports how often query has been executed.
+func fakeCalls(query string) int {
+	fakeMu.Lock()
+	defer fakeMu.Unlock()
+	if q, ok := fakeQueries[query]; ok {
+		return q.calls
+	}
+	return 0
+}
+
+// openFakeDB registers result under query and returns a database serving it.
+func openFakeDB(query string, result *fakeResult) (*sql.DB, error) {
+	registerFake(query, &fakeQuery{sets: []*fakeResult{result}})
+	return sql.Open("fakedb", "")
+}
+
+type fakeDriver struct{}
+
+func (fakeDriver) Open(string) (driver.Conn, error) { return fakeConn{}, nil }
+
+type fakeConn struct{}
+
+func (fakeConn) Prepare(query string) (driver.Stmt, error) { return fakeStmt{query: query}, nil }
+func (fakeConn) Close() error                              { return nil }
+func (fakeConn) Begin() (driver.Tx, error) {
+	return nil, fmt.Errorf("fakedb: transactions are not supported")
+}
+
+// QueryContext lets database/sql skip Prepare and honours a context that is
+// already done.
+func (fakeConn) QueryContext(ctx context.Context, query string, _ []driver.NamedValue) (driver.Rows, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return runFake(query)
+}
+
+type fakeStmt struct{ query string }
+
+func (s fakeStmt) Close() error  { return nil }
+func (s fakeStmt) NumInput() int { return -1 }
+
+func (s fakeStmt) Exec([]driver.Value) (driver.Result, error) {
+	return nil, fmt.Errorf("fakedb: exec is not supported")
+}
+
+func (s fakeStmt) Query([]driver.Value) (driver.Rows, error) {
+	return runFake(s.query)
+}
+
+// runFake executes a registered query.
+func runFake(query string) (driver.Rows, error) {
+	fakeMu.Lock()
+	defer fakeMu.Unlock()
+	q, ok := fakeQueries[query]
+	if !ok {
+		for _, h := range fakeHandlers {
+			if res, ok := h(query); ok {
+				return &fakeRows{sets: []*fakeResult{res}}, nil
+			}
+		}
+		return nil, fmt.Errorf("fakedb: no result registered for %q", query)
+	}
+	q.calls++
+	if q.err != nil && (q.failures == 0 || q.calls <= q.failures) {
+		return nil, q.err
+	}
+	if len(q.sets) == 0 {
+		return nil, fmt.Errorf("fakedb: %q has no result sets", query)
+	}
+	return &fakeRows{sets: q.sets}, nil
+}
+
+type fakeRows struct {
+	sets []*fakeResult
+	set  int
+	next int
+}
+
+func (r *fakeRows) result() *fakeResult { return r.sets[r.set] }
+
+func (r *fakeRows) Columns() []string {
+	names := make([]string, len(r.result().columns))
+	for i, c := range r.result().columns {
+		names[i] = c.name
+	}
+	return names
+}
+
+func (r *fakeRows) Close() error { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	res := r.result()
+	if res.err != nil && r.next == res.errAt {
+		return res.err
+	}
+	if r.next >= res.rows {
+		return io.EOF
+	}
+	time.Sleep(res.delay)
+	for i := range dest {
+		dest[i] = res.value(r.next, i)
+	}
+	r.next++
+	return nil
+}
+
+func (r *fakeRows) HasNextResultSet() bool { return r.set+1 < len(r.sets) }
+
+func (r *fakeRows) NextResultSet() error {
+	if !r.HasNextResultSet() {
+		return io.EOF
+	}
+	r.set++
+	r.next = 0
+	return nil
+}
+
+func (r *fakeRows) ColumnTypeDatabaseTypeName(i int) string { return r.result().columns[i].dbType }
+func (r *fakeRows) ColumnTypeScanType(i int) reflect.Type   { return r.result().columns[i].scanType }
+
+func (r *fakeRows) ColumnTypeNullable(i int) (bool, bool) {
+	return r.result().columns[i].nullable, true
+}
+
+func (r *fakeRows) ColumnTypePrecisionScale(i int) (int64, int64, bool) {
+	c := r.result().columns[i]
+	return c.precision, c.scale, c.precision > 0
+}
+
+// numbersResult returns n rows of (id BIGINT, name NVARCHAR).
+func numbersResult(n int) *fakeResult {
+	return &fakeResult{
+		columns: []fakeColumn{
+			{name: "id", dbType: "BIGINT", scanType: reflect.TypeOf(int64(0))},
+			{name: "name", dbType: "NVARCHAR", scanType: reflect.TypeOf(""), nullable: true},
+		},
+		rows: n,
+		value: func(row, col int) driver.Value {
+			if col == 0 {
+				return int64(row + 1)
+			}
+			if row%3 == 2 {
+				return nil
+			}
+			return fmt.Sprintf("name %d", row+1)
+		},
+	}
+}
+
+// typedResult returns a row with a value of every kind this tool maps to a
+// typed output column, and a row of NULLs.
+func typedResult() *fakeResult {
+	row := []driver.Value{
+		int64(42), 2.5, []byte("12.34"), true,
+		time.Date(2026, 10, 15, 0, 0, 0, 0, time.UTC),
+		time.Date(2026, 10, 15, 9, 30, 15, 123456000, time.UTC),
+		`Åsa "q"`, []byte{0, 1, 2, 255},
+	}
+	timeType := reflect.TypeOf(time.Time{})
+	return &fakeResult{
+		columns: []fakeColumn{
+			{name: "id", dbType: "BIGINT", scanType: reflect.TypeOf(int64(0)), nullable: true},
+			{name: "price", dbType: "FLOAT", scanType: reflect.TypeOf(0.0), nullable: true},
+			{name: "amount", dbType: "DECIMAL", scanType: reflect.TypeOf([]byte(nil)), nullable: true, precision: 10, scale: 2},
+			{name: "active", dbType: "BIT", scanType: reflect.TypeOf(true), nullable: true},
+			{name: "day", dbType: "DATE", scanType: timeType, nullable: true},
+			{name: "at", dbType: "DATETIME2", scanType: timeType, nullable: true},
+			{name: "name", dbType: "NVARCHAR", scanType: reflect.TypeOf(""), nullable: true},
+			{name: "data", dbType: "VARBINARY", scanType: reflect.TypeOf([]byte(nil)), nullable: true},
+		},
+		rows: 2,
+		value: func(r, col int) driver.Value {
+			if r == 1 {
+				return nil
+			}
+			return row[col]
+		},
+	}
+}
+
+func TestFakeDBMultipleResultSets(t *testing.T) {
+	registerFake("multi", &fakeQuery{sets: []*fakeResult{numbersResult(2), numbersResult(3)}})
+	db, _ := sql.Open("fakedb", "")
+	defer db.Close()
+
+	rows, err := db.Query("multi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var counts []int
+	for {
+		n := 0
+		for rows.Next() {
+			n++
+		}
+		counts = append(counts, n)
+		if !rows.NextResultSet() {
+			break
+		}
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(counts, []int{2, 3}) {
+		t.Fatalf("got result sets of %v rows, want [2 3]", counts)
+	}
+}
+
+func TestFakeDBErrorMidStream(t *testing.T) {
+	boom := errors.New("boom")
+	res := numbersResult(10)
+	res.err, res.errAt = boom, 4
+	registerFake("mid-stream", &fakeQuery{sets: []*fakeResult{res}})
+	db, _ := sql.Open("fakedb", "")
+	defer db.Close()
+
+	rows, err := db.Query("mid-stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	n := 0
+	for rows.Next() {
+		n++
+	}
+	if n != 4 || !errors.Is(rows.Err(), boom) {
+		t.Fatalf("got %d rows and error %v, want 4 rows and %v", n, rows.Err(), boom)
+	}
+}
+
+func TestFakeDBSlowRowsCancellation(t *testing.T) {
+	res := numbersResult(1000)
+	res.delay = 5 * time.Millisecond
+	registerFake("slow", &fakeQuery{sets: []*fakeResult{res}})
+	db, _ := sql.Open("fakedb", "")
+	defer db.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
+	defer cancel()
+	rows, err := db.QueryContext(ctx, "slow")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	n := 0
+	for rows.Next() {
+		n++
+	}
+	if n >= 1000 || !errors.Is(rows.Err(), context.DeadlineExceeded) {
+		t.Fatalf("got %d rows and error %v, want the deadline to stop the scan", n, rows.Err())
+	}
+}
+
+func TestFakeDBTransientFailures(t *testing.T) {
+	registerFake("flaky", &fakeQuery{sets: []*fakeResult{numbersResult(1)}, err: errors.New("deadlock victim"), failures: 2})
+	db, _ := sql.Open("fakedb", "")
+	defer db.Close()
+
+	for i := 1; i <= 3; i++ {
+		rows, err := db.Query("flaky")
+		if (err != nil) != (i <= 2) {
+			t.Fatalf("call %d: got error %v", i, err)
+		}
+		if rows != nil {
+			rows.Close()
+		}
+	}
+	if got := fakeCalls("flaky"); got != 3 {
+		t.Fatalf("got %d calls, want 3", got)
+	}
+}
@@ -0,0 +1,30 @@
+package extract
+
+import "fmt"
+
+// filterTransform keeps the rows for which an expression is true.
+type filterTransform struct {
+	src  string
+	expr exprNode
+}
+
+func (t *filterTransform) Setup(cols []column) ([]column, error) {
+	n, err := compileExpr(t.src, cols, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid filter: %v\n", err)
+	}
+	t.expr = n
+	return cols, nil
+}
+
+func (t *filterTransform) Apply(row []any) ([]any, bool, error) {
+	v, err := t.expr.eval(row)
+	if err != nil {
+		return nil, false, fmt.Errorf("filter %s: %v", t.src, err)
+	}
+	keep, err := truth(v)
+	if err != nil {
+		return nil, false, fmt.Errorf("filter %s: %v", t.src, err)
+	}
+	return row, keep, nil
+}
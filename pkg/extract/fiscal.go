@@ -0,0 +1,373 @@
+package extract
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// fiscalCalendarOptions defines a fiscal calendar other than whole months
+// from fiscal_year_start: a 52/53-week calendar of 4-4-5 quarters, or the
+// periods returned by a query.
+type fiscalCalendarOptions struct {
+	// Pattern is the weeks of the three periods of each quarter: 4-4-5,
+	// 4-5-4 or 5-4-4. The 53rd week of a long year goes to the last period.
+	Pattern string `yaml:"pattern"`
+	// A year ends on the last EndWeekday of EndMonth or, with Nearest, on
+	// the EndWeekday nearest the end of EndMonth.
+	EndMonth   int    `yaml:"end_month"`
+	EndWeekday string `yaml:"end_weekday"`
+	Nearest    bool   `yaml:"nearest"`
+	// Query returns the periods of a custom calendar, one row each:
+	// fiscal_year, fiscal_period, period_start and period_end, its last day.
+	Query string `yaml:"query"`
+	// NamedBy names a fiscal year after the calendar year it ends in (end,
+	// the default) or starts in (start).
+	NamedBy string `yaml:"named_by"`
+}
+
+func (o fiscalCalendarOptions) enabled() bool {
+	return o.Pattern != "" || o.Query != ""
+}
+
+var fiscalPatterns = map[string][3]int{
+	"4-4-5": {4, 4, 5},
+	"4-5-4": {4, 5, 4},
+	"5-4-4": {5, 4, 4},
+}
+
+var weekdays = map[string]time.Weekday{
+	"sunday": time.Sunday, "monday": time.Monday, "tuesday": time.Tuesday, "wednesday": time.Wednesday,
+	"thursday": time.Thursday, "friday": time.Friday, "saturday": time.Saturday,
+}
+
+func (o fiscalCalendarOptions) validate(c *config) error {
+	if !o.enabled() {
+		return nil
+	}
+	if c.FiscalYearStart != 0 {
+		return fmt.Errorf("fiscal_year_start and fiscal_calendar cannot both be set\n")
+	}
+	switch o.NamedBy {
+	case "", "end", "start":
+	default:
+		return fmt.Errorf("Unsupported fiscal_calendar named_by '%s' (end, start)\n", o.NamedBy)
+	}
+	if o.Query != "" {
+		if o.Pattern != "" {
+			return fmt.Errorf("fiscal_calendar takes either a pattern or a query\n")
+		}
+		return nil
+	}
+	if _, ok := fiscalPatterns[o.Pattern]; !ok {
+		return fmt.Errorf("Unsupported fiscal_calendar pattern '%s' (4-4-5, 4-5-4, 5-4-4)\n", o.Pattern)
+	}
+	if o.EndMonth < 1 || o.EndMonth > 12 {
+		return fmt.Errorf("fiscal_calendar end_month must be a month from 1 to 12, got %d\n", o.EndMonth)
+	}
+	if _, ok := weekdays[strings.ToLower(o.EndWeekday)]; !ok {
+		return fmt.Errorf("fiscal_calendar end_weekday must be a day of the week, got '%s'\n", o.EndWeekday)
+	}
+	return nil
+}
+
+// fiscalPeriod is where a day falls in a fiscal calendar. The ends are the
+// first days after.
+type fiscalPeriod struct {
+	year, quarter, period, week int
+	yearStart, yearEnd          time.Time
+	quarterStart, quarterEnd    time.Time
+	periodStart, periodEnd      time.Time
+}
+
+// bounds returns the start and end of the fiscal period, quarter, week or
+// year containing p's day.
+func (p fiscalPeriod) bounds(period string) (time.Time, time.Time) {
+	switch period {
+	case "fiscal_week":
+		// the last week of a year of months is cut short
+		start := p.yearStart.AddDate(0, 0, 7*(p.week-1))
+		end := start.AddDate(0, 0, 7)
+		if end.After(p.yearEnd) {
+			end = p.yearEnd
+		}
+		return start, end
+	case "fiscal_period":
+		return p.periodStart, p.periodEnd
+	case "fiscal_quarter":
+		return p.quarterStart, p.quarterEnd
+	}
+	return p.yearStart, p.yearEnd
+}
+
+// fiscalCalendar places days in fiscal years, quarters, periods and weeks.
+// Days are dates at midnight UTC.
+type fiscalCalendar interface {
+	lookup(day time.Time) (fiscalPeriod, error)
+}
+
+// fiscalCalendar returns the calendar of the run: fiscal_calendar, or whole
+// months from fiscal_year_start. A query calendar has no periods until it is
+// loaded.
+func (c *config) fiscalCalendar() fiscalCalendar {
+	if c.FiscalCalendar.Query != "" {
+		return c.calendar
+	}
+	if o := c.FiscalCalendar; o.Pattern != "" {
+		return weekCalendar{
+			weeks:   fiscalPatterns[o.Pattern],
+			month:   time.Month(o.EndMonth),
+			weekday: weekdays[strings.ToLower(o.EndWeekday)],
+			nearest: o.Nearest,
+			start:   o.NamedBy == "start",
+		}
+	}
+	return monthCalendar{first: c.fiscalStart(), start: c.FiscalCalendar.NamedBy == "start"}
+}
+
+// utcDate returns the date of t at midnight UTC.
+func utcDate(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
+
+// fiscalShift returns the start of the fiscal period of day, shifted by
+// offset periods, in day's location.
+func fiscalShift(cal fiscalCalendar, day time.Time, period string, offset int) (time.Time, error) {
+	p, err := cal.lookup(utcDate(day))
+	if err != nil {
+		return time.Time{}, err
+	}
+	start, end := p.bounds(period)
+	for ; offset < 0; offset++ {
+		if p, err = cal.lookup(start.AddDate(0, 0, -1)); err != nil {
+			return time.Time{}, err
+		}
+		start, end = p.bounds(period)
+	}
+	for ; offset > 0; offset-- {
+		if p, err = cal.lookup(end); err != nil {
+			return time.Time{}, err
+		}
+		start, end = p.bounds(period)
+	}
+	y, m, d := start.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, day.Location()), nil
+}
+
+// weekOf returns the week of day in a year starting on yearStart.
+func weekOf(day, yearStart time.Time) int {
+	return int(day.Sub(yearStart).Hours()/24)/7 + 1
+}
+
+// monthCalendar has years of twelve months starting in first.
+type monthCalendar struct {
+	first time.Month
+	start bool
+}
+
+func (cal monthCalendar) lookup(day time.Time) (fiscalPeriod, error) {
+	y, m, _ := day.Date()
+	k := int(m-cal.first+12) % 12
+	p := fiscalPeriod{period: k + 1, quarter: k/3 + 1}
+	p.yearStart = time.Date(y, m-time.Month(k), 1, 0, 0, 0, 0, time.UTC)
+	p.yearEnd = p.yearStart.AddDate(0, 12, 0)
+	p.quarterStart = p.yearStart.AddDate(0, 3*(p.quarter-1), 0)
+	p.quarterEnd = p.quarterStart.AddDate(0, 3, 0)
+	p.periodStart = time.Date(y, m, 1, 0, 0, 0, 0, time.UTC)
+	p.periodEnd = p.periodStart.AddDate(0, 1, 0)
+	p.week = weekOf(day, p.yearStart)
+	p.year = fiscalYearName(p.yearStart, p.yearEnd, cal.start)
+	return p, nil
+}
+
+// fiscalYearName names the year from start to end after the calendar year
+// it starts or ends in.
+func fiscalYearName(start, end time.Time, byStart bool) int {
+	if byStart {
+		return start.Year()
+	}
+	return end.AddDate(0, 0, -1).Year()
+}
+
+// weekCalendar has years of 52 or 53 weeks ending on a weekday at the end
+// of a month, in quarters of three periods of whole weeks.
+type weekCalendar struct {
+	weeks   [3]int
+	month   time.Month
+	weekday time.Weekday
+	nearest bool
+	start   bool
+}
+
+// yearEnd returns the first day after the fiscal year ending in calendar
+// year y.
+func (cal weekCalendar) yearEnd(y int) time.Time {
+	last := time.Date(y, cal.month+1, 0, 0, 0, 0, 0, time.UTC)
+	end := last.AddDate(0, 0, -((int(last.Weekday()) - int(cal.weekday) + 7) % 7))
+	if cal.nearest && last.Sub(end) > 3*24*time.Hour {
+		end = end.AddDate(0, 0, 7)
+	}
+	return end.AddDate(0, 0, 1)
+}
+
+func (cal weekCalendar) lookup(day time.Time) (fiscalPeriod, error) {
+	y := day.Year()
+	for !day.Before(cal.yearEnd(y)) {
+		y++
+	}
+	for day.Before(cal.yearEnd(y - 1)) {
+		y--
+	}
+	p := fiscalPeriod{yearStart: cal.yearEnd(y - 1), yearEnd: cal.yearEnd(y)}
+	p.year = fiscalYearName(p.yearStart, p.yearEnd, cal.start)
+	p.week = weekOf(day, p.yearStart)
+	extra := weekOf(p.yearEnd, p.yearStart) - 1 - 52
+	start := p.yearStart
+	for i := range 12 {
+		weeks := cal.weeks[i%3]
+		if i == 11 {
+			weeks += extra
+		}
+		end := start.AddDate(0, 0, 7*weeks)
+		if i%3 == 0 {
+			p.quarterStart = start
+		}
+		if day.Before(end) {
+			p.period, p.quarter = i+1, i/3+1
+			p.periodStart, p.periodEnd = start, end
+			break
+		}
+		start = end
+	}
+	p.quarterEnd = p.quarterStart
+	for i := (p.quarter - 1) * 3; i < p.quarter*3; i++ {
+		weeks := cal.weeks[i%3]
+		if i == 11 {
+			weeks += extra
+		}
+		p.quarterEnd = p.quarterEnd.AddDate(0, 0, 7*weeks)
+	}
+	return p, nil
+}
+
+// tablePeriod is one row of a custom fiscal calendar.
+type tablePeriod struct {
+	year, period int
+	start, end   time.Time
+}
+
+// tableCalendar is a custom fiscal calendar, its periods ordered by start.
+// Quarters divide each year's periods in four.
+type tableCalendar []tablePeriod
+
+func (cal tableCalendar) lookup(day time.Time) (fiscalPeriod, error) {
+	i := sort.Search(len(cal), func(i int) bool { return cal[i].end.After(day) })
+	if i == len(cal) || day.Before(cal[i].start) {
+		return fiscalPeriod{}, fmt.Errorf("%s is not in the fiscal calendar", day.Format("2006-01-02"))
+	}
+	row := cal[i]
+	var year []tablePeriod
+	for _, r := range cal {
+		if r.year == row.year {
+			year = append(year, r)
+		}
+	}
+	quarter := func(r tablePeriod) int { return (r.period-1)*4/len(year) + 1 }
+	p := fiscalPeriod{year: row.year, period: row.period, quarter: quarter(row), periodStart: row.start, periodEnd: row.end}
+	p.yearStart, p.yearEnd = year[0].start, year[len(year)-1].end
+	p.quarterStart, p.quarterEnd = row.start, row.end
+	for _, r := range year {
+		if quarter(r) == p.quarter {
+			if r.start.Before(p.quarterStart) {
+				p.quarterStart = r.start
+			}
+			if r.end.After(p.quarterEnd) {
+				p.quarterEnd = r.end
+			}
+		}
+	}
+	p.week = weekOf(day, p.yearStart)
+	return p, nil
+}
+
+// loadFiscalCalendar loads the periods of a fiscal_calendar query through db,
+// or a connection of its own when the run has none.
+func (c *config) loadFiscalCalendar(db *sql.DB) error {
+	if c.FiscalCalendar.Query == "" || c.calendar != nil {
+		return nil
+	}
+	if db == nil {
+		var err error
+		if db, err = sqlConnect(c); err != nil {
+			return err
+		}
+		defer db.Close()
+	}
+	cal, err := queryFiscalCalendar(context.Background(), db, c.FiscalCalendar.Query)
+	if err != nil {
+		return err
+	}
+	c.calendar = cal
+	return nil
+}
+
+// queryFiscalCalendar reads the periods of a custom calendar from the source.
+func queryFiscalCalendar(ctx context.Context, db *sql.DB, query string) (tableCalendar, error) {
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("Could not load the fiscal calendar: %v\n", err)
+	}
+	defer rows.Close()
+	var cal tableCalendar
+	for rows.Next() {
+		var year, period, start, end any
+		if err := rows.Scan(&year, &period, &start, &end); err != nil {
+			return nil, fmt.Errorf("Could not read the fiscal calendar, it needs fiscal_year, fiscal_period, period_start and period_end: %v\n", err)
+		}
+		var p tablePeriod
+		y, err1 := asInt64(year)
+		n, err2 := asInt64(period)
+		s, err3 := asDate(start)
+		e, err4 := asDate(end)
+		for _, err := range []error{err1, err2, err3, err4} {
+			if err != nil {
+				return nil, fmt.Errorf("Could not read the fiscal calendar: %v\n", err)
+			}
+		}
+		p.year, p.period, p.start, p.end = int(y), int(n), s, e.AddDate(0, 0, 1)
+		if !p.end.After(p.start) {
+			return nil, fmt.Errorf("Fiscal period %d of %d ends before it starts\n", p.period, p.year)
+		}
+		cal = append(cal, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("Could not load the fiscal calendar: %v\n", err)
+	}
+	if len(cal) == 0 {
+		return nil, fmt.Errorf("The fiscal calendar query returned no periods\n")
+	}
+	sort.Slice(cal, func(i, j int) bool { return cal[i].start.Before(cal[j].start) })
+	for i := 1; i < len(cal); i++ {
+		if cal[i].start.Before(cal[i-1].end) {
+			return nil, fmt.Errorf("Fiscal periods %d of %d and %d of %d overlap\n", cal[i-1].period, cal[i-1].year, cal[i].period, cal[i].year)
+		}
+	}
+	return cal, nil
+}
+
+// asDate converts a date driver value, or its 2006-01-02 text, to a date.
+func asDate(v any) (time.Time, error) {
+	switch v := v.(type) {
+	case time.Time:
+		return utcDate(v), nil
+	case []byte:
+		return time.Parse("2006-01-02", string(v))
+	case string:
+		return time.Parse("2006-01-02", v)
+	}
+	return time.Time{}, fmt.Errorf("cannot convert %T to a date", v)
+}
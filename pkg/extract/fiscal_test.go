@@ -0,0 +1,144 @@
+package extract
+
+import (
+	"context"
+	"database/sql/driver"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWeekCalendar(t *testing.T) {
+	// the retail calendar: years end on the Saturday nearest the end of
+	// January and are named after the year they start in
+	cal := weekCalendar{weeks: fiscalPatterns["4-4-5"], month: time.January, weekday: time.Saturday, nearest: true, start: true}
+	for _, tc := range []struct {
+		day                         string
+		year, quarter, period, week int
+		periodStart, periodEnd      string
+	}{
+		{"2024-02-04", 2024, 1, 1, 1, "2024-02-04", "2024-03-03"},
+		{"2024-03-10", 2024, 1, 2, 6, "2024-03-03", "2024-03-31"},
+		{"2024-05-04", 2024, 1, 3, 13, "2024-03-31", "2024-05-05"},
+		// fiscal 2023 has 53 weeks, the last in period 12
+		{"2024-02-01", 2023, 4, 12, 53, "2023-12-24", "2024-02-04"},
+		{"2025-02-01", 2024, 4, 12, 52, "2024-12-29", "2025-02-02"},
+	} {
+		day, _ := time.Parse("2006-01-02", tc.day)
+		p, err := cal.lookup(day)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := []any{p.year, p.quarter, p.period, p.week, p.periodStart.Format("2006-01-02"), p.periodEnd.Format("2006-01-02")}
+		want := []any{tc.year, tc.quarter, tc.period, tc.week, tc.periodStart, tc.periodEnd}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got %v, want %v", tc.day, got, want)
+		}
+	}
+}
+
+func TestFiscalShift(t *testing.T) {
+	cal := weekCalendar{weeks: fiscalPatterns["4-4-5"], month: time.January, weekday: time.Saturday, nearest: true, start: true}
+	run := time.Date(2024, time.March, 10, 9, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		period string
+		offset int
+		want   string
+	}{
+		{"fiscal_week", 0, "2024-03-10"},
+		{"fiscal_week", -6, "2024-01-28"},
+		{"fiscal_period", -1, "2024-02-04"},
+		{"fiscal_period", 1, "2024-03-31"},
+		{"fiscal_quarter", -1, "2023-10-29"},
+		{"fiscal_year", -1, "2023-01-29"},
+	} {
+		got, err := fiscalShift(cal, run, tc.period, tc.offset)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s := got.Format("2006-01-02"); s != tc.want {
+			t.Errorf("%s %+d: got %s, want %s", tc.period, tc.offset, s, tc.want)
+		}
+	}
+}
+
+func TestQueryFiscalCalendar(t *testing.T) {
+	// fiscal 2025 in four periods of three months
+	starts := []string{"2024-07-01", "2024-10-01", "2025-01-01", "2025-04-01"}
+	ends := []string{"2024-09-30", "2024-12-31", "2025-03-31", "2025-06-30"}
+	db, err := openFakeDB("SELECT * FROM dbo.FiscalPeriods", &fakeResult{
+		columns: []fakeColumn{
+			{name: "fiscal_year", dbType: "INT", scanType: reflect.TypeOf(int64(0))},
+			{name: "fiscal_period", dbType: "INT", scanType: reflect.TypeOf(int64(0))},
+			{name: "period_start", dbType: "DATE", scanType: reflect.TypeOf(time.Time{})},
+			{name: "period_end", dbType: "DATE", scanType: reflect.TypeOf(time.Time{})},
+		},
+		rows: 4,
+		value: func(row, col int) driver.Value {
+			switch col {
+			case 0:
+				return int64(2025)
+			case 1:
+				return int64(row + 1)
+			case 2:
+				d, _ := time.Parse("2006-01-02", starts[row])
+				return d
+			}
+			return ends[row]
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	cal, err := queryFiscalCalendar(context.Background(), db, "SELECT * FROM dbo.FiscalPeriods")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := cal.lookup(time.Date(2025, time.January, 9, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.year != 2025 || p.period != 3 || p.quarter != 3 || p.week != 28 || !p.yearEnd.Equal(time.Date(2025, time.July, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("got %+v", p)
+	}
+
+	c := &config{
+		Queries:        []string{`SELECT '{{ .PeriodStart "fiscal_period" -1 }}' -- P{{ .FiscalPeriod }}`},
+		OutFiles:       []string{"a.csv"},
+		FiscalCalendar: fiscalCalendarOptions{Query: "SELECT * FROM dbo.FiscalPeriods"},
+		calendar:       cal,
+		started:        time.Date(2025, time.January, 9, 14, 30, 0, 0, time.UTC),
+	}
+	got, err := renderQueries(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "SELECT '2024-10-01' -- P3"; got[0] != want {
+		t.Errorf("got %q, want %q", got[0], want)
+	}
+	c.started = time.Date(2025, time.August, 1, 0, 0, 0, 0, time.UTC)
+	if _, err := renderQueries(c); err == nil || !strings.Contains(err.Error(), "2025-08-01 is not in the fiscal calendar") {
+		t.Errorf("got %v, want the run date outside the calendar", err)
+	}
+}
+
+func TestFiscalCalendarValidate(t *testing.T) {
+	for _, tc := range []struct {
+		c    config
+		want string
+	}{
+		{config{FiscalYearStart: 7, FiscalCalendar: fiscalCalendarOptions{Query: "SELECT 1"}}, "cannot both be set"},
+		{config{FiscalCalendar: fiscalCalendarOptions{Pattern: "4-4-4"}}, "Unsupported fiscal_calendar pattern"},
+		{config{FiscalCalendar: fiscalCalendarOptions{Pattern: "4-4-5", EndMonth: 13}}, "end_month"},
+		{config{FiscalCalendar: fiscalCalendarOptions{Pattern: "4-4-5", EndMonth: 1, EndWeekday: "sat"}}, "end_weekday"},
+		{config{FiscalCalendar: fiscalCalendarOptions{Pattern: "4-4-5", Query: "SELECT 1"}}, "either a pattern or a query"},
+		{config{FiscalCalendar: fiscalCalendarOptions{Pattern: "4-4-5", EndMonth: 1, EndWeekday: "Saturday"}}, ""},
+	} {
+		err := tc.c.FiscalCalendar.validate(&tc.c)
+		if tc.want == "" && err != nil || tc.want != "" && (err == nil || !strings.Contains(err.Error(), tc.want)) {
+			t.Errorf("%+v: got %v, want %q", tc.c.FiscalCalendar, err, tc.want)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package extract
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"sort"
+	"time"
+)
+
+// flowOptions is a named group of jobs run together with -flow, such as the
+// morning-critical extracts, with its own concurrency, notification targets
+// and -serve interval.
+type flowOptions struct {
+	// Jobs are the names or outfiles of the jobs of the flow.
+	Jobs        []string       `yaml:"jobs"`
+	Concurrency int            `yaml:"concurrency"`
+	Notify      []string       `yaml:"notify"`
+	Every       configDuration `yaml:"every"`
+	// Schedule is the cron expression -daemon runs the flow on.
+	Schedule string `yaml:"schedule"`
+	// OnSuccess is the flow run next when every job of this one succeeds.
+	OnSuccess string `yaml:"on_success"`
+}
+
+func (o flowOptions) validate(c *config, name string) error {
+	if len(o.Jobs) == 0 {
+		return fmt.Errorf("Flow %s has no jobs\n", name)
+	}
+	if _, err := c.jobsNamed(o.Jobs); err != nil {
+		return fmt.Errorf("Flow %s: %v", name, err)
+	}
+	if o.Concurrency < 0 {
+		return fmt.Errorf("Flow %s concurrency must be at least 1, got %d\n", name, o.Concurrency)
+	}
+	if o.Every < 0 {
+		return fmt.Errorf("Flow %s every cannot be negative\n", name)
+	}
+	if o.Schedule != "" {
+		if _, err := parseCron(o.Schedule); err != nil {
+			return fmt.Errorf("Flow %s schedule: %v\n", name, err)
+		}
+	}
+	for _, target := range o.Notify {
+		u, err := url.Parse(target)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return fmt.Errorf("Flow %s notify target '%s' is not an http(s) URL\n", name, target)
+		}
+	}
+	// the chain from the flow must end
+	seen := map[string]bool{name: true}
+	for next := o.OnSuccess; next != ""; next = c.Flows[next].OnSuccess {
+		if _, ok := c.Flows[next]; !ok {
+			return fmt.Errorf("Flow %s is followed by unknown flow '%s'\n", name, next)
+		}
+		if seen[next] {
+			return fmt.Errorf("Flow %s is followed by itself through on_success\n", name)
+		}
+		seen[next] = true
+	}
+	return nil
+}
+
+// selectFlow narrows the jobs down to those of the flow name and applies
+// its settings.
+func (c *config) selectFlow(name string) error {
+	o, ok := c.Flows[name]
+	if !ok {
+		return fmt.Errorf("No flow is named %s\n", name)
+	}
+	outFiles, err := c.jobsNamed(o.Jobs)
+	if err != nil {
+		return err
+	}
+	c.keepJobs(outFiles)
+	if o.Concurrency > 0 {
+		c.Concurrency = o.Concurrency
+	}
+	c.flow = name
+	return nil
+}
+
+// runChain runs c with run, then as long as every job succeeds the flow that
+// the on_success of its flow names, loaded with loadFlow, on the same
+// controller.
+func runChain(ctx context.Context, c *config, control *controller, loadFlow func(flow string) (*config, error), run func(context.Context, *config, *controller) error) error {
+	for {
+		if err := run(ctx, c, control); err != nil {
+			return err
+		}
+		next := c.Flows[c.flow].OnSuccess
+		if c.flow == "" || next == "" || ctx.Err() != nil || !control.hold() {
+			return nil
+		}
+		log.Printf("Flow %s succeeded, starting flow %s\n", c.flow, next)
+		var err error
+		if c, err = loadFlow(next); err != nil {
+			return err
+		}
+		control.begin(c.OutFiles)
+	}
+}
+
+// flowNotice is posted as JSON to the notify targets of a flow once its run
+// is over.
+type flowNotice struct {
+	Event    string      `json:"event"`
+	Flow     string      `json:"flow"`
+	Started  time.Time   `json:"started"`
+	Finished time.Time   `json:"finished"`
+	Jobs     []tenantJob `json:"jobs"`
+}
+
+// notifyFlow posts the outcome of the run's jobs to the targets of its flow.
+// Failed notifications are logged and do not fail the run.
+func notifyFlow(c *config, control *controller, r *runReport) {
+	o := c.Flows[c.flow]
+	if c.flow == "" || len(o.Notify) == 0 {
+		return
+	}
+	notice := flowNotice{Event: "flow_run", Flow: c.flow, Started: c.started, Finished: time.Now()}
+	notice.Jobs = jobOutcomes(c, control, r, func(string) bool { return true })
+	body, err := json.Marshal(notice)
+	if err != nil {
+		log.Printf("Warning: could not encode the notification for flow %s: %v\n", c.flow, err)
+		return
+	}
+	for _, target := range o.Notify {
+		if err := postNotice(target, body); err != nil {
+			log.Printf("Warning: could not notify flow %s at %s: %v\n", c.flow, target, err)
+		}
+	}
+}
+
+// jobOutcomes returns the status and rows of the run's jobs that keep
+// selects, by outfile.
+func jobOutcomes(c *config, control *controller, r *runReport, keep func(outFile string) bool) []tenantJob {
+	rows := map[string]uint{}
+	r.mu.Lock()
+	for _, j := range r.Jobs {
+		rows[j.OutFile] = j.Rows
+	}
+	r.mu.Unlock()
+	states := control.states()
+	var jobs []tenantJob
+	for _, outFile := range c.OutFiles {
+		if keep(outFile) {
+			jobs = append(jobs, tenantJob{OutFile: outFile, Status: states[outFile], TimedOut: control.timedOutJob(outFile), Rows: rows[outFile]})
+		}
+	}
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].OutFile < jobs[j].OutFile })
+	return jobs
+}
@@ -0,0 +1,146 @@
+package extract
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFlows(t *testing.T) {
+	data := `concurrency: 8
+extracts:
+  - name: customer
+    query: SELECT * FROM dbo.Customer
+    outfile: customer.csv
+  - name: orders
+    query: SELECT * FROM dbo.Orders
+    outfile: orders.csv
+  - name: ledger
+    query: SELECT * FROM dbo.Ledger
+    outfile: ledger.csv
+flows:
+  morning-critical:
+    jobs: [customer, orders.csv]
+    concurrency: 2
+    every: 24h
+  monthly-regulatory:
+    jobs: [ledger]
+`
+	c, _, err := parseConfigDocs([]configDoc{{"config.yaml", []byte(data)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.validate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.selectFlow("morning-critical"); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"customer.csv", "orders.csv"}; !reflect.DeepEqual(c.OutFiles, want) {
+		t.Errorf("got jobs %v, want %v", c.OutFiles, want)
+	}
+	if c.concurrency() != 2 || c.flow != "morning-critical" {
+		t.Errorf("got concurrency %d and flow %q", c.concurrency(), c.flow)
+	}
+	if err := c.selectFlow("nightly"); err == nil || !strings.Contains(err.Error(), "No flow is named nightly") {
+		t.Errorf("unknown flow: got %v", err)
+	}
+
+	for _, bad := range []flowOptions{
+		{},
+		{Jobs: []string{"invoices"}},
+		{Jobs: []string{"customer"}, Concurrency: -1},
+		{Jobs: []string{"customer"}, Notify: []string{"ftp://alerts"}},
+	} {
+		c.Flows = map[string]flowOptions{"bad": bad}
+		if err := c.validate(); err == nil {
+			t.Errorf("accepted flow %+v", bad)
+		}
+	}
+}
+
+func TestRunChain(t *testing.T) {
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	data := `extracts:
+  - {name: orders, query: SELECT 1, outfile: orders.csv}
+  - {name: upload, query: SELECT 2, outfile: upload.csv}
+  - {name: notify, query: SELECT 3, outfile: notify.csv}
+flows:
+  extract: {jobs: [orders], on_success: upload-and-notify}
+  upload-and-notify: {jobs: [upload, notify]}
+`
+	loadFlow := func(flow string) (*config, error) {
+		c, _, err := parseConfigDocs([]configDoc{{"config.yaml", []byte(data)}})
+		if err != nil {
+			return nil, err
+		}
+		if err := c.validate(); err != nil {
+			return nil, err
+		}
+		return c, c.selectFlow(flow)
+	}
+	var ran [][]string
+	fail := ""
+	run := func(_ context.Context, c *config, _ *controller) error {
+		ran = append(ran, c.OutFiles)
+		if c.flow == fail {
+			return errors.New("1 of 1 extract(s) failed\n")
+		}
+		return nil
+	}
+
+	c, err := loadFlow("extract")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := runChain(context.Background(), c, newController(c.OutFiles), loadFlow, run); err != nil {
+		t.Fatal(err)
+	}
+	if want := [][]string{{"orders.csv"}, {"upload.csv", "notify.csv"}}; !reflect.DeepEqual(ran, want) {
+		t.Errorf("ran %v, want %v", ran, want)
+	}
+
+	ran, fail = nil, "extract"
+	if err := runChain(context.Background(), c, newController(c.OutFiles), loadFlow, run); err == nil || len(ran) != 1 {
+		t.Errorf("a failed flow was followed: ran %v, %v", ran, err)
+	}
+
+	c, _, _ = parseConfigDocs([]configDoc{{"config.yaml", []byte(data)}})
+	c.Flows["upload-and-notify"] = flowOptions{Jobs: []string{"upload"}, OnSuccess: "extract"}
+	if err := c.validate(); err == nil || !strings.Contains(err.Error(), "followed by itself") {
+		t.Errorf("cycle: got %v", err)
+	}
+	c.Flows["upload-and-notify"] = flowOptions{Jobs: []string{"upload"}, OnSuccess: "archive"}
+	if err := c.validate(); err == nil || !strings.Contains(err.Error(), "unknown flow 'archive'") {
+		t.Errorf("unknown flow: got %v", err)
+	}
+}
+
+func TestNotifyFlow(t *testing.T) {
+	notices := make(chan flowNotice, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var n flowNotice
+		json.NewDecoder(r.Body).Decode(&n)
+		notices <- n
+	}))
+	defer srv.Close()
+
+	c := &config{OutFiles: []string{"orders.csv"}, Flows: map[string]flowOptions{"morning": {Jobs: []string{"orders.csv"}, Notify: []string{srv.URL}}}, flow: "morning"}
+	control := newController(c.OutFiles)
+	control.start(context.Background(), "orders.csv")
+	control.finish("orders.csv", nil)
+	notifyFlow(c, control, newRunReport(""))
+	n := <-notices
+	if n.Event != "flow_run" || n.Flow != "morning" || len(n.Jobs) != 1 || n.Jobs[0].Status != jobDone {
+		t.Errorf("got %+v", n)
+	}
+}
@@ -0,0 +1,111 @@
+package extract
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+// FuzzCSVWriter round-trips arbitrary values through csvWriter and
+// encoding/csv with the same delimiter.
+func FuzzCSVWriter(f *testing.F) {
+	f.Add(",", "plain", "with,comma")
+	f.Add(";", "quote \" inside", "new\nline")
+	f.Add("\t", "", " leading space")
+	f.Add("|", "\r\n", "\xff\xfe invalid utf-8")
+	f.Fuzz(func(t *testing.T, delimiter, a, b string) {
+		d, _ := utf8.DecodeRuneInString(delimiter)
+		if !validCSVDelimiter(d) {
+			t.Skip()
+		}
+		var buf bytes.Buffer
+		w := newCSVWriter(&buf, d)
+		cols := []column{{Name: "a", DBType: "NVARCHAR"}, {Name: "b", DBType: "VARBINARY"}}
+		if err := w.WriteHeader(cols); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.WriteRow([]any{a, []byte(b)}); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		r := csv.NewReader(&buf)
+		r.Comma = d
+		records, err := r.ReadAll()
+		if err != nil {
+			t.Fatalf("output is not parseable: %v\n%q", err, buf.String())
+		}
+		if len(records) != 2 {
+			t.Fatalf("got %d records, want 2: %q", len(records), buf.String())
+		}
+		// encoding/csv reads a quoted \r\n back as \n
+		want := []string{csvNormalize(a), csvNormalize(b)}
+		if got := records[1]; len(got) != 2 || got[0] != want[0] || got[1] != want[1] {
+			t.Fatalf("got %q, want %q", got, want)
+		}
+	})
+}
+
+// validCSVDelimiter mirrors the delimiters encoding/csv accepts.
+func validCSVDelimiter(r rune) bool {
+	return r != 0 && r != '"' && r != '\r' && r != '\n' && utf8.ValidRune(r) && r != utf8.RuneError
+}
+
+func csvNormalize(s string) string {
+	return strings.ReplaceAll(s, "\r\n", "\n")
+}
+
+// FuzzJSONWriter checks that jsonWriter always produces valid JSON holding the
+// written values, with invalid UTF-8 replaced as encoding/json does.
+func FuzzJSONWriter(f *testing.F) {
+	f.Add("name", "value", "addr_", "addr_city", "Paris")
+	f.Add("quote\"key", "line\nbreak", "", "x", "\x00\x1f ")
+	f.Add("a", "\xff", "a_", "a_b", "</script>")
+	f.Fuzz(func(t *testing.T, k1, v1, nest, k2, v2 string) {
+		// keys that collide once coerced to UTF-8 are ambiguous
+		key1, key2 := coerceUTF8(k1), coerceUTF8(k2)
+		if key1 == key2 || key1 == coerceUTF8(strings.TrimRight(nest, "_")) || (nest != "" && strings.HasPrefix(k1, nest)) {
+			t.Skip()
+		}
+		var buf bytes.Buffer
+		w := newJSONWriter(&buf, []string{nest})
+		if err := w.WriteHeader([]column{{Name: k1}, {Name: k2}}); err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 2; i++ {
+			if err := w.WriteRow([]any{v1, []byte(v2)}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		var rows []map[string]any
+		if err := json.Unmarshal(buf.Bytes(), &rows); err != nil {
+			t.Fatalf("output is not valid JSON: %v\n%q", err, buf.String())
+		}
+		if len(rows) != 2 {
+			t.Fatalf("got %d rows, want 2", len(rows))
+		}
+		if got := rows[0][key1]; got != coerceUTF8(v1) {
+			t.Fatalf("%q = %q, want %q", k1, got, coerceUTF8(v1))
+		}
+	})
+}
+
+// coerceUTF8 replaces each invalid byte with U+FFFD like encoding/json.
+func coerceUTF8(s string) string {
+	var b strings.Builder
+	for len(s) > 0 {
+		r, size := utf8.DecodeRuneInString(s)
+		b.WriteRune(r)
+		s = s[size:]
+	}
+	return b.String()
+}
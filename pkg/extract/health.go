@@ -0,0 +1,121 @@
+package extract
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// health records what /healthz and /readyz report about a -serve process.
+type health struct {
+	mu          sync.Mutex
+	every       time.Duration
+	staleAfter  time.Duration
+	running     bool
+	lastRun     time.Time
+	nextRun     time.Time
+	lastSuccess map[string]time.Time
+	jobs        []string
+}
+
+// newHealth starts tracking a server that runs every interval. Jobs count as
+// stale once their last success is older than staleAfter, or twice the
+// interval when staleAfter is zero.
+func newHealth(every, staleAfter time.Duration) *health {
+	if staleAfter <= 0 {
+		staleAfter = 2 * every
+	}
+	return &health{every: every, staleAfter: staleAfter, lastSuccess: map[string]time.Time{}}
+}
+
+// runStarted records the start of a run of the given jobs.
+func (h *health) runStarted(jobs []string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.running, h.lastRun, h.jobs = true, time.Now(), jobs
+}
+
+// runFinished records which jobs of the run succeeded and when the next run is due.
+func (h *health) runFinished(states map[string]string, next time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.running, h.nextRun = false, next
+	for outFile, state := range states {
+		if state == jobDone {
+			h.lastSuccess[outFile] = time.Now()
+		}
+	}
+}
+
+type jobHealth struct {
+	OutFile     string     `json:"outfile"`
+	LastSuccess *time.Time `json:"last_success,omitempty"`
+	Stale       bool       `json:"stale"`
+}
+
+type healthStatus struct {
+	Scheduler string      `json:"scheduler"`
+	Running   bool        `json:"running"`
+	LastRun   *time.Time  `json:"last_run,omitempty"`
+	NextRun   *time.Time  `json:"next_run,omitempty"`
+	Ready     bool        `json:"ready"`
+	Jobs      []jobHealth `json:"jobs"`
+}
+
+// status reports the scheduler and every job. The process is ready unless it
+// is draining or a job is stale; a job that never succeeded only counts as
+// stale once the process has been up for the staleness limit.
+func (h *health) status(state string, up time.Time) healthStatus {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s := healthStatus{Scheduler: state, Running: h.running, Ready: state != stateDraining}
+	if !h.lastRun.IsZero() {
+		last := h.lastRun
+		s.LastRun = &last
+	}
+	if !h.nextRun.IsZero() {
+		next := h.nextRun
+		s.NextRun = &next
+	}
+	jobs := append([]string(nil), h.jobs...)
+	sort.Strings(jobs)
+	for _, outFile := range jobs {
+		j := jobHealth{OutFile: outFile}
+		since := up
+		if t, ok := h.lastSuccess[outFile]; ok {
+			j.LastSuccess, since = &t, t
+		}
+		if j.Stale = time.Since(since) > h.staleAfter; j.Stale {
+			s.Ready = false
+		}
+		s.Jobs = append(s.Jobs, j)
+	}
+	return s
+}
+
+// serveHealth serves /healthz, which answers 200 while the process is up,
+// and /readyz, which answers 503 while it is not ready; both describe the
+// scheduler and jobs as JSON.
+func serveHealth(addr string, h *health, control *controller) {
+	up := time.Now()
+	write := func(w http.ResponseWriter, ready bool) {
+		s := h.status(control.runState(), up)
+		w.Header().Set("Content-Type", "application/json")
+		if ready && !s.Ready {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(s)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) { write(w, false) })
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) { write(w, true) })
+	go func() {
+		log.Printf("Serving health checks on http://%s/healthz and /readyz\n", addr)
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			log.Printf("Warning: health server stopped: %v\n", err)
+		}
+	}()
+}
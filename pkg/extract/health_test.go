@@ -0,0 +1,37 @@
+package extract
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHealthStatus(t *testing.T) {
+	h := newHealth(time.Hour, 0)
+	control := newController([]string{"a.csv", "b.csv"})
+	up := time.Now()
+
+	h.runStarted([]string{"a.csv", "b.csv"})
+	if s := h.status(control.runState(), up); !s.Running || !s.Ready {
+		t.Fatalf("during the first run got %+v, want running and ready", s)
+	}
+
+	h.runFinished(map[string]string{"a.csv": jobDone, "b.csv": jobFailed}, up.Add(time.Hour))
+	s := h.status(control.runState(), up)
+	if s.Running || !s.Ready || s.NextRun == nil {
+		t.Fatalf("after the run got %+v", s)
+	}
+	if s.Jobs[0].LastSuccess == nil || s.Jobs[1].LastSuccess != nil {
+		t.Errorf("last success: got %+v", s.Jobs)
+	}
+
+	// b.csv never succeeded in the three hours since start, past twice the interval
+	s = h.status(control.runState(), up.Add(-3*time.Hour))
+	if s.Ready || !s.Jobs[1].Stale || s.Jobs[0].Stale {
+		t.Errorf("with b.csv stale got %+v", s)
+	}
+
+	control.setState(stateDraining)
+	if s := h.status(control.runState(), up); s.Ready {
+		t.Error("ready while draining")
+	}
+}
@@ -0,0 +1,807 @@
+package extract
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// icebergOptions holds the settings for iceberg:// destinations.
+type icebergOptions struct {
+	// PartitionBy lists the columns the table is partitioned on, by identity.
+	PartitionBy []string `yaml:"partition_by"`
+	// Compression is the Parquet codec for data files: snappy (default), gzip, zstd or none.
+	Compression string `yaml:"compression"`
+}
+
+// icebergField is a column of an Iceberg schema. Type is a primitive type
+// name for the columns this tool writes; other engines may have nested ones.
+type icebergField struct {
+	ID       int    `json:"id"`
+	Name     string `json:"name"`
+	Required bool   `json:"required"`
+	Type     any    `json:"type"`
+}
+
+type icebergSchema struct {
+	Type     string         `json:"type"`
+	SchemaID int            `json:"schema-id"`
+	Fields   []icebergField `json:"fields"`
+}
+
+type icebergPartitionField struct {
+	Name      string `json:"name"`
+	Transform string `json:"transform"`
+	SourceID  int    `json:"source-id"`
+	FieldID   int    `json:"field-id"`
+}
+
+type icebergSpec struct {
+	SpecID int                     `json:"spec-id"`
+	Fields []icebergPartitionField `json:"fields"`
+}
+
+// icebergMetadata is what an append reads of the table metadata. The file
+// itself is kept as raw, so fields this tool does not know survive the
+// commit.
+type icebergMetadata struct {
+	FormatVersion      int             `json:"format-version"`
+	Location           string          `json:"location"`
+	LastSequenceNumber int64           `json:"last-sequence-number"`
+	CurrentSchemaID    int             `json:"current-schema-id"`
+	Schemas            []icebergSchema `json:"schemas"`
+	DefaultSpecID      int             `json:"default-spec-id"`
+	PartitionSpecs     []icebergSpec   `json:"partition-specs"`
+	CurrentSnapshotID  *int64          `json:"current-snapshot-id"`
+	Snapshots          []struct {
+		SnapshotID   int64  `json:"snapshot-id"`
+		ManifestList string `json:"manifest-list"`
+	} `json:"snapshots"`
+
+	version int64
+	raw     map[string]any
+}
+
+// icebergWriter appends the result set to an Iceberg table as a new
+// snapshot. The table is a file system table, as the Hadoop catalog keeps
+// them: each commit claims metadata/v<N>.metadata.json, so tables a catalog
+// service tracks are refused rather than forked.
+type icebergWriter struct {
+	table   string
+	store   tableStore
+	opts    icebergOptions
+	files   *tableFiles
+	current *icebergMetadata
+	done    bool
+}
+
+func newIcebergWriter(table string, o icebergOptions) (*icebergWriter, error) {
+	if _, err := parquetCodec(o.Compression); err != nil {
+		return nil, err
+	}
+	store, err := openTableStore(table)
+	if err != nil {
+		return nil, err
+	}
+	return newIcebergTableWriter(table, store, o), nil
+}
+
+// newIcebergTableWriter appends to the table held in store.
+func newIcebergTableWriter(table string, store tableStore, o icebergOptions) *icebergWriter {
+	files := &tableFiles{store: store, dir: "data", compression: o.Compression, nullPartition: "null", keepPartitions: true}
+	return &icebergWriter{table: table, store: store, opts: o, files: files}
+}
+
+// WriteHeader checks the columns against the table, if there is one, since
+// the data files carry its field ids.
+func (w *icebergWriter) WriteHeader(cols []column) error {
+	if err := w.files.setup(cols, w.opts.PartitionBy); err != nil {
+		return err
+	}
+	for _, idx := range w.files.partIdx {
+		if _, err := icebergPartitionType(cols[idx]); err != nil {
+			return err
+		}
+	}
+	current, err := readIcebergMetadata(w.store)
+	if err != nil {
+		return err
+	}
+	w.current = current
+	ids, err := w.fieldIDs(current)
+	if err != nil {
+		return err
+	}
+	w.files.fieldIDs = ids
+	return nil
+}
+
+func (w *icebergWriter) WriteRow(values []any) error {
+	return w.files.write(values)
+}
+
+// fieldIDs returns the field ids of the columns in the table described by
+// meta, numbering them from 1 for a new table, and fails when the columns
+// or partitioning differ from the table's.
+func (w *icebergWriter) fieldIDs(meta *icebergMetadata) ([]int, error) {
+	cols := w.files.cols
+	ids := make([]int, len(cols))
+	if meta == nil {
+		for i := range ids {
+			ids[i] = i + 1
+		}
+		return ids, nil
+	}
+	fail := func(format string, args ...any) ([]int, error) {
+		return nil, fmt.Errorf("Cannot append to iceberg table %s: %s\n", w.table, fmt.Sprintf(format, args...))
+	}
+	if meta.FormatVersion != 2 {
+		return fail("format version %d is not supported, only 2", meta.FormatVersion)
+	}
+	schema, ok := meta.schema()
+	if !ok {
+		return fail("current schema %d is missing", meta.CurrentSchemaID)
+	}
+	if len(schema.Fields) != len(cols) {
+		return fail("table has %d columns, query returned %d", len(schema.Fields), len(cols))
+	}
+	for i, f := range schema.Fields {
+		// engines differ in the spacing of decimal(P, S)
+		if typ := icebergType(cols[i]); !strings.EqualFold(f.Name, cols[i].Name) || strings.ReplaceAll(fmt.Sprint(f.Type), " ", "") != strings.ReplaceAll(typ, " ", "") {
+			return fail("column %d is %s %v in the table but %s %s in the query", i+1, f.Name, f.Type, cols[i].Name, typ)
+		}
+		ids[i] = f.ID
+	}
+	spec, _ := meta.spec()
+	var partitionBy []string
+	for _, f := range spec.Fields {
+		name := ""
+		for i, sf := range schema.Fields {
+			if sf.ID == f.SourceID && f.Transform == "identity" {
+				name = cols[i].Name
+			}
+		}
+		if name == "" {
+			return fail("partition field %s is not an identity partition", f.Name)
+		}
+		partitionBy = append(partitionBy, name)
+	}
+	if strings.Join(partitionBy, ",") != strings.Join(w.opts.PartitionBy, ",") {
+		return fail("table is partitioned by [%s], not [%s]", strings.Join(partitionBy, ","), strings.Join(w.opts.PartitionBy, ","))
+	}
+	return ids, nil
+}
+
+// Close finishes the data files and commits them as a new snapshot.
+func (w *icebergWriter) Close() error {
+	w.done = true
+	defer w.store.Close()
+	parts, err := w.files.finish()
+	if err == nil {
+		err = w.commit(parts)
+	}
+	if err != nil {
+		w.files.cleanup()
+		return err
+	}
+	return nil
+}
+
+// commit writes a manifest of the new data files, then claims the next
+// metadata version with a snapshot whose manifest list adds that manifest to
+// the current snapshot's, retrying when another writer claims it first.
+func (w *icebergWriter) commit(parts []*tablePart) error {
+	spec := w.partitionSpec(w.current)
+	manifest := fmt.Sprintf("metadata/%s-m0.avro", newUUID())
+	data, err := w.manifest(spec, parts)
+	if err != nil {
+		return err
+	}
+	var written []string
+	defer func() {
+		for _, rel := range written {
+			w.store.remove(rel)
+		}
+	}()
+	if err := w.writeFile(manifest, data); err != nil {
+		return err
+	}
+	written = append(written, manifest)
+	var rows int64
+	for _, part := range parts {
+		rows += part.rows
+	}
+	entry := icebergManifestFile{
+		path:       w.store.location(manifest),
+		length:     int64(len(data)),
+		specID:     spec.SpecID,
+		addedFiles: int64(len(parts)),
+		addedRows:  rows,
+	}
+
+	for attempt := 0; attempt < deltaCommitAttempts; attempt++ {
+		current, err := readIcebergMetadata(w.store)
+		if err != nil {
+			return err
+		}
+		// the data files and manifest carry the field ids and partition spec
+		// read when the job started
+		if ids, err := w.fieldIDs(current); err != nil {
+			return err
+		} else if fmt.Sprint(ids) != fmt.Sprint(w.files.fieldIDs) || fmt.Sprint(w.partitionSpec(current)) != fmt.Sprint(spec) {
+			return fmt.Errorf("Cannot append to iceberg table %s: its schema changed during the run\n", w.table)
+		}
+		meta, list, err := w.snapshot(current, entry)
+		if err != nil {
+			return err
+		}
+		version := int64(1)
+		if current != nil {
+			version = current.version + 1
+		}
+		body, err := json.MarshalIndent(meta, "", "  ")
+		if err != nil {
+			return err
+		}
+		metaFile := fmt.Sprintf("metadata/v%d.metadata.json", version)
+		err = w.store.claim(metaFile, body)
+		if err != nil {
+			// the list only belongs to this attempt; the next one writes its own
+			w.store.remove(list)
+		}
+		if errors.Is(err, os.ErrExist) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("Could not commit to iceberg table %s: %v\n", w.table, err)
+		}
+		written = nil
+		// readers that do not list the metadata find the version through the
+		// hint, which only lags if this write fails
+		if err := w.writeFile("metadata/version-hint.text", []byte(strconv.FormatInt(version, 10))); err != nil {
+			log.Printf("Warning: committed version %d of iceberg table %s, but %v", version, w.table, err)
+		}
+		return nil
+	}
+	return fmt.Errorf("Could not commit to iceberg table %s after %d attempts\n", w.table, deltaCommitAttempts)
+}
+
+// writeFile writes a small file of the table whole.
+func (w *icebergWriter) writeFile(rel string, data []byte) error {
+	u, err := w.store.create(rel)
+	if err != nil {
+		return err
+	}
+	if _, err := u.Write(data); err != nil {
+		u.Abort()
+		return fmt.Errorf("Could not write %s: %v\n", w.store.location(rel), err)
+	}
+	if err := u.Close(); err != nil {
+		return fmt.Errorf("Could not write %s: %v\n", w.store.location(rel), err)
+	}
+	return nil
+}
+
+// snapshot returns the table metadata after this append to current, nil for
+// a new table, and writes the manifest list of the new snapshot, returning
+// its path.
+func (w *icebergWriter) snapshot(current *icebergMetadata, added icebergManifestFile) (map[string]any, string, error) {
+	now := time.Now().UnixMilli()
+	snapshotID := newSnapshotID()
+	seq := int64(1)
+	var meta map[string]any
+	var parent *int64
+	if current == nil {
+		meta = w.newMetadata(now)
+	} else {
+		meta = current.raw
+		seq = current.LastSequenceNumber + 1
+		if id := current.CurrentSnapshotID; id != nil && *id >= 0 {
+			parent = id
+		}
+	}
+
+	manifests := []icebergManifestFile{}
+	if parent != nil {
+		var err error
+		if manifests, err = w.readManifestList(current, *parent); err != nil {
+			return nil, "", err
+		}
+	}
+	added.seq, added.minSeq, added.snapshotID = seq, seq, snapshotID
+	manifests = append(manifests, added)
+
+	listMeta := map[string]string{"snapshot-id": strconv.FormatInt(snapshotID, 10), "sequence-number": strconv.FormatInt(seq, 10), "format-version": "2", "parent-snapshot-id": "null"}
+	if parent != nil {
+		listMeta["parent-snapshot-id"] = strconv.FormatInt(*parent, 10)
+	}
+	var records [][]byte
+	for _, m := range manifests {
+		records = append(records, m.encode())
+	}
+	list := fmt.Sprintf("metadata/snap-%d-1-%s.avro", snapshotID, newUUID())
+	if err := w.writeFile(list, writeAvroFile(icebergManifestListSchema, listMeta, records)); err != nil {
+		return nil, "", err
+	}
+
+	schemaID := 0
+	if current != nil {
+		schemaID = current.CurrentSchemaID
+	}
+	snapshot := map[string]any{
+		"snapshot-id":     snapshotID,
+		"sequence-number": seq,
+		"timestamp-ms":    now,
+		"manifest-list":   w.store.location(list),
+		"schema-id":       schemaID,
+		"summary": map[string]string{
+			"operation":        "append",
+			"added-data-files": strconv.FormatInt(added.addedFiles, 10),
+			"added-records":    strconv.FormatInt(added.addedRows, 10),
+		},
+	}
+	if parent != nil {
+		snapshot["parent-snapshot-id"] = *parent
+		appendJSON(meta, "metadata-log", map[string]any{
+			"timestamp-ms":  meta["last-updated-ms"],
+			"metadata-file": w.store.location(fmt.Sprintf("metadata/v%d.metadata.json", current.version)),
+		})
+	}
+	meta["last-sequence-number"] = seq
+	meta["last-updated-ms"] = now
+	meta["current-snapshot-id"] = snapshotID
+	appendJSON(meta, "snapshots", snapshot)
+	appendJSON(meta, "snapshot-log", map[string]any{"timestamp-ms": now, "snapshot-id": snapshotID})
+	refs, _ := meta["refs"].(map[string]any)
+	if refs == nil {
+		refs = map[string]any{}
+	}
+	refs["main"] = map[string]any{"snapshot-id": snapshotID, "type": "branch"}
+	meta["refs"] = refs
+	return meta, list, nil
+}
+
+// newMetadata describes a new table of the result's columns.
+func (w *icebergWriter) newMetadata(now int64) map[string]any {
+	schema := icebergSchema{Type: "struct"}
+	for i, c := range w.files.cols {
+		schema.Fields = append(schema.Fields, icebergField{ID: w.files.fieldIDs[i], Name: c.Name, Type: icebergType(c)})
+	}
+	spec := w.partitionSpec(nil)
+	lastPartition := 999
+	if n := len(spec.Fields); n > 0 {
+		lastPartition = spec.Fields[n-1].FieldID
+	}
+	return map[string]any{
+		"format-version":        2,
+		"table-uuid":            newUUID(),
+		"location":              strings.TrimSuffix(w.store.location(""), "/"),
+		"last-column-id":        len(schema.Fields),
+		"current-schema-id":     0,
+		"schemas":               []icebergSchema{schema},
+		"default-spec-id":       0,
+		"partition-specs":       []icebergSpec{spec},
+		"last-partition-id":     lastPartition,
+		"default-sort-order-id": 0,
+		"sort-orders":           []any{map[string]any{"order-id": 0, "fields": []any{}}},
+		"properties":            map[string]string{},
+		"last-updated-ms":       now,
+		"metadata-log":          []any{},
+	}
+}
+
+// partitionSpec returns the table's default spec, or the spec of a new
+// table partitioned by the identity of each PartitionBy column.
+func (w *icebergWriter) partitionSpec(meta *icebergMetadata) icebergSpec {
+	if meta != nil {
+		spec, _ := meta.spec()
+		return spec
+	}
+	spec := icebergSpec{Fields: []icebergPartitionField{}}
+	for i, idx := range w.files.partIdx {
+		spec.Fields = append(spec.Fields, icebergPartitionField{
+			Name:      w.files.cols[idx].Name,
+			Transform: "identity",
+			SourceID:  w.files.fieldIDs[idx],
+			FieldID:   1000 + i,
+		})
+	}
+	return spec
+}
+
+// manifest encodes the manifest of the data files, whose entries inherit
+// the snapshot id and sequence number of the snapshot that adds them, so
+// the manifest stays valid when a commit is retried.
+func (w *icebergWriter) manifest(spec icebergSpec, parts []*tablePart) ([]byte, error) {
+	var partFields []string
+	var partTypes []string
+	for i, f := range spec.Fields {
+		col := w.files.cols[w.files.partIdx[i]]
+		typ, _ := icebergPartitionType(col)
+		partTypes = append(partTypes, typ)
+		partFields = append(partFields, fmt.Sprintf(`{"name":%q,"type":["null",%s],"default":null,"field-id":%d}`, f.Name, typ, f.FieldID))
+	}
+	schema := fmt.Sprintf(icebergManifestSchema, strings.Join(partFields, ","))
+
+	var records [][]byte
+	for _, part := range parts {
+		var e avroEncoder
+		e.int(1) // added
+		e.union(false)
+		e.union(false)
+		e.union(false)
+		e.int(0) // data
+		e.string(w.store.location(part.path))
+		e.string("PARQUET")
+		for i, v := range part.values {
+			e.union(v != nil)
+			if v != nil {
+				if err := encodeIcebergValue(&e, partTypes[i], v); err != nil {
+					return nil, fmt.Errorf("Partition value of %s: %v\n", spec.Fields[i].Name, err)
+				}
+			}
+		}
+		e.long(part.rows)
+		e.long(part.size)
+		records = append(records, e.Bytes())
+	}
+	tableSchema, _ := json.Marshal(icebergSchema{Type: "struct", Fields: w.schemaFields()})
+	specFields, _ := json.Marshal(spec.Fields)
+	meta := map[string]string{
+		"schema":            string(tableSchema),
+		"schema-id":         "0",
+		"partition-spec":    string(specFields),
+		"partition-spec-id": strconv.Itoa(spec.SpecID),
+		"format-version":    "2",
+		"content":           "data",
+	}
+	if w.current != nil {
+		meta["schema-id"] = strconv.Itoa(w.current.CurrentSchemaID)
+	}
+	return writeAvroFile(schema, meta, records), nil
+}
+
+func (w *icebergWriter) schemaFields() []icebergField {
+	var fields []icebergField
+	for i, c := range w.files.cols {
+		fields = append(fields, icebergField{ID: w.files.fieldIDs[i], Name: c.Name, Type: icebergType(c)})
+	}
+	return fields
+}
+
+// readManifestList returns the manifests of snapshot id, which the next
+// snapshot keeps.
+func (w *icebergWriter) readManifestList(meta *icebergMetadata, id int64) ([]icebergManifestFile, error) {
+	for _, s := range meta.Snapshots {
+		if s.SnapshotID != id {
+			continue
+		}
+		rel, err := w.relative(meta, s.ManifestList)
+		if err != nil {
+			return nil, err
+		}
+		data, err := w.store.read(rel)
+		if err != nil {
+			return nil, fmt.Errorf("Could not read manifest list %s: %v\n", s.ManifestList, err)
+		}
+		f, err := readAvroFile(data)
+		if err != nil {
+			return nil, fmt.Errorf("Could not read manifest list %s: %v\n", s.ManifestList, err)
+		}
+		manifests := []icebergManifestFile{}
+		for _, r := range f.records {
+			manifests = append(manifests, manifestFileOf(r.(map[string]any)))
+		}
+		return manifests, nil
+	}
+	return nil, fmt.Errorf("Iceberg table %s has no snapshot %d\n", w.table, id)
+}
+
+// relative returns the path in the table of location, a file the metadata
+// records by its full location.
+func (w *icebergWriter) relative(meta *icebergMetadata, location string) (string, error) {
+	for _, root := range []string{strings.TrimSuffix(meta.Location, "/"), strings.TrimSuffix(w.store.location(""), "/")} {
+		for _, loc := range []string{location, strings.TrimPrefix(location, "file:"), strings.TrimPrefix(location, "file://")} {
+			if rel, ok := strings.CutPrefix(loc, root+"/"); ok && root != "" {
+				return rel, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("Iceberg file %s is outside table %s\n", location, w.table)
+}
+
+// Abort removes the data files written so far; nothing was committed yet.
+func (w *icebergWriter) Abort() {
+	if !w.done {
+		w.done = true
+		w.files.cleanup()
+		w.store.Close()
+	}
+}
+
+func (m *icebergMetadata) schema() (icebergSchema, bool) {
+	for _, s := range m.Schemas {
+		if s.SchemaID == m.CurrentSchemaID {
+			return s, true
+		}
+	}
+	return icebergSchema{}, false
+}
+
+func (m *icebergMetadata) spec() (icebergSpec, bool) {
+	for _, s := range m.PartitionSpecs {
+		if s.SpecID == m.DefaultSpecID {
+			return s, true
+		}
+	}
+	return icebergSpec{SpecID: m.DefaultSpecID}, false
+}
+
+// readIcebergMetadata returns the latest metadata of the table, or nil for
+// a new table.
+func readIcebergMetadata(store tableStore) (*icebergMetadata, error) {
+	names, err := store.list("metadata")
+	if err != nil {
+		return nil, fmt.Errorf("Could not list the metadata of iceberg table %s: %v\n", store.location(""), err)
+	}
+	version, catalog := int64(0), false
+	for _, name := range names {
+		n, ok := strings.CutSuffix(name, ".metadata.json")
+		if !ok {
+			continue
+		}
+		if v, err := strconv.ParseInt(strings.TrimPrefix(n, "v"), 10, 64); err == nil && strings.HasPrefix(n, "v") {
+			version = max(version, v)
+		} else {
+			catalog = true
+		}
+	}
+	if version == 0 {
+		if catalog {
+			return nil, fmt.Errorf("Iceberg table %s is tracked by a catalog, which iceberg:// does not update\n", store.location(""))
+		}
+		return nil, nil
+	}
+	data, err := store.read(fmt.Sprintf("metadata/v%d.metadata.json", version))
+	if err != nil {
+		return nil, err
+	}
+	m := &icebergMetadata{version: version}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&m.raw); err != nil {
+		return nil, fmt.Errorf("Could not parse the metadata of iceberg table %s: %v\n", store.location(""), err)
+	}
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, fmt.Errorf("Could not parse the metadata of iceberg table %s: %v\n", store.location(""), err)
+	}
+	return m, nil
+}
+
+// appendJSON appends v to the array under key of a metadata object.
+func appendJSON(meta map[string]any, key string, v any) {
+	list, _ := meta[key].([]any)
+	meta[key] = append(list, v)
+}
+
+// icebergType maps a column to its Iceberg primitive type.
+func icebergType(c column) string {
+	switch c.kind() {
+	case kindInt:
+		return "long"
+	case kindFloat:
+		return "double"
+	case kindBool:
+		return "boolean"
+	case kindDecimal:
+		return fmt.Sprintf("decimal(%d, %d)", c.Precision, c.Scale)
+	case kindDate:
+		return "date"
+	case kindTimestamp:
+		return "timestamptz"
+	case kindBytes:
+		return "binary"
+	default:
+		return "string"
+	}
+}
+
+// icebergPartitionType returns the Avro type of a partition value of c in a
+// manifest. Decimal partitions are not written.
+func icebergPartitionType(c column) (string, error) {
+	switch c.kind() {
+	case kindInt:
+		return `"long"`, nil
+	case kindFloat:
+		return `"double"`, nil
+	case kindBool:
+		return `"boolean"`, nil
+	case kindDate:
+		return `{"type":"int","logicalType":"date"}`, nil
+	case kindTimestamp:
+		return `{"type":"long","logicalType":"timestamp-micros","adjust-to-utc":true}`, nil
+	case kindBytes:
+		return `"bytes"`, nil
+	case kindDecimal:
+		return "", fmt.Errorf("Iceberg tables cannot be partitioned on decimal column %s\n", c.Name)
+	}
+	return `"string"`, nil
+}
+
+// encodeIcebergValue writes a partition value of Avro type typ.
+func encodeIcebergValue(e *avroEncoder, typ string, v any) error {
+	switch {
+	case typ == `"long"`:
+		n, err := asInt64(v)
+		if err != nil {
+			return err
+		}
+		e.long(n)
+	case typ == `"double"`:
+		f, err := asFloat64(v)
+		if err != nil {
+			return err
+		}
+		e.double(f)
+	case typ == `"boolean"`:
+		b, err := asBool(v)
+		if err != nil {
+			return err
+		}
+		e.bool(b)
+	case typ == `"bytes"`:
+		b, ok := v.([]byte)
+		if !ok {
+			b = []byte(formatValue(v))
+		}
+		e.bytes(b)
+	case strings.Contains(typ, `"date"`):
+		t, err := asTime(v)
+		if err != nil {
+			return err
+		}
+		e.int(int32(time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC).Unix() / 86400))
+	case strings.Contains(typ, "timestamp-micros"):
+		t, err := asTime(v)
+		if err != nil {
+			return err
+		}
+		e.long(t.UnixMicro())
+	default:
+		e.string(formatValue(v))
+	}
+	return nil
+}
+
+// icebergManifestFile is an entry of a manifest list.
+type icebergManifestFile struct {
+	path                                    string
+	length                                  int64
+	specID                                  int
+	content                                 int64
+	seq, minSeq, snapshotID                 int64
+	addedFiles, existingFiles, deletedFiles int64
+	addedRows, existingRows, deletedRows    int64
+	// partitions are the partition summaries of an entry read from the
+	// current list, kept as decoded; the entries this tool adds have none.
+	partitions []any
+}
+
+// manifestFileOf reads an entry of a manifest list.
+func manifestFileOf(r map[string]any) icebergManifestFile {
+	long := func(name string) int64 {
+		n, _ := r[name].(int64)
+		return n
+	}
+	path, _ := r["manifest_path"].(string)
+	partitions, _ := r["partitions"].([]any)
+	return icebergManifestFile{
+		path:          path,
+		length:        long("manifest_length"),
+		specID:        int(long("partition_spec_id")),
+		content:       long("content"),
+		seq:           long("sequence_number"),
+		minSeq:        long("min_sequence_number"),
+		snapshotID:    long("added_snapshot_id"),
+		addedFiles:    long("added_files_count"),
+		existingFiles: long("existing_files_count"),
+		deletedFiles:  long("deleted_files_count"),
+		addedRows:     long("added_rows_count"),
+		existingRows:  long("existing_rows_count"),
+		deletedRows:   long("deleted_rows_count"),
+		partitions:    partitions,
+	}
+}
+
+func (m icebergManifestFile) encode() []byte {
+	var e avroEncoder
+	e.string(m.path)
+	e.long(m.length)
+	e.int(int32(m.specID))
+	e.int(int32(m.content))
+	e.long(m.seq)
+	e.long(m.minSeq)
+	e.long(m.snapshotID)
+	e.int(int32(m.addedFiles))
+	e.int(int32(m.existingFiles))
+	e.int(int32(m.deletedFiles))
+	e.long(m.addedRows)
+	e.long(m.existingRows)
+	e.long(m.deletedRows)
+	e.union(m.partitions != nil)
+	if m.partitions != nil {
+		e.long(int64(len(m.partitions)))
+		for _, p := range m.partitions {
+			s, _ := p.(map[string]any)
+			containsNull, _ := s["contains_null"].(bool)
+			e.bool(containsNull)
+			nan, ok := s["contains_nan"].(bool)
+			e.union(ok)
+			if ok {
+				e.bool(nan)
+			}
+			for _, bound := range []string{"lower_bound", "upper_bound"} {
+				b, ok := s[bound].([]byte)
+				e.union(ok)
+				if ok {
+					e.bytes(b)
+				}
+			}
+		}
+		e.long(0)
+	}
+	return e.Bytes()
+}
+
+// newSnapshotID returns a random positive snapshot id.
+func newSnapshotID() int64 {
+	var b [8]byte
+	rand.Read(b[:])
+	return int64(binary.BigEndian.Uint64(b[:]) >> 1)
+}
+
+// icebergManifestSchema is the Avro schema of a manifest of data files, with
+// the partition fields to fill in.
+const icebergManifestSchema = `{"type":"record","name":"manifest_entry","fields":[` +
+	`{"name":"status","type":"int","field-id":0},` +
+	`{"name":"snapshot_id","type":["null","long"],"default":null,"field-id":1},` +
+	`{"name":"sequence_number","type":["null","long"],"default":null,"field-id":3},` +
+	`{"name":"file_sequence_number","type":["null","long"],"default":null,"field-id":4},` +
+	`{"name":"data_file","type":{"type":"record","name":"r2","fields":[` +
+	`{"name":"content","type":"int","field-id":134},` +
+	`{"name":"file_path","type":"string","field-id":100},` +
+	`{"name":"file_format","type":"string","field-id":101},` +
+	`{"name":"partition","type":{"type":"record","name":"r102","fields":[%s]},"field-id":102},` +
+	`{"name":"record_count","type":"long","field-id":103},` +
+	`{"name":"file_size_in_bytes","type":"long","field-id":104}` +
+	`]},"field-id":2}]}`
+
+// icebergManifestListSchema is the Avro schema of a manifest list.
+const icebergManifestListSchema = `{"type":"record","name":"manifest_file","fields":[` +
+	`{"name":"manifest_path","type":"string","field-id":500},` +
+	`{"name":"manifest_length","type":"long","field-id":501},` +
+	`{"name":"partition_spec_id","type":"int","field-id":502},` +
+	`{"name":"content","type":"int","field-id":517},` +
+	`{"name":"sequence_number","type":"long","field-id":515},` +
+	`{"name":"min_sequence_number","type":"long","field-id":516},` +
+	`{"name":"added_snapshot_id","type":"long","field-id":503},` +
+	`{"name":"added_files_count","type":"int","field-id":504},` +
+	`{"name":"existing_files_count","type":"int","field-id":505},` +
+	`{"name":"deleted_files_count","type":"int","field-id":506},` +
+	`{"name":"added_rows_count","type":"long","field-id":512},` +
+	`{"name":"existing_rows_count","type":"long","field-id":513},` +
+	`{"name":"deleted_rows_count","type":"long","field-id":514},` +
+	`{"name":"partitions","type":["null",{"type":"array","items":{"type":"record","name":"r508","fields":[` +
+	`{"name":"contains_null","type":"boolean","field-id":509},` +
+	`{"name":"contains_nan","type":["null","boolean"],"default":null,"field-id":518},` +
+	`{"name":"lower_bound","type":["null","bytes"],"default":null,"field-id":510},` +
+	`{"name":"upper_bound","type":["null","bytes"],"default":null,"field-id":511}` +
+	`]},"element-id":508}],"default":null,"field-id":507}]}`
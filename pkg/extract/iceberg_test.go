@@ -0,0 +1,225 @@
+package extract
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/apache/arrow-go/v18/arrow/memory"
+	"github.com/apache/arrow-go/v18/parquet"
+	"github.com/apache/arrow-go/v18/parquet/pqarrow"
+)
+
+func writeIceberg(t *testing.T, w *icebergWriter, cols []column, rows [][]any) error {
+	t.Helper()
+	if err := w.WriteHeader(cols); err != nil {
+		w.Abort()
+		return err
+	}
+	for _, row := range rows {
+		if err := w.WriteRow(row); err != nil {
+			w.Abort()
+			return err
+		}
+	}
+	return w.Close()
+}
+
+// readIcebergAvro decodes the Avro file of the table at location.
+func readIcebergAvro(t *testing.T, location string) *avroFile {
+	t.Helper()
+	data, err := os.ReadFile(location)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := readAvroFile(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func TestIcebergCommit(t *testing.T) {
+	table := filepath.Join(t.TempDir(), "orders")
+	cols := []column{{Name: "id", DBType: "BIGINT"}, {Name: "region", DBType: "NVARCHAR"}, {Name: "amount", DBType: "FLOAT"}}
+	o := icebergOptions{PartitionBy: []string{"region"}}
+	rows := [][]any{{int64(1), "EU", 1.5}, {int64(2), "US", 2.5}, {int64(3), "EU", 3.5}, {int64(4), nil, 4.5}}
+	open := func() *icebergWriter {
+		w, err := newIcebergWriter(table, o)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return w
+	}
+	if err := writeIceberg(t, open(), cols, rows); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeIceberg(t, open(), cols, rows[:1]); err != nil {
+		t.Fatal(err)
+	}
+
+	if hint, _ := os.ReadFile(filepath.Join(table, "metadata", "version-hint.text")); string(hint) != "2" {
+		t.Errorf("version hint %q", hint)
+	}
+	meta, err := readIcebergMetadata(localTable(table))
+	if err != nil || meta == nil {
+		t.Fatalf("metadata %v, %v", meta, err)
+	}
+	schema, _ := meta.schema()
+	spec, _ := meta.spec()
+	if meta.FormatVersion != 2 || meta.Location != table || meta.LastSequenceNumber != 2 || len(meta.Snapshots) != 2 {
+		t.Errorf("metadata %+v", meta)
+	}
+	if len(schema.Fields) != 3 || schema.Fields[0].Type != "long" || schema.Fields[1].Type != "string" || schema.Fields[2].ID != 3 {
+		t.Errorf("schema %+v", schema.Fields)
+	}
+	if len(spec.Fields) != 1 || spec.Fields[0].SourceID != 2 || spec.Fields[0].Transform != "identity" || spec.Fields[0].FieldID != 1000 {
+		t.Errorf("partition spec %+v", spec)
+	}
+	var raw struct {
+		SnapshotLog []any `json:"snapshot-log"`
+		MetadataLog []struct {
+			File string `json:"metadata-file"`
+		} `json:"metadata-log"`
+		Refs map[string]struct {
+			SnapshotID int64 `json:"snapshot-id"`
+		} `json:"refs"`
+	}
+	data, _ := os.ReadFile(filepath.Join(table, "metadata", "v2.metadata.json"))
+	json.Unmarshal(data, &raw)
+	if len(raw.SnapshotLog) != 2 || len(raw.MetadataLog) != 1 || raw.MetadataLog[0].File != filepath.Join(table, "metadata", "v1.metadata.json") {
+		t.Errorf("logs %+v", raw)
+	}
+	if raw.Refs["main"].SnapshotID != *meta.CurrentSnapshotID || *meta.CurrentSnapshotID != meta.Snapshots[1].SnapshotID {
+		t.Errorf("main branch %+v, current snapshot %d", raw.Refs, *meta.CurrentSnapshotID)
+	}
+
+	// the second snapshot keeps the first one's manifest and adds its own
+	list := readIcebergAvro(t, meta.Snapshots[1].ManifestList)
+	if len(list.records) != 2 || list.meta["parent-snapshot-id"] == "null" {
+		t.Fatalf("manifest list %+v", list)
+	}
+	var files []string
+	records := map[string]int64{}
+	for i, r := range list.records {
+		m := manifestFileOf(r.(map[string]any))
+		if m.seq != int64(i+1) || m.minSeq != int64(i+1) || m.snapshotID != meta.Snapshots[i].SnapshotID {
+			t.Errorf("manifest %d: %+v", i, m)
+		}
+		manifest := readIcebergAvro(t, m.path)
+		if int64(len(manifest.records)) != m.addedFiles || manifest.meta["partition-spec-id"] != "0" {
+			t.Errorf("manifest %d has %d entries, want %d", i, len(manifest.records), m.addedFiles)
+		}
+		for _, e := range manifest.records {
+			entry := e.(map[string]any)
+			df := entry["data_file"].(map[string]any)
+			if entry["status"] != int64(1) || entry["snapshot_id"] != nil || df["file_format"] != "PARQUET" {
+				t.Errorf("entry %v", entry)
+			}
+			path := df["file_path"].(string)
+			info, err := os.Stat(path)
+			if err != nil || info.Size() != df["file_size_in_bytes"] {
+				t.Errorf("data file %s: %v", path, err)
+			}
+			if i == 0 {
+				region, _ := df["partition"].(map[string]any)["region"].(string)
+				records[region] = df["record_count"].(int64)
+			}
+			files = append(files, path)
+		}
+	}
+	if records["EU"] != 2 || records["US"] != 1 || records[""] != 1 {
+		t.Errorf("records per partition %v", records)
+	}
+	sort.Strings(files)
+	if !strings.HasPrefix(files[0], filepath.Join(table, "data", "region=EU")) || !strings.Contains(files[len(files)-1], "region=null") {
+		t.Errorf("data files %v", files)
+	}
+
+	// data files hold the partition column too, with the table's field ids
+	f, err := os.Open(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	tbl, err := pqarrow.ReadTable(context.Background(), f, parquet.NewReaderProperties(memory.DefaultAllocator), pqarrow.ArrowReadProperties{}, memory.DefaultAllocator)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tbl.Release()
+	for i, field := range tbl.Schema().Fields() {
+		if id, _ := field.Metadata.GetValue("PARQUET:field_id"); id != []string{"1", "2", "3"}[i] {
+			t.Errorf("field %s has id %q", field.Name, id)
+		}
+	}
+}
+
+func TestIcebergRejects(t *testing.T) {
+	table := filepath.Join(t.TempDir(), "orders")
+	cols := []column{{Name: "id", DBType: "BIGINT"}, {Name: "region", DBType: "NVARCHAR"}}
+	write := func(o icebergOptions, cols []column, rows [][]any) error {
+		w, err := newIcebergWriter(table, o)
+		if err != nil {
+			return err
+		}
+		return writeIceberg(t, w, cols, rows)
+	}
+	if err := write(icebergOptions{}, cols, [][]any{{int64(1), "EU"}}); err != nil {
+		t.Fatal(err)
+	}
+	files := func() int {
+		n := 0
+		filepath.Walk(table, func(path string, info os.FileInfo, err error) error {
+			if strings.HasSuffix(path, ".parquet") || strings.HasSuffix(path, ".avro") {
+				n++
+			}
+			return nil
+		})
+		return n
+	}
+	before := files()
+
+	for _, tc := range []struct {
+		name string
+		o    icebergOptions
+		cols []column
+		want string
+	}{
+		{"schema", icebergOptions{}, []column{{Name: "id", DBType: "NVARCHAR"}, {Name: "region", DBType: "NVARCHAR"}}, "column 1"},
+		{"partitioning", icebergOptions{PartitionBy: []string{"region"}}, cols, "partitioned"},
+		{"missing partition column", icebergOptions{PartitionBy: []string{"missing"}}, cols, "not in the query result"},
+		{"decimal partition", icebergOptions{PartitionBy: []string{"amount"}}, []column{{Name: "amount", DBType: "DECIMAL", Precision: 10, Scale: 2}}, "decimal"},
+	} {
+		if err := write(tc.o, tc.cols, [][]any{{int64(1), "EU"}}); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: got %v, want %q", tc.name, err, tc.want)
+		}
+	}
+
+	w, err := newIcebergWriter(table, icebergOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.WriteHeader(cols)
+	w.WriteRow([]any{int64(2), "US"})
+	w.Abort()
+	if n := files(); n != before {
+		t.Errorf("rejected and aborted appends left %d files, want %d", n, before)
+	}
+	if meta, _ := readIcebergMetadata(localTable(table)); meta.version != 1 {
+		t.Errorf("rejected appends committed version %d", meta.version)
+	}
+
+	// a table a catalog tracks names its metadata files differently
+	catalog := filepath.Join(t.TempDir(), "tracked")
+	os.MkdirAll(filepath.Join(catalog, "metadata"), 0o755)
+	os.WriteFile(filepath.Join(catalog, "metadata", "00001-0c1a.metadata.json"), []byte("{}"), 0o644)
+	if w, err := newIcebergWriter(catalog, icebergOptions{}); err != nil {
+		t.Fatal(err)
+	} else if err := w.WriteHeader(cols); err == nil || !strings.Contains(err.Error(), "catalog") {
+		t.Errorf("appended to a catalog table: %v", err)
+	}
+}
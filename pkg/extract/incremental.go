@@ -0,0 +1,267 @@
+package extract
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// incrementalOptions extracts only the rows past the watermark: the largest
+// value of Column the last successful run wrote, kept in StateFile between
+// runs. Initial is the watermark of the first run; without it the first run
+// has none and should extract everything.
+type incrementalOptions struct {
+	Column    string `yaml:"column"`
+	StateFile string `yaml:"state_file"`
+	Initial   string `yaml:"initial"`
+}
+
+func (o incrementalOptions) enabled() bool {
+	return o != incrementalOptions{}
+}
+
+// watermarkEntry is the watermark of one job in a state file. Encoding is
+// "hex" for a binary watermark, such as a rowversion, kept as a 0x literal.
+type watermarkEntry struct {
+	Column    string    `json:"column"`
+	Watermark string    `json:"watermark"`
+	Encoding  string    `json:"encoding,omitempty"`
+	Updated   time.Time `json:"updated"`
+	RunID     string    `json:"run_id,omitempty"`
+}
+
+// watermarkState is a state file, holding the watermarks of the jobs sharing
+// it by job name.
+type watermarkState struct {
+	Jobs map[string]watermarkEntry `json:"jobs"`
+}
+
+// watermarkMu serializes the updates of state files shared by jobs.
+var watermarkMu sync.Mutex
+
+func validateIncremental(c *config) error {
+	for _, e := range c.Extracts {
+		if !e.Incremental.enabled() {
+			continue
+		}
+		if e.Incremental.Column == "" || e.Incremental.StateFile == "" {
+			return fmt.Errorf("The incremental settings of extract %s need a column and a state_file\n", e.Name)
+		}
+		if _, ok := e.Params["watermark"]; ok {
+			return fmt.Errorf("Extract %s is incremental, so it cannot have a param named watermark\n", e.Name)
+		}
+	}
+	return nil
+}
+
+// readWatermarks reads the state file at path. A missing file holds no
+// watermarks.
+func readWatermarks(path string, keys *keyring) (watermarkState, error) {
+	s := watermarkState{Jobs: map[string]watermarkEntry{}}
+	data, err := keys.readState(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	var plain *plaintextStateError
+	if errors.As(err, &plain) {
+		return s, err
+	}
+	if err != nil {
+		return s, fmt.Errorf("Could not read state file %s: %v\n", path, err)
+	}
+	if err := json.Unmarshal(data, &s); err != nil {
+		return s, fmt.Errorf("Could not parse state file %s: %v\n", path, err)
+	}
+	if s.Jobs == nil {
+		s.Jobs = map[string]watermarkEntry{}
+	}
+	return s, nil
+}
+
+// watermark returns the watermark of the incremental job writing outFile:
+// the one saved by its last successful run, else its initial one. ok is
+// false when the job is not incremental or has no watermark yet.
+func (c *config) watermark(outFile string) (value string, ok bool, err error) {
+	entry, ok, err := c.watermarkEntry(outFile)
+	return entry.Watermark, ok, err
+}
+
+// watermarkEntry is watermark with the encoding of the saved value.
+func (c *config) watermarkEntry(outFile string) (watermarkEntry, bool, error) {
+	e, _ := c.extract(outFile)
+	if !e.Incremental.enabled() {
+		return watermarkEntry{}, false, nil
+	}
+	s, err := readWatermarks(e.Incremental.StateFile, c.keys)
+	if err != nil {
+		return watermarkEntry{}, false, err
+	}
+	if entry, found := s.Jobs[e.Name]; found && entry.Column == e.Incremental.Column {
+		return entry, true, nil
+	}
+	return watermarkEntry{Watermark: e.Incremental.Initial}, e.Incremental.Initial != "", nil
+}
+
+// watermarkArg returns the @watermark argument of the incremental job
+// writing outFile on SQL Server, the driver binding named parameters: its
+// watermark, or NULL before it has one. A binary watermark is bound as bytes.
+func (c *config) watermarkArg(outFile string) (any, bool) {
+	e, _ := c.extract(outFile)
+	if !e.Incremental.enabled() {
+		return nil, false
+	}
+	if d, err := c.sourceDriver(); err != nil || d.name != "sqlserver" {
+		return nil, false
+	}
+	// exportData reports an unreadable state file before the query runs
+	entry, ok, _ := c.watermarkEntry(outFile)
+	if !ok {
+		return sql.Named("watermark", nil), true
+	}
+	if entry.Encoding == "hex" {
+		if b, err := hex.DecodeString(strings.TrimPrefix(entry.Watermark, "0x")); err == nil {
+			return sql.Named("watermark", b), true
+		}
+	}
+	return sql.Named("watermark", entry.Watermark), true
+}
+
+// watermarkTracker follows the largest value of the watermark column
+// among the rows a job writes, as the query returned them. NULLs are
+// skipped. Decimals compare by value, and binary values, such as
+// rowversions, as unsigned big-endian numbers.
+type watermarkTracker struct {
+	column int
+	kind   valueKind
+	max    any
+}
+
+// newWatermarkTracker returns the tracker of the incremental job writing
+// outFile, or nil if it is not incremental. cols are the columns of the
+// query result, before any transform; a watermark column missing from them
+// is an error.
+func (c *config) newWatermarkTracker(outFile string, cols []column) (*watermarkTracker, error) {
+	e, _ := c.extract(outFile)
+	if !e.Incremental.enabled() {
+		return nil, nil
+	}
+	i := slices.IndexFunc(cols, func(col column) bool { return col.Name == e.Incremental.Column })
+	if i < 0 {
+		return nil, fmt.Errorf("The result of %s has no watermark column %s\n", outFile, e.Incremental.Column)
+	}
+	w := &watermarkTracker{column: i, kind: cols[i].kind()}
+	switch cols[i].DBType {
+	case "DECIMAL", "NUMERIC", "MONEY", "SMALLMONEY":
+		w.kind = kindDecimal
+	case "ROWVERSION", "TIMESTAMP":
+		// TIMESTAMP is a rowversion only on SQL Server
+		if d, err := c.sourceDriver(); err == nil && d.name == "sqlserver" && cols[i].ScanType == reflect.TypeOf([]byte(nil)) {
+			w.kind = kindBytes
+		}
+	}
+	return w, nil
+}
+
+// value returns the watermark column of a scanned row, before the
+// transforms change it.
+func (w *watermarkTracker) value(row []any) any {
+	if w == nil {
+		return nil
+	}
+	return row[w.column]
+}
+
+// advance takes v, the value of a written row, into the watermark.
+func (w *watermarkTracker) advance(v any) {
+	if w == nil || v == nil {
+		return
+	}
+	if w.max == nil || w.compare(v, w.max) > 0 {
+		w.max = v
+	}
+}
+
+func (w *watermarkTracker) compare(a, b any) int {
+	switch w.kind {
+	case kindDecimal:
+		x, okA := new(big.Rat).SetString(formatValue(a))
+		y, okB := new(big.Rat).SetString(formatValue(b))
+		if okA && okB {
+			return x.Cmp(y)
+		}
+	case kindBytes:
+		x, okA := a.([]byte)
+		y, okB := b.([]byte)
+		if okA && okB {
+			// the shorter value is padded with leading zeros
+			if n := len(y) - len(x); n > 0 {
+				x = append(make([]byte, n), x...)
+			} else if n < 0 {
+				y = append(make([]byte, -n), y...)
+			}
+			return bytes.Compare(x, y)
+		}
+	}
+	return compareValues(a, b)
+}
+
+// encoded returns the largest value as it is saved, with its encoding.
+func (w *watermarkTracker) encoded() (value, encoding string) {
+	if b, ok := w.max.([]byte); ok && w.kind == kindBytes {
+		return "0x" + strings.ToUpper(hex.EncodeToString(b)), "hex"
+	}
+	return formatValue(w.max), ""
+}
+
+// saveWatermark records the largest watermark value the succeeded job
+// writing outFile wrote, for its next run. A job that wrote no rows keeps its
+// watermark.
+func (c *config) saveWatermark(outFile string, w *watermarkTracker) error {
+	if w == nil || w.max == nil {
+		return nil
+	}
+	e, _ := c.extract(outFile)
+	path := e.Incremental.StateFile
+	watermarkMu.Lock()
+	defer watermarkMu.Unlock()
+	s, err := readWatermarks(path, c.keys)
+	if err != nil {
+		return err
+	}
+	value, encoding := w.encoded()
+	s.Jobs[e.Name] = watermarkEntry{Column: e.Incremental.Column, Watermark: value, Encoding: encoding, Updated: time.Now().UTC(), RunID: c.runID}
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	if data, err = c.keys.sealState(data); err != nil {
+		return fmt.Errorf("Could not encrypt state file %s: %v\n", path, err)
+	}
+	if dir := filepath.Dir(path); dir != "" {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return fmt.Errorf("Could not create state directory %s: %v\n", dir, err)
+		}
+	}
+	// written through a temporary file so a crash cannot lose the watermark
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("Could not write state file %s: %v\n", path, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("Could not write state file %s: %v\n", path, err)
+	}
+	log.Printf("Watermark of %s is now %s\n", outFile, value)
+	return nil
+}
@@ -0,0 +1,142 @@
+package extract
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestExportDataIncremental(t *testing.T) {
+	registerFake("changed", &fakeQuery{sets: []*fakeResult{numbersResult(3)}})
+	dir := t.TempDir()
+	outFile := filepath.Join(dir, "changed.csv")
+	state := filepath.Join(dir, "state", "watermarks.json")
+	c := &config{Extracts: []extract{{Name: "changed", OutFile: outFile, Incremental: incrementalOptions{Column: "id", StateFile: state, Initial: "0"}}}}
+	if err := validateIncremental(c); err != nil {
+		t.Fatal(err)
+	}
+	if got := c.queryArgs(outFile); !reflect.DeepEqual(got, []any{sql.Named("watermark", "0")}) {
+		t.Errorf("first run args %v", got)
+	}
+	if _, err := exportFake(t, c, "changed", outFile); err != nil {
+		t.Fatal(err)
+	}
+	if w, ok, err := c.watermark(outFile); w != "3" || !ok || err != nil {
+		t.Errorf("watermark %q, %v, %v", w, ok, err)
+	}
+	q, err := renderQuery(c, outFile, "SELECT * FROM t WHERE id > {{ .Watermark }}")
+	if err != nil || q != "SELECT * FROM t WHERE id > @watermark" {
+		t.Errorf("rendered %q, %v", q, err)
+	}
+	// other drivers take it by position, and outfiles get the value
+	c.Driver, c.bound = "postgres", &boundArgs{args: map[string][]boundArg{}}
+	q, _ = renderQuery(c, outFile, "SELECT * FROM t WHERE {{ .Watermark }}::int IS NULL OR id > {{ .Watermark }}")
+	if want := "SELECT * FROM t WHERE $1::int IS NULL OR id > $1"; q != want {
+		t.Errorf("rendered %q, want %q", q, want)
+	}
+	if got := c.jobArgs(outFile); !reflect.DeepEqual(got, []any{"3"}) {
+		t.Errorf("postgres args %v", got)
+	}
+	if tmpl, _ := newRunTemplate(c, outFile); tmpl.Watermark() != "3" {
+		t.Errorf("outfile watermark %q", tmpl.Watermark())
+	}
+	c.Driver = ""
+
+	// limited runs do not move the watermark, nor do empty ones
+	registerFake("unchanged", &fakeQuery{sets: []*fakeResult{numbersResult(0)}})
+	c.limit = 1
+	registerFake("SELECT TOP (1) * FROM (more) AS src", &fakeQuery{sets: []*fakeResult{numbersResult(5)}})
+	if _, err := exportFake(t, c, "more", outFile); err != nil {
+		t.Fatal(err)
+	}
+	c.limit = 0
+	if _, err := exportFake(t, c, "unchanged", outFile); err != nil {
+		t.Fatal(err)
+	}
+	if w, _, _ := c.watermark(outFile); w != "3" {
+		t.Errorf("watermark moved to %q", w)
+	}
+
+	// a new column starts over from the initial watermark
+	c.Extracts[0].Incremental.Column = "name"
+	if w, _, _ := c.watermark(outFile); w != "0" {
+		t.Errorf("watermark of a new column %q", w)
+	}
+	c.Extracts[0].Incremental.Column = "updated_at"
+	if _, err := exportFake(t, c, "changed", outFile); err == nil {
+		t.Error("tracked a missing column")
+	}
+}
+
+func TestValidateIncremental(t *testing.T) {
+	c := &config{Extracts: []extract{{Name: "orders", Incremental: incrementalOptions{Column: "updated_at"}}}}
+	if err := validateIncremental(c); err == nil {
+		t.Error("an incremental extract without a state file is valid")
+	}
+	c.Extracts[0].Incremental.StateFile = "state.json"
+	c.Extracts[0].Params = map[string]string{"watermark": "x"}
+	if err := validateIncremental(c); err == nil {
+		t.Error("a param named watermark is valid")
+	}
+}
+
+func TestWatermarkTracker(t *testing.T) {
+	bytesType := reflect.TypeOf([]byte(nil))
+	for _, tc := range []struct {
+		name   string
+		col    column
+		values []any
+		want   string
+	}{
+		{"integers", column{DBType: "BIGINT"}, []any{int64(9), nil, int64(10)}, "10"},
+		{"decimals by value", column{DBType: "DECIMAL", Precision: 10, Scale: 2, ScanType: bytesType}, []any{[]byte("9.50"), []byte("10.25"), []byte("2.00")}, "10.25"},
+		{"wide decimals", column{DBType: "NUMERIC", Precision: 50, ScanType: bytesType}, []any{[]byte("99999999999999999999999999999999999999999"), []byte("100000000000000000000000000000000000000000")}, "100000000000000000000000000000000000000000"},
+		{"rowversions", column{DBType: "ROWVERSION", ScanType: bytesType}, []any{[]byte{0, 0, 0, 0, 0, 0, 0xff, 0x01}, []byte{0, 0, 0, 0, 0, 1, 0, 0}, []byte{0, 0, 0, 0, 0, 0, 0, 0x80}}, "0x0000000000010000"},
+		{"binary of different widths", column{DBType: "VARBINARY", ScanType: bytesType}, []any{[]byte{0x02, 0x00}, []byte{0xff}}, "0x0200"},
+	} {
+		w, err := (&config{Extracts: []extract{{OutFile: "out.csv", Incremental: incrementalOptions{Column: "v", StateFile: "s.json"}}}}).
+			newWatermarkTracker("out.csv", []column{{Name: "id"}, withName(tc.col, "v")})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, v := range tc.values {
+			w.advance(w.value([]any{nil, v}))
+		}
+		if got, _ := w.encoded(); got != tc.want {
+			t.Errorf("%s: watermark %s, want %s", tc.name, got, tc.want)
+		}
+	}
+}
+
+func withName(c column, name string) column {
+	c.Name = name
+	return c
+}
+
+func TestExportDataBinaryWatermark(t *testing.T) {
+	registerFake("versions", &fakeQuery{sets: []*fakeResult{{
+		columns: []fakeColumn{{name: "rv", dbType: "ROWVERSION", scanType: reflect.TypeOf([]byte(nil))}},
+		rows:    2,
+		value: func(row, _ int) driver.Value {
+			return []byte{0, 0, 0, 0, 0, 0, byte(row + 1), 0xff}
+		},
+	}}})
+	dir := t.TempDir()
+	outFile := filepath.Join(dir, "versions.csv")
+	c := &config{
+		Extracts: []extract{{Name: "versions", OutFile: outFile, Incremental: incrementalOptions{Column: "rv", StateFile: filepath.Join(dir, "state.json")}}},
+		// the written value is masked, the watermark follows the query's
+		Classification: classificationOptions{Columns: map[string]string{"rv": "internal"}, Default: map[string]string{"internal": "mask"}},
+	}
+	if _, err := exportFake(t, c, "versions", outFile); err != nil {
+		t.Fatal(err)
+	}
+	if w, ok, err := c.watermark(outFile); w != "0x00000000000002FF" || !ok || err != nil {
+		t.Errorf("watermark %q, %v, %v", w, ok, err)
+	}
+	if got := c.queryArgs(outFile); !reflect.DeepEqual(got, []any{sql.Named("watermark", []byte{0, 0, 0, 0, 0, 0, 2, 0xff})}) {
+		t.Errorf("args %v", got)
+	}
+}
@@ -0,0 +1,96 @@
+package extract
+
+import (
+	"io"
+	"strings"
+)
+
+// jsonOptions holds the settings specific to format: json.
+type jsonOptions struct {
+	// Nest lists column prefixes (e.g. "address_") whose columns are grouped
+	// into a nested object named after the prefix.
+	Nest []string `yaml:"nest"`
+}
+
+// jsonField is one key of an output object; it is either a column value or a
+// nested object of grouped columns.
+type jsonField struct {
+	key    string
+	column int
+	fields []jsonField
+}
+
+// jsonWriter writes the result set as a single JSON array of objects, each
+// encoded as a jsonlWriter encodes its lines.
+type jsonWriter struct {
+	*jsonlWriter
+	rows uint
+}
+
+func newJSONWriter(w io.Writer, nest []string) *jsonWriter {
+	return &jsonWriter{jsonlWriter: newJSONLWriter(w, nest)}
+}
+
+// WriteHeader builds the object layout from the column names and opens the array.
+func (j *jsonWriter) WriteHeader(cols []column) error {
+	if err := j.jsonlWriter.WriteHeader(cols); err != nil {
+		return err
+	}
+	_, err := j.w.WriteString("[")
+	return err
+}
+
+// jsonLayout returns the keys of the objects written for cols, grouping the
+// columns sharing one of the nest prefixes.
+func jsonLayout(cols []column, nest []string) []jsonField {
+	var fields []jsonField
+	nested := make(map[string]int)
+	for i, col := range columnNames(cols) {
+		prefix := prefixOf(nest, col)
+		if prefix == "" {
+			fields = append(fields, jsonField{key: col, column: i})
+			continue
+		}
+		key := strings.TrimRight(prefix, "_")
+		idx, ok := nested[key]
+		if !ok {
+			idx = len(fields)
+			nested[key] = idx
+			fields = append(fields, jsonField{key: key, column: -1})
+		}
+		fields[idx].fields = append(fields[idx].fields, jsonField{key: strings.TrimPrefix(col, prefix), column: i})
+	}
+	return fields
+}
+
+// prefixOf returns the longest of the nesting prefixes matching col.
+func prefixOf(nest []string, col string) string {
+	var match string
+	for _, p := range nest {
+		if strings.HasPrefix(col, p) && len(col) > len(p) && len(p) > len(match) {
+			match = p
+		}
+	}
+	return match
+}
+
+func (j *jsonWriter) WriteRow(values []any) error {
+	if j.rows > 0 {
+		if err := j.w.WriteByte(','); err != nil {
+			return err
+		}
+	}
+	j.rows++
+	if err := j.w.WriteByte('\n'); err != nil {
+		return err
+	}
+	return j.writeObject(j.fields, values)
+}
+
+// Close terminates the array and flushes the buffered output.
+func (j *jsonWriter) Close() error {
+	if _, err := j.w.WriteString("\n]\n"); err != nil {
+		return err
+	}
+	return j.w.Flush()
+}
@@ -0,0 +1,77 @@
+package extract
+
+import (
+	"bytes"
+	"encoding/json"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// typedJSON is the object written for the first row of typedResult: typed
+// columns keep their JSON types, the rest are strings.
+var typedJSON = map[string]any{
+	"id": json.Number("42"), "price": json.Number("2.5"), "amount": json.Number("12.34"), "active": true,
+	"day": "2026-10-15T00:00:00Z", "at": "2026-10-15T09:30:15.123456Z", "name": `Åsa "q"`, "data": "\x00\x01\x02�",
+}
+
+var nullJSON = map[string]any{"id": nil, "price": nil, "amount": nil, "active": nil, "day": nil, "at": nil, "name": nil, "data": nil}
+
+func decodeJSON(t *testing.T, data string, v any) {
+	t.Helper()
+	dec := json.NewDecoder(strings.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(v); err != nil {
+		t.Fatalf("%v in %q", err, data)
+	}
+}
+
+func TestExportDataJSON(t *testing.T) {
+	registerFake("typed", &fakeQuery{sets: []*fakeResult{typedResult()}})
+	got, err := exportFake(t, &config{Format: "json"}, "typed", filepath.Join(t.TempDir(), "out.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rows []map[string]any
+	decodeJSON(t, got, &rows)
+	if len(rows) != 2 || !reflect.DeepEqual(rows[0], typedJSON) || !reflect.DeepEqual(rows[1], nullJSON) {
+		t.Fatalf("got %v", rows)
+	}
+
+	got, err = exportFake(t, &config{Format: "jsonl"}, "typed", filepath.Join(t.TempDir(), "out.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(got, "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got %d lines, want 2", len(lines))
+	}
+	for i, want := range []map[string]any{typedJSON, nullJSON} {
+		var row map[string]any
+		decodeJSON(t, lines[i], &row)
+		if !reflect.DeepEqual(row, want) {
+			t.Errorf("line %d: got %v, want %v", i+1, row, want)
+		}
+	}
+}
+
+func TestJSONNesting(t *testing.T) {
+	var buf bytes.Buffer
+	w := newJSONWriter(&buf, []string{"address_", "address_geo_"})
+	cols := []column{{Name: "id", DBType: "INT"}, {Name: "address_city"}, {Name: "address_geo_lat", DBType: "FLOAT"}, {Name: "address_zip"}, {Name: "address_"}}
+	if err := w.WriteHeader(cols); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.WriteRow([]any{int64(1), "Paris", 48.85, nil, "x"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// the longest prefix wins, and a column named just the prefix is not nested
+	want := "[\n" + `{"id":1,"address":{"city":"Paris","zip":null},"address_geo":{"lat":48.85},"address_":"x"}` + "\n]\n"
+	if buf.String() != want {
+		t.Errorf("got %s, want %s", buf.String(), want)
+	}
+}
@@ -0,0 +1,105 @@
+package extract
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"math"
+	"strconv"
+)
+
+// jsonlWriter writes JSON Lines: one object per row, keyed by column name and
+// nested by prefix as jsonLayout lays them out. Integers, floats, decimals
+// and booleans are written as JSON numbers and booleans, NULL as null and
+// everything else as strings.
+type jsonlWriter struct {
+	w      *bufio.Writer
+	nest   []string
+	fields []jsonField
+	values *valueFormat
+	kinds  []valueKind
+}
+
+func newJSONLWriter(w io.Writer, nest []string) *jsonlWriter {
+	return &jsonlWriter{w: bufio.NewWriter(w), nest: nest}
+}
+
+func (j *jsonlWriter) WriteHeader(cols []column) error {
+	j.values.setColumns(cols)
+	j.fields = jsonLayout(cols, j.nest)
+	j.kinds = make([]valueKind, len(cols))
+	for i, col := range cols {
+		j.kinds[i] = col.kind()
+	}
+	return nil
+}
+
+func (j *jsonlWriter) WriteRow(values []any) error {
+	if err := j.writeObject(j.fields, values); err != nil {
+		return err
+	}
+	return j.w.WriteByte('\n')
+}
+
+func (j *jsonlWriter) writeObject(fields []jsonField, values []any) error {
+	j.w.WriteByte('{')
+	for i, f := range fields {
+		if i > 0 {
+			j.w.WriteByte(',')
+		}
+		key, err := json.Marshal(f.key)
+		if err != nil {
+			return err
+		}
+		j.w.Write(key)
+		j.w.WriteByte(':')
+		if f.column < 0 {
+			if err := j.writeObject(f.fields, values); err != nil {
+				return err
+			}
+			continue
+		}
+		value, err := j.value(f.column, values[f.column])
+		if err != nil {
+			return err
+		}
+		j.w.Write(value)
+	}
+	return j.w.WriteByte('}')
+}
+
+// value encodes the value of column i by the column's type. Values that do
+// not convert to it are written as strings.
+func (j *jsonlWriter) value(i int, v any) ([]byte, error) {
+	if v == nil {
+		return []byte("null"), nil
+	}
+	switch j.kinds[i] {
+	case kindInt:
+		if n, err := asInt64(v); err == nil {
+			return strconv.AppendInt(nil, n, 10), nil
+		}
+	case kindFloat:
+		if f, err := asFloat64(v); err == nil && !math.IsNaN(f) && !math.IsInf(f, 0) {
+			if j.values != nil && j.values.places >= 0 {
+				return []byte(j.values.format(i, v)), nil
+			}
+			return strconv.AppendFloat(nil, f, 'g', -1, 64), nil
+		}
+	case kindDecimal:
+		if s := j.values.format(i, v); json.Valid([]byte(s)) {
+			if _, err := strconv.ParseFloat(s, 64); err == nil {
+				return []byte(s), nil
+			}
+		}
+	case kindBool:
+		if b, err := asBool(v); err == nil {
+			return strconv.AppendBool(nil, b), nil
+		}
+	}
+	return json.Marshal(j.values.format(i, v))
+}
+
+func (j *jsonlWriter) Close() error {
+	return j.w.Flush()
+}
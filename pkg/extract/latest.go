@@ -0,0 +1,106 @@
+package extract
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Ways to publish the latest output of a job.
+const (
+	latestSymlink = "symlink"
+	latestCopy    = "copy"
+)
+
+// validateLatest checks the latest paths and mode. Only local outfiles can be
+// published under a stable path.
+func validateLatest(latest map[string]string, mode string) error {
+	switch mode {
+	case "", latestSymlink, latestCopy:
+	default:
+		return fmt.Errorf("Unsupported latest_mode '%s' (symlink, copy)\n", mode)
+	}
+	for outFile, path := range latest {
+		if strings.Contains(outFile, "://") || strings.Contains(path, "://") {
+			return fmt.Errorf("Latest path for %s: only local files can be published\n", outFile)
+		}
+		if filepath.Clean(path) == filepath.Clean(outFile) {
+			return fmt.Errorf("Latest path for %s is the outfile itself\n", outFile)
+		}
+	}
+	return nil
+}
+
+// publishLatest points the stable path latest at outFile, replacing any
+// earlier version in one rename so readers never see a missing or partial
+// file. A symlink that cannot be created, as on Windows without the
+// privilege, falls back to a copy.
+func publishLatest(outFile, latest, mode string) error {
+	if err := os.MkdirAll(filepath.Dir(latest), 0o755); err != nil {
+		return fmt.Errorf("Could not create the directory of %s: %v\n", latest, err)
+	}
+	tmp := fmt.Sprintf("%s.%d.tmp", latest, os.Getpid())
+	defer os.Remove(tmp)
+
+	if mode != latestCopy {
+		err := symlinkTo(outFile, latest, tmp)
+		if err == nil {
+			return renameLatest(tmp, latest)
+		}
+		if mode == latestSymlink {
+			return fmt.Errorf("Could not link %s to %s: %v\n", latest, outFile, err)
+		}
+		log.Printf("Warning: copying %s to %s because it could not be linked: %v\n", outFile, latest, err)
+	}
+	if err := copyFile(outFile, tmp); err != nil {
+		return fmt.Errorf("Could not copy %s to %s: %v\n", outFile, latest, err)
+	}
+	return renameLatest(tmp, latest)
+}
+
+// symlinkTo creates tmp as a link to outFile, relative to latest's directory
+// so the pair can move together.
+func symlinkTo(outFile, latest, tmp string) error {
+	target, err := filepath.Abs(outFile)
+	if err != nil {
+		return err
+	}
+	if dir, err := filepath.Abs(filepath.Dir(latest)); err == nil {
+		if rel, err := filepath.Rel(dir, target); err == nil {
+			target = rel
+		}
+	}
+	return os.Symlink(target, tmp)
+}
+
+func renameLatest(tmp, latest string) error {
+	if err := os.Rename(tmp, latest); err != nil {
+		return fmt.Errorf("Could not publish %s: %v\n", latest, err)
+	}
+	log.Printf("Published %s\n", latest)
+	return nil
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,34 @@
+package extract
+
+import (
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPublishLatest(t *testing.T) {
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	dir := t.TempDir()
+	latest := filepath.Join(dir, "latest", "orders.csv")
+	for _, mode := range []string{"", latestCopy} {
+		for _, day := range []string{"2024-06-01", "2024-06-02"} {
+			outFile := filepath.Join(dir, "orders-"+day+mode+".csv")
+			os.WriteFile(outFile, []byte(day), 0o644)
+			if err := publishLatest(outFile, latest, mode); err != nil {
+				t.Fatal(err)
+			}
+			if got, _ := os.ReadFile(latest); string(got) != day {
+				t.Errorf("mode %q: latest reads %q, want %q", mode, got, day)
+			}
+		}
+	}
+	if fi, err := os.Lstat(latest); err != nil || fi.Mode()&os.ModeSymlink != 0 {
+		t.Errorf("copy mode left a symlink: %v", err)
+	}
+	if err := validateLatest(map[string]string{"s3://bucket/a.csv": "latest.csv"}, ""); err == nil {
+		t.Error("a URL outfile was accepted")
+	}
+}
@@ -0,0 +1,170 @@
+package extract
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// schemaColumn is one column of a job's recorded output schema.
+type schemaColumn struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+// ledgerEntry records the last successful run of a job.
+type ledgerEntry struct {
+	LastRun time.Time      `json:"last_run"`
+	Rows    uint           `json:"rows"`
+	Schema  []schemaColumn `json:"schema"`
+}
+
+// ledger is the run history persisted between runs, keyed by outfile. A nil
+// *ledger is valid and records nothing.
+type ledger struct {
+	mu   sync.Mutex
+	path string
+	keys *keyring
+	Jobs map[string]*ledgerEntry `json:"jobs"`
+}
+
+// loadLedger reads the ledger at path, starting an empty one if it does not
+// exist. With keys the ledger is saved encrypted.
+func loadLedger(path string, keys *keyring) (*ledger, error) {
+	if path == "" {
+		return nil, nil
+	}
+	l := &ledger{path: path, keys: keys, Jobs: make(map[string]*ledgerEntry)}
+	data, err := keys.readState(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return l, nil
+	}
+	var plain *plaintextStateError
+	if errors.As(err, &plain) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Could not read ledger %s: %v\n", path, err)
+	}
+	if err := json.Unmarshal(data, l); err != nil {
+		return nil, fmt.Errorf("Could not parse ledger %s: %v\n", path, err)
+	}
+	if l.Jobs == nil {
+		l.Jobs = make(map[string]*ledgerEntry)
+	}
+	return l, nil
+}
+
+// outputSchema describes cols in the form stored in the ledger.
+func outputSchema(cols []column) []schemaColumn {
+	schema := make([]schemaColumn, len(cols))
+	for i, c := range cols {
+		schema[i] = schemaColumn{Name: c.Name, Type: c.DBType}
+		if c.kind() == kindDecimal {
+			schema[i].Type = fmt.Sprintf("%s(%d,%d)", c.DBType, c.Precision, c.Scale)
+		}
+	}
+	return schema
+}
+
+// schemaDrift lists the differences between the schema recorded for job and cols.
+func (l *ledger) schemaDrift(job string, cols []column) []string {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	entry, ok := l.Jobs[job]
+	l.mu.Unlock()
+	if !ok || entry.Schema == nil {
+		return nil
+	}
+
+	previous := make(map[string]schemaColumn, len(entry.Schema))
+	for _, c := range entry.Schema {
+		previous[c.Name] = c
+	}
+	var drift []string
+	current := outputSchema(cols)
+	seen := make(map[string]bool, len(current))
+	for _, c := range current {
+		seen[c.Name] = true
+		old, ok := previous[c.Name]
+		switch {
+		case !ok:
+			drift = append(drift, fmt.Sprintf("column %s %s was added", c.Name, c.Type))
+		case old.Type != c.Type:
+			drift = append(drift, fmt.Sprintf("column %s changed from %s to %s", c.Name, old.Type, c.Type))
+		}
+	}
+	for _, c := range entry.Schema {
+		if !seen[c.Name] {
+			drift = append(drift, fmt.Sprintf("column %s %s was removed", c.Name, c.Type))
+		}
+	}
+	return drift
+}
+
+// record stores a successful run of job and saves the ledger.
+func (l *ledger) record(job string, cols []column, rows uint) error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.Jobs[job] = &ledgerEntry{LastRun: time.Now(), Rows: rows, Schema: outputSchema(cols)}
+	return l.save()
+}
+
+// set stores an entry recorded elsewhere, by a worker, and saves the ledger.
+func (l *ledger) set(job string, e *ledgerEntry) error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.Jobs[job] = e
+	return l.save()
+}
+
+// entry returns the last recorded run of job, or nil.
+func (l *ledger) entry(job string) *ledgerEntry {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.Jobs[job]
+}
+
+// save writes the ledger through a temporary file so a crash cannot leave it
+// truncated. A ledger without a path, a worker's, stays in memory. The caller
+// must hold l.mu.
+func (l *ledger) save() error {
+	if l.path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(l, "", "  ")
+	if err != nil {
+		return err
+	}
+	if data, err = l.keys.sealState(data); err != nil {
+		return fmt.Errorf("Could not encrypt ledger %s: %v\n", l.path, err)
+	}
+	if dir := filepath.Dir(l.path); dir != "" {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return fmt.Errorf("Could not create ledger directory %s: %v\n", dir, err)
+		}
+	}
+	tmp := l.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("Could not write ledger %s: %v\n", l.path, err)
+	}
+	if err := os.Rename(tmp, l.path); err != nil {
+		return fmt.Errorf("Could not write ledger %s: %v\n", l.path, err)
+	}
+	return nil
+}
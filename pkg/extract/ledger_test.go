@@ -0,0 +1,93 @@
+package extract
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSchemaDrift(t *testing.T) {
+	recorded := []column{
+		{Name: "id", DBType: "BIGINT"},
+		{Name: "name", DBType: "NVARCHAR"},
+		{Name: "amount", DBType: "DECIMAL", Precision: 10, Scale: 2},
+	}
+	for _, tc := range []struct {
+		name string
+		cols []column
+		want []string
+	}{
+		{"unchanged", recorded, nil},
+		{"reordered", []column{recorded[2], recorded[0], recorded[1]}, nil},
+		{"added", append(recorded[:3:3], column{Name: "region", DBType: "VARCHAR"}), []string{"column region VARCHAR was added"}},
+		{"removed", recorded[:2], []string{"column amount DECIMAL(10,2) was removed"}},
+		{"retyped", []column{{Name: "id", DBType: "INT"}, recorded[1], recorded[2]}, []string{"column id changed from BIGINT to INT"}},
+		{"rescaled", []column{recorded[0], recorded[1], {Name: "amount", DBType: "DECIMAL", Precision: 12, Scale: 4}}, []string{"column amount changed from DECIMAL(10,2) to DECIMAL(12,4)"}},
+		{"renamed", []column{{Name: "order_id", DBType: "BIGINT"}, recorded[1], recorded[2]}, []string{"column order_id BIGINT was added", "column id BIGINT was removed"}},
+	} {
+		l := &ledger{Jobs: map[string]*ledgerEntry{}}
+		l.record("orders.csv", recorded, 3)
+		if got := l.schemaDrift("orders.csv", tc.cols); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: got %q, want %q", tc.name, got, tc.want)
+		}
+	}
+
+	// jobs never run and entries without a schema have nothing to drift from
+	l := &ledger{Jobs: map[string]*ledgerEntry{"old.csv": {Rows: 1}}}
+	if d := l.schemaDrift("new.csv", recorded); d != nil {
+		t.Errorf("unrecorded job drifted: %q", d)
+	}
+	if d := l.schemaDrift("old.csv", recorded); d != nil {
+		t.Errorf("job without a schema drifted: %q", d)
+	}
+	if d := (*ledger)(nil).schemaDrift("orders.csv", recorded); d != nil {
+		t.Errorf("nil ledger drifted: %q", d)
+	}
+}
+
+func TestSchemaDriftPolicy(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ledger.json")
+	l, err := loadLedger(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := l.record("orders.csv", []column{{Name: "id", DBType: "BIGINT"}}, 1); err != nil {
+		t.Fatal(err)
+	}
+	// the schema is compared with the one saved by the previous run
+	if l, err = loadLedger(path, nil); err != nil {
+		t.Fatal(err)
+	}
+	cols := []column{{Name: "id", DBType: "NVARCHAR"}}
+
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	for _, tc := range []struct {
+		policy string
+		err    string
+		log    string
+	}{
+		{"", "", "Warning: schema of orders.csv changed since the last run: column id changed from BIGINT to NVARCHAR\n"},
+		{"warn", "", "Warning: schema of orders.csv changed since the last run: column id changed from BIGINT to NVARCHAR\n"},
+		{"fail", "Schema of orders.csv changed since the last run: column id changed from BIGINT to NVARCHAR\n", ""},
+		{"ignore", "", ""},
+	} {
+		buf.Reset()
+		_, got, err := prepareColumns(&config{SchemaDrift: tc.policy}, l, nil, "orders.csv", cols)
+		if tc.err != "" {
+			if err == nil || err.Error() != tc.err {
+				t.Errorf("%q: got %v, want %q", tc.policy, err, tc.err)
+			}
+		} else if err != nil || len(got) != 1 {
+			t.Errorf("%q: got %v, %v", tc.policy, got, err)
+		}
+		if !strings.HasSuffix(buf.String(), tc.log) || (tc.log == "" && buf.Len() > 0) {
+			t.Errorf("%q: logged %q, want %q", tc.policy, buf.String(), tc.log)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+// Package extract runs the extractions of sql-export-wiz: the jobs of a
+// configuration, each exporting the result of a query to a file. The
+// sql-export-wiz command is a thin wrapper around Main; other programs load
+// a Config and Run it.
+package extract
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Config is a validated configuration, read with LoadConfig or ParseConfig.
+// Its methods override settings as the command line flags do. A Config runs
+// one extraction at a time.
+type Config struct {
+	c *config
+}
+
+// LoadConfig reads and validates the configuration at path: a YAML file, a
+// directory of them, or env: to read it from the environment.
+func LoadConfig(path string) (*Config, error) {
+	c, err := loadConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	return &Config{c}, nil
+}
+
+// ParseConfig reads and validates a YAML configuration.
+func ParseConfig(data []byte) (*Config, error) {
+	c, err := configFromDocs([]configDoc{{"config.yaml", data}}, "")
+	if err != nil {
+		return nil, err
+	}
+	if err := c.prepare(); err != nil {
+		return nil, err
+	}
+	return &Config{c}, nil
+}
+
+// Jobs returns the outfiles of the jobs a run exports, in order.
+func (c *Config) Jobs() []string {
+	return append([]string(nil), c.c.OutFiles...)
+}
+
+// SetParam sets the query parameter name of every extract declaring it, as
+// -param does.
+func (c *Config) SetParam(name, value string) error {
+	return c.c.setParams(paramFlag{name: value})
+}
+
+// SetConcurrency sets how many jobs run at once, as -concurrency does.
+func (c *Config) SetConcurrency(n int) error {
+	if n < 1 {
+		return fmt.Errorf("Concurrency must be at least 1, got %d\n", n)
+	}
+	c.c.Concurrency = n
+	return nil
+}
+
+// SelectFlow narrows the jobs down to those of a flow, as -flow does.
+func (c *Config) SelectFlow(name string) error {
+	return c.c.selectFlow(name)
+}
+
+// Report is how a run ended.
+type Report struct {
+	Started      time.Time
+	Duration     time.Duration
+	Rows         uint
+	BytesWritten int64
+	Jobs         []JobReport
+}
+
+// JobReport is how one job of a run ended. State is done, failed, skipped or
+// cancelled, or queued when the run failed before starting the job.
+type JobReport struct {
+	Name         string
+	OutFile      string
+	State        string
+	Rows         uint
+	BytesWritten int64
+	Duration     time.Duration
+	Error        string
+	TimedOut     bool
+}
+
+// Exporter runs the jobs of a Config. With DB set it queries DB, which it
+// leaves open, instead of connecting to the configured server; tenants with
+// servers of their own are connected to either way.
+type Exporter struct {
+	DB *sql.DB
+}
+
+// Run runs every job of cfg and reports how each ended. The error is that
+// of the run, such as a job failing or ctx being cancelled, as for the
+// command line.
+func (e *Exporter) Run(ctx context.Context, cfg *Config) (Report, error) {
+	c := cfg.c
+	c.db = e.DB
+	defer func() { c.db = nil }()
+	control := newController(c.OutFiles)
+	err := runJobs(ctx, c, control, nil)
+	return newReport(c, control), err
+}
+
+// Run runs every job of cfg against the configured server, as the command
+// line does.
+func Run(ctx context.Context, cfg *Config) (Report, error) {
+	var e Exporter
+	return e.Run(ctx, cfg)
+}
+
+// newReport reports the run control tracked.
+func newReport(c *config, control *controller) Report {
+	var rep Report
+	var usage []jobUsage
+	control.mu.Lock()
+	r := control.report
+	control.mu.Unlock()
+	if r != nil {
+		r.mu.Lock()
+		rep.Started, rep.Duration = r.Started, time.Duration(r.Seconds*float64(time.Second))
+		rep.Rows, rep.BytesWritten = r.Rows, r.BytesWritten
+		usage = append(usage, r.Jobs...)
+		r.mu.Unlock()
+	}
+	for _, outFile := range c.OutFiles {
+		j := JobReport{
+			Name: c.jobName(outFile), OutFile: outFile, State: control.jobState(outFile),
+			Error: control.jobError(outFile), TimedOut: control.timedOutJob(outFile),
+		}
+		for _, u := range usage {
+			if u.OutFile == outFile {
+				j.Rows, j.BytesWritten = u.Rows, u.BytesWritten
+				j.Duration = time.Duration(u.Seconds * float64(time.Second))
+			}
+		}
+		rep.Jobs = append(rep.Jobs, j)
+	}
+	return rep
+}
@@ -0,0 +1,63 @@
+package extract
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestExporterRun(t *testing.T) {
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	registerFake("library", &fakeQuery{sets: []*fakeResult{numbersResult(3)}})
+	registerFake("library broken", &fakeQuery{err: errors.New("invalid object name")})
+	dir := t.TempDir()
+	cfg, err := ParseConfig([]byte(`server: sql01
+database: Sales
+delimiter: ","
+extracts:
+  - {name: orders, query: library, outfile: ` + filepath.Join(dir, "orders.csv") + `}
+  - {name: broken, query: library broken, outfile: ` + filepath.Join(dir, "broken.csv") + `}
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := sql.Open("fakedb", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	rep, err := (&Exporter{DB: db}).Run(context.Background(), cfg)
+	if err == nil || err.Error() != "1 of 2 extract(s) failed\n" {
+		t.Errorf("got %v", err)
+	}
+	if len(rep.Jobs) != 2 || rep.Rows != 3 {
+		t.Fatalf("got report %+v", rep)
+	}
+	if j := rep.Jobs[0]; j.Name != "orders" || j.State != "done" || j.Rows != 3 || j.BytesWritten == 0 {
+		t.Errorf("got orders %+v", j)
+	}
+	if j := rep.Jobs[1]; j.State != "failed" || j.Error == "" {
+		t.Errorf("got broken %+v", j)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dir, "orders.csv")); string(data) != "id,name\n1,name 1\n2,name 2\n3,\n" {
+		t.Errorf("wrote %q", data)
+	}
+	// the exporter's database is left open
+	if err := db.Ping(); err != nil {
+		t.Error(err)
+	}
+
+	if err := cfg.SelectFlow("month-end"); err == nil {
+		t.Error("selected a missing flow")
+	}
+	if err := cfg.SetConcurrency(0); err == nil {
+		t.Error("accepted a concurrency of 0")
+	}
+}
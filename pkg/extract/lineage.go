@@ -0,0 +1,208 @@
+package extract
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	lineageProducer      = "https://github.com/nnyquist/sql-export-wiz"
+	lineageEventSchema   = "https://openlineage.io/spec/2-0-2/OpenLineage.json#/$defs/RunEvent"
+	lineageSchemaFacetV1 = "https://openlineage.io/spec/facets/1-1-1/SchemaDatasetFacet.json#/$defs/SchemaDatasetFacet"
+)
+
+// lineageOptions configures OpenLineage events. Each job emits START when it
+// begins and COMPLETE, FAIL or ABORT when it ends.
+type lineageOptions struct {
+	// Endpoint receives the events, e.g. http://marquez:5000/api/v1/lineage.
+	Endpoint string `yaml:"endpoint"`
+	// Namespace is the job namespace, sql-export-wiz by default.
+	Namespace string `yaml:"namespace"`
+	// APIKeyEnv names an environment variable holding a bearer token.
+	APIKeyEnv string `yaml:"api_key_env"`
+}
+
+func (o lineageOptions) validate() error {
+	if o.Endpoint == "" {
+		return nil
+	}
+	u, err := url.Parse(o.Endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return fmt.Errorf("Lineage endpoint '%s' is not an http(s) URL\n", o.Endpoint)
+	}
+	return nil
+}
+
+type lineageEvent struct {
+	EventType string        `json:"eventType"`
+	EventTime time.Time     `json:"eventTime"`
+	Run       lineageRunRef `json:"run"`
+	Job       lineageJob    `json:"job"`
+	Inputs    []lineageSet  `json:"inputs"`
+	Outputs   []lineageSet  `json:"outputs"`
+	Producer  string        `json:"producer"`
+	SchemaURL string        `json:"schemaURL"`
+}
+
+type lineageRunRef struct {
+	RunID string `json:"runId"`
+}
+
+type lineageJob struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+}
+
+type lineageSet struct {
+	Namespace string         `json:"namespace"`
+	Name      string         `json:"name"`
+	Facets    map[string]any `json:"facets,omitempty"`
+}
+
+type lineageSchemaFacet struct {
+	Producer  string         `json:"_producer"`
+	SchemaURL string         `json:"_schemaURL"`
+	Fields    []lineageField `json:"fields"`
+}
+
+type lineageField struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+var lineageClient = &http.Client{Timeout: 10 * time.Second}
+
+// lineageRun describes one job run to OpenLineage.
+type lineageRun struct {
+	o      lineageOptions
+	id     string
+	job    lineageJob
+	inputs []lineageSet
+	output lineageSet
+}
+
+// newLineageRun prepares the events of a job, or returns nil when no
+// endpoint is configured.
+func (c *config) newLineageRun(tenant, query, outFile string) *lineageRun {
+	o := c.Lineage
+	if o.Endpoint == "" {
+		return nil
+	}
+	namespace := o.Namespace
+	if namespace == "" {
+		namespace = "sql-export-wiz"
+	}
+	entry := c.auditEntry(tenant, query, outFile)
+	l := &lineageRun{o: o, id: newUUID(), job: lineageJob{Namespace: namespace, Name: outFile}, output: outputDataset(outFile)}
+	for _, table := range entry.Tables {
+		l.inputs = append(l.inputs, inputDataset(entry.Server, entry.Database, table))
+	}
+	return l
+}
+
+// inputDataset names a source table the OpenLineage way for SQL Server,
+// as database.schema.table in the mssql://server namespace.
+func inputDataset(server, database, table string) lineageSet {
+	parts := strings.Split(table, ".")
+	for i, p := range parts {
+		parts[i] = strings.Trim(p, `[]"`)
+	}
+	switch len(parts) {
+	case 1:
+		parts = []string{database, "dbo", parts[0]}
+	case 2:
+		parts = append([]string{database}, parts...)
+	case 4:
+		// a linked server name moves the table to that server
+		server, parts = parts[0], parts[1:]
+	}
+	if parts[1] == "" {
+		parts[1] = "dbo"
+	}
+	return lineageSet{Namespace: "mssql://" + server, Name: strings.Join(parts, ".")}
+}
+
+// outputDataset names an outfile: URL outfiles by scheme and location, local
+// files by their absolute path on this host.
+func outputDataset(outFile string) lineageSet {
+	if scheme, rest, ok := strings.Cut(outFile, "://"); ok {
+		if bucket, key, ok := strings.Cut(rest, "/"); ok && (scheme == "s3" || scheme == "gs" || scheme == "az" || scheme == "azure") {
+			return lineageSet{Namespace: scheme + "://" + bucket, Name: key}
+		}
+		return lineageSet{Namespace: scheme, Name: rest}
+	}
+	host, _ := os.Hostname()
+	abs, err := filepath.Abs(outFile)
+	if err != nil {
+		abs = outFile
+	}
+	return lineageSet{Namespace: "file://" + host, Name: filepath.ToSlash(abs)}
+}
+
+// emit posts an event of the run. Failures are logged and never fail the job.
+func (l *lineageRun) emit(eventType string, cols []column) {
+	if l == nil {
+		return
+	}
+	output := l.output
+	if len(cols) > 0 {
+		facet := lineageSchemaFacet{Producer: lineageProducer, SchemaURL: lineageSchemaFacetV1}
+		for _, c := range cols {
+			facet.Fields = append(facet.Fields, lineageField{Name: c.Name, Type: c.DBType})
+		}
+		output.Facets = map[string]any{"schema": facet}
+	}
+	event := lineageEvent{
+		EventType: eventType, EventTime: time.Now().UTC(), Run: lineageRunRef{RunID: l.id}, Job: l.job,
+		Inputs: l.inputs, Outputs: []lineageSet{output}, Producer: lineageProducer, SchemaURL: lineageEventSchema,
+	}
+	if event.Inputs == nil {
+		event.Inputs = []lineageSet{}
+	}
+	if err := l.post(event); err != nil {
+		log.Printf("Warning: could not send the lineage %s event of %s: %v\n", eventType, l.job.Name, err)
+	}
+}
+
+func (l *lineageRun) post(event lineageEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, l.o.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if l.o.APIKeyEnv != "" {
+		req.Header.Set("Authorization", "Bearer "+os.Getenv(l.o.APIKeyEnv))
+	}
+	resp, err := lineageClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("status %s", resp.Status)
+	}
+	return nil
+}
+
+// lineageEventType maps a finished job's state to its OpenLineage event.
+func lineageEventType(state string) string {
+	switch state {
+	case jobDone:
+		return "COMPLETE"
+	case jobCancelled:
+		return "ABORT"
+	}
+	return "FAIL"
+}
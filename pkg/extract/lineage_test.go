@@ -0,0 +1,54 @@
+package extract
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestInputDataset(t *testing.T) {
+	tests := []struct{ table, namespace, name string }{
+		{"Orders", "mssql://db01", "Sales.dbo.Orders"},
+		{"[dbo].[Orders]", "mssql://db01", "Sales.dbo.Orders"},
+		{"Archive..Orders", "mssql://db01", "Archive.dbo.Orders"},
+		{"LINKED.Hr.dbo.People", "mssql://LINKED", "Hr.dbo.People"},
+	}
+	for _, tt := range tests {
+		got := inputDataset("db01", "Sales", tt.table)
+		if got.Namespace != tt.namespace || got.Name != tt.name {
+			t.Errorf("inputDataset(%q) = %s %s, want %s %s", tt.table, got.Namespace, got.Name, tt.namespace, tt.name)
+		}
+	}
+}
+
+func TestLineageEmit(t *testing.T) {
+	var events []lineageEvent
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var e lineageEvent
+		if err := json.NewDecoder(r.Body).Decode(&e); err != nil {
+			t.Error(err)
+		}
+		events = append(events, e)
+	}))
+	defer srv.Close()
+
+	c := &config{Server: "db01", Database: "Sales", Lineage: lineageOptions{Endpoint: srv.URL}}
+	l := c.newLineageRun("", "SELECT * FROM dbo.Orders", "s3://reports/orders.csv")
+	l.emit("START", nil)
+	l.emit(lineageEventType(jobDone), []column{{Name: "id", DBType: "BIGINT"}})
+
+	if len(events) != 2 || events[0].EventType != "START" || events[1].EventType != "COMPLETE" {
+		t.Fatalf("got events %+v", events)
+	}
+	if events[0].Run.RunID != events[1].Run.RunID {
+		t.Error("the events of one job have different run ids")
+	}
+	e := events[1]
+	if len(e.Inputs) != 1 || e.Inputs[0].Name != "Sales.dbo.Orders" {
+		t.Errorf("inputs = %+v", e.Inputs)
+	}
+	if out := e.Outputs[0]; out.Namespace != "s3://reports" || out.Name != "orders.csv" || out.Facets["schema"] == nil {
+		t.Errorf("output = %+v", out)
+	}
+}
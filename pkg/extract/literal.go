@@ -0,0 +1,85 @@
+package extract
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// literalColumns are constant output columns in the order they are configured.
+type literalColumns []literalColumn
+
+// literalColumn is a constant stamped on every row.
+type literalColumn struct {
+	Name  string
+	Value any
+}
+
+// UnmarshalYAML reads a mapping of column names to values, keeping its order.
+func (l *literalColumns) UnmarshalYAML(n *yaml.Node) error {
+	if n.Kind != yaml.MappingNode {
+		return fmt.Errorf("line %d: literal columns must be a mapping of names to values", n.Line)
+	}
+	for i := 0; i < len(n.Content); i += 2 {
+		var v any
+		if err := n.Content[i+1].Decode(&v); err != nil {
+			return err
+		}
+		switch x := v.(type) {
+		case int:
+			v = int64(x)
+		case nil, int64, float64, bool, string, time.Time:
+		default:
+			return fmt.Errorf("line %d: literal column %s must be a scalar", n.Content[i+1].Line, n.Content[i].Value)
+		}
+		*l = append(*l, literalColumn{Name: n.Content[i].Value, Value: v})
+	}
+	return nil
+}
+
+// column returns the output column for the literal, typed by its YAML value.
+func (lc literalColumn) column() column {
+	c := column{Name: lc.Name, DBType: "NVARCHAR", Nullable: lc.Value == nil}
+	switch v := lc.Value.(type) {
+	case int64:
+		c.DBType = "BIGINT"
+	case float64:
+		c.DBType = "FLOAT"
+	case bool:
+		c.DBType = "BIT"
+	case time.Time:
+		c.DBType = "DATETIME2"
+		if v.Equal(v.Truncate(24 * time.Hour)) {
+			c.DBType = "DATE"
+		}
+	}
+	return c
+}
+
+// literalTransform appends the literal columns to every row.
+type literalTransform struct {
+	columns literalColumns
+}
+
+func (t *literalTransform) Setup(cols []column) ([]column, error) {
+	for _, lc := range t.columns {
+		for _, c := range cols {
+			if strings.EqualFold(c.Name, lc.Name) {
+				return nil, fmt.Errorf("Literal column %s duplicates a column of the query result\n", lc.Name)
+			}
+		}
+		cols = append(cols[:len(cols):len(cols)], lc.column())
+	}
+	return cols, nil
+}
+
+func (t *literalTransform) Apply(row []any) ([]any, bool, error) {
+	out := make([]any, len(row), len(row)+len(t.columns))
+	copy(out, row)
+	for _, lc := range t.columns {
+		out = append(out, lc.Value)
+	}
+	return out, true, nil
+}
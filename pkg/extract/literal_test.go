@@ -0,0 +1,65 @@
+package extract
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestLiteralColumns(t *testing.T) {
+	var lc literalColumns
+	src := "source: ERP1\nbatch: 7\nrate: 1.5\nactive: true\nloaded: 2026-10-15\nat: 2026-10-15T09:30:00Z\nnote: null\n"
+	if err := yaml.Unmarshal([]byte(src), &lc); err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		name   string
+		value  any
+		dbType string
+	}{
+		{"source", "ERP1", "NVARCHAR"},
+		{"batch", int64(7), "BIGINT"},
+		{"rate", 1.5, "FLOAT"},
+		{"active", true, "BIT"},
+		{"loaded", time.Date(2026, 10, 15, 0, 0, 0, 0, time.UTC), "DATE"},
+		{"at", time.Date(2026, 10, 15, 9, 30, 0, 0, time.UTC), "DATETIME2"},
+		{"note", nil, "NVARCHAR"},
+	}
+	if len(lc) != len(want) {
+		t.Fatalf("got %d columns, want %d", len(lc), len(want))
+	}
+	for i, w := range want {
+		col := lc[i].column()
+		if lc[i].Name != w.name || lc[i].Value != w.value || col.DBType != w.dbType || col.Nullable != (w.value == nil) {
+			t.Errorf("column %d is %s = %#v (%+v), want %s = %#v %s", i, lc[i].Name, lc[i].Value, col, w.name, w.value, w.dbType)
+		}
+	}
+
+	for _, bad := range []string{"[a, b]", "nested: {a: 1}", "list: [1, 2]"} {
+		var lc literalColumns
+		if err := yaml.Unmarshal([]byte(bad), &lc); err == nil {
+			t.Errorf("%q parsed as literal columns", bad)
+		}
+	}
+}
+
+func TestExportDataLiterals(t *testing.T) {
+	registerFake("literals", &fakeQuery{sets: []*fakeResult{numbersResult(2)}})
+	outFile := filepath.Join(t.TempDir(), "out.csv")
+	c := &config{Literals: map[string]literalColumns{outFile: {{Name: "source", Value: "ERP1"}, {Name: "batch", Value: int64(7)}, {Name: "note", Value: nil}}}}
+	got, err := exportFake(t, c, "literals", outFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "id,name,source,batch,note\n1,name 1,ERP1,7,\n2,name 2,ERP1,7,\n"; got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+
+	c.Literals[outFile] = literalColumns{{Name: "NAME", Value: "x"}}
+	if _, err := exportFake(t, c, "literals", outFile); err == nil || !strings.Contains(err.Error(), "duplicates a column") {
+		t.Errorf("a literal named like a result column: %v", err)
+	}
+}
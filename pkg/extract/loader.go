@@ -0,0 +1,215 @@
+package extract
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// loaderTailLines is how many of the last lines a loader writes are kept for
+// the job's error.
+const loaderTailLines = 20
+
+// loaderOptions is a loader process for loader:// destinations, such as
+// psql running \copy, sqlldr or bcp, which reads the formatted rows on its
+// standard input.
+type loaderOptions struct {
+	// Command is the program and its arguments. {target} is replaced with
+	// what follows the loader's name in the outfile.
+	Command []string          `yaml:"command"`
+	Dir     string            `yaml:"dir"`
+	Env     map[string]string `yaml:"env"`
+}
+
+func validateLoaders(c *config) error {
+	for name, o := range c.Loaders {
+		if len(o.Command) == 0 || o.Command[0] == "" {
+			return fmt.Errorf("Loader %s has no command\n", name)
+		}
+	}
+	for _, outFile := range c.OutFiles {
+		dest, ok := strings.CutPrefix(outFile, "loader://")
+		if !ok {
+			continue
+		}
+		name, _, _ := strings.Cut(dest, "/")
+		if _, ok := c.Loaders[name]; !ok {
+			return fmt.Errorf("%s names unknown loader '%s'\n", outFile, name)
+		}
+	}
+	return nil
+}
+
+// loaderOutput streams the formatted result into the standard input of a
+// loader process. Close waits for the loader, whose exit status decides the
+// job's.
+type loaderOutput struct {
+	rowWriter
+	name       string
+	cmd        *exec.Cmd
+	stdin      io.WriteCloser
+	compressor io.WriteCloser
+	count      *countingWriter
+	output     *tailLines
+	closed     bool
+
+	waitOnce sync.Once
+	waitErr  error
+}
+
+// newLoaderOutput starts the loader named by dest, as name/target, for the
+// job writing outFile.
+func newLoaderOutput(c *config, dest, outFile string) (*loaderOutput, error) {
+	name, target, _ := strings.Cut(dest, "/")
+	o, ok := c.Loaders[name]
+	if !ok {
+		return nil, fmt.Errorf("No loader is named %s\n", name)
+	}
+	args := make([]string, len(o.Command))
+	for i, arg := range o.Command {
+		args[i] = strings.ReplaceAll(arg, "{target}", target)
+	}
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Dir = o.Dir
+	if len(o.Env) > 0 {
+		cmd.Env = os.Environ()
+		keys := make([]string, 0, len(o.Env))
+		for k := range o.Env {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			cmd.Env = append(cmd.Env, k+"="+o.Env[k])
+		}
+	}
+	l := &loaderOutput{name: name, cmd: cmd, output: &tailLines{max: loaderTailLines}}
+	cmd.Stdout, cmd.Stderr = l.output, l.output
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("Could not start loader %s: %v\n", name, err)
+	}
+	l.stdin = stdin
+	l.count = &countingWriter{w: c.throttle(loaderStdin{l}, outFile)}
+	if l.compressor, l.rowWriter, err = newFileEncoder(c, l.count, dest, outFile); err != nil {
+		l.Abort()
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *loaderOutput) bytesWritten() int64 {
+	return l.count.n.Load()
+}
+
+// wait waits for the loader to exit and returns why it failed, with the
+// last lines it wrote.
+func (l *loaderOutput) wait() error {
+	l.waitOnce.Do(func() {
+		if err := l.cmd.Wait(); err != nil {
+			l.waitErr = fmt.Errorf("loader %s failed: %v", l.name, err)
+			if tail := l.output.String(); tail != "" {
+				l.waitErr = fmt.Errorf("%v\n%s", l.waitErr, tail)
+			}
+		}
+	})
+	return l.waitErr
+}
+
+// loaderStdin writes to the loader, reporting a loader that stopped reading
+// by how it exited.
+type loaderStdin struct {
+	l *loaderOutput
+}
+
+func (w loaderStdin) Write(p []byte) (int, error) {
+	n, err := w.l.stdin.Write(p)
+	if err != nil {
+		if exited := w.l.wait(); exited != nil {
+			return n, exited
+		}
+		return n, fmt.Errorf("loader %s stopped reading: %v", w.l.name, err)
+	}
+	return n, nil
+}
+
+// Close finishes the input and waits for the loader. One that cannot be
+// finished is killed.
+func (l *loaderOutput) Close() error {
+	l.closed = true
+	if err := l.rowWriter.Close(); err != nil {
+		l.kill()
+		return err
+	}
+	if l.compressor != nil {
+		if err := l.compressor.Close(); err != nil {
+			l.kill()
+			return fmt.Errorf("could not finish the compressed stream: %v", err)
+		}
+	}
+	if err := l.stdin.Close(); err != nil && !errors.Is(err, os.ErrClosed) {
+		l.kill()
+		return err
+	}
+	if err := l.wait(); err != nil {
+		return err
+	}
+	log.Printf("Loader %s exited with status 0\n", l.name)
+	return nil
+}
+
+// Abort kills the loader, which is left to roll back what it loaded.
+func (l *loaderOutput) Abort() {
+	if !l.closed {
+		l.closed = true
+		if l.compressor != nil {
+			l.compressor.Close()
+		}
+		l.kill()
+	}
+}
+
+func (l *loaderOutput) kill() {
+	l.cmd.Process.Kill()
+	l.stdin.Close()
+	l.wait()
+}
+
+// tailLines keeps the last max lines written to it.
+type tailLines struct {
+	mu      sync.Mutex
+	max     int
+	lines   []string
+	partial string
+}
+
+func (t *tailLines) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	text := t.partial + string(p)
+	lines := strings.Split(text, "\n")
+	t.partial = lines[len(lines)-1]
+	t.lines = append(t.lines, lines[:len(lines)-1]...)
+	if len(t.lines) > t.max {
+		t.lines = t.lines[len(t.lines)-t.max:]
+	}
+	return len(p), nil
+}
+
+func (t *tailLines) String() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	lines := t.lines
+	if t.partial != "" {
+		lines = append(lines[:len(lines):len(lines)], t.partial)
+	}
+	return strings.Join(lines[max(len(lines)-t.max, 0):], "\n")
+}
@@ -0,0 +1,85 @@
+package extract
+
+import (
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoaderOutput(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("no shell here")
+	}
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	dir := t.TempDir()
+	c := &config{Delimiter: ",", Loaders: map[string]loaderOptions{
+		"copy": {Command: []string{"sh", "-c", "cat > {target}.csv"}, Dir: dir},
+		"fail": {Command: []string{"sh", "-c", "cat > /dev/null; echo \"bad row 2\" >&2; exit 3"}},
+		"gone": {Command: []string{"sh", "-c", "echo \"no such table\" >&2; exit 4"}},
+	}}
+	cols := []column{{Name: "id"}, {Name: "name"}}
+	write := func(outFile string, rows int) error {
+		o, err := openOutput(c, outFile)
+		if err != nil {
+			return err
+		}
+		if err := o.WriteHeader(cols); err != nil {
+			o.Abort()
+			return err
+		}
+		for i := 0; i < rows; i++ {
+			if err := o.WriteRow([]any{int64(i), strings.Repeat("x", 100)}); err != nil {
+				o.Abort()
+				return err
+			}
+		}
+		return o.Close()
+	}
+
+	if err := write("loader://copy/orders", 2); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "orders.csv"))
+	if err != nil || !strings.HasPrefix(string(data), "id,name\n0,xxx") {
+		t.Errorf("loader got %q, %v", data, err)
+	}
+
+	err = write("loader://fail/orders", 2)
+	if err == nil || !strings.Contains(err.Error(), "exit status 3") || !strings.Contains(err.Error(), "bad row 2") {
+		t.Errorf("failed loader: %v", err)
+	}
+	// a loader exiting early fails the write of the rows it did not take
+	err = write("loader://gone/orders", 100000)
+	if err == nil || !strings.Contains(err.Error(), "exit status 4") || !strings.Contains(err.Error(), "no such table") {
+		t.Errorf("loader that stopped reading: %v", err)
+	}
+}
+
+func TestValidateLoaders(t *testing.T) {
+	c := &config{Loaders: map[string]loaderOptions{"psql": {Command: []string{"psql"}}}, OutFiles: []string{"loader://psql/orders"}}
+	if err := validateLoaders(c); err != nil {
+		t.Error(err)
+	}
+	c.OutFiles = []string{"loader://sqlldr/orders"}
+	if err := validateLoaders(c); err == nil {
+		t.Error("an unknown loader is valid")
+	}
+	c.Loaders["psql"] = loaderOptions{}
+	if err := validateLoaders(c); err == nil {
+		t.Error("a loader without a command is valid")
+	}
+}
+
+func TestTailLines(t *testing.T) {
+	l := &tailLines{max: 2}
+	io.WriteString(l, "one\ntwo\nth")
+	io.WriteString(l, "ree\nfour")
+	if got := l.String(); got != "three\nfour" {
+		t.Errorf("got %q", got)
+	}
+}
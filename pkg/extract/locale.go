@@ -0,0 +1,48 @@
+package extract
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/text/collate"
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+)
+
+// parseLocale parses a BCP 47 locale such as sv-SE.
+func parseLocale(name string) (language.Tag, error) {
+	tag, err := language.Parse(name)
+	if err != nil {
+		return language.Und, fmt.Errorf("Unsupported locale '%s': %v\n", name, err)
+	}
+	return tag, nil
+}
+
+// locale returns the locale configured for the job writing outFile.
+func (c *config) locale(outFile string) (language.Tag, bool) {
+	name, ok := c.Locales[outFile]
+	if !ok {
+		return language.Und, false
+	}
+	tag, err := parseLocale(name)
+	return tag, err == nil
+}
+
+// decimalSeparator returns the decimal separator of a locale.
+func decimalSeparator(tag language.Tag) string {
+	s := message.NewPrinter(tag).Sprintf("%.1f", 1.5)
+	return strings.TrimSuffix(strings.TrimPrefix(s, "1"), "5")
+}
+
+// localizeNumber rewrites the decimal point of a formatted number.
+func localizeNumber(s, sep string) string {
+	if sep == "." {
+		return s
+	}
+	return strings.Replace(s, ".", sep, 1)
+}
+
+// newCollator returns the string collation of a locale.
+func newCollator(tag language.Tag) *collate.Collator {
+	return collate.New(tag)
+}
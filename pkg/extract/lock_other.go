@@ -0,0 +1,12 @@
+//go:build !unix && !windows
+
+package extract
+
+import "os"
+
+// lockFile does nothing on this platform; only one process may write the
+// files it guards.
+func lockFile(f *os.File) error { return nil }
+
+// unlockFile releases the lock lockFile took.
+func unlockFile(f *os.File) error { return nil }
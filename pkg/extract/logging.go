@@ -0,0 +1,76 @@
+package extract
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// jsonLog writes the log as JSON lines under -log-format json, and is nil
+// for the text log.
+var jsonLog *slog.Logger
+
+// setupLogFormat selects the log format: text, as the log has always read,
+// or json, one object per line for log aggregators.
+func setupLogFormat(format string) error {
+	switch format {
+	case "", "text":
+		return nil
+	case "json":
+		jsonLog = slog.New(slog.NewJSONHandler(os.Stderr, nil))
+		// counts and durations are written plainly for the aggregator
+		console = consoleMode{}
+		log.SetFlags(0)
+		log.SetOutput(jsonLogWriter{jsonLog})
+		return nil
+	}
+	return fmt.Errorf("Unknown log format '%s', want text or json\n", format)
+}
+
+// jsonLogWriter turns each line the log package writes into a record,
+// levelled by what it reports.
+type jsonLogWriter struct {
+	logger *slog.Logger
+}
+
+func (w jsonLogWriter) Write(p []byte) (int, error) {
+	msg := strings.TrimSuffix(string(p), "\n")
+	w.logger.Log(context.Background(), messageLevel(msg), msg)
+	return len(p), nil
+}
+
+// messageLevel returns the level of a log message, by the same prefixes the
+// console colors it by.
+func messageLevel(msg string) slog.Level {
+	switch {
+	case strings.HasPrefix(msg, "Warning:"), strings.HasPrefix(msg, "Retrying "):
+		return slog.LevelWarn
+	case strings.HasPrefix(msg, "Failed "), strings.HasPrefix(msg, "Timed out "), strings.HasPrefix(msg, "  "):
+		return slog.LevelError
+	}
+	return slog.LevelInfo
+}
+
+// jobAttrs are the fields of every record about the job writing outFile.
+func (c *config) jobAttrs(outFile string) []slog.Attr {
+	return []slog.Attr{slog.String("run_id", c.runID), slog.String("extract", c.jobName(outFile)), slog.String("outfile", outFile)}
+}
+
+// logJob logs msg about the job writing outFile. The JSON log adds the run
+// and job fields and attrs to it.
+func (c *config) logJob(outFile, msg string, attrs ...slog.Attr) {
+	logAttrs(msg, append(c.jobAttrs(outFile), attrs...))
+}
+
+// logAttrs logs msg, with attrs in the JSON log.
+func logAttrs(msg string, attrs []slog.Attr) {
+	if jsonLog == nil {
+		log.Print(msg)
+		return
+	}
+	msg = strings.TrimSuffix(msg, "\n")
+	jsonLog.LogAttrs(context.Background(), messageLevel(msg), msg, attrs...)
+}
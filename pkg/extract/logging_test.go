@@ -0,0 +1,53 @@
+package extract
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"log/slog"
+	"os"
+	"testing"
+)
+
+func TestJSONLog(t *testing.T) {
+	var out bytes.Buffer
+	jsonLog = slog.New(slog.NewJSONHandler(&out, nil))
+	log.SetFlags(0)
+	log.SetOutput(jsonLogWriter{jsonLog})
+	t.Cleanup(func() {
+		jsonLog = nil
+		log.SetFlags(log.LstdFlags)
+		log.SetOutput(os.Stderr)
+	})
+
+	c := &config{runID: "run1"}
+	c.logJob("orders.csv", "Failed orders.csv: boom\n", slog.String("error", "boom"))
+	log.Println("Warning: slow")
+
+	dec := json.NewDecoder(&out)
+	var rec map[string]any
+	if err := dec.Decode(&rec); err != nil {
+		t.Fatal(err)
+	}
+	for k, want := range map[string]any{"level": "ERROR", "msg": "Failed orders.csv: boom", "run_id": "run1", "extract": "orders.csv", "outfile": "orders.csv", "error": "boom"} {
+		if rec[k] != want {
+			t.Errorf("%s = %v, want %v", k, rec[k], want)
+		}
+	}
+	rec = nil
+	if err := dec.Decode(&rec); err != nil {
+		t.Fatal(err)
+	}
+	if rec["level"] != "WARN" || rec["msg"] != "Warning: slow" {
+		t.Errorf("log package line: %v", rec)
+	}
+}
+
+func TestSetupLogFormat(t *testing.T) {
+	if err := setupLogFormat("text"); err != nil || jsonLog != nil {
+		t.Errorf("text: %v", err)
+	}
+	if err := setupLogFormat("xml"); err == nil {
+		t.Error("want error for an unknown format")
+	}
+}
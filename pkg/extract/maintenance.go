@@ -0,0 +1,92 @@
+package extract
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// maintenanceWindow is a blackout during which no job starts, such as
+// patching on Sundays from 01:00 to 03:00 local time. A window ending before
+// it starts runs past midnight into the next day.
+type maintenanceWindow struct {
+	// Days are the days of the week the window starts on, every day if empty.
+	Days  []string `yaml:"days"`
+	Start string   `yaml:"start"`
+	End   string   `yaml:"end"`
+}
+
+func (w maintenanceWindow) validate(i int) error {
+	for _, day := range w.Days {
+		if _, ok := weekdays[strings.ToLower(day)]; !ok {
+			return fmt.Errorf("Maintenance window %d has days that are not days of the week: '%s'\n", i+1, day)
+		}
+	}
+	start, err1 := time.Parse("15:04", w.Start)
+	end, err2 := time.Parse("15:04", w.End)
+	if err1 != nil || err2 != nil {
+		return fmt.Errorf("Maintenance window %d needs a start and end time of day such as 01:00\n", i+1)
+	}
+	if start.Equal(end) {
+		return fmt.Errorf("Maintenance window %d starts and ends at %s\n", i+1, w.Start)
+	}
+	return nil
+}
+
+// until returns the end of the occurrence of the window that t falls in.
+func (w maintenanceWindow) until(t time.Time) (time.Time, bool) {
+	start, _ := time.Parse("15:04", w.Start)
+	end, _ := time.Parse("15:04", w.End)
+	// an occurrence past midnight may have started the day before
+	for _, back := range []int{0, -1} {
+		y, m, d := t.AddDate(0, 0, back).Date()
+		from := time.Date(y, m, d, start.Hour(), start.Minute(), 0, 0, t.Location())
+		to := time.Date(y, m, d, end.Hour(), end.Minute(), 0, 0, t.Location())
+		if !to.After(from) {
+			to = to.AddDate(0, 0, 1)
+		}
+		if w.onDay(from.Weekday()) && !t.Before(from) && t.Before(to) {
+			return to, true
+		}
+	}
+	return time.Time{}, false
+}
+
+func (w maintenanceWindow) onDay(day time.Weekday) bool {
+	if len(w.Days) == 0 {
+		return true
+	}
+	for _, name := range w.Days {
+		if weekdays[strings.ToLower(name)] == day {
+			return true
+		}
+	}
+	return false
+}
+
+// maintenanceUntil returns when the maintenance windows t falls in are over,
+// following windows that start before the last one ends.
+func (c *config) maintenanceUntil(t time.Time) (time.Time, bool) {
+	var until time.Time
+	for in := true; in; {
+		in = false
+		for _, w := range c.Maintenance {
+			if end, ok := w.until(t); ok {
+				t, until, in = end, end, true
+			}
+		}
+	}
+	return until, !until.IsZero()
+}
+
+// awaitMaintenance holds off the start of what until the maintenance windows
+// of now are over. It reports false if the run drains in the meantime.
+func (c *config) awaitMaintenance(control *controller, what string) bool {
+	until, ok := c.maintenanceUntil(time.Now())
+	if !ok {
+		return true
+	}
+	log.Printf("Deferring %s until %s, after the maintenance window\n", what, until.Format(time.DateTime))
+	return control.sleepUntil(until)
+}
@@ -0,0 +1,47 @@
+package extract
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMaintenanceUntil(t *testing.T) {
+	c := &config{Maintenance: []maintenanceWindow{
+		{Days: []string{"Sunday"}, Start: "01:00", End: "03:00"},
+		{Days: []string{"sunday"}, Start: "02:30", End: "04:00"},
+		{Days: []string{"Saturday"}, Start: "23:00", End: "00:30"},
+	}}
+	if err := c.validate(); err != nil {
+		t.Fatal(err)
+	}
+	// March 2, 2025 is a Sunday
+	at := func(day, hour, min int) time.Time { return time.Date(2025, time.March, day, hour, min, 0, 0, time.UTC) }
+	for _, tc := range []struct {
+		t    time.Time
+		want time.Time
+	}{
+		{at(2, 0, 59), time.Time{}},
+		{at(2, 1, 0), at(2, 4, 0)},
+		{at(2, 3, 30), at(2, 4, 0)},
+		{at(2, 4, 0), time.Time{}},
+		{at(1, 23, 15), at(2, 0, 30)},
+		{at(2, 0, 15), at(2, 0, 30)},
+		{at(9, 0, 15), at(9, 0, 30)},
+		{at(3, 0, 15), time.Time{}},
+	} {
+		got, ok := c.maintenanceUntil(tc.t)
+		if ok != !tc.want.IsZero() || !got.Equal(tc.want) {
+			t.Errorf("%s: got %s %v, want %s", tc.t, got, ok, tc.want)
+		}
+	}
+
+	for _, bad := range []maintenanceWindow{
+		{Days: []string{"sun"}, Start: "01:00", End: "03:00"},
+		{Start: "1am", End: "03:00"},
+		{Start: "01:00", End: "01:00"},
+	} {
+		if err := bad.validate(0); err == nil {
+			t.Errorf("accepted %+v", bad)
+		}
+	}
+}
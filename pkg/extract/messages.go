@@ -0,0 +1,90 @@
+package extract
+
+import (
+	"time"
+
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+	"golang.org/x/text/message/catalog"
+)
+
+// Messages of the run summary and the tenant notifications, keyed by their
+// English text.
+const (
+	msgRunFinished = "Finished %d of %d extract(s): %d failed, %d skipped, %d cancelled"
+	msgTenantRun   = "Run of %s from %s to %s: %d of %d extract(s) finished, %d failed, %d row(s) written"
+)
+
+// translations holds the messages in the languages besides English.
+var translations = map[language.Tag]map[string]string{
+	language.German: {
+		msgRunFinished: "%[1]d von %[2]d Extrakt(en) abgeschlossen: %[3]d fehlgeschlagen, %[4]d übersprungen, %[5]d abgebrochen",
+		msgTenantRun:   "Lauf von %[1]s von %[2]s bis %[3]s: %[4]d von %[5]d Extrakt(en) abgeschlossen, %[6]d fehlgeschlagen, %[7]d Zeile(n) geschrieben",
+	},
+	language.French: {
+		msgRunFinished: "%[1]d extraction(s) sur %[2]d terminée(s) : %[3]d en échec, %[4]d ignorée(s), %[5]d annulée(s)",
+		msgTenantRun:   "Exécution de %[1]s du %[2]s au %[3]s : %[4]d extraction(s) sur %[5]d terminée(s), %[6]d en échec, %[7]d ligne(s) écrite(s)",
+	},
+	language.Spanish: {
+		msgRunFinished: "%[1]d de %[2]d extracción(es) terminada(s): %[3]d con error, %[4]d omitida(s), %[5]d cancelada(s)",
+		msgTenantRun:   "Ejecución de %[1]s del %[2]s al %[3]s: %[4]d de %[5]d extracción(es) terminada(s), %[6]d con error, %[7]d fila(s) escrita(s)",
+	},
+	language.Swedish: {
+		msgRunFinished: "%[1]d av %[2]d extrakt klara: %[3]d misslyckades, %[4]d hoppades över, %[5]d avbröts",
+		msgTenantRun:   "Körning för %[1]s från %[2]s till %[3]s: %[4]d av %[5]d extrakt klara, %[6]d misslyckades, %[7]d rad(er) skrivna",
+	},
+}
+
+// reportTimeLayouts are the time layouts of the report languages.
+var reportTimeLayouts = map[language.Base]string{
+	mustBase(language.English): "Jan 2, 2006 15:04 MST",
+	mustBase(language.German):  "02.01.2006 15:04 MST",
+	mustBase(language.French):  "02/01/2006 15:04 MST",
+	mustBase(language.Spanish): "02/01/2006 15:04 MST",
+	mustBase(language.Swedish): "2006-01-02 15:04 MST",
+}
+
+func mustBase(tag language.Tag) language.Base {
+	b, _ := tag.Base()
+	return b
+}
+
+var reportCatalog = func() catalog.Catalog {
+	b := catalog.NewBuilder(catalog.Fallback(language.English))
+	for _, key := range []string{msgRunFinished, msgTenantRun} {
+		b.SetString(language.English, key, key)
+	}
+	for tag, msgs := range translations {
+		for key, msg := range msgs {
+			b.SetString(tag, key, msg)
+		}
+	}
+	return b
+}()
+
+// reportLanguages is what a report locale is matched against.
+var reportLanguages = language.NewMatcher(reportCatalog.Languages())
+
+// reportPrinter renders the summary and notifications in a locale: its
+// language if there is a translation, English otherwise, and its numbers.
+type reportPrinter struct {
+	*message.Printer
+	layout string
+}
+
+// newReportPrinter returns the printer of locale, a BCP 47 name checked by
+// validate; "" is English.
+func newReportPrinter(locale string) reportPrinter {
+	tag := language.English
+	if locale != "" {
+		tag, _ = parseLocale(locale)
+	}
+	_, i, _ := reportLanguages.Match(tag)
+	layout := reportTimeLayouts[mustBase(reportCatalog.Languages()[i])]
+	return reportPrinter{message.NewPrinter(tag, message.Catalog(reportCatalog)), layout}
+}
+
+// time writes t in the printer's language.
+func (p reportPrinter) time(t time.Time) string {
+	return t.Format(p.layout)
+}
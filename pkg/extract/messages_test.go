@@ -0,0 +1,40 @@
+package extract
+
+import (
+	"testing"
+	"time"
+)
+
+func TestReportPrinter(t *testing.T) {
+	for _, tc := range []struct {
+		locale, want string
+	}{
+		{"", "Finished 1,200 of 1,500 extract(s): 300 failed, 0 skipped, 0 cancelled"},
+		{"de-CH", "1’200 von 1’500 Extrakt(en) abgeschlossen: 300 fehlgeschlagen, 0 übersprungen, 0 abgebrochen"},
+		{"sv-SE", "1\u00a0200 av 1\u00a0500 extrakt klara: 300 misslyckades, 0 hoppades över, 0 avbröts"},
+		// no translation, but the numbers of the locale
+		{"it-IT", "Finished 1.200 of 1.500 extract(s): 300 failed, 0 skipped, 0 cancelled"},
+	} {
+		if got := newReportPrinter(tc.locale).Sprintf(msgRunFinished, 1200, 1500, 300, 0, 0); got != tc.want {
+			t.Errorf("%s: got %q, want %q", tc.locale, got, tc.want)
+		}
+	}
+}
+
+func TestTenantSummary(t *testing.T) {
+	notice := tenantNotice{
+		Tenant:   "acme",
+		Started:  time.Date(2026, 3, 4, 1, 0, 0, 0, time.UTC),
+		Finished: time.Date(2026, 3, 4, 1, 30, 0, 0, time.UTC),
+		Jobs:     []tenantJob{{Status: jobDone, Rows: 1500}, {Status: jobFailed}},
+	}
+	c := &config{ReportLocale: "fr-FR"}
+	want := "Exécution de acme du 04/03/2026 01:00 UTC au 04/03/2026 01:30 UTC : 1 extraction(s) sur 2 terminée(s), 1 en échec, 1 500 ligne(s) écrite(s)"
+	if got := (tenantOptions{}).summary(c, notice); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	want = "Run of acme from Mar 4, 2026 01:00 UTC to Mar 4, 2026 01:30 UTC: 1 of 2 extract(s) finished, 1 failed, 1,500 row(s) written"
+	if got := (tenantOptions{Locale: "en-US"}).summary(c, notice); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
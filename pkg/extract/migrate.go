@@ -0,0 +1,180 @@
+package extract
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// migrateConfigCommand prints each configuration file with its queries and
+// outfiles rewritten as extracts, or with -w writes it back.
+func migrateConfigCommand(fs *flag.FlagSet) func([]string) error {
+	write := fs.Bool("w", false, "Write the result back to each file instead of printing it.")
+	return func(names []string) error {
+		if len(names) == 0 {
+			return fmt.Errorf("No configuration file given\n")
+		}
+		return migrateConfigFiles(names, *write)
+	}
+}
+
+func migrateConfigFiles(names []string, write bool) error {
+	for _, name := range names {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		out, changed, err := migrateConfig(data)
+		if err != nil {
+			return fmt.Errorf("%s: %v\n", name, err)
+		}
+		switch {
+		case !write:
+			os.Stdout.Write(out)
+		case changed:
+			fi, err := os.Stat(name)
+			if err != nil {
+				return err
+			}
+			if err := os.WriteFile(name, out, fi.Mode().Perm()); err != nil {
+				return err
+			}
+			fmt.Printf("Migrated %s\n", name)
+		}
+	}
+	return nil
+}
+
+// migrateConfig rewrites the queries and outfiles lists of a configuration
+// document as an extracts list, named after the outfiles. Comments on the
+// lists and their items move with them. It reports whether there was
+// anything to rewrite; if not, data is returned as it is.
+func migrateConfig(data []byte) ([]byte, bool, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, false, err
+	}
+	if len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return data, false, nil
+	}
+	root := doc.Content[0]
+	qi, oi, ei := mappingIndex(root, "queries"), mappingIndex(root, "outfiles"), mappingIndex(root, "extracts")
+	if qi < 0 && oi < 0 {
+		return data, false, nil
+	}
+	if qi < 0 || oi < 0 {
+		return nil, false, fmt.Errorf("queries and outfiles must be given together")
+	}
+	queries, outFiles := root.Content[qi+1], root.Content[oi+1]
+	if queries.Kind != yaml.SequenceNode || outFiles.Kind != yaml.SequenceNode {
+		return nil, false, fmt.Errorf("line %d: queries and outfiles must be lists", root.Content[qi].Line)
+	}
+	if len(queries.Content) != len(outFiles.Content) {
+		return nil, false, fmt.Errorf("line %d: %d queries but %d outfiles", root.Content[qi].Line, len(queries.Content), len(outFiles.Content))
+	}
+
+	names := map[string]bool{}
+	var extracts *yaml.Node
+	if ei >= 0 {
+		extracts = root.Content[ei+1]
+		if extracts.Kind != yaml.SequenceNode {
+			return nil, false, fmt.Errorf("line %d: extracts must be a list", root.Content[ei].Line)
+		}
+		for _, item := range extracts.Content {
+			if i := mappingIndex(item, "name"); i >= 0 {
+				names[item.Content[i+1].Value] = true
+			}
+		}
+	}
+
+	items := make([]*yaml.Node, len(queries.Content))
+	for i, q := range queries.Content {
+		o := outFiles.Content[i]
+		name := extractName(o.Value, names)
+		item := &yaml.Node{Kind: yaml.MappingNode, HeadComment: q.HeadComment}
+		outKey := scalarNode("outfile")
+		outKey.HeadComment = o.HeadComment
+		q.HeadComment, o.HeadComment = "", ""
+		item.Content = []*yaml.Node{scalarNode("name"), scalarNode(name), scalarNode("query"), q, outKey, o}
+		items[i] = item
+	}
+
+	qKey, oKey := root.Content[qi], root.Content[oi]
+	if extracts == nil {
+		// the extracts take the place of the queries
+		key := scalarNode("extracts")
+		key.HeadComment = joinComments(qKey.HeadComment, oKey.HeadComment)
+		key.LineComment = joinComments(qKey.LineComment, oKey.LineComment)
+		extracts = &yaml.Node{Kind: yaml.SequenceNode, Content: items}
+		extracts.FootComment = joinComments(queries.FootComment, outFiles.FootComment)
+		root.Content[qi], root.Content[qi+1] = key, extracts
+		root.Content = append(root.Content[:oi], root.Content[oi+2:]...)
+	} else {
+		// jobs of the older layout ran first, so they still do
+		extracts.Content = append(items, extracts.Content...)
+		key := root.Content[ei]
+		key.HeadComment = joinComments(qKey.HeadComment, oKey.HeadComment, key.HeadComment)
+		for _, i := range []int{max(qi, oi), min(qi, oi)} {
+			root.Content = append(root.Content[:i], root.Content[i+2:]...)
+		}
+	}
+
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(&doc); err != nil {
+		return nil, false, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, false, err
+	}
+	return buf.Bytes(), true, nil
+}
+
+// mappingIndex returns the index of key's node in the mapping m, or -1.
+func mappingIndex(m *yaml.Node, key string) int {
+	if m.Kind != yaml.MappingNode {
+		return -1
+	}
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if m.Content[i].Value == key {
+			return i
+		}
+	}
+	return -1
+}
+
+func scalarNode(value string) *yaml.Node {
+	return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: value}
+}
+
+func joinComments(comments ...string) string {
+	var kept []string
+	for _, c := range comments {
+		if c != "" {
+			kept = append(kept, c)
+		}
+	}
+	return strings.Join(kept, "\n")
+}
+
+// extractName names an extract after its outfile without the extension,
+// numbering it if the name is taken.
+func extractName(outFile string, taken map[string]bool) string {
+	base := path.Base(strings.ReplaceAll(outFile, `\`, "/"))
+	base = strings.TrimSuffix(base, path.Ext(base))
+	if base == "" || base == "." || base == "/" {
+		base = "extract"
+	}
+	name := base
+	for n := 2; taken[name]; n++ {
+		name = fmt.Sprintf("%s-%d", base, n)
+	}
+	taken[name] = true
+	return name
+}
@@ -0,0 +1,68 @@
+package extract
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMigrateConfig(t *testing.T) {
+	old := `server: db1
+# the nightly jobs
+queries:
+  # customers first
+  - SELECT * FROM dbo.Customer
+  - SELECT * FROM dbo.Orders # large
+outfiles:
+  - //share/customer.csv
+  - //share/orders.csv
+delimiter: "|"
+`
+	out, changed, err := migrateConfig([]byte(old))
+	if err != nil || !changed {
+		t.Fatalf("got changed %v, error %v", changed, err)
+	}
+	text := string(out)
+	for _, want := range []string{"# the nightly jobs\nextracts:", "# customers first", "# large", "name: customer", "name: orders"} {
+		if !strings.Contains(text, want) {
+			t.Errorf("migrated configuration is missing %q:\n%s", want, text)
+		}
+	}
+	if strings.Contains(text, "queries:") || strings.Contains(text, "outfiles:") {
+		t.Errorf("older layout left in:\n%s", text)
+	}
+
+	before, _, err := parseConfigDocs([]configDoc{{"old", []byte(old)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	after, _, err := parseConfigDocs([]configDoc{{"new", out}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(before.Queries, after.Queries) || !reflect.DeepEqual(before.OutFiles, after.OutFiles) || after.Delimiter != "|" {
+		t.Errorf("jobs changed: %v %v became %v %v", before.Queries, before.OutFiles, after.Queries, after.OutFiles)
+	}
+
+	if _, changed, _ := migrateConfig(out); changed {
+		t.Error("a migrated configuration was migrated again")
+	}
+	if _, _, err := migrateConfig([]byte("queries: [select 1, select 2]\noutfiles: [a.csv]\n")); err == nil {
+		t.Error("lists of different lengths were migrated")
+	}
+}
+
+func TestMigrateConfigExistingExtracts(t *testing.T) {
+	old := "extracts:\n  - {name: a, query: select 2, outfile: b/a.csv}\nqueries: [select 1]\noutfiles: [a.csv]\n"
+	out, _, err := migrateConfig([]byte(old))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, _, err := parseConfigDocs([]configDoc{{"new", out}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(c.OutFiles, []string{"a.csv", "b/a.csv"}) || c.Extracts[0].Name != "a-2" {
+		t.Errorf("got %+v\n%s", c.Extracts, out)
+	}
+}
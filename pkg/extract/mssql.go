@@ -0,0 +1,171 @@
+package extract
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	mssql "github.com/denisenkom/go-mssqldb"
+)
+
+// mssqlOptions holds the settings for mssql:// bulk copy destinations.
+type mssqlOptions struct {
+	tableOptions `yaml:",inline"`
+	// Tablock takes a table lock for the duration of the load.
+	Tablock bool `yaml:"tablock"`
+	// CheckConstraints enforces check constraints during the load.
+	CheckConstraints bool `yaml:"check_constraints"`
+	// FireTriggers runs insert triggers on the target table.
+	FireTriggers bool `yaml:"fire_triggers"`
+	// Auth is the login to the destination server, the source login by
+	// default.
+	Auth authOptions `yaml:"auth"`
+}
+
+// mssqlLogin returns the login to mssql:// destinations: mssql.auth when
+// set, otherwise the login of the source database.
+func (c *config) mssqlLogin() authOptions {
+	if c.MSSQL.Auth != (authOptions{}) {
+		return c.MSSQL.Auth
+	}
+	return c.login()
+}
+
+// mssqlWriter loads the result set into a SQL Server table through the bulk
+// copy protocol, committing every CommitEvery rows or once at the end.
+type mssqlWriter struct {
+	opts    mssqlOptions
+	table   string
+	db      *sql.DB
+	tx      *sql.Tx
+	stmt    *sql.Stmt
+	cols    []column
+	record  []any
+	pending int
+	done    bool
+}
+
+// newMSSQLWriter connects to target, given as server/database/table, with the
+// login a.
+func newMSSQLWriter(target string, o mssqlOptions, a authOptions) (*mssqlWriter, error) {
+	parts := strings.SplitN(target, "/", 3)
+	if len(parts) != 3 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+		return nil, fmt.Errorf("SQL Server destination '%s' must be server/database/table\n", target)
+	}
+	if err := o.validate(); err != nil {
+		return nil, err
+	}
+	d := sourceDrivers["sqlserver"]
+	if err := a.validate("mssql", d); err != nil {
+		return nil, err
+	}
+	db, err := d.open(parts[0], parts[1], a)
+	if err != nil {
+		return nil, err
+	}
+	return &mssqlWriter{opts: o, table: parts[2], db: db}, nil
+}
+
+func (m *mssqlWriter) WriteHeader(cols []column) error {
+	m.cols = cols
+	m.record = make([]any, len(cols))
+	return m.begin(m.opts.statements(m.opts.Pre, m.table))
+}
+
+// begin opens a transaction, runs pre and prepares the bulk copy in it.
+func (m *mssqlWriter) begin(pre []string) error {
+	tx, err := m.db.Begin()
+	if err != nil {
+		return fmt.Errorf("Could not begin transaction for %s: %v\n", m.table, err)
+	}
+	m.tx = tx
+	for _, stmt := range pre {
+		if _, err := tx.Exec(stmt); err != nil {
+			return fmt.Errorf("Pre statement for %s failed: %v\n", m.table, err)
+		}
+	}
+	opts := mssql.BulkOptions{
+		KeepNulls:        true,
+		RowsPerBatch:     m.opts.BatchSize,
+		Tablock:          m.opts.Tablock,
+		CheckConstraints: m.opts.CheckConstraints,
+		FireTriggers:     m.opts.FireTriggers,
+	}
+	stmt, err := tx.Prepare(mssql.CopyIn(m.table, opts, columnNames(m.cols)...))
+	if err != nil {
+		return fmt.Errorf("Could not start bulk copy into %s: %v\n", m.table, err)
+	}
+	m.stmt = stmt
+	return nil
+}
+
+// commit flushes the bulk copy and commits the current transaction.
+func (m *mssqlWriter) commit() error {
+	_, err := m.stmt.Exec()
+	m.stmt.Close()
+	m.stmt = nil
+	if err != nil {
+		m.tx.Rollback()
+		return fmt.Errorf("Bulk copy into %s failed: %v\n", m.table, err)
+	}
+	m.pending = 0
+	if err := m.tx.Commit(); err != nil {
+		return fmt.Errorf("Could not commit bulk copy into %s: %v\n", m.table, err)
+	}
+	return nil
+}
+
+func (m *mssqlWriter) WriteRow(values []any) error {
+	for i, v := range values {
+		m.record[i] = bulkValue(m.cols[i], v)
+	}
+	if _, err := m.stmt.Exec(m.record...); err != nil {
+		return err
+	}
+	m.pending++
+	if m.opts.CommitEvery > 0 && m.pending >= m.opts.CommitEvery {
+		if err := m.commit(); err != nil {
+			return err
+		}
+		return m.begin(nil)
+	}
+	return nil
+}
+
+// bulkValue adapts a source value to the types the bulk copy encoder accepts;
+// text returned as bytes is passed as a string except for binary columns.
+func bulkValue(c column, v any) any {
+	if p, ok := v.([]byte); ok && c.kind() != kindBytes && c.DBType != "UNIQUEIDENTIFIER" {
+		return string(p)
+	}
+	return v
+}
+
+// Close commits the remaining rows and runs the post statements.
+func (m *mssqlWriter) Close() error {
+	m.done = true
+	defer m.db.Close()
+	if err := m.commit(); err != nil {
+		return err
+	}
+	for _, stmt := range m.opts.statements(m.opts.Post, m.table) {
+		if _, err := m.db.Exec(stmt); err != nil {
+			return fmt.Errorf("Post statement for %s failed: %v\n", m.table, err)
+		}
+	}
+	return nil
+}
+
+// Abort rolls back the open transaction; earlier commits are kept.
+func (m *mssqlWriter) Abort() {
+	if !m.done {
+		m.done = true
+		if m.stmt != nil {
+			m.stmt.Close()
+		}
+		if m.tx != nil {
+			m.tx.Rollback()
+		}
+		m.db.Close()
+	}
+}
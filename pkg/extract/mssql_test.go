@@ -0,0 +1,189 @@
+package extract
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	mssql "github.com/denisenkom/go-mssqldb"
+)
+
+// recordDriver is a database that records what a writer sends it: begin,
+// commit, rollback, "exec <statement>", "prepare <statement>" and the
+// arguments of each prepared execution.
+type recordDriver struct{}
+
+var (
+	recordMu  sync.Mutex
+	recordLog = map[string][]string{}
+)
+
+func init() {
+	sql.Register("recorddb", recordDriver{})
+}
+
+// openRecordDB returns a database recording under the test's name.
+func openRecordDB(t *testing.T) (*sql.DB, func() []string) {
+	name := t.Name()
+	recordMu.Lock()
+	delete(recordLog, name)
+	recordMu.Unlock()
+	db, err := sql.Open("recorddb", name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db, func() []string {
+		recordMu.Lock()
+		defer recordMu.Unlock()
+		return recordLog[name]
+	}
+}
+
+func record(name, event string) {
+	recordMu.Lock()
+	defer recordMu.Unlock()
+	recordLog[name] = append(recordLog[name], event)
+}
+
+func (recordDriver) Open(name string) (driver.Conn, error) { return recordConn(name), nil }
+
+type recordConn string
+
+func (c recordConn) Prepare(query string) (driver.Stmt, error) {
+	if strings.HasPrefix(query, "INSERTBULK") {
+		record(string(c), "prepare "+query)
+	}
+	return recordStmt{name: string(c), query: query}, nil
+}
+
+func (c recordConn) Close() error { return nil }
+
+func (c recordConn) Begin() (driver.Tx, error) {
+	record(string(c), "begin")
+	return recordTx(c), nil
+}
+
+type recordTx string
+
+func (tx recordTx) Commit() error   { record(string(tx), "commit"); return nil }
+func (tx recordTx) Rollback() error { record(string(tx), "rollback"); return nil }
+
+type recordStmt struct{ name, query string }
+
+func (s recordStmt) Close() error  { return nil }
+func (s recordStmt) NumInput() int { return -1 }
+
+func (s recordStmt) Exec(args []driver.Value) (driver.Result, error) {
+	if !strings.HasPrefix(s.query, "INSERTBULK") {
+		record(s.name, "exec "+s.query)
+	} else if len(args) == 0 {
+		record(s.name, "flush")
+	} else {
+		record(s.name, fmt.Sprint(args))
+	}
+	return driver.RowsAffected(0), nil
+}
+
+func (s recordStmt) Query([]driver.Value) (driver.Rows, error) {
+	return nil, fmt.Errorf("recorddb: queries are not supported")
+}
+
+func TestMSSQLWriterBatches(t *testing.T) {
+	cols := []column{{Name: "id", DBType: "BIGINT"}, {Name: "name", DBType: "NVARCHAR"}}
+	o := mssqlOptions{
+		tableOptions: tableOptions{BatchSize: 100, CommitEvery: 2, Pre: []string{"TRUNCATE TABLE {table}"}, Post: []string{"UPDATE STATISTICS {table}"}},
+		Tablock:      true,
+		FireTriggers: true,
+	}
+	db, events := openRecordDB(t)
+	m := &mssqlWriter{opts: o, table: "dbo.Orders", db: db}
+	if err := m.WriteHeader(cols); err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 5; i++ {
+		if err := m.WriteRow([]any{int64(i), []byte(fmt.Sprintf("n%d", i))}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := m.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	prepare := "prepare " + mssql.CopyIn("dbo.Orders", mssql.BulkOptions{KeepNulls: true, RowsPerBatch: 100, Tablock: true, FireTriggers: true}, "id", "name")
+	want := []string{
+		"begin", "exec TRUNCATE TABLE dbo.Orders", prepare, "[1 n1]", "[2 n2]", "flush", "commit",
+		"begin", prepare, "[3 n3]", "[4 n4]", "flush", "commit",
+		"begin", prepare, "[5 n5]", "flush", "commit",
+		"exec UPDATE STATISTICS dbo.Orders",
+	}
+	if got := events(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestMSSQLWriterAbort(t *testing.T) {
+	db, events := openRecordDB(t)
+	m := &mssqlWriter{opts: mssqlOptions{tableOptions: tableOptions{CommitEvery: 2}}, table: "dbo.Orders", db: db}
+	if err := m.WriteHeader([]column{{Name: "id", DBType: "BIGINT"}}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 3; i++ {
+		m.WriteRow([]any{int64(i)})
+	}
+	m.Abort()
+	// the first batch stays committed, the open one is rolled back unflushed
+	got := events()
+	if n := len(got); n < 2 || got[n-2] != "[3]" || got[n-1] != "rollback" || strings.Count(strings.Join(got, " "), "commit") != 1 {
+		t.Errorf("events %v", got)
+	}
+}
+
+func TestBulkValue(t *testing.T) {
+	for _, tc := range []struct {
+		c    column
+		v    any
+		want any
+	}{
+		{column{DBType: "NVARCHAR"}, []byte("Åsa"), "Åsa"},
+		{column{DBType: "DECIMAL", Precision: 10, Scale: 2}, []byte("12.34"), "12.34"},
+		{column{DBType: "VARBINARY"}, []byte{0, 1}, []byte{0, 1}},
+		{column{DBType: "UNIQUEIDENTIFIER"}, []byte{1, 2}, []byte{1, 2}},
+		{column{DBType: "BIGINT"}, int64(7), int64(7)},
+	} {
+		if got := bulkValue(tc.c, tc.v); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s %v: got %#v", tc.c.DBType, tc.v, got)
+		}
+	}
+}
+
+func TestMSSQLLogin(t *testing.T) {
+	c := &config{User: "loader", PasswordEnv: "LOADER_PASSWORD"}
+	if a := c.mssqlLogin(); a.User != "loader" || a.PasswordEnv != "LOADER_PASSWORD" || a.method() != authSQL {
+		t.Errorf("source login not used: %+v", a)
+	}
+	c.MSSQL.Auth = authOptions{Method: authAzureAD, Credential: "managed_identity"}
+	if a := c.mssqlLogin(); a != c.MSSQL.Auth {
+		t.Errorf("mssql.auth not used: %+v", a)
+	}
+
+	c.MSSQL.Auth = authOptions{Method: authAzureAD, User: "loader"}
+	if _, err := newMSSQLWriter("db01/Sales/dbo.Orders", c.MSSQL, c.mssqlLogin()); err == nil || !strings.Contains(err.Error(), "mssql azure_ad login takes no user") {
+		t.Errorf("got %v", err)
+	}
+}
+
+func TestMSSQLReplayable(t *testing.T) {
+	c := &config{}
+	if !c.replayable("mssql://db01/Sales/dbo.Orders") {
+		t.Error("a load in one transaction is not replayable")
+	}
+	// batches committed before a failure would be loaded again
+	c.MSSQL.CommitEvery = 1000
+	if c.replayable("mssql://db01/Sales/dbo.Orders") {
+		t.Error("a load committing every 1000 rows is replayable")
+	}
+}
@@ -0,0 +1,109 @@
+package extract
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+
+	"golang.org/x/time/rate"
+)
+
+// ioOptions keep a run from starving the other services of a shared host.
+type ioOptions struct {
+	// Background lowers the CPU and I/O priority of the process.
+	Background bool `yaml:"background"`
+	// WriteRate caps the bytes per second the jobs write to files together.
+	WriteRate byteSize `yaml:"write_rate"`
+	// Direct writes local files bypassing the page cache, where supported.
+	Direct bool `yaml:"direct"`
+}
+
+// throttleChunk is the most a throttled write hands to the file at once.
+const throttleChunk = 64 << 10
+
+// backgroundOnce lowers the priority once, however many runs a process makes.
+var backgroundOnce sync.Once
+
+// startBackground lowers the priority of the process if the configuration
+// asks for it. Failing to is logged and the run goes on.
+func (c *config) startBackground() {
+	if !c.IO.Background {
+		return
+	}
+	backgroundOnce.Do(func() {
+		if err := lowerPriority(); err != nil {
+			log.Printf("Warning: could not lower the process priority: %v\n", err)
+			return
+		}
+		log.Println("Running with background CPU and I/O priority")
+	})
+}
+
+// newWriteLimiter returns the limiter shared by the jobs of a run, or nil.
+func (c *config) newWriteLimiter() *rate.Limiter {
+	if c.IO.WriteRate <= 0 {
+		return nil
+	}
+	return rate.NewLimiter(rate.Limit(c.IO.WriteRate), throttleChunk)
+}
+
+// writeRate returns the bytes per second the job writing outFile may write
+// on its own, or 0.
+func (c *config) writeRate(outFile string) byteSize {
+	e, _ := c.extract(outFile)
+	return e.WriteRate
+}
+
+// throttle returns w slowed down to the write rates of the run and of the
+// job writing outFile, or w itself if neither is set.
+func (c *config) throttle(w io.Writer, outFile string) io.Writer {
+	var limiters []*rate.Limiter
+	if c.writeLimiter != nil {
+		limiters = append(limiters, c.writeLimiter)
+	}
+	if r := c.writeRate(outFile); r > 0 {
+		limiters = append(limiters, rate.NewLimiter(rate.Limit(r), throttleChunk))
+	}
+	if len(limiters) == 0 {
+		return w
+	}
+	return &throttledWriter{w: w, limiters: limiters}
+}
+
+func validateIO(c *config) error {
+	if c.IO.WriteRate < 0 {
+		return fmt.Errorf("io write_rate cannot be negative\n")
+	}
+	for _, e := range c.Extracts {
+		if e.WriteRate < 0 {
+			return fmt.Errorf("Extract %s write_rate cannot be negative\n", e.Name)
+		}
+	}
+	return nil
+}
+
+// throttledWriter writes to w no faster than every one of its limiters
+// allows, in chunks of at most throttleChunk bytes.
+type throttledWriter struct {
+	w        io.Writer
+	limiters []*rate.Limiter
+}
+
+func (t *throttledWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := min(len(p), throttleChunk)
+		for _, l := range t.limiters {
+			l.WaitN(context.Background(), n)
+		}
+		m, err := t.w.Write(p[:n])
+		written += m
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
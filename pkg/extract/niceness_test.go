@@ -0,0 +1,30 @@
+package extract
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestThrottle(t *testing.T) {
+	c := &config{Extracts: []extract{{Name: "orders", OutFile: "orders.csv", WriteRate: 1 << 20}}}
+	var buf bytes.Buffer
+	if w := c.throttle(&buf, "other.csv"); w != &buf {
+		t.Error("throttled a job without a write rate")
+	}
+	// the first chunk is free, the next two take 64KiB at 1MiB/s each
+	w := c.throttle(&buf, "orders.csv")
+	start := time.Now()
+	n, err := w.Write(make([]byte, 3*throttleChunk))
+	if err != nil || n != 3*throttleChunk || buf.Len() != n {
+		t.Fatalf("wrote %d of %d bytes: %v", buf.Len(), n, err)
+	}
+	if d := time.Since(start); d < 100*time.Millisecond {
+		t.Errorf("wrote 192KiB at 1MiB/s in %v", d)
+	}
+
+	c.Extracts[0].WriteRate = -1
+	if err := validateIO(c); err == nil {
+		t.Error("accepted a negative write_rate")
+	}
+}
@@ -0,0 +1,124 @@
+package extract
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/scritchley/orc"
+)
+
+// orcOptions holds the settings specific to format: orc.
+type orcOptions struct {
+	// StripeSize is the target stripe size, e.g. 64MiB; zero keeps the library default.
+	StripeSize byteSize `yaml:"stripe_size"`
+	// Compression is one of zlib (default) or none.
+	Compression string `yaml:"compression"`
+}
+
+// orcWriter writes the result set as an ORC file. Decimal and binary columns
+// are stored as strings because the ORC library has no writer for them.
+type orcWriter struct {
+	w      io.Writer
+	opts   []orc.WriterConfigFunc
+	cols   []column
+	kinds  []valueKind
+	ow     *orc.Writer
+	record []any
+}
+
+func newORCWriter(w io.Writer, o orcOptions) (*orcWriter, error) {
+	var opts []orc.WriterConfigFunc
+	switch o.Compression {
+	case "", "zlib":
+		opts = append(opts, orc.SetCompression(orc.CompressionZlib{}))
+	case "none":
+		opts = append(opts, orc.SetCompression(orc.CompressionNone{}))
+	default:
+		return nil, fmt.Errorf("Unsupported orc compression '%s'\n", o.Compression)
+	}
+	if o.StripeSize < 0 {
+		return nil, fmt.Errorf("The orc stripe_size must not be negative\n")
+	}
+	if o.StripeSize > 0 {
+		opts = append(opts, orc.SetStripeTargetSize(int64(o.StripeSize)))
+	}
+	return &orcWriter{w: w, opts: opts}, nil
+}
+
+// orcCategory maps a column to the ORC type used to store it.
+func orcCategory(k valueKind) orc.Category {
+	switch k {
+	case kindInt:
+		return orc.CategoryLong
+	case kindFloat:
+		return orc.CategoryDouble
+	case kindBool:
+		return orc.CategoryBoolean
+	case kindDate:
+		return orc.CategoryDate
+	case kindTimestamp:
+		return orc.CategoryTimestamp
+	default:
+		return orc.CategoryString
+	}
+}
+
+func (o *orcWriter) WriteHeader(cols []column) error {
+	fields := make([]orc.TypeDescriptionTransformFunc, 0, len(cols)+1)
+	fields = append(fields, orc.SetCategory(orc.CategoryStruct))
+	o.kinds = make([]valueKind, len(cols))
+	for i, c := range cols {
+		o.kinds[i] = c.kind()
+		fields = append(fields, orc.AddField(c.Name, orc.SetCategory(orcCategory(o.kinds[i]))))
+	}
+	schema, err := orc.NewTypeDescription(fields...)
+	if err != nil {
+		return err
+	}
+
+	ow, err := orc.NewWriter(o.w, append(o.opts, orc.SetSchema(schema))...)
+	if err != nil {
+		return err
+	}
+	o.ow = ow
+	o.cols = cols
+	o.record = make([]any, len(cols))
+	return nil
+}
+
+func (o *orcWriter) WriteRow(values []any) error {
+	for i, v := range values {
+		value, err := o.convert(o.kinds[i], v)
+		if err != nil {
+			return fmt.Errorf("column %s: %v", o.cols[i].Name, err)
+		}
+		o.record[i] = value
+	}
+	return o.ow.Write(o.record...)
+}
+
+// convert returns v as the Go type expected by the ORC column writer.
+func (o *orcWriter) convert(k valueKind, v any) (any, error) {
+	if v == nil {
+		return nil, nil
+	}
+	switch orcCategory(k) {
+	case orc.CategoryLong:
+		return asInt64(v)
+	case orc.CategoryDouble:
+		return asFloat64(v)
+	case orc.CategoryBoolean:
+		return asBool(v)
+	case orc.CategoryDate, orc.CategoryTimestamp:
+		return asTime(v)
+	default:
+		return formatValue(v), nil
+	}
+}
+
+func (o *orcWriter) Close() error {
+	if o.ow == nil {
+		return nil
+	}
+	return o.ow.Close()
+}
@@ -0,0 +1,104 @@
+package extract
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// fileTemplate is what outfile templates see of the run: the values of query
+// templates, the job's name and the run's ID, such as
+//
+//	//share/extracts/{{ .QueryName }}_{{ .Date "2006-01-02" }}.csv
+type fileTemplate struct {
+	runTemplate
+	QueryName string
+	RunID     string
+}
+
+// Date returns the time the run started in layout.
+func (t fileTemplate) Date(layout string) string { return t.RunTime.Format(layout) }
+
+// newRunID returns the ID of a run started at started: its UTC start time
+// and a random suffix, so IDs sort by start and are safe in file names.
+func newRunID(started time.Time) string {
+	b := make([]byte, 3)
+	rand.Read(b)
+	return started.UTC().Format("20060102T150405Z") + "-" + hex.EncodeToString(b)
+}
+
+// outPaths holds the files the jobs of a run write, keyed by outfile, for
+// the jobs whose outfile is a template.
+type outPaths struct {
+	mu    sync.Mutex
+	paths map[string]string
+}
+
+func (p *outPaths) get(outFile string) (string, bool) {
+	if p == nil {
+		return "", false
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	path, ok := p.paths[outFile]
+	return path, ok
+}
+
+func (p *outPaths) set(outFile, path string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.paths[outFile] = path
+}
+
+// parseOutFileTemplate parses the template of an outfile.
+func parseOutFileTemplate(outFile string) (*template.Template, error) {
+	tmpl, err := template.New(outFile).Option("missingkey=error").Parse(outFile)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid template in the outfile %s: %v\n", outFile, err)
+	}
+	return tmpl, nil
+}
+
+// renderOutFiles resolves the outfile templates for the run, which has
+// started, and logs the file each of those jobs writes. A rendered file goes
+// through the output policy and its tenant's root again, so a template
+// cannot render its way out of them.
+func (c *config) renderOutFiles() error {
+	paths := &outPaths{paths: map[string]string{}}
+	for _, outFile := range c.OutFiles {
+		if !isTemplate(outFile) {
+			continue
+		}
+		tmpl, err := parseOutFileTemplate(outFile)
+		if err != nil {
+			return err
+		}
+		t, err := newRunTemplate(c, outFile)
+		if err != nil {
+			return err
+		}
+		var b strings.Builder
+		if err := tmpl.Execute(&b, fileTemplate{runTemplate: t, QueryName: c.jobName(outFile), RunID: c.runID}); err != nil {
+			return fmt.Errorf("Could not render the outfile %s: %v\n", outFile, err)
+		}
+		path := b.String()
+		if strings.TrimSpace(path) == "" {
+			return fmt.Errorf("The outfile %s renders empty\n", outFile)
+		}
+		if err := c.OutputPolicy.validate([]string{path}); err != nil {
+			return err
+		}
+		if err := c.checkTenantRoot(outFile, path); err != nil {
+			return err
+		}
+		paths.set(outFile, path)
+		log.Printf("%s writes %s\n", c.jobName(outFile), path)
+	}
+	c.paths = paths
+	return nil
+}
@@ -0,0 +1,67 @@
+package extract
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRenderOutFiles(t *testing.T) {
+	dir := t.TempDir()
+	outFile := filepath.Join(dir, `{{ .QueryName }}_{{ .Date "2006-01-02" }}_FY{{ .FiscalYear }}.csv`)
+	c := &config{
+		Extracts:        []extract{{Name: "orders", Query: "numbers", OutFile: outFile}},
+		Queries:         []string{"numbers"},
+		OutFiles:        []string{outFile},
+		FiscalYearStart: 7,
+		Compression:     "gzip",
+		started:         time.Date(2025, time.January, 9, 14, 30, 0, 0, time.UTC),
+	}
+	c.runID = newRunID(c.started)
+	if !regexp.MustCompile(`^20250109T143000Z-[0-9a-f]{6}$`).MatchString(c.runID) {
+		t.Errorf("run ID %s", c.runID)
+	}
+	if err := c.renderOutFiles(); err != nil {
+		t.Fatal(err)
+	}
+	want := filepath.Join(dir, "orders_2025-01-09_FY2025.csv.gz")
+	if got := c.outputPath(outFile); got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+
+	c.Compression = ""
+	registerFake("numbers", &fakeQuery{sets: []*fakeResult{numbersResult(2)}})
+	if _, err := exportFake(t, c, "numbers", outFile); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(strings.TrimSuffix(want, ".gz")); err != nil {
+		t.Errorf("the rendered file was not written: %v", err)
+	}
+
+	c.OutFiles[0] = filepath.Join(dir, "{{ .Date }}.csv")
+	if err := c.renderOutFiles(); err == nil {
+		t.Error("rendered a Date without a layout")
+	}
+
+	// a rendered file is held to the output policy and the tenant's root
+	c.OutFiles[0] = dir + "/{{ .RunID }}/../../escaped.csv"
+	c.OutputPolicy = outputPolicy{Allow: []string{filepath.Join(dir, "*")}}
+	if err := c.renderOutFiles(); err == nil || !strings.Contains(err.Error(), "not allowed by output_policy") {
+		t.Errorf("got %v, want the output policy", err)
+	}
+	c.OutputPolicy = outputPolicy{}
+	c.Tenants = map[string]tenantOptions{"acme": {Root: dir}}
+	c.JobTenants = map[string]string{c.OutFiles[0]: "acme"}
+	if err := c.renderOutFiles(); err == nil || !strings.Contains(err.Error(), "outside the root") {
+		t.Errorf("got %v, want the tenant root", err)
+	}
+	c.Tenants, c.JobTenants = nil, nil
+
+	c.OutFiles[0] = filepath.Join(dir, "{{ .RunID }.csv")
+	if err := c.validate(); err == nil || !strings.Contains(err.Error(), "Invalid template in the outfile") {
+		t.Errorf("got %v, want the invalid template", err)
+	}
+}
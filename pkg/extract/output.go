@@ -0,0 +1,182 @@
+package extract
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+)
+
+// output is a rowWriter bound to a destination. Close commits the result to
+// the destination; Abort discards it and is a no-op once Close has succeeded.
+type output interface {
+	rowWriter
+	Abort()
+}
+
+// openOutput prepares the destination named by outFile. Plain paths are local
+// or network files; a scheme prefix such as delta:// selects another destination.
+func openOutput(c *config, outFile string) (output, error) {
+	if table, ok := strings.CutPrefix(outFile, "delta://"); ok {
+		return newDeltaWriter(table, c.Delta)
+	}
+	if table, ok := strings.CutPrefix(outFile, "iceberg://"); ok {
+		return newIcebergWriter(table, c.Iceberg)
+	}
+	if target, ok := strings.CutPrefix(outFile, "bigquery://"); ok {
+		return newBigQueryWriter(target, c.BigQuery, c.Abort)
+	}
+	if table, ok := strings.CutPrefix(outFile, "snowflake://"); ok {
+		return newSnowflakeWriter(table, c.Snowflake, c.Abort)
+	}
+	if table, ok := strings.CutPrefix(outFile, "redshift://"); ok {
+		return newRedshiftWriter(table, c.Redshift, c.Abort)
+	}
+	if target, ok := strings.CutPrefix(outFile, "mssql://"); ok {
+		return newMSSQLWriter(target, c.MSSQL, c.mssqlLogin())
+	}
+	if table, ok := strings.CutPrefix(outFile, "postgres://"); ok {
+		return newPostgresWriter(table, c.Postgres)
+	}
+	if dest, ok := strings.CutPrefix(outFile, "loader://"); ok {
+		return newLoaderOutput(c, dest, outFile)
+	}
+
+	path := c.outputPath(outFile)
+	if isRemoteFile(outFile) {
+		upload, err := openUpload(c, path)
+		if err != nil {
+			return nil, err
+		}
+		o := &remoteOutput{upload: upload, count: &countingWriter{w: c.throttle(upload, outFile)}}
+		if o.compressor, o.rowWriter, err = newFileEncoder(c, o.count, path, outFile); err != nil {
+			o.Abort()
+			return nil, err
+		}
+		return o, nil
+	}
+	if isPipe(path) {
+		f, w, err := c.openPipe(path, outFile)
+		if err != nil {
+			return nil, fmt.Errorf("Could not open pipe %s: %v\n", path, err)
+		}
+		o := &fileOutput{f: f, pipe: true, count: &countingWriter{w: c.throttle(w, outFile)}, path: path, policy: c.Abort}
+		if o.compressor, o.rowWriter, err = newFileEncoder(c, o.count, path, outFile); err != nil {
+			o.Abort()
+			return nil, err
+		}
+		return o, nil
+	}
+	f, w, direct, err := c.createFile(path+partSuffix, outFile)
+	if err != nil {
+		return nil, fmt.Errorf("Could not create file %s: %v\n", path+partSuffix, err)
+	}
+	o := &fileOutput{f: f, direct: direct, count: &countingWriter{w: c.throttle(w, outFile)}, path: path, part: path + partSuffix, policy: c.Abort}
+	if o.compressor, o.rowWriter, err = newFileEncoder(c, o.count, path, outFile); err != nil {
+		o.Abort()
+		return nil, err
+	}
+	return o, nil
+}
+
+// newFileEncoder returns the writer of the job writing outFile into w, the
+// file at path, and its compressor if it is compressed. The compressor is
+// returned even when the writer cannot be created, so that it is closed.
+func newFileEncoder(c *config, w io.Writer, path, outFile string) (io.WriteCloser, rowWriter, error) {
+	compressor, err := newCompressor(c.compression(outFile), w)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Could not compress %s: %v\n", path, err)
+	}
+	if compressor != nil {
+		w = compressor
+	}
+	rw, err := newRowWriter(c, w, outFile)
+	return compressor, rw, err
+}
+
+// fileOutput writes the formatted result to a single file, through
+// compressor if it is compressed. count is what reaches the file. A file is
+// written as part and renamed to path by Close.
+type fileOutput struct {
+	rowWriter
+	f          *os.File
+	direct     *directWriter
+	compressor io.WriteCloser
+	count      *countingWriter
+	path       string
+	part       string
+	policy     string
+	closed     bool
+	// pipe is set when path is a pipe, which is never removed or renamed.
+	pipe bool
+}
+
+func (o *fileOutput) bytesWritten() int64 {
+	return o.count.n.Load()
+}
+
+// Close finishes the output. Some writers close the file themselves, so an
+// already closed file is not an error. A file that cannot be finished is
+// handled like an aborted one.
+func (o *fileOutput) Close() error {
+	o.closed = true
+	if err := o.rowWriter.Close(); err != nil {
+		o.f.Close()
+		o.discard()
+		return err
+	}
+	if o.compressor != nil {
+		if err := o.compressor.Close(); err != nil {
+			o.f.Close()
+			o.discard()
+			return fmt.Errorf("could not finish the compressed file: %v", err)
+		}
+	}
+	if o.direct != nil {
+		if err := o.direct.Close(); err != nil {
+			o.f.Close()
+			o.discard()
+			return err
+		}
+	}
+	if err := o.f.Close(); err != nil && !errors.Is(err, os.ErrClosed) {
+		o.discard()
+		return err
+	}
+	if o.part != "" {
+		if err := os.Rename(o.part, o.path); err != nil {
+			o.discard()
+			return err
+		}
+	}
+	return nil
+}
+
+// Abort closes the file and deletes, marks or keeps what was written so far
+// according to the abort policy. The last complete file is left as it was.
+func (o *fileOutput) Abort() {
+	if !o.closed {
+		o.closed = true
+		if o.compressor != nil {
+			// a kept or marked file still ends in a readable stream
+			o.compressor.Close()
+		}
+		if o.direct != nil {
+			o.direct.Close()
+		}
+		o.f.Close()
+		o.discard()
+	}
+}
+
+// discard handles what was written so far according to the abort policy.
+// The reader of a pipe has read it already, and sees the stream end early.
+func (o *fileOutput) discard() {
+	if o.pipe {
+		log.Printf("Warning: the reader of pipe %s got a partial stream\n", o.path)
+		return
+	}
+	discardPart(o.part, o.path, o.policy)
+}
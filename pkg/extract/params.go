@@ -0,0 +1,208 @@
+package extract
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// paramName is the name of a query parameter, referenced as @name.
+var paramName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// paramFlag collects the -param name=value flags.
+type paramFlag map[string]string
+
+func (p paramFlag) String() string {
+	var pairs []string
+	for name, value := range p {
+		pairs = append(pairs, name+"="+value)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func (p paramFlag) Set(s string) error {
+	name, value, ok := strings.Cut(s, "=")
+	if !ok || !paramName.MatchString(name) {
+		return fmt.Errorf("want name=value, got %q", s)
+	}
+	p[name] = value
+	return nil
+}
+
+// paramsFlag defines the repeatable -param flag of the run commands.
+func paramsFlag(fs *flag.FlagSet) paramFlag {
+	p := paramFlag{}
+	fs.Var(p, "param", "Set the query parameter name of every extract declaring it, as name=value. Repeat for more.")
+	return p
+}
+
+// validateParams checks the params of the extracts. Only the SQL Server
+// driver binds named parameters.
+func (c *config) validateParams() error {
+	d, err := c.sourceDriver()
+	for _, e := range c.Extracts {
+		if len(e.Params) == 0 {
+			continue
+		}
+		if err == nil && d.name != "sqlserver" {
+			return fmt.Errorf("Extract %s has params, which only the sqlserver driver supports\n", e.Name)
+		}
+		for name := range e.Params {
+			if !paramName.MatchString(name) {
+				return fmt.Errorf("Extract %s has a param named '%s', which is not a valid parameter name\n", e.Name, name)
+			}
+		}
+	}
+	return nil
+}
+
+// setParams sets the params given on the command line. A flag naming no
+// param of any extract is an error.
+func (c *config) setParams(flags paramFlag) error {
+	for name, value := range flags {
+		found := false
+		for _, e := range c.Extracts {
+			if _, ok := e.Params[name]; ok {
+				e.Params[name] = value
+				found = true
+			}
+		}
+		if !found {
+			return fmt.Errorf("No extract has a param named %s\n", name)
+		}
+	}
+	return nil
+}
+
+// envReference is a ${NAME} environment variable in a param value.
+var envReference = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// expandParams expands the ${NAME} environment variables in the configured
+// params. An unset variable is an error.
+func (c *config) expandParams(getenv func(string) (string, bool)) error {
+	for _, e := range c.Extracts {
+		for name, value := range e.Params {
+			var missing string
+			e.Params[name] = envReference.ReplaceAllStringFunc(value, func(ref string) string {
+				v := ref[2 : len(ref)-1]
+				s, ok := getenv(v)
+				if !ok && missing == "" {
+					missing = v
+				}
+				return s
+			})
+			if missing != "" {
+				return fmt.Errorf("The param %s of extract %s uses ${%s}, which is not set\n", name, e.Name, missing)
+			}
+		}
+	}
+	return nil
+}
+
+// queryArgs returns the params of the job writing outFile as named
+// arguments, in name order, followed by the watermark of an incremental job.
+func (c *config) queryArgs(outFile string) []any {
+	e, ok := c.extract(outFile)
+	if !ok {
+		return nil
+	}
+	names := make([]string, 0, len(e.Params))
+	for name := range e.Params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var args []any
+	for _, name := range names {
+		args = append(args, sql.Named(name, e.Params[name]))
+	}
+	if arg, ok := c.watermarkArg(outFile); ok {
+		args = append(args, arg)
+	}
+	return args
+}
+
+// boundArg is a value a query template binds as a parameter of the query
+// instead of writing it into the SQL, where a captured value could change the
+// statement. A nil Value is NULL.
+type boundArg struct {
+	Name  string  `json:"name"`
+	Value *string `json:"value"`
+}
+
+// templateArgs collects the values bound while one query template renders.
+// SQL Server binds a value once as @name; other drivers take it by position.
+type templateArgs struct {
+	placeholder func(n int) string
+	args        []boundArg
+	refs        map[string]string
+}
+
+func newTemplateArgs(c *config) *templateArgs {
+	d, _ := c.sourceDriver()
+	return &templateArgs{placeholder: d.placeholder, refs: map[string]string{}}
+}
+
+// bind binds value under name and returns the placeholder referencing it. A
+// name bound before is referenced again, unless the driver's placeholders
+// are all ? and so only ever match one argument.
+func (b *templateArgs) bind(name string, value *string) string {
+	if ref, ok := b.refs[name]; ok && ref != "?" {
+		return ref
+	}
+	b.args = append(b.args, boundArg{Name: name, Value: value})
+	ref := "@" + name
+	if b.placeholder != nil {
+		ref = b.placeholder(len(b.args))
+	}
+	b.refs[name] = ref
+	return ref
+}
+
+// boundArgs holds the arguments the query templates of a run bound, keyed by
+// outfile.
+type boundArgs struct {
+	mu   sync.Mutex
+	args map[string][]boundArg
+}
+
+func (b *boundArgs) get(outFile string) []boundArg {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.args[outFile]
+}
+
+func (b *boundArgs) set(outFile string, args []boundArg) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.args[outFile] = args
+}
+
+// jobArgs returns the arguments of the query of the job writing outFile: its
+// params and watermark, then the values its template bound.
+func (c *config) jobArgs(outFile string) []any {
+	args := c.queryArgs(outFile)
+	d, _ := c.sourceDriver()
+	for _, a := range c.bound.get(outFile) {
+		var v any
+		if a.Value != nil {
+			v = *a.Value
+		}
+		if d.placeholder == nil {
+			args = append(args, sql.Named(a.Name, v))
+		} else {
+			args = append(args, v)
+		}
+	}
+	return args
+}
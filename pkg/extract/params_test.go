@@ -0,0 +1,72 @@
+package extract
+
+import (
+	"database/sql"
+	"flag"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParams(t *testing.T) {
+	data := `extracts:
+  - name: orders
+    query: SELECT * FROM dbo.Orders WHERE OrderDate >= @start_date AND Region = @region
+    outfile: orders.csv
+    params:
+      start_date: "2025-01-01"
+      region: ${REGION}
+  - name: returns
+    query: SELECT * FROM dbo.Returns WHERE ReturnDate >= @start_date
+    outfile: returns.csv
+    params:
+      start_date: "2025-01-01"
+`
+	c, _, err := parseConfigDocs([]configDoc{{"config.yaml", []byte(data)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := map[string]string{"REGION": "EMEA"}
+	getenv := func(name string) (string, bool) { v, ok := env[name]; return v, ok }
+	if err := c.expandParams(getenv); err != nil {
+		t.Fatal(err)
+	}
+	fs := flag.NewFlagSet("run", flag.ContinueOnError)
+	flags := paramsFlag(fs)
+	if err := fs.Parse([]string{"-param", "start_date=2025-02-01"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.setParams(flags); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.validate(); err != nil {
+		t.Fatal(err)
+	}
+	want := []any{sql.Named("region", "EMEA"), sql.Named("start_date", "2025-02-01")}
+	if got := c.queryArgs("orders.csv"); !reflect.DeepEqual(got, want) {
+		t.Errorf("orders: got %v, want %v", got, want)
+	}
+	if got := c.queryArgs("returns.csv"); !reflect.DeepEqual(got, want[1:]) {
+		t.Errorf("returns: got %v, want %v", got, want[1:])
+	}
+	if cacheKey(c, c.Queries[1], "returns.csv") == cacheKey(&config{Extracts: []extract{{OutFile: "returns.csv"}}}, c.Queries[1], "returns.csv") {
+		t.Error("the params do not change the cache key")
+	}
+
+	if err := c.setParams(paramFlag{"end_date": "2025-03-01"}); err == nil || !strings.Contains(err.Error(), "No extract has a param named end_date") {
+		t.Errorf("unknown param: got %v", err)
+	}
+	delete(env, "REGION")
+	c.Extracts[0].Params["region"] = "${REGION}"
+	if err := c.expandParams(getenv); err == nil || !strings.Contains(err.Error(), "${REGION}, which is not set") {
+		t.Errorf("unset variable: got %v", err)
+	}
+	if err := flags.Set("1st=x"); err == nil {
+		t.Error("accepted an invalid param name")
+	}
+	c.Extracts[0].Params["region"] = "EMEA"
+	c.Driver, c.User = "postgres", "etl"
+	if err := c.validate(); err == nil || !strings.Contains(err.Error(), "only the sqlserver driver") {
+		t.Errorf("postgres params: got %v", err)
+	}
+}
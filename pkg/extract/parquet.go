@@ -0,0 +1,95 @@
+package extract
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/apache/arrow-go/v18/arrow/memory"
+	"github.com/apache/arrow-go/v18/parquet"
+	"github.com/apache/arrow-go/v18/parquet/compress"
+	"github.com/apache/arrow-go/v18/parquet/pqarrow"
+)
+
+// parquetOptions holds the settings specific to format: parquet.
+type parquetOptions struct {
+	// Compression is one of snappy (default), gzip, zstd or none.
+	Compression string `yaml:"compression"`
+}
+
+// parquetCodec maps a compression name to the Parquet codec.
+func parquetCodec(name string) (compress.Compression, error) {
+	switch name {
+	case "", "snappy":
+		return compress.Codecs.Snappy, nil
+	case "gzip":
+		return compress.Codecs.Gzip, nil
+	case "zstd":
+		return compress.Codecs.Zstd, nil
+	case "none":
+		return compress.Codecs.Uncompressed, nil
+	default:
+		return compress.Codecs.Uncompressed, fmt.Errorf("Unsupported parquet compression '%s'\n", name)
+	}
+}
+
+// parquetWriter writes the result set as a Parquet file with one row group per
+// record batch.
+type parquetWriter struct {
+	w     io.Writer
+	codec compress.Compression
+	// fieldIDs, when set, are written as the field ids of the columns.
+	fieldIDs []int
+	b        *recordBatcher
+	fw       *pqarrow.FileWriter
+}
+
+func newParquetWriter(w io.Writer, compression string) (*parquetWriter, error) {
+	codec, err := parquetCodec(compression)
+	if err != nil {
+		return nil, err
+	}
+	return &parquetWriter{w: w, codec: codec}, nil
+}
+
+func (p *parquetWriter) WriteHeader(cols []column) error {
+	p.b = newRecordBatcherWithIDs(cols, p.fieldIDs)
+	props := parquet.NewWriterProperties(
+		parquet.WithAllocator(memory.DefaultAllocator),
+		parquet.WithCompression(p.codec),
+	)
+	fw, err := pqarrow.NewFileWriter(p.b.schema, p.w, props, pqarrow.DefaultWriterProps())
+	if err != nil {
+		return err
+	}
+	p.fw = fw
+	return nil
+}
+
+func (p *parquetWriter) WriteRow(values []any) error {
+	full, err := p.b.add(values)
+	if err != nil || !full {
+		return err
+	}
+	return p.flush()
+}
+
+// flush writes the buffered rows as one row group.
+func (p *parquetWriter) flush() error {
+	rec := p.b.batch()
+	if rec == nil {
+		return nil
+	}
+	defer rec.Release()
+	return p.fw.Write(rec)
+}
+
+func (p *parquetWriter) Close() error {
+	if p.fw == nil {
+		return nil
+	}
+	defer p.b.release()
+	if err := p.flush(); err != nil {
+		return err
+	}
+	return p.fw.Close()
+}
@@ -0,0 +1,48 @@
+package extract
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+	"github.com/apache/arrow-go/v18/parquet"
+	"github.com/apache/arrow-go/v18/parquet/pqarrow"
+)
+
+func TestExportDataParquet(t *testing.T) {
+	registerFake("numbers", &fakeQuery{sets: []*fakeResult{numbersResult(3)}})
+	out := filepath.Join(t.TempDir(), "out.parquet")
+	c := &config{Format: "parquet", Parquet: parquetOptions{Compression: "zstd"}}
+	if _, err := exportFake(t, c, "numbers", out); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	table, err := pqarrow.ReadTable(context.Background(), f, parquet.NewReaderProperties(memory.DefaultAllocator), pqarrow.ArrowReadProperties{}, memory.DefaultAllocator)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer table.Release()
+	if table.NumRows() != 3 {
+		t.Errorf("got %d rows, want 3", table.NumRows())
+	}
+	schema := table.Schema()
+	if schema.Field(0).Name != "id" || !arrow.TypeEqual(schema.Field(0).Type, arrow.PrimitiveTypes.Int64) {
+		t.Errorf("id column: got %v", schema.Field(0))
+	}
+	if !arrow.TypeEqual(schema.Field(1).Type, arrow.BinaryTypes.String) {
+		t.Errorf("name column: got %v", schema.Field(1))
+	}
+
+	c = &config{Format: "parquet", Parquet: parquetOptions{Compression: "brotli"}}
+	if _, err := exportFake(t, c, "numbers", out); err == nil {
+		t.Error("an unsupported compression was accepted")
+	}
+}
@@ -0,0 +1,382 @@
+package extract
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// partitionOptions splits a job's query into ranges of an integer column
+// that are read in parallel on separate connections. Rows whose column is
+// NULL are read by one more query of their own.
+type partitionOptions struct {
+	Column string `yaml:"column"`
+	Count  int    `yaml:"count"`
+	// Ordered writes the rows ordered by Column, NULLs first, and then by
+	// OrderBy, instead of as they arrive.
+	Ordered bool     `yaml:"ordered"`
+	OrderBy []string `yaml:"order_by"`
+}
+
+// orderedReadAhead is how many rows each range of an ordered job reads
+// ahead of the range being written.
+const orderedReadAhead = 4096
+
+var plainIdentifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+func (o partitionOptions) validate(outFile string) error {
+	if !plainIdentifier.MatchString(o.Column) {
+		return fmt.Errorf("Partition column '%s' for %s must be a plain column name\n", o.Column, outFile)
+	}
+	if o.Count < 1 {
+		return fmt.Errorf("Partitions for %s need a count of at least 1\n", outFile)
+	}
+	for _, name := range o.OrderBy {
+		if !plainIdentifier.MatchString(name) {
+			return fmt.Errorf("Partition order column '%s' for %s must be a plain column name\n", name, outFile)
+		}
+	}
+	if len(o.OrderBy) > 0 && !o.Ordered {
+		return fmt.Errorf("Partitions for %s have order_by but are not ordered\n", outFile)
+	}
+	return nil
+}
+
+// resultSet is a query result as exportData reads it, scanning into *any.
+type resultSet interface {
+	columns() ([]column, error)
+	Next() bool
+	Scan(dest ...any) error
+	Err() error
+	Close() error
+}
+
+// sqlResult is a plain query result.
+type sqlResult struct{ *sql.Rows }
+
+func (r sqlResult) columns() ([]column, error) {
+	types, err := r.ColumnTypes()
+	if err != nil {
+		return nil, err
+	}
+	return newColumns(types), nil
+}
+
+// openResult runs the job's query, reuses its recent result with -cache or
+// replays its recording with -replay. With -record the result is recorded.
+func openResult(ctx context.Context, db *sql.DB, c *config, query, outFile string) (resultSet, error) {
+	if c.replayDir != "" {
+		return openReplay(c, query, outFile)
+	}
+	var res resultSet
+	var err error
+	if c.cacheTTL > 0 {
+		res, err = openCached(ctx, db, c, query, outFile)
+	} else {
+		res, err = queryResult(ctx, db, c, query, outFile)
+	}
+	if err != nil || c.recordDir == "" {
+		return res, err
+	}
+	return recordResult(c, query, outFile, res), nil
+}
+
+// queryResult runs the job's query, partitioned if it is configured so. Row
+// limits apply to the whole result, so limited runs are not partitioned.
+func queryResult(ctx context.Context, db *sql.DB, c *config, query, outFile string) (resultSet, error) {
+	if o, ok := c.Partitions[outFile]; ok && c.limit == 0 {
+		return openPartitioned(ctx, db, c, o, query, c.jobArgs(outFile))
+	}
+	// the server's CPU time is only read for the chargeback file
+	if d, _ := c.sourceDriver(); c.Chargeback != "" && d.name == "sqlserver" {
+		return queryWithCPU(ctx, db, wrapQuery(c, query), c.jobArgs(outFile)...)
+	}
+	rows, err := db.QueryContext(ctx, wrapQuery(c, query), c.jobArgs(outFile)...)
+	if err != nil {
+		return nil, err
+	}
+	return sqlResult{rows}, nil
+}
+
+// keyRange is the unread part of a partition: the keys from next up to, but
+// not including, hi. A reader stops at hi, which a steal can lower while it
+// reads. In ordered jobs the range's rows go to out.
+type keyRange struct {
+	next, hi int64
+	nulls    bool
+	out      chan []any
+}
+
+// partitionedRows merges the rows of a job's partitions as they arrive. Each
+// reader works through the queued ranges, and once they are gone splits the
+// largest range still being read, taking over its upper half. Partitions
+// with few rows thus do not leave their readers idle while others are busy.
+//
+// Ordered jobs merge the ranges rather than interleave them. Every range is
+// read in order and no two ranges share a key, so the merge writes the ranges
+// one after the other in key order, each read ahead while earlier ones are
+// written. A stolen range follows the one it was taken from.
+type partitionedRows struct {
+	parent context.Context
+	ctx    context.Context
+	cancel context.CancelFunc
+	db     *sql.DB
+	c      *config
+	opts   partitionOptions
+	query  string
+	args   []any
+	cols   []column
+	key    int
+
+	rows    chan []any
+	current []any
+	// order lists the ranges of an ordered job by key, pos the one being written.
+	order []*keyRange
+	pos   int
+
+	mu     sync.Mutex
+	queue  []*keyRange
+	active map[*keyRange]bool
+	err    error
+	wg     sync.WaitGroup
+}
+
+func openPartitioned(ctx context.Context, db *sql.DB, c *config, o partitionOptions, query string, args []any) (*partitionedRows, error) {
+	if c.sample > 0 && c.sample < 100 {
+		query = c.dialect.sample(query, c.sample)
+	}
+	p := &partitionedRows{parent: ctx, db: db, c: c, opts: o, query: query, args: args, rows: make(chan []any, 256), active: map[*keyRange]bool{}}
+
+	probe, err := db.QueryContext(ctx, c.dialect.probe(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	types, err := probe.ColumnTypes()
+	probe.Close()
+	if err != nil {
+		return nil, err
+	}
+	p.cols = newColumns(types)
+	p.key = -1
+	for i, col := range p.cols {
+		if strings.EqualFold(col.Name, o.Column) {
+			p.key = i
+		}
+	}
+	if p.key < 0 {
+		return nil, fmt.Errorf("partition column %s is not in the result", o.Column)
+	}
+
+	var lo, hi sql.NullInt64
+	bounds := c.dialect.selectFrom(query, fmt.Sprintf("MIN(%s), MAX(%s)", o.Column, o.Column), "")
+	if err := db.QueryRowContext(ctx, bounds, args...).Scan(&lo, &hi); err != nil {
+		return nil, fmt.Errorf("could not find the range of %s: %v", o.Column, err)
+	}
+	p.queue = append(p.queue, &keyRange{nulls: true})
+	if lo.Valid {
+		if hi.Int64 == math.MaxInt64 {
+			return nil, fmt.Errorf("partition column %s reaches the largest 64-bit integer", o.Column)
+		}
+		p.queue = append(p.queue, splitRange(lo.Int64, hi.Int64+1, o.Count)...)
+	}
+	if o.Ordered {
+		for _, r := range p.queue {
+			r.out = make(chan []any, orderedReadAhead)
+		}
+		p.order = slices.Clone(p.queue)
+	}
+
+	p.ctx, p.cancel = context.WithCancel(ctx)
+	for range o.Count {
+		p.wg.Add(1)
+		go p.read()
+	}
+	go func() {
+		p.wg.Wait()
+		close(p.rows)
+	}()
+	return p, nil
+}
+
+// splitRange divides the keys from lo up to hi into at most n ranges.
+func splitRange(lo, hi int64, n int) []*keyRange {
+	span := uint64(hi - lo)
+	size := max((span+uint64(n)-1)/uint64(n), 1)
+	var ranges []*keyRange
+	for next := lo; next < hi; {
+		end := hi
+		if uint64(hi-next) > size {
+			end = next + int64(size)
+		}
+		ranges = append(ranges, &keyRange{next: next, hi: end})
+		next = end
+	}
+	return ranges
+}
+
+// take returns the next range to read: a queued one, or the upper half of
+// the range with the most keys left to read. It returns nil when no range
+// has two keys left.
+func (p *partitionedRows) take() *keyRange {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.err != nil {
+		return nil
+	}
+	if len(p.queue) > 0 {
+		r := p.queue[0]
+		p.queue = p.queue[1:]
+		p.active[r] = true
+		return r
+	}
+	var victim *keyRange
+	for r := range p.active {
+		if !r.nulls && r.hi-r.next >= 2 && (victim == nil || r.hi-r.next > victim.hi-victim.next) {
+			victim = r
+		}
+	}
+	if victim == nil {
+		return nil
+	}
+	mid := victim.next + (victim.hi-victim.next)/2
+	stolen := &keyRange{next: mid, hi: victim.hi}
+	victim.hi = mid
+	p.active[stolen] = true
+	if p.opts.Ordered {
+		stolen.out = make(chan []any, orderedReadAhead)
+		i := slices.Index(p.order, victim)
+		p.order = slices.Insert(p.order, i+1, stolen)
+	}
+	return stolen
+}
+
+func (p *partitionedRows) read() {
+	defer p.wg.Done()
+	for r := p.take(); r != nil; r = p.take() {
+		err := p.readRange(r)
+		p.mu.Lock()
+		delete(p.active, r)
+		if err != nil && p.err == nil {
+			p.err = err
+			p.cancel()
+		}
+		p.mu.Unlock()
+	}
+}
+
+// readRange reads r in key order until it reaches the end of r, however
+// far a steal moved it.
+func (p *partitionedRows) readRange(r *keyRange) error {
+	out := p.rows
+	if r.out != nil {
+		out = r.out
+		defer close(r.out)
+	}
+	col := p.opts.Column
+	order := strings.Join(append([]string{col}, p.opts.OrderBy...), ", ")
+	p.mu.Lock()
+	where := fmt.Sprintf("WHERE %s >= %d AND %s < %d ORDER BY %s", col, r.next, col, r.hi, order)
+	p.mu.Unlock()
+	if r.nulls {
+		where = fmt.Sprintf("WHERE %s IS NULL", col)
+		if len(p.opts.OrderBy) > 0 {
+			where += " ORDER BY " + strings.Join(p.opts.OrderBy, ", ")
+		}
+	}
+	query := p.c.dialect.selectFrom(p.query, "*", where)
+	rows, err := p.db.QueryContext(p.ctx, query, p.args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		row := make([]any, len(p.cols))
+		ptrs := make([]any, len(row))
+		for i := range row {
+			ptrs[i] = &row[i]
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			return err
+		}
+		if !r.nulls {
+			k, ok := row[p.key].(int64)
+			if !ok {
+				return fmt.Errorf("partition column %s holds %T, not an integer", col, row[p.key])
+			}
+			p.mu.Lock()
+			if k >= r.hi {
+				// the rest of the range was stolen
+				p.mu.Unlock()
+				return nil
+			}
+			r.next = k + 1
+			p.mu.Unlock()
+		}
+		select {
+		case out <- row:
+		case <-p.ctx.Done():
+			return nil
+		}
+	}
+	return rows.Err()
+}
+
+func (p *partitionedRows) columns() ([]column, error) { return p.cols, nil }
+
+func (p *partitionedRows) Next() bool {
+	if !p.opts.Ordered {
+		row, ok := <-p.rows
+		p.current = row
+		return ok
+	}
+	for {
+		p.mu.Lock()
+		if p.pos == len(p.order) {
+			p.mu.Unlock()
+			return false
+		}
+		r := p.order[p.pos]
+		p.mu.Unlock()
+		select {
+		case row, ok := <-r.out:
+			if ok {
+				p.current = row
+				return true
+			}
+			p.pos++
+		case <-p.ctx.Done():
+			// a failed reader leaves later ranges unread
+			return false
+		}
+	}
+}
+
+func (p *partitionedRows) Scan(dest ...any) error {
+	for i, d := range dest {
+		*d.(*any) = p.current[i]
+	}
+	return nil
+}
+
+func (p *partitionedRows) Err() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.err == nil {
+		return p.parent.Err()
+	}
+	return p.err
+}
+
+// Close stops the readers and waits for them.
+func (p *partitionedRows) Close() error {
+	p.cancel()
+	for range p.rows {
+	}
+	return nil
+}
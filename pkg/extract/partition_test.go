@@ -0,0 +1,109 @@
+package extract
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSplitRange(t *testing.T) {
+	var got [][2]int64
+	for _, r := range splitRange(1, 11, 3) {
+		got = append(got, [2]int64{r.next, r.hi})
+	}
+	if want := [][2]int64{{1, 5}, {5, 9}, {9, 11}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if n := len(splitRange(0, 2, 8)); n != 2 {
+		t.Errorf("two keys split into %d ranges", n)
+	}
+}
+
+// registerSkewed serves the partition queries of a job reading ids 1 to 100
+// and one NULL id from query, the first quarter of the ids slowly. It returns
+// the number of range queries run.
+func registerSkewed(t *testing.T, query string) *atomic.Int32 {
+	ranges := &atomic.Int32{}
+	rowsFrom := func(ids []any, delay time.Duration) *fakeResult {
+		res := numbersResult(len(ids))
+		res.value = func(row, col int) driver.Value {
+			if col == 0 {
+				return ids[row]
+			}
+			return fmt.Sprintf("name %v", ids[row])
+		}
+		res.delay = delay
+		return res
+	}
+	registerFake("SELECT TOP (0) * FROM ("+query+") AS src", &fakeQuery{sets: []*fakeResult{numbersResult(0)}})
+	bounds := numbersResult(1)
+	bounds.value = func(row, col int) driver.Value { return []int64{1, 100}[col] }
+	registerFake("SELECT MIN(id), MAX(id) FROM ("+query+") AS src", &fakeQuery{sets: []*fakeResult{bounds}})
+	registerFake("SELECT * FROM ("+query+") AS src WHERE id IS NULL", &fakeQuery{sets: []*fakeResult{rowsFrom([]any{nil}, 0)}})
+	registerFakeHandler(t, func(q string) (*fakeResult, bool) {
+		var lo, hi int64
+		if _, err := fmt.Sscanf(q, "SELECT * FROM ("+query+") AS src WHERE id >= %d AND id < %d ORDER BY id", &lo, &hi); err != nil {
+			return nil, false
+		}
+		ranges.Add(1)
+		var ids []any
+		for id := lo; id < hi; id++ {
+			ids = append(ids, id)
+		}
+		delay := time.Duration(0)
+		if lo < 25 {
+			delay = 2 * time.Millisecond
+		}
+		return rowsFrom(ids, delay), true
+	})
+	return ranges
+}
+
+func TestPartitionedExportSteals(t *testing.T) {
+	ranges := registerSkewed(t, "skewed")
+	outFile := filepath.Join(t.TempDir(), "out.csv")
+	c := &config{Partitions: map[string]partitionOptions{outFile: {Column: "id", Count: 4}}}
+	got, err := exportFake(t, c, "skewed", outFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(got), "\n")[1:]
+	seen := map[string]bool{}
+	for _, l := range lines {
+		if seen[l] {
+			t.Errorf("row %q exported twice", l)
+		}
+		seen[l] = true
+	}
+	if len(lines) != 101 || !seen["1,name 1"] || !seen["100,name 100"] || !seen[",name <nil>"] {
+		t.Errorf("got %d rows: %v", len(lines), lines)
+	}
+	if ranges.Load() <= 4 {
+		t.Errorf("no range was stolen: %d range queries", ranges.Load())
+	}
+}
+
+func TestPartitionedExportOrdered(t *testing.T) {
+	ranges := registerSkewed(t, "skewed-ordered")
+	outFile := filepath.Join(t.TempDir(), "out.csv")
+	c := &config{Partitions: map[string]partitionOptions{outFile: {Column: "id", Count: 3, Ordered: true}}}
+	got, err := exportFake(t, c, "skewed-ordered", outFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"id,name", ",name <nil>"}
+	for id := 1; id <= 100; id++ {
+		want = append(want, fmt.Sprintf("%d,name %d", id, id))
+	}
+	if lines := strings.Split(strings.TrimSpace(got), "\n"); !reflect.DeepEqual(lines, want) {
+		t.Errorf("rows out of order:\n%v", lines)
+	}
+	if ranges.Load() <= 3 {
+		t.Errorf("no range was stolen: %d range queries", ranges.Load())
+	}
+}
@@ -0,0 +1,202 @@
+package extract
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// periods are the logical periods of query templates. Weeks are ISO weeks,
+// starting on Monday; fiscal periods come from the fiscal calendar.
+var periods = []string{"day", "week", "month", "quarter", "year", "fiscal_week", "fiscal_period", "fiscal_quarter", "fiscal_year"}
+
+// fiscalStart returns the first month of the fiscal year, January by default.
+func (c *config) fiscalStart() time.Month {
+	if c.FiscalYearStart > 0 {
+		return time.Month(c.FiscalYearStart)
+	}
+	return time.January
+}
+
+// periodStart returns the start of the period of t, shifted by offset
+// periods: -1 is the period before.
+func periodStart(t time.Time, period string, offset int, cal fiscalCalendar) (time.Time, error) {
+	y, m, d := t.Date()
+	loc := t.Location()
+	switch period {
+	case "day":
+		return time.Date(y, m, d+offset, 0, 0, 0, 0, loc), nil
+	case "week":
+		monday := d - (int(t.Weekday())+6)%7
+		return time.Date(y, m, monday+7*offset, 0, 0, 0, 0, loc), nil
+	case "month":
+		return time.Date(y, m+time.Month(offset), 1, 0, 0, 0, 0, loc), nil
+	case "quarter":
+		return time.Date(y, m-(m-1)%3+time.Month(3*offset), 1, 0, 0, 0, 0, loc), nil
+	case "year":
+		return time.Date(y+offset, time.January, 1, 0, 0, 0, 0, loc), nil
+	case "fiscal_week", "fiscal_period", "fiscal_quarter", "fiscal_year":
+		return fiscalShift(cal, t, period, offset)
+	}
+	return time.Time{}, fmt.Errorf("unknown period %q (%s)", period, strings.Join(periods, ", "))
+}
+
+// templateDate is a date in a query template, written as 2006-01-02.
+type templateDate struct{ time.Time }
+
+func (d templateDate) String() string { return d.Format("2006-01-02") }
+
+// templateTime is a time in a query template, written as 2006-01-02 15:04:05.
+type templateTime struct{ time.Time }
+
+func (t templateTime) String() string { return t.Format("2006-01-02 15:04:05") }
+
+// runTemplate is what query templates see of the run, such as
+//
+//	WHERE order_date >= '{{ .PeriodStart "month" -1 }}' AND order_date < '{{ .PeriodEnd "month" -1 }}'
+type runTemplate struct {
+	RunDate       templateDate
+	RunTime       templateTime
+	OutFile       string
+	ISOYear       int
+	ISOWeek       int
+	FiscalYear    int
+	FiscalQuarter int
+	FiscalPeriod  int
+	FiscalWeek    int
+
+	calendar fiscalCalendar
+	vars     *runVars
+	// watermark is the watermark of an incremental job, and namedWatermark
+	// is set where queryArgs binds it as @watermark.
+	watermark      string
+	hasWatermark   bool
+	namedWatermark bool
+	// args binds the values of query templates; outfile templates have none.
+	args *templateArgs
+}
+
+// newRunTemplate returns the template values of the job writing outFile. It
+// fails only when the run date is not in a custom fiscal calendar.
+func newRunTemplate(c *config, outFile string) (runTemplate, error) {
+	t := runTemplate{RunTime: templateTime{c.started}, OutFile: outFile, calendar: c.fiscalCalendar(), vars: c.vars}
+	day, _ := periodStart(c.started, "day", 0, t.calendar)
+	t.RunDate = templateDate{day}
+	t.watermark, t.hasWatermark, _ = c.watermark(outFile)
+	_, t.namedWatermark = c.watermarkArg(outFile)
+	t.ISOYear, t.ISOWeek = c.started.ISOWeek()
+	p, err := t.calendar.lookup(utcDate(day))
+	if err != nil {
+		return t, fmt.Errorf("The run date %v\n", err)
+	}
+	t.FiscalYear, t.FiscalQuarter, t.FiscalPeriod, t.FiscalWeek = p.year, p.quarter, p.period, p.week
+	return t, nil
+}
+
+// PeriodStart returns the first day of the period the run falls in, or of
+// the period offset periods away.
+func (t runTemplate) PeriodStart(period string, offset ...int) (templateDate, error) {
+	start, err := periodStart(t.RunDate.Time, period, offsetOf(offset), t.calendar)
+	return templateDate{start}, err
+}
+
+// PeriodEnd returns the first day after the period, for comparing with <.
+func (t runTemplate) PeriodEnd(period string, offset ...int) (templateDate, error) {
+	end, err := periodStart(t.RunDate.Time, period, offsetOf(offset)+1, t.calendar)
+	return templateDate{end}, err
+}
+
+// Var returns the value captured under name by a job this one runs after. A
+// query gets it bound as a parameter, and an outfile the value itself.
+func (t runTemplate) Var(name string) (string, error) {
+	if v, ok := t.vars.get(name); ok {
+		if t.args != nil {
+			return t.args.bind(name, &v), nil
+		}
+		return v, nil
+	}
+	return "", fmt.Errorf("no job it runs after captured %s", name)
+}
+
+// Watermark returns the watermark of an incremental job. A query gets it
+// bound as a parameter, NULL before the job has one, and an outfile the
+// value itself, empty before it has one.
+func (t runTemplate) Watermark() string {
+	switch {
+	case t.args == nil:
+		return t.watermark
+	case t.namedWatermark:
+		// queryArgs binds it already
+		return "@watermark"
+	case t.hasWatermark:
+		return t.args.bind("watermark", &t.watermark)
+	}
+	return t.args.bind("watermark", nil)
+}
+
+// offsetOf returns the optional offset argument of a template method.
+func offsetOf(offset []int) int {
+	if len(offset) > 0 {
+		return offset[0]
+	}
+	return 0
+}
+
+// isTemplate reports whether a query is rendered as a template.
+func isTemplate(query string) bool {
+	return strings.Contains(query, "{{")
+}
+
+// parseQueryTemplate parses the template of the query of outFile.
+func parseQueryTemplate(outFile, query string) (*template.Template, error) {
+	tmpl, err := template.New(outFile).Option("missingkey=error").Parse(query)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid template in the query of %s: %v\n", outFile, err)
+	}
+	return tmpl, nil
+}
+
+// renderQueries returns the queries of the run with their templates
+// rendered for the time it started. The queries of jobs running after others
+// are rendered by renderQuery once those are over.
+func renderQueries(c *config) ([]string, error) {
+	// the run date has to be in the fiscal calendar even without templates,
+	// since computed columns see its fiscal period too
+	if _, err := newRunTemplate(c, ""); err != nil {
+		return nil, err
+	}
+	c.bound = &boundArgs{args: map[string][]boundArg{}}
+	queries := make([]string, len(c.Queries))
+	for i, query := range c.Queries {
+		queries[i] = query
+		if len(c.dependencies(c.OutFiles[i])) > 0 {
+			continue
+		}
+		var err error
+		if queries[i], err = renderQuery(c, c.OutFiles[i], query); err != nil {
+			return nil, err
+		}
+	}
+	return queries, nil
+}
+
+// renderQuery renders the query template of the job writing outFile. The
+// values it binds are kept for jobArgs.
+func renderQuery(c *config, outFile, query string) (string, error) {
+	if !isTemplate(query) {
+		return query, nil
+	}
+	tmpl, err := parseQueryTemplate(outFile, query)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	t, _ := newRunTemplate(c, outFile)
+	t.args = newTemplateArgs(c)
+	if err := tmpl.Execute(&b, t); err != nil {
+		return "", fmt.Errorf("Could not render the query of %s: %v\n", outFile, err)
+	}
+	c.bound.set(outFile, t.args.args)
+	return b.String(), nil
+}
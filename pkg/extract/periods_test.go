@@ -0,0 +1,85 @@
+package extract
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPeriodStart(t *testing.T) {
+	// a Thursday in ISO week 2 of 2025
+	run := time.Date(2025, time.January, 9, 14, 30, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		period string
+		offset int
+		fiscal time.Month
+		want   string
+	}{
+		{"day", 0, time.January, "2025-01-09"},
+		{"day", -1, time.January, "2025-01-08"},
+		{"week", 0, time.January, "2025-01-06"},
+		{"week", -2, time.January, "2024-12-23"},
+		{"month", -1, time.January, "2024-12-01"},
+		{"quarter", 1, time.January, "2025-04-01"},
+		{"year", 0, time.January, "2025-01-01"},
+		{"fiscal_quarter", 0, time.July, "2025-01-01"},
+		{"fiscal_quarter", 0, time.February, "2024-11-01"},
+		{"fiscal_year", 0, time.July, "2024-07-01"},
+		{"fiscal_year", 1, time.July, "2025-07-01"},
+	} {
+		got, err := periodStart(run, tc.period, tc.offset, monthCalendar{first: tc.fiscal})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s := got.Format("2006-01-02"); s != tc.want {
+			t.Errorf("%s %+d (fiscal %v): got %s, want %s", tc.period, tc.offset, tc.fiscal, s, tc.want)
+		}
+	}
+	if _, err := periodStart(run, "fortnight", 0, monthCalendar{first: time.January}); err == nil {
+		t.Error("an unknown period got no error")
+	}
+}
+
+func TestFiscalYear(t *testing.T) {
+	for _, tc := range []struct {
+		day           time.Time
+		fiscal        time.Month
+		year, quarter int
+	}{
+		{time.Date(2025, time.January, 9, 0, 0, 0, 0, time.UTC), time.January, 2025, 1},
+		{time.Date(2025, time.January, 9, 0, 0, 0, 0, time.UTC), time.July, 2025, 3},
+		{time.Date(2025, time.July, 1, 0, 0, 0, 0, time.UTC), time.July, 2026, 1},
+		{time.Date(2025, time.June, 30, 0, 0, 0, 0, time.UTC), time.October, 2025, 3},
+	} {
+		p, _ := monthCalendar{first: tc.fiscal}.lookup(tc.day)
+		if p.year != tc.year || p.quarter != tc.quarter {
+			t.Errorf("%s (fiscal %v): got FY%d Q%d, want FY%d Q%d", tc.day.Format("2006-01-02"), tc.fiscal, p.year, p.quarter, tc.year, tc.quarter)
+		}
+	}
+}
+
+func TestRenderQueries(t *testing.T) {
+	c := &config{
+		Queries:         []string{"SELECT 1", `SELECT * FROM sales WHERE d >= '{{ .PeriodStart "month" -1 }}' AND d < '{{ .PeriodEnd "month" -1 }}' -- W{{ .ISOWeek }} FY{{ .FiscalYear }}`},
+		OutFiles:        []string{"a.csv", "b.csv"},
+		FiscalYearStart: 7,
+		started:         time.Date(2025, time.January, 9, 14, 30, 0, 0, time.UTC),
+	}
+	got, err := renderQueries(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "SELECT * FROM sales WHERE d >= '2024-12-01' AND d < '2025-01-01' -- W2 FY2025"
+	if got[0] != "SELECT 1" || got[1] != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	c.Queries[1] = `SELECT '{{ .PeriodStart "fortnight" }}'`
+	if _, err := renderQueries(c); err == nil {
+		t.Error("an unknown period got no error")
+	}
+	c.Queries[1] = `SELECT '{{ .PeriodStart "month" }'`
+	if err := c.validate(); err == nil || !strings.Contains(err.Error(), "Invalid template in the query of b.csv") {
+		t.Errorf("got %v, want the invalid template", err)
+	}
+}
@@ -0,0 +1,82 @@
+package extract
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"time"
+)
+
+// defaultPipeWait is how long a job waits for the reader of its pipe.
+const defaultPipeWait = 10 * time.Minute
+
+// pipeRetry is how often a job tries the pipe again while no reader has it open.
+const pipeRetry = 200 * time.Millisecond
+
+// pipeWait returns how long the job writing outFile waits for a reader to
+// open its pipe.
+func (c *config) pipeWait(outFile string) time.Duration {
+	if e, _ := c.extract(outFile); e.PipeWait > 0 {
+		return time.Duration(e.PipeWait)
+	}
+	return defaultPipeWait
+}
+
+// openPipe opens the pipe at path for the job writing outFile once a reader
+// has it open, and returns a writer reporting a reader that went away.
+func (c *config) openPipe(path, outFile string) (*os.File, *pipeWriter, error) {
+	wait := c.pipeWait(outFile)
+	started := time.Now()
+	for waiting := false; ; waiting = true {
+		f, err := openPipeWriter(path)
+		if err == nil {
+			return f, &pipeWriter{f: f}, nil
+		}
+		if !noPipeReader(err) {
+			return nil, nil, err
+		}
+		if time.Since(started) >= wait {
+			return nil, nil, fmt.Errorf("no reader opened the pipe within %s", wait)
+		}
+		if !waiting {
+			log.Printf("Waiting up to %s for a reader of pipe %s\n", wait, path)
+		}
+		time.Sleep(pipeRetry)
+	}
+}
+
+// pipeWriter writes to a pipe, turning the error of a reader that closed
+// its end into one that says so.
+type pipeWriter struct {
+	f *os.File
+	n int64
+}
+
+func (w *pipeWriter) Write(p []byte) (int, error) {
+	n, err := w.f.Write(p)
+	w.n += int64(n)
+	if err != nil && brokenPipe(err) {
+		return n, fmt.Errorf("the reader of pipe %s closed it after %d byte(s)", w.f.Name(), w.n)
+	}
+	return n, err
+}
+
+func validatePipes(c *config) error {
+	for _, e := range c.Extracts {
+		if e.PipeWait < 0 {
+			return fmt.Errorf("Extract %s pipe_wait cannot be negative\n", e.Name)
+		}
+	}
+	for _, outFile := range c.OutFiles {
+		if !isPipe(c.outputPath(outFile)) {
+			continue
+		}
+		if _, ok := c.Verify[outFile]; ok {
+			return fmt.Errorf("%s is a pipe, whose output cannot be read back to verify\n", outFile)
+		}
+		if c.Latest[outFile] != "" {
+			return fmt.Errorf("%s is a pipe, whose output cannot be published as latest\n", outFile)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+//go:build !windows
+
+package extract
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestOpenPipe(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "orders.fifo")
+	if err := syscall.Mkfifo(path, 0o600); err != nil {
+		t.Skip("no FIFOs here:", err)
+	}
+	if !isPipe(path) || isPipe(filepath.Dir(path)) {
+		t.Fatal("isPipe does not tell the FIFO")
+	}
+	c := &config{Extracts: []extract{{Name: "orders", OutFile: path, PipeWait: configDuration(300 * time.Millisecond), Compression: "gzip"}}}
+	if got := c.outputPath(path); got != path {
+		t.Errorf("outputPath = %s, want the pipe itself", got)
+	}
+
+	if _, _, err := c.openPipe(path, path); err == nil || !strings.Contains(err.Error(), "no reader") {
+		t.Errorf("without a reader: %v", err)
+	}
+
+	read := make(chan string)
+	go func() {
+		r, err := os.OpenFile(path, os.O_RDONLY, 0)
+		if err != nil {
+			read <- err.Error()
+			return
+		}
+		data, _ := io.ReadAll(r)
+		r.Close()
+		read <- string(data)
+	}()
+	f, w, err := c.openPipe(path, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(w, "id,name\n1,Acme\n")
+	f.Close()
+	if got := <-read; got != "id,name\n1,Acme\n" {
+		t.Errorf("reader got %q", got)
+	}
+	if fi, err := os.Stat(path); err != nil || fi.Mode()&fs.ModeNamedPipe == 0 {
+		t.Error("the pipe is kept")
+	}
+}
+
+func TestPipeReaderGone(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "orders.fifo")
+	if err := syscall.Mkfifo(path, 0o600); err != nil {
+		t.Skip("no FIFOs here:", err)
+	}
+	r, err := os.OpenFile(path, os.O_RDONLY|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &config{}
+	f, w, err := c.openPipe(path, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	r.Close()
+	if _, err := w.Write([]byte("1,Acme\n")); err == nil || !strings.Contains(err.Error(), "closed it after 0 byte(s)") {
+		t.Errorf("got %v, want the reader gone", err)
+	}
+}
@@ -0,0 +1,29 @@
+//go:build !windows
+
+package extract
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// isPipe reports whether path is an existing FIFO.
+func isPipe(path string) bool {
+	fi, err := os.Stat(path)
+	return err == nil && fi.Mode()&os.ModeNamedPipe != 0
+}
+
+// openPipeWriter opens the FIFO at path without blocking, which fails with
+// ENXIO while no reader has it open.
+func openPipeWriter(path string) (*os.File, error) {
+	return os.OpenFile(path, os.O_WRONLY|syscall.O_NONBLOCK, 0)
+}
+
+func noPipeReader(err error) bool {
+	return errors.Is(err, syscall.ENXIO)
+}
+
+func brokenPipe(err error) bool {
+	return errors.Is(err, syscall.EPIPE)
+}
@@ -0,0 +1,29 @@
+package extract
+
+import (
+	"errors"
+	"os"
+	"strings"
+
+	"golang.org/x/sys/windows"
+)
+
+// isPipe reports whether path names a pipe, as \\.\pipe\name.
+func isPipe(path string) bool {
+	return strings.HasPrefix(strings.ToLower(path), `\\.\pipe\`)
+}
+
+// openPipeWriter connects to the named pipe at path, which the reader
+// creates and listens on.
+func openPipeWriter(path string) (*os.File, error) {
+	return os.OpenFile(path, os.O_WRONLY, 0)
+}
+
+// noPipeReader reports the pipe missing, or every instance of it busy.
+func noPipeReader(err error) bool {
+	return errors.Is(err, windows.ERROR_FILE_NOT_FOUND) || errors.Is(err, windows.ERROR_PIPE_BUSY)
+}
+
+func brokenPipe(err error) bool {
+	return errors.Is(err, windows.ERROR_BROKEN_PIPE) || errors.Is(err, windows.ERROR_NO_DATA)
+}
@@ -0,0 +1,76 @@
+package extract
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// outputPolicy restricts where jobs may write. When Allow is set, every
+// outfile, and every other place a run writes to, must match one of its
+// globs, e.g. /exports/* or s3://reports-bucket/*.
+type outputPolicy struct {
+	Allow []string `yaml:"allow"`
+}
+
+// validate rejects the outputs that no allow glob matches.
+func (p outputPolicy) validate(outFiles []string) error {
+	if len(p.Allow) == 0 {
+		return nil
+	}
+	for _, outFile := range outFiles {
+		if !p.allows(outFile) {
+			return fmt.Errorf("Output %s is not allowed by output_policy (allow: %v)\n", outFile, p.Allow)
+		}
+	}
+	return nil
+}
+
+// policyTargets returns everything a run writes to besides the job files:
+// the latest paths, the staging locations of the warehouse loads and the
+// change snapshots. A named Snowflake stage is matched as
+// snowflake://DB.SCHEMA.STAGE; the default table stage is the table's own.
+func (c *config) policyTargets() []string {
+	targets := append([]string(nil), c.OutFiles...)
+	for _, latest := range c.Latest {
+		targets = append(targets, latest)
+	}
+	for _, staging := range []string{c.BigQuery.Staging, c.Redshift.Staging} {
+		if staging != "" {
+			targets = append(targets, staging)
+		}
+	}
+	if c.Snowflake.Stage != "" {
+		targets = append(targets, "snowflake://"+strings.TrimPrefix(c.Snowflake.Stage, "@"))
+	}
+	for _, o := range c.Changes {
+		targets = append(targets, o.Snapshot)
+	}
+	return targets
+}
+
+// allows reports whether outFile matches an allow glob once it is cleaned,
+// so ../ segments cannot climb out of an allowed root.
+func (p outputPolicy) allows(outFile string) bool {
+	target := cleanOutput(outFile)
+	for _, pattern := range p.Allow {
+		if globMatch(pattern, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// cleanOutput makes a local outfile absolute and resolves the . and ..
+// segments of both paths and URL-style outfiles.
+func cleanOutput(outFile string) string {
+	if scheme, rest, ok := strings.Cut(outFile, "://"); ok {
+		return scheme + "://" + strings.TrimPrefix(path.Clean("/"+rest), "/")
+	}
+	abs, err := filepath.Abs(outFile)
+	if err != nil {
+		return filepath.Clean(outFile)
+	}
+	return abs
+}
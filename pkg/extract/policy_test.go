@@ -0,0 +1,57 @@
+package extract
+
+import "testing"
+
+func TestOutputPolicy(t *testing.T) {
+	p := outputPolicy{Allow: []string{"/exports/*", "s3://reports-bucket/*", "snowflake://ANALYTICS.*"}}
+	tests := []struct {
+		outFile string
+		want    bool
+	}{
+		{"/exports/gl.csv", true},
+		{"/exports/../etc/passwd", false},
+		{"/tmp/gl.csv", false},
+		{"s3://reports-bucket/daily/gl.csv", true},
+		{"s3://reports-bucket/../other-bucket/gl.csv", false},
+		{"s3://attacker-bucket/gl.csv", false},
+		{"snowflake://ANALYTICS.PUBLIC.GL", true},
+		{"bigquery://proj.ds.gl", false},
+	}
+	for _, tt := range tests {
+		if got := p.allows(tt.outFile); got != tt.want {
+			t.Errorf("allows(%q) = %v, want %v", tt.outFile, got, tt.want)
+		}
+	}
+	if err := (outputPolicy{}).validate([]string{"/anywhere.csv"}); err != nil {
+		t.Errorf("an empty policy rejected an output: %v", err)
+	}
+}
+
+func TestOutputPolicyTargets(t *testing.T) {
+	p := outputPolicy{Allow: []string{"/exports/*", "s3://reports-bucket/*", "snowflake://ANALYTICS.*"}}
+	base := func() *config {
+		return &config{OutFiles: []string{"/exports/gl.csv"}, OutputPolicy: p}
+	}
+	if err := p.validate(base().policyTargets()); err != nil {
+		t.Fatal(err)
+	}
+	for name, c := range map[string]*config{
+		"latest":           {Latest: map[string]string{"/exports/gl.csv": "/srv/www/gl.csv"}},
+		"bigquery staging": {BigQuery: bigqueryOptions{Staging: "gs://open-bucket/stage"}},
+		"redshift staging": {Redshift: redshiftOptions{Staging: "s3://other-bucket/stage"}},
+		"snowflake stage":  {Snowflake: snowflakeOptions{Stage: "@SCRATCH.PUBLIC.STG"}},
+		"snapshot":         {Changes: map[string]changeOptions{"/exports/gl.csv": {Key: []string{"id"}, Snapshot: "/tmp/gl.snap"}}},
+	} {
+		c.OutFiles = base().OutFiles
+		if err := p.validate(c.policyTargets()); err == nil {
+			t.Errorf("%s outside the policy was allowed", name)
+		}
+	}
+	c := base()
+	c.Latest = map[string]string{"/exports/gl.csv": "/exports/latest/gl.csv"}
+	c.Redshift.Staging = "s3://reports-bucket/stage"
+	c.Snowflake.Stage = "@ANALYTICS.PUBLIC.STG"
+	if err := p.validate(c.policyTargets()); err != nil {
+		t.Errorf("targets inside the policy: %v", err)
+	}
+}
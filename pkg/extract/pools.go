@@ -0,0 +1,113 @@
+package extract
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// defaultPool runs the jobs not assigned to a pool.
+const defaultPool = "default"
+
+// limiter is a counting semaphore.
+type limiter struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	limit   int
+	running int
+}
+
+func newLimiter(limit int) *limiter {
+	l := &limiter{limit: limit}
+	l.cond = sync.NewCond(&l.mu)
+	return l
+}
+
+// acquire blocks until a slot is free and takes it.
+func (l *limiter) acquire() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for l.running >= l.limit {
+		l.cond.Wait()
+	}
+	l.running++
+}
+
+// setLimit changes the number of slots. Lowering it lets running holders
+// finish; no new ones start until running is below the new limit.
+func (l *limiter) setLimit(limit int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.limit = limit
+	l.cond.Broadcast()
+}
+
+// currentLimit returns the number of slots.
+func (l *limiter) currentLimit() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.limit
+}
+
+// release frees a slot taken by acquire.
+func (l *limiter) release() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.running--
+	l.cond.Broadcast()
+}
+
+// workerPools limits the jobs running at once per named pool, within an
+// overall limit. Jobs wait for their pool before taking an overall slot, so
+// a full pool never holds slots other pools could use.
+type workerPools struct {
+	total *limiter
+	pools map[string]*limiter
+}
+
+// newWorkerPools creates the configured pools. The default pool is only
+// limited by total unless it is configured.
+func newWorkerPools(sizes map[string]int, total int) (*workerPools, error) {
+	p := &workerPools{total: newLimiter(total), pools: make(map[string]*limiter, len(sizes))}
+	for name, size := range sizes {
+		if size < 1 {
+			return nil, fmt.Errorf("Pool %s must allow at least one job, got %d\n", name, size)
+		}
+		p.pools[name] = newLimiter(size)
+	}
+	return p, nil
+}
+
+// validate checks that every job is assigned to a configured pool.
+func (p *workerPools) validate(jobPools map[string]string) error {
+	for outFile, pool := range jobPools {
+		if _, ok := p.pools[pool]; !ok && pool != defaultPool {
+			names := make([]string, 0, len(p.pools))
+			for name := range p.pools {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			return fmt.Errorf("Job %s is assigned to unknown pool '%s' (pools: %v)\n", outFile, pool, names)
+		}
+	}
+	return nil
+}
+
+// acquire blocks until the pool and the overall limit both have a free slot,
+// returning the function that frees them.
+func (p *workerPools) acquire(pool string) func() {
+	if pool == "" {
+		pool = defaultPool
+	}
+	l := p.pools[pool]
+	if l != nil {
+		l.acquire()
+	}
+	p.total.acquire()
+	return func() {
+		p.total.release()
+		if l != nil {
+			l.release()
+		}
+	}
+}
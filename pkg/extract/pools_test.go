@@ -0,0 +1,123 @@
+package extract
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWorkerPoolsLimits(t *testing.T) {
+	p, err := newWorkerPools(map[string]int{"heavy": 2}, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var heavy, total, maxHeavy, maxTotal atomic.Int32
+	record := func(n *atomic.Int32, max *atomic.Int32) {
+		v := n.Add(1)
+		for m := max.Load(); v > m && !max.CompareAndSwap(m, v); m = max.Load() {
+		}
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		pool := ""
+		if i%2 == 0 {
+			pool = "heavy"
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			release := p.acquire(pool)
+			defer release()
+			record(&total, &maxTotal)
+			if pool == "heavy" {
+				record(&heavy, &maxHeavy)
+				defer heavy.Add(-1)
+			}
+			defer total.Add(-1)
+			time.Sleep(5 * time.Millisecond)
+		}()
+	}
+	wg.Wait()
+
+	if maxHeavy.Load() > 2 || maxTotal.Load() > 5 {
+		t.Fatalf("ran %d heavy and %d total jobs at once, want at most 2 and 5", maxHeavy.Load(), maxTotal.Load())
+	}
+}
+
+func TestWorkerPoolsUnknownPool(t *testing.T) {
+	p, _ := newWorkerPools(map[string]int{"heavy": 2}, 5)
+	if err := p.validate(map[string]string{"a.csv": "light"}); err == nil {
+		t.Fatal("validate accepted an unknown pool")
+	}
+	if err := p.validate(map[string]string{"a.csv": "heavy", "b.csv": defaultPool}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAdaptiveNextLimit(t *testing.T) {
+	o := adaptiveOptions{Limits: map[string]float64{"cpu_percent": 80, "blocked_sessions": 5}, Min: 2, Max: 10}
+	for _, tc := range []struct {
+		current int
+		load    map[string]float64
+		want    int
+	}{
+		{8, map[string]float64{"cpu_percent": 95, "blocked_sessions": 0}, 4},
+		{3, map[string]float64{"cpu_percent": 50, "blocked_sessions": 9}, 2},
+		{4, map[string]float64{"cpu_percent": 40, "blocked_sessions": 1}, 5},
+		{10, map[string]float64{"cpu_percent": 10}, 10},
+		{6, map[string]float64{"cpu_percent": 70, "blocked_sessions": 1}, 6},
+	} {
+		if got, _ := o.nextLimit(tc.current, tc.load); got != tc.want {
+			t.Errorf("nextLimit(%d, %v) = %d, want %d", tc.current, tc.load, got, tc.want)
+		}
+	}
+}
+
+func TestSampleLoad(t *testing.T) {
+	registerFake("health", &fakeQuery{sets: []*fakeResult{{
+		columns: []fakeColumn{{name: "CPU_Percent", dbType: "INT"}, {name: "blocked_sessions", dbType: "INT"}, {name: "runnable_tasks", dbType: "INT", nullable: true}},
+		rows:    1,
+		value: func(_, col int) driver.Value {
+			if col == 2 {
+				return nil
+			}
+			return int64(40 + col)
+		},
+	}}})
+	db, _ := sql.Open("fakedb", "")
+	defer db.Close()
+	load, err := sampleLoad(db, "health")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(load) != 2 || load["cpu_percent"] != 40 || load["blocked_sessions"] != 41 {
+		t.Fatalf("got %v", load)
+	}
+}
+
+func TestConcurrencySetting(t *testing.T) {
+	c := &config{}
+	if n := c.concurrency(); n != defaultConcurrency {
+		t.Errorf("default concurrency %d", n)
+	}
+	c.Concurrency = -1
+	if err := c.validate(); err == nil {
+		t.Error("a negative concurrency passed")
+	}
+
+	c = &config{Concurrency: 4, Adaptive: adaptiveOptions{Limits: map[string]float64{"cpu_percent": 80}}}
+	if err := c.validate(); err != nil {
+		t.Fatal(err)
+	}
+	if c.Adaptive.Max != 4 {
+		t.Errorf("adaptive max %d, want the concurrency", c.Adaptive.Max)
+	}
+	c.Tenants = map[string]tenantOptions{"acme": {Concurrency: 5}}
+	if err := c.validate(); err == nil {
+		t.Error("a tenant allowing more jobs than the run passed")
+	}
+}
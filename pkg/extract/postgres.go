@@ -0,0 +1,291 @@
+package extract
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// postgresOptions holds the settings for postgres:// COPY destinations.
+type postgresOptions struct {
+	tableOptions `yaml:",inline"`
+	// DSNEnv names the environment variable holding the pgx connection string.
+	DSNEnv string `yaml:"dsn_env"`
+	// CreateTable creates the target table from the source schema when it does not exist.
+	CreateTable bool `yaml:"create_table"`
+}
+
+// validate checks the load settings. COPY streams the rows in one go, so
+// there are no batches to size; commit_every splits a load instead.
+func (o postgresOptions) validate() error {
+	if o.BatchSize != 0 {
+		return fmt.Errorf("postgres.batch_size is not supported, COPY sends the rows in one stream; use commit_every to split the load\n")
+	}
+	return o.tableOptions.validate()
+}
+
+// errCopyAborted ends an in-progress COPY when the extract fails.
+var errCopyAborted = errors.New("copy aborted")
+
+// pgConn is the part of a *pgx.Conn the writer uses.
+type pgConn interface {
+	Begin(ctx context.Context) (pgx.Tx, error)
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+	Close(ctx context.Context) error
+}
+
+// postgresWriter streams the result set into a Postgres table with the COPY
+// protocol, committing every CommitEvery rows or once at the end. CopyFrom
+// pulls rows, so WriteRow hands them over a channel to the goroutine running
+// the copy.
+type postgresWriter struct {
+	opts    postgresOptions
+	table   pgx.Identifier
+	ctx     context.Context
+	cancel  context.CancelFunc
+	conn    pgConn
+	tx      pgx.Tx
+	cols    []column
+	rows    chan []any
+	result  chan error
+	pending int
+	done    bool
+}
+
+func newPostgresWriter(table string, o postgresOptions) (*postgresWriter, error) {
+	if o.DSNEnv == "" {
+		o.DSNEnv = "POSTGRES_DSN"
+	}
+	dsn := os.Getenv(o.DSNEnv)
+	if dsn == "" {
+		return nil, fmt.Errorf("Postgres DSN environment variable %s is not set\n", o.DSNEnv)
+	}
+	if table == "" {
+		return nil, fmt.Errorf("Postgres destination requires a table name\n")
+	}
+	if err := o.validate(); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	conn, err := pgx.Connect(ctx, dsn)
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("Could not connect to Postgres: %v\n", err)
+	}
+	return &postgresWriter{
+		opts:   o,
+		table:  pgx.Identifier(strings.Split(table, ".")),
+		ctx:    ctx,
+		cancel: cancel,
+		conn:   conn,
+	}, nil
+}
+
+// postgresType maps a column to the Postgres type used when creating the table.
+func postgresType(c column) string {
+	switch c.kind() {
+	case kindInt:
+		return "bigint"
+	case kindFloat:
+		return "double precision"
+	case kindBool:
+		return "boolean"
+	case kindDecimal:
+		return fmt.Sprintf("numeric(%d,%d)", c.Precision, c.Scale)
+	case kindDate:
+		return "date"
+	case kindTimestamp:
+		if c.DBType == "DATETIMEOFFSET" {
+			return "timestamptz"
+		}
+		return "timestamp"
+	case kindBytes:
+		return "bytea"
+	default:
+		return "text"
+	}
+}
+
+func (p *postgresWriter) WriteHeader(cols []column) error {
+	p.cols = cols
+	tx, err := p.conn.Begin(p.ctx)
+	if err != nil {
+		return fmt.Errorf("Could not begin transaction on Postgres: %v\n", err)
+	}
+	p.tx = tx
+	if p.opts.CreateTable {
+		defs := make([]string, len(cols))
+		for i, c := range cols {
+			defs[i] = pgx.Identifier{c.Name}.Sanitize() + " " + postgresType(c)
+		}
+		ddl := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s)", p.table.Sanitize(), strings.Join(defs, ", "))
+		if _, err := p.tx.Exec(p.ctx, ddl); err != nil {
+			return fmt.Errorf("Could not create Postgres table %s: %v\n", p.table.Sanitize(), err)
+		}
+	}
+	for _, stmt := range p.opts.statements(p.opts.Pre, p.table.Sanitize()) {
+		if _, err := p.tx.Exec(p.ctx, stmt); err != nil {
+			return fmt.Errorf("Pre statement for %s failed: %v\n", p.table.Sanitize(), err)
+		}
+	}
+	p.startCopy()
+	return nil
+}
+
+// startCopy runs a COPY in the current transaction, fed by WriteRow.
+func (p *postgresWriter) startCopy() {
+	p.rows = make(chan []any, 256)
+	p.result = make(chan error, 1)
+	go func(tx pgx.Tx, rows chan []any, result chan error) {
+		n, err := tx.CopyFrom(p.ctx, p.table, columnNames(p.cols), &channelSource{rows: rows, ctx: p.ctx})
+		if err == nil {
+			log.Printf("Postgres COPY into %s copied %d row(s)\n", p.table.Sanitize(), n)
+		}
+		result <- err
+	}(p.tx, p.rows, p.result)
+}
+
+// commit ends the running COPY and commits its transaction.
+func (p *postgresWriter) commit() error {
+	close(p.rows)
+	err := <-p.result
+	p.result = nil
+	if err != nil {
+		p.tx.Rollback(context.Background())
+		return fmt.Errorf("Postgres COPY into %s failed: %v\n", p.table.Sanitize(), err)
+	}
+	p.pending = 0
+	if err := p.tx.Commit(p.ctx); err != nil {
+		return fmt.Errorf("Could not commit Postgres COPY into %s: %v\n", p.table.Sanitize(), err)
+	}
+	return nil
+}
+
+func (p *postgresWriter) WriteRow(values []any) error {
+	record := make([]any, len(values))
+	for i, v := range values {
+		value, err := postgresValue(p.cols[i], v)
+		if err != nil {
+			return fmt.Errorf("column %s: %v", p.cols[i].Name, err)
+		}
+		record[i] = value
+	}
+	select {
+	case p.rows <- record:
+	case err := <-p.result:
+		// the copy ended early; keep the error for Close
+		p.result <- err
+		return fmt.Errorf("Postgres COPY into %s failed: %v\n", p.table.Sanitize(), err)
+	}
+
+	p.pending++
+	if p.opts.CommitEvery > 0 && p.pending >= p.opts.CommitEvery {
+		if err := p.commit(); err != nil {
+			return err
+		}
+		tx, err := p.conn.Begin(p.ctx)
+		if err != nil {
+			return fmt.Errorf("Could not begin transaction on Postgres: %v\n", err)
+		}
+		p.tx = tx
+		p.startCopy()
+	}
+	return nil
+}
+
+// postgresValue converts a source value to a type pgx can encode for the
+// column; decimals go through pgtype.Numeric to keep their precision.
+func postgresValue(c column, v any) (any, error) {
+	if v == nil {
+		return nil, nil
+	}
+	switch c.kind() {
+	case kindInt:
+		return asInt64(v)
+	case kindFloat:
+		return asFloat64(v)
+	case kindBool:
+		return asBool(v)
+	case kindDecimal:
+		var n pgtype.Numeric
+		if err := n.Scan(formatValue(v)); err != nil {
+			return nil, err
+		}
+		return n, nil
+	case kindDate, kindTimestamp:
+		return asTime(v)
+	case kindBytes:
+		if b, ok := v.([]byte); ok {
+			return b, nil
+		}
+	}
+	return formatValue(v), nil
+}
+
+// Close commits the remaining rows and runs the post statements.
+func (p *postgresWriter) Close() error {
+	p.done = true
+	defer p.cancel()
+	defer p.conn.Close(context.Background())
+	if err := p.commit(); err != nil {
+		return err
+	}
+	for _, stmt := range p.opts.statements(p.opts.Post, p.table.Sanitize()) {
+		if _, err := p.conn.Exec(p.ctx, stmt); err != nil {
+			return fmt.Errorf("Post statement for %s failed: %v\n", p.table.Sanitize(), err)
+		}
+	}
+	return nil
+}
+
+// Abort cancels the running copy and rolls back its transaction; earlier
+// commits are kept. The copy holds the connection until it returns, so the
+// rollback waits for it.
+func (p *postgresWriter) Abort() {
+	if !p.done {
+		p.done = true
+		p.cancel()
+		if p.result != nil {
+			<-p.result
+		}
+		if p.tx != nil {
+			p.tx.Rollback(context.Background())
+		}
+		p.conn.Close(context.Background())
+	}
+}
+
+// channelSource adapts a channel of rows to pgx.CopyFromSource.
+type channelSource struct {
+	ctx  context.Context
+	rows chan []any
+	row  []any
+	err  error
+}
+
+func (s *channelSource) Next() bool {
+	select {
+	case row, ok := <-s.rows:
+		s.row = row
+		return ok
+	case <-s.ctx.Done():
+		s.err = errCopyAborted
+		return false
+	}
+}
+
+func (s *channelSource) Values() ([]any, error) {
+	return s.row, nil
+}
+
+func (s *channelSource) Err() error {
+	return s.err
+}
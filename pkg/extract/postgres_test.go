@@ -0,0 +1,171 @@
+package extract
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+func TestPostgresOptionsValidate(t *testing.T) {
+	if err := (postgresOptions{tableOptions: tableOptions{CommitEvery: 1000}}).validate(); err != nil {
+		t.Error(err)
+	}
+	if err := (postgresOptions{tableOptions: tableOptions{BatchSize: 1000}}).validate(); err == nil {
+		t.Error("a batch_size for COPY is valid")
+	}
+	if err := (postgresOptions{tableOptions: tableOptions{CommitEvery: -1}}).validate(); err == nil {
+		t.Error("a negative commit_every is valid")
+	}
+}
+
+// recordPgConn is a Postgres connection that records what the writer sends:
+// begin, commit, rollback, "exec <statement>", "copy <table> (<columns>)"
+// and each copied row.
+type recordPgConn struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (c *recordPgConn) record(event string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.events = append(c.events, event)
+}
+
+func (c *recordPgConn) Begin(context.Context) (pgx.Tx, error) {
+	c.record("begin")
+	return &recordPgTx{c: c}, nil
+}
+
+func (c *recordPgConn) Exec(_ context.Context, sql string, _ ...any) (pgconn.CommandTag, error) {
+	c.record("exec " + sql)
+	return pgconn.CommandTag{}, nil
+}
+
+func (c *recordPgConn) Close(context.Context) error { return nil }
+
+type recordPgTx struct {
+	pgx.Tx
+	c *recordPgConn
+}
+
+func (tx *recordPgTx) Commit(context.Context) error   { tx.c.record("commit"); return nil }
+func (tx *recordPgTx) Rollback(context.Context) error { tx.c.record("rollback"); return nil }
+
+func (tx *recordPgTx) Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error) {
+	return tx.c.Exec(ctx, sql, args...)
+}
+
+func (tx *recordPgTx) CopyFrom(_ context.Context, table pgx.Identifier, cols []string, src pgx.CopyFromSource) (int64, error) {
+	tx.c.record(fmt.Sprintf("copy %s (%s)", table.Sanitize(), strings.Join(cols, ", ")))
+	var n int64
+	for src.Next() {
+		values, _ := src.Values()
+		tx.c.record(fmt.Sprint(values))
+		n++
+	}
+	return n, src.Err()
+}
+
+func newRecordPostgresWriter(o postgresOptions) (*postgresWriter, *recordPgConn) {
+	conn := &recordPgConn{}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &postgresWriter{opts: o, table: pgx.Identifier{"public", "orders"}, ctx: ctx, cancel: cancel, conn: conn}, conn
+}
+
+func TestPostgresWriterBatches(t *testing.T) {
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	p, conn := newRecordPostgresWriter(postgresOptions{
+		tableOptions: tableOptions{CommitEvery: 2, Pre: []string{"TRUNCATE {table}"}, Post: []string{"ANALYZE {table}"}},
+		CreateTable:  true,
+	})
+	cols := []column{{Name: "id", DBType: "BIGINT"}, {Name: "name", DBType: "NVARCHAR"}, {Name: "amount", DBType: "DECIMAL", Precision: 10, Scale: 2}}
+	if err := p.WriteHeader(cols); err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 3; i++ {
+		if err := p.WriteRow([]any{int64(i), fmt.Sprintf("n%d", i), nil}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	copy := `copy "public"."orders" (id, name, amount)`
+	want := []string{
+		`begin`, `exec CREATE TABLE IF NOT EXISTS "public"."orders" ("id" bigint, "name" text, "amount" numeric(10,2))`,
+		`exec TRUNCATE "public"."orders"`, copy, `[1 n1 <nil>]`, `[2 n2 <nil>]`, `commit`,
+		`begin`, copy, `[3 n3 <nil>]`, `commit`,
+		`exec ANALYZE "public"."orders"`,
+	}
+	if !reflect.DeepEqual(conn.events, want) {
+		t.Errorf("got\n%s\nwant\n%s", strings.Join(conn.events, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestPostgresWriterAbort(t *testing.T) {
+	p, conn := newRecordPostgresWriter(postgresOptions{tableOptions: tableOptions{CommitEvery: 2}})
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	if err := p.WriteHeader([]column{{Name: "id", DBType: "BIGINT"}}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 3; i++ {
+		p.WriteRow([]any{int64(i)})
+	}
+	p.Abort()
+	// the first batch stays committed and the running copy is rolled back
+	events := conn.events
+	if events[len(events)-1] != "rollback" || strings.Count(strings.Join(events, " "), "commit") != 1 {
+		t.Errorf("events %v", events)
+	}
+}
+
+func TestPostgresValue(t *testing.T) {
+	at := time.Date(2026, 10, 15, 9, 30, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		c    column
+		v    any
+		want string
+	}{
+		{column{DBType: "INT"}, int32(7), "int64 7"},
+		{column{DBType: "FLOAT"}, []byte("2.5"), "float64 2.5"},
+		{column{DBType: "BIT"}, int64(1), "bool true"},
+		{column{DBType: "DATETIME2"}, at, "time.Time 2026-10-15 09:30:00 +0000 UTC"},
+		{column{DBType: "VARBINARY"}, []byte{1}, "[]uint8 [1]"},
+		{column{DBType: "NVARCHAR"}, []byte("Åsa"), "string Åsa"},
+	} {
+		v, err := postgresValue(tc.c, tc.v)
+		if got := fmt.Sprintf("%T %v", v, v); err != nil || got != tc.want {
+			t.Errorf("%s %v: got %s, %v, want %s", tc.c.DBType, tc.v, got, err, tc.want)
+		}
+	}
+	n, err := postgresValue(column{DBType: "DECIMAL", Precision: 10, Scale: 2}, []byte("12.34"))
+	if d, ok := n.(pgtype.Numeric); err != nil || !ok || d.Int.Int64() != 1234 || d.Exp != -2 {
+		t.Errorf("decimal: got %#v, %v", n, err)
+	}
+}
+
+func TestPostgresReplayable(t *testing.T) {
+	c := &config{}
+	if !c.replayable("postgres://public.orders") {
+		t.Error("a load in one transaction is not replayable")
+	}
+	c.Postgres.CommitEvery = 1000
+	if c.replayable("postgres://public.orders") {
+		t.Error("a load committing every 1000 rows is replayable")
+	}
+}
@@ -0,0 +1,38 @@
+package extract
+
+import (
+	"os"
+	"strconv"
+	"syscall"
+)
+
+// Linux keeps the nice value and I/O priority of each thread, which the
+// threads started later inherit.
+const (
+	backgroundNice = 10
+	// the lowest priority of the best-effort class, as ionice -c2 -n7
+	ioprioWhoProcess = 1
+	ioprioBackground = 2<<13 | 7
+)
+
+// lowerPriority gives every thread of the process the nice value and I/O
+// priority of a background job.
+func lowerPriority() error {
+	tasks, err := os.ReadDir("/proc/self/task")
+	if err != nil {
+		return err
+	}
+	for _, task := range tasks {
+		tid, err := strconv.Atoi(task.Name())
+		if err != nil {
+			continue
+		}
+		if err := syscall.Setpriority(syscall.PRIO_PROCESS, tid, backgroundNice); err != nil {
+			return err
+		}
+		if _, _, errno := syscall.Syscall(syscall.SYS_IOPRIO_SET, ioprioWhoProcess, uintptr(tid), ioprioBackground); errno != 0 {
+			return errno
+		}
+	}
+	return nil
+}
@@ -0,0 +1,9 @@
+//go:build !linux && !windows
+
+package extract
+
+import "errors"
+
+func lowerPriority() error {
+	return errors.New("background priority is only supported on Linux and Windows")
+}
@@ -0,0 +1,98 @@
+package extract
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"runtime"
+	rpprof "runtime/pprof"
+	"strings"
+)
+
+// startProfiles parses a -profile value such as cpu=cpu.out,heap=heap.out
+// and starts the requested profiles. The returned function writes them out.
+func startProfiles(spec string) (func(), error) {
+	if spec == "" {
+		return func() {}, nil
+	}
+	profiles := map[string]string{}
+	for _, item := range strings.Split(spec, ",") {
+		kind, path, ok := strings.Cut(strings.TrimSpace(item), "=")
+		if !ok || path == "" {
+			return nil, fmt.Errorf("Invalid profile '%s', expected kind=file\n", item)
+		}
+		switch kind {
+		case "cpu", "heap", "allocs", "goroutine", "threadcreate":
+		case "block":
+			runtime.SetBlockProfileRate(1)
+		case "mutex":
+			runtime.SetMutexProfileFraction(1)
+		default:
+			return nil, fmt.Errorf("Unsupported profile '%s'\n", kind)
+		}
+		profiles[kind] = path
+	}
+
+	var cpu *os.File
+	if path, ok := profiles["cpu"]; ok {
+		f, err := os.Create(path)
+		if err != nil {
+			return nil, fmt.Errorf("Could not create CPU profile %s: %v\n", path, err)
+		}
+		if err := rpprof.StartCPUProfile(f); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("Could not start CPU profile: %v\n", err)
+		}
+		cpu = f
+	}
+
+	return func() {
+		if cpu != nil {
+			rpprof.StopCPUProfile()
+			cpu.Close()
+		}
+		for kind, path := range profiles {
+			if kind == "cpu" {
+				continue
+			}
+			if kind == "heap" {
+				runtime.GC()
+			}
+			if err := writeProfile(kind, path); err != nil {
+				log.Println(err)
+			}
+		}
+	}, nil
+}
+
+// writeProfile writes the named runtime profile to path.
+func writeProfile(kind, path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("Could not create %s profile %s: %v\n", kind, path, err)
+	}
+	defer f.Close()
+	if err := rpprof.Lookup(kind).WriteTo(f, 0); err != nil {
+		return fmt.Errorf("Could not write %s profile %s: %v\n", kind, path, err)
+	}
+	return nil
+}
+
+// servePprof serves the pprof endpoints under /debug/pprof/ on addr in the
+// background.
+func servePprof(addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	go func() {
+		log.Printf("Serving pprof on http://%s/debug/pprof/\n", addr)
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			log.Printf("Warning: pprof server stopped: %v\n", err)
+		}
+	}()
+}
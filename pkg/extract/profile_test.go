@@ -0,0 +1,60 @@
+package extract
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestStartProfiles(t *testing.T) {
+	dir := t.TempDir()
+	kinds := []string{"cpu", "heap", "goroutine", "mutex"}
+	var spec []string
+	for _, kind := range kinds {
+		spec = append(spec, kind+"="+filepath.Join(dir, kind+".pprof"))
+	}
+	stop, err := startProfiles(strings.Join(spec, ", "))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { runtime.SetMutexProfileFraction(0) })
+	var sink [][]byte
+	for i := range 1000 {
+		sink = append(sink, bytes.Repeat([]byte{byte(i)}, 1024))
+	}
+	stop()
+
+	// every profile is a gzipped pprof protobuf
+	for _, kind := range kinds {
+		data, err := os.ReadFile(filepath.Join(dir, kind+".pprof"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			t.Errorf("%s profile is not gzipped: %v", kind, err)
+			continue
+		}
+		if body, err := io.ReadAll(r); err != nil || len(body) == 0 {
+			t.Errorf("%s profile is empty: %v", kind, err)
+		}
+	}
+
+	for _, tc := range []struct{ spec, want string }{
+		{"cpu", "expected kind=file"},
+		{"heap=", "expected kind=file"},
+		{"trace=trace.out", "Unsupported profile 'trace'"},
+	} {
+		if _, err := startProfiles(tc.spec); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%q: got %v, want %q", tc.spec, err, tc.want)
+		}
+	}
+	if stop, err := startProfiles(""); err != nil || stop == nil {
+		t.Errorf("no profiles: %v", err)
+	}
+}
@@ -0,0 +1,209 @@
+package extract
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/term"
+)
+
+// progressBarEvery is how often the progress bars are redrawn.
+const progressBarEvery = 500 * time.Millisecond
+
+// runningJob is how far a running job has got.
+type runningJob struct {
+	outFile  string
+	elapsed  time.Duration
+	progress jobProgress
+	// expected is the rows of the job's last successful run, or 0.
+	expected uint
+}
+
+// eta returns how long the job will take at its current rate to write the
+// rows of its last run, or false if that cannot be told.
+func (j runningJob) eta() (time.Duration, bool) {
+	return estimate(j.progress.Rows, j.elapsed.Seconds(), j.expected)
+}
+
+// estimate returns how long writing want rows takes at the rate of rows
+// written in elapsed seconds, or false if that cannot be told.
+func estimate(rows uint, elapsed float64, want uint) (time.Duration, bool) {
+	if elapsed <= 0 || rows == 0 || want <= rows {
+		return 0, false
+	}
+	perSecond := float64(rows) / elapsed
+	return time.Duration(float64(want-rows)/perSecond) * time.Second, true
+}
+
+// expectedRows returns the rows of each job's last successful run in the
+// ledger, for the ETA.
+func (c *config) expectedRows() map[string]uint {
+	expected := map[string]uint{}
+	l, err := loadLedger(c.Ledger, c.keys)
+	if err != nil {
+		return expected
+	}
+	for _, outFile := range c.OutFiles {
+		if e := l.entry(outFile); e != nil {
+			expected[outFile] = e.Rows
+		}
+	}
+	return expected
+}
+
+// runningJobs returns the jobs of control that are running, in the order of
+// the configuration.
+func (c *config) runningJobs(control *controller, expected map[string]uint) []runningJob {
+	states := control.states()
+	var jobs []runningJob
+	for _, outFile := range c.OutFiles {
+		if states[outFile] != jobRunning {
+			continue
+		}
+		started, p := control.jobProgress(outFile)
+		if started.IsZero() {
+			continue
+		}
+		jobs = append(jobs, runningJob{outFile: outFile, elapsed: time.Since(started), progress: p, expected: expected[outFile]})
+	}
+	return jobs
+}
+
+// startProgress reports the progress of the running jobs until the returned
+// function is called: a log line per job every -progress, or bars redrawn
+// in place under -progress-bar when stderr is a terminal.
+func (c *config) startProgress(control *controller) func() {
+	every := c.progressEvery
+	var bar *progressBar
+	if c.progressBar && jsonLog == nil && term.IsTerminal(int(os.Stderr.Fd())) {
+		bar = &progressBar{w: log.Writer()}
+		log.SetOutput(bar)
+		every = progressBarEvery
+	}
+	if every <= 0 {
+		return func() {}
+	}
+	expected := c.expectedRows()
+	done, stopped := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(stopped)
+		t := time.NewTicker(every)
+		defer t.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-t.C:
+			}
+			jobs := c.runningJobs(control, expected)
+			if bar != nil {
+				bar.draw(jobs, c.jobName)
+				continue
+			}
+			for _, j := range jobs {
+				// the jobs started since the last tick have nothing to tell yet
+				if j.elapsed >= every {
+					c.logProgress(j)
+				}
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+		if bar != nil {
+			bar.draw(nil, c.jobName)
+			log.SetOutput(bar.w)
+		}
+	}
+}
+
+// logProgress logs how far the job j has got.
+func (c *config) logProgress(j runningJob) {
+	written := fmt.Sprintf("%d byte(s)", j.progress.Bytes)
+	if console.human {
+		written = humanSize(j.progress.Bytes)
+	}
+	msg := fmt.Sprintf("Progress of %s: %s row(s), %s written in %s", j.outFile, console.count(int64(j.progress.Rows)), written, console.duration(j.elapsed))
+	attrs := []slog.Attr{
+		slog.String("state", jobRunning), slog.Uint64("rows", uint64(j.progress.Rows)),
+		slog.Int64("bytes_written", j.progress.Bytes), slog.Float64("elapsed_seconds", j.elapsed.Seconds()),
+	}
+	if eta, ok := j.eta(); ok {
+		msg += fmt.Sprintf(", about %s left", console.duration(eta))
+		attrs = append(attrs, slog.Float64("eta_seconds", eta.Seconds()))
+	}
+	c.logJob(j.outFile, msg+"\n", attrs...)
+}
+
+// progressBarWidth is how many cells the bar of a job with an ETA takes.
+const progressBarWidth = 24
+
+// progressBar draws a line per running job at the bottom of the terminal.
+// It takes the place of the log's writer, so that log lines are written
+// above the bars.
+type progressBar struct {
+	mu    sync.Mutex
+	w     io.Writer
+	lines []string
+}
+
+func (b *progressBar) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.erase()
+	n, err := b.w.Write(p)
+	b.render()
+	return n, err
+}
+
+// draw replaces the bars with those of jobs.
+func (b *progressBar) draw(jobs []runningJob, name func(outFile string) string) {
+	width := 0
+	for _, j := range jobs {
+		width = max(width, len(name(j.outFile)))
+	}
+	lines := make([]string, 0, len(jobs))
+	for _, j := range jobs {
+		lines = append(lines, progressLine(j, name(j.outFile), width))
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.erase()
+	b.lines = lines
+	b.render()
+}
+
+// erase clears the bars, leaving the cursor where the first one was.
+func (b *progressBar) erase() {
+	if len(b.lines) > 0 {
+		io.WriteString(b.w, strings.Repeat("\033[1A\033[2K", len(b.lines)))
+	}
+}
+
+func (b *progressBar) render() {
+	for _, line := range b.lines {
+		io.WriteString(b.w, line+"\n")
+	}
+}
+
+// progressLine is the bar of the job j, named name padded to width. A job
+// without an ETA gets its counts alone.
+func progressLine(j runningJob, name string, width int) string {
+	counts := fmt.Sprintf("%s rows  %s  %s", consoleMode{human: true}.count(int64(j.progress.Rows)), humanSize(j.progress.Bytes),
+		consoleMode{human: true}.duration(j.elapsed))
+	eta, ok := j.eta()
+	if !ok {
+		return fmt.Sprintf("%-*s  %s", width, name, counts)
+	}
+	done := float64(j.progress.Rows) / float64(j.expected)
+	filled := int(done * progressBarWidth)
+	return fmt.Sprintf("%-*s  [%s%s] %3.0f%%  %s  ETA %s", width, name, strings.Repeat("#", filled), strings.Repeat("-", progressBarWidth-filled),
+		done*100, counts, consoleMode{human: true}.duration(eta))
+}
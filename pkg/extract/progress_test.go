@@ -0,0 +1,50 @@
+package extract
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestEstimate(t *testing.T) {
+	if eta, ok := estimate(1000, 10, 3000); !ok || eta != 20*time.Second {
+		t.Errorf("estimate = %s, %v, want 20s", eta, ok)
+	}
+	for _, tc := range []struct {
+		rows    uint
+		elapsed float64
+		want    uint
+	}{{0, 10, 3000}, {1000, 0, 3000}, {1000, 10, 0}, {3000, 10, 1000}} {
+		if _, ok := estimate(tc.rows, tc.elapsed, tc.want); ok {
+			t.Errorf("estimate(%d, %g, %d) has an ETA", tc.rows, tc.elapsed, tc.want)
+		}
+	}
+}
+
+func TestProgressLine(t *testing.T) {
+	j := runningJob{outFile: "orders.csv", elapsed: time.Minute, progress: jobProgress{Rows: 250000, Bytes: 30 << 20}, expected: 1000000}
+	got := progressLine(j, "orders", 8)
+	want := "orders    [######------------------]  25%  250,000 rows  30.0 MiB  1m0s  ETA 3m0s"
+	if got != want {
+		t.Errorf("got  %q\nwant %q", got, want)
+	}
+	j.expected = 0
+	if got := progressLine(j, "orders", 6); got != "orders  250,000 rows  30.0 MiB  1m0s" {
+		t.Errorf("without an ETA: %q", got)
+	}
+}
+
+func TestProgressBar(t *testing.T) {
+	var out bytes.Buffer
+	b := &progressBar{w: &out}
+	b.draw([]runningJob{{outFile: "a.csv", elapsed: time.Second, progress: jobProgress{Rows: 10}}}, func(s string) string { return s })
+	b.Write([]byte("Extraction completed for b.csv\n"))
+	b.draw(nil, func(s string) string { return s })
+
+	bar := "a.csv  10 rows  0 B  1s\n"
+	erase := "\033[1A\033[2K"
+	want := bar + erase + "Extraction completed for b.csv\n" + bar + erase
+	if out.String() != want {
+		t.Errorf("got %q\nwant %q", out.String(), want)
+	}
+}
@@ -0,0 +1,232 @@
+package extract
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+	"github.com/segmentio/kafka-go"
+)
+
+// queueOptions makes -listen start a flow or job on each readiness event
+// published to a Kafka topic or an Azure Service Bus queue or subscription.
+type queueOptions struct {
+	Kafka      kafkaOptions      `yaml:"kafka"`
+	ServiceBus serviceBusOptions `yaml:"service_bus"`
+}
+
+// kafkaOptions reads the topic as the consumer group Group, committing each
+// message as its run starts.
+type kafkaOptions struct {
+	Brokers []string `yaml:"brokers"`
+	Topic   string   `yaml:"topic"`
+	Group   string   `yaml:"group"`
+}
+
+// serviceBusOptions reads a queue, or the subscription of a topic, of the
+// namespace such as corp.servicebus.windows.net, logging in with the Azure
+// default credential unless ConnectionStringEnv names the environment
+// variable holding a connection string.
+type serviceBusOptions struct {
+	Namespace           string `yaml:"namespace"`
+	ConnectionStringEnv string `yaml:"connection_string_env"`
+	Queue               string `yaml:"queue"`
+	Topic               string `yaml:"topic"`
+	Subscription        string `yaml:"subscription"`
+}
+
+func (o queueOptions) enabled() bool {
+	return o.Kafka.Topic != "" || len(o.Kafka.Brokers) > 0 || o.ServiceBus != (serviceBusOptions{})
+}
+
+func (o queueOptions) validate() error {
+	k, sb := o.Kafka, o.ServiceBus
+	kafkaSet := k.Topic != "" || len(k.Brokers) > 0 || k.Group != ""
+	if kafkaSet && sb != (serviceBusOptions{}) {
+		return fmt.Errorf("queue takes either kafka or service_bus\n")
+	}
+	if kafkaSet && (len(k.Brokers) == 0 || k.Topic == "" || k.Group == "") {
+		return fmt.Errorf("queue kafka needs brokers, a topic and a group\n")
+	}
+	if sb == (serviceBusOptions{}) {
+		return nil
+	}
+	if (sb.Namespace == "") == (sb.ConnectionStringEnv == "") {
+		return fmt.Errorf("queue service_bus needs either a namespace or a connection_string_env\n")
+	}
+	if (sb.Queue == "") == (sb.Topic == "" && sb.Subscription == "") || (sb.Topic == "") != (sb.Subscription == "") {
+		return fmt.Errorf("queue service_bus needs either a queue or a topic and subscription\n")
+	}
+	return nil
+}
+
+// queueRetryWait is how long -listen waits after failing to read the queue.
+const queueRetryWait = 30 * time.Second
+
+// queueMessage is the JSON payload of a readiness event: the flow or job to
+// start and the values of its params, such as the partition date.
+type queueMessage struct {
+	Flow   string            `json:"flow"`
+	Job    string            `json:"job"`
+	Params map[string]string `json:"params"`
+}
+
+// parseQueueMessage reads a readiness event and checks that it names one
+// flow or job of c.
+func parseQueueMessage(c *config, body []byte) (queueMessage, error) {
+	var m queueMessage
+	if err := json.Unmarshal(body, &m); err != nil {
+		return m, fmt.Errorf("the message is not a JSON object: %v", err)
+	}
+	switch {
+	case (m.Flow == "") == (m.Job == ""):
+		return m, fmt.Errorf("the message must name either a flow or a job")
+	case m.Flow != "":
+		if _, ok := c.Flows[m.Flow]; !ok {
+			return m, fmt.Errorf("no flow is named %s", m.Flow)
+		}
+	default:
+		if _, err := c.jobsNamed([]string{m.Job}); err != nil {
+			return m, fmt.Errorf("no job is named %s", m.Job)
+		}
+	}
+	return m, nil
+}
+
+// queueConsumer reads readiness events. receive blocks for the next message
+// and acknowledges it, so it is not delivered again.
+type queueConsumer interface {
+	receive(ctx context.Context) ([]byte, error)
+	close() error
+}
+
+func newQueueConsumer(o queueOptions) (queueConsumer, error) {
+	if o.Kafka.Topic != "" {
+		r := kafka.NewReader(kafka.ReaderConfig{Brokers: o.Kafka.Brokers, Topic: o.Kafka.Topic, GroupID: o.Kafka.Group})
+		return &kafkaConsumer{r: r}, nil
+	}
+	sb := o.ServiceBus
+	var client *azservicebus.Client
+	var err error
+	if sb.ConnectionStringEnv != "" {
+		cs := os.Getenv(sb.ConnectionStringEnv)
+		if cs == "" {
+			return nil, fmt.Errorf("The Service Bus connection string variable %s is not set\n", sb.ConnectionStringEnv)
+		}
+		client, err = azservicebus.NewClientFromConnectionString(cs, nil)
+	} else {
+		var cred *azidentity.DefaultAzureCredential
+		if cred, err = azidentity.NewDefaultAzureCredential(nil); err == nil {
+			client, err = azservicebus.NewClient(sb.Namespace, cred, nil)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Could not connect to Service Bus: %v\n", err)
+	}
+	var r *azservicebus.Receiver
+	if sb.Queue != "" {
+		r, err = client.NewReceiverForQueue(sb.Queue, nil)
+	} else {
+		r, err = client.NewReceiverForSubscription(sb.Topic, sb.Subscription, nil)
+	}
+	if err != nil {
+		client.Close(context.Background())
+		return nil, fmt.Errorf("Could not connect to Service Bus: %v\n", err)
+	}
+	return &serviceBusConsumer{client: client, r: r}, nil
+}
+
+type kafkaConsumer struct {
+	r *kafka.Reader
+}
+
+func (k *kafkaConsumer) receive(ctx context.Context) ([]byte, error) {
+	m, err := k.r.FetchMessage(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return m.Value, k.r.CommitMessages(ctx, m)
+}
+
+func (k *kafkaConsumer) close() error { return k.r.Close() }
+
+type serviceBusConsumer struct {
+	client *azservicebus.Client
+	r      *azservicebus.Receiver
+}
+
+func (s *serviceBusConsumer) receive(ctx context.Context) ([]byte, error) {
+	for {
+		msgs, err := s.r.ReceiveMessages(ctx, 1, nil)
+		if err != nil {
+			return nil, err
+		}
+		if len(msgs) == 0 {
+			continue
+		}
+		return msgs[0].Body, s.r.CompleteMessage(ctx, msgs[0], nil)
+	}
+}
+
+func (s *serviceBusConsumer) close() error {
+	s.r.Close(context.Background())
+	return s.client.Close(context.Background())
+}
+
+// listenQueue starts the flow or job of every readiness event on the queue
+// of c until the admin drain command or ctx ends. Each message is
+// acknowledged as its run starts, and each run loads the configuration
+// afresh with loadFlow and sets the params the message carries.
+func listenQueue(ctx context.Context, c *config, control *controller, loadFlow func(flow string) (*config, error)) error {
+	consumer, err := newQueueConsumer(c.Queue)
+	if err != nil {
+		return err
+	}
+	defer consumer.close()
+	// draining stops waiting for the next message, not the run in progress
+	recv, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-control.drained:
+			cancel()
+		case <-recv.Done():
+		}
+	}()
+
+	log.Println("Listening for readiness events")
+	for recv.Err() == nil && control.hold() {
+		body, err := consumer.receive(recv)
+		if err != nil {
+			if recv.Err() == nil {
+				log.Printf("Warning: could not read the queue: %v\n", err)
+				// wait before reading again, unless the run drains
+				if !control.sleepUntil(time.Now().Add(queueRetryWait)) {
+					break
+				}
+			}
+			continue
+		}
+		m, err := parseQueueMessage(c, body)
+		if err != nil {
+			log.Printf("Warning: ignoring a message, %v\n", err)
+			continue
+		}
+		target := m.Flow + m.Job
+		if !c.awaitMaintenance(control, target) {
+			break
+		}
+		log.Printf("Readiness event starts %s\n", target)
+		if err := runTrigger(ctx, control, loadFlow, target, m.Params); err != nil {
+			log.Printf("Warning: run of %s failed: %v\n", target, strings.TrimSpace(err.Error()))
+		}
+	}
+	log.Println("Stopped listening for readiness events")
+	return nil
+}
@@ -0,0 +1,55 @@
+package extract
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestQueueOptions(t *testing.T) {
+	for _, ok := range []queueOptions{
+		{},
+		{Kafka: kafkaOptions{Brokers: []string{"kafka01:9092"}, Topic: "extract-ready", Group: "sql-export-wiz"}},
+		{ServiceBus: serviceBusOptions{Namespace: "corp.servicebus.windows.net", Queue: "extract-ready"}},
+		{ServiceBus: serviceBusOptions{ConnectionStringEnv: "SB_CONN", Topic: "ready", Subscription: "extracts"}},
+	} {
+		if err := ok.validate(); err != nil {
+			t.Errorf("%+v: %v", ok, err)
+		}
+	}
+	for _, bad := range []queueOptions{
+		{Kafka: kafkaOptions{Topic: "extract-ready"}},
+		{Kafka: kafkaOptions{Brokers: []string{"kafka01:9092"}, Topic: "t", Group: "g"}, ServiceBus: serviceBusOptions{Queue: "q"}},
+		{ServiceBus: serviceBusOptions{Queue: "extract-ready"}},
+		{ServiceBus: serviceBusOptions{Namespace: "ns", ConnectionStringEnv: "SB_CONN", Queue: "q"}},
+		{ServiceBus: serviceBusOptions{Namespace: "ns"}},
+		{ServiceBus: serviceBusOptions{Namespace: "ns", Queue: "q", Topic: "t", Subscription: "s"}},
+		{ServiceBus: serviceBusOptions{Namespace: "ns", Topic: "t"}},
+	} {
+		if err := bad.validate(); err == nil {
+			t.Errorf("accepted %+v", bad)
+		}
+	}
+}
+
+func TestParseQueueMessage(t *testing.T) {
+	c := &config{
+		Extracts: []extract{{Name: "orders", OutFile: "orders.csv"}},
+		OutFiles: []string{"orders.csv"},
+		Flows:    map[string]flowOptions{"month-end": {Jobs: []string{"orders"}}},
+	}
+	m, err := parseQueueMessage(c, []byte(`{"flow": "month-end", "params": {"partition_date": "2025-03-31"}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (queueMessage{Flow: "month-end", Params: map[string]string{"partition_date": "2025-03-31"}}); !reflect.DeepEqual(m, want) {
+		t.Errorf("got %+v", m)
+	}
+	if _, err := parseQueueMessage(c, []byte(`{"job": "orders.csv"}`)); err != nil {
+		t.Errorf("job by outfile: %v", err)
+	}
+	for _, bad := range []string{`ready`, `{}`, `{"flow": "month-end", "job": "orders"}`, `{"flow": "daily"}`, `{"job": "invoices"}`} {
+		if _, err := parseQueueMessage(c, []byte(bad)); err == nil {
+			t.Errorf("accepted %s", bad)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package extract
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+)
+
+// defaultReadyPoll is how often a readiness query is run unless ready.poll
+// is set.
+const defaultReadyPoll = time.Minute
+
+// readyOptions holds the readiness probe of a job, run with its params before
+// the extract until the first column of its first row is not NULL and, if
+// Value is set, equals it. MaxWait fails the job if it is not ready in time.
+type readyOptions struct {
+	Query   string         `yaml:"query"`
+	Value   string         `yaml:"value"`
+	Poll    configDuration `yaml:"poll"`
+	MaxWait configDuration `yaml:"max_wait"`
+}
+
+func validateReady(c *config) error {
+	for _, e := range c.Extracts {
+		r := e.Ready
+		if r.Query == "" && r != (readyOptions{}) {
+			return fmt.Errorf("Extract %s ready needs a query\n", e.Name)
+		}
+		if r.Poll < 0 || r.MaxWait < 0 {
+			return fmt.Errorf("Extract %s ready poll and max_wait cannot be negative\n", e.Name)
+		}
+	}
+	return nil
+}
+
+// awaitReady runs the readiness query of the job writing outFile every poll
+// until it reports the source loaded. Replayed runs do not wait.
+func (c *config) awaitReady(ctx context.Context, db *sql.DB, outFile string) error {
+	e, ok := c.extract(outFile)
+	if !ok || e.Ready.Query == "" || c.replayDir != "" {
+		return nil
+	}
+	poll := time.Duration(e.Ready.Poll)
+	if poll == 0 {
+		poll = defaultReadyPoll
+	}
+	started := time.Now()
+	for waiting := false; ; waiting = true {
+		ready, status, err := e.Ready.check(ctx, db, c.queryArgs(outFile))
+		if err != nil {
+			return fmt.Errorf("Unable to execute the readiness query '%s': %v\n", e.Ready.Query, err)
+		}
+		if ready {
+			if waiting {
+				log.Printf("%s is ready after %s\n", outFile, time.Since(started).Round(time.Second))
+			}
+			return nil
+		}
+		wait := poll
+		if e.Ready.MaxWait > 0 {
+			left := time.Duration(e.Ready.MaxWait) - time.Since(started)
+			if left <= 0 {
+				return fmt.Errorf("%s was not ready after %s, the readiness query returned %s\n", outFile, time.Duration(e.Ready.MaxWait), status)
+			}
+			wait = min(wait, left)
+		}
+		if !waiting {
+			log.Printf("Waiting for %s to be ready, the readiness query returned %s\n", outFile, status)
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+}
+
+// check runs the readiness query once, returning whether the source is
+// ready and what the query returned.
+func (r readyOptions) check(ctx context.Context, db *sql.DB, args []any) (bool, string, error) {
+	v, found, err := queryScalar(ctx, db, r.Query, args)
+	switch {
+	case err != nil:
+		return false, "", err
+	case !found:
+		return false, "no rows", nil
+	case v == nil:
+		return false, "NULL", nil
+	}
+	value := formatValue(v)
+	return r.Value == "" || value == r.Value, fmt.Sprintf("'%s'", value), nil
+}
+
+// queryScalar returns the first column of the first row of a query, and
+// whether it returned a row.
+func queryScalar(ctx context.Context, db *sql.DB, query string, args []any) (any, bool, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, false, err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		return nil, false, rows.Err()
+	}
+	cols, err := rows.Columns()
+	if err != nil {
+		return nil, false, err
+	}
+	row := make([]any, len(cols))
+	ptrs := make([]any, len(cols))
+	for i := range row {
+		ptrs[i] = &row[i]
+	}
+	if err := rows.Scan(ptrs...); err != nil {
+		return nil, false, err
+	}
+	return row[0], true, nil
+}
@@ -0,0 +1,61 @@
+package extract
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"log"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAwaitReady(t *testing.T) {
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	calls := 0
+	registerFakeHandler(t, func(query string) (*fakeResult, bool) {
+		if query != "SELECT status FROM etl.LoadControl" {
+			return nil, false
+		}
+		calls++
+		status := "LOADING"
+		if calls == 3 {
+			status = "COMPLETE"
+		}
+		return &fakeResult{
+			columns: []fakeColumn{{name: "status", dbType: "VARCHAR", scanType: reflect.TypeOf("")}},
+			rows:    1,
+			value:   func(row, col int) driver.Value { return status },
+		}, true
+	})
+	db, err := sql.Open("fakedb", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	ready := readyOptions{Query: "SELECT status FROM etl.LoadControl", Value: "COMPLETE", Poll: configDuration(time.Millisecond)}
+	c := &config{Extracts: []extract{{Name: "orders", OutFile: "orders.csv", Ready: ready}}}
+	if err := c.awaitReady(context.Background(), db, "orders.csv"); err != nil || calls != 3 {
+		t.Errorf("got %v after %d queries, want ready after 3", err, calls)
+	}
+
+	calls = 0
+	c.Extracts[0].Ready.MaxWait = configDuration(time.Millisecond)
+	c.Extracts[0].Ready.Poll = configDuration(time.Hour)
+	err = c.awaitReady(context.Background(), db, "orders.csv")
+	if err == nil || !strings.Contains(err.Error(), "was not ready after 1ms, the readiness query returned 'LOADING'") {
+		t.Errorf("got %v, want not ready", err)
+	}
+
+	for _, bad := range []readyOptions{{Value: "COMPLETE"}, {Query: "SELECT 1", Poll: -1}, {Query: "SELECT 1", MaxWait: -1}} {
+		c.Extracts[0].Ready = bad
+		if err := validateReady(c); err == nil {
+			t.Errorf("accepted %+v", bad)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package extract
+
+import (
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	_ "github.com/jackc/pgx/v5/stdlib"
+)
+
+// redshiftOptions holds the settings for redshift:// destinations.
+type redshiftOptions struct {
+	// DSNEnv names the environment variable holding the Redshift connection string.
+	DSNEnv string `yaml:"dsn_env"`
+	// Staging is the s3://bucket/prefix location COPY loads from.
+	Staging string `yaml:"staging"`
+	// IAMRole is the role ARN Redshift assumes to read the staged file.
+	IAMRole string `yaml:"iam_role"`
+	// Format of the staged file: parquet (default) or csv, which is gzip compressed.
+	Format string `yaml:"format"`
+	// Region of the staging bucket when it differs from the cluster's.
+	Region string `yaml:"region"`
+	// KeepStaged leaves the staged file in S3 after a successful load.
+	KeepStaged bool `yaml:"keep_staged"`
+}
+
+// gzipCSVWriter writes gzip compressed CSV, closing the gzip stream after the
+// CSV writer has flushed.
+type gzipCSVWriter struct {
+	*csvWriter
+	gz *gzip.Writer
+}
+
+func (g *gzipCSVWriter) Close() error {
+	if err := g.csvWriter.Close(); err != nil {
+		return err
+	}
+	return g.gz.Close()
+}
+
+// redshiftWriter streams the result set to S3 and loads it with COPY.
+type redshiftWriter struct {
+	rowWriter
+	opts   redshiftOptions
+	table  string
+	bucket string
+	key    string
+	ctx    context.Context
+	client *s3.Client
+	upload *s3Upload
+	abort  string
+	done   bool
+}
+
+func newRedshiftWriter(table string, o redshiftOptions, abort string) (*redshiftWriter, error) {
+	if o.DSNEnv == "" {
+		o.DSNEnv = "REDSHIFT_DSN"
+	}
+	if o.IAMRole == "" {
+		return nil, fmt.Errorf("Redshift destinations require redshift.iam_role to be set\n")
+	}
+	bucket, prefix, err := splitS3URI(o.Staging)
+	if err != nil {
+		return nil, fmt.Errorf("Redshift destinations require a valid redshift.staging: %v", err)
+	}
+
+	ext := ".parquet"
+	switch o.Format {
+	case "", "parquet":
+	case "csv":
+		ext = ".csv.gz"
+	default:
+		return nil, fmt.Errorf("Unsupported redshift format '%s'\n", o.Format)
+	}
+
+	ctx := context.Background()
+	client, err := newS3Client(ctx, o.Region)
+	if err != nil {
+		return nil, err
+	}
+	key := path.Join(prefix, strings.ReplaceAll(table, ".", "_")+"_"+newUUID()+ext)
+	upload := newS3Upload(ctx, client, bucket, key)
+
+	r := &redshiftWriter{opts: o, table: table, bucket: bucket, key: key, ctx: ctx, client: client, upload: upload}
+	if o.Format == "csv" {
+		gz := gzip.NewWriter(upload)
+		r.rowWriter = &gzipCSVWriter{csvWriter: newCSVWriter(gz, ','), gz: gz}
+	} else {
+		r.rowWriter, _ = newParquetWriter(upload, "snappy")
+	}
+	return r, nil
+}
+
+// Close completes the upload and runs COPY into the target table.
+func (r *redshiftWriter) Close() error {
+	r.done = true
+	if err := r.rowWriter.Close(); err != nil {
+		r.upload.Abort()
+		return err
+	}
+	if err := r.upload.Close(); err != nil {
+		return err
+	}
+	loaded := false
+	defer func() {
+		if !loaded {
+			discardS3Object(r.ctx, r.client, r.bucket, r.key, r.abort)
+		}
+	}()
+
+	dsn := os.Getenv(r.opts.DSNEnv)
+	if dsn == "" {
+		return fmt.Errorf("Redshift DSN environment variable %s is not set\n", r.opts.DSNEnv)
+	}
+	db, err := sql.Open("pgx", dsn)
+	if err != nil {
+		return fmt.Errorf("Could not connect to Redshift: %v\n", err)
+	}
+	defer db.Close()
+
+	if _, err := db.Exec(r.copyStatement()); err != nil {
+		return fmt.Errorf("Redshift COPY into %s failed: %v\n", r.table, err)
+	}
+	log.Printf("Redshift COPY completed for %s from s3://%s/%s\n", r.table, r.bucket, r.key)
+	loaded = true
+
+	if !r.opts.KeepStaged {
+		if _, err := r.client.DeleteObject(r.ctx, &s3.DeleteObjectInput{Bucket: aws.String(r.bucket), Key: aws.String(r.key)}); err != nil {
+			log.Printf("Could not delete staged file s3://%s/%s: %v\n", r.bucket, r.key, err)
+		}
+	}
+	return nil
+}
+
+// copyStatement loads the staged file with the IAM role; a CSV file starts
+// with its header row.
+func (r *redshiftWriter) copyStatement() string {
+	copySQL := fmt.Sprintf("COPY %s FROM 's3://%s/%s' IAM_ROLE '%s'", r.table, r.bucket, r.key, r.opts.IAMRole)
+	if r.opts.Format == "csv" {
+		copySQL += " FORMAT AS CSV GZIP IGNOREHEADER 1"
+	} else {
+		copySQL += " FORMAT AS PARQUET"
+	}
+	if r.opts.Region != "" {
+		copySQL += fmt.Sprintf(" REGION '%s'", r.opts.Region)
+	}
+	return copySQL
+}
+
+// Abort cancels the upload so no partial object is left in S3.
+func (r *redshiftWriter) Abort() {
+	if !r.done {
+		r.done = true
+		r.upload.Abort()
+	}
+}
@@ -0,0 +1,77 @@
+package extract
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestRedshiftCopyStatement(t *testing.T) {
+	t.Setenv("AWS_REGION", "us-east-1")
+	role := "arn:aws:iam::123456789012:role/loader"
+	for _, tc := range []struct {
+		o    redshiftOptions
+		ext  string
+		want string
+	}{
+		{redshiftOptions{}, ".parquet", " FORMAT AS PARQUET"},
+		{redshiftOptions{Format: "csv"}, ".csv.gz", " FORMAT AS CSV GZIP IGNOREHEADER 1"},
+		{redshiftOptions{Region: "eu-west-1"}, ".parquet", " FORMAT AS PARQUET REGION 'eu-west-1'"},
+	} {
+		tc.o.Staging, tc.o.IAMRole = "s3://stage/loads", role
+		r, err := newRedshiftWriter("sales.orders", tc.o, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Abort()
+		if r.bucket != "stage" || !strings.HasPrefix(r.key, "loads/sales_orders_") || !strings.HasSuffix(r.key, tc.ext) {
+			t.Errorf("%+v: staged to s3://%s/%s", tc.o, r.bucket, r.key)
+		}
+		want := "COPY sales.orders FROM 's3://stage/" + r.key + "' IAM_ROLE '" + role + "'" + tc.want
+		if got := r.copyStatement(); got != want {
+			t.Errorf("%+v:\n got %s\nwant %s", tc.o, got, want)
+		}
+	}
+
+	for _, tc := range []struct {
+		o    redshiftOptions
+		want string
+	}{
+		{redshiftOptions{Staging: "s3://stage"}, "iam_role"},
+		{redshiftOptions{IAMRole: role}, "redshift.staging"},
+		{redshiftOptions{Staging: "s3://stage", IAMRole: role, Format: "json"}, "format"},
+	} {
+		if _, err := newRedshiftWriter("sales.orders", tc.o, ""); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%+v: got %v, want %q", tc.o, err, tc.want)
+		}
+	}
+}
+
+func TestGzipCSVWriter(t *testing.T) {
+	// IGNOREHEADER 1 skips the header row the CSV starts with
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	w := &gzipCSVWriter{csvWriter: newCSVWriter(gz, ','), gz: gz}
+	w.WriteHeader([]column{{Name: "id", DBType: "BIGINT"}, {Name: "name", DBType: "NVARCHAR"}})
+	w.WriteRow([]any{int64(1), "a,b"})
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	r, err := gzip.NewReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(r)
+	if string(data) != "id,name\n1,\"a,b\"\n" {
+		t.Errorf("staged %q", data)
+	}
+}
+
+func TestRedshiftReplayable(t *testing.T) {
+	// COPY runs once the file is staged, in a statement of its own
+	if !(&config{}).replayable("redshift://sales.orders") {
+		t.Error("redshift:// is not replayable")
+	}
+}
@@ -0,0 +1,243 @@
+package extract
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"cloud.google.com/go/storage"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+)
+
+// remoteSchemes are the outfile prefixes of files uploaded as they are
+// written: objects in S3, GCS or Azure Blob Storage, and files on SFTP servers.
+var remoteSchemes = []string{"s3://", "gs://", "az://", "sftp://"}
+
+// isRemoteFile reports whether outFile names a file uploaded as it is written.
+func isRemoteFile(outFile string) bool {
+	for _, scheme := range remoteSchemes {
+		if strings.HasPrefix(outFile, scheme) {
+			return true
+		}
+	}
+	return false
+}
+
+// remoteUpload streams a file to where it is stored. Close commits the file;
+// Abort drops the upload so that no partial file becomes visible under its
+// name.
+type remoteUpload interface {
+	io.Writer
+	Close() error
+	Abort()
+}
+
+// openUpload starts the upload of uri. Object storage credentials come from
+// each provider's standard chain: the AWS SDK chain, Application Default
+// Credentials, and AZURE_STORAGE_CONNECTION_STRING or else the Azure default
+// credential for the account in AZURE_STORAGE_ACCOUNT. SFTP servers use
+// their sftp settings.
+func openUpload(c *config, uri string) (remoteUpload, error) {
+	switch {
+	case strings.HasPrefix(uri, "s3://"):
+		bucket, key, err := splitS3URI(uri)
+		if err != nil {
+			return nil, err
+		}
+		if key == "" {
+			return nil, fmt.Errorf("Invalid S3 location '%s'\n", uri)
+		}
+		client, err := newS3Client(context.Background(), "")
+		if err != nil {
+			return nil, err
+		}
+		return newS3Upload(context.Background(), client, bucket, key), nil
+	case strings.HasPrefix(uri, "gs://"):
+		bucket, object, err := splitGCSURI(uri)
+		if err != nil {
+			return nil, err
+		}
+		if object == "" {
+			return nil, fmt.Errorf("Invalid GCS location '%s'\n", uri)
+		}
+		return newGCSUpload(bucket, object)
+	case strings.HasPrefix(uri, "az://"):
+		container, blob, _ := strings.Cut(strings.TrimPrefix(uri, "az://"), "/")
+		if container == "" || blob == "" {
+			return nil, fmt.Errorf("Invalid Azure Blob Storage location '%s'\n", uri)
+		}
+		client, err := newAzureBlobClient()
+		if err != nil {
+			return nil, err
+		}
+		return newAzureUpload(client, container, blob), nil
+	case strings.HasPrefix(uri, "sftp://"):
+		return newSFTPUpload(uri, c.SFTP, c.Abort)
+	}
+	return nil, fmt.Errorf("Unsupported remote location '%s'\n", uri)
+}
+
+// gcsUpload writes one GCS object, which only appears once Close succeeds.
+// It closes client, unless that is nil because a table store shares its own.
+type gcsUpload struct {
+	uri    string
+	client *storage.Client
+	w      *storage.Writer
+	cancel context.CancelFunc
+	once   sync.Once
+	err    error
+}
+
+func newGCSUpload(bucket, object string) (*gcsUpload, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	client, err := storage.NewClient(ctx)
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("Could not create a GCS client: %v\n", err)
+	}
+	w := client.Bucket(bucket).Object(object).NewWriter(ctx)
+	return &gcsUpload{uri: "gs://" + bucket + "/" + object, client: client, w: w, cancel: cancel}, nil
+}
+
+func (u *gcsUpload) Write(p []byte) (int, error) {
+	return u.w.Write(p)
+}
+
+func (u *gcsUpload) Close() error {
+	u.once.Do(func() {
+		if err := u.w.Close(); err != nil {
+			u.err = fmt.Errorf("Upload to %s failed: %v\n", u.uri, err)
+		}
+		u.cancel()
+		u.closeClient()
+	})
+	return u.err
+}
+
+func (u *gcsUpload) closeClient() {
+	if u.client != nil {
+		u.client.Close()
+	}
+}
+
+// Abort cancels the writer's context, which abandons the upload.
+func (u *gcsUpload) Abort() {
+	u.once.Do(func() {
+		u.cancel()
+		u.w.Close()
+		u.closeClient()
+		u.err = fmt.Errorf("Upload to %s was aborted\n", u.uri)
+	})
+}
+
+// newAzureBlobClient connects to Blob Storage with a connection string from
+// the environment, or the account named there and the default credential.
+func newAzureBlobClient() (*azblob.Client, error) {
+	if cs := os.Getenv("AZURE_STORAGE_CONNECTION_STRING"); cs != "" {
+		client, err := azblob.NewClientFromConnectionString(cs, nil)
+		if err != nil {
+			return nil, fmt.Errorf("Could not create an Azure Blob Storage client: %v\n", err)
+		}
+		return client, nil
+	}
+	account := os.Getenv("AZURE_STORAGE_ACCOUNT")
+	if account == "" {
+		return nil, fmt.Errorf("Azure Blob Storage needs AZURE_STORAGE_CONNECTION_STRING or AZURE_STORAGE_ACCOUNT to be set\n")
+	}
+	cred, err := azidentity.NewDefaultAzureCredential(nil)
+	if err != nil {
+		return nil, fmt.Errorf("Could not load Azure credentials: %v\n", err)
+	}
+	client, err := azblob.NewClient(fmt.Sprintf("https://%s.blob.core.windows.net/", account), cred, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Could not create an Azure Blob Storage client: %v\n", err)
+	}
+	return client, nil
+}
+
+// azureUpload streams into a block blob. Its blocks are only committed once
+// the stream ends, so a failed upload leaves the blob as it was.
+type azureUpload struct {
+	uri    string
+	pw     *io.PipeWriter
+	result chan error
+	once   sync.Once
+	err    error
+}
+
+func newAzureUpload(client *azblob.Client, container, blob string) *azureUpload {
+	pr, pw := io.Pipe()
+	u := &azureUpload{uri: "az://" + container + "/" + blob, pw: pw, result: make(chan error, 1)}
+	go func() {
+		_, err := client.UploadStream(context.Background(), container, blob, pr, nil)
+		pr.CloseWithError(err)
+		u.result <- err
+	}()
+	return u
+}
+
+func (u *azureUpload) Write(p []byte) (int, error) {
+	return u.pw.Write(p)
+}
+
+func (u *azureUpload) Close() error {
+	u.once.Do(func() {
+		u.pw.Close()
+		if err := <-u.result; err != nil {
+			u.err = fmt.Errorf("Upload to %s failed: %v\n", u.uri, err)
+		}
+	})
+	return u.err
+}
+
+// Abort fails the stream before the block list is committed.
+func (u *azureUpload) Abort() {
+	u.once.Do(func() {
+		u.pw.CloseWithError(fmt.Errorf("upload aborted"))
+		<-u.result
+		u.err = fmt.Errorf("Upload to %s was aborted\n", u.uri)
+	})
+}
+
+// remoteOutput writes the formatted result to a remote file, through
+// compressor if it is compressed. count is what reaches the upload.
+type remoteOutput struct {
+	rowWriter
+	upload     remoteUpload
+	compressor io.WriteCloser
+	count      *countingWriter
+	closed     bool
+}
+
+func (o *remoteOutput) bytesWritten() int64 {
+	return o.count.n.Load()
+}
+
+// Close finishes the file. One that cannot be finished is aborted.
+func (o *remoteOutput) Close() error {
+	o.closed = true
+	if err := o.rowWriter.Close(); err != nil {
+		o.upload.Abort()
+		return err
+	}
+	if o.compressor != nil {
+		if err := o.compressor.Close(); err != nil {
+			o.upload.Abort()
+			return fmt.Errorf("could not finish the compressed file: %v", err)
+		}
+	}
+	return o.upload.Close()
+}
+
+// Abort drops the upload. Object stores never show a partial object, so the
+// abort policy only applies to the temporary files of SFTP uploads.
+func (o *remoteOutput) Abort() {
+	if !o.closed {
+		o.closed = true
+		o.upload.Abort()
+	}
+}
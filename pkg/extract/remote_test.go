@@ -0,0 +1,69 @@
+package extract
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"testing"
+)
+
+// memoryUpload keeps what is uploaded, and whether it was committed.
+type memoryUpload struct {
+	bytes.Buffer
+	committed, aborted bool
+}
+
+func (u *memoryUpload) Close() error { u.committed = true; return nil }
+func (u *memoryUpload) Abort()       { u.aborted = true }
+
+func TestObjectOutput(t *testing.T) {
+	c := &config{Delimiter: ",", Compression: "gzip"}
+	upload := &memoryUpload{}
+	o := &remoteOutput{upload: upload, count: &countingWriter{w: upload}}
+	var err error
+	if o.compressor, o.rowWriter, err = newFileEncoder(c, o.count, "s3://bucket/out.csv.gz", "s3://bucket/out.csv"); err != nil {
+		t.Fatal(err)
+	}
+	if err := o.WriteHeader([]column{{Name: "id"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := o.WriteRow([]any{int64(1)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := o.Close(); err != nil {
+		t.Fatal(err)
+	}
+	o.Abort()
+	if !upload.committed || upload.aborted {
+		t.Fatalf("committed %v, aborted %v; want only committed", upload.committed, upload.aborted)
+	}
+	if o.bytesWritten() != int64(upload.Len()) {
+		t.Errorf("counted %d bytes, uploaded %d", o.bytesWritten(), upload.Len())
+	}
+	r, err := gzip.NewReader(&upload.Buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _ := io.ReadAll(r)
+	if string(got) != "id\n1\n" {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestObjectOutputAbort(t *testing.T) {
+	upload := &memoryUpload{}
+	o := &remoteOutput{upload: upload, count: &countingWriter{w: upload}}
+	o.compressor, o.rowWriter, _ = newFileEncoder(&config{Delimiter: ","}, o.count, "gs://bucket/out.csv", "gs://bucket/out.csv")
+	o.Abort()
+	if upload.committed || !upload.aborted {
+		t.Fatalf("committed %v, aborted %v; want only aborted", upload.committed, upload.aborted)
+	}
+}
+
+func TestOpenObjectUploadInvalid(t *testing.T) {
+	for _, uri := range []string{"s3://bucket", "gs://bucket/", "az://container", "az:///file.csv"} {
+		if _, err := openUpload(&config{}, uri); err == nil {
+			t.Errorf("%s: got no error", uri)
+		}
+	}
+}
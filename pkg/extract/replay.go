@@ -0,0 +1,56 @@
+package extract
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+)
+
+// -record keeps the raw result of every job in a directory, and -replay
+// feeds the recordings back through the job's transforms and output in
+// place of the database, so that formats and transforms can be worked on
+// offline. Replayed runs, like limited ones, leave no state behind.
+
+var unsafeNameChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// recordingName names the recording of a job: its outfile's base name and a
+// hash of the full outfile, so outfiles in different directories differ.
+func recordingName(outFile string) string {
+	sum := sha256.Sum256([]byte(outFile))
+	base := unsafeNameChars.ReplaceAllString(filepath.Base(outFile), "_")
+	return fmt.Sprintf("%s-%s.replay", base, hex.EncodeToString(sum[:4]))
+}
+
+// recordResult records res as it is read, passing it through unchanged if
+// the recording cannot be started.
+func recordResult(c *config, query, outFile string, res resultSet) resultSet {
+	rec, err := newResultRecorder(c.keys, filepath.Join(c.recordDir, recordingName(outFile)), query, res)
+	if err != nil {
+		log.Printf("Warning: not recording the result of %s: %v\n", outFile, err)
+		return res
+	}
+	return rec
+}
+
+// openReplay returns the recorded result of a job.
+func openReplay(c *config, query, outFile string) (resultSet, error) {
+	path := filepath.Join(c.replayDir, recordingName(outFile))
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("no recording of %s in %s", outFile, c.replayDir)
+	}
+	res, err := readStoredResult(c.keys, path, fi.Size())
+	if err != nil {
+		return nil, fmt.Errorf("could not read recording %s: %v", path, err)
+	}
+	if normalizeSQL(res.query) != normalizeSQL(query) {
+		log.Printf("Warning: the query of %s changed since it was recorded\n", outFile)
+	}
+	log.Printf("Replaying %s, recorded at %s\n", outFile, fi.ModTime().Format(time.DateTime))
+	return res, nil
+}
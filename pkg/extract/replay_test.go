@@ -0,0 +1,45 @@
+package extract
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRecordAndReplay(t *testing.T) {
+	registerFake("recorded numbers", &fakeQuery{sets: []*fakeResult{numbersResult(4)}})
+	dir := t.TempDir()
+	outFile := filepath.Join(dir, "out.csv")
+
+	recorded, err := exportFake(t, &config{recordDir: filepath.Join(dir, "rec")}, "recorded numbers", outFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Remove(outFile)
+
+	// replaying needs no database, and the output format may change meanwhile
+	c := &config{Delimiter: ";", replayDir: filepath.Join(dir, "rec")}
+	c.dialect, _ = dialectFor("sqlserver")
+	if err := exportData(context.Background(), nil, c, nil, newRunReport(""), nil, "recorded numbers", outFile); err != nil {
+		t.Fatal(err)
+	}
+	replayed, _ := os.ReadFile(outFile)
+	if want := "id;name\n1;name 1\n2;name 2\n3;\n4;name 4\n"; string(replayed) != want {
+		t.Errorf("replayed %q, want %q (recorded %q)", replayed, want, recorded)
+	}
+	if fakeCalls("recorded numbers") != 1 {
+		t.Error("the replay queried the database")
+	}
+
+	if err := exportData(context.Background(), nil, c, nil, newRunReport(""), nil, "other", filepath.Join(dir, "other.csv")); err == nil {
+		t.Error("a job without a recording was replayed")
+	}
+}
+
+func TestRecordingName(t *testing.T) {
+	a, b := recordingName("/exports/a/out.csv"), recordingName("/exports/b/out.csv")
+	if a == b || filepath.Ext(a) != ".replay" || filepath.Base(a) != a {
+		t.Errorf("got %q and %q", a, b)
+	}
+}
@@ -0,0 +1,205 @@
+package extract
+
+import (
+	"bufio"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"time"
+)
+
+// A stored result is a query result saved to a file as it is read, for -cache
+// and -record. It is the raw result, before any transform, encrypted like
+// other state when state_encryption is set.
+
+// storedHeader starts a stored result; the rows follow, one gob value each.
+type storedHeader struct {
+	Query   string
+	Columns []storedColumn
+}
+
+// storedColumn is a column with its scan type by name.
+type storedColumn struct {
+	Name      string
+	DBType    string
+	ScanType  string
+	Nullable  bool
+	Precision int64
+	Scale     int64
+}
+
+// storedScanTypes restores the scan types drivers report.
+var storedScanTypes = map[string]reflect.Type{}
+
+func init() {
+	for _, v := range []any{false, int64(0), int32(0), int16(0), uint8(0), float64(0), float32(0), "", []byte(nil), time.Time{}} {
+		storedScanTypes[reflect.TypeOf(v).String()] = reflect.TypeOf(v)
+	}
+}
+
+// storedResult reads a stored result.
+type storedResult struct {
+	f       *os.File
+	dec     *gob.Decoder
+	query   string
+	cols    []column
+	current []any
+	err     error
+}
+
+func readStoredResult(keys *keyring, path string, size int64) (*storedResult, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	src, err := keys.openState(f, size, path)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	r := &storedResult{f: f, dec: gob.NewDecoder(bufio.NewReader(io.NewSectionReader(src, 0, src.Size())))}
+	var h storedHeader
+	if err := r.dec.Decode(&h); err != nil {
+		f.Close()
+		return nil, err
+	}
+	r.query = h.Query
+	for _, c := range h.Columns {
+		r.cols = append(r.cols, column{Name: c.Name, DBType: c.DBType, ScanType: storedScanTypes[c.ScanType], Nullable: c.Nullable, Precision: c.Precision, Scale: c.Scale})
+	}
+	return r, nil
+}
+
+func (r *storedResult) columns() ([]column, error) { return r.cols, nil }
+
+func (r *storedResult) Next() bool {
+	r.current = nil
+	if err := r.dec.Decode(&r.current); err != nil {
+		if !errors.Is(err, io.EOF) {
+			r.err = fmt.Errorf("stored result is damaged: %v", err)
+		}
+		return false
+	}
+	return true
+}
+
+func (r *storedResult) Scan(dest ...any) error {
+	if len(dest) != len(r.current) {
+		return fmt.Errorf("stored row has %d values, want %d", len(r.current), len(dest))
+	}
+	for i, d := range dest {
+		*d.(*any) = r.current[i]
+	}
+	return nil
+}
+
+func (r *storedResult) Err() error   { return r.err }
+func (r *storedResult) Close() error { return r.f.Close() }
+
+// resultRecorder passes a live result through while storing it at path,
+// which it only keeps if the result was read to the end.
+type resultRecorder struct {
+	resultSet
+	path   string
+	tmp    *os.File
+	sealed io.WriteCloser
+	buf    *bufio.Writer
+	enc    *gob.Encoder
+	row    []any
+	done   bool
+}
+
+func newResultRecorder(keys *keyring, path, query string, live resultSet) (*resultRecorder, error) {
+	cols, err := live.columns()
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return nil, err
+	}
+	rec := &resultRecorder{resultSet: live, path: path, tmp: tmp}
+	if rec.sealed, err = keys.seal(tmp); err != nil {
+		rec.abandon(nil)
+		return nil, err
+	}
+	rec.buf = bufio.NewWriter(rec.sealed)
+	rec.enc = gob.NewEncoder(rec.buf)
+	h := storedHeader{Query: query}
+	for _, col := range cols {
+		cc := storedColumn{Name: col.Name, DBType: col.DBType, Nullable: col.Nullable, Precision: col.Precision, Scale: col.Scale}
+		if col.ScanType != nil {
+			cc.ScanType = col.ScanType.String()
+		}
+		h.Columns = append(h.Columns, cc)
+	}
+	if err := rec.enc.Encode(h); err != nil {
+		rec.abandon(nil)
+		return nil, err
+	}
+	return rec, nil
+}
+
+func (r *resultRecorder) Next() bool {
+	ok := r.resultSet.Next()
+	r.done = !ok
+	return ok
+}
+
+func (r *resultRecorder) Scan(dest ...any) error {
+	if err := r.resultSet.Scan(dest...); err != nil {
+		return err
+	}
+	if r.enc == nil {
+		return nil
+	}
+	r.row = r.row[:0]
+	for _, d := range dest {
+		r.row = append(r.row, *d.(*any))
+	}
+	if err := r.enc.Encode(r.row); err != nil {
+		r.abandon(err)
+	}
+	return nil
+}
+
+// Close keeps the stored result if every row was read.
+func (r *resultRecorder) Close() error {
+	err := r.resultSet.Close()
+	if r.enc == nil {
+		return err
+	}
+	if !r.done || r.resultSet.Err() != nil {
+		r.abandon(nil)
+		return err
+	}
+	if ferr := r.buf.Flush(); ferr != nil {
+		r.abandon(ferr)
+	} else if ferr := r.sealed.Close(); ferr != nil {
+		r.abandon(ferr)
+	} else if ferr := r.tmp.Close(); ferr != nil {
+		r.abandon(ferr)
+	} else if ferr := os.Rename(r.tmp.Name(), r.path); ferr != nil {
+		r.abandon(ferr)
+	}
+	r.enc = nil
+	return err
+}
+
+// abandon drops the partly written file, warning about err if set.
+func (r *resultRecorder) abandon(err error) {
+	if err != nil {
+		log.Printf("Warning: not storing the result: %v\n", err)
+	}
+	r.tmp.Close()
+	os.Remove(r.tmp.Name())
+	r.enc = nil
+}
@@ -0,0 +1,133 @@
+package extract
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// defaultRetryBackoff is the wait before the first retry unless retry_backoff
+// is set.
+const defaultRetryBackoff = 30 * time.Second
+
+// transientErrors are the driver messages, in lower case, of failures that
+// are worth another attempt: deadlocks, timeouts and dropped connections.
+var transientErrors = []string{
+	"deadlock",
+	"timeout",
+	"timed out",
+	"bad connection",
+	"connection reset",
+	"connection refused",
+	"broken pipe",
+	"unexpected eof",
+	"server closed the connection",
+	"transport-level error",
+}
+
+// isTransient reports whether err is a failure worth retrying.
+func isTransient(err error) bool {
+	msg := strings.ToLower(err.Error())
+	for _, s := range transientErrors {
+		if strings.Contains(msg, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func validateRetries(c *config) error {
+	if c.Retries < 0 || c.RetryBackoff < 0 {
+		return fmt.Errorf("retries and retry_backoff cannot be negative\n")
+	}
+	for _, e := range c.Extracts {
+		if (e.Retries != nil && *e.Retries < 0) || e.RetryBackoff < 0 {
+			return fmt.Errorf("Extract %s retries and retry_backoff cannot be negative\n", e.Name)
+		}
+	}
+	return nil
+}
+
+// retries returns how many times the job writing outFile is retried after a
+// transient error, and the wait before the first retry.
+func (c *config) retries(outFile string) (int, time.Duration) {
+	n, backoff := c.Retries, time.Duration(c.RetryBackoff)
+	if e, ok := c.extract(outFile); ok {
+		if e.Retries != nil {
+			n = *e.Retries
+		}
+		if e.RetryBackoff > 0 {
+			backoff = time.Duration(e.RetryBackoff)
+		}
+	}
+	if backoff == 0 {
+		backoff = defaultRetryBackoff
+	}
+	return n, backoff
+}
+
+// replayable reports whether the destination of outFile keeps nothing of a
+// failed job, so the job can run again from the start: files are renamed
+// into place and objects appear once uploaded, and table loads roll back
+// unless commit_every commits them as they go. Pipes and loaders take the
+// rows as they are written.
+func (c *config) replayable(outFile string) bool {
+	switch {
+	case strings.HasPrefix(outFile, "loader://"):
+		return false
+	case strings.HasPrefix(outFile, "mssql://"):
+		return c.MSSQL.CommitEvery == 0
+	case strings.HasPrefix(outFile, "postgres://"):
+		return c.Postgres.CommitEvery == 0
+	}
+	return !isPipe(c.outputPath(outFile))
+}
+
+// deliveredError is the failure of a job whose destination already took
+// some of its rows, because it is not replayable or because the output was
+// closed before the failure, so another attempt would deliver them again.
+type deliveredError struct{ err error }
+
+func (e *deliveredError) Error() string { return e.err.Error() }
+func (e *deliveredError) Unwrap() error { return e.err }
+
+// withRetries runs the job writing outFile, which recreates its output on
+// each attempt, and runs it again after a transient error, doubling the wait
+// each time. A wait ending in a maintenance window lasts until it is over. A
+// job whose rows already reached a destination that is not replayable is
+// not run again, nor is one that failed after its output was delivered.
+func (c *config) withRetries(ctx context.Context, control *controller, outFile string, run func() error) error {
+	n, backoff := c.retries(outFile)
+	for attempt := 1; ; attempt++ {
+		err := run()
+		if err == nil || attempt > n || ctx.Err() != nil || !isTransient(err) {
+			return err
+		}
+		var delivered *deliveredError
+		if errors.As(err, &delivered) {
+			log.Printf("Warning: %s is not retried, its destination already has rows from this attempt\n", outFile)
+			return err
+		}
+		at := time.Now().Add(backoff)
+		if until, ok := c.maintenanceUntil(at); ok {
+			at = until
+		}
+		c.logJob(outFile, fmt.Sprintf("Retrying %s at %s, attempt %d of %d failed: %v\n", outFile, at.Format(time.DateTime), attempt, n+1, strings.TrimSpace(err.Error())),
+			slog.Int("attempt", attempt), slog.Int("attempts", n+1), slog.Time("retry_at", at), slog.String("error", strings.TrimSpace(err.Error())))
+		timer := time.NewTimer(time.Until(at))
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-control.drained:
+			timer.Stop()
+			return err
+		}
+		backoff *= 2
+	}
+}
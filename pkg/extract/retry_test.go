@@ -0,0 +1,81 @@
+package extract
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestWithRetries(t *testing.T) {
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	none := 0
+	c := &config{
+		Retries:      2,
+		RetryBackoff: configDuration(time.Millisecond),
+		Extracts:     []extract{{Name: "once", OutFile: "once.csv", Retries: &none}},
+	}
+	control := newController([]string{"a.csv", "once.csv"})
+	deadlock := errors.New("Unable to execute the provided query 'q': mssql: Transaction (Process ID 61) was deadlocked on lock resources with another process and has been chosen as the deadlock victim. Rerun the transaction.\n")
+
+	for _, tc := range []struct {
+		outFile  string
+		failures []error
+		attempts int
+		ok       bool
+	}{
+		{"a.csv", []error{deadlock, errors.New("read tcp 10.0.0.5:50112: i/o timeout")}, 3, true},
+		{"a.csv", []error{deadlock, deadlock, deadlock}, 3, false},
+		{"a.csv", []error{errors.New("Invalid object name 'dbo.Nope'.\n")}, 1, false},
+		{"once.csv", []error{deadlock}, 1, false},
+		{"a.csv", []error{&deliveredError{deadlock}}, 1, false},
+	} {
+		attempts := 0
+		err := c.withRetries(context.Background(), control, tc.outFile, func() error {
+			attempts++
+			if attempts <= len(tc.failures) {
+				return tc.failures[attempts-1]
+			}
+			return nil
+		})
+		if attempts != tc.attempts || (err == nil) != tc.ok {
+			t.Errorf("%s after %v: %d attempts, %v", tc.outFile, tc.failures, attempts, err)
+		}
+	}
+
+	// the wait before a retry ends early once the job is cancelled
+	c.RetryBackoff = configDuration(time.Hour)
+	ctx, cancel := context.WithCancel(context.Background())
+	attempts := 0
+	err := c.withRetries(ctx, control, "a.csv", func() error {
+		attempts++
+		cancel()
+		return deadlock
+	})
+	if err == nil || attempts != 1 {
+		t.Errorf("cancelled: %d attempts, %v", attempts, err)
+	}
+	if n, backoff := (&config{}).retries("a.csv"); n != 0 || backoff != defaultRetryBackoff {
+		t.Errorf("defaults: %d retries after %v", n, backoff)
+	}
+}
+
+func TestReplayable(t *testing.T) {
+	c := &config{}
+	c.Postgres.CommitEvery = 1000
+	for outFile, want := range map[string]bool{
+		"a.csv":                    true,
+		"s3://bucket/a.csv":        true,
+		"mssql://dbo.Orders":       true,
+		"postgres://public.orders": false,
+		"loader://warehouse/x":     false,
+	} {
+		if got := c.replayable(outFile); got != want {
+			t.Errorf("%s replayable %v, want %v", outFile, got, want)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package extract
+
+import (
+	"fmt"
+	"strings"
+)
+
+// rowKeyOptions prepends a surrogate key column to the output.
+type rowKeyOptions struct {
+	// Name of the key column, row_id by default.
+	Name string `yaml:"name"`
+	// Type is number (a sequence starting at Start, 1 by default) or uuid.
+	Type  string `yaml:"type"`
+	Start *int64 `yaml:"start"`
+}
+
+// validate checks the key type.
+func (o rowKeyOptions) validate() error {
+	switch o.Type {
+	case "", "number", "uuid":
+		return nil
+	}
+	return fmt.Errorf("Unsupported row key type '%s'\n", o.Type)
+}
+
+// rowKeyTransform numbers the rows that reach the output.
+type rowKeyTransform struct {
+	opts rowKeyOptions
+	next int64
+}
+
+func newRowKeyTransform(o rowKeyOptions) *rowKeyTransform {
+	if o.Name == "" {
+		o.Name = "row_id"
+	}
+	t := &rowKeyTransform{opts: o, next: 1}
+	if o.Start != nil {
+		t.next = *o.Start
+	}
+	return t
+}
+
+func (t *rowKeyTransform) Setup(cols []column) ([]column, error) {
+	for _, c := range cols {
+		if strings.EqualFold(c.Name, t.opts.Name) {
+			return nil, fmt.Errorf("Row key column %s duplicates a column of the query result\n", t.opts.Name)
+		}
+	}
+	key := column{Name: t.opts.Name, DBType: "BIGINT"}
+	if t.opts.Type == "uuid" {
+		key.DBType = "NVARCHAR"
+	}
+	return append([]column{key}, cols...), nil
+}
+
+func (t *rowKeyTransform) Apply(row []any) ([]any, bool, error) {
+	out := make([]any, 0, len(row)+1)
+	if t.opts.Type == "uuid" {
+		out = append(out, newUUID())
+	} else {
+		out = append(out, t.next)
+		t.next++
+	}
+	return append(out, row...), true, nil
+}
@@ -0,0 +1,57 @@
+package extract
+
+import (
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestExportDataRowKey(t *testing.T) {
+	registerFake("row_keys", &fakeQuery{sets: []*fakeResult{numbersResult(6)}})
+	outFile := filepath.Join(t.TempDir(), "out.csv")
+	start := int64(100)
+	c := &config{
+		// filtered rows are not numbered, so the keys have no gaps
+		Filters: map[string]string{outFile: "name IS NOT NULL"},
+		RowKeys: map[string]rowKeyOptions{outFile: {Name: "seq", Start: &start}},
+	}
+	got, err := exportFake(t, c, "row_keys", outFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "seq,id,name\n100,1,name 1\n101,2,name 2\n102,4,name 4\n103,5,name 5\n"; got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+
+	c = &config{RowKeys: map[string]rowKeyOptions{outFile: {Type: "uuid"}}}
+	got, err = exportFake(t, c, "row_keys", outFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(got), "\n")
+	if lines[0] != "row_id,id,name" || len(lines) != 7 {
+		t.Fatalf("got %q", got)
+	}
+	uuid := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	seen := map[string]bool{}
+	for _, line := range lines[1:] {
+		key, _, _ := strings.Cut(line, ",")
+		if !uuid.MatchString(key) || seen[key] {
+			t.Errorf("key %q is not a new version 4 UUID", key)
+		}
+		seen[key] = true
+	}
+}
+
+func TestRowKeyErrors(t *testing.T) {
+	if err := (rowKeyOptions{Type: "serial"}).validate(); err == nil {
+		t.Error("an unknown key type is valid")
+	}
+	registerFake("row_key_errors", &fakeQuery{sets: []*fakeResult{numbersResult(1)}})
+	outFile := filepath.Join(t.TempDir(), "out.csv")
+	c := &config{RowKeys: map[string]rowKeyOptions{outFile: {Name: "ID"}}}
+	if _, err := exportFake(t, c, "row_key_errors", outFile); err == nil || !strings.Contains(err.Error(), "duplicates a column") {
+		t.Errorf("a key named like a result column: %v", err)
+	}
+}
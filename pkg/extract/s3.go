@@ -0,0 +1,111 @@
+package extract
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// splitS3URI splits s3://bucket/key into its bucket and key.
+func splitS3URI(uri string) (string, string, error) {
+	rest, ok := strings.CutPrefix(uri, "s3://")
+	if !ok || rest == "" {
+		return "", "", fmt.Errorf("Invalid S3 location '%s'\n", uri)
+	}
+	bucket, key, _ := strings.Cut(rest, "/")
+	return bucket, key, nil
+}
+
+// newS3Client loads the standard AWS credential chain, optionally pinning the region.
+func newS3Client(ctx context.Context, region string) (*s3.Client, error) {
+	var opts []func(*awsconfig.LoadOptions) error
+	if region != "" {
+		opts = append(opts, awsconfig.WithRegion(region))
+	}
+	cfg, err := awsconfig.LoadDefaultConfig(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("Could not load AWS configuration: %v\n", err)
+	}
+	return s3.NewFromConfig(cfg), nil
+}
+
+// discardS3Object deletes or tags an object whose load failed.
+func discardS3Object(ctx context.Context, client *s3.Client, bucket, key, policy string) {
+	var err error
+	switch policy {
+	case abortKeep:
+		return
+	case abortMark:
+		_, err = client.PutObjectTagging(ctx, &s3.PutObjectTaggingInput{
+			Bucket:  aws.String(bucket),
+			Key:     aws.String(key),
+			Tagging: &types.Tagging{TagSet: []types.Tag{{Key: aws.String("incomplete"), Value: aws.String("true")}}},
+		})
+	default:
+		_, err = client.DeleteObject(ctx, &s3.DeleteObjectInput{Bucket: aws.String(bucket), Key: aws.String(key)})
+	}
+	if err != nil {
+		log.Printf("Warning: could not clean up staged file s3://%s/%s: %v\n", bucket, key, err)
+	}
+}
+
+// s3Upload streams everything written to it into one S3 object using a
+// multipart upload. A failed or aborted upload is aborted on the S3 side, so
+// no partial object becomes visible.
+type s3Upload struct {
+	bucket string
+	key    string
+	pw     *io.PipeWriter
+	result chan error
+	once   sync.Once
+	err    error
+}
+
+func newS3Upload(ctx context.Context, client *s3.Client, bucket, key string) *s3Upload {
+	pr, pw := io.Pipe()
+	u := &s3Upload{bucket: bucket, key: key, pw: pw, result: make(chan error, 1)}
+	go func() {
+		_, err := manager.NewUploader(client).Upload(ctx, &s3.PutObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(key),
+			Body:   pr,
+		})
+		pr.CloseWithError(err)
+		u.result <- err
+	}()
+	return u
+}
+
+func (u *s3Upload) Write(p []byte) (int, error) {
+	return u.pw.Write(p)
+}
+
+// Close ends the stream and waits for the upload to complete. It is safe to
+// call more than once.
+func (u *s3Upload) Close() error {
+	u.once.Do(func() {
+		u.pw.Close()
+		if err := <-u.result; err != nil {
+			u.err = fmt.Errorf("Upload to s3://%s/%s failed: %v\n", u.bucket, u.key, err)
+		}
+	})
+	return u.err
+}
+
+// Abort fails the stream so the uploader aborts the multipart upload.
+func (u *s3Upload) Abort() {
+	u.once.Do(func() {
+		u.pw.CloseWithError(fmt.Errorf("upload aborted"))
+		<-u.result
+		u.err = fmt.Errorf("Upload to s3://%s/%s was aborted\n", u.bucket, u.key)
+	})
+}
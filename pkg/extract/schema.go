@@ -0,0 +1,139 @@
+package extract
+
+//go:generate go run ../.. schema -o ../../config.schema.json
+
+import (
+	"encoding/json"
+	"flag"
+	"os"
+	"reflect"
+	"strings"
+)
+
+// schemaID identifies the configuration schema, which is generated from the
+// config struct into config.schema.json.
+const schemaID = "https://github.com/nnyquist/sql-export-wiz/config.schema.json"
+
+// schemaType is implemented by the settings whose YAML form differs from
+// what their Go type suggests.
+type schemaType interface {
+	jsonSchema() map[string]any
+}
+
+// schemaCommand prints the JSON Schema of the configuration or writes it
+// to -o.
+func schemaCommand(fs *flag.FlagSet) func([]string) error {
+	out := fs.String("o", "", "Write the schema to this file instead of printing it.")
+	return func([]string) error {
+		data, err := configSchemaJSON()
+		if err != nil {
+			return err
+		}
+		if *out == "" {
+			_, err = os.Stdout.Write(data)
+			return err
+		}
+		return os.WriteFile(*out, data, 0o644)
+	}
+}
+
+// configSchemaJSON returns the JSON Schema of a configuration document.
+func configSchemaJSON() ([]byte, error) {
+	root := schemaFor(reflect.TypeOf(config{}))
+	// a document may also hold a single job, as in a directory of them
+	props := root["properties"].(map[string]any)
+	for k, v := range schemaFor(reflect.TypeOf(jobDoc{}))["properties"].(map[string]any) {
+		props[k] = v
+	}
+	items := props["extracts"].(map[string]any)["items"].(map[string]any)
+	items["required"] = []string{"name", "outfile"}
+	// a job reads its query from query or query_file, except a concat job
+	items["anyOf"] = []any{
+		map[string]any{"required": []string{"query"}},
+		map[string]any{"required": []string{"query_file"}},
+		map[string]any{"required": []string{"type"}, "properties": map[string]any{"type": map[string]any{"const": jobTypeConcat}}},
+	}
+	root["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	root["$id"] = schemaID
+	root["title"] = "sql-export-wiz configuration"
+	data, err := json.MarshalIndent(root, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+var schemaTypeType = reflect.TypeOf((*schemaType)(nil)).Elem()
+
+// schemaFor returns the JSON Schema of values of type t as the YAML decoder
+// reads them.
+func schemaFor(t reflect.Type) map[string]any {
+	if reflect.PointerTo(t).Implements(schemaTypeType) {
+		return reflect.New(t).Interface().(schemaType).jsonSchema()
+	}
+	switch t.Kind() {
+	case reflect.Pointer:
+		return schemaFor(t.Elem())
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": schemaFor(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": schemaFor(t.Elem())}
+	case reflect.Struct:
+		props := map[string]any{}
+		addStructProperties(props, t)
+		return map[string]any{"type": "object", "properties": props, "additionalProperties": false}
+	default:
+		// interfaces take any value
+		return map[string]any{}
+	}
+}
+
+// addStructProperties adds the properties of the YAML fields of t, with those
+// of inlined structs, to props.
+func addStructProperties(props map[string]any, t reflect.Type) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		key, opts, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+		switch {
+		case opts == "inline":
+			addStructProperties(props, f.Type)
+		case key != "" && key != "-" && f.IsExported():
+			props[key] = schemaFor(f.Type)
+		}
+	}
+}
+
+func (configDuration) jsonSchema() map[string]any {
+	return map[string]any{
+		"type":        "string",
+		"pattern":     `^(0|([0-9]*(\.[0-9]*)?(ns|us|µs|ms|s|m|h))+)$`,
+		"description": "A duration with a unit, such as 30s, 5m or 1h30m.",
+	}
+}
+
+func (byteSize) jsonSchema() map[string]any {
+	return map[string]any{
+		"oneOf": []any{
+			map[string]any{"type": "integer", "minimum": 0},
+			map[string]any{"type": "string", "pattern": `^[0-9]+(\.[0-9]*)?( *([KkMmGgTt][Ii]?)?[Bb])?$`},
+		},
+		"description": "A size in bytes, or with a unit such as 64MiB or 1.5GB.",
+	}
+}
+
+func (literalColumns) jsonSchema() map[string]any {
+	return map[string]any{
+		"type":                 "object",
+		"additionalProperties": map[string]any{"type": []any{"string", "number", "boolean", "null"}},
+		"description":          "Column names mapped to the value stamped on every row.",
+	}
+}
@@ -0,0 +1,61 @@
+package extract
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"testing"
+)
+
+func TestSchemaUpToDate(t *testing.T) {
+	want, err := configSchemaJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile("../../config.schema.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Error("config.schema.json is out of date, run go generate")
+	}
+}
+
+func TestSchemaProperties(t *testing.T) {
+	data, err := configSchemaJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var schema map[string]any
+	if err := json.Unmarshal(data, &schema); err != nil {
+		t.Fatal(err)
+	}
+	// property walks the nested properties of the schema
+	property := func(path ...string) map[string]any {
+		s := schema
+		for _, p := range path {
+			next, _ := s["properties"].(map[string]any)[p].(map[string]any)
+			if next == nil {
+				t.Fatalf("no property %v", path)
+			}
+			s = next
+		}
+		return s
+	}
+	if property("orc", "stripe_size")["oneOf"] == nil {
+		t.Error("stripe_size does not accept sizes with units")
+	}
+	if property("mssql", "batch_size")["type"] != "integer" {
+		t.Error("inlined table options are missing")
+	}
+	if property("query")["type"] != "string" {
+		t.Error("the single job shorthand is missing")
+	}
+	items := property("extracts")["items"].(map[string]any)
+	if items["required"] == nil || items["properties"].(map[string]any)["timeout"].(map[string]any)["pattern"] == nil {
+		t.Errorf("extracts: got %v", items)
+	}
+	if property("literals")["additionalProperties"].(map[string]any)["description"] == nil {
+		t.Error("literal columns are not described as a mapping")
+	}
+}
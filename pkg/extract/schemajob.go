@@ -0,0 +1,227 @@
+package extract
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Job types of the extracts list. An extract job, the default, moves the
+// result; a schema job only describes it, and a concat job joins files.
+const (
+	jobTypeExtract = ""
+	jobTypeSchema  = "schema"
+	jobTypeConcat  = "concat"
+)
+
+// jobType returns the type of the job writing outFile.
+func (c *config) jobType(outFile string) string {
+	e, _ := c.extract(outFile)
+	return e.Type
+}
+
+func validateJobTypes(c *config) error {
+	for _, e := range c.Extracts {
+		switch e.Type {
+		case jobTypeExtract:
+		case jobTypeSchema:
+			if strings.Contains(e.OutFile, "://") {
+				return fmt.Errorf("Schema job %s must write a local file\n", e.Name)
+			}
+			if e.Incremental.enabled() || len(e.Capture) > 0 {
+				return fmt.Errorf("Schema job %s reads no rows, so it cannot be incremental or capture values\n", e.Name)
+			}
+		case jobTypeConcat:
+			if err := validateConcat(c, e); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("Extract %s has unknown type '%s', use schema, concat or leave it out\n", e.Name, e.Type)
+		}
+	}
+	return nil
+}
+
+// schemaDocument is what a schema job writes: the columns of the result, in
+// the layout of a contract so it can serve as one, and the optimizer's
+// estimate of its rows where the source gives one.
+type schemaDocument struct {
+	Job           string            `json:"job" yaml:"job"`
+	Captured      time.Time         `json:"captured" yaml:"captured"`
+	EstimatedRows *int64            `json:"estimated_rows,omitempty" yaml:"estimated_rows,omitempty"`
+	Columns       []schemaDocColumn `json:"columns" yaml:"columns"`
+}
+
+type schemaDocColumn struct {
+	Name     string `json:"name" yaml:"name"`
+	Type     string `json:"type" yaml:"type"`
+	Nullable bool   `json:"nullable" yaml:"nullable"`
+}
+
+// exportSchema runs the schema job writing outFile: query runs as a zero-row
+// probe, its columns are checked like an extract's and written with the
+// estimated row count. No rows are read.
+func exportSchema(ctx context.Context, db *sql.DB, c *config, l *ledger, r *runReport, k *contract, query, outFile string) error {
+	started := time.Now()
+	rows, err := db.QueryContext(ctx, c.dialect.probe(query), c.jobArgs(outFile)...)
+	if err != nil {
+		return fmt.Errorf("Unable to execute the provided query '%s': %v\n", query, err)
+	}
+	types, err := rows.ColumnTypes()
+	rows.Close()
+	if err != nil {
+		return fmt.Errorf("Columns could not be collected from the query result: %v\n", err)
+	}
+	stages, cols, err := prepareColumns(c, l, k, outFile, newColumns(types))
+	if err != nil {
+		return err
+	}
+	discardStages(stages)
+	r.schema(outFile, cols)
+
+	doc := schemaDocument{Job: c.jobName(outFile), Captured: started.UTC()}
+	for i, col := range outputSchema(cols) {
+		doc.Columns = append(doc.Columns, schemaDocColumn{Name: col.Name, Type: col.Type, Nullable: cols[i].Nullable})
+	}
+	// an estimate is a nicety, so a source that cannot give one does not fail the job
+	if e, ok := c.dialect.(rowEstimator); ok {
+		if n, err := e.estimateRows(ctx, db, query, c.jobArgs(outFile)); err != nil {
+			log.Printf("Warning: could not estimate the rows of %s: %v\n", outFile, err)
+		} else {
+			doc.EstimatedRows = &n
+		}
+	}
+	path := c.outputPath(outFile)
+	if err := writeSchemaDocument(path, doc); err != nil {
+		return err
+	}
+
+	attrs := []slog.Attr{slog.String("state", jobDone), slog.Any("columns", columnNames(cols)), slog.Float64("duration_seconds", time.Since(started).Seconds())}
+	msg := fmt.Sprintf("Schema of %s written to %s (%d column(s))\n", outFile, path, len(cols))
+	if doc.EstimatedRows != nil {
+		attrs = append(attrs, slog.Int64("estimated_rows", *doc.EstimatedRows))
+		msg = fmt.Sprintf("Schema of %s written to %s (%d column(s), about %s row(s))\n", outFile, path, len(cols), console.count(*doc.EstimatedRows))
+	}
+	c.logJob(outFile, msg, attrs...)
+
+	// the ledger keeps the schema to tell drift on the next run
+	if !c.partial() {
+		return l.record(outFile, cols, 0)
+	}
+	return nil
+}
+
+// writeSchemaDocument writes doc as YAML for .yaml and .yml paths and as
+// JSON otherwise, through a temporary file.
+func writeSchemaDocument(path string, doc schemaDocument) error {
+	var data []byte
+	var err error
+	if catalogIsYAML(path) {
+		data, err = yaml.Marshal(doc)
+	} else {
+		data, err = json.MarshalIndent(doc, "", "  ")
+	}
+	if err != nil {
+		return err
+	}
+	if dir := filepath.Dir(path); dir != "" {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return fmt.Errorf("Could not create directory %s: %v\n", dir, err)
+		}
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("Could not write schema %s: %v\n", path, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("Could not write schema %s: %v\n", path, err)
+	}
+	return nil
+}
+
+// rowEstimator is a dialect that estimates the rows of a query from the
+// optimizer's plan, without running it.
+type rowEstimator interface {
+	estimateRows(ctx context.Context, db *sql.DB, query string, args []any) (int64, error)
+}
+
+// estimateRows reads StatementEstRows from the XML plan of query. SHOWPLAN_XML
+// is a session setting, so the plan is asked for on one connection.
+func (sqlServerDialect) estimateRows(ctx context.Context, db *sql.DB, query string, args []any) (int64, error) {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	if _, err := conn.ExecContext(ctx, "SET SHOWPLAN_XML ON"); err != nil {
+		return 0, err
+	}
+	defer conn.ExecContext(context.Background(), "SET SHOWPLAN_XML OFF")
+	var plan string
+	if err := conn.QueryRowContext(ctx, query, args...).Scan(&plan); err != nil {
+		return 0, err
+	}
+	return showplanRows(plan)
+}
+
+// showplanRows returns the estimated rows of the last statement of a
+// SHOWPLAN_XML plan, the one returning the result.
+func showplanRows(plan string) (int64, error) {
+	dec := xml.NewDecoder(strings.NewReader(plan))
+	var rows float64
+	found := false
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			break
+		}
+		if el, ok := tok.(xml.StartElement); ok && el.Name.Local == "StmtSimple" {
+			for _, a := range el.Attr {
+				if a.Name.Local == "StatementEstRows" {
+					if _, err := fmt.Sscan(a.Value, &rows); err == nil {
+						found = true
+					}
+				}
+			}
+		}
+	}
+	if !found {
+		return 0, fmt.Errorf("the plan has no row estimate")
+	}
+	return int64(rows + 0.5), nil
+}
+
+// estimateRows reads the Plan Rows of the top node of the JSON plan of query.
+func (postgresDialect) estimateRows(ctx context.Context, db *sql.DB, query string, args []any) (int64, error) {
+	var plan string
+	if err := db.QueryRowContext(ctx, "EXPLAIN (FORMAT JSON) "+subquery(query), args...).Scan(&plan); err != nil {
+		return 0, err
+	}
+	return explainRows(plan)
+}
+
+// explainRows returns the Plan Rows of the top node of a Postgres JSON plan.
+func explainRows(plan string) (int64, error) {
+	var plans []struct {
+		Plan struct {
+			Rows float64 `json:"Plan Rows"`
+		} `json:"Plan"`
+	}
+	if err := json.Unmarshal([]byte(plan), &plans); err != nil {
+		return 0, fmt.Errorf("could not parse the plan: %v", err)
+	}
+	if len(plans) == 0 {
+		return 0, fmt.Errorf("the plan is empty")
+	}
+	return int64(plans[0].Plan.Rows + 0.5), nil
+}
@@ -0,0 +1,66 @@
+package extract
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExportSchema(t *testing.T) {
+	registerFake("SELECT TOP (0) * FROM (feed) AS src", &fakeQuery{sets: []*fakeResult{numbersResult(0)}})
+	dir := t.TempDir()
+	outFile := filepath.Join(dir, "feed.schema.yaml")
+	c := &config{Extracts: []extract{{Name: "feed", Type: jobTypeSchema, OutFile: outFile}}}
+	if err := validateJobTypes(c); err != nil {
+		t.Fatal(err)
+	}
+	// the fake driver cannot show plans, which leaves out the estimate
+	if _, err := exportFake(t, c, "feed", outFile); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(outFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "job: feed") || strings.Contains(string(data), "estimated_rows") {
+		t.Errorf("schema document:\n%s", data)
+	}
+	// the document serves as a contract
+	k, err := loadContracts(map[string]string{"feed": outFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := k["feed"].violations(newColumns(nil)); len(v) == 0 {
+		t.Error("an empty result matches the contract")
+	}
+	if len(k["feed"].Columns) != 2 || k["feed"].Columns[0].Name != "id" || k["feed"].Columns[0].Type != "BIGINT" || !*k["feed"].Columns[1].Nullable {
+		t.Errorf("contract columns %+v", k["feed"].Columns)
+	}
+}
+
+func TestValidateJobTypes(t *testing.T) {
+	c := &config{Extracts: []extract{{Name: "feed", Type: "snapshot", OutFile: "feed.json"}}}
+	if err := validateJobTypes(c); err == nil {
+		t.Error("an unknown job type is valid")
+	}
+	c.Extracts[0] = extract{Name: "feed", Type: jobTypeSchema, OutFile: "s3://bucket/feed.json"}
+	if err := validateJobTypes(c); err == nil {
+		t.Error("a schema job writing to S3 is valid")
+	}
+}
+
+func TestPlanRows(t *testing.T) {
+	plan := `<ShowPlanXML xmlns="http://schemas.microsoft.com/sqlserver/2004/07/showplan"><BatchSequence><Batch><Statements>` +
+		`<StmtSimple StatementText="SELECT * FROM dbo.Orders" StatementEstRows="12345.6" StatementType="SELECT"/>` +
+		`</Statements></Batch></BatchSequence></ShowPlanXML>`
+	if n, err := showplanRows(plan); n != 12346 || err != nil {
+		t.Errorf("showplan rows %d, %v", n, err)
+	}
+	if _, err := showplanRows("<ShowPlanXML/>"); err == nil {
+		t.Error("a plan without statements has an estimate")
+	}
+	if n, err := explainRows(`[{"Plan": {"Node Type": "Seq Scan", "Plan Rows": 5400}}]`); n != 5400 || err != nil {
+		t.Errorf("explain rows %d, %v", n, err)
+	}
+}
@@ -0,0 +1,130 @@
+package extract
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// server repeats the extraction for -serve. Each run takes the configuration
+// current when it starts, so a reload never changes a run in progress.
+type server struct {
+	path string
+	load func() (*config, error)
+	// loadFlow loads the flows that follow a run through on_success.
+	loadFlow func(flow string) (*config, error)
+	control  *controller
+	health   *health
+
+	mu      sync.Mutex
+	current *config
+	modTime time.Time
+}
+
+func newServer(path string, load func() (*config, error), c *config, control *controller) *server {
+	s := &server{path: path, load: load, control: control, current: c, modTime: configModTime(path, c)}
+	control.reload = s.reload
+	return s
+}
+
+// modTime returns the modification time of path, or the zero time if it
+// cannot be read.
+func modTime(path string) time.Time {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return fi.ModTime()
+}
+
+// reload loads and validates the configuration file and, only if it is valid,
+// swaps it in for the next run.
+func (s *server) reload() error {
+	s.mu.Lock()
+	mod := configModTime(s.path, s.current)
+	s.mu.Unlock()
+	c, err := s.load()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// an invalid file is reported once, not before every run
+	s.modTime = mod
+	if err != nil {
+		return err
+	}
+	s.current, s.modTime = c, configModTime(s.path, c)
+	log.Printf("Reloaded the configuration from %s with %d job(s)\n", s.path, len(c.Queries))
+	return nil
+}
+
+// config returns the configuration for the next run, reloading the file
+// first if it changed since it was last read.
+func (s *server) config() *config {
+	s.mu.Lock()
+	changed := !configModTime(s.path, s.current).Equal(s.modTime)
+	s.mu.Unlock()
+	if changed {
+		if err := s.reload(); err != nil {
+			log.Printf("Warning: keeping the previous configuration, %s is invalid: %v\n", s.path, err)
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.current
+}
+
+// reloadOnHangup reloads the configuration on SIGHUP until the returned
+// function is called.
+func (s *server) reloadOnHangup() func() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			if err := s.reload(); err != nil {
+				log.Printf("Warning: keeping the previous configuration, %s is invalid: %v\n", s.path, err)
+			}
+		}
+	}()
+	return func() { signal.Stop(hup) }
+}
+
+// serve starts a run every interval until the admin drain command. A run
+// that takes longer than the interval is followed straight away by the next.
+func (s *server) serve(ctx context.Context, every time.Duration) {
+	defer s.reloadOnHangup()()
+
+	for s.control.hold() {
+		if !s.config().awaitMaintenance(s.control, "the run") {
+			break
+		}
+		now := time.Now()
+		next := now.Add(every)
+		c, err := s.config().scheduled(ctx, now)
+		switch {
+		case err != nil:
+			log.Printf("Warning: skipping the run, %v\n", strings.TrimSpace(err.Error()))
+		case len(c.OutFiles) == 0:
+			log.Printf("No job runs on %s\n", now.Format(time.DateOnly))
+		default:
+			s.control.begin(c.OutFiles)
+			if s.health != nil {
+				s.health.runStarted(c.OutFiles)
+			}
+			if err := runChain(ctx, c, s.control, s.loadFlow, runExtraction); err != nil {
+				log.Printf("Warning: run failed: %v\n", err)
+			}
+			if s.health != nil {
+				s.health.runFinished(s.control.states(), next)
+			}
+		}
+		log.Printf("Next run at %s\n", next.Format(time.DateTime))
+		if !s.control.sleepUntil(next) {
+			break
+		}
+	}
+	log.Println("Drained, stopping the server")
+}
@@ -0,0 +1,44 @@
+package extract
+
+import (
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestServerReload(t *testing.T) {
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	write := func(data string, mod time.Time) {
+		if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		os.Chtimes(path, mod, mod)
+	}
+	load := func() (*config, error) { return loadConfig(path) }
+
+	now := time.Now()
+	write("queries: [select 1]\noutfiles: [a.csv]\n", now.Add(-time.Hour))
+	first, err := load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newServer(path, load, first, newController(first.OutFiles))
+
+	write("schema_drift: sometimes\n", now.Add(-time.Minute))
+	if c := s.config(); c != first {
+		t.Fatal("an invalid configuration replaced the running one")
+	}
+
+	write("queries: [select 1, select 2]\noutfiles: [a.csv, b.csv]\n", now)
+	if c := s.config(); len(c.OutFiles) != 2 {
+		t.Fatalf("the changed configuration was not loaded: %v", c.OutFiles)
+	}
+	if reply := s.control.command("reload"); reply[:3] != "ok:" {
+		t.Errorf("reload = %q", reply)
+	}
+}
@@ -0,0 +1,241 @@
+package extract
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// sftpOptions holds the login to one SFTP server, keyed by its host name in
+// the sftp setting.
+type sftpOptions struct {
+	// KeyFile is the private key to log in with.
+	KeyFile string `yaml:"key_file"`
+	// PassphraseEnv names the environment variable holding the passphrase of
+	// the key, if it has one.
+	PassphraseEnv string `yaml:"passphrase_env"`
+	// PasswordEnv names the environment variable holding the password.
+	PasswordEnv string `yaml:"password_env"`
+	// KnownHosts is the known_hosts file the server's key is checked
+	// against, ~/.ssh/known_hosts by default.
+	KnownHosts string `yaml:"known_hosts"`
+	// TempSuffix is added to the file's name while it is uploaded, .part by
+	// default.
+	TempSuffix string `yaml:"temp_suffix"`
+}
+
+// sftpDialTimeout bounds connecting and logging in to a server.
+const sftpDialTimeout = 30 * time.Second
+
+// parseSFTPURI splits sftp://user@host[:port]/path into its parts. The path
+// is absolute on the server.
+func parseSFTPURI(uri string) (*url.URL, error) {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "sftp" || u.Hostname() == "" || u.User.Username() == "" ||
+		u.Path == "" || strings.HasSuffix(u.Path, "/") {
+		return nil, fmt.Errorf("Invalid SFTP location '%s', want sftp://user@host/path/file\n", uri)
+	}
+	if _, ok := u.User.Password(); ok {
+		return nil, fmt.Errorf("SFTP location '%s' cannot hold a password; set password_env in sftp instead\n", uri)
+	}
+	return u, nil
+}
+
+// validateSFTP checks that outFile is a valid SFTP location with a login.
+func validateSFTP(outFile string, hosts map[string]sftpOptions) error {
+	u, err := parseSFTPURI(outFile)
+	if err != nil {
+		return err
+	}
+	o, ok := hosts[u.Hostname()]
+	if !ok {
+		return fmt.Errorf("SFTP destination %s needs a login in sftp.%s\n", outFile, u.Hostname())
+	}
+	if o.KeyFile == "" && o.PasswordEnv == "" {
+		return fmt.Errorf("SFTP login for %s needs key_file or password_env\n", u.Hostname())
+	}
+	return nil
+}
+
+// auth returns the ways of logging in: the key, then the password.
+func (o sftpOptions) auth() ([]ssh.AuthMethod, error) {
+	var methods []ssh.AuthMethod
+	if o.KeyFile != "" {
+		key, err := os.ReadFile(o.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("Could not read SFTP key %s: %v\n", o.KeyFile, err)
+		}
+		var signer ssh.Signer
+		if o.PassphraseEnv != "" {
+			signer, err = ssh.ParsePrivateKeyWithPassphrase(key, []byte(os.Getenv(o.PassphraseEnv)))
+		} else {
+			signer, err = ssh.ParsePrivateKey(key)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Could not parse SFTP key %s: %v\n", o.KeyFile, err)
+		}
+		methods = append(methods, ssh.PublicKeys(signer))
+	}
+	if o.PasswordEnv != "" {
+		password := os.Getenv(o.PasswordEnv)
+		if password == "" {
+			return nil, fmt.Errorf("The SFTP password variable %s is not set\n", o.PasswordEnv)
+		}
+		methods = append(methods, ssh.Password(password))
+	}
+	return methods, nil
+}
+
+func (o sftpOptions) knownHosts() (string, error) {
+	if o.KnownHosts != "" {
+		return o.KnownHosts, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".ssh", "known_hosts"), nil
+}
+
+func (o sftpOptions) tempSuffix() string {
+	if o.TempSuffix != "" {
+		return o.TempSuffix
+	}
+	return ".part"
+}
+
+// sftpUpload writes a file on an SFTP server under a temporary name and
+// renames it into place once it is complete, so that the partner never
+// picks up a partial file. An aborted upload's temporary file is handled by
+// the abort policy.
+type sftpUpload struct {
+	uri    string
+	path   string
+	temp   string
+	policy string
+	conn   *ssh.Client
+	client *sftp.Client
+	f      *sftp.File
+	once   sync.Once
+	err    error
+}
+
+func newSFTPUpload(uri string, hosts map[string]sftpOptions, policy string) (*sftpUpload, error) {
+	u, err := parseSFTPURI(uri)
+	if err != nil {
+		return nil, err
+	}
+	o := hosts[u.Hostname()]
+	auth, err := o.auth()
+	if err != nil {
+		return nil, err
+	}
+	path, err := o.knownHosts()
+	if err != nil {
+		return nil, fmt.Errorf("Could not find the SFTP known_hosts file: %v\n", err)
+	}
+	hostKeys, err := knownhosts.New(path)
+	if err != nil {
+		return nil, fmt.Errorf("Could not read SFTP known_hosts %s: %v\n", path, err)
+	}
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), "22")
+	}
+	conn, err := ssh.Dial("tcp", addr, &ssh.ClientConfig{
+		User:            u.User.Username(),
+		Auth:            auth,
+		HostKeyCallback: hostKeys,
+		Timeout:         sftpDialTimeout,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Could not connect to SFTP server %s: %v\n", addr, err)
+	}
+	client, err := sftp.NewClient(conn)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("Could not start SFTP on %s: %v\n", addr, err)
+	}
+	s := &sftpUpload{uri: uri, path: u.Path, temp: u.Path + o.tempSuffix(), policy: policy, conn: conn, client: client}
+	if s.f, err = client.Create(s.temp); err != nil {
+		s.disconnect()
+		return nil, fmt.Errorf("Could not create %s on %s: %v\n", s.temp, addr, err)
+	}
+	return s, nil
+}
+
+func (s *sftpUpload) Write(p []byte) (int, error) {
+	return s.f.Write(p)
+}
+
+// Close finishes the temporary file and renames it to the file's name,
+// replacing an earlier delivery.
+func (s *sftpUpload) Close() error {
+	s.once.Do(func() {
+		defer s.disconnect()
+		if err := s.f.Close(); err != nil {
+			s.discard()
+			s.err = fmt.Errorf("Upload to %s failed: %v\n", s.uri, err)
+			return
+		}
+		if err := s.rename(); err != nil {
+			s.discard()
+			s.err = fmt.Errorf("Upload to %s failed, could not rename %s into place: %v\n", s.uri, s.temp, err)
+		}
+	})
+	return s.err
+}
+
+// rename moves the temporary file into place. Servers without the POSIX
+// rename extension refuse to rename over a file, so there the old one is
+// removed first; any other failure is returned as is.
+func (s *sftpUpload) rename() error {
+	if _, ok := s.client.HasExtension("posix-rename@openssh.com"); ok {
+		return s.client.PosixRename(s.temp, s.path)
+	}
+	if err := s.client.Remove(s.path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return s.client.Rename(s.temp, s.path)
+}
+
+// Abort closes the temporary file and deletes, marks or keeps it according
+// to the abort policy.
+func (s *sftpUpload) Abort() {
+	s.once.Do(func() {
+		defer s.disconnect()
+		s.f.Close()
+		s.discard()
+		s.err = fmt.Errorf("Upload to %s was aborted\n", s.uri)
+	})
+}
+
+func (s *sftpUpload) discard() {
+	var err error
+	switch s.policy {
+	case abortKeep:
+		return
+	case abortMark:
+		err = s.client.Rename(s.temp, s.path+incompleteSuffix)
+	default:
+		err = s.client.Remove(s.temp)
+	}
+	if err != nil && !os.IsNotExist(err) {
+		log.Printf("Warning: could not clean up partial file %s on the SFTP server: %v\n", s.temp, err)
+	}
+}
+
+func (s *sftpUpload) disconnect() {
+	s.client.Close()
+	s.conn.Close()
+}
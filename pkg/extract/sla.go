@@ -0,0 +1,121 @@
+package extract
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"time"
+)
+
+// slaOptions is the service level of a job: it must have finished by
+// CompleteBy, a local time of day such as 06:30, and within MaxDuration of
+// starting. A breach raises an alert when it happens, whether or not the job
+// goes on to succeed.
+type slaOptions struct {
+	CompleteBy  string         `yaml:"complete_by"`
+	MaxDuration configDuration `yaml:"max_duration"`
+}
+
+func (o slaOptions) validate(outFile string) error {
+	if o.CompleteBy == "" && o.MaxDuration == 0 {
+		return fmt.Errorf("The sla of %s sets neither complete_by nor max_duration\n", outFile)
+	}
+	if o.CompleteBy != "" {
+		if _, err := time.Parse("15:04", o.CompleteBy); err != nil {
+			return fmt.Errorf("The sla complete_by of %s must be a time of day such as 06:30, got '%s'\n", outFile, o.CompleteBy)
+		}
+	}
+	if o.MaxDuration < 0 {
+		return fmt.Errorf("The sla max_duration of %s cannot be negative\n", outFile)
+	}
+	return nil
+}
+
+// validateSLANotify checks that the sla_notify targets are http(s) URLs.
+func validateSLANotify(targets []string) error {
+	for _, target := range targets {
+		u, err := url.Parse(target)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return fmt.Errorf("sla_notify target '%s' is not an http(s) URL\n", target)
+		}
+	}
+	return nil
+}
+
+// SLA breaches, by the limit that was passed.
+const (
+	slaCompleteBy  = "complete_by"
+	slaMaxDuration = "max_duration"
+)
+
+// deadline returns when a run of the job starting at started breaches the
+// SLA, and which limit it passes. A run starting after the day's complete_by
+// is only held to it if the job has not succeeded since the day began, as
+// lastRun records.
+func (o slaOptions) deadline(started, lastRun time.Time) (time.Time, string) {
+	var deadline time.Time
+	var limit string
+	if o.CompleteBy != "" {
+		t, _ := time.Parse("15:04", o.CompleteBy)
+		y, m, d := started.Date()
+		day := time.Date(y, m, d, 0, 0, 0, 0, started.Location())
+		by := time.Date(y, m, d, t.Hour(), t.Minute(), 0, 0, started.Location())
+		if !by.Before(started) || lastRun.Before(day) {
+			deadline, limit = by, slaCompleteBy
+		}
+	}
+	if o.MaxDuration > 0 {
+		if d := started.Add(time.Duration(o.MaxDuration)); deadline.IsZero() || d.Before(deadline) {
+			deadline, limit = d, slaMaxDuration
+		}
+	}
+	return deadline, limit
+}
+
+// slaBreach is posted as JSON to the sla_notify targets when a job breaches
+// its SLA.
+type slaBreach struct {
+	Event    string    `json:"event"`
+	OutFile  string    `json:"outfile"`
+	Job      string    `json:"job"`
+	Limit    string    `json:"limit"`
+	Deadline time.Time `json:"deadline"`
+	Started  time.Time `json:"started"`
+	Tenant   string    `json:"tenant,omitempty"`
+}
+
+// watchSLA raises an alert if the job writing outFile, started now, has not
+// finished by its SLA's deadline. The returned function is called when the
+// job finishes.
+func (c *config) watchSLA(l *ledger, tenant, outFile string) func() {
+	o, ok := c.SLAs[outFile]
+	if !ok {
+		return func() {}
+	}
+	started := time.Now()
+	var lastRun time.Time
+	if e := l.entry(outFile); e != nil {
+		lastRun = e.LastRun
+	}
+	deadline, limit := o.deadline(started, lastRun)
+	breach := slaBreach{Event: "sla_breach", OutFile: outFile, Job: c.jobName(outFile), Limit: limit, Deadline: deadline, Started: started, Tenant: tenant}
+	timer := time.AfterFunc(time.Until(deadline), func() { c.raiseSLABreach(breach) })
+	return func() { timer.Stop() }
+}
+
+// raiseSLABreach logs the breach and posts it to the sla_notify targets.
+// Failed notifications are logged and do not fail the job.
+func (c *config) raiseSLABreach(b slaBreach) {
+	log.Printf("SLA breach: %s has not finished by its %s deadline of %s\n", b.OutFile, b.Limit, b.Deadline.Format(time.DateTime))
+	body, err := json.Marshal(b)
+	if err != nil {
+		log.Printf("Warning: could not encode the SLA breach of %s: %v\n", b.OutFile, err)
+		return
+	}
+	for _, target := range c.SLANotify {
+		if err := postNotice(target, body); err != nil {
+			log.Printf("Warning: could not send the SLA breach of %s to %s: %v\n", b.OutFile, target, err)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package extract
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSLADeadline(t *testing.T) {
+	at := func(hour, min int) time.Time { return time.Date(2025, time.March, 3, hour, min, 0, 0, time.UTC) }
+	for _, tc := range []struct {
+		name      string
+		o         slaOptions
+		started   time.Time
+		lastRun   time.Time
+		want      time.Time
+		wantLimit string
+	}{
+		{"before complete_by", slaOptions{CompleteBy: "06:30"}, at(5, 0), time.Time{}, at(6, 30), slaCompleteBy},
+		{"max_duration first", slaOptions{CompleteBy: "06:30", MaxDuration: configDuration(45 * time.Minute)}, at(6, 0), time.Time{}, at(6, 30), slaCompleteBy},
+		{"max_duration sooner", slaOptions{CompleteBy: "06:30", MaxDuration: configDuration(20 * time.Minute)}, at(6, 0), time.Time{}, at(6, 20), slaMaxDuration},
+		{"late without a success", slaOptions{CompleteBy: "06:30"}, at(7, 0), at(5, 0).AddDate(0, 0, -1), at(6, 30), slaCompleteBy},
+		{"late after a success", slaOptions{CompleteBy: "06:30", MaxDuration: configDuration(time.Hour)}, at(7, 0), at(6, 10), at(8, 0), slaMaxDuration},
+	} {
+		got, limit := tc.o.deadline(tc.started, tc.lastRun)
+		if !got.Equal(tc.want) || limit != tc.wantLimit {
+			t.Errorf("%s: got %s %s, want %s %s", tc.name, got, limit, tc.want, tc.wantLimit)
+		}
+	}
+	if err := (slaOptions{CompleteBy: "6.30pm"}).validate("a.csv"); err == nil {
+		t.Error("accepted complete_by 6.30pm")
+	}
+}
+
+func TestSLABreachAlert(t *testing.T) {
+	breaches := make(chan slaBreach, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var b slaBreach
+		json.NewDecoder(r.Body).Decode(&b)
+		breaches <- b
+	}))
+	defer srv.Close()
+
+	c := &config{
+		SLAs:      map[string]slaOptions{"slow.csv": {MaxDuration: configDuration(10 * time.Millisecond)}, "fast.csv": {MaxDuration: configDuration(time.Hour)}},
+		SLANotify: []string{srv.URL},
+	}
+	c.watchSLA(nil, "", "fast.csv")()
+	finish := c.watchSLA(nil, "finance", "slow.csv")
+	defer finish()
+	select {
+	case b := <-breaches:
+		if b.Event != "sla_breach" || b.OutFile != "slow.csv" || b.Limit != slaMaxDuration || b.Tenant != "finance" {
+			t.Errorf("got %+v", b)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no breach was posted")
+	}
+	select {
+	case b := <-breaches:
+		t.Errorf("a finished job breached: %+v", b)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -0,0 +1,402 @@
+package extract
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+
+	"cloud.google.com/go/storage"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// snapshotStore keeps the snapshot of a change publication between runs.
+// Snapshots are read and written through local files; a remote store copies
+// them down on fetch and up on commit.
+type snapshotStore interface {
+	// fetch returns the current snapshot, or nil if there is none yet, and a
+	// function to call when done with it.
+	fetch() (*os.File, func(), error)
+	// create returns a file for the next snapshot.
+	create() (*os.File, error)
+	// commit replaces the current snapshot with f.
+	commit(f *os.File) error
+	// discard drops f, keeping the current snapshot.
+	discard(f *os.File)
+	// Close releases the client of a remote store.
+	Close() error
+}
+
+// openSnapshotStore picks the store for a snapshot location: s3://bucket/key,
+// gs://bucket/object or a local path. The caller closes it.
+func openSnapshotStore(location string) (snapshotStore, error) {
+	switch {
+	case strings.HasPrefix(location, "s3://"):
+		bucket, key, err := splitS3URI(location)
+		if err != nil {
+			return nil, err
+		}
+		client, err := newS3Client(context.Background(), "")
+		if err != nil {
+			return nil, err
+		}
+		return &objectStore{
+			location: location,
+			get: func(ctx context.Context) (io.ReadCloser, error) {
+				out, err := client.GetObject(ctx, &s3.GetObjectInput{Bucket: aws.String(bucket), Key: aws.String(key)})
+				var missing *types.NoSuchKey
+				if errors.As(err, &missing) {
+					return nil, nil
+				}
+				if err != nil {
+					return nil, err
+				}
+				return out.Body, nil
+			},
+			put: func(ctx context.Context, r io.Reader) error {
+				_, err := client.PutObject(ctx, &s3.PutObjectInput{Bucket: aws.String(bucket), Key: aws.String(key), Body: r})
+				return err
+			},
+		}, nil
+	case strings.HasPrefix(location, "gs://"):
+		bucket, object, _ := strings.Cut(strings.TrimPrefix(location, "gs://"), "/")
+		if bucket == "" || object == "" {
+			return nil, fmt.Errorf("Invalid GCS location '%s'\n", location)
+		}
+		client, err := storage.NewClient(context.Background())
+		if err != nil {
+			return nil, fmt.Errorf("Could not create a GCS client: %v\n", err)
+		}
+		obj := client.Bucket(bucket).Object(object)
+		return &objectStore{
+			location: location,
+			close:    client.Close,
+			get: func(ctx context.Context) (io.ReadCloser, error) {
+				r, err := obj.NewReader(ctx)
+				if errors.Is(err, storage.ErrObjectNotExist) {
+					return nil, nil
+				}
+				return r, err
+			},
+			put: func(ctx context.Context, r io.Reader) error {
+				w := obj.NewWriter(ctx)
+				if _, err := io.Copy(w, r); err != nil {
+					w.Close()
+					return err
+				}
+				return w.Close()
+			},
+		}, nil
+	}
+	return fileStore(location), nil
+}
+
+// fileStore keeps the snapshot in a local file, replaced by rename.
+type fileStore string
+
+func (s fileStore) fetch() (*os.File, func(), error) {
+	f, err := os.Open(string(s))
+	if os.IsNotExist(err) {
+		return nil, func() {}, nil
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("Could not open snapshot %s: %v\n", s, err)
+	}
+	return f, func() { f.Close() }, nil
+}
+
+func (s fileStore) create() (*os.File, error) {
+	f, err := os.Create(string(s) + ".new")
+	if err != nil {
+		return nil, fmt.Errorf("Could not create snapshot %s: %v\n", s, err)
+	}
+	return f, nil
+}
+
+func (s fileStore) commit(f *os.File) error {
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("Could not write snapshot %s: %v\n", s, err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("Could not write snapshot %s: %v\n", s, err)
+	}
+	if err := os.Rename(f.Name(), string(s)); err != nil {
+		return fmt.Errorf("Could not replace snapshot %s: %v\n", s, err)
+	}
+	return nil
+}
+
+func (s fileStore) discard(f *os.File) {
+	f.Close()
+	os.Remove(f.Name())
+}
+
+func (s fileStore) Close() error { return nil }
+
+// objectStore keeps the snapshot as one object, uploaded whole on commit so
+// readers never see a partial snapshot. close, if set, releases the client.
+type objectStore struct {
+	location string
+	get      func(ctx context.Context) (io.ReadCloser, error)
+	put      func(ctx context.Context, r io.Reader) error
+	close    func() error
+}
+
+func (s *objectStore) fetch() (*os.File, func(), error) {
+	r, err := s.get(context.Background())
+	if err != nil {
+		return nil, nil, fmt.Errorf("Could not download snapshot %s: %v\n", s.location, err)
+	}
+	if r == nil {
+		return nil, func() {}, nil
+	}
+	defer r.Close()
+	f, err := os.CreateTemp("", "snapshot-*")
+	if err != nil {
+		return nil, nil, err
+	}
+	done := func() {
+		f.Close()
+		os.Remove(f.Name())
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		done()
+		return nil, nil, fmt.Errorf("Could not download snapshot %s: %v\n", s.location, err)
+	}
+	return f, done, nil
+}
+
+func (s *objectStore) create() (*os.File, error) {
+	return os.CreateTemp("", "snapshot-*.new")
+}
+
+func (s *objectStore) commit(f *os.File) error {
+	defer s.discard(f)
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if err := s.put(context.Background(), f); err != nil {
+		return fmt.Errorf("Could not upload snapshot %s: %v\n", s.location, err)
+	}
+	return nil
+}
+
+func (s *objectStore) discard(f *os.File) {
+	f.Close()
+	os.Remove(f.Name())
+}
+
+func (s *objectStore) Close() error {
+	if s.close == nil {
+		return nil
+	}
+	return s.close()
+}
+
+// The snapshot file holds a gob stream of key records, for writing deletes,
+// followed by the compacted index: one key and row hash pair per key, sorted
+// by key hash, 16 bytes each. The footer gives the offset of the index, the
+// number of keys and the magic.
+const (
+	snapshotMagic  = "SXSNAP2\n"
+	snapshotFooter = 24
+)
+
+// snapshotKey is a key record of a snapshot.
+type snapshotKey struct {
+	KeyHash uint64
+	Key     []any
+}
+
+// snapshotHash is a snapshot index entry.
+type snapshotHash struct{ key, row uint64 }
+
+// snapshotHashes is a snapshot's index sorted by key hash.
+type snapshotHashes []snapshotHash
+
+func (s snapshotHashes) find(key uint64) (int, bool) {
+	return slices.BinarySearchFunc(s, key, func(e snapshotHash, k uint64) int {
+		return cmpUint64(e.key, k)
+	})
+}
+
+func cmpUint64(a, b uint64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// compact sorts the index by key hash and keeps the last entry of each key.
+func (s snapshotHashes) compact() snapshotHashes {
+	slices.SortStableFunc(s, func(a, b snapshotHash) int { return cmpUint64(a.key, b.key) })
+	out := s[:0]
+	for i, e := range s {
+		if i+1 < len(s) && s[i+1].key == e.key {
+			continue
+		}
+		out = append(out, e)
+	}
+	return out
+}
+
+// snapshotWriter writes a snapshot, refusing to grow past maxKeys when set.
+type snapshotWriter struct {
+	counter *countingWriter
+	buf     *bufio.Writer
+	enc     *gob.Encoder
+	index   snapshotHashes
+	maxKeys int
+}
+
+func newSnapshotWriter(f io.Writer, maxKeys int) *snapshotWriter {
+	w := &snapshotWriter{maxKeys: maxKeys}
+	w.buf = bufio.NewWriter(f)
+	w.counter = &countingWriter{w: w.buf}
+	w.enc = gob.NewEncoder(w.counter)
+	return w
+}
+
+func (w *snapshotWriter) add(keyHash, rowHash uint64, key []any) error {
+	if err := w.enc.Encode(snapshotKey{KeyHash: keyHash, Key: key}); err != nil {
+		return fmt.Errorf("could not write snapshot: %v", err)
+	}
+	w.index = append(w.index, snapshotHash{keyHash, rowHash})
+	// repeated keys only count once, so the index is compacted before giving up
+	if w.maxKeys > 0 && len(w.index) >= 2*w.maxKeys {
+		return w.compact()
+	}
+	return nil
+}
+
+func (w *snapshotWriter) compact() error {
+	w.index = w.index.compact()
+	if w.maxKeys > 0 && len(w.index) > w.maxKeys {
+		return fmt.Errorf("the snapshot would hold more than max_keys (%d) keys", w.maxKeys)
+	}
+	return nil
+}
+
+// finish writes the compacted index and footer.
+func (w *snapshotWriter) finish() error {
+	offset := w.counter.n.Load()
+	if err := w.compact(); err != nil {
+		return err
+	}
+	var b [16]byte
+	for _, e := range w.index {
+		binary.LittleEndian.PutUint64(b[:8], e.key)
+		binary.LittleEndian.PutUint64(b[8:], e.row)
+		if _, err := w.buf.Write(b[:]); err != nil {
+			return err
+		}
+	}
+	binary.LittleEndian.PutUint64(b[:8], uint64(offset))
+	binary.LittleEndian.PutUint64(b[8:], uint64(len(w.index)))
+	w.buf.Write(b[:])
+	w.buf.WriteString(snapshotMagic)
+	return w.buf.Flush()
+}
+
+// snapshotLayout returns where the index of a snapshot starts and how many
+// keys it has, or ok false for a snapshot in the original format, a plain gob
+// stream of entries.
+func snapshotLayout(f stateSource) (offset, count int64, ok bool, err error) {
+	size := f.Size()
+	if size < snapshotFooter {
+		return 0, 0, false, nil
+	}
+	var footer [snapshotFooter]byte
+	if _, err := f.ReadAt(footer[:], size-snapshotFooter); err != nil && !errors.Is(err, io.EOF) {
+		return 0, 0, false, err
+	}
+	if string(footer[16:]) != snapshotMagic {
+		return 0, 0, false, nil
+	}
+	offset = int64(binary.LittleEndian.Uint64(footer[:8]))
+	count = int64(binary.LittleEndian.Uint64(footer[8:16]))
+	if offset+16*count+snapshotFooter != size {
+		return 0, 0, false, fmt.Errorf("the snapshot is truncated")
+	}
+	return offset, count, true, nil
+}
+
+// legacySnapshotEntry is an entry of the original snapshot format.
+type legacySnapshotEntry struct {
+	KeyHash uint64
+	RowHash uint64
+	Key     []any
+}
+
+// readSnapshotIndex loads the index of a snapshot.
+func readSnapshotIndex(f stateSource) (snapshotHashes, error) {
+	offset, count, ok, err := snapshotLayout(f)
+	if err != nil {
+		return nil, fmt.Errorf("Could not read snapshot %s: %v\n", f.Name(), err)
+	}
+	if !ok {
+		var index snapshotHashes
+		err := readLegacySnapshot(f, func(e legacySnapshotEntry) {
+			index = append(index, snapshotHash{e.KeyHash, e.RowHash})
+		})
+		return index.compact(), err
+	}
+	index := make(snapshotHashes, count)
+	r := bufio.NewReader(io.NewSectionReader(f, offset, 16*count))
+	var b [16]byte
+	for i := range index {
+		if _, err := io.ReadFull(r, b[:]); err != nil {
+			return nil, fmt.Errorf("Could not read snapshot %s: %v\n", f.Name(), err)
+		}
+		index[i] = snapshotHash{binary.LittleEndian.Uint64(b[:8]), binary.LittleEndian.Uint64(b[8:])}
+	}
+	return index, nil
+}
+
+// readSnapshotKeys calls fn for every key record of a snapshot.
+func readSnapshotKeys(f stateSource, fn func(snapshotKey)) error {
+	offset, _, ok, err := snapshotLayout(f)
+	if err != nil {
+		return fmt.Errorf("Could not read snapshot %s: %v\n", f.Name(), err)
+	}
+	if !ok {
+		return readLegacySnapshot(f, func(e legacySnapshotEntry) {
+			fn(snapshotKey{KeyHash: e.KeyHash, Key: e.Key})
+		})
+	}
+	dec := gob.NewDecoder(bufio.NewReader(io.NewSectionReader(f, 0, offset)))
+	for {
+		var k snapshotKey
+		if err := dec.Decode(&k); errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("Could not read snapshot %s: %v\n", f.Name(), err)
+		}
+		fn(k)
+	}
+}
+
+func readLegacySnapshot(f stateSource, fn func(legacySnapshotEntry)) error {
+	dec := gob.NewDecoder(bufio.NewReader(io.NewSectionReader(f, 0, f.Size())))
+	for {
+		var e legacySnapshotEntry
+		if err := dec.Decode(&e); errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("Could not read snapshot %s: %v\n", f.Name(), err)
+		}
+		fn(e)
+	}
+}
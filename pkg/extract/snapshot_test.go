@@ -0,0 +1,107 @@
+package extract
+
+import (
+	"encoding/gob"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// openPlain returns the unencrypted view of a snapshot file.
+func openPlain(t *testing.T, f *os.File) stateSource {
+	t.Helper()
+	fi, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	src, err := (*keyring)(nil).openState(f, fi.Size(), f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return src
+}
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	store := fileStore(filepath.Join(t.TempDir(), "snapshot"))
+	f, err := store.create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := newSnapshotWriter(f, 0)
+	// key 2 appears twice; the index keeps its last row hash
+	for _, e := range []snapshotHash{{3, 30}, {2, 20}, {1, 10}, {2, 21}} {
+		if err := w.add(e.key, e.row, []any{int64(e.key)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.finish(); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.commit(f); err != nil {
+		t.Fatal(err)
+	}
+
+	cur, done, err := store.fetch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer done()
+	src := openPlain(t, cur)
+	index, err := readSnapshotIndex(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := snapshotHashes{{1, 10}, {2, 21}, {3, 30}}
+	if len(index) != len(want) {
+		t.Fatalf("got index %v, want %v", index, want)
+	}
+	for i := range want {
+		if index[i] != want[i] {
+			t.Fatalf("got index %v, want %v", index, want)
+		}
+	}
+	var keys int
+	if err := readSnapshotKeys(src, func(k snapshotKey) { keys++ }); err != nil || keys != 4 {
+		t.Errorf("read %d key records, %v", keys, err)
+	}
+}
+
+func TestSnapshotLegacyFormat(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snapshot")
+	f, _ := os.Create(path)
+	enc := gob.NewEncoder(f)
+	enc.Encode(legacySnapshotEntry{KeyHash: 7, RowHash: 70, Key: []any{int64(7)}})
+	enc.Encode(legacySnapshotEntry{KeyHash: 5, RowHash: 50, Key: []any{int64(5)}})
+	f.Close()
+
+	f, _ = os.Open(path)
+	defer f.Close()
+	src := openPlain(t, f)
+	index, err := readSnapshotIndex(src)
+	if err != nil || len(index) != 2 || index[0] != (snapshotHash{5, 50}) {
+		t.Fatalf("got %v, %v", index, err)
+	}
+	var keys []any
+	readSnapshotKeys(src, func(k snapshotKey) { keys = append(keys, k.Key[0]) })
+	if len(keys) != 2 || keys[0] != int64(7) {
+		t.Errorf("got keys %v", keys)
+	}
+}
+
+func TestSnapshotMaxKeys(t *testing.T) {
+	f, err := os.Create(filepath.Join(t.TempDir(), "snapshot"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	w := newSnapshotWriter(f, 2)
+	w.add(1, 1, nil)
+	w.add(2, 2, nil)
+	if err := w.add(1, 3, nil); err != nil {
+		t.Fatalf("a repeated key counted against max_keys: %v", err)
+	}
+	if err := w.add(3, 3, nil); err == nil || !strings.Contains(err.Error(), "max_keys") {
+		t.Errorf("got %v, want the max_keys error", err)
+	}
+}
@@ -0,0 +1,163 @@
+package extract
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	_ "github.com/snowflakedb/gosnowflake"
+)
+
+// snowflakeOnError matches the ON_ERROR values COPY INTO accepts.
+var snowflakeOnError = regexp.MustCompile(`^(?i)(abort_statement|continue|skip_file|skip_file_\d+%?)$`)
+
+// snowflakeOptions holds the settings for snowflake:// destinations.
+type snowflakeOptions struct {
+	// DSNEnv names the environment variable holding the gosnowflake DSN.
+	DSNEnv string `yaml:"dsn_env"`
+	// Stage is the stage files are PUT to; the table stage is used when empty.
+	Stage string `yaml:"stage"`
+	// OnError is the COPY INTO ON_ERROR option, abort_statement by default.
+	OnError string `yaml:"on_error"`
+	// KeepStaged leaves the file on the stage after a successful load.
+	KeepStaged bool `yaml:"keep_staged"`
+}
+
+// snowflakeWriter writes the result set to a local Parquet file, PUTs it to a
+// Snowflake stage and loads it with COPY INTO.
+type snowflakeWriter struct {
+	*parquetWriter
+	opts  snowflakeOptions
+	table string
+	dir   string
+	file  string
+	f     *os.File
+	abort string
+	done  bool
+}
+
+// newSnowflakeWriter prepares a load into table, given as [database.][schema.]table.
+func newSnowflakeWriter(table string, o snowflakeOptions, abort string) (*snowflakeWriter, error) {
+	if table == "" {
+		return nil, fmt.Errorf("Snowflake destination requires a table name\n")
+	}
+	if o.DSNEnv == "" {
+		o.DSNEnv = "SNOWFLAKE_DSN"
+	}
+	if o.OnError == "" {
+		o.OnError = "abort_statement"
+	}
+	if !snowflakeOnError.MatchString(o.OnError) {
+		return nil, fmt.Errorf("Unsupported snowflake on_error '%s'\n", o.OnError)
+	}
+	if o.Stage == "" {
+		// the table stage of a qualified name is @db.schema.%table
+		dot := strings.LastIndex(table, ".")
+		o.Stage = "@" + table[:dot+1] + "%" + table[dot+1:]
+	}
+
+	dir, err := os.MkdirTemp("", "tea-extract-snowflake-")
+	if err != nil {
+		return nil, err
+	}
+	file := strings.ReplaceAll(table, ".", "_") + "_" + newUUID() + ".parquet"
+	f, err := os.Create(filepath.Join(dir, file))
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	pw, _ := newParquetWriter(f, "snappy")
+	return &snowflakeWriter{parquetWriter: pw, opts: o, table: table, dir: dir, file: file, f: f, abort: abort}, nil
+}
+
+// Close stages the file and runs COPY INTO, logging the per-file load status.
+func (s *snowflakeWriter) Close() error {
+	s.done = true
+	defer os.RemoveAll(s.dir)
+	if err := s.parquetWriter.Close(); err != nil {
+		return err
+	}
+
+	dsn := os.Getenv(s.opts.DSNEnv)
+	if dsn == "" {
+		return fmt.Errorf("Snowflake DSN environment variable %s is not set\n", s.opts.DSNEnv)
+	}
+	db, err := sql.Open("snowflake", dsn)
+	if err != nil {
+		return fmt.Errorf("Could not connect to Snowflake: %v\n", err)
+	}
+	defer db.Close()
+
+	if _, err := db.Exec(s.putStatement()); err != nil {
+		return fmt.Errorf("Could not PUT extract to %s: %v\n", s.opts.Stage, err)
+	}
+
+	rows, err := db.Query(s.copyStatement())
+	if err != nil {
+		// stages have no tags, so mark keeps the file like keep does
+		if s.abort != abortKeep && s.abort != abortMark {
+			if _, rmErr := db.Exec(fmt.Sprintf("REMOVE %s/%s", s.opts.Stage, s.file)); rmErr != nil {
+				log.Printf("Warning: could not remove staged file %s/%s: %v\n", s.opts.Stage, s.file, rmErr)
+			}
+		}
+		return fmt.Errorf("COPY INTO %s failed: %v\n", s.table, err)
+	}
+	defer rows.Close()
+	return logCopyResult(rows, s.table)
+}
+
+// putStatement uploads the local file to the stage as is; it is Parquet
+// already, so it is not compressed again.
+func (s *snowflakeWriter) putStatement() string {
+	local := filepath.ToSlash(filepath.Join(s.dir, s.file))
+	return fmt.Sprintf("PUT 'file://%s' %s AUTO_COMPRESS=FALSE OVERWRITE=TRUE", local, s.opts.Stage)
+}
+
+// copyStatement loads the staged file, matching its columns to the table's
+// by name and purging it unless keep_staged is set.
+func (s *snowflakeWriter) copyStatement() string {
+	return fmt.Sprintf("COPY INTO %s FROM %s FILES = ('%s') FILE_FORMAT = (TYPE = PARQUET) MATCH_BY_COLUMN_NAME = CASE_INSENSITIVE ON_ERROR = %s PURGE = %t",
+		s.table, s.opts.Stage, s.file, strings.ToUpper(s.opts.OnError), !s.opts.KeepStaged)
+}
+
+// logCopyResult logs the status columns COPY INTO returns for each file.
+func logCopyResult(rows *sql.Rows, table string) error {
+	cols, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+	values := make([]sql.NullString, len(cols))
+	ptrs := make([]any, len(cols))
+	for i := range values {
+		ptrs[i] = &values[i]
+	}
+	for rows.Next() {
+		if err := rows.Scan(ptrs...); err != nil {
+			return err
+		}
+		var fields []string
+		for i, c := range cols {
+			switch strings.ToLower(c) {
+			case "status", "rows_loaded", "errors_seen", "first_error":
+				if values[i].Valid && values[i].String != "" {
+					fields = append(fields, fmt.Sprintf("%s=%s", strings.ToLower(c), values[i].String))
+				}
+			}
+		}
+		log.Printf("COPY INTO %s: %s\n", table, strings.Join(fields, " "))
+	}
+	return rows.Err()
+}
+
+// Abort removes the local file; nothing has been staged yet.
+func (s *snowflakeWriter) Abort() {
+	if !s.done {
+		s.done = true
+		s.f.Close()
+		os.RemoveAll(s.dir)
+	}
+}
@@ -0,0 +1,110 @@
+package extract
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSnowflakeStatements(t *testing.T) {
+	for _, tc := range []struct {
+		table string
+		o     snowflakeOptions
+		put   string
+		copy  string
+	}{
+		{
+			"SALES.PUBLIC.ORDERS", snowflakeOptions{},
+			"@SALES.PUBLIC.%ORDERS AUTO_COMPRESS=FALSE OVERWRITE=TRUE",
+			"COPY INTO SALES.PUBLIC.ORDERS FROM @SALES.PUBLIC.%ORDERS FILES = ('%s') FILE_FORMAT = (TYPE = PARQUET) MATCH_BY_COLUMN_NAME = CASE_INSENSITIVE ON_ERROR = ABORT_STATEMENT PURGE = true",
+		},
+		{
+			"ORDERS", snowflakeOptions{Stage: "@LANDING", OnError: "skip_file_10%", KeepStaged: true},
+			"@LANDING AUTO_COMPRESS=FALSE OVERWRITE=TRUE",
+			"COPY INTO ORDERS FROM @LANDING FILES = ('%s') FILE_FORMAT = (TYPE = PARQUET) MATCH_BY_COLUMN_NAME = CASE_INSENSITIVE ON_ERROR = SKIP_FILE_10% PURGE = false",
+		},
+	} {
+		s, err := newSnowflakeWriter(tc.table, tc.o, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(s.file, strings.ReplaceAll(tc.table, ".", "_")+"_") {
+			t.Errorf("%s: local file %s", tc.table, s.file)
+		}
+		local := filepath.ToSlash(filepath.Join(s.dir, s.file))
+		if got, want := s.putStatement(), "PUT 'file://"+local+"' "+tc.put; got != want {
+			t.Errorf("%s: PUT\n got %s\nwant %s", tc.table, got, want)
+		}
+		if got, want := s.copyStatement(), strings.Replace(tc.copy, "%s", s.file, 1); got != want {
+			t.Errorf("%s: COPY\n got %s\nwant %s", tc.table, got, want)
+		}
+		s.Abort()
+		if _, err := os.Stat(s.dir); !os.IsNotExist(err) {
+			t.Errorf("%s: aborted load left %s", tc.table, s.dir)
+		}
+	}
+
+	if _, err := newSnowflakeWriter("ORDERS", snowflakeOptions{OnError: "ignore"}, ""); err == nil {
+		t.Error("accepted an unknown on_error")
+	}
+}
+
+func TestSnowflakeCloseWithoutDSN(t *testing.T) {
+	t.Setenv("TEST_SNOWFLAKE_DSN", "")
+	s, err := newSnowflakeWriter("ORDERS", snowflakeOptions{DSNEnv: "TEST_SNOWFLAKE_DSN"}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.WriteHeader([]column{{Name: "id", DBType: "BIGINT"}})
+	s.WriteRow([]any{int64(1)})
+	if err := s.Close(); err == nil || !strings.Contains(err.Error(), "TEST_SNOWFLAKE_DSN") {
+		t.Errorf("got %v", err)
+	}
+	if _, err := os.Stat(s.dir); !os.IsNotExist(err) {
+		t.Errorf("failed load left %s", s.dir)
+	}
+}
+
+func TestLogCopyResult(t *testing.T) {
+	text := func(name string) fakeColumn {
+		return fakeColumn{name: name, dbType: "TEXT", scanType: reflect.TypeOf("")}
+	}
+	db, err := openFakeDB("copy result", &fakeResult{
+		columns: []fakeColumn{text("file"), text("STATUS"), text("rows_parsed"), text("rows_loaded"), text("errors_seen"), text("first_error")},
+		rows:    1,
+		value: func(_, col int) driver.Value {
+			return []driver.Value{"orders.parquet", "LOADED", "3", "3", "0", ""}[col]
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	rows, err := db.Query("copy result")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	if err := logCopyResult(rows, "ORDERS"); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "COPY INTO ORDERS: status=LOADED rows_loaded=3 errors_seen=0\n") {
+		t.Errorf("logged %q", buf.String())
+	}
+}
+
+func TestSnowflakeReplayable(t *testing.T) {
+	// a COPY INTO statement that fails loads nothing
+	if !(&config{}).replayable("snowflake://SALES.PUBLIC.ORDERS") {
+		t.Error("snowflake:// is not replayable")
+	}
+}
@@ -0,0 +1,110 @@
+package extract
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"golang.org/x/text/collate"
+)
+
+// sortKey is a column to order by, descending when the name starts with -.
+type sortKey struct {
+	idx  int
+	desc bool
+}
+
+// defaultSortMaxRows is how many rows a sort holds in memory unless
+// sort_max_rows is set.
+const defaultSortMaxRows = 1000000
+
+// sortMaxRows returns the most rows a sorted job may buffer.
+func (c *config) sortMaxRows() int {
+	if c.SortMaxRows > 0 {
+		return c.SortMaxRows
+	}
+	return defaultSortMaxRows
+}
+
+// sortedOutput buffers every row in memory and writes them in order on Close.
+// Text columns are ordered by the job's collation. A result of more than
+// maxRows rows fails the job rather than the process running out of memory.
+type sortedOutput struct {
+	output
+	spec     []string
+	collator *collate.Collator
+	maxRows  int
+	cols     []column
+	keys     []sortKey
+	rows     [][]any
+}
+
+func newSortedOutput(w output, spec []string, collator *collate.Collator, maxRows int) *sortedOutput {
+	return &sortedOutput{output: w, spec: spec, collator: collator, maxRows: maxRows}
+}
+
+func (s *sortedOutput) WriteHeader(cols []column) error {
+	byName := make(map[string]int, len(cols))
+	for i, c := range cols {
+		byName[strings.ToLower(c.Name)] = i
+	}
+	for _, name := range s.spec {
+		key := sortKey{}
+		name, key.desc = strings.CutPrefix(name, "-")
+		idx, ok := byName[strings.ToLower(name)]
+		if !ok {
+			return fmt.Errorf("sort column %s is not in the result", name)
+		}
+		key.idx = idx
+		s.keys = append(s.keys, key)
+	}
+	s.cols = cols
+	return s.output.WriteHeader(cols)
+}
+
+func (s *sortedOutput) WriteRow(values []any) error {
+	if len(s.rows) >= s.maxRows {
+		return fmt.Errorf("the result has more than %d rows to sort in memory; raise sort_max_rows or order the rows in the query", s.maxRows)
+	}
+	s.rows = append(s.rows, append([]any(nil), values...))
+	return nil
+}
+
+// Close sorts the buffered rows, NULLs first, and writes them out.
+func (s *sortedOutput) Close() error {
+	sort.SliceStable(s.rows, func(i, j int) bool {
+		for _, k := range s.keys {
+			c := s.compare(k.idx, s.rows[i][k.idx], s.rows[j][k.idx])
+			if c == 0 {
+				continue
+			}
+			if k.desc {
+				return c > 0
+			}
+			return c < 0
+		}
+		return false
+	})
+	for _, row := range s.rows {
+		if err := s.output.WriteRow(row); err != nil {
+			return err
+		}
+	}
+	s.rows = nil
+	return s.output.Close()
+}
+
+func (s *sortedOutput) compare(idx int, a, b any) int {
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		return -1
+	case b == nil:
+		return 1
+	}
+	if s.collator != nil && s.cols[idx].kind() == kindString {
+		return s.collator.CompareString(formatValue(a), formatValue(b))
+	}
+	return compareValues(a, b)
+}
@@ -0,0 +1,85 @@
+package extract
+
+import (
+	"database/sql/driver"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// customersResult returns rows of (name NVARCHAR, balance FLOAT).
+func customersResult() *fakeResult {
+	rows := [][]driver.Value{{"Zorro", 1.5}, {"Åsa", 2.25}, {nil, 3.0}, {"Örjan", 2.25}, {"anna", -0.5}}
+	return &fakeResult{
+		columns: []fakeColumn{
+			{name: "name", dbType: "NVARCHAR", scanType: reflect.TypeOf(""), nullable: true},
+			{name: "balance", dbType: "FLOAT", scanType: reflect.TypeOf(0.0)},
+		},
+		rows:  len(rows),
+		value: func(row, col int) driver.Value { return rows[row][col] },
+	}
+}
+
+func TestExportDataSort(t *testing.T) {
+	registerFake("customers", &fakeQuery{sets: []*fakeResult{customersResult()}})
+	outFile := filepath.Join(t.TempDir(), "out.csv")
+	c := &config{Delimiter: ";", Sort: map[string][]string{outFile: {"-balance", "NAME"}}}
+	got, err := exportFake(t, c, "customers", outFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// without a locale text compares by code point
+	if want := "name;balance\n;3\nÅsa;2.25\nÖrjan;2.25\nZorro;1.5\nanna;-0.5\n"; got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+
+	c.Sort[outFile] = []string{"name"}
+	c.Locales = map[string]string{outFile: "sv-SE"}
+	got, err = exportFake(t, c, "customers", outFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Swedish puts å and ö after z, NULLs first, and writes decimal commas
+	if want := "name;balance\n;3\nanna;-0,5\nZorro;1,5\nÅsa;2,25\nÖrjan;2,25\n"; got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+
+	c.Locales[outFile] = "de-DE"
+	got, err = exportFake(t, c, "customers", outFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "name;balance\n;3\nanna;-0,5\nÅsa;2,25\nÖrjan;2,25\nZorro;1,5\n"; got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
+
+func TestExportDataSortErrors(t *testing.T) {
+	registerFake("sort_errors", &fakeQuery{sets: []*fakeResult{customersResult()}})
+	outFile := filepath.Join(t.TempDir(), "out.csv")
+	c := &config{Sort: map[string][]string{outFile: {"missing"}}}
+	if _, err := exportFake(t, c, "sort_errors", outFile); err == nil || !strings.Contains(err.Error(), "sort column missing") {
+		t.Errorf("sorted by a column not in the result: %v", err)
+	}
+
+	// a result over the cap fails the job and leaves no file
+	c = &config{Sort: map[string][]string{outFile: {"name"}}, SortMaxRows: 4}
+	if _, err := exportFake(t, c, "sort_errors", outFile); err == nil || !strings.Contains(err.Error(), "more than 4 rows") {
+		t.Errorf("sorted more rows than sort_max_rows: %v", err)
+	}
+	if matches, _ := filepath.Glob(filepath.Join(filepath.Dir(outFile), "*")); len(matches) != 0 {
+		t.Errorf("a failed sort left %v", matches)
+	}
+	c.SortMaxRows = 5
+	if _, err := exportFake(t, c, "sort_errors", outFile); err != nil {
+		t.Errorf("sorting as many rows as sort_max_rows: %v", err)
+	}
+
+	if err := (&config{SortMaxRows: -1}).validate(); err == nil || !strings.Contains(err.Error(), "sort_max_rows") {
+		t.Errorf("a negative sort_max_rows: %v", err)
+	}
+	if _, err := parseLocale("not a locale!"); err == nil {
+		t.Error("parsed an invalid locale")
+	}
+}
@@ -0,0 +1,299 @@
+package extract
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// sqlToken is a token of T-SQL text.
+type sqlToken struct {
+	text string
+	// kind is 'w' for a word, 'q' for a bracketed or quoted identifier, 's' for
+	// a string or number, or the punctuation character itself.
+	kind rune
+	// pos is the offset of the token in the text, in runes.
+	pos int
+}
+
+// tokenizeSQL splits T-SQL into words, identifiers, literals and punctuation,
+// dropping whitespace and comments.
+func tokenizeSQL(src string) []sqlToken {
+	var tokens []sqlToken
+	r := []rune(src)
+	for i := 0; i < len(r); {
+		c, at := r[i], i
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '-' && i+1 < len(r) && r[i+1] == '-':
+			for i < len(r) && r[i] != '\n' {
+				i++
+			}
+		case c == '/' && i+1 < len(r) && r[i+1] == '*':
+			// block comments nest in T-SQL
+			depth := 0
+			for i < len(r) {
+				if r[i] == '/' && i+1 < len(r) && r[i+1] == '*' {
+					depth++
+					i += 2
+				} else if r[i] == '*' && i+1 < len(r) && r[i+1] == '/' {
+					depth--
+					i += 2
+					if depth == 0 {
+						break
+					}
+				} else {
+					i++
+				}
+			}
+		case c == '\'' || ((c == 'N' || c == 'n') && i+1 < len(r) && r[i+1] == '\''):
+			if c != '\'' {
+				i++
+			}
+			i = skipQuoted(r, i, '\'')
+			tokens = append(tokens, sqlToken{kind: 's', pos: at})
+		case c == '[':
+			end := skipQuoted(r, i, ']')
+			tokens = append(tokens, sqlToken{text: unquoteIdent(string(r[i+1:end-1]), "]"), kind: 'q', pos: at})
+			i = end
+		case c == '"':
+			end := skipQuoted(r, i, '"')
+			tokens = append(tokens, sqlToken{text: unquoteIdent(string(r[i+1:end-1]), `"`), kind: 'q', pos: at})
+			i = end
+		case unicode.IsLetter(c) || c == '_' || c == '@' || c == '#':
+			start := i
+			for i < len(r) && (unicode.IsLetter(r[i]) || unicode.IsDigit(r[i]) || strings.ContainsRune("_@#$", r[i])) {
+				i++
+			}
+			tokens = append(tokens, sqlToken{text: string(r[start:i]), kind: 'w', pos: at})
+		case unicode.IsDigit(c):
+			for i < len(r) && (unicode.IsDigit(r[i]) || r[i] == '.' || r[i] == 'e' || r[i] == 'E') {
+				i++
+			}
+			tokens = append(tokens, sqlToken{kind: 's', pos: at})
+		default:
+			tokens = append(tokens, sqlToken{text: string(c), kind: c, pos: at})
+			i++
+		}
+	}
+	return tokens
+}
+
+// skipQuoted returns the index just past the literal opened at r[i], where a
+// doubled close character stands for itself.
+func skipQuoted(r []rune, i int, close rune) int {
+	for i++; i < len(r); i++ {
+		if r[i] == close {
+			if i+1 < len(r) && r[i+1] == close {
+				i++
+				continue
+			}
+			return i + 1
+		}
+	}
+	return len(r)
+}
+
+func unquoteIdent(s, close string) string {
+	return strings.ReplaceAll(s, close+close, close)
+}
+
+// isWord reports whether t is the keyword kw.
+func (t sqlToken) isWord(kw string) bool {
+	return t.kind == 'w' && strings.EqualFold(t.text, kw)
+}
+
+// identifier reports whether t can be part of an object name.
+func (t sqlToken) identifier() bool {
+	return t.kind == 'q' || (t.kind == 'w' && !sqlReserved[strings.ToUpper(t.text)])
+}
+
+// sqlReserved holds the keywords that end a table reference where an alias
+// could otherwise follow.
+var sqlReserved = map[string]bool{
+	"AS": true, "ON": true, "WHERE": true, "JOIN": true, "INNER": true, "LEFT": true, "RIGHT": true,
+	"FULL": true, "OUTER": true, "CROSS": true, "APPLY": true, "GROUP": true, "ORDER": true,
+	"HAVING": true, "UNION": true, "EXCEPT": true, "INTERSECT": true, "WITH": true, "SELECT": true,
+	"FROM": true, "OPTION": true, "FOR": true, "PIVOT": true, "UNPIVOT": true, "WINDOW": true,
+	"WHEN": true, "THEN": true, "ELSE": true, "END": true, "AND": true, "OR": true, "NOT": true,
+	"INTO": true, "SET": true, "VALUES": true, "TABLESAMPLE": true,
+}
+
+// sqlRowsetFuncs are the built-in functions that can stand in for a table.
+var sqlRowsetFuncs = map[string]bool{
+	"OPENJSON": true, "OPENQUERY": true, "OPENROWSET": true, "OPENDATASOURCE": true, "OPENXML": true,
+	"STRING_SPLIT": true, "GENERATE_SERIES": true, "CHANGETABLE": true, "CONTAINSTABLE": true,
+	"FREETEXTTABLE": true, "PREDICT": true,
+}
+
+// referencedTables lists the tables, views and table-valued functions a
+// query reads, as schema.name or database.schema.name like they are written
+// but without brackets. Names defined by the query itself, common table
+// expressions, table variables and temporary tables are left out, as are
+// built-in rowset functions such as OPENJSON.
+func referencedTables(query string) []string {
+	tokens := tokenizeSQL(query)
+
+	// common table expressions: WITH name [(columns)] AS ( and , name ... AS (
+	ctes := map[string]bool{}
+	for i := 1; i+1 < len(tokens); i++ {
+		if !tokens[i].identifier() || !(tokens[i-1].isWord("WITH") || tokens[i-1].kind == ',') {
+			continue
+		}
+		j := i + 1
+		if tokens[j].kind == '(' {
+			j = skipParens(tokens, j)
+		}
+		if j+1 < len(tokens) && tokens[j].isWord("AS") && tokens[j+1].kind == '(' {
+			ctes[strings.ToLower(tokens[i].text)] = true
+		}
+	}
+
+	seen := map[string]bool{}
+	var tables []string
+	// queryParen records, per open parenthesis, whether it holds a query; a
+	// FROM inside a function call such as TRIM('x' FROM col) names no table.
+	var queryParen []bool
+	inQuery := func() bool { return len(queryParen) == 0 || queryParen[len(queryParen)-1] }
+
+	for i := 0; i < len(tokens); i++ {
+		t := tokens[i]
+		switch {
+		case t.kind == '(':
+			queryParen = append(queryParen, i+1 < len(tokens) && (tokens[i+1].isWord("SELECT") || tokens[i+1].isWord("WITH")))
+			continue
+		case t.kind == ')':
+			if len(queryParen) > 0 {
+				queryParen = queryParen[:len(queryParen)-1]
+			}
+			continue
+		}
+		if !(t.isWord("FROM") || t.isWord("JOIN") || t.isWord("APPLY")) || !inQuery() {
+			continue
+		}
+		// a FROM list can name several tables separated by commas
+		for j := i + 1; j < len(tokens); {
+			name, parts, next := objectName(tokens, j)
+			if name == "" {
+				break
+			}
+			function := next < len(tokens) && tokens[next].kind == '('
+			switch {
+			case strings.HasPrefix(name, "@"), strings.HasPrefix(name, "#"):
+			case parts == 1 && (ctes[strings.ToLower(name)] || function && sqlRowsetFuncs[strings.ToUpper(name)]):
+			default:
+				if key := strings.ToLower(name); !seen[key] {
+					seen[key] = true
+					tables = append(tables, name)
+				}
+			}
+			if function {
+				next = skipParens(tokens, next)
+			}
+			next = skipAlias(tokens, next)
+			if next >= len(tokens) || tokens[next].kind != ',' || !t.isWord("FROM") {
+				break
+			}
+			j = next + 1
+		}
+	}
+	sort.Strings(tables)
+	return tables
+}
+
+// objectName reads a dotted object name starting at tokens[i], returning it,
+// its number of parts and the index after it. Omitted parts, as in db..table,
+// stay empty.
+func objectName(tokens []sqlToken, i int) (string, int, int) {
+	if i >= len(tokens) || !tokens[i].identifier() {
+		return "", 0, i
+	}
+	parts := []string{tokens[i].text}
+	i++
+	for i < len(tokens) && tokens[i].kind == '.' {
+		i++
+		if i < len(tokens) && tokens[i].identifier() {
+			parts = append(parts, tokens[i].text)
+			i++
+		} else {
+			parts = append(parts, "")
+		}
+	}
+	return strings.Join(parts, "."), len(parts), i
+}
+
+// skipParens returns the index after the parenthesis opened at tokens[i].
+func skipParens(tokens []sqlToken, i int) int {
+	depth := 0
+	for ; i < len(tokens); i++ {
+		switch tokens[i].kind {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return i + 1
+			}
+		}
+	}
+	return i
+}
+
+// skipAlias skips an optional [AS] alias and WITH (hints) after a table.
+func skipAlias(tokens []sqlToken, i int) int {
+	if i < len(tokens) && tokens[i].isWord("AS") {
+		i++
+	}
+	if i < len(tokens) && tokens[i].identifier() {
+		i++
+	}
+	if i+1 < len(tokens) && tokens[i].isWord("WITH") && tokens[i+1].kind == '(' {
+		i = skipParens(tokens, i+1)
+	}
+	return i
+}
+
+// normalizeTable makes a table name comparable for lookups: lower case,
+// without brackets, with the dbo schema when none is given.
+func normalizeTable(name string) string {
+	parts := strings.Split(strings.ToLower(name), ".")
+	for i, p := range parts {
+		parts[i] = strings.Trim(p, `[]"`)
+	}
+	if len(parts) == 1 {
+		parts = []string{"dbo", parts[0]}
+	}
+	if parts[len(parts)-2] == "" {
+		parts[len(parts)-2] = "dbo"
+	}
+	return strings.Join(parts, ".")
+}
+
+// readsTable reports whether a query reads table, matching on the trailing
+// parts both names have, so dbo.Customer matches Sales.dbo.Customer.
+func readsTable(tables []string, table string) bool {
+	want := strings.Split(normalizeTable(table), ".")
+	for _, t := range tables {
+		got := strings.Split(normalizeTable(t), ".")
+		n := min(len(got), len(want))
+		if strings.Join(got[len(got)-n:], ".") == strings.Join(want[len(want)-n:], ".") {
+			return true
+		}
+	}
+	return false
+}
+
+// listTables prints each job with the tables it reads, or with table set,
+// only the jobs that read it.
+func listTables(w io.Writer, c *config, table string) {
+	for i, query := range c.Queries {
+		tables := referencedTables(query)
+		if table != "" && !readsTable(tables, table) {
+			continue
+		}
+		fmt.Fprintf(w, "%s\t%s\n", c.OutFiles[i], strings.Join(tables, ", "))
+	}
+}
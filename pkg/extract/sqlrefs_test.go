@@ -0,0 +1,40 @@
+package extract
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestReferencedTables(t *testing.T) {
+	tests := []struct {
+		name, query string
+		want        []string
+	}{
+		{"joins", `SELECT o.id FROM [dbo].[Orders] o
+			INNER JOIN Sales.dbo.Customers AS c ON c.id = o.customer_id
+			LEFT JOIN (SELECT * FROM dbo.Orders) x ON 1 = 1
+			CROSS APPLY dbo.Lines(o.id)`,
+			[]string{"Sales.dbo.Customers", "dbo.Lines", "dbo.Orders"}},
+		{"comma list with hints", "SELECT * FROM dbo.A a WITH (NOLOCK), B AS b, Archive..C", []string{"Archive..C", "B", "dbo.A"}},
+		{"ctes", `WITH recent (id) AS (SELECT id FROM dbo.Orders), top10 AS (SELECT TOP 10 * FROM recent)
+			SELECT * FROM top10 JOIN dbo.Customer c ON 1 = 1`, []string{"dbo.Customer", "dbo.Orders"}},
+		{"comments and strings", `-- FROM dbo.Commented
+			SELECT 'FROM dbo.Quoted' AS s /* JOIN /* nested */ dbo.Block */ FROM [odd]]name]`, []string{"odd]name"}},
+		{"function syntax", "SELECT TRIM('x' FROM name), (SELECT MAX(id) FROM dbo.Ids) FROM dbo.People", []string{"dbo.Ids", "dbo.People"}},
+		{"temporary and builtin", "SELECT * FROM #staging s JOIN @ids i ON 1 = 1 CROSS APPLY OPENJSON(s.doc) WHERE id IN (SELECT id FROM dbo.Keep)", []string{"dbo.Keep"}},
+	}
+	for _, tt := range tests {
+		if got := referencedTables(tt.query); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestReadsTable(t *testing.T) {
+	tables := []string{"Sales.dbo.Customer", "Orders"}
+	for table, want := range map[string]bool{"dbo.Customer": true, "[dbo].[customer]": true, "customer": true, "dbo.Orders": true, "hr.Orders": false, "dbo.Lines": false} {
+		if got := readsTable(tables, table); got != want {
+			t.Errorf("readsTable(%q) = %v, want %v", table, got, want)
+		}
+	}
+}
@@ -0,0 +1,358 @@
+package extract
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// stateKeyOptions is one key for state encryption: 32 bytes, base64 encoded,
+// read from the environment variable Env or printed by Command, e.g. a KMS or
+// vault CLI call that unwraps a data key.
+type stateKeyOptions struct {
+	ID      string `yaml:"id"`
+	Env     string `yaml:"env"`
+	Command string `yaml:"command"`
+}
+
+// stateEncryptionOptions encrypts the ledger and snapshots at rest. The first
+// key encrypts; every key can decrypt, so a new key is put first and older
+// ones stay listed until every file was rewritten with the new one.
+type stateEncryptionOptions struct {
+	Keys []stateKeyOptions `yaml:"keys"`
+	// AllowPlaintext reads state written before encryption was enabled while
+	// it is migrated; otherwise a plain file is refused.
+	AllowPlaintext bool `yaml:"allow_plaintext"`
+}
+
+// keyring holds the state keys by id. A nil *keyring leaves state in plain text.
+type keyring struct {
+	current        string
+	aeads          map[string]cipher.AEAD
+	allowPlaintext bool
+}
+
+// loadKeyring reads the configured keys.
+func loadKeyring(o stateEncryptionOptions) (*keyring, error) {
+	if len(o.Keys) == 0 {
+		return nil, nil
+	}
+	k := &keyring{current: o.Keys[0].ID, aeads: make(map[string]cipher.AEAD, len(o.Keys)), allowPlaintext: o.AllowPlaintext}
+	for _, ko := range o.Keys {
+		if ko.ID == "" || len(ko.ID) > 255 {
+			return nil, fmt.Errorf("Every state key needs an id of at most 255 bytes\n")
+		}
+		if _, dup := k.aeads[ko.ID]; dup {
+			return nil, fmt.Errorf("State key id '%s' is used twice\n", ko.ID)
+		}
+		raw, err := ko.material()
+		if err != nil {
+			return nil, fmt.Errorf("Could not read state key %s: %v\n", ko.ID, err)
+		}
+		block, err := aes.NewCipher(raw)
+		if err != nil {
+			return nil, fmt.Errorf("State key %s: %v\n", ko.ID, err)
+		}
+		k.aeads[ko.ID], _ = cipher.NewGCM(block)
+	}
+	return k, nil
+}
+
+// material returns the decoded 32-byte key.
+func (o stateKeyOptions) material() ([]byte, error) {
+	var encoded string
+	switch {
+	case o.Env != "" && o.Command != "":
+		return nil, errors.New("set env or command, not both")
+	case o.Env != "":
+		encoded = os.Getenv(o.Env)
+		if encoded == "" {
+			return nil, fmt.Errorf("%s is not set", o.Env)
+		}
+	case o.Command != "":
+		shell, flag := "sh", "-c"
+		if runtime.GOOS == "windows" {
+			shell, flag = "cmd", "/C"
+		}
+		out, err := exec.Command(shell, flag, o.Command).Output()
+		if err != nil {
+			return nil, fmt.Errorf("command failed: %v", err)
+		}
+		encoded = string(out)
+	default:
+		return nil, errors.New("set env or command")
+	}
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, fmt.Errorf("not base64: %v", err)
+	}
+	if len(raw) != 32 {
+		return nil, fmt.Errorf("want 32 bytes for AES-256, got %d", len(raw))
+	}
+	return raw, nil
+}
+
+// Encrypted state is a header followed by the plaintext in chunks sealed with
+// AES-GCM. Each chunk's nonce is the header's random prefix and the chunk
+// number, and its additional data is the header and whether it is the last
+// chunk, so chunks cannot be reordered, swapped between files or cut off.
+// Fixed-size chunks let readers decrypt any part of a file on its own.
+const (
+	stateMagic     = "SXENC1\n"
+	stateChunk     = 64 * 1024
+	stateNonceSize = 12
+	stateTagSize   = 16
+)
+
+// sealer encrypts everything written to it onto w. Close writes the last
+// chunk and must be called.
+type sealer struct {
+	w      io.Writer
+	aead   cipher.AEAD
+	header []byte
+	prefix []byte
+	chunk  uint32
+	buf    []byte
+}
+
+// seal returns a writer encrypting onto w with the current key, or w itself
+// when there are no keys.
+func (k *keyring) seal(w io.Writer) (io.WriteCloser, error) {
+	if k == nil {
+		return nopWriteCloser{w}, nil
+	}
+	prefix := make([]byte, stateNonceSize-4)
+	if _, err := rand.Read(prefix); err != nil {
+		return nil, err
+	}
+	header := append([]byte(stateMagic), byte(len(k.current)))
+	header = append(header, k.current...)
+	header = append(header, prefix...)
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+	return &sealer{w: w, aead: k.aeads[k.current], header: header, prefix: prefix, buf: make([]byte, 0, stateChunk)}, nil
+}
+
+func (s *sealer) Write(p []byte) (int, error) {
+	n := 0
+	for len(p) > 0 {
+		m := min(stateChunk-len(s.buf), len(p))
+		s.buf = append(s.buf, p[:m]...)
+		p, n = p[m:], n+m
+		// a full chunk waits for more data, so that the last chunk is never full
+		if len(s.buf) == stateChunk && len(p) > 0 {
+			if err := s.flush(false); err != nil {
+				return n, err
+			}
+		}
+	}
+	return n, nil
+}
+
+func (s *sealer) flush(last bool) error {
+	if len(s.buf) == stateChunk && last {
+		// the last chunk has to be short, so a full one goes out first
+		if err := s.flush(false); err != nil {
+			return err
+		}
+	}
+	nonce := chunkNonce(s.prefix, s.chunk)
+	out := s.aead.Seal(nil, nonce, s.buf, chunkAD(s.header, last))
+	s.chunk++
+	s.buf = s.buf[:0]
+	_, err := s.w.Write(out)
+	return err
+}
+
+func (s *sealer) Close() error {
+	return s.flush(true)
+}
+
+func chunkNonce(prefix []byte, n uint32) []byte {
+	nonce := make([]byte, stateNonceSize)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[len(prefix):], n)
+	return nonce
+}
+
+func chunkAD(header []byte, last bool) []byte {
+	flag := byte(0)
+	if last {
+		flag = 1
+	}
+	return append(bytes.Clone(header), flag)
+}
+
+type nopWriteCloser struct{ io.Writer }
+
+func (nopWriteCloser) Close() error { return nil }
+
+// stateSource is readable state: a plain file or the decrypted view of an
+// encrypted one.
+type stateSource interface {
+	io.ReaderAt
+	Size() int64
+	Name() string
+}
+
+// plainSource is an unencrypted file.
+type plainSource struct {
+	*os.File
+	size int64
+}
+
+func (p plainSource) Size() int64 { return p.size }
+
+// plaintextStateError refuses a plain state file once keys are configured.
+// It tells the user what to do, so readers return it as is.
+type plaintextStateError struct{ name string }
+
+func (e *plaintextStateError) Error() string {
+	return fmt.Sprintf("State file %s is not encrypted; set state_encryption.allow_plaintext to read it once while migrating\n", e.name)
+}
+
+// openState returns the view of r, named name, to read state through.
+// Encrypted state needs the key it was written with. Plain state is read as
+// is without keys, and with keys only while allow_plaintext migrates it, so
+// that a plain file swapped in for an encrypted one is not trusted.
+func (k *keyring) openState(r io.ReaderAt, size int64, name string) (stateSource, error) {
+	magic := make([]byte, len(stateMagic)+1)
+	n, _ := r.ReadAt(magic, 0)
+	if n < len(magic) || string(magic[:len(stateMagic)]) != stateMagic {
+		if k != nil && !k.allowPlaintext {
+			return nil, &plaintextStateError{name}
+		}
+		if f, ok := r.(*os.File); ok {
+			return plainSource{f, size}, nil
+		}
+		return namedReader{ReaderAt: r, size: size, name: name}, nil
+	}
+	if k == nil {
+		return nil, fmt.Errorf("%s is encrypted but state_encryption has no keys", name)
+	}
+	idLen := int(magic[len(stateMagic)])
+	header := make([]byte, len(stateMagic)+1+idLen+stateNonceSize-4)
+	if _, err := r.ReadAt(header, 0); err != nil {
+		return nil, fmt.Errorf("%s: the encryption header is truncated", name)
+	}
+	id := string(header[len(stateMagic)+1 : len(stateMagic)+1+idLen])
+	aead, ok := k.aeads[id]
+	if !ok {
+		return nil, fmt.Errorf("%s is encrypted with key '%s', which is not configured", name, id)
+	}
+	body := size - int64(len(header))
+	sealed := int64(stateChunk + stateTagSize)
+	chunks := (body + sealed - 1) / sealed
+	if chunks == 0 || body-chunks*stateTagSize < 0 {
+		return nil, fmt.Errorf("%s is truncated", name)
+	}
+	return &openedState{
+		r: r, name: name, aead: aead, header: header, prefix: header[len(header)-(stateNonceSize-4):],
+		chunks: chunks, size: body - chunks*stateTagSize, cached: -1,
+	}, nil
+}
+
+type namedReader struct {
+	io.ReaderAt
+	size int64
+	name string
+}
+
+func (n namedReader) Size() int64  { return n.size }
+func (n namedReader) Name() string { return n.name }
+
+// openedState decrypts an encrypted file a chunk at a time.
+type openedState struct {
+	r      io.ReaderAt
+	name   string
+	aead   cipher.AEAD
+	header []byte
+	prefix []byte
+	chunks int64
+	size   int64
+
+	cached int64
+	plain  []byte
+}
+
+func (o *openedState) Size() int64  { return o.size }
+func (o *openedState) Name() string { return o.name }
+
+// load decrypts chunk i into o.plain.
+func (o *openedState) load(i int64) error {
+	if o.cached == i {
+		return nil
+	}
+	sealed := int64(stateChunk + stateTagSize)
+	buf := make([]byte, sealed)
+	n, err := o.r.ReadAt(buf, int64(len(o.header))+i*sealed)
+	if err != nil && !(errors.Is(err, io.EOF) && i == o.chunks-1) {
+		return err
+	}
+	plain, err := o.aead.Open(buf[:0], chunkNonce(o.prefix, uint32(i)), buf[:n], chunkAD(o.header, i == o.chunks-1))
+	if err != nil {
+		return fmt.Errorf("%s failed to decrypt: it was modified or truncated", o.name)
+	}
+	o.cached, o.plain = i, plain
+	return nil
+}
+
+func (o *openedState) ReadAt(p []byte, off int64) (int, error) {
+	n := 0
+	for len(p) > 0 {
+		if off >= o.size {
+			return n, io.EOF
+		}
+		i := off / stateChunk
+		if err := o.load(i); err != nil {
+			return n, err
+		}
+		m := copy(p, o.plain[off-i*stateChunk:])
+		p, off, n = p[m:], off+int64(m), n+m
+	}
+	return n, nil
+}
+
+// readState reads a whole state file, decrypting it if needed.
+func (k *keyring) readState(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	src, err := k.openState(bytes.NewReader(data), int64(len(data)), path)
+	if err != nil {
+		return nil, err
+	}
+	plain := make([]byte, src.Size())
+	if _, err := src.ReadAt(plain, 0); err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	return plain, nil
+}
+
+// sealState encrypts a whole state file's contents.
+func (k *keyring) sealState(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := k.seal(&buf)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
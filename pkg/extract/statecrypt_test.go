@@ -0,0 +1,141 @@
+package extract
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testKeyring returns a keyring of fresh keys with the given ids, the first
+// one current.
+func testKeyring(t *testing.T, ids ...string) *keyring {
+	t.Helper()
+	var o stateEncryptionOptions
+	for _, id := range ids {
+		raw := make([]byte, 32)
+		rand.Read(raw)
+		env := "TEST_STATE_KEY_" + strings.ToUpper(id)
+		t.Setenv(env, base64.StdEncoding.EncodeToString(raw))
+		o.Keys = append(o.Keys, stateKeyOptions{ID: id, Env: env})
+	}
+	k, err := loadKeyring(o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+func TestStateEncryptionRoundTrip(t *testing.T) {
+	k := testKeyring(t, "a")
+	for _, n := range []int{0, 1, stateChunk - 1, stateChunk, stateChunk + 1, 3*stateChunk + 17} {
+		plain := make([]byte, n)
+		rand.Read(plain)
+		sealed, err := k.sealState(plain)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n > 16 && bytes.Contains(sealed, plain[:16]) {
+			t.Fatalf("%d bytes: the plaintext is visible", n)
+		}
+		src, err := k.openState(bytes.NewReader(sealed), int64(len(sealed)), "state")
+		if err != nil {
+			t.Fatalf("%d bytes: %v", n, err)
+		}
+		if src.Size() != int64(n) {
+			t.Fatalf("%d bytes: size %d", n, src.Size())
+		}
+		got, err := io.ReadAll(io.NewSectionReader(src, 0, src.Size()))
+		if err != nil || !bytes.Equal(got, plain) {
+			t.Fatalf("%d bytes: round trip failed: %v", n, err)
+		}
+		// a read across a chunk boundary
+		if n > stateChunk+8 {
+			part := make([]byte, 16)
+			src.ReadAt(part, stateChunk-8)
+			if !bytes.Equal(part, plain[stateChunk-8:stateChunk+8]) {
+				t.Errorf("%d bytes: ReadAt across chunks returned the wrong bytes", n)
+			}
+		}
+	}
+}
+
+func TestStateEncryptionTampering(t *testing.T) {
+	k := testKeyring(t, "a")
+	plain := make([]byte, 2*stateChunk+100)
+	sealed, _ := k.sealState(plain)
+
+	read := func(data []byte) error {
+		src, err := k.openState(bytes.NewReader(data), int64(len(data)), "state")
+		if err != nil {
+			return err
+		}
+		_, err = io.ReadAll(io.NewSectionReader(src, 0, src.Size()))
+		return err
+	}
+	flipped := bytes.Clone(sealed)
+	flipped[len(flipped)/2] ^= 1
+	if err := read(flipped); err == nil {
+		t.Error("a modified file decrypted")
+	}
+	// dropping the last chunk leaves a whole number of full chunks, the last
+	// of which is not sealed as last
+	if err := read(sealed[:len(sealed)-(100+stateTagSize)]); err == nil {
+		t.Error("a truncated file decrypted")
+	}
+}
+
+func TestStateKeyRotation(t *testing.T) {
+	old := testKeyring(t, "old")
+	path := filepath.Join(t.TempDir(), "ledger.json")
+	l, _ := loadLedger(path, old)
+	if err := l.record("a.csv", []column{{Name: "id", DBType: "BIGINT"}}, 3); err != nil {
+		t.Fatal(err)
+	}
+
+	// the new key is listed first and the old one kept for reading
+	rotated := testKeyring(t, "new", "old")
+	rotated.aeads["old"] = old.aeads["old"]
+	l, err := loadLedger(path, rotated)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l.Jobs["a.csv"] == nil || l.Jobs["a.csv"].Rows != 3 {
+		t.Fatalf("the ledger written with the old key was not read: %+v", l.Jobs)
+	}
+	l.record("b.csv", nil, 1)
+	if _, err := loadLedger(path, old); err == nil || !strings.Contains(err.Error(), "'new'") {
+		t.Errorf("got %v, want the rewritten ledger to need the new key", err)
+	}
+	if _, err := loadLedger(path, nil); err == nil {
+		t.Error("an encrypted ledger was read without keys")
+	}
+}
+
+func TestStatePlaintextMigration(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ledger.json")
+	l, _ := loadLedger(path, nil)
+	if err := l.record("a.csv", nil, 3); err != nil {
+		t.Fatal(err)
+	}
+
+	k := testKeyring(t, "a")
+	want := "State file " + path + " is not encrypted; set state_encryption.allow_plaintext to read it once while migrating\n"
+	if _, err := loadLedger(path, k); err == nil || err.Error() != want {
+		t.Fatalf("got %q, want a plain ledger refused once keys are set", err)
+	}
+
+	k.allowPlaintext = true
+	l, err := loadLedger(path, k)
+	if err != nil || l.Jobs["a.csv"] == nil {
+		t.Fatalf("the plain ledger was not migrated: %v", err)
+	}
+	l.record("b.csv", nil, 1)
+	k.allowPlaintext = false
+	if l, err = loadLedger(path, k); err != nil || len(l.Jobs) != 2 {
+		t.Errorf("the migrated ledger was not rewritten encrypted: %v", err)
+	}
+}
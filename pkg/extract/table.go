@@ -0,0 +1,37 @@
+package extract
+
+import (
+	"fmt"
+	"strings"
+)
+
+// tableOptions are the load settings shared by database table destinations.
+type tableOptions struct {
+	// BatchSize is the number of rows per bulk copy batch sent to SQL Server.
+	BatchSize int `yaml:"batch_size"`
+	// CommitEvery commits after this many rows so large loads don't grow the
+	// target's transaction log unbounded; zero loads in one transaction.
+	CommitEvery int `yaml:"commit_every"`
+	// Pre statements run in the first load transaction, before any row.
+	Pre []string `yaml:"pre"`
+	// Post statements run after the final commit.
+	Post []string `yaml:"post"`
+}
+
+// validate checks the numeric settings.
+func (t tableOptions) validate() error {
+	if t.BatchSize < 0 || t.CommitEvery < 0 {
+		return fmt.Errorf("The batch_size and commit_every settings must not be negative\n")
+	}
+	return nil
+}
+
+// statements returns stmts with {table} replaced by the destination table, so
+// one configured statement applies to every job's own target.
+func (t tableOptions) statements(stmts []string, table string) []string {
+	out := make([]string, len(stmts))
+	for i, s := range stmts {
+		out[i] = strings.ReplaceAll(s, "{table}", table)
+	}
+	return out
+}
@@ -0,0 +1,150 @@
+package extract
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+// tableFiles writes the Parquet data files of an append to a Delta or
+// Iceberg table, one per partition, in Hive-style partition directories
+// under dir. Nothing is visible to readers until the table format commits
+// the files.
+type tableFiles struct {
+	store       tableStore
+	dir         string
+	compression string
+	// nullPartition names the directory of a NULL partition value.
+	nullPartition string
+	// keepPartitions writes the partition columns into the data files too,
+	// as Iceberg does; Delta leaves them out.
+	keepPartitions bool
+	// fieldIDs, when set, are the Parquet field ids of the data columns.
+	fieldIDs []int
+
+	cols     []column
+	dataCols []column
+	partIdx  []int
+	dataIdx  []int
+	parts    map[string]*tablePart
+	record   []any
+}
+
+// tablePart is one data file being written. Size is known once it is closed.
+type tablePart struct {
+	path   string
+	values []any
+	upload remoteUpload
+	count  *countingWriter
+	w      *parquetWriter
+	rows   int64
+	size   int64
+}
+
+// setup resolves the partition columns partitionBy among cols.
+func (t *tableFiles) setup(cols []column, partitionBy []string) error {
+	t.cols = cols
+	t.parts = make(map[string]*tablePart)
+	byName := make(map[string]int, len(cols))
+	for i, c := range cols {
+		byName[c.Name] = i
+	}
+	partitioned := make(map[int]bool)
+	for _, name := range partitionBy {
+		i, ok := byName[name]
+		if !ok {
+			return fmt.Errorf("Partition column %s is not in the query result\n", name)
+		}
+		t.partIdx = append(t.partIdx, i)
+		partitioned[i] = true
+	}
+	for i, c := range cols {
+		if t.keepPartitions || !partitioned[i] {
+			t.dataIdx = append(t.dataIdx, i)
+			t.dataCols = append(t.dataCols, c)
+		}
+	}
+	t.record = make([]any, len(t.dataIdx))
+	return nil
+}
+
+func (t *tableFiles) write(values []any) error {
+	part, err := t.partFor(values)
+	if err != nil {
+		return err
+	}
+	for i, idx := range t.dataIdx {
+		t.record[i] = values[idx]
+	}
+	part.rows++
+	return part.w.WriteRow(t.record)
+}
+
+// partFor returns the data file for the partition of the row, creating it on
+// first use.
+func (t *tableFiles) partFor(values []any) (*tablePart, error) {
+	var dirs []string
+	partValues := make([]any, len(t.partIdx))
+	for i, idx := range t.partIdx {
+		name := t.cols[idx].Name
+		if values[idx] == nil {
+			dirs = append(dirs, name+"="+t.nullPartition)
+			continue
+		}
+		partValues[i] = values[idx]
+		dirs = append(dirs, name+"="+escapePartitionValue(deltaPartitionValue(t.cols[idx], values[idx])))
+	}
+	key := strings.Join(dirs, "/")
+	if part, ok := t.parts[key]; ok {
+		return part, nil
+	}
+
+	rel := path.Join(t.dir, key, fmt.Sprintf("part-%05d-%s-c000.parquet", len(t.parts), newUUID()))
+	upload, err := t.store.create(rel)
+	if err != nil {
+		return nil, err
+	}
+	count := &countingWriter{w: upload}
+	w, _ := newParquetWriter(count, t.compression)
+	w.fieldIDs = t.fieldIDs
+	if err := w.WriteHeader(t.dataCols); err != nil {
+		upload.Abort()
+		return nil, err
+	}
+	part := &tablePart{path: rel, values: partValues, upload: upload, count: count, w: w}
+	t.parts[key] = part
+	return part, nil
+}
+
+// finish closes the data files and returns them by path. An unpartitioned
+// append of no rows still gets an empty file, so its commit records the
+// schema.
+func (t *tableFiles) finish() ([]*tablePart, error) {
+	if len(t.parts) == 0 && len(t.partIdx) == 0 {
+		if _, err := t.partFor(nil); err != nil {
+			return nil, err
+		}
+	}
+	var parts []*tablePart
+	for _, part := range t.parts {
+		if err := part.w.Close(); err != nil {
+			return nil, err
+		}
+		if err := part.upload.Close(); err != nil {
+			return nil, err
+		}
+		part.size = part.count.n.Load()
+		parts = append(parts, part)
+	}
+	sort.Slice(parts, func(i, j int) bool { return parts[i].path < parts[j].path })
+	return parts, nil
+}
+
+// cleanup removes the data files of an append that was not committed.
+func (t *tableFiles) cleanup() {
+	for _, part := range t.parts {
+		part.upload.Abort()
+		t.store.remove(part.path)
+	}
+}
@@ -0,0 +1,390 @@
+package extract
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"cloud.google.com/go/storage"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/bloberror"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/iterator"
+)
+
+// tableStore holds the files of a lakehouse table under its root: a local
+// directory, or a prefix in S3, GCS or Azure Blob Storage. Paths are
+// relative to the root and separated by slashes.
+type tableStore interface {
+	// create starts writing the file at rel, which appears once it is closed.
+	create(rel string) (remoteUpload, error)
+	// read returns the file at rel, failing with os.ErrNotExist if there is
+	// none.
+	read(rel string) ([]byte, error)
+	// list returns the names of the files directly under dir.
+	list(dir string) ([]string, error)
+	// claim writes the file at rel unless it exists, failing with
+	// os.ErrExist if it does. Commits claim their version with it.
+	claim(rel string, data []byte) error
+	remove(rel string) error
+	// location is the full path or URI of rel, as engines reading the table
+	// see it.
+	location(rel string) string
+	Close() error
+}
+
+// openTableStore opens the table at root: s3://bucket/prefix,
+// gs://bucket/prefix, az://container/prefix or a local path. Credentials come
+// from the same chains as remote outfiles.
+func openTableStore(root string) (tableStore, error) {
+	switch {
+	case strings.HasPrefix(root, "s3://"):
+		bucket, prefix, err := splitS3URI(root)
+		if err != nil {
+			return nil, err
+		}
+		client, err := newS3Client(context.Background(), "")
+		if err != nil {
+			return nil, err
+		}
+		return &s3Table{client: client, bucket: bucket, prefix: strings.Trim(prefix, "/")}, nil
+	case strings.HasPrefix(root, "gs://"):
+		bucket, prefix, err := splitGCSURI(root)
+		if err != nil {
+			return nil, err
+		}
+		client, err := storage.NewClient(context.Background())
+		if err != nil {
+			return nil, fmt.Errorf("Could not create a GCS client: %v\n", err)
+		}
+		return &gcsTable{client: client, bucket: bucket, prefix: strings.Trim(prefix, "/")}, nil
+	case strings.HasPrefix(root, "az://"):
+		container, prefix, _ := strings.Cut(strings.TrimPrefix(root, "az://"), "/")
+		if container == "" {
+			return nil, fmt.Errorf("Invalid Azure Blob Storage location '%s'\n", root)
+		}
+		client, err := newAzureBlobClient()
+		if err != nil {
+			return nil, err
+		}
+		return &azureTable{client: client, container: container, prefix: strings.Trim(prefix, "/")}, nil
+	case strings.Contains(root, "://"):
+		return nil, fmt.Errorf("Unsupported table location '%s', use a local path, s3://, gs:// or az://\n", root)
+	}
+	abs, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(abs, 0o755); err != nil {
+		return nil, fmt.Errorf("Could not create table directory %s: %v\n", root, err)
+	}
+	return localTable(abs), nil
+}
+
+// objectKey joins the prefix of a table in an object store and rel.
+func objectKey(prefix, rel string) string {
+	if prefix == "" {
+		return rel
+	}
+	return prefix + "/" + rel
+}
+
+// localTable is a table in a local or mounted directory.
+type localTable string
+
+func (t localTable) path(rel string) string {
+	return filepath.Join(string(t), filepath.FromSlash(rel))
+}
+
+func (t localTable) create(rel string) (remoteUpload, error) {
+	p := t.path(rel)
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return nil, err
+	}
+	f, err := os.Create(p)
+	if err != nil {
+		return nil, fmt.Errorf("Could not create table file %s: %v\n", p, err)
+	}
+	return &localUpload{f: f}, nil
+}
+
+func (t localTable) read(rel string) ([]byte, error) {
+	return os.ReadFile(t.path(rel))
+}
+
+func (t localTable) list(dir string) ([]string, error) {
+	entries, err := os.ReadDir(t.path(dir))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	var names []string
+	for _, e := range entries {
+		if !e.IsDir() {
+			names = append(names, e.Name())
+		}
+	}
+	return names, err
+}
+
+// claim creates the file exclusively, which is atomic on local and network
+// file systems.
+func (t localTable) claim(rel string, data []byte) error {
+	p := t.path(rel)
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(p, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(p)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(p)
+		return err
+	}
+	return nil
+}
+
+func (t localTable) remove(rel string) error { return os.Remove(t.path(rel)) }
+
+func (t localTable) location(rel string) string { return t.path(rel) }
+
+func (t localTable) Close() error { return nil }
+
+// localUpload writes a table file in place; Abort removes it.
+type localUpload struct {
+	f      *os.File
+	closed bool
+}
+
+func (u *localUpload) Write(p []byte) (int, error) { return u.f.Write(p) }
+
+func (u *localUpload) Close() error {
+	u.closed = true
+	return u.f.Close()
+}
+
+func (u *localUpload) Abort() {
+	if !u.closed {
+		u.f.Close()
+	}
+	os.Remove(u.f.Name())
+}
+
+// s3Table is a table under a prefix of an S3 bucket. Commits rely on
+// conditional writes, which S3 supports since 2024.
+type s3Table struct {
+	client *s3.Client
+	bucket string
+	prefix string
+}
+
+func (t *s3Table) create(rel string) (remoteUpload, error) {
+	return newS3Upload(context.Background(), t.client, t.bucket, objectKey(t.prefix, rel)), nil
+}
+
+func (t *s3Table) read(rel string) ([]byte, error) {
+	out, err := t.client.GetObject(context.Background(), &s3.GetObjectInput{Bucket: aws.String(t.bucket), Key: aws.String(objectKey(t.prefix, rel))})
+	var missing *types.NoSuchKey
+	if errors.As(err, &missing) {
+		return nil, fmt.Errorf("%s: %w", t.location(rel), os.ErrNotExist)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer out.Body.Close()
+	return io.ReadAll(out.Body)
+}
+
+func (t *s3Table) list(dir string) ([]string, error) {
+	prefix := objectKey(t.prefix, dir) + "/"
+	p := s3.NewListObjectsV2Paginator(t.client, &s3.ListObjectsV2Input{Bucket: aws.String(t.bucket), Prefix: aws.String(prefix), Delimiter: aws.String("/")})
+	var names []string
+	for p.HasMorePages() {
+		page, err := p.NextPage(context.Background())
+		if err != nil {
+			return nil, err
+		}
+		for _, o := range page.Contents {
+			names = append(names, strings.TrimPrefix(aws.ToString(o.Key), prefix))
+		}
+	}
+	return names, nil
+}
+
+func (t *s3Table) claim(rel string, data []byte) error {
+	_, err := t.client.PutObject(context.Background(), &s3.PutObjectInput{
+		Bucket:      aws.String(t.bucket),
+		Key:         aws.String(objectKey(t.prefix, rel)),
+		Body:        bytes.NewReader(data),
+		IfNoneMatch: aws.String("*"),
+	})
+	var api smithy.APIError
+	if errors.As(err, &api) && (api.ErrorCode() == "PreconditionFailed" || api.ErrorCode() == "ConditionalRequestConflict") {
+		return fmt.Errorf("%s: %w", t.location(rel), os.ErrExist)
+	}
+	return err
+}
+
+func (t *s3Table) remove(rel string) error {
+	_, err := t.client.DeleteObject(context.Background(), &s3.DeleteObjectInput{Bucket: aws.String(t.bucket), Key: aws.String(objectKey(t.prefix, rel))})
+	return err
+}
+
+func (t *s3Table) location(rel string) string {
+	return "s3://" + t.bucket + "/" + objectKey(t.prefix, rel)
+}
+
+func (t *s3Table) Close() error { return nil }
+
+// gcsTable is a table under a prefix of a GCS bucket, sharing one client.
+type gcsTable struct {
+	client *storage.Client
+	bucket string
+	prefix string
+}
+
+func (t *gcsTable) create(rel string) (remoteUpload, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	w := t.client.Bucket(t.bucket).Object(objectKey(t.prefix, rel)).NewWriter(ctx)
+	return &gcsUpload{uri: t.location(rel), w: w, cancel: cancel}, nil
+}
+
+func (t *gcsTable) read(rel string) ([]byte, error) {
+	r, err := t.client.Bucket(t.bucket).Object(objectKey(t.prefix, rel)).NewReader(context.Background())
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		return nil, fmt.Errorf("%s: %w", t.location(rel), os.ErrNotExist)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+func (t *gcsTable) list(dir string) ([]string, error) {
+	prefix := objectKey(t.prefix, dir) + "/"
+	it := t.client.Bucket(t.bucket).Objects(context.Background(), &storage.Query{Prefix: prefix, Delimiter: "/"})
+	var names []string
+	for {
+		attrs, err := it.Next()
+		if errors.Is(err, iterator.Done) {
+			return names, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if attrs.Name != "" {
+			names = append(names, strings.TrimPrefix(attrs.Name, prefix))
+		}
+	}
+}
+
+func (t *gcsTable) claim(rel string, data []byte) error {
+	w := t.client.Bucket(t.bucket).Object(objectKey(t.prefix, rel)).If(storage.Conditions{DoesNotExist: true}).NewWriter(context.Background())
+	if _, err := w.Write(data); err != nil {
+		w.Close()
+		return err
+	}
+	err := w.Close()
+	var api *googleapi.Error
+	if errors.As(err, &api) && api.Code == 412 {
+		return fmt.Errorf("%s: %w", t.location(rel), os.ErrExist)
+	}
+	return err
+}
+
+func (t *gcsTable) remove(rel string) error {
+	return t.client.Bucket(t.bucket).Object(objectKey(t.prefix, rel)).Delete(context.Background())
+}
+
+func (t *gcsTable) location(rel string) string {
+	return "gs://" + t.bucket + "/" + objectKey(t.prefix, rel)
+}
+
+func (t *gcsTable) Close() error { return t.client.Close() }
+
+// azureTable is a table under a prefix of a Blob Storage container. Engines
+// address its files through the account's abfss:// endpoint.
+type azureTable struct {
+	client    *azblob.Client
+	container string
+	prefix    string
+}
+
+func (t *azureTable) create(rel string) (remoteUpload, error) {
+	return newAzureUpload(t.client, t.container, objectKey(t.prefix, rel)), nil
+}
+
+func (t *azureTable) read(rel string) ([]byte, error) {
+	resp, err := t.client.DownloadStream(context.Background(), t.container, objectKey(t.prefix, rel), nil)
+	if bloberror.HasCode(err, bloberror.BlobNotFound) {
+		return nil, fmt.Errorf("%s: %w", t.location(rel), os.ErrNotExist)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return io.ReadAll(resp.Body)
+}
+
+func (t *azureTable) list(dir string) ([]string, error) {
+	prefix := objectKey(t.prefix, dir) + "/"
+	p := t.client.NewListBlobsFlatPager(t.container, &azblob.ListBlobsFlatOptions{Prefix: &prefix})
+	var names []string
+	for p.More() {
+		page, err := p.NextPage(context.Background())
+		if err != nil {
+			return nil, err
+		}
+		for _, b := range page.Segment.BlobItems {
+			// the flat listing includes deeper blobs
+			if name := strings.TrimPrefix(*b.Name, prefix); !strings.Contains(name, "/") {
+				names = append(names, name)
+			}
+		}
+	}
+	return names, nil
+}
+
+func (t *azureTable) claim(rel string, data []byte) error {
+	any := azcore.ETagAny
+	_, err := t.client.UploadBuffer(context.Background(), t.container, objectKey(t.prefix, rel), data, &azblob.UploadBufferOptions{
+		AccessConditions: &blob.AccessConditions{ModifiedAccessConditions: &blob.ModifiedAccessConditions{IfNoneMatch: &any}},
+	})
+	if bloberror.HasCode(err, bloberror.BlobAlreadyExists, bloberror.ConditionNotMet) {
+		return fmt.Errorf("%s: %w", t.location(rel), os.ErrExist)
+	}
+	return err
+}
+
+func (t *azureTable) remove(rel string) error {
+	_, err := t.client.DeleteBlob(context.Background(), t.container, objectKey(t.prefix, rel), nil)
+	return err
+}
+
+func (t *azureTable) location(rel string) string {
+	account := ""
+	if u, err := url.Parse(t.client.URL()); err == nil {
+		account, _, _ = strings.Cut(u.Host, ".")
+	}
+	return fmt.Sprintf("abfss://%s@%s.dfs.core.windows.net/%s", t.container, account, objectKey(t.prefix, rel))
+}
+
+func (t *azureTable) Close() error { return nil }
@@ -0,0 +1,189 @@
+package extract
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// memTable is a table in an object store kept in memory. onClaim runs
+// before each claim, so a test can have another writer get there first.
+type memTable struct {
+	mu      sync.Mutex
+	root    string
+	files   map[string][]byte
+	onClaim func(rel string)
+}
+
+func newMemTable(root string) *memTable {
+	return &memTable{root: root, files: map[string][]byte{}}
+}
+
+// memUpload only stores the object once it is closed, as object stores do.
+type memUpload struct {
+	bytes.Buffer
+	t   *memTable
+	rel string
+}
+
+func (u *memUpload) Close() error {
+	u.t.mu.Lock()
+	defer u.t.mu.Unlock()
+	u.t.files[u.rel] = u.Bytes()
+	return nil
+}
+
+func (u *memUpload) Abort() {}
+
+func (t *memTable) create(rel string) (remoteUpload, error) {
+	return &memUpload{t: t, rel: rel}, nil
+}
+
+func (t *memTable) read(rel string) ([]byte, error) {
+	// manifest lists are read by their full location
+	rel = strings.TrimPrefix(rel, t.root+"/")
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	data, ok := t.files[rel]
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", rel, os.ErrNotExist)
+	}
+	return data, nil
+}
+
+func (t *memTable) list(dir string) ([]string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var names []string
+	for rel := range t.files {
+		if path.Dir(rel) == dir {
+			names = append(names, path.Base(rel))
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (t *memTable) claim(rel string, data []byte) error {
+	if t.onClaim != nil {
+		hook := t.onClaim
+		t.onClaim = nil
+		hook(rel)
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.files[rel]; ok {
+		return fmt.Errorf("%s: %w", rel, os.ErrExist)
+	}
+	t.files[rel] = data
+	return nil
+}
+
+func (t *memTable) remove(rel string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.files, rel)
+	return nil
+}
+
+func (t *memTable) location(rel string) string { return t.root + "/" + rel }
+
+func (t *memTable) Close() error { return nil }
+
+func (t *memTable) count(suffix string) int {
+	n := 0
+	for rel := range t.files {
+		if strings.HasSuffix(rel, suffix) {
+			n++
+		}
+	}
+	return n
+}
+
+func TestTableStoreCommitRace(t *testing.T) {
+	cols := []column{{Name: "id", DBType: "BIGINT"}, {Name: "region", DBType: "NVARCHAR"}}
+	rows := [][]any{{int64(1), "EU"}, {int64(2), "US"}}
+
+	t.Run("delta", func(t *testing.T) {
+		store := newMemTable("s3://lake/orders")
+		write := func() error {
+			d := newDeltaTableWriter("s3://lake/orders", store, deltaOptions{PartitionBy: []string{"region"}})
+			if err := d.WriteHeader(cols); err != nil {
+				return err
+			}
+			for _, row := range rows {
+				d.WriteRow(row)
+			}
+			return d.Close()
+		}
+		if err := write(); err != nil {
+			t.Fatal(err)
+		}
+		// another writer commits version 1 while this one is committing
+		store.onClaim = func(string) {
+			if err := write(); err != nil {
+				t.Error(err)
+			}
+		}
+		if err := write(); err != nil {
+			t.Fatal(err)
+		}
+		if v, _, err := readDeltaLog(store); v != 2 || err != nil {
+			t.Errorf("latest version %d, %v, want 2", v, err)
+		}
+		if n := store.count(".parquet"); n != 6 {
+			t.Errorf("%d data files, want 6", n)
+		}
+	})
+
+	t.Run("iceberg", func(t *testing.T) {
+		store := newMemTable("s3://lake/events")
+		write := func() error {
+			return writeIceberg(t, newIcebergTableWriter("s3://lake/events", store, icebergOptions{}), cols, rows)
+		}
+		if err := write(); err != nil {
+			t.Fatal(err)
+		}
+		store.onClaim = func(string) {
+			if err := write(); err != nil {
+				t.Error(err)
+			}
+		}
+		if err := write(); err != nil {
+			t.Fatal(err)
+		}
+		meta, err := readIcebergMetadata(store)
+		if err != nil || meta.version != 3 || meta.Location != "s3://lake/events" {
+			t.Fatalf("metadata %+v, %v", meta, err)
+		}
+		// the losing attempt's manifest list is gone, and the last snapshot
+		// lists every manifest by its location in the store
+		if n := store.count(".avro"); n != 3+3 {
+			t.Errorf("%d manifests and lists, want 6", n)
+		}
+		data, _ := store.read(meta.Snapshots[2].ManifestList)
+		list, err := readAvroFile(data)
+		if err != nil || len(list.records) != 3 {
+			t.Fatalf("manifest list %+v, %v", list, err)
+		}
+		for _, r := range list.records {
+			if p := manifestFileOf(r.(map[string]any)).path; !strings.HasPrefix(p, "s3://lake/events/metadata/") {
+				t.Errorf("manifest path %s", p)
+			}
+		}
+	})
+}
+
+func TestOpenTableStore(t *testing.T) {
+	if _, err := openTableStore("ftp://lake/orders"); err == nil {
+		t.Error("opened a table in an unsupported store")
+	}
+	if _, err := openTableStore("az:///orders"); err == nil {
+		t.Error("opened an Azure table without a container")
+	}
+}
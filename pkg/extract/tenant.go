@@ -0,0 +1,258 @@
+package extract
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// tenantOptions isolates a group of jobs: they connect with the tenant's own
+// credentials, may only write under its root, run at most MaxConcurrent at
+// once and are reported to its notification targets.
+type tenantOptions struct {
+	// Server and Database default to the top-level settings.
+	Server   string `yaml:"server"`
+	Database string `yaml:"database"`
+	// User and the password read from the PasswordEnv environment variable
+	// replace the top-level login.
+	User        string   `yaml:"user"`
+	PasswordEnv string   `yaml:"password_env"`
+	Root        string   `yaml:"root"`
+	Concurrency int      `yaml:"max_concurrent"`
+	Notify      []string `yaml:"notify"`
+	// Locale is the language of the notification summary, report_locale
+	// unless set.
+	Locale string `yaml:"locale"`
+}
+
+// login returns the tenant's own user, logging in as SQL Server would take
+// it: a DOMAIN\user over NTLM, any other user as a SQL login. Without one
+// the tenant logs in as the top-level login.
+func (t tenantOptions) login(c *config) authOptions {
+	if t.User == "" {
+		return c.login()
+	}
+	a := authOptions{User: t.User, PasswordEnv: t.PasswordEnv}
+	if strings.Contains(t.User, `\`) {
+		a.Method = authWindows
+	}
+	return a
+}
+
+// serverFor returns the server and database the jobs of tenant read.
+func (c *config) serverFor(tenant string) (string, string) {
+	server, database := c.Server, c.Database
+	if t, ok := c.Tenants[tenant]; ok {
+		if t.Server != "" {
+			server = t.Server
+		}
+		if t.Database != "" {
+			database = t.Database
+		}
+	}
+	return server, database
+}
+
+// validateTenants checks the tenants and that every job assigned to one
+// writes under its root.
+func validateTenants(c *config) error {
+	for name, t := range c.Tenants {
+		if t.Concurrency < 0 || t.Concurrency > c.concurrency() {
+			return fmt.Errorf("Tenant %s max_concurrent must be between 0 and %d, got %d\n", name, c.concurrency(), t.Concurrency)
+		}
+		if t.PasswordEnv != "" && t.User == "" {
+			return fmt.Errorf("Tenant %s sets password_env without a user\n", name)
+		}
+		if d, err := c.sourceDriver(); err == nil && t.User != "" {
+			if err := t.login(c).validate("tenant "+name, d); err != nil {
+				return err
+			}
+		}
+		if t.Locale != "" {
+			if _, err := parseLocale(t.Locale); err != nil {
+				return err
+			}
+		}
+		for _, target := range t.Notify {
+			u, err := url.Parse(target)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+				return fmt.Errorf("Tenant %s notify target '%s' is not an http(s) URL\n", name, target)
+			}
+		}
+	}
+	for outFile, name := range c.JobTenants {
+		if _, ok := c.Tenants[name]; !ok {
+			return fmt.Errorf("Job %s is assigned to unknown tenant '%s'\n", outFile, name)
+		}
+		if err := c.checkTenantRoot(outFile, outFile); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkTenantRoot fails when path, the file the job writing outFile writes,
+// is outside the root of the job's tenant.
+func (c *config) checkTenantRoot(outFile, path string) error {
+	name, ok := c.JobTenants[outFile]
+	if !ok {
+		return nil
+	}
+	if t := c.Tenants[name]; t.Root != "" && !underRoot(t.Root, path) {
+		return fmt.Errorf("Job %s is outside the root %s of tenant %s\n", path, t.Root, name)
+	}
+	return nil
+}
+
+// underRoot reports whether outFile lies under root. URL-style outfiles are
+// cleaned and compared by prefix ending at a separator: / for remote files,
+// / or . for tables such as snowflake://db.schema.table.
+func underRoot(root, outFile string) bool {
+	if strings.Contains(root, "://") || strings.Contains(outFile, "://") {
+		root, okRoot := cleanURL(root)
+		outFile, okOut := cleanURL(outFile)
+		if !okRoot || !okOut {
+			return false
+		}
+		rest, ok := strings.CutPrefix(outFile, root)
+		if !ok {
+			return false
+		}
+		seps := "/."
+		if isRemoteFile(outFile) {
+			seps = "/"
+		}
+		return rest == "" || strings.ContainsAny(root[len(root)-1:], seps) || strings.ContainsAny(rest[:1], seps)
+	}
+	absRoot, err := filepath.Abs(root)
+	if err != nil {
+		return false
+	}
+	abs, err := filepath.Abs(outFile)
+	if err != nil {
+		return false
+	}
+	rel, err := filepath.Rel(absRoot, abs)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// cleanURL resolves the . and .. segments of the path of a URL-style outfile,
+// failing when a .. climbs above its first segment, the host or database.
+func cleanURL(u string) (string, bool) {
+	scheme, rest, ok := strings.Cut(u, "://")
+	if !ok || rest == "" {
+		return u, ok
+	}
+	cleaned := path.Clean(rest)
+	if cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return "", false
+	}
+	// a root such as s3://exports/acme/ keeps the separator it ends in
+	if strings.HasSuffix(rest, "/") {
+		cleaned += "/"
+	}
+	return scheme + "://" + cleaned, true
+}
+
+// connect opens the tenant's own connection to the source. Without a user of
+// its own the tenant logs in as the top-level user.
+func (t tenantOptions) connect(c *config) (*sql.DB, error) {
+	d, err := c.sourceDriver()
+	if err != nil {
+		return nil, err
+	}
+	server, database := t.Server, t.Database
+	if server == "" {
+		server = c.Server
+	}
+	if database == "" {
+		database = c.Database
+	}
+	return d.open(server, database, t.login(c))
+}
+
+// tenantJob is one job in a tenant notification.
+type tenantJob struct {
+	OutFile  string `json:"outfile"`
+	Status   string `json:"status"`
+	TimedOut bool   `json:"timed_out,omitempty"`
+	Rows     uint   `json:"rows"`
+}
+
+// tenantNotice is posted as JSON to a tenant's notification targets at the
+// end of a run.
+type tenantNotice struct {
+	Tenant   string    `json:"tenant"`
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished"`
+	// Summary describes the run in the tenant's locale.
+	Summary string      `json:"summary"`
+	Jobs    []tenantJob `json:"jobs"`
+}
+
+// notifyTenants posts the outcome of its jobs to each tenant's targets.
+// Failed notifications are logged and do not fail the run.
+func notifyTenants(c *config, control *controller, r *runReport) {
+	finished := time.Now()
+	for name, t := range c.Tenants {
+		if len(t.Notify) == 0 {
+			continue
+		}
+		notice := tenantNotice{Tenant: name, Started: c.started, Finished: finished}
+		notice.Jobs = jobOutcomes(c, control, r, func(outFile string) bool { return c.JobTenants[outFile] == name })
+		notice.Summary = t.summary(c, notice)
+		body, err := json.Marshal(notice)
+		if err != nil {
+			log.Printf("Warning: could not encode the notification for tenant %s: %v\n", name, err)
+			continue
+		}
+		for _, target := range t.Notify {
+			if err := postNotice(target, body); err != nil {
+				log.Printf("Warning: could not notify tenant %s at %s: %v\n", name, target, err)
+			}
+		}
+	}
+}
+
+// summary describes the run of notice in the tenant's locale.
+func (t tenantOptions) summary(c *config, notice tenantNotice) string {
+	locale := t.Locale
+	if locale == "" {
+		locale = c.ReportLocale
+	}
+	p := newReportPrinter(locale)
+	var done, failed int
+	var rows uint
+	for _, j := range notice.Jobs {
+		switch j.Status {
+		case jobDone:
+			done++
+		case jobFailed:
+			failed++
+		}
+		rows += j.Rows
+	}
+	return p.Sprintf(msgTenantRun, notice.Tenant, p.time(notice.Started), p.time(notice.Finished), done, len(notice.Jobs), failed, rows)
+}
+
+var noticeClient = &http.Client{Timeout: 10 * time.Second}
+
+func postNotice(target string, body []byte) error {
+	resp, err := noticeClient.Post(target, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("status %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package extract
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestUnderRoot(t *testing.T) {
+	tests := []struct {
+		root, outFile string
+		want          bool
+	}{
+		{"/exports/finance", "/exports/finance/gl.csv", true},
+		{"/exports/finance", "/exports/finance/2024/gl.csv", true},
+		{"/exports/finance", "/exports/finance-old/gl.csv", false},
+		{"/exports/finance", "/exports/finance/../hr/pay.csv", false},
+		{"snowflake://FINANCE.", "snowflake://FINANCE.PUBLIC.GL", true},
+		{"snowflake://FINANCE.", "snowflake://HR.PUBLIC.PAY", false},
+		{"snowflake://DB.SCHEMA", "snowflake://DB.SCHEMA.T", true},
+		{"snowflake://DB.SCHEMA", "snowflake://DB.SCHEMA2.T", false},
+		{"s3://exports/acme", "s3://exports/acme/gl.csv", true},
+		{"s3://exports/acme/", "s3://exports/acme/gl.csv", true},
+		{"s3://exports/acme", "s3://exports/acme-other/gl.csv", false},
+		{"s3://exports/acme", "s3://exports/acme.csv", false},
+		{"sftp://host/tenantA", "sftp://host/tenantA/../tenantB/x.csv", false},
+		{"s3://exports/acme", "s3://exports/acme/./2024/../gl.csv", true},
+		{"gs://exports/acme", "gs://exports/acme/../../other/acme/x.csv", false},
+		{"az://exports/acme", "az://exports/../../exports/acme/x.csv", false},
+		{"/exports/finance", "bigquery://proj.ds.gl", false},
+	}
+	for _, tt := range tests {
+		if got := underRoot(tt.root, tt.outFile); got != tt.want {
+			t.Errorf("underRoot(%q, %q) = %v, want %v", tt.root, tt.outFile, got, tt.want)
+		}
+	}
+}
+
+func TestValidateTenants(t *testing.T) {
+	c := &config{
+		Tenants:    map[string]tenantOptions{"finance": {Root: "/exports/finance"}},
+		JobTenants: map[string]string{"/exports/hr/pay.csv": "finance"},
+	}
+	if err := validateTenants(c); err == nil || !strings.Contains(err.Error(), "outside the root") {
+		t.Errorf("got %v, want an error for a job outside the root", err)
+	}
+	c.JobTenants = map[string]string{"/exports/finance/gl.csv": "hr"}
+	if err := validateTenants(c); err == nil || !strings.Contains(err.Error(), "unknown tenant") {
+		t.Errorf("got %v, want an error for an unknown tenant", err)
+	}
+}
@@ -0,0 +1,101 @@
+package extract
+
+import (
+	"fmt"
+	"time"
+	// embedded so zone names resolve on hosts without a zoneinfo database
+	_ "time/tzdata"
+)
+
+// timezoneOptions normalizes the timestamps of a job.
+type timezoneOptions struct {
+	// Zone converts every timestamp to UTC or an IANA zone such as
+	// Europe/Stockholm; empty passes the instants through unchanged.
+	Zone string `yaml:"zone"`
+	// Source is the zone naive DATETIME/DATETIME2 values were recorded in.
+	// Without it their wall clock is kept as is.
+	Source string `yaml:"source"`
+}
+
+// validate checks that the zones exist.
+func (o timezoneOptions) validate() error {
+	for _, z := range []string{o.Zone, o.Source} {
+		if _, err := time.LoadLocation(z); z != "" && err != nil {
+			return fmt.Errorf("Unsupported time zone '%s': %v\n", z, err)
+		}
+	}
+	return nil
+}
+
+// offsetAware reports whether the column's values carry their own offset.
+func offsetAware(c column) bool {
+	switch c.DBType {
+	case "DATETIMEOFFSET", "TIMESTAMPTZ":
+		return true
+	}
+	return false
+}
+
+// timestamp handling per column
+const (
+	tzUntouched = iota
+	tzAware
+	tzNaive
+)
+
+// timezoneTransform converts timestamp columns according to a timezoneOptions.
+type timezoneTransform struct {
+	zone   *time.Location
+	source *time.Location
+	mode   []int
+}
+
+func newTimezoneTransform(o timezoneOptions) *timezoneTransform {
+	t := &timezoneTransform{}
+	if o.Zone != "" {
+		t.zone, _ = time.LoadLocation(o.Zone)
+	}
+	if o.Source != "" {
+		t.source, _ = time.LoadLocation(o.Source)
+	}
+	return t
+}
+
+func (t *timezoneTransform) Setup(cols []column) ([]column, error) {
+	t.mode = make([]int, len(cols))
+	for i, c := range cols {
+		if c.kind() != kindTimestamp {
+			continue
+		}
+		if offsetAware(c) {
+			t.mode[i] = tzAware
+		} else {
+			t.mode[i] = tzNaive
+		}
+	}
+	return cols, nil
+}
+
+func (t *timezoneTransform) Apply(row []any) ([]any, bool, error) {
+	for i, m := range t.mode {
+		if m == tzUntouched || row[i] == nil {
+			continue
+		}
+		v, err := asTime(row[i])
+		if err != nil {
+			return nil, false, err
+		}
+		if m == tzNaive {
+			// naive values come back from the driver labelled UTC
+			if t.source == nil {
+				continue
+			}
+			v = time.Date(v.Year(), v.Month(), v.Day(), v.Hour(), v.Minute(), v.Second(), v.Nanosecond(), t.source)
+		}
+		if t.zone != nil {
+			v = v.In(t.zone)
+		}
+		row[i] = v
+	}
+	return row, true, nil
+}
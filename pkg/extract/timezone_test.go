@@ -0,0 +1,72 @@
+package extract
+
+import (
+	"database/sql/driver"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// eventsResult returns a row of (at DATETIME2, at_offset DATETIMEOFFSET,
+// day DATE) as the driver returns them, naive values labelled UTC, and a
+// row of NULLs.
+func eventsResult() *fakeResult {
+	plusTwo := time.FixedZone("", 2*3600)
+	row := []driver.Value{
+		time.Date(2026, 10, 15, 9, 30, 0, 0, time.UTC),
+		time.Date(2026, 10, 15, 9, 30, 0, 0, plusTwo),
+		time.Date(2026, 10, 15, 0, 0, 0, 0, time.UTC),
+	}
+	timeType := reflect.TypeOf(time.Time{})
+	return &fakeResult{
+		columns: []fakeColumn{
+			{name: "at", dbType: "DATETIME2", scanType: timeType},
+			{name: "at_offset", dbType: "DATETIMEOFFSET", scanType: timeType},
+			{name: "day", dbType: "DATE", scanType: timeType, nullable: true},
+		},
+		rows: 2,
+		value: func(r, col int) driver.Value {
+			if r == 1 {
+				return nil
+			}
+			return row[col]
+		},
+	}
+}
+
+func TestExportDataTimezone(t *testing.T) {
+	registerFake("events", &fakeQuery{sets: []*fakeResult{eventsResult()}})
+	for _, tc := range []struct {
+		opts timezoneOptions
+		want string
+	}{
+		// passed through, naive values keep their wall clock
+		{timezoneOptions{}, "2026-10-15T09:30:00Z,2026-10-15T09:30:00+02:00,2026-10-15T00:00:00Z"},
+		{timezoneOptions{Zone: "UTC"}, "2026-10-15T09:30:00Z,2026-10-15T07:30:00Z,2026-10-15T00:00:00Z"},
+		// naive values are read in the source zone before converting
+		{timezoneOptions{Zone: "UTC", Source: "America/New_York"}, "2026-10-15T13:30:00Z,2026-10-15T07:30:00Z,2026-10-15T00:00:00Z"},
+		{timezoneOptions{Zone: "Asia/Tokyo", Source: "Europe/Stockholm"}, "2026-10-15T16:30:00+09:00,2026-10-15T16:30:00+09:00,2026-10-15T00:00:00Z"},
+		// only a source labels naive values without converting the others
+		{timezoneOptions{Source: "Europe/Stockholm"}, "2026-10-15T09:30:00+02:00,2026-10-15T09:30:00+02:00,2026-10-15T00:00:00Z"},
+	} {
+		if err := tc.opts.validate(); err != nil {
+			t.Fatal(err)
+		}
+		outFile := filepath.Join(t.TempDir(), "out.csv")
+		c := &config{Timezones: map[string]timezoneOptions{outFile: tc.opts}}
+		got, err := exportFake(t, c, "events", outFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := "at,at_offset,day\n" + tc.want + "\n,,\n"; got != want {
+			t.Errorf("%+v: got %q, want %q", tc.opts, got, want)
+		}
+	}
+
+	for _, o := range []timezoneOptions{{Zone: "Mars/Olympus"}, {Source: "CEST+2"}} {
+		if err := o.validate(); err == nil {
+			t.Errorf("%+v is valid", o)
+		}
+	}
+}
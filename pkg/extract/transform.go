@@ -0,0 +1,104 @@
+package extract
+
+import (
+	"regexp"
+	"strings"
+)
+
+// transform is one stage between Scan and Write. Setup receives the incoming
+// columns and returns the outgoing ones; Apply rewrites one row and reports
+// whether it is kept.
+type transform interface {
+	Setup(cols []column) ([]column, error)
+	Apply(row []any) ([]any, bool, error)
+}
+
+// finisher is a stage that keeps state across rows. Finish returns rows to
+// write after the last one of the result, Commit persists the state once the
+// output is closed, and Discard drops it when the job fails; Discard after
+// Commit does nothing.
+type finisher interface {
+	Finish() ([][]any, error)
+	Commit() error
+	Discard()
+}
+
+// jobTransforms returns the stages configured for the job writing outFile.
+func jobTransforms(c *config, outFile string) []transform {
+	var ts []transform
+	if f, ok := c.Filters[outFile]; ok {
+		ts = append(ts, &filterTransform{src: f})
+	}
+	if k, ok := c.RowKeys[outFile]; ok {
+		ts = append(ts, newRowKeyTransform(k))
+	}
+	if lc := c.Literals[outFile]; len(lc) > 0 {
+		ts = append(ts, &literalTransform{columns: lc})
+	}
+	if cc := c.Computed[outFile]; len(cc) > 0 {
+		ts = append(ts, &computedTransform{columns: cc, vars: runVariables(c, outFile)})
+	}
+	if tz, ok := c.Timezones[outFile]; ok {
+		ts = append(ts, newTimezoneTransform(tz))
+	}
+	if t := c.Classification.transform(outFile); t != nil {
+		ts = append(ts, t)
+	}
+	// changes compares the final rows, so it has to be the last stage
+	if o, ok := c.Changes[outFile]; ok {
+		ts = append(ts, newChangeTransform(o, c.partial(), c.keys))
+	}
+	return ts
+}
+
+// setupTransforms runs Setup through the chain, returning the output columns.
+func setupTransforms(ts []transform, cols []column) ([]column, error) {
+	var err error
+	for _, t := range ts {
+		if cols, err = t.Setup(cols); err != nil {
+			return nil, err
+		}
+	}
+	return cols, nil
+}
+
+// applyTransforms passes a row through the chain; a dropped row stops it.
+func applyTransforms(ts []transform, row []any) ([]any, bool, error) {
+	for _, t := range ts {
+		var keep bool
+		var err error
+		if row, keep, err = t.Apply(row); err != nil || !keep {
+			return nil, false, err
+		}
+	}
+	return row, true, nil
+}
+
+// globMatch reports whether s matches pattern, where * matches any run of
+// characters (including path separators) and ? matches one character.
+func globMatch(pattern, s string) bool {
+	var b strings.Builder
+	b.WriteString("^")
+	for _, r := range pattern {
+		switch r {
+		case '*':
+			b.WriteString(".*")
+		case '?':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	b.WriteString("$")
+	ok, _ := regexp.MatchString(b.String(), s)
+	return ok
+}
+
+// discardStages drops the state of the finishers that were not committed.
+func discardStages(ts []transform) {
+	for _, t := range ts {
+		if f, ok := t.(finisher); ok {
+			f.Discard()
+		}
+	}
+}
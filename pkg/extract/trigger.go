@@ -0,0 +1,156 @@
+package extract
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// defaultTriggerPoll is how often -watch looks for trigger files unless
+// triggers.poll is set.
+const defaultTriggerPoll = 10 * time.Second
+
+// triggerOptions makes -watch start a flow or job when an upstream system
+// drops a trigger file into Dir. Files maps glob patterns of file names to
+// the flow or job they start; a file matching none starts the flow or job
+// named by the file name without its extension, such as orders.ready.
+type triggerOptions struct {
+	Dir   string            `yaml:"dir"`
+	Poll  configDuration    `yaml:"poll"`
+	Files map[string]string `yaml:"files"`
+}
+
+func (o triggerOptions) validate(c *config) error {
+	if o.Dir == "" {
+		if len(o.Files) > 0 || o.Poll != 0 {
+			return fmt.Errorf("triggers needs a dir to watch\n")
+		}
+		return nil
+	}
+	if o.Poll < 0 {
+		return fmt.Errorf("triggers poll cannot be negative\n")
+	}
+	for pattern, target := range o.Files {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return fmt.Errorf("Invalid trigger file pattern '%s': %v\n", pattern, err)
+		}
+		if !c.isTriggerTarget(target) {
+			return fmt.Errorf("Trigger file pattern '%s' starts '%s', which is neither a flow nor a job\n", pattern, target)
+		}
+	}
+	return nil
+}
+
+func (c *config) isTriggerTarget(name string) bool {
+	if _, ok := c.Flows[name]; ok {
+		return true
+	}
+	_, err := c.jobsNamed([]string{name})
+	return err == nil
+}
+
+// triggerTarget returns the flow or job the trigger file name starts, trying
+// the patterns in order.
+func (c *config) triggerTarget(name string) (string, bool) {
+	patterns := make([]string, 0, len(c.Triggers.Files))
+	for pattern := range c.Triggers.Files {
+		patterns = append(patterns, pattern)
+	}
+	sort.Strings(patterns)
+	for _, pattern := range patterns {
+		if ok, _ := filepath.Match(pattern, name); ok {
+			return c.Triggers.Files[pattern], true
+		}
+	}
+	target := strings.TrimSuffix(name, filepath.Ext(name))
+	return target, c.isTriggerTarget(target)
+}
+
+// pendingTriggers returns the trigger files in the drop directory, by name,
+// with the flow or job each starts. Hidden files and files that name nothing
+// are left alone.
+func (c *config) pendingTriggers() ([]string, []string, error) {
+	entries, err := os.ReadDir(c.Triggers.Dir)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Could not read the trigger directory: %v\n", err)
+	}
+	var files, targets []string
+	for _, e := range entries {
+		if e.IsDir() || strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+		if target, ok := c.triggerTarget(e.Name()); ok {
+			files = append(files, filepath.Join(c.Triggers.Dir, e.Name()))
+			targets = append(targets, target)
+		}
+	}
+	return files, targets, nil
+}
+
+// watchTriggers starts the flow or job of every trigger file appearing in
+// the drop directory of c until the admin drain command or ctx ends. Each
+// file is removed as its run starts, so that dropping it again starts
+// another, and each run loads the configuration afresh with loadFlow.
+func watchTriggers(ctx context.Context, c *config, control *controller, loadFlow func(flow string) (*config, error)) {
+	poll := time.Duration(c.Triggers.Poll)
+	if poll == 0 {
+		poll = defaultTriggerPoll
+	}
+	log.Printf("Watching %s for trigger files\n", c.Triggers.Dir)
+	for ctx.Err() == nil && control.hold() {
+		files, targets, err := c.pendingTriggers()
+		if err != nil {
+			log.Printf("Warning: %v", err)
+		}
+		for i, file := range files {
+			if ctx.Err() != nil || !control.hold() || !c.awaitMaintenance(control, targets[i]) {
+				break
+			}
+			if err := os.Remove(file); err != nil {
+				// another watcher took it
+				if !os.IsNotExist(err) {
+					log.Printf("Warning: could not take the trigger file %s: %v\n", file, err)
+				}
+				continue
+			}
+			log.Printf("Trigger file %s starts %s\n", filepath.Base(file), targets[i])
+			if err := runTrigger(ctx, control, loadFlow, targets[i], nil); err != nil {
+				log.Printf("Warning: run of %s failed: %v\n", targets[i], strings.TrimSpace(err.Error()))
+			}
+		}
+		if !control.sleepUntil(time.Now().Add(poll)) {
+			break
+		}
+	}
+	log.Println("Stopped watching for trigger files")
+}
+
+// runTrigger runs the flow named target, and the flows following it, or
+// else the job named target, with params set as by -param.
+func runTrigger(ctx context.Context, control *controller, loadFlow func(flow string) (*config, error), target string, params map[string]string) error {
+	c, err := loadFlow("")
+	if err != nil {
+		return err
+	}
+	if _, ok := c.Flows[target]; ok {
+		if c, err = loadFlow(target); err != nil {
+			return err
+		}
+	} else {
+		outFiles, err := c.jobsNamed([]string{target})
+		if err != nil {
+			return err
+		}
+		c.keepJobs(outFiles)
+	}
+	if err := c.setParams(params); err != nil {
+		return err
+	}
+	control.begin(c.OutFiles)
+	return runChain(ctx, c, control, loadFlow, runExtraction)
+}
@@ -0,0 +1,53 @@
+package extract
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestPendingTriggers(t *testing.T) {
+	dir := t.TempDir()
+	data := `extracts:
+  - {name: orders, query: SELECT 1, outfile: orders.csv}
+  - {name: gl, query: SELECT 2, outfile: gl.csv}
+flows:
+  month-end: {jobs: [gl]}
+triggers:
+  dir: ` + dir + `
+  files:
+    "GL_*.FLG": month-end
+`
+	c, _, err := parseConfigDocs([]configDoc{{"config.yaml", []byte(data)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.validate(); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"GL_202503.FLG", "orders.ready", "month-end.go", "unknown.ready", ".orders.ready"} {
+		os.WriteFile(filepath.Join(dir, name), nil, 0o644)
+	}
+	os.Mkdir(filepath.Join(dir, "gl"), 0o755)
+	files, targets, err := c.pendingTriggers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantFiles := []string{filepath.Join(dir, "GL_202503.FLG"), filepath.Join(dir, "month-end.go"), filepath.Join(dir, "orders.ready")}
+	if !reflect.DeepEqual(files, wantFiles) || !reflect.DeepEqual(targets, []string{"month-end", "month-end", "orders"}) {
+		t.Errorf("got %v starting %v", files, targets)
+	}
+
+	for _, bad := range []triggerOptions{
+		{Files: map[string]string{"*.flg": "orders"}},
+		{Dir: dir, Files: map[string]string{"[": "orders"}},
+		{Dir: dir, Files: map[string]string{"*.flg": "invoices"}},
+		{Dir: dir, Poll: -1},
+	} {
+		c.Triggers = bad
+		if err := c.validate(); err == nil {
+			t.Errorf("accepted triggers %+v", bad)
+		}
+	}
+}
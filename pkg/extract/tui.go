@@ -0,0 +1,220 @@
+package extract
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"golang.org/x/term"
+)
+
+// dashboardLines is how many log lines the dashboard keeps, and
+// dashboardLogLines how many of a job's it shows.
+const (
+	dashboardLines    = 10000
+	dashboardLogLines = 15
+)
+
+// logLines keeps the log output while the dashboard holds the terminal.
+type logLines struct {
+	mu      sync.Mutex
+	lines   []string
+	partial string
+	dropped int
+}
+
+func (l *logLines) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	text := l.partial + string(p)
+	parts := strings.Split(text, "\n")
+	l.partial = parts[len(parts)-1]
+	l.lines = append(l.lines, parts[:len(parts)-1]...)
+	if over := len(l.lines) - dashboardLines; over > 0 {
+		l.lines = l.lines[over:]
+		l.dropped += over
+	}
+	return len(p), nil
+}
+
+// mentioning returns the last n lines containing name.
+func (l *logLines) mentioning(n int, name string) []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var found []string
+	for i := len(l.lines) - 1; i >= 0 && len(found) < n; i-- {
+		if strings.Contains(l.lines[i], name) {
+			found = append(found, l.lines[i])
+		}
+	}
+	slices.Reverse(found)
+	return found
+}
+
+// flush writes the kept lines to w.
+func (l *logLines) flush(w io.Writer) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.dropped > 0 {
+		fmt.Fprintf(w, "(%d earlier log lines were dropped)\n", l.dropped)
+	}
+	for _, line := range l.lines {
+		fmt.Fprintln(w, line)
+	}
+	if l.partial != "" {
+		fmt.Fprintln(w, l.partial)
+	}
+	l.lines, l.partial, l.dropped = nil, "", 0
+}
+
+// dashboard is the terminal UI of -tui. It lists every job of the run with
+// its progress, and cancels a job or shows its log lines on a key press.
+type dashboard struct {
+	params  *config
+	control *controller
+	logs    *logLines
+	// expected holds the rows of each job's last successful run, for the ETA.
+	expected map[string]uint
+
+	selected int
+	viewLog  bool
+	notice   string
+	done     bool
+	err      error
+}
+
+type dashboardTick time.Time
+
+// dashboardDone ends the dashboard with the run's error.
+type dashboardDone struct{ err error }
+
+func dashboardTicker() tea.Cmd {
+	return tea.Tick(250*time.Millisecond, func(t time.Time) tea.Msg { return dashboardTick(t) })
+}
+
+// runDashboard runs the extraction behind the dashboard. Log output is held
+// while it runs and written to stderr once it is over.
+func runDashboard(ctx context.Context, params *config, control *controller) error {
+	if !term.IsTerminal(int(os.Stdout.Fd())) {
+		return fmt.Errorf("-tui needs a terminal\n")
+	}
+	d := &dashboard{params: params, control: control, logs: &logLines{}, expected: params.expectedRows()}
+	out := log.Writer()
+	log.SetOutput(d.logs)
+	defer func() {
+		log.SetOutput(out)
+		d.logs.flush(out)
+	}()
+
+	p := tea.NewProgram(d)
+	go func() {
+		p.Send(dashboardDone{runExtraction(ctx, params, control)})
+	}()
+	if _, err := p.Run(); err != nil {
+		return err
+	}
+	return d.err
+}
+
+func (d *dashboard) Init() tea.Cmd { return dashboardTicker() }
+
+func (d *dashboard) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case dashboardTick:
+		return d, dashboardTicker()
+	case dashboardDone:
+		d.done, d.err = true, msg.err
+		d.viewLog = false
+		return d, tea.Quit
+	case tea.KeyMsg:
+		if len(d.params.OutFiles) == 0 {
+			return d, nil
+		}
+		outFile := d.params.OutFiles[d.selected]
+		switch msg.String() {
+		case "up", "k":
+			d.selected = max(d.selected-1, 0)
+		case "down", "j":
+			d.selected = min(d.selected+1, len(d.params.OutFiles)-1)
+		case "enter", "l":
+			d.viewLog = !d.viewLog
+		case "esc":
+			d.viewLog = false
+		case "c":
+			d.notice = d.control.cancel(outFile)
+		case "p":
+			if d.control.runState() == statePaused {
+				d.notice = d.control.command("resume")
+			} else {
+				d.notice = d.control.command("pause")
+			}
+		case "q", "ctrl+c":
+			d.notice = d.control.command("drain")
+		}
+	}
+	return d, nil
+}
+
+func (d *dashboard) View() string {
+	var b strings.Builder
+	counts := map[string]int{}
+	states := d.control.states()
+	for _, s := range states {
+		counts[s]++
+	}
+	fmt.Fprintf(&b, "%s on %s: %s, %d/%d done, %d running, %d failed\n\n", d.params.Database, d.params.Server,
+		d.control.runState(), counts[jobDone], len(d.params.OutFiles), counts[jobRunning], counts[jobFailed])
+
+	width := len("JOB")
+	for _, outFile := range d.params.OutFiles {
+		width = max(width, len(d.params.jobName(outFile)))
+	}
+	fmt.Fprintf(&b, "  %-*s  %-9s  %12s  %10s  %8s  %s\n", width, "JOB", "STATUS", "ROWS", "ROWS/S", "ETA", "ERROR")
+	for i, outFile := range d.params.OutFiles {
+		cursor := " "
+		if i == d.selected {
+			cursor = ">"
+		}
+		state := states[outFile]
+		started, p := d.control.jobProgress(outFile)
+		rows, rate, eta := "", "", ""
+		if state != jobQueued && state != jobSkipped {
+			rows = fmt.Sprint(p.Rows)
+			elapsed := p.Seconds
+			if elapsed == 0 && !started.IsZero() {
+				elapsed = time.Since(started).Seconds()
+			}
+			if elapsed > 0 {
+				perSecond := float64(p.Rows) / elapsed
+				rate = fmt.Sprintf("%.0f", perSecond)
+				if left, ok := estimate(p.Rows, elapsed, d.expected[outFile]); state == jobRunning && ok {
+					eta = left.String()
+				}
+			}
+		}
+		jobErr, _, _ := strings.Cut(d.control.jobError(outFile), "\n")
+		fmt.Fprintf(&b, "%s %-*s  %-9s  %12s  %10s  %8s  %s\n", cursor, width, d.params.jobName(outFile), state, rows, rate, eta, jobErr)
+	}
+
+	if d.viewLog && len(d.params.OutFiles) > 0 {
+		outFile := d.params.OutFiles[d.selected]
+		fmt.Fprintf(&b, "\nLog of %s:\n", d.params.jobName(outFile))
+		for _, line := range d.logs.mentioning(dashboardLogLines, outFile) {
+			fmt.Fprintf(&b, "  %s\n", line)
+		}
+	}
+	if d.notice != "" {
+		fmt.Fprintf(&b, "\n%s\n", d.notice)
+	}
+	if !d.done {
+		b.WriteString("\n↑/↓ select  enter log  c cancel job  p pause/resume  q drain\n")
+	}
+	return b.String()
+}
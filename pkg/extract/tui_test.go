@@ -0,0 +1,68 @@
+package extract
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+func TestLogLines(t *testing.T) {
+	l := &logLines{}
+	l.Write([]byte("one a.csv\ntwo b.csv\nthree a."))
+	l.Write([]byte("csv\n"))
+	if got := l.mentioning(5, "a.csv"); len(got) != 2 || got[1] != "three a.csv" {
+		t.Errorf("got %q", got)
+	}
+	var out bytes.Buffer
+	l.flush(&out)
+	if out.String() != "one a.csv\ntwo b.csv\nthree a.csv\n" {
+		t.Errorf("flushed %q", out.String())
+	}
+}
+
+func TestDashboard(t *testing.T) {
+	c := &config{
+		Server: "db1", Database: "sales",
+		Extracts: []extract{{Name: "customers", OutFile: "customers.csv"}, {Name: "orders", OutFile: "orders.csv"}},
+		OutFiles: []string{"customers.csv", "orders.csv"},
+	}
+	control := newController(c.OutFiles)
+	report := newRunReport("")
+	control.track(report, nil)
+	d := &dashboard{params: c, control: control, logs: &logLines{}, expected: map[string]uint{"customers.csv": 4000}}
+
+	if _, ok := control.start(context.Background(), "customers.csv"); !ok {
+		t.Fatal("the job did not start")
+	}
+	report.progress("customers.csv", 1000, 4096)
+	control.start(context.Background(), "orders.csv")
+	control.finish("orders.csv", errors.New("login failed\nfor user"))
+
+	time.Sleep(10 * time.Millisecond)
+	view := d.View()
+	for _, want := range []string{"sales on db1: running, 0/2 done, 1 running, 1 failed", "customers", "1000", "login failed"} {
+		if !strings.Contains(view, want) {
+			t.Errorf("view is missing %q:\n%s", want, view)
+		}
+	}
+	if strings.Contains(view, "for user") {
+		t.Errorf("view shows more than the first line of an error:\n%s", view)
+	}
+
+	d.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("c")})
+	if !strings.Contains(d.notice, "cancel") {
+		t.Errorf("cancel: got %q", d.notice)
+	}
+	d.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("q")})
+	if control.runState() != stateDraining {
+		t.Errorf("q left the run %s", control.runState())
+	}
+	if _, cmd := d.Update(dashboardDone{}); cmd == nil || !d.done {
+		t.Error("the dashboard did not end with the run")
+	}
+}
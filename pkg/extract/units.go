@@ -0,0 +1,92 @@
+package extract
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// configDuration is a duration written with units in the configuration,
+// e.g. 30s, 5m or 1h30m.
+type configDuration time.Duration
+
+func (d *configDuration) UnmarshalYAML(node *yaml.Node) error {
+	v, err := time.ParseDuration(strings.TrimSpace(node.Value))
+	if node.Kind != yaml.ScalarNode || err != nil {
+		return fmt.Errorf("line %d: %q is not a duration, write it with a unit such as 30s, 5m or 2h", node.Line, node.Value)
+	}
+	if v < 0 {
+		return fmt.Errorf("line %d: the duration %s is negative", node.Line, node.Value)
+	}
+	*d = configDuration(v)
+	return nil
+}
+
+func (d configDuration) MarshalYAML() (any, error) { return d.String(), nil }
+func (d configDuration) String() string            { return time.Duration(d).String() }
+
+// byteSize is a number of bytes written with an optional unit in the
+// configuration: decimal KB, MB, GB and TB, or binary KiB, MiB, GiB and TiB,
+// e.g. 512MiB or 1.5GB.
+type byteSize int64
+
+// byteUnits are the units of byteSize, longest first so KiB is not read as B.
+var byteUnits = []struct {
+	suffix string
+	scale  float64
+}{
+	{"kib", 1 << 10}, {"mib", 1 << 20}, {"gib", 1 << 30}, {"tib", 1 << 40},
+	{"kb", 1e3}, {"mb", 1e6}, {"gb", 1e9}, {"tb", 1e12},
+	{"b", 1},
+}
+
+// parseByteSize reads a size such as 4096, 64KiB or 1.5 GB.
+func parseByteSize(s string) (byteSize, error) {
+	s = strings.TrimSpace(s)
+	number, scale := s, 1.0
+	lower := strings.ToLower(s)
+	for _, u := range byteUnits {
+		if strings.HasSuffix(lower, u.suffix) {
+			number, scale = strings.TrimSpace(s[:len(s)-len(u.suffix)]), u.scale
+			break
+		}
+	}
+	v, err := strconv.ParseFloat(number, 64)
+	if err != nil || v < 0 || math.IsInf(v, 0) || math.IsNaN(v) {
+		return 0, fmt.Errorf("%q is not a size, write it as bytes or with a unit such as 64KiB, 512MiB or 1.5GB", s)
+	}
+	bytes := math.Round(v * scale)
+	if bytes > math.MaxInt64 {
+		return 0, fmt.Errorf("the size %s is too large", s)
+	}
+	return byteSize(bytes), nil
+}
+
+func (b *byteSize) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind != yaml.ScalarNode {
+		return fmt.Errorf("line %d: a size must be a single value", node.Line)
+	}
+	v, err := parseByteSize(node.Value)
+	if err != nil {
+		return fmt.Errorf("line %d: %v", node.Line, err)
+	}
+	*b = v
+	return nil
+}
+
+func (b byteSize) MarshalYAML() (any, error) { return b.String(), nil }
+
+// String writes the size in the largest binary unit that divides it.
+func (b byteSize) String() string {
+	for i := 3; i >= 0; i-- {
+		u := byteUnits[i]
+		if b != 0 && int64(b)%int64(u.scale) == 0 {
+			return fmt.Sprintf("%d%s", int64(b)/int64(u.scale), strings.Replace(strings.ToUpper(u.suffix), "IB", "iB", 1))
+		}
+	}
+	return strconv.FormatInt(int64(b), 10)
+}
@@ -0,0 +1,43 @@
+package extract
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestByteSize(t *testing.T) {
+	for in, want := range map[string]byteSize{
+		"4096": 4096, "64KiB": 64 << 10, "512MiB": 512 << 20, "1.5GB": 1500000000, "2 gib": 2 << 30, "10B": 10,
+	} {
+		got, err := parseByteSize(in)
+		if err != nil || got != want {
+			t.Errorf("%q: got %d, %v, want %d", in, got, err, want)
+		}
+	}
+	for _, in := range []string{"", "lots", "-1MB", "1.5XB"} {
+		if _, err := parseByteSize(in); err == nil {
+			t.Errorf("%q was accepted", in)
+		}
+	}
+	if s := byteSize(512 << 20).String(); s != "512MiB" {
+		t.Errorf("got %s, want 512MiB", s)
+	}
+}
+
+func TestUnitsInConfig(t *testing.T) {
+	var c config
+	if err := yaml.Unmarshal([]byte("adaptive:\n  interval: 2m\norc:\n  stripe_size: 64MiB\n"), &c); err != nil {
+		t.Fatal(err)
+	}
+	if time.Duration(c.Adaptive.Interval) != 2*time.Minute || c.ORC.StripeSize != 64<<20 {
+		t.Errorf("got interval %s and stripe size %d", c.Adaptive.Interval, c.ORC.StripeSize)
+	}
+
+	err := yaml.Unmarshal([]byte("server: db1\nadaptive:\n  interval: 30 seconds\n"), &c)
+	if err == nil || !strings.Contains(err.Error(), "line 3") || !strings.Contains(err.Error(), "30 seconds") {
+		t.Errorf("got %v, want an error pointing at line 3", err)
+	}
+}
@@ -0,0 +1,226 @@
+package extract
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// jobUsage is the resource usage of one job in the run report.
+type jobUsage struct {
+	OutFile        string    `json:"outfile"`
+	Started        time.Time `json:"started"`
+	Seconds        float64   `json:"duration_seconds"`
+	Rows           uint      `json:"rows"`
+	BytesWritten   int64     `json:"bytes_written,omitempty"`
+	RowsPerSecond  float64   `json:"rows_per_second"`
+	BytesPerSecond float64   `json:"bytes_per_second,omitempty"`
+	// QueryCPUSeconds is the server's CPU time for the query, when measured.
+	QueryCPUSeconds *float64 `json:"query_cpu_seconds,omitempty"`
+}
+
+// runReport collects process and per-job resource usage for a run, logs a
+// summary and optionally writes it as JSON.
+type runReport struct {
+	mu   sync.Mutex
+	path string
+
+	Started           time.Time  `json:"started"`
+	Seconds           float64    `json:"duration_seconds"`
+	PeakRSSBytes      int64      `json:"peak_rss_bytes,omitempty"`
+	CPUUserSeconds    float64    `json:"cpu_user_seconds,omitempty"`
+	CPUSysSeconds     float64    `json:"cpu_system_seconds,omitempty"`
+	GCCount           int64      `json:"gc_count"`
+	GCPauseSeconds    float64    `json:"gc_pause_total_seconds"`
+	GCMaxPauseSeconds float64    `json:"gc_pause_max_seconds"`
+	Rows              uint       `json:"rows"`
+	BytesWritten      int64      `json:"bytes_written"`
+	RowsPerSecond     float64    `json:"rows_per_second"`
+	BytesPerSecond    float64    `json:"bytes_per_second"`
+	Jobs              []jobUsage `json:"jobs"`
+	schemas           map[string][]column
+	live              map[string]jobProgress
+	gcPausesAtStart   time.Duration
+	gcCountAtStart    int64
+}
+
+// newRunReport starts measuring a run. The report is written to path on
+// finish unless path is empty.
+func newRunReport(path string) *runReport {
+	var gc debug.GCStats
+	debug.ReadGCStats(&gc)
+	return &runReport{path: path, Started: time.Now(), gcPausesAtStart: gc.PauseTotal, gcCountAtStart: gc.NumGC}
+}
+
+// jobProgress is how far a job has got. Seconds is set once it finished.
+type jobProgress struct {
+	Rows    uint
+	Bytes   int64
+	Seconds float64
+}
+
+// progressRows is how many rows a job writes between progress updates.
+const progressRows = 1000
+
+// progress records how far a running job has got.
+func (r *runReport) progress(outFile string, rows uint, bytes int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.live == nil {
+		r.live = map[string]jobProgress{}
+	}
+	r.live[outFile] = jobProgress{Rows: rows, Bytes: bytes}
+}
+
+// progressFor returns the last progress a running job recorded.
+func (r *runReport) progressFor(outFile string) jobProgress {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.live[outFile]
+}
+
+// job records a finished job.
+func (r *runReport) job(outFile string, started time.Time, rows uint, bytes int64) {
+	d := time.Since(started)
+	j := jobUsage{OutFile: outFile, Started: started, Seconds: d.Seconds(), Rows: rows, BytesWritten: bytes}
+	if d > 0 {
+		j.RowsPerSecond = float64(rows) / d.Seconds()
+		j.BytesPerSecond = float64(bytes) / d.Seconds()
+	}
+	r.add(j)
+}
+
+// jobCPU records the server's CPU time for the query of a finished job.
+func (r *runReport) jobCPU(outFile string, d time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := range r.Jobs {
+		if r.Jobs[i].OutFile == outFile {
+			s := d.Seconds()
+			r.Jobs[i].QueryCPUSeconds = &s
+		}
+	}
+}
+
+// add records the usage of a finished job, measured here or by a worker.
+func (r *runReport) add(j jobUsage) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Jobs = append(r.Jobs, j)
+	r.Rows += j.Rows
+	r.BytesWritten += j.BytesWritten
+}
+
+// schema records the output columns of a job.
+func (r *runReport) schema(outFile string, cols []column) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.schemas == nil {
+		r.schemas = map[string][]column{}
+	}
+	r.schemas[outFile] = cols
+}
+
+// schemaFor returns the output columns of a job, if it got as far as
+// preparing them.
+func (r *runReport) schemaFor(outFile string) []column {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.schemas[outFile]
+}
+
+// rowsFor returns the rows written by a finished job.
+func (r *runReport) rowsFor(outFile string) uint {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, j := range r.Jobs {
+		if j.OutFile == outFile {
+			return j.Rows
+		}
+	}
+	return 0
+}
+
+// usageFor returns the usage of a finished job.
+func (r *runReport) usageFor(outFile string) (jobUsage, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, j := range r.Jobs {
+		if j.OutFile == outFile {
+			return j, true
+		}
+	}
+	return jobUsage{}, false
+}
+
+// finish takes the process measurements, logs them and writes the report.
+func (r *runReport) finish() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	d := time.Since(r.Started)
+	r.Seconds = d.Seconds()
+	if d > 0 {
+		r.RowsPerSecond = float64(r.Rows) / d.Seconds()
+		r.BytesPerSecond = float64(r.BytesWritten) / d.Seconds()
+	}
+	if u, ok := processUsage(); ok {
+		r.PeakRSSBytes = u.peakRSS
+		r.CPUUserSeconds = u.user.Seconds()
+		r.CPUSysSeconds = u.system.Seconds()
+	}
+	gc := debug.GCStats{PauseQuantiles: make([]time.Duration, 5)}
+	debug.ReadGCStats(&gc)
+	r.GCCount = gc.NumGC - r.gcCountAtStart
+	r.GCPauseSeconds = (gc.PauseTotal - r.gcPausesAtStart).Seconds()
+	if r.GCCount > 0 {
+		r.GCMaxPauseSeconds = gc.PauseQuantiles[4].Seconds()
+	}
+
+	rss, written, rate := fmt.Sprintf("%d MiB", r.PeakRSSBytes>>20), fmt.Sprintf("%d byte(s)", r.BytesWritten), fmt.Sprintf("%.0f", r.RowsPerSecond)
+	if console.human {
+		rss, written, rate = humanSize(r.PeakRSSBytes), humanSize(r.BytesWritten), console.count(int64(r.RowsPerSecond+0.5))
+	}
+	log.Printf("Resource usage: peak RSS %s, CPU %.1fs user / %.1fs system, %d GC pause(s) totalling %.3fs (max %.3fs), %s written (%s rows/s)\n",
+		rss, r.CPUUserSeconds, r.CPUSysSeconds, r.GCCount, r.GCPauseSeconds, r.GCMaxPauseSeconds, written, rate)
+
+	if r.path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(r.path, data, 0o644); err != nil {
+		return fmt.Errorf("Could not write run report %s: %v\n", r.path, err)
+	}
+	return nil
+}
+
+// usage is process-level resource usage as reported by the operating system.
+type usage struct {
+	peakRSS      int64
+	user, system time.Duration
+}
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	w io.Writer
+	n atomic.Int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n.Add(int64(n))
+	return n, err
+}
+
+// byteCounter is implemented by outputs that know how much they wrote.
+type byteCounter interface {
+	bytesWritten() int64
+}
@@ -0,0 +1,9 @@
+//go:build !unix
+
+package extract
+
+// processUsage is not available on this platform; only Go runtime figures
+// are reported.
+func processUsage() (usage, bool) {
+	return usage{}, false
+}
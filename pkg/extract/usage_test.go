@@ -0,0 +1,99 @@
+package extract
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunReport(t *testing.T) {
+	dir := t.TempDir()
+	outFile := filepath.Join(dir, "out.csv")
+	path := filepath.Join(dir, "report.json")
+	registerFake("usage", &fakeQuery{sets: []*fakeResult{numbersResult(5)}})
+	db, err := sql.Open("fakedb", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	c := &config{}
+	c.dialect, _ = dialectFor("sqlserver")
+	r := newRunReport(path)
+	if err := exportData(context.Background(), db, c, nil, r, nil, "usage", outFile); err != nil {
+		t.Fatal(err)
+	}
+	r.jobCPU(outFile, 1500*time.Millisecond)
+	if err := r.finish(); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "Resource usage: peak RSS ") || !strings.Contains(buf.String(), " written (") {
+		t.Errorf("logged %q", buf.String())
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got struct {
+		Started time.Time `json:"started"`
+		Seconds float64   `json:"duration_seconds"`
+		PeakRSS int64     `json:"peak_rss_bytes"`
+		Rows    uint      `json:"rows"`
+		Bytes   int64     `json:"bytes_written"`
+		GCCount *int64    `json:"gc_count"`
+		Jobs    []struct {
+			OutFile  string   `json:"outfile"`
+			Rows     uint     `json:"rows"`
+			Bytes    int64    `json:"bytes_written"`
+			Rate     float64  `json:"rows_per_second"`
+			QueryCPU *float64 `json:"query_cpu_seconds"`
+		} `json:"jobs"`
+	}
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	info, _ := os.Stat(outFile)
+	if got.Started.IsZero() || got.Seconds <= 0 || got.GCCount == nil || got.Rows != 5 || got.Bytes != info.Size() {
+		t.Errorf("report %s", data)
+	}
+	if runtime.GOOS == "linux" && got.PeakRSS <= 0 {
+		t.Errorf("no peak RSS in %s", data)
+	}
+	if len(got.Jobs) != 1 {
+		t.Fatalf("jobs in %s", data)
+	}
+	j := got.Jobs[0]
+	if j.OutFile != outFile || j.Rows != 5 || j.Bytes != info.Size() || j.Rate <= 0 || j.QueryCPU == nil || *j.QueryCPU != 1.5 {
+		t.Errorf("job %+v", j)
+	}
+}
+
+func TestRunReportWithoutPath(t *testing.T) {
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	r := newRunReport("")
+	r.add(jobUsage{OutFile: "a.csv", Rows: 2, BytesWritten: 10})
+	r.add(jobUsage{OutFile: "b.csv", Rows: 3, BytesWritten: 20})
+	if u, ok := r.usageFor("b.csv"); !ok || u.Rows != 3 || r.rowsFor("a.csv") != 2 {
+		t.Errorf("usage %+v, %v", u, ok)
+	}
+	if r.Rows != 5 || r.BytesWritten != 30 {
+		t.Errorf("totals %d rows, %d bytes", r.Rows, r.BytesWritten)
+	}
+	if err := r.finish(); err != nil {
+		t.Errorf("a report without a path failed: %v", err)
+	}
+}
@@ -0,0 +1,27 @@
+//go:build unix
+
+package extract
+
+import (
+	"runtime"
+	"syscall"
+	"time"
+)
+
+// processUsage reads the usage of this process from getrusage.
+func processUsage() (usage, bool) {
+	var ru syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &ru); err != nil {
+		return usage{}, false
+	}
+	// macOS reports the peak in bytes, everything else in KiB
+	rss := int64(ru.Maxrss)
+	if runtime.GOOS != "darwin" && runtime.GOOS != "ios" {
+		rss *= 1024
+	}
+	return usage{
+		peakRSS: rss,
+		user:    time.Duration(ru.Utime.Nano()),
+		system:  time.Duration(ru.Stime.Nano()),
+	}, true
+}
@@ -0,0 +1,87 @@
+package extract
+
+import (
+	"fmt"
+	"math/big"
+	"strconv"
+)
+
+// valueFormat is how the text formats write temporal and numeric columns
+// when datetime_format, date_format or decimal_places is set, whatever the
+// driver returns their values as. Columns without one are written as the
+// driver returns them.
+type valueFormat struct {
+	datetime string
+	date     string
+	// places is the number of decimals of floats and decimals, or -1.
+	places int
+	kinds  []valueKind
+}
+
+// valueFormat returns the formatting of the configured text output, or nil
+// to keep the driver's.
+func (c *config) valueFormat() *valueFormat {
+	if c.DatetimeFormat == "" && c.DateFormat == "" && c.DecimalPlaces == nil {
+		return nil
+	}
+	f := &valueFormat{datetime: c.DatetimeFormat, date: c.DateFormat, places: -1}
+	if c.DecimalPlaces != nil {
+		f.places = *c.DecimalPlaces
+	}
+	return f
+}
+
+func validateValueFormat(c *config) error {
+	if c.DecimalPlaces != nil && (*c.DecimalPlaces < 0 || *c.DecimalPlaces > 38) {
+		return fmt.Errorf("decimal_places must be between 0 and 38, got %d\n", *c.DecimalPlaces)
+	}
+	return nil
+}
+
+// setColumns records the kinds of the columns of the result.
+func (f *valueFormat) setColumns(cols []column) {
+	if f == nil {
+		return
+	}
+	f.kinds = make([]valueKind, len(cols))
+	for i, col := range cols {
+		f.kinds[i] = col.kind()
+	}
+}
+
+// format renders v, the non-NULL value of column i. Values that do not
+// convert to the column's type are written as returned.
+func (f *valueFormat) format(i int, v any) string {
+	if f == nil {
+		return formatValue(v)
+	}
+	switch f.kinds[i] {
+	case kindTimestamp, kindDate:
+		layout := f.datetime
+		if f.kinds[i] == kindDate {
+			layout = f.date
+		}
+		if layout == "" {
+			break
+		}
+		if t, err := asTime(v); err == nil {
+			return t.Format(layout)
+		}
+	case kindFloat:
+		if f.places < 0 {
+			break
+		}
+		if n, err := asFloat64(v); err == nil {
+			return strconv.FormatFloat(n, 'f', f.places, 64)
+		}
+	case kindDecimal:
+		if f.places < 0 {
+			break
+		}
+		// decimals are rounded exactly, half away from zero
+		if n, ok := new(big.Rat).SetString(formatValue(v)); ok {
+			return n.FloatString(f.places)
+		}
+	}
+	return formatValue(v)
+}
@@ -0,0 +1,51 @@
+package extract
+
+import (
+	"testing"
+	"time"
+)
+
+func TestValueFormat(t *testing.T) {
+	places := 2
+	c := &config{DatetimeFormat: "2006-01-02 15:04:05", DateFormat: "02/01/2006", DecimalPlaces: &places}
+	if err := validateValueFormat(c); err != nil {
+		t.Fatal(err)
+	}
+	var cols []column
+	for _, typ := range []string{"DATETIME2", "DATE", "DECIMAL(19,4)", "FLOAT", "NVARCHAR"} {
+		col, _ := parseColumnType("c", typ)
+		cols = append(cols, col)
+	}
+	f := c.valueFormat()
+	f.setColumns(cols)
+	ts := time.Date(2025, 3, 7, 14, 5, 9, 120000000, time.UTC)
+	for _, tc := range []struct {
+		column int
+		value  any
+		want   string
+	}{
+		{0, ts, "2025-03-07 14:05:09"},
+		{0, []byte("2025-03-07 14:05:09.12"), "2025-03-07 14:05:09"},
+		{1, []byte("2025-03-07"), "07/03/2025"},
+		{1, ts, "07/03/2025"},
+		{2, []byte("12.3450"), "12.35"},
+		{2, "-0.0050", "-0.01"},
+		{3, 1e6, "1000000.00"},
+		{3, []byte("0.126"), "0.13"},
+		{4, "12.3450", "12.3450"},
+		{0, "not a time", "not a time"},
+	} {
+		if got := f.format(tc.column, tc.value); got != tc.want {
+			t.Errorf("%v in column %d: got %q, want %q", tc.value, tc.column, got, tc.want)
+		}
+	}
+
+	var unset *valueFormat
+	if (&config{}).valueFormat() != unset || unset.format(0, ts) != formatValue(ts) {
+		t.Error("formatting without options changes the driver's values")
+	}
+	places = 39
+	if err := validateValueFormat(c); err == nil {
+		t.Error("accepted decimal_places 39")
+	}
+}
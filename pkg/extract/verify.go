@@ -0,0 +1,257 @@
+package extract
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+)
+
+// verifyOptions checks a job's file against the source once it is written.
+type verifyOptions struct {
+	// Keys are the output columns identifying a row.
+	Keys []string `yaml:"keys"`
+	// Rows is how many rows are sampled; 0 samples the default of 100.
+	Rows int `yaml:"rows"`
+}
+
+// verifyMismatchesShown is how many differing rows a failed verification lists.
+const verifyMismatchesShown = 5
+
+func (o verifyOptions) validate(c *config, outFile string) error {
+	if len(o.Keys) == 0 {
+		return fmt.Errorf("Verification of %s needs its key columns\n", outFile)
+	}
+	if o.Rows < 0 {
+		return fmt.Errorf("Verification of %s: rows cannot be negative, got %d\n", outFile, o.Rows)
+	}
+	if f := c.format(outFile); f != "" && f != "csv" {
+		return fmt.Errorf("Verification of %s: only csv files can be verified, not %s\n", outFile, f)
+	}
+	if strings.Contains(outFile, "://") {
+		return fmt.Errorf("Verification of %s: only local files can be verified\n", outFile)
+	}
+	return nil
+}
+
+func (o verifyOptions) rows() int {
+	if o.Rows > 0 {
+		return o.Rows
+	}
+	return 100
+}
+
+// verifyOutput queries a random sample of the source rows of a job that
+// wrote rowCount rows and compares each field with the row of the same key in
+// its file. The sample goes through the job's transforms, except for row
+// keys and change publication, and its writer, so that it reads as the file
+// does.
+func verifyOutput(ctx context.Context, db *sql.DB, c *config, o verifyOptions, query, outFile string, rowCount uint) error {
+	if rowCount == 0 {
+		return nil
+	}
+	n := o.rows()
+	// a large source is sampled down to a few times the rows needed first, so
+	// that the database does not order every row at random
+	if percent := 300 * float64(n) / float64(rowCount); percent < 100 {
+		query = c.dialect.sample(query, percent)
+	}
+	sampled := c.dialect.randomRows(query, n)
+	want, err := sourceSample(ctx, db, c, sampled, outFile)
+	if err != nil {
+		return fmt.Errorf("Verification of %s could not sample the source: %v\n", outFile, err)
+	}
+	wantHeader, wantRows := want[0], want[1:]
+	keyOf, err := verifyKeys(o.Keys, wantHeader)
+	if err != nil {
+		return fmt.Errorf("Verification of %s: %v in the source sample\n", outFile, err)
+	}
+	expected := make(map[string][]string, len(wantRows))
+	for _, rec := range wantRows {
+		expected[keyOf(rec)] = rec
+	}
+	sampledRows := len(expected)
+
+	f, err := openOutputFile(c, outFile)
+	if err != nil {
+		return fmt.Errorf("Verification of %s could not read the file: %v\n", outFile, err)
+	}
+	defer f.Close()
+	r := csv.NewReader(f)
+	r.Comma = []rune(c.delimiter(outFile))[0]
+	r.FieldsPerRecord = -1
+	header, err := r.Read()
+	if err != nil {
+		return fmt.Errorf("Verification of %s could not read the file: %v\n", outFile, err)
+	}
+	fileKeyOf, err := verifyKeys(o.Keys, header)
+	if err != nil {
+		return fmt.Errorf("Verification of %s: %v in the file\n", outFile, err)
+	}
+	fileIndex := make(map[string]int, len(header))
+	for i, name := range header {
+		fileIndex[name] = i
+	}
+
+	var mismatches []string
+	for len(expected) > 0 {
+		rec, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("Verification of %s could not read the file: %v\n", outFile, err)
+		}
+		key := fileKeyOf(rec)
+		src, ok := expected[key]
+		if !ok {
+			continue
+		}
+		delete(expected, key)
+		for i, name := range wantHeader {
+			j, ok := fileIndex[name]
+			if !ok {
+				continue
+			}
+			if j >= len(rec) || rec[j] != src[i] {
+				got := ""
+				if j < len(rec) {
+					got = rec[j]
+				}
+				mismatches = append(mismatches, fmt.Sprintf("%s: %s is %q in the file but %q in the source", key, name, got, src[i]))
+				break
+			}
+		}
+	}
+	for key := range expected {
+		mismatches = append(mismatches, fmt.Sprintf("%s: missing from the file", key))
+	}
+	if len(mismatches) > 0 {
+		shown := mismatches[:min(len(mismatches), verifyMismatchesShown)]
+		return fmt.Errorf("Verification of %s failed, %d of %d sampled row(s) differ: %s\n", outFile, len(mismatches), sampledRows, strings.Join(shown, "; "))
+	}
+	log.Printf("Verified %d sampled row(s) of %s against the source\n", sampledRows, outFile)
+	return nil
+}
+
+// sourceSample runs query and returns its rows as the job's writer would
+// write them, header first.
+func sourceSample(ctx context.Context, db *sql.DB, c *config, query, outFile string) ([][]string, error) {
+	rows, err := db.QueryContext(ctx, query, c.jobArgs(outFile)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	res := sqlResult{rows}
+	cols, err := res.columns()
+	if err != nil {
+		return nil, err
+	}
+	var stages []transform
+	for _, t := range jobTransforms(c, outFile) {
+		switch t.(type) {
+		case *rowKeyTransform, *changeTransform:
+		default:
+			stages = append(stages, t)
+		}
+	}
+	if cols, err = setupTransforms(stages, cols); err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	w, err := newRowWriter(c, &buf, outFile)
+	if err != nil {
+		return nil, err
+	}
+	if err := w.WriteHeader(cols); err != nil {
+		return nil, err
+	}
+	row := make([]any, len(cols))
+	ptrs := make([]any, len(row))
+	for i := range row {
+		ptrs[i] = &row[i]
+	}
+	for rows.Next() {
+		if err := rows.Scan(ptrs...); err != nil {
+			return nil, err
+		}
+		out, keep, err := applyTransforms(stages, row)
+		if err != nil {
+			return nil, err
+		}
+		if keep {
+			if err := w.WriteRow(out); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	r := csv.NewReader(&buf)
+	r.Comma = []rune(c.delimiter(outFile))[0]
+	return r.ReadAll()
+}
+
+// verifyKeys returns the key of a record with the given header.
+func verifyKeys(keys, header []string) (func(rec []string) string, error) {
+	idx := make([]int, len(keys))
+	for i, k := range keys {
+		idx[i] = -1
+		for j, name := range header {
+			if name == k {
+				idx[i] = j
+			}
+		}
+		if idx[i] < 0 {
+			return nil, fmt.Errorf("no key column %s", k)
+		}
+	}
+	return func(rec []string) string {
+		parts := make([]string, len(idx))
+		for i, j := range idx {
+			if j < len(rec) {
+				parts[i] = keys[i] + "=" + rec[j]
+			}
+		}
+		return strings.Join(parts, ",")
+	}, nil
+}
+
+// openOutputFile opens the file the job writing outFile created, reading
+// through its compression.
+func openOutputFile(c *config, outFile string) (io.ReadCloser, error) {
+	f, err := os.Open(c.outputPath(outFile))
+	if err != nil {
+		return nil, err
+	}
+	r, err := newDecompressor(c.compression(outFile), f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if r == nil {
+		return f, nil
+	}
+	return decompressedFile{r, f}, nil
+}
+
+// decompressedFile reads a compressed file, closing both on Close.
+type decompressedFile struct {
+	io.ReadCloser
+	f *os.File
+}
+
+func (d decompressedFile) Close() error {
+	d.ReadCloser.Close()
+	return d.f.Close()
+}
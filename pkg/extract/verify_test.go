@@ -0,0 +1,65 @@
+package extract
+
+import (
+	"database/sql/driver"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestVerifyOutput(t *testing.T) {
+	registerFake("numbers", &fakeQuery{sets: []*fakeResult{numbersResult(3)}})
+	// with 3 rows the whole source is the sample
+	registerFake(sqlServerDialect{}.randomRows("numbers", 100), &fakeQuery{sets: []*fakeResult{numbersResult(3)}})
+	outFile := filepath.Join(t.TempDir(), "out.csv")
+	c := &config{Verify: map[string]verifyOptions{outFile: {Keys: []string{"id"}}}}
+	if _, err := exportFake(t, c, "numbers", outFile); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(outFile); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVerifyOutputMismatch(t *testing.T) {
+	registerFake("changed", &fakeQuery{sets: []*fakeResult{numbersResult(3)}})
+	// the source sample disagrees with what was written on the second row
+	changed := numbersResult(3)
+	value := changed.value
+	changed.value = func(row, col int) driver.Value {
+		if row == 1 && col == 1 {
+			return "renamed"
+		}
+		return value(row, col)
+	}
+	registerFake(sqlServerDialect{}.randomRows("changed", 100), &fakeQuery{sets: []*fakeResult{changed}})
+	outFile := filepath.Join(t.TempDir(), "out.csv")
+	c := &config{Verify: map[string]verifyOptions{outFile: {Keys: []string{"id"}}}}
+	_, err := exportFake(t, c, "changed", outFile)
+	if err == nil || !strings.Contains(err.Error(), `1 of 3 sampled row(s) differ: id=2: name is "name 2" in the file but "renamed" in the source`) {
+		t.Fatalf("got error %v, want the differing row", err)
+	}
+	if _, err := os.Stat(outFile); !os.IsNotExist(err) {
+		t.Errorf("the file was kept after failing verification: %v", err)
+	}
+}
+
+func TestVerifyOptionsValidate(t *testing.T) {
+	c := &config{Extracts: []extract{{Name: "a", OutFile: "a.parquet", Format: "parquet"}}}
+	for _, tc := range []struct {
+		outFile string
+		o       verifyOptions
+		ok      bool
+	}{
+		{"a.csv", verifyOptions{Keys: []string{"id"}}, true},
+		{"a.csv", verifyOptions{}, false},
+		{"a.csv", verifyOptions{Keys: []string{"id"}, Rows: -1}, false},
+		{"a.parquet", verifyOptions{Keys: []string{"id"}}, false},
+		{"snowflake://db.public.orders", verifyOptions{Keys: []string{"id"}}, false},
+	} {
+		if err := tc.o.validate(c, tc.outFile); (err == nil) != tc.ok {
+			t.Errorf("%s %+v: got %v", tc.outFile, tc.o, err)
+		}
+	}
+}
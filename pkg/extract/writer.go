@@ -0,0 +1,97 @@
+package extract
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+)
+
+// rowWriter serializes the header and rows of a query result to an output file.
+// Values are passed as returned by the driver, with nil for NULL.
+type rowWriter interface {
+	WriteHeader(cols []column) error
+	WriteRow(values []any) error
+	Close() error
+}
+
+// newRowWriter returns the rowWriter for the configured output format.
+// outFile is the name of the file w writes to.
+func newRowWriter(c *config, w io.Writer, outFile string) (rowWriter, error) {
+	switch format := c.format(outFile); format {
+	case "", "csv":
+		cw := newCSVWriter(w, []rune(c.delimiter(outFile))[0])
+		cw.null = c.nullString(outFile)
+		cw.values = c.valueFormat()
+		if tag, ok := c.locale(outFile); ok {
+			cw.decimal = decimalSeparator(tag)
+		}
+		return cw, nil
+	case "json":
+		jw := newJSONWriter(w, c.JSON.Nest)
+		jw.values = c.valueFormat()
+		return jw, nil
+	case "jsonl":
+		jw := newJSONLWriter(w, c.JSON.Nest)
+		jw.values = c.valueFormat()
+		return jw, nil
+	case "arrow":
+		return newArrowWriter(w, c.Arrow)
+	case "orc":
+		return newORCWriter(w, c.ORC)
+	case "parquet":
+		return newParquetWriter(w, c.Parquet.Compression)
+	case "xlsx":
+		return newXLSXWriter(w, c.sheetName(outFile), c.XLSX.FreezeHeader)
+	case "bcp":
+		return newBCPWriter(w, outFile), nil
+	default:
+		return nil, fmt.Errorf("Unsupported output format '%s'\n", format)
+	}
+}
+
+// csvWriter writes delimited text with a header row. Floats and decimals use
+// decimal as their separator when it is set, and NULL is written as null.
+type csvWriter struct {
+	w       *csv.Writer
+	record  []string
+	decimal string
+	null    string
+	values  *valueFormat
+	numeric []bool
+}
+
+func newCSVWriter(w io.Writer, delimiter rune) *csvWriter {
+	cw := csv.NewWriter(w)
+	cw.Comma = delimiter
+	return &csvWriter{w: cw}
+}
+
+func (c *csvWriter) WriteHeader(cols []column) error {
+	c.record = make([]string, len(cols))
+	c.numeric = make([]bool, len(cols))
+	c.values.setColumns(cols)
+	for i, col := range cols {
+		k := col.kind()
+		c.numeric[i] = k == kindFloat || k == kindDecimal
+	}
+	return c.w.Write(columnNames(cols))
+}
+
+func (c *csvWriter) WriteRow(values []any) error {
+	for i, v := range values {
+		if v == nil {
+			c.record[i] = c.null
+			continue
+		}
+		c.record[i] = c.values.format(i, v)
+		if c.decimal != "" && c.numeric[i] {
+			c.record[i] = localizeNumber(c.record[i], c.decimal)
+		}
+	}
+	return c.w.Write(c.record)
+}
+
+func (c *csvWriter) Close() error {
+	c.w.Flush()
+	return c.w.Error()
+}
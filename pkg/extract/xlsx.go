@@ -0,0 +1,319 @@
+package extract
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// xlsxOptions holds the settings specific to format: xlsx.
+type xlsxOptions struct {
+	// Sheets names the sheet of each outfile, its job's name unless set.
+	Sheets map[string]string `yaml:"sheets"`
+	// FreezeHeader keeps the header row in view while scrolling.
+	FreezeHeader bool `yaml:"freeze_header"`
+	// Workbooks maps the path of a workbook to the jobs whose sheets it
+	// combines, written once they have all succeeded.
+	Workbooks map[string][]string `yaml:"workbooks"`
+}
+
+// xlsxMaxRows is the number of rows of an Excel sheet, header included.
+const xlsxMaxRows = 1048576
+
+// Number formats of the date and timestamp cells.
+const (
+	xlsxDateFormat     = 14
+	xlsxDateTimeFormat = 22
+)
+
+// sheetName returns the sheet of the job writing outFile: the configured
+// name, or the job's name (the outfile's base name for unnamed jobs) made a
+// valid sheet name.
+func (c *config) sheetName(outFile string) string {
+	if name, ok := c.XLSX.Sheets[outFile]; ok {
+		return name
+	}
+	name := c.jobName(outFile)
+	if name == outFile {
+		name = strings.TrimSuffix(filepath.Base(outFile), filepath.Ext(outFile))
+	}
+	name = strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`[]:*?/\`, r) {
+			return '_'
+		}
+		return r
+	}, name)
+	for utf8.RuneCountInString(name) > excelize.MaxSheetNameLength {
+		_, size := utf8.DecodeLastRuneInString(name)
+		name = name[:len(name)-size]
+	}
+	return name
+}
+
+func (o xlsxOptions) validate(c *config) error {
+	for outFile, name := range o.Sheets {
+		if err := excelize.NewFile().SetSheetName("Sheet1", name); err != nil || name == "" {
+			return fmt.Errorf("The xlsx sheet name '%s' of %s is not a valid sheet name\n", name, outFile)
+		}
+	}
+	for path, jobs := range o.Workbooks {
+		outFiles, err := c.jobsNamed(jobs)
+		if err != nil {
+			return fmt.Errorf("Workbook %s: %v", path, err)
+		}
+		if strings.Contains(path, "://") {
+			return fmt.Errorf("Workbook %s must be a local file\n", path)
+		}
+		sheets := map[string]string{}
+		for _, outFile := range outFiles {
+			if c.format(outFile) != "xlsx" || strings.Contains(outFile, "://") || c.compression(outFile) != "" && c.compression(outFile) != "none" {
+				return fmt.Errorf("Workbook %s takes %s, which is not an uncompressed local xlsx file\n", path, outFile)
+			}
+			sheet := strings.ToLower(c.sheetName(outFile))
+			if other, ok := sheets[sheet]; ok {
+				return fmt.Errorf("Workbook %s has two sheets named %s, of %s and %s\n", path, c.sheetName(outFile), other, outFile)
+			}
+			sheets[sheet] = outFile
+		}
+	}
+	return nil
+}
+
+// xlsxWriter streams the result set to one sheet of an Excel workbook, with
+// numbers, booleans, dates and timestamps in typed cells.
+type xlsxWriter struct {
+	w      io.Writer
+	f      *excelize.File
+	sw     *excelize.StreamWriter
+	freeze bool
+	kinds  []valueKind
+	date   int
+	row    int
+}
+
+func newXLSXWriter(w io.Writer, sheet string, freeze bool) (*xlsxWriter, error) {
+	f := excelize.NewFile()
+	if err := f.SetSheetName("Sheet1", sheet); err != nil {
+		return nil, fmt.Errorf("Invalid xlsx sheet name '%s': %v\n", sheet, err)
+	}
+	sw, err := f.NewStreamWriter(sheet)
+	if err != nil {
+		return nil, err
+	}
+	date, err := f.NewStyle(&excelize.Style{NumFmt: xlsxDateFormat})
+	if err != nil {
+		return nil, err
+	}
+	return &xlsxWriter{w: w, f: f, sw: sw, freeze: freeze, date: date}, nil
+}
+
+func (x *xlsxWriter) WriteHeader(cols []column) error {
+	if x.freeze {
+		if err := x.sw.SetPanes(&excelize.Panes{Freeze: true, YSplit: 1, TopLeftCell: "A2", ActivePane: "bottomLeft"}); err != nil {
+			return err
+		}
+	}
+	x.kinds = make([]valueKind, len(cols))
+	header := make([]any, len(cols))
+	for i, col := range cols {
+		x.kinds[i] = col.kind()
+		header[i] = col.Name
+	}
+	return x.setRow(header)
+}
+
+func (x *xlsxWriter) WriteRow(values []any) error {
+	cells := make([]any, len(values))
+	for i, v := range values {
+		cells[i] = x.cell(i, v)
+	}
+	return x.setRow(cells)
+}
+
+func (x *xlsxWriter) setRow(cells []any) error {
+	if x.row == xlsxMaxRows {
+		return fmt.Errorf("An Excel sheet holds at most %d rows\n", xlsxMaxRows-1)
+	}
+	x.row++
+	cell, _ := excelize.CoordinatesToCellName(1, x.row)
+	return x.sw.SetRow(cell, cells)
+}
+
+// cell converts the value of column i to the cell of its type. Values that
+// do not convert are written as text.
+func (x *xlsxWriter) cell(i int, v any) any {
+	if v == nil {
+		return nil
+	}
+	switch x.kinds[i] {
+	case kindInt:
+		if n, err := asInt64(v); err == nil {
+			return n
+		}
+	case kindFloat, kindDecimal:
+		if f, err := strconv.ParseFloat(formatValue(v), 64); err == nil {
+			return f
+		}
+	case kindBool:
+		if b, err := asBool(v); err == nil {
+			return b
+		}
+	case kindDate:
+		if t, err := asTime(v); err == nil {
+			return excelize.Cell{StyleID: x.date, Value: t}
+		}
+	case kindTimestamp:
+		if t, err := asTime(v); err == nil {
+			return t
+		}
+	}
+	return formatValue(v)
+}
+
+func (x *xlsxWriter) Close() error {
+	defer x.f.Close()
+	if err := x.sw.Flush(); err != nil {
+		return err
+	}
+	return x.f.Write(x.w)
+}
+
+// writeWorkbooks combines the sheets of the jobs of each workbook into it,
+// if they all succeeded in this run. Workbooks are left as they were
+// otherwise, and failing to write one is logged and does not fail the run.
+func writeWorkbooks(c *config, control *controller, r *runReport) {
+	if c.dryRun {
+		return
+	}
+	paths := make([]string, 0, len(c.XLSX.Workbooks))
+	for path := range c.XLSX.Workbooks {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	states := control.states()
+	for _, path := range paths {
+		outFiles, _ := c.jobsNamed(c.XLSX.Workbooks[path])
+		ready := true
+		for _, outFile := range outFiles {
+			if states[outFile] != jobDone {
+				log.Printf("Not writing workbook %s, %s is %s\n", path, outFile, stateOrMissing(states[outFile]))
+				ready = false
+				break
+			}
+		}
+		if !ready {
+			continue
+		}
+		if err := c.combineSheets(path, outFiles, r); err != nil {
+			log.Printf("Warning: could not write workbook %s: %v\n", path, err)
+			continue
+		}
+		log.Printf("Wrote workbook %s with %d sheet(s)\n", path, len(outFiles))
+	}
+}
+
+func stateOrMissing(state string) string {
+	if state == "" {
+		return "not part of this run"
+	}
+	return state
+}
+
+// combineSheets copies the sheet of each of outFiles into a new workbook at
+// path, typing the cells by the columns the jobs wrote.
+func (c *config) combineSheets(path string, outFiles []string, r *runReport) error {
+	wb := excelize.NewFile()
+	defer wb.Close()
+	date, err := wb.NewStyle(&excelize.Style{NumFmt: xlsxDateFormat})
+	if err != nil {
+		return err
+	}
+	datetime, err := wb.NewStyle(&excelize.Style{NumFmt: xlsxDateTimeFormat})
+	if err != nil {
+		return err
+	}
+	for i, outFile := range outFiles {
+		sheet := c.sheetName(outFile)
+		if i == 0 {
+			err = wb.SetSheetName("Sheet1", sheet)
+		} else {
+			_, err = wb.NewSheet(sheet)
+		}
+		if err != nil {
+			return err
+		}
+		if err := copySheet(wb, sheet, c.outputPath(outFile), r.schemaFor(outFile), c.XLSX.FreezeHeader, date, datetime); err != nil {
+			return fmt.Errorf("%s: %v", outFile, err)
+		}
+	}
+	return wb.SaveAs(path)
+}
+
+// copySheet streams the rows of the sheet in the workbook at src into the
+// sheet of wb, typed by cols.
+func copySheet(wb *excelize.File, sheet, src string, cols []column, freeze bool, date, datetime int) error {
+	in, err := excelize.OpenFile(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	rows, err := in.Rows(sheet)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	sw, err := wb.NewStreamWriter(sheet)
+	if err != nil {
+		return err
+	}
+	if freeze {
+		if err := sw.SetPanes(&excelize.Panes{Freeze: true, YSplit: 1, TopLeftCell: "A2", ActivePane: "bottomLeft"}); err != nil {
+			return err
+		}
+	}
+	for n := 1; rows.Next(); n++ {
+		raw, err := rows.Columns(excelize.Options{RawCellValue: true})
+		if err != nil {
+			return err
+		}
+		cells := make([]any, len(raw))
+		for i, s := range raw {
+			cells[i] = s
+			if n == 1 || s == "" || i >= len(cols) {
+				continue
+			}
+			switch k := cols[i].kind(); k {
+			case kindInt, kindFloat, kindDecimal, kindDate, kindTimestamp:
+				f, err := strconv.ParseFloat(s, 64)
+				if err != nil {
+					break
+				}
+				switch k {
+				case kindDate:
+					cells[i] = excelize.Cell{StyleID: date, Value: f}
+				case kindTimestamp:
+					cells[i] = excelize.Cell{StyleID: datetime, Value: f}
+				default:
+					cells[i] = f
+				}
+			case kindBool:
+				cells[i] = s == "1"
+			}
+		}
+		cell, _ := excelize.CoordinatesToCellName(1, n)
+		if err := sw.SetRow(cell, cells); err != nil {
+			return err
+		}
+	}
+	if err := rows.Error(); err != nil {
+		return err
+	}
+	return sw.Flush()
+}
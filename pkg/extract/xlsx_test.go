@@ -0,0 +1,132 @@
+package extract
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/xuri/excelize/v2"
+)
+
+func registerXLSXFake(name string) {
+	registerFake(name, &fakeQuery{sets: []*fakeResult{{
+		columns: []fakeColumn{
+			{name: "id", dbType: "BIGINT", scanType: reflect.TypeOf(int64(0))},
+			{name: "price", dbType: "DECIMAL", scanType: reflect.TypeOf([]byte{}), nullable: true, precision: 10, scale: 2},
+			{name: "active", dbType: "BIT", scanType: reflect.TypeOf(false)},
+			{name: "due", dbType: "DATE", scanType: reflect.TypeOf(time.Time{}), nullable: true},
+		},
+		rows: 2,
+		value: func(row, col int) driver.Value {
+			return [][]driver.Value{
+				{int64(1), []byte("12.50"), true, time.Date(2025, 3, 31, 0, 0, 0, 0, time.UTC)},
+				{int64(2), nil, false, nil},
+			}[row][col]
+		},
+	}}})
+}
+
+// xlsxCells reads the raw cells of a sheet, with the type of each cell of its
+// first row of data. Numbers have no type of their own.
+func xlsxCells(t *testing.T, path, sheet string) ([][]string, []excelize.CellType) {
+	t.Helper()
+	f, err := excelize.OpenFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	rows, err := f.GetRows(sheet, excelize.Options{RawCellValue: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var types []excelize.CellType
+	for _, cell := range []string{"A2", "B2", "C2", "D2"} {
+		typ, _ := f.GetCellType(sheet, cell)
+		types = append(types, typ)
+	}
+	return rows, types
+}
+
+func TestExportDataXLSX(t *testing.T) {
+	registerXLSXFake("xlsx")
+	outFile := filepath.Join(t.TempDir(), "orders.xlsx")
+	c := &config{Format: "xlsx", XLSX: xlsxOptions{FreezeHeader: true}}
+	if _, err := exportFake(t, c, "xlsx", outFile); err != nil {
+		t.Fatal(err)
+	}
+	rows, types := xlsxCells(t, outFile, "orders")
+	want := [][]string{{"id", "price", "active", "due"}, {"1", "12.5", "1", "45747"}, {"2", "", "0"}}
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("got rows %q, want %q", rows, want)
+	}
+	if want := []excelize.CellType{excelize.CellTypeUnset, excelize.CellTypeUnset, excelize.CellTypeBool, excelize.CellTypeUnset}; !reflect.DeepEqual(types, want) {
+		t.Errorf("got cell types %v, want %v", types, want)
+	}
+}
+
+func TestWriteWorkbooks(t *testing.T) {
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	registerXLSXFake("workbook")
+	dir := t.TempDir()
+	c := &config{
+		Format:    "xlsx",
+		Delimiter: ",",
+		Extracts: []extract{
+			{Name: "orders", Query: "workbook", OutFile: filepath.Join(dir, "orders.xlsx")},
+			{Name: "invoices", Query: "workbook", OutFile: filepath.Join(dir, "invoices.xlsx")},
+		},
+		OutFiles: []string{filepath.Join(dir, "orders.xlsx"), filepath.Join(dir, "invoices.xlsx")},
+		XLSX: xlsxOptions{
+			Sheets:    map[string]string{filepath.Join(dir, "invoices.xlsx"): "Open invoices"},
+			Workbooks: map[string][]string{filepath.Join(dir, "month-end.xlsx"): {"orders", "invoices"}},
+		},
+	}
+	if err := c.XLSX.validate(c); err != nil {
+		t.Fatal(err)
+	}
+	c.dialect, _ = dialectFor("sqlserver")
+	db, err := sql.Open("fakedb", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	report := newRunReport("")
+	control := newController(c.OutFiles)
+	for _, outFile := range c.OutFiles {
+		ctx, _ := control.start(context.Background(), outFile)
+		if err := exportData(ctx, db, c, nil, report, nil, "workbook", outFile); err != nil {
+			t.Fatal(err)
+		}
+		control.finish(outFile, nil)
+	}
+	writeWorkbooks(c, control, report)
+
+	path := filepath.Join(dir, "month-end.xlsx")
+	for _, sheet := range []string{"orders", "Open invoices"} {
+		rows, types := xlsxCells(t, path, sheet)
+		if len(rows) != 3 || rows[1][3] != "45747" {
+			t.Errorf("sheet %s: got rows %q", sheet, rows)
+		}
+		if types[2] != excelize.CellTypeBool || types[3] != excelize.CellTypeUnset {
+			t.Errorf("sheet %s: got cell types %v", sheet, types)
+		}
+	}
+
+	for _, bad := range []xlsxOptions{
+		{Sheets: map[string]string{"orders.xlsx": "a:b"}},
+		{Workbooks: map[string][]string{"all.xlsx": {"payments"}}},
+		{Workbooks: map[string][]string{"all.xlsx": {"orders", "orders"}}},
+	} {
+		if err := bad.validate(c); err == nil {
+			t.Errorf("accepted %+v", bad)
+		}
+	}
+}